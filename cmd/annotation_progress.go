@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/logging"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// annotationProgress is the project.ProgressReporter of `vyb init` and `vyb
+// update`. It prints a "[12/37] annotating workspace/selector…" line on w
+// whenever the annotation of a module starts, and collects the metrics of
+// the LLM calls. Nothing is printed in quiet mode.
+type annotationProgress struct {
+	project.MetricsRecorder
+	w io.Writer
+
+	mu      sync.Mutex
+	elapsed map[string]time.Duration
+}
+
+func newAnnotationProgress(w io.Writer) *annotationProgress {
+	if ui.Quiet() {
+		w = io.Discard
+	}
+	return &annotationProgress{w: w, elapsed: make(map[string]time.Duration)}
+}
+
+func (p *annotationProgress) AnnotationProgress(ev project.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch ev.Stage {
+	case project.ProgressStarted:
+		fmt.Fprintf(p.w, "[%d/%d] annotating %s…\n", ev.Index, ev.Total, ev.Module)
+	case project.ProgressFinished:
+		p.elapsed[ev.Module] = ev.Elapsed
+	}
+}
+
+// logTimings logs, at debug level, the time spent annotating every module,
+// slowest first.
+func (p *annotationProgress) logTimings() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	modules := make([]string, 0, len(p.elapsed))
+	for name := range p.elapsed {
+		modules = append(modules, name)
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		if p.elapsed[modules[i]] != p.elapsed[modules[j]] {
+			return p.elapsed[modules[i]] > p.elapsed[modules[j]]
+		}
+		return modules[i] < modules[j]
+	})
+	for _, name := range modules {
+		logging.Log.Debugf("annotated module %q in %s\n", name, p.elapsed[name].Round(time.Millisecond))
+	}
+}
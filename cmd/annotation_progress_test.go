@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func TestAnnotationProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := newAnnotationProgress(&out)
+	p.AnnotationProgress(project.ProgressEvent{Stage: project.ProgressStarted, Module: "workspace/selector", Index: 12, Total: 37})
+	p.AnnotationProgress(project.ProgressEvent{Stage: project.ProgressFinished, Module: "workspace/selector", Index: 12, Total: 37, Elapsed: time.Second})
+	p.AnnotationCall("workspace/selector", time.Second, payload.UsageStats{}, nil)
+
+	if got, want := out.String(), "[12/37] annotating workspace/selector…\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+	if p.elapsed["workspace/selector"] != time.Second {
+		t.Fatalf("expected the elapsed time to be recorded, got %v", p.elapsed)
+	}
+	if m := p.Metrics(); m.Calls != 1 {
+		t.Fatalf("expected the call to be recorded, got %+v", m)
+	}
+}
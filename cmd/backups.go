@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/workspace/backup"
+)
+
+var backupsKeep int
+
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "Manages the backups taken before proposals are applied.",
+	Long: `Every applied proposal leaves a backup set under .vyb/backups/, which
+vyb undo restores. These commands list the sets and remove the old ones.`,
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the backup sets, newest first.",
+	Args:  cobra.NoArgs,
+	Run:   BackupsList,
+}
+
+var backupsPruneCmd = &cobra.Command{
+	Use:   "prune --keep N",
+	Short: "Removes the backup sets older than the newest N.",
+	Args:  cobra.NoArgs,
+	Run:   BackupsPrune,
+}
+
+func init() {
+	backupsPruneCmd.Flags().IntVar(&backupsKeep, "keep", 0, "number of backup sets to keep, newest first")
+	_ = backupsPruneCmd.MarkFlagRequired("keep")
+	backupsCmd.AddCommand(backupsListCmd)
+	backupsCmd.AddCommand(backupsPruneCmd)
+}
+
+// BackupsList is the cobra handler for `vyb backups list`.
+func BackupsList(cmd *cobra.Command, _ []string) {
+	projectRoot, err := workingProjectRoot()
+	if err != nil {
+		exitWithError("Error locating project root", err)
+	}
+	if err := runBackupsList(cmd.OutOrStdout(), projectRoot); err != nil {
+		exitWithError("Error listing backups", err)
+	}
+}
+
+// BackupsPrune is the cobra handler for `vyb backups prune`.
+func BackupsPrune(cmd *cobra.Command, _ []string) {
+	projectRoot, err := workingProjectRoot()
+	if err != nil {
+		exitWithError("Error locating project root", err)
+	}
+	if err := runBackupsPrune(cmd.OutOrStdout(), projectRoot, backupsKeep); err != nil {
+		exitWithError("Error pruning backups", err)
+	}
+}
+
+// runBackupsList lists the backup sets of the project, newest first, with
+// the command that produced them and their number of files.
+func runBackupsList(w io.Writer, projectRoot string) error {
+	sets, err := backup.List(projectRoot)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		fmt.Fprintln(w, "No backup.")
+		return nil
+	}
+	for _, m := range sets {
+		command := m.Command
+		if command == "" {
+			command = "unknown command"
+		}
+		fmt.Fprintf(w, "%s  %-20s %d file(s)\n", m.Timestamp.Format("2006-01-02 15:04:05"), command, len(m.Files))
+	}
+	return nil
+}
+
+// runBackupsPrune removes the backup sets older than the newest keep ones.
+func runBackupsPrune(w io.Writer, projectRoot string, keep int) error {
+	removed, err := backup.Prune(projectRoot, keep)
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		fmt.Fprintf(w, "Nothing to prune, at most %d backup set(s) are stored.\n", keep)
+		return nil
+	}
+	for _, m := range removed {
+		fmt.Fprintf(w, "removed the backup of %s\n", m.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/workspace/backup"
+)
+
+func TestRunBackups(t *testing.T) {
+	root := t.TempDir()
+	var out bytes.Buffer
+	if err := runBackupsList(&out, root); err != nil || out.String() != "No backup.\n" {
+		t.Fatalf("runBackupsList = %q, %v", out.String(), err)
+	}
+
+	writeFiles(t, root, map[string]string{"a.go": "package a\n", "b.go": "package b\n"})
+	for _, origin := range []backup.Origin{{Command: "code"}, {Command: "document"}, {}} {
+		if _, err := backup.Create(root, origin, []backup.Change{{Path: "a.go"}, {Path: "b.go"}}); err != nil {
+			t.Fatalf("backup.Create: %v", err)
+		}
+	}
+
+	out.Reset()
+	if err := runBackupsList(&out, root); err != nil {
+		t.Fatalf("runBackupsList: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a line per backup set, got %q", out.String())
+	}
+	for i, want := range []string{"unknown command", "document", "code"} {
+		if !strings.Contains(lines[i], want) || !strings.HasSuffix(lines[i], "2 file(s)") {
+			t.Fatalf("line %d: expected %q and its file count, newest first, got %q", i, want, lines[i])
+		}
+	}
+
+	out.Reset()
+	if err := runBackupsPrune(&out, root, 1); err != nil {
+		t.Fatalf("runBackupsPrune: %v", err)
+	}
+	if strings.Count(out.String(), "removed") != 2 {
+		t.Fatalf("expected 2 sets to be removed, got %q", out.String())
+	}
+	sets, err := backup.List(root)
+	if err != nil || len(sets) != 1 || sets[0].Command != "" {
+		t.Fatalf("expected the newest set to be kept, got %+v, %v", sets, err)
+	}
+
+	out.Reset()
+	if err := runBackupsPrune(&out, root, 1); err != nil || !strings.HasPrefix(out.String(), "Nothing to prune") {
+		t.Fatalf("runBackupsPrune = %q, %v", out.String(), err)
+	}
+	if err := runBackupsPrune(&out, root, -1); err == nil {
+		t.Fatal("expected an error for a negative count")
+	}
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Inspects the context vyb stores for the modules.",
+}
+
+var contextShowCmd = &cobra.Command{
+	Use:   "show [module]",
+	Short: "Prints the stored annotation of a module.",
+	Long: `Prints, as markdown, the external, internal and public contexts stored in
+.vyb/metadata.yaml for the given module, named by its path relative to the
+project root ("." for the root module). Without argument, the module of the
+current directory is shown. Nothing is sent to the LLM.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  ContextShow,
+}
+
+func init() {
+	contextCmd.AddCommand(contextShowCmd)
+}
+
+// ContextShow is the cobra handler for `vyb context show`.
+func ContextShow(cmd *cobra.Command, args []string) {
+	module := ""
+	if len(args) == 1 {
+		module = args[0]
+	}
+	if err := runContextShow(cmd.OutOrStdout(), ".", module); err != nil {
+		exitWithError("Error showing module context", err)
+	}
+}
+
+// runContextShow writes the annotation of module, or of the module of dir
+// when module is empty, of the project containing dir.
+func runContextShow(w io.Writer, dir, module string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to determine absolute working dir: %w", err)
+	}
+	distToRoot, err := project.FindDistanceToRoot(absDir)
+	if err != nil {
+		return err
+	}
+	absRoot := filepath.Join(absDir, distToRoot)
+	meta, err := project.LoadMetadata(absRoot)
+	if err != nil {
+		return err
+	}
+
+	var m *project.Module
+	if module == "" {
+		rel, err := filepath.Rel(absRoot, absDir)
+		if err != nil {
+			return err
+		}
+		m = project.FindModule(meta.Modules, rel)
+	} else {
+		name := path.Clean(filepath.ToSlash(module))
+		m = project.FindModule(meta.Modules, name)
+		if m == nil || m.Name != name {
+			return fmt.Errorf("module %q not found, module names are paths relative to the project root", module)
+		}
+	}
+	if m == nil {
+		return fmt.Errorf("no module found in .vyb/metadata.yaml")
+	}
+	if m.Annotation == nil {
+		return fmt.Errorf("module %q is not annotated yet, run `vyb update`", m.Name)
+	}
+	_, err = io.WriteString(w, renderAnnotation(m.Name, m.Annotation))
+	return err
+}
+
+// renderAnnotation formats the annotation a of module as markdown, with a
+// section per context.
+func renderAnnotation(module string, a *project.Annotation) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Module %s\n", module)
+	if !a.GeneratedAt.IsZero() {
+		fmt.Fprintf(&sb, "\nGenerated at %s", a.GeneratedAt.Format("2006-01-02 15:04:05"))
+		if a.Provider != "" {
+			fmt.Fprintf(&sb, " by %s", a.Provider)
+			if a.ModelSize != "" {
+				fmt.Fprintf(&sb, " (%s model)", a.ModelSize)
+			}
+		}
+		sb.WriteString(".\n")
+	}
+	for _, s := range []struct{ title, content string }{
+		{"External context", a.ExternalContext},
+		{"Internal context", a.InternalContext},
+		{"Public context", a.PublicContext},
+	} {
+		content := strings.TrimSpace(s.content)
+		if content == "" {
+			content = "_(empty)_"
+		}
+		fmt.Fprintf(&sb, "\n## %s\n\n%s\n", s.title, content)
+	}
+	return sb.String()
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/workspace/project"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRunContextShow(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":    "package main\n",
+		"pkg/lib.go": "package pkg\n",
+	})
+	writeMetadata(t, root)
+	meta, err := project.LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	pkg := project.FindModule(meta.Modules, "pkg")
+	if pkg == nil || pkg.Name != "pkg" {
+		t.Fatalf("expected a pkg module, got %+v", pkg)
+	}
+	pkg.Annotation = &project.Annotation{
+		ExternalContext: "Used by main.",
+		InternalContext: "Holds the library.",
+		GeneratedAt:     time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+		Provider:        "openai",
+		ModelSize:       "small",
+	}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	writeFiles(t, root, map[string]string{".vyb/metadata.yaml": string(data)})
+
+	want := "# Module pkg\n\nGenerated at 2025-03-01 10:00:00 by openai (small model).\n\n" +
+		"## External context\n\nUsed by main.\n\n## Internal context\n\nHolds the library.\n\n## Public context\n\n_(empty)_\n"
+	for _, c := range []struct{ dir, module string }{
+		{filepath.Join(root, "pkg"), ""},
+		{root, "pkg"},
+		{root, "./pkg/"},
+	} {
+		var out bytes.Buffer
+		if err := runContextShow(&out, c.dir, c.module); err != nil {
+			t.Fatalf("runContextShow(%s, %q): %v", c.dir, c.module, err)
+		}
+		if out.String() != want {
+			t.Fatalf("runContextShow(%s, %q) =\n%s\nwant:\n%s", c.dir, c.module, out.String(), want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runContextShow(&out, root, ""); err != nil || !strings.HasPrefix(out.String(), "# Module .\n\n## External context") {
+		t.Fatalf("expected the root module, got %v:\n%s", err, out.String())
+	}
+	for module, wantErr := range map[string]string{
+		"nope":       `module "nope" not found`,
+		"pkg/lib.go": `module "pkg/lib.go" not found`,
+	} {
+		if err := runContextShow(&bytes.Buffer{}, root, module); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("runContextShow(%q): expected %q, got %v", module, wantErr, err)
+		}
+	}
+}
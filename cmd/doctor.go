@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the stored annotations against the project's annotation policy",
+	Long: `This command checks that every stored annotation was generated by the
+provider and model size required by annotation.require_provider and
+annotation.require_model_size in .vyb/config.yaml. Annotations generated by
+another provider, or before vyb recorded their provenance, are listed and the
+command exits with code 6. Run 'vyb update --regenerate-from-provider <name>'
+or 'vyb update --regenerate-all' to bring them in line. It can be executed
+from any directory within the project.`,
+	Args: cobra.NoArgs,
+	Run:  Doctor,
+}
+
+func Doctor(cmd *cobra.Command, _ []string) {
+	code, err := runDoctor(cmd.OutOrStdout(), ".")
+	if err != nil {
+		exitWithError("Error checking project", err)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// runDoctor reports the annotations of the project containing dir that do
+// not comply with its annotation policy, returning the exit code to use.
+func runDoctor(w io.Writer, dir string) (int, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine absolute working dir: %w", err)
+	}
+	distToRoot, err := project.FindDistanceToRoot(absDir)
+	if err != nil {
+		return 0, err
+	}
+	absRoot := filepath.Join(absDir, distToRoot)
+
+	cfg, err := config.Load(absRoot)
+	if err != nil {
+		return 0, err
+	}
+	meta, err := project.LoadMetadata(absRoot)
+	if err != nil {
+		return 0, err
+	}
+
+	out := ui.NewAuto(w)
+	if err := project.TokenizerError(); err != nil {
+		out.Warn("%s: %v. Token budgets and module collapsing use these estimates.", project.HeuristicTokensNote, err)
+	}
+	policy := cfg.Annotation
+	if policy.RequireProvider == "" && policy.RequireModelSize == "" {
+		out.Success("No annotation policy configured, nothing to check.")
+		return 0, nil
+	}
+	violations := project.CheckPolicy(meta, policy)
+	if len(violations) == 0 {
+		out.Success("Every annotation complies with the annotation policy.")
+		return 0, nil
+	}
+
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	rows := make([][]string, 0, len(violations))
+	for _, v := range violations {
+		rows = append(rows, []string{v.Module, orUnknown(v.Provider), orUnknown(string(v.ModelSize))})
+	}
+	out.Table([]string{"MODULE", "PROVIDER", "MODEL SIZE"}, rows)
+	out.Error("%d annotation(s) do not comply with the annotation policy. Run 'vyb update --regenerate-from-provider <provider>' or 'vyb update --regenerate-all' to regenerate them.", len(violations))
+	return exitOutdatedMetadata, nil
+}
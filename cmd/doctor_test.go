@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func TestRunDoctor(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":    "package main\n",
+		"pkg/lib.go": "package pkg\n",
+	})
+	meta, err := project.BuildMetadataFS(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("BuildMetadataFS: %v", err)
+	}
+	meta.Modules.Annotation = &project.Annotation{Provider: "gemini", ModelSize: "small"}
+	project.FindModule(meta.Modules, "pkg").Annotation = &project.Annotation{Provider: "openai", ModelSize: "small"}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	writeFiles(t, root, map[string]string{".vyb/metadata.yaml": string(data)})
+
+	var out bytes.Buffer
+	if code, err := runDoctor(&out, root); err != nil || code != 0 {
+		t.Fatalf("expected no policy to exit 0, got %d, %v:\n%s", code, err, out.String())
+	}
+
+	writeFiles(t, root, map[string]string{".vyb/config.yaml": "annotation:\n  require_provider: gemini\n"})
+	out.Reset()
+	code, err := runDoctor(&out, filepath.Join(root, "pkg"))
+	if err != nil || code != exitOutdatedMetadata {
+		t.Fatalf("expected exit code %d, got %d, %v:\n%s", exitOutdatedMetadata, code, err, out.String())
+	}
+	if !strings.Contains(out.String(), "pkg") || !strings.Contains(out.String(), "openai") || strings.Contains(out.String(), "gemini ") {
+		t.Fatalf("expected only module pkg to be reported, got:\n%s", out.String())
+	}
+}
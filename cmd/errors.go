@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/vybdev/vyb/llm"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// Exit codes used when a command fails. Scripts can rely on them to tell
+// the failure causes apart.
+const (
+	exitGeneric         = 1
+	exitNoMetadata      = 2
+	exitCorruptMetadata = 3
+	exitAnnotation      = 4
+	exitPersist         = 5
+	// exitOutdatedMetadata is used by `vyb verify` and `vyb status` when
+	// the metadata does not match the project files.
+	exitOutdatedMetadata = 6
+	// exitInterrupted follows the shell convention for SIGINT.
+	exitInterrupted = 130
+)
+
+// classifyError maps err to an exit code and, when the cause is known, a
+// hint on how to recover from it.
+func classifyError(err error) (int, string) {
+	var annErr *project.AnnotationError
+	var persistErr *project.PersistError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted, "Interrupted. An interrupted 'vyb update' resumes from the annotations it already generated."
+	case errors.Is(err, project.ErrNoMetadata):
+		return exitNoMetadata, "Run 'vyb init' at the project root to create the project metadata."
+	case errors.Is(err, project.ErrCorruptMetadata):
+		return exitCorruptMetadata, "If the project was created by an older vyb version run 'vyb migrate', otherwise run 'vyb remove' and 'vyb init' to start over."
+	case errors.As(err, &annErr):
+		return exitAnnotation, fmt.Sprintf("Annotating module %q failed. Check the provider configuration and run 'vyb update' to retry.", annErr.Module)
+	case errors.As(err, &persistErr):
+		return exitPersist, fmt.Sprintf("Could not write %s. Check the file permissions and available disk space.", persistErr.Path)
+	case errors.Is(err, llm.ErrRequestTooLarge):
+		return exitGeneric, "Lower request.max_file_tokens in .vyb/config.yaml, or run the command from a narrower directory, to send fewer files."
+	}
+	return exitGeneric, ""
+}
+
+// exitWithError prints err prefixed by context, followed by a recovery hint
+// when available, and exits with the matching exit code.
+func exitWithError(context string, err error) {
+	code, hint := classifyError(err)
+	fmt.Printf("%s: %v\n", context, err)
+	if hint != "" {
+		fmt.Println(hint)
+	}
+	os.Exit(code)
+}
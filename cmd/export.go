@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var exportFormat string
+var exportOutput string
+var exportMaxTokens int
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export module annotations for external tools",
+	Long: `This command exports the module annotations stored in .vyb/metadata.yaml.
+
+With --format chunks (the only format available) it writes one JSON object
+per line, each holding a piece of a module context of at most --max-tokens
+tokens, ready to be fed to an embedding pipeline. Chunk ids only change when
+the chunk text does, so indexes can be refreshed incrementally after
+'vyb update'. It must be executed on the project root.`,
+	Args: cobra.NoArgs,
+	Run:  Export,
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "chunks", "export format (chunks)")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "-", "output file, - for stdout")
+	exportCmd.Flags().IntVar(&exportMaxTokens, "max-tokens", project.DefaultChunkTokens, "maximum tokens per chunk")
+}
+
+func Export(cmd *cobra.Command, _ []string) {
+	if exportFormat != "chunks" {
+		exitWithError("Error exporting annotations", fmt.Errorf("unsupported export format %q, expected chunks", exportFormat))
+	}
+	chunks, err := project.ExportChunks(".", exportMaxTokens)
+	if err != nil {
+		exitWithError("Error exporting annotations", err)
+	}
+
+	var w io.Writer = cmd.OutOrStdout()
+	if exportOutput != "-" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			exitWithError("Error exporting annotations", &project.PersistError{Path: exportOutput, Cause: err})
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	for _, c := range chunks {
+		if err := enc.Encode(c); err != nil {
+			exitWithError("Error exporting annotations", &project.PersistError{Path: exportOutput, Cause: err})
+		}
+	}
+}
@@ -2,54 +2,76 @@ package cmd
 
 import (
 	"fmt"
-	"os"
+	"strings"
 
-	"github.com/AlecAivazis/survey/v2"
 	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
 	"github.com/vybdev/vyb/config"
 	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/workspace/project"
 )
 
+var initProvider string
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initializes a vyb project. Must be executed from the project's root directory.",
 	Run:   Init,
 }
 
+func init() {
+	initCmd.Flags().StringVar(&initProvider, "provider", "", fmt.Sprintf("LLM provider to configure (%s); required when stdin is not a terminal", strings.Join(llm.SupportedProviders(), ", ")))
+}
+
 // Init is the cobra handler for `vyb init`.
-func Init(_ *cobra.Command, _ []string) {
+func Init(cmd *cobra.Command, _ []string) {
 	// ---------------------------------------------------------------------
 	// 1. Ask the user which provider should be configured.
 	// ---------------------------------------------------------------------
-	provider := chooseProvider()
+	provider, err := chooseProvider(initProvider)
+	if err != nil {
+		exitWithError("Error initializing project", err)
+	}
 
 	// ---------------------------------------------------------------------
 	// 2. Generate project configuration and update annotations
 	// ---------------------------------------------------------------------
-	if err := project.Create(".", provider); err != nil {
-		fmt.Printf("Error initializing project: %v\n", err)
-		os.Exit(1)
+	progress := newAnnotationProgress(cmd.ErrOrStderr())
+	err = project.CreateWithReporter(cmd.Context(), ".", provider, progress)
+	progress.logTimings()
+	if err != nil {
+		exitWithError("Error initializing project", err)
 	}
 
+	if metrics := progress.Metrics(); metrics.Calls > 0 && !ui.Quiet() {
+		fmt.Printf("Annotations: %s.\n", metrics)
+		if metrics.Usage.Calls > 0 {
+			fmt.Printf("LLM usage: %s.\n", metrics.Usage)
+		}
+	}
 	fmt.Println("Project initialized successfully.")
 }
 
-// chooseProvider interacts with the user to pick a provider.  When the
-// session is not interactive or the prompt fails, it returns the default
-// provider.
-func chooseProvider() string {
-	providers := llm.SupportedProviders()
-
-	var selection string
-	prompt := &survey.Select{
-		Message: "Select LLM provider:",
-		Options: providers,
-		Default: config.Default().Provider,
+// chooseProvider returns flagValue when set, and otherwise asks the user to
+// pick a provider. It never falls back to a default: when the session is
+// not interactive, the provider must be passed with --provider.
+func chooseProvider(flagValue string) (string, error) {
+	if flagValue != "" {
+		return parseProvider(flagValue)
 	}
-	// Ignore prompt errors (non-tty, etc.) and fall back to default.
-	if err := survey.AskOne(prompt, &selection); err != nil || selection == "" {
-		return config.Default().Provider
+
+	selection, err := ui.Select("Select LLM provider:", llm.SupportedProviders(), config.Default().Provider, "--provider")
+	if err != nil {
+		return "", fmt.Errorf("failed to select a provider: %w", err)
+	}
+	return selection, nil
+}
+
+// parseProvider normalizes a provider name passed on the command line and
+// rejects the ones the llm package does not support.
+func parseProvider(name string) (string, error) {
+	if !llm.IsSupportedProvider(name) {
+		return "", fmt.Errorf("unsupported provider %q, expected one of %s", name, strings.Join(llm.SupportedProviders(), ", "))
 	}
-	return selection
+	return strings.ToLower(name), nil
 }
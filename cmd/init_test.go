@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/vybdev/vyb/cmd/ui"
+)
+
+func TestChooseProvider(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		_ = r.Close()
+		_ = w.Close()
+	})
+
+	if _, err := chooseProvider(""); !errors.Is(err, ui.ErrNotInteractive) {
+		t.Fatalf("expected ErrNotInteractive without --provider, got %v", err)
+	}
+	got, err := chooseProvider("Gemini")
+	if err != nil || got != "gemini" {
+		t.Fatalf("chooseProvider(Gemini) = %q, %v; want gemini", got, err)
+	}
+	if _, err := chooseProvider("acme"); err == nil {
+		t.Fatalf("expected unsupported provider error")
+	}
+}
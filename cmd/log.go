@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/internal/diff"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var logEntries int
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Shows historical information recorded by vyb.",
+}
+
+var logAnnotationsCmd = &cobra.Command{
+	Use:   "annotations <module>",
+	Short: "Shows the last annotation versions recorded for a module.",
+	Args:  cobra.ExactArgs(1),
+	Run:   LogAnnotations,
+}
+
+func init() {
+	logAnnotationsCmd.Flags().IntVarP(&logEntries, "number", "n", 5, "number of versions to show")
+	logCmd.AddCommand(logAnnotationsCmd)
+}
+
+// LogAnnotations is the cobra handler for `vyb log annotations`.
+func LogAnnotations(cmd *cobra.Command, args []string) {
+	module := args[0]
+	w := cmd.OutOrStdout()
+
+	projectRoot, err := workingProjectRoot()
+	if err != nil {
+		exitWithError("Error locating project root", err)
+	}
+
+	versions, err := project.LoadAnnotationHistory(projectRoot, module)
+	if err != nil {
+		exitWithError("Error loading annotation history", err)
+	}
+	if len(versions) == 0 {
+		fmt.Fprintf(w, "No annotation history recorded for module %q.\n", module)
+		return
+	}
+
+	start := 0
+	if logEntries > 0 && len(versions) > logEntries {
+		start = len(versions) - logEntries
+	}
+	// Newest first, like `git log`.
+	for i := len(versions) - 1; i >= start; i-- {
+		v := versions[i]
+		fmt.Fprintf(w, "version %d – %s\n", i+1, v.Timestamp.Format("2006-01-02 15:04:05"))
+		if i == 0 {
+			fmt.Fprintf(w, "  %s\n\n", v.Annotation.InternalContext)
+			continue
+		}
+		d := diff.Words(versions[i-1].Annotation.InternalContext, v.Annotation.InternalContext)
+		if d == "" {
+			d = "(internal context unchanged)"
+		}
+		fmt.Fprintf(w, "  %s\n\n", d)
+	}
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert a .vyb directory created by an older vyb version",
+	Long: `This command converts a .vyb directory written by an older vyb version into
+the current layout. The original files are archived under .vyb/legacy/,
+reusable summaries are kept as annotations and everything else is
+regenerated as in 'vyb update'. It must be executed on the project root.`,
+	Run: Migrate,
+}
+
+func Migrate(cmd *cobra.Command, _ []string) {
+	report, err := project.Migrate(cmd.Context(), ".")
+	if err != nil {
+		exitWithError("Error migrating project", err)
+	}
+
+	out := ui.NewAuto(cmd.OutOrStdout())
+	if report.Layout == project.LayoutCurrent {
+		out.Success("Project already uses the current layout, nothing to migrate.")
+		return
+	}
+
+	out.Heading(fmt.Sprintf("Migrated from the %q layout", report.Layout))
+	out.Printf("Originals archived under .vyb/legacy/:\n")
+	for _, p := range report.Archived {
+		out.Printf("  %s\n", p)
+	}
+	out.Printf("\n")
+
+	var rows [][]string
+	if report.PreservedConfig {
+		rows = append(rows, []string{"  .vyb/config.yaml", "preserved"})
+	} else {
+		rows = append(rows, []string{"  .vyb/config.yaml", "regenerated"})
+	}
+	for _, m := range report.PreservedAnnotations {
+		rows = append(rows, []string{"  " + m, "annotation preserved"})
+	}
+	for _, m := range report.RegeneratedAnnotations {
+		rows = append(rows, []string{"  " + m, "annotation regenerated"})
+	}
+	out.Table(nil, rows)
+	out.Success("Project migrated successfully.")
+}
@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/internal/diff"
+	"github.com/vybdev/vyb/llm"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/backup"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var narrateSince string
+var narrateLast bool
+var narrateOutput string
+
+// getChangeNarrative is the LLM entry-point used by `vyb narrate`.
+// NOTE: it is a var (not a direct call) to allow test overrides.
+var getChangeNarrative = llm.GetChangeNarrative
+
+var narrateCmd = &cobra.Command{
+	Use:   "narrate",
+	Short: "Writes a commit message or pull request description of applied changes.",
+	Long: `Describes the changes applied to the working tree, as they are on disk
+(formatter effects included), with a title, a body, risk notes and a test
+plan.
+
+With --last (the default), the changes are those of the last applied
+proposal, compared with the backup taken before applying it; the
+instructions of its command are sent too. With --since <ref>, they are the
+changes since the given git ref, untracked files included.
+
+Diffs too large for the request token budget are summarized per file.`,
+	Args: cobra.NoArgs,
+	Run:  Narrate,
+}
+
+func init() {
+	narrateCmd.Flags().StringVar(&narrateSince, "since", "", "describe the changes since this git ref")
+	narrateCmd.Flags().BoolVar(&narrateLast, "last", false, "describe the last applied proposal (default)")
+	narrateCmd.Flags().StringVarP(&narrateOutput, "output", "o", "", "write the narrative to this file instead of the standard output")
+	narrateCmd.MarkFlagsMutuallyExclusive("since", "last")
+}
+
+// Narrate is the cobra handler for `vyb narrate`.
+func Narrate(cmd *cobra.Command, _ []string) {
+	absWorkingDir, err := filepath.Abs(".")
+	if err != nil {
+		fmt.Printf("Error determining working directory: %v\n", err)
+		os.Exit(1)
+	}
+	distToRoot, err := project.FindDistanceToRoot(absWorkingDir)
+	if err != nil {
+		exitWithError("Error locating project root", err)
+	}
+	projectRoot := filepath.Join(absWorkingDir, distToRoot)
+
+	w := cmd.OutOrStdout()
+	if narrateOutput != "" {
+		f, err := os.Create(narrateOutput)
+		if err != nil {
+			exitWithError("Error creating output file", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	usage, err := runNarrate(cmd.Context(), w, projectRoot, narrateSince)
+	if err != nil {
+		exitWithError("Error narrating changes", err)
+	}
+	// stdout only carries the narrative, so it can be redirected to a file.
+	if usage.Calls > 0 {
+		ui.NewAuto(cmd.ErrOrStderr()).Info().Printf("LLM usage: %s.\n", usage)
+	}
+}
+
+// narrateSystemMessage is the system message of `vyb narrate`.
+const narrateSystemMessage = `You are a senior software engineer writing the commit message and pull
+request description of a change already applied to a code base. You are given
+the instructions the change was made from, when known, the context of the
+modules it touches, and the final diff of every changed file. Some diffs are
+too large and only summarized.
+
+Describe the change as it is in the diffs, which may differ from the
+instructions. Write for a reviewer who has not seen the change: say what it
+does and why before how. Do not invent facts the diffs do not support.`
+
+// fileChange is the content of a changed file before and after the change.
+type fileChange struct {
+	Path             string
+	Old, New         string
+	Created, Deleted bool
+}
+
+// runNarrate writes to w the narrative of the changes since the git ref
+// since, or of the last applied proposal when since is empty, and returns
+// the usage of the LLM call.
+func runNarrate(ctx context.Context, w io.Writer, projectRoot, since string) (payload.UsageStats, error) {
+	cfg, err := config.Load(projectRoot)
+	if err != nil {
+		return payload.UsageStats{}, err
+	}
+
+	var changes []fileChange
+	var origin backup.Origin
+	if since != "" {
+		changes, err = gitChanges(projectRoot, since)
+	} else {
+		changes, origin, err = lastChanges(projectRoot)
+	}
+	if err != nil {
+		return payload.UsageStats{}, err
+	}
+
+	req := &payload.ChangeNarrativeRequest{Instructions: origin.Instructions}
+	var paths []string
+	for _, c := range changes {
+		if d := diff.Unified(c.Path, c.Old, c.New, c.Created, c.Deleted); d != "" {
+			req.Diffs = append(req.Diffs, payload.FileDiff{Path: c.Path, Diff: d})
+			paths = append(paths, c.Path)
+		}
+	}
+	if len(req.Diffs) == 0 {
+		return payload.UsageStats{}, errors.New("no change to narrate")
+	}
+	req.ModuleContexts = moduleContexts(projectRoot, paths)
+
+	budget := cfg.Request.TokenBudget(config.ModelSizeSmall)
+	if budget > 0 {
+		enc := project.EncodingFor(cfg)
+		used, err := project.CountTokensFor(enc, narrateSystemMessage+req.Instructions)
+		if err != nil {
+			return payload.UsageStats{}, err
+		}
+		for _, mc := range req.ModuleContexts {
+			n, err := project.CountTokensFor(enc, mc.Content)
+			if err != nil {
+				return payload.UsageStats{}, err
+			}
+			used += n
+		}
+		if req.Diffs, err = fitDiffs(req.Diffs, budget-used, enc); err != nil {
+			return payload.UsageStats{}, err
+		}
+	}
+
+	narrative, usage, err := getChangeNarrative(ctx, cfg, config.ModelFamilyGPT, config.ModelSizeSmall, narrateSystemMessage, req)
+	if err != nil {
+		return usage, err
+	}
+	_, err = io.WriteString(w, renderNarrative(narrative))
+	return usage, err
+}
+
+// lastChanges returns the changes of the last applied proposal, and its
+// origin.
+func lastChanges(projectRoot string) ([]fileChange, backup.Origin, error) {
+	m, err := backup.Latest(projectRoot)
+	if errors.Is(err, backup.ErrNoBackup) {
+		return nil, backup.Origin{}, errors.New("no applied proposal to narrate, pass --since to describe a git range")
+	}
+	if err != nil {
+		return nil, backup.Origin{}, err
+	}
+	var changes []fileChange
+	for _, e := range m.Files {
+		c := fileChange{Path: e.Path, Created: e.Action == backup.Created}
+		if !c.Created {
+			old, err := m.Saved(e.Path)
+			if err != nil {
+				return nil, backup.Origin{}, fmt.Errorf("failed to read the backup of %s: %w", e.Path, err)
+			}
+			c.Old = string(old)
+		}
+		if c.New, c.Deleted, err = readCurrent(projectRoot, e.Path); err != nil {
+			return nil, backup.Origin{}, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, m.Origin, nil
+}
+
+// gitChanges returns the changes of the files under projectRoot since ref,
+// untracked files included. vyb's own files are left out.
+func gitChanges(projectRoot, ref string) ([]fileChange, error) {
+	changed, err := git(projectRoot, "diff", "--name-only", "--relative", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(projectRoot, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	for _, p := range strings.Split(changed+untracked, "\n") {
+		if p == "" || seen[p] || strings.HasPrefix(p, ".vyb/") {
+			continue
+		}
+		seen[p] = true
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var changes []fileChange
+	for _, p := range paths {
+		c := fileChange{Path: p}
+		old, err := git(projectRoot, "show", ref+":./"+p)
+		if err != nil {
+			c.Created = true
+		}
+		c.Old = old
+		if c.New, c.Deleted, err = readCurrent(projectRoot, p); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// git runs git in dir and returns its output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// readCurrent returns the content of path in the working tree, or deleted
+// true when it no longer exists.
+func readCurrent(projectRoot, path string) (content string, deleted bool, err error) {
+	data, err := os.ReadFile(filepath.Join(projectRoot, filepath.FromSlash(path)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), false, nil
+}
+
+// moduleContexts returns the internal context of the modules holding
+// paths, in order of first appearance. It returns nothing when the
+// metadata cannot be loaded.
+func moduleContexts(projectRoot string, paths []string) []payload.ModuleContext {
+	meta, err := project.LoadMetadata(projectRoot)
+	if err != nil || meta.Modules == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var contexts []payload.ModuleContext
+	for _, p := range paths {
+		m := project.FindModule(meta.Modules, p)
+		if m == nil || seen[m.Name] || m.Annotation == nil || m.Annotation.InternalContext == "" {
+			continue
+		}
+		seen[m.Name] = true
+		contexts = append(contexts, payload.ModuleContext{Name: m.Name, Content: m.Annotation.InternalContext})
+	}
+	return contexts
+}
+
+// fitDiffs summarizes the largest diffs until they all fit in budget
+// tokens, counted in the encoding enc, and fails when even the summaries do
+// not fit.
+func fitDiffs(diffs []payload.FileDiff, budget int, enc tokenizer.Encoding) ([]payload.FileDiff, error) {
+	tokens := make([]int, len(diffs))
+	total := 0
+	for i, d := range diffs {
+		n, err := project.CountTokensFor(enc, d.Diff)
+		if err != nil {
+			return nil, err
+		}
+		tokens[i] = n
+		total += n
+	}
+	order := make([]int, len(diffs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return tokens[order[a]] > tokens[order[b]] })
+
+	fitted := append([]payload.FileDiff(nil), diffs...)
+	for _, i := range order {
+		if total <= budget {
+			break
+		}
+		fitted[i] = payload.FileDiff{Path: diffs[i].Path, Diff: summarizeDiff(diffs[i].Diff), Summarized: true}
+		n, err := project.CountTokensFor(enc, fitted[i].Diff)
+		if err != nil {
+			return nil, err
+		}
+		total += n - tokens[i]
+	}
+	if total > budget {
+		return nil, fmt.Errorf("the diffs of %d files exceed the request token budget of %d tokens even summarized, narrow the range of changes", len(diffs), budget)
+	}
+	return fitted, nil
+}
+
+// summarizeDiff outlines a unified diff: the number of lines added and
+// removed, and the header of every hunk.
+func summarizeDiff(d string) string {
+	var added, removed int
+	var hunks []string
+	for _, line := range strings.Split(d, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "@@"):
+			hunks = append(hunks, line)
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return fmt.Sprintf("%d lines added and %d removed in %d hunks:\n%s", added, removed, len(hunks), strings.Join(hunks, "\n"))
+}
+
+// renderNarrative formats n as a commit message: the title, the body, then
+// the risk notes and the test plan, when not empty.
+func renderNarrative(n *payload.ChangeNarrative) string {
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSpace(n.Title) + "\n")
+	if body := strings.TrimSpace(n.Body); body != "" {
+		sb.WriteString("\n" + body + "\n")
+	}
+	if risks := strings.TrimSpace(n.RiskNotes); risks != "" {
+		sb.WriteString("\n## Risk notes\n\n" + risks + "\n")
+	}
+	if plan := strings.TrimSpace(n.TestPlan); plan != "" {
+		sb.WriteString("\n## Test plan\n\n" + plan + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/backup"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// useNarrative makes getChangeNarrative return n, recording the request it
+// receives in req.
+func useNarrative(t *testing.T, n *payload.ChangeNarrative, req **payload.ChangeNarrativeRequest) {
+	t.Helper()
+	old := getChangeNarrative
+	getChangeNarrative = func(_ context.Context, _ *config.Config, _ config.ModelFamily, _ config.ModelSize, _ string, r *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+		*req = r
+		return n, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getChangeNarrative = old })
+}
+
+func TestRunNarrate_Last(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go": "package main\n",
+		"old.go":  "package main // old\n",
+	})
+	writeMetadata(t, root)
+	origin := backup.Origin{Command: "code", Instructions: "add a greeting"}
+	if _, err := backup.Create(root, origin, []backup.Change{{Path: "main.go"}, {Path: "new.go"}, {Path: "old.go", Delete: true}}); err != nil {
+		t.Fatalf("backup.Create: %v", err)
+	}
+	// The proposal, as reformatted after being applied.
+	writeFiles(t, root, map[string]string{
+		"main.go": "package main\n\nfunc greet() {}\n",
+		"new.go":  "package main // new\n",
+	})
+	if err := os.Remove(filepath.Join(root, "old.go")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	var req *payload.ChangeNarrativeRequest
+	useNarrative(t, &payload.ChangeNarrative{Title: "Add a greeting", Body: "Adds greet.", TestPlan: "go test ./..."}, &req)
+	var out bytes.Buffer
+	if _, err := runNarrate(context.Background(), &out, root, ""); err != nil {
+		t.Fatalf("runNarrate: %v", err)
+	}
+
+	if req.Instructions != "add a greeting" {
+		t.Fatalf("expected the instructions of the command, got %q", req.Instructions)
+	}
+	if len(req.Diffs) != 3 {
+		t.Fatalf("expected a diff per changed file, got %+v", req.Diffs)
+	}
+	for i, want := range []string{"+func greet() {}", "+++ b/new.go", "+++ /dev/null"} {
+		if !strings.Contains(req.Diffs[i].Diff, want) {
+			t.Errorf("diff %d: expected %q, got:\n%s", i, want, req.Diffs[i].Diff)
+		}
+	}
+	if len(req.ModuleContexts) != 1 || req.ModuleContexts[0].Content != "not a structural change" {
+		t.Fatalf("expected the context of the root module, got %+v", req.ModuleContexts)
+	}
+	want := "Add a greeting\n\nAdds greet.\n\n## Test plan\n\ngo test ./...\n"
+	if out.String() != want {
+		t.Fatalf("unexpected narrative:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRunNarrate_NothingApplied(t *testing.T) {
+	root := t.TempDir()
+	if _, err := runNarrate(context.Background(), &bytes.Buffer{}, root, ""); err == nil || !strings.Contains(err.Error(), "--since") {
+		t.Fatalf("expected an error suggesting --since, got %v", err)
+	}
+}
+
+func TestRunNarrate_Since(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.go": "package main\n", "gone.go": "package main\n"})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		if _, err := git(root, args...); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	writeFiles(t, root, map[string]string{
+		"main.go":            "package main\n\nfunc main() {}\n",
+		"added.go":           "package main\n",
+		".vyb/metadata.yaml": "modules: {}\n",
+	})
+	if err := os.Remove(filepath.Join(root, "gone.go")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	var req *payload.ChangeNarrativeRequest
+	useNarrative(t, &payload.ChangeNarrative{Title: "t"}, &req)
+	if _, err := runNarrate(context.Background(), &bytes.Buffer{}, root, "HEAD"); err != nil {
+		t.Fatalf("runNarrate: %v", err)
+	}
+	var paths []string
+	for _, d := range req.Diffs {
+		paths = append(paths, d.Path)
+	}
+	if strings.Join(paths, ",") != "added.go,gone.go,main.go" {
+		t.Fatalf("expected the changed and untracked files, without vyb's own, got %v", paths)
+	}
+	if !strings.Contains(req.Diffs[0].Diff, "--- /dev/null") || !strings.Contains(req.Diffs[1].Diff, "+++ /dev/null") {
+		t.Fatalf("expected a created and a deleted file, got %+v", req.Diffs)
+	}
+
+	if _, err := runNarrate(context.Background(), &bytes.Buffer{}, root, "no-such-ref"); err == nil {
+		t.Fatal("expected an error for an unknown ref")
+	}
+}
+
+func TestFitDiffs(t *testing.T) {
+	large := "--- a/big.go\n+++ b/big.go\n@@ -1,0 +1,200 @@\n" + strings.Repeat("+var x = 1 // a long line of code\n", 200)
+	diffs := []payload.FileDiff{
+		{Path: "small.go", Diff: "--- a/small.go\n+++ b/small.go\n@@ -1 +1 @@\n-a\n+b\n"},
+		{Path: "big.go", Diff: large},
+	}
+
+	fitted, err := fitDiffs(diffs, 200, project.DefaultEncoding)
+	if err != nil {
+		t.Fatalf("fitDiffs: %v", err)
+	}
+	if fitted[0] != diffs[0] {
+		t.Fatalf("expected the small diff to be kept, got %+v", fitted[0])
+	}
+	if !fitted[1].Summarized || !strings.HasPrefix(fitted[1].Diff, "200 lines added and 0 removed in 1 hunks:\n@@ -1,0 +1,200 @@") {
+		t.Fatalf("expected the large diff to be summarized, got %+v", fitted[1])
+	}
+	if diffs[1].Diff != large {
+		t.Fatal("fitDiffs must not modify its input")
+	}
+
+	if _, err := fitDiffs(diffs, 5, project.DefaultEncoding); err == nil || !strings.Contains(err.Error(), "token budget of 5") {
+		t.Fatalf("expected an error when even the summaries do not fit, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/logging"
+	"github.com/vybdev/vyb/workspace/outline"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var outlineFormat string
+
+var outlineCmd = &cobra.Command{
+	Use:   "outline [path]",
+	Short: "Print an overview of a directory without calling the LLM",
+	Long: `This command renders a deterministic overview of the given directory (the
+current one by default) from local data only: its files grouped by role
+(source, test, config, docs), directory layout, token distribution and
+exported Go API. Within a vyb project the stored annotation of the
+enclosing module is included. It needs no network access and also works
+outside of a project, before 'vyb init'.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  Outline,
+}
+
+func init() {
+	outlineCmd.Flags().StringVar(&outlineFormat, "format", "markdown", "output format (markdown or json)")
+}
+
+func Outline(cmd *cobra.Command, args []string) {
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	if err := runOutline(cmd.OutOrStdout(), dir, outlineFormat); err != nil {
+		exitWithError("Error outlining directory", err)
+	}
+}
+
+// runOutline writes the outline of dir to w in the given format.
+func runOutline(w io.Writer, dir, format string) error {
+	if format != "markdown" && format != "json" {
+		return fmt.Errorf("unsupported format %q, expected markdown or json", format)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to determine absolute path of %s: %w", dir, err)
+	}
+	if fi, err := os.Stat(absDir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	o, err := outline.Build(os.DirFS(absDir), filepath.ToSlash(dir))
+	if err != nil {
+		return err
+	}
+	attachAnnotation(o, absDir)
+
+	if format == "json" {
+		return outline.WriteJSON(w, o)
+	}
+	return outline.WriteMarkdown(w, o)
+}
+
+// attachAnnotation adds the stored annotation of the module enclosing
+// absDir to o, when absDir is within an initialized project.
+func attachAnnotation(o *outline.Outline, absDir string) {
+	distToRoot, err := project.FindDistanceToRoot(absDir)
+	if err != nil {
+		return
+	}
+	absRoot := filepath.Join(absDir, distToRoot)
+	meta, err := project.LoadMetadata(absRoot)
+	if err != nil {
+		if !errors.Is(err, project.ErrNoMetadata) {
+			logging.Log.Warnf("annotations left out of the outline: %v", err)
+		}
+		return
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	if err != nil {
+		return
+	}
+	o.AttachAnnotation(meta, filepath.ToSlash(rel))
+}
@@ -3,11 +3,12 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
 	"github.com/vybdev/vyb/workspace/project"
-	"os"
 )
 
 var forceRoot bool
+var removeYes bool
 
 var removeCmd = &cobra.Command{
 	Use:   "remove",
@@ -16,13 +17,23 @@ var removeCmd = &cobra.Command{
 }
 
 func init() {
+	removeCmd.Flags().BoolVarP(&removeYes, "yes", "y", false, "remove without asking for confirmation")
 }
 
 func Remove(_ *cobra.Command, _ []string) {
+	if !removeYes {
+		ok, err := ui.Confirm("Remove all vyb metadata, including annotations, from this project?", "--yes")
+		if err != nil {
+			exitWithError("Error removing project configuration", err)
+		}
+		if !ok {
+			fmt.Println("Project configuration left untouched.")
+			return
+		}
+	}
 	err := project.Remove(".")
 	if err != nil {
-		fmt.Printf("Error removing project configuration: %v\n", err)
-		os.Exit(1)
+		exitWithError("Error removing project configuration", err)
 	}
 	fmt.Println("Project configuration removed successfully.")
 }
@@ -1,39 +1,52 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/vybdev/vyb/cmd/template"
+	"github.com/vybdev/vyb/cmd/ui"
 	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/logging"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 var logLevel string
 var debugLogging bool
+var noColor bool
+var quiet bool
 
 var rootCmd = &cobra.Command{
 	Use:   "vyb",
 	Short: "vyb is a CLI tool that uses AI to help you iteratively develop applications faster",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		cfg, err := config.Load(".")
+		cfg, err := config.Discover(".")
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		if logLevel == "" {
-			logLevel = cfg.Logging.Level
+		if noColor {
+			ui.DisableColor()
 		}
 
-		if logLevel == "" {
-			logLevel = "info"
+		if quiet {
+			ui.SetQuiet(true)
+			llm.DisableProgress()
 		}
 
-		if err := logging.Init(logLevel); err != nil {
+		level, requestResponseDebug := loggingSettings(cfg.Logging, logLevel, debugLogging, quiet)
+		if err := logging.Init(level); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+
+		if requestResponseDebug {
+			llm.EnableRequestResponseDebug()
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommand is provided, print usage.
@@ -41,17 +54,46 @@ var rootCmd = &cobra.Command{
 	},
 }
 
-// Execute executes the root command.
+// loggingSettings resolves the application log level and whether LLM
+// request/response pairs are recorded, from the logging configuration and
+// the --log-level, --debug and --quiet flags. The two are independent:
+// --log-level only sets the level, which takes precedence over
+// logging.level, and --debug only turns recording on, like
+// logging.request-response-debug. Quiet mode lowers the default level to
+// error, unless --log-level is given.
+func loggingSettings(cfg config.Logging, flagLevel string, debug, quiet bool) (level string, requestResponseDebug bool) {
+	switch {
+	case flagLevel != "":
+		level = flagLevel
+	case quiet:
+		level = "error"
+	case cfg.Level != "":
+		level = cfg.Level
+	default:
+		level = "info"
+	}
+	return level, debug || cfg.RequestResponseDebug
+}
+
+// Execute executes the root command. SIGINT and SIGTERM cancel the context
+// of the command, aborting the LLM requests in flight; a second signal
+// terminates the process right away.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		exitWithError("Error", err)
 	}
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level (e.g. debug, info, warn, error, fatal, panic)")
-	rootCmd.PersistentFlags().BoolVar(&debugLogging, "debug", false, "enable request/response debug logging")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "application log level (e.g. debug, info, warn, error, fatal, panic), overriding logging.level")
+	rootCmd.PersistentFlags().BoolVar(&debugLogging, "debug", false, "record every LLM request/response pair under .vyb/logs/")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colored output (also honours the NO_COLOR environment variable)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only print results and errors")
 	err := template.Register(rootCmd)
 	if err != nil {
 		fmt.Println(err)
@@ -60,6 +102,19 @@ func init() {
 
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(removeCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(logCmd)
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(backupsCmd)
+	rootCmd.AddCommand(narrateCmd)
+	rootCmd.AddCommand(outlineCmd)
+	rootCmd.AddCommand(contextCmd)
+	rootCmd.AddCommand(template.NewRunCommand())
+	rootCmd.AddCommand(template.NewApplyCommand())
 }
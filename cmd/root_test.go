@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+)
+
+func TestLoggingSettings(t *testing.T) {
+	cases := []struct {
+		name      string
+		cfg       config.Logging
+		flagLevel string
+		debug     bool
+		quiet     bool
+		wantLevel string
+		wantDebug bool
+	}{
+		{name: "defaults", wantLevel: "info"},
+		{name: "--debug alone", debug: true, wantLevel: "info", wantDebug: true},
+		{name: "--log-level alone", flagLevel: "debug", wantLevel: "debug"},
+		{name: "--log-level and --debug", flagLevel: "warn", debug: true, wantLevel: "warn", wantDebug: true},
+		{name: "config level", cfg: config.Logging{Level: "debug"}, wantLevel: "debug"},
+		{name: "config recording", cfg: config.Logging{RequestResponseDebug: true}, wantLevel: "info", wantDebug: true},
+		{name: "config level and recording", cfg: config.Logging{Level: "debug", RequestResponseDebug: true}, wantLevel: "debug", wantDebug: true},
+		{name: "--log-level over config level", cfg: config.Logging{Level: "debug"}, flagLevel: "error", wantLevel: "error"},
+		{name: "config level with --debug", cfg: config.Logging{Level: "warn"}, debug: true, wantLevel: "warn", wantDebug: true},
+		{name: "--log-level with config recording", cfg: config.Logging{RequestResponseDebug: true}, flagLevel: "debug", wantLevel: "debug", wantDebug: true},
+		{name: "quiet", cfg: config.Logging{Level: "debug"}, quiet: true, wantLevel: "error"},
+		{name: "quiet with --log-level", quiet: true, flagLevel: "debug", wantLevel: "debug"},
+		{name: "quiet with --debug", quiet: true, debug: true, wantLevel: "error", wantDebug: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			level, debug := loggingSettings(c.cfg, c.flagLevel, c.debug, c.quiet)
+			if level != c.wantLevel || debug != c.wantDebug {
+				t.Fatalf("got level %q and request/response debug %v, want %q and %v", level, debug, c.wantLevel, c.wantDebug)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the modules changed since the last update",
+	Long: `This command compares the stored project metadata with the current project
+files and lists the modules that were added, removed or changed since the
+last 'vyb update', with their previous and current token counts and when
+their annotation was generated, as well as the modules missing an
+annotation. Nothing is modified. It exits with code 6 when the metadata is
+stale, so scripts can gate on it. It can be executed from any directory
+within the project.`,
+	Args: cobra.NoArgs,
+	Run:  Status,
+}
+
+func Status(cmd *cobra.Command, _ []string) {
+	code, err := runStatus(cmd.OutOrStdout(), ".")
+	if err != nil {
+		exitWithError("Error checking project status", err)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// runStatus reports the stale modules of the project containing dir,
+// returning the exit code to use.
+func runStatus(w io.Writer, dir string) (int, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine absolute working dir: %w", err)
+	}
+	distToRoot, err := project.FindDistanceToRoot(absDir)
+	if err != nil {
+		return 0, err
+	}
+	absRoot := filepath.Join(absDir, distToRoot)
+
+	cfg, err := config.Load(absRoot)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := project.LoadMetadata(absRoot)
+	if err != nil {
+		return 0, err
+	}
+	fresh, err := project.BuildMetadataFS(os.DirFS(absRoot))
+	if err != nil {
+		return 0, err
+	}
+	patch := stored.DryRunPatch(fresh)
+	var unannotated []string
+	collectUnannotated(cfg, stored.Modules, patch, &unannotated)
+
+	out := ui.NewAuto(w)
+	if len(patch.AddedModules) == 0 && len(patch.RemovedModules) == 0 && len(patch.ChangedModules) == 0 && len(unannotated) == 0 {
+		out.Success("Project metadata is up to date.")
+		return 0, nil
+	}
+
+	tokens := func(root *project.Module, name string) string {
+		if m := project.FindModule(root, name); m != nil && m.Name == name {
+			return fmt.Sprint(m.TokenCount)
+		}
+		return "-"
+	}
+	// annotated tells when the stored annotation of a module was generated,
+	// so users can weigh it against the changes.
+	annotated := func(name string) string {
+		m := project.FindModule(stored.Modules, name)
+		if m == nil || m.Name != name || m.Annotation == nil {
+			return "-"
+		}
+		if m.Annotation.GeneratedAt.IsZero() {
+			return "unknown"
+		}
+		return m.Annotation.GeneratedAt.Local().Format("2006-01-02 15:04")
+	}
+	var rows [][]string
+	for _, name := range sorted(patch.AddedModules) {
+		rows = append(rows, []string{name, "added", "-", tokens(fresh.Modules, name), "-"})
+	}
+	for _, name := range sorted(patch.RemovedModules) {
+		rows = append(rows, []string{name, "removed", tokens(stored.Modules, name), "-", annotated(name)})
+	}
+	changed := make([]string, 0, len(patch.ChangedModules))
+	for name := range patch.ChangedModules {
+		changed = append(changed, name)
+	}
+	for _, name := range sorted(changed) {
+		c := patch.ChangedModules[name]
+		rows = append(rows, []string{name, "changed", fmt.Sprint(c.PreviousTokenCount), fmt.Sprint(c.CurrentTokenCount), annotated(name)})
+	}
+	for _, name := range sorted(unannotated) {
+		count := tokens(stored.Modules, name)
+		rows = append(rows, []string{name, "not annotated", count, count, "-"})
+	}
+	out.Table([]string{"MODULE", "STATUS", "PREVIOUS TOKENS", "CURRENT TOKENS", "ANNOTATED"}, rows)
+	if project.HeuristicTokenCounts() {
+		out.Warn("%s.", project.HeuristicTokensNote)
+	}
+	out.Warn("metadata is stale. Run 'vyb update' to refresh.")
+	return exitOutdatedMetadata, nil
+}
+
+// collectUnannotated appends to dst the name of every module of the tree
+// rooted at m that has neither an internal nor a public context, unless
+// patch already reports it as changed or removed, or it is intentionally
+// left without annotation (see project.SkipsAnnotation).
+func collectUnannotated(cfg *config.Config, m *project.Module, patch *project.PatchResult, dst *[]string) {
+	if m == nil {
+		return
+	}
+	_, changed := patch.ChangedModules[m.Name]
+	removed := false
+	for _, name := range patch.RemovedModules {
+		removed = removed || name == m.Name
+	}
+	if !changed && !removed && !project.SkipsAnnotation(cfg, m) && (m.Annotation == nil || (m.Annotation.InternalContext == "" && m.Annotation.PublicContext == "")) {
+		*dst = append(*dst, m.Name)
+	}
+	for _, child := range m.Modules {
+		collectUnannotated(cfg, child, patch, dst)
+	}
+}
+
+func sorted(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
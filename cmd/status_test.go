@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/workspace/project"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRunStatus(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":    "package main\n",
+		"pkg/lib.go": "package pkg\n",
+	})
+	writeMetadata(t, root)
+	nested := filepath.Join(root, "pkg")
+
+	var out bytes.Buffer
+	code, err := runStatus(&out, nested)
+	if err != nil || code != 0 || !strings.Contains(out.String(), "up to date") {
+		t.Fatalf("expected up-to-date status, got %d, %v:\n%s", code, err, out.String())
+	}
+
+	writeFiles(t, root, map[string]string{
+		"pkg/lib.go":  "package pkg\n\nfunc Greet() string { return \"hi\" }\n",
+		"util/str.go": "package util\n",
+	})
+	out.Reset()
+	code, err = runStatus(&out, nested)
+	if err != nil {
+		t.Fatalf("runStatus: %v", err)
+	}
+	if code != exitOutdatedMetadata {
+		t.Fatalf("expected exit code %d for stale metadata, got %d", exitOutdatedMetadata, code)
+	}
+	for _, want := range []string{"util", "added", "pkg", "changed", "ANNOTATED", "unknown"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunStatus_MissingAnnotations(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.go": "package main\n"})
+	meta, err := project.BuildMetadataFS(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("BuildMetadataFS: %v", err)
+	}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	writeFiles(t, root, map[string]string{".vyb/metadata.yaml": string(data)})
+
+	var out bytes.Buffer
+	code, err := runStatus(&out, root)
+	if err != nil {
+		t.Fatalf("runStatus: %v", err)
+	}
+	if code != exitOutdatedMetadata || !strings.Contains(out.String(), "not annotated") {
+		t.Fatalf("expected the unannotated module to make the metadata stale, got %d:\n%s", code, out.String())
+	}
+}
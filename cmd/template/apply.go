@@ -0,0 +1,101 @@
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/backup"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// defaultChunkSize is the number of files per chunk `vyb apply` resumes an
+// interrupted proposal with.
+const defaultChunkSize = 50
+
+// NewApplyCommand builds `vyb apply`, which resumes the application of a
+// proposal applied with --chunk-size and interrupted by a failure or a
+// cancellation.
+func NewApplyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Resume the application of an interrupted proposal",
+		Long: `Resumes the proposal applied with --chunk-size whose application was
+interrupted, by a failure or a cancellation, from its first file not in
+place. The chunks applied before the interruption are kept; the proposal is
+read from the backup set taken before applying it, so ` + "`vyb undo`" + ` still
+reverts all of it.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			absWorkingDir, err := filepath.Abs(".")
+			if err != nil {
+				return err
+			}
+			distToRoot, err := project.FindDistanceToRoot(absWorkingDir)
+			if err != nil {
+				return err
+			}
+			chunkSize, _ := cmd.Flags().GetInt("chunk-size")
+			if chunkSize < 0 {
+				return fmt.Errorf("--chunk-size must not be negative, got %d", chunkSize)
+			}
+			out := ui.NewAuto(cmd.OutOrStdout())
+			m, err := resumeApply(cmd.Context(), filepath.Join(absWorkingDir, distToRoot), applyOptions{progress: printProgress(out.Info()), chunkSize: chunkSize})
+			if err != nil {
+				return err
+			}
+			out.Success("Applied the %d files of the proposal of %s.", len(m.Files), m.Timestamp.Format("2006-01-02 15:04:05"))
+			return nil
+		},
+	}
+	cmd.Flags().Int("chunk-size", defaultChunkSize, "number of files applied, and synced to disk, per chunk")
+	return cmd
+}
+
+// printProgress returns an applyOptions.progress printing a "[12/500]
+// path" line per applied file on out.
+func printProgress(out *ui.Printer) func(done, total int, file string) {
+	return func(done, total int, file string) {
+		out.Printf("[%d/%d] %s\n", done, total, file)
+	}
+}
+
+// errNothingToResume is returned by resumeApply when the last applied
+// proposal was not interrupted.
+var errNothingToResume = errors.New("no interrupted proposal to resume, only those applied with --chunk-size can be")
+
+// resumeApply resumes the application in chunks of the proposal of the
+// latest backup set, from its first file not in place, and returns its
+// manifest.
+func resumeApply(ctx context.Context, absRoot string, opts applyOptions) (*backup.Manifest, error) {
+	m, err := backup.Latest(absRoot)
+	if errors.Is(err, backup.ErrNoBackup) {
+		return nil, errNothingToResume
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Progress == nil {
+		return nil, errNothingToResume
+	}
+	data, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var proposals []payload.FileChangeProposal
+	if err := json.Unmarshal(data, &proposals); err != nil {
+		return nil, fmt.Errorf("failed to decode the pending proposal: %w", err)
+	}
+	if len(proposals) != m.Progress.Total || m.Progress.Applied > m.Progress.Total {
+		return nil, fmt.Errorf("the pending proposal has %d files, its backup set records %d of %d applied", len(proposals), m.Progress.Applied, m.Progress.Total)
+	}
+	if opts.chunkSize <= 0 {
+		opts.chunkSize = defaultChunkSize
+	}
+	return m, applyChunks(ctx, absRoot, m, proposals, m.Progress.Applied, opts)
+}
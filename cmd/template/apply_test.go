@@ -0,0 +1,123 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/backup"
+)
+
+func TestApplyProposals_Chunked(t *testing.T) {
+	root := t.TempDir()
+	var proposals []payload.FileChangeProposal
+	for i := range 7 {
+		name := fmt.Sprintf("f%d.go", i)
+		if err := os.WriteFile(filepath.Join(root, name), []byte("package f // old\n"), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		proposals = append(proposals, payload.FileChangeProposal{FileName: name, Content: "package f // new\n"})
+	}
+	before := snapshot(t, root)
+
+	// Canceled within the second chunk, once f3.go is in place.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	progress := func(done, _ int, _ string) {
+		if done == 4 {
+			cancel()
+		}
+	}
+	err := applyProposals(ctx, root, backup.Origin{Command: "code"}, proposals, applyOptions{progress: progress, chunkSize: 3})
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "3 of 7 files applied") {
+		t.Fatalf("expected the interruption to be reported, got %v", err)
+	}
+	for i := range 7 {
+		want := before[fmt.Sprintf("f%d.go", i)]
+		if i < 3 {
+			want = "-rw-r--r-- package f // new\n"
+		}
+		if got := snapshot(t, root)[fmt.Sprintf("f%d.go", i)]; got != want {
+			t.Fatalf("f%d.go: expected only the first chunk to be kept, got %q", i, got)
+		}
+	}
+	m, err := backup.Latest(root)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if diff := cmp.Diff(&backup.Progress{Applied: 3, Total: 7}, m.Progress); diff != "" {
+		t.Fatalf("unexpected progress (-want +got):\n%s", diff)
+	}
+
+	var resumed []string
+	m, err = resumeApply(context.Background(), root, applyOptions{
+		progress:  func(done, _ int, file string) { resumed = append(resumed, fmt.Sprintf("%d:%s", done, file)) },
+		chunkSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("resumeApply: %v", err)
+	}
+	if diff := cmp.Diff([]string{"4:f3.go", "5:f4.go", "6:f5.go", "7:f6.go"}, resumed); diff != "" {
+		t.Fatalf("expected the application to resume after the first chunk (-want +got):\n%s", diff)
+	}
+	for name, got := range snapshot(t, root) {
+		if strings.HasSuffix(name, ".go") && got != "-rw-r--r-- package f // new\n" {
+			t.Fatalf("%s: expected the new content, got %q", name, got)
+		}
+	}
+	if m.Progress != nil || m.Command != "code" {
+		t.Fatalf("expected the set to be completed, got %+v", m)
+	}
+	if _, err := resumeApply(context.Background(), root, applyOptions{}); !errors.Is(err, errNothingToResume) {
+		t.Fatalf("expected nothing left to resume, got %v", err)
+	}
+
+	// The whole proposal is reverted at once.
+	if _, err := backup.Restore(root); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if diff := cmp.Diff(before, snapshot(t, root)); diff != "" {
+		t.Fatalf("expected undo to restore the workspace (-before +after):\n%s", diff)
+	}
+}
+
+func TestApplyProposals_ChunkFailure(t *testing.T) {
+	root := t.TempDir()
+	proposals := []payload.FileChangeProposal{
+		{FileName: "a.go", Content: "package a\n"},
+		{FileName: "b.go", Content: "package b\n"},
+		{FileName: "c.go", Content: "package c\n"},
+	}
+	old := renameFile
+	renameFile = func(from, to string) error {
+		if filepath.Base(to) == "c.go" {
+			return errors.New("disk full")
+		}
+		return old(from, to)
+	}
+	t.Cleanup(func() { renameFile = old })
+
+	err := applyProposals(context.Background(), root, backup.Origin{}, proposals, applyOptions{chunkSize: 2})
+	if err == nil || !strings.Contains(err.Error(), "disk full") || !strings.Contains(err.Error(), "vyb apply") {
+		t.Fatalf("expected the failure to suggest resuming, got %v", err)
+	}
+	want := map[string]string{"./": "", "a.go": "-rw-r--r-- package a\n", "b.go": "-rw-r--r-- package b\n"}
+	if diff := cmp.Diff(want, snapshot(t, root)); diff != "" {
+		t.Fatalf("expected the failed chunk to be rolled back (-want +got):\n%s", diff)
+	}
+
+	renameFile = old
+	if _, err := resumeApply(context.Background(), root, applyOptions{}); err != nil {
+		t.Fatalf("resumeApply: %v", err)
+	}
+	want["c.go"] = "-rw-r--r-- package c\n"
+	if diff := cmp.Diff(want, snapshot(t, root)); diff != "" {
+		t.Fatalf("unexpected workspace after resuming (-want +got):\n%s", diff)
+	}
+}
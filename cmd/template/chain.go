@@ -0,0 +1,140 @@
+package template
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/matcher"
+)
+
+// chainStep records the outcome of an applied step of a command chain, so it
+// can be carried forward to the next steps.
+type chainStep struct {
+	Command     string
+	Summary     string
+	Description string
+	// ChangedFiles lists the files created or modified by the step, and
+	// DeletedFiles those it removed, relative to the project root.
+	ChangedFiles []string
+	DeletedFiles []string
+}
+
+func newChainStep(command string, proposal *payload.WorkspaceChangeProposal) chainStep {
+	step := chainStep{Command: command, Summary: proposal.Summary, Description: proposal.Description}
+	for _, p := range proposal.Proposals {
+		if p.Delete {
+			step.DeletedFiles = append(step.DeletedFiles, p.FileName)
+		} else {
+			step.ChangedFiles = append(step.ChangedFiles, p.FileName)
+		}
+	}
+	return step
+}
+
+// lookupDefinitions returns the registered definitions by name. It is used
+// to resolve the `then` steps of a definition.
+// NOTE: it is a var (not a func) to allow test overrides.
+var lookupDefinitions = func() map[string]*Definition {
+	defs, _ := load()
+	byName := make(map[string]*Definition, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+	return byName
+}
+
+// resolveChain expands def and, depth-first, the commands listed in its
+// `then` field into the ordered list of definitions to execute. Unknown
+// commands and cycles are reported as errors.
+func resolveChain(def *Definition) ([]*Definition, error) {
+	if len(def.Then) == 0 {
+		return []*Definition{def}, nil
+	}
+	registry := lookupDefinitions()
+
+	var chain []*Definition
+	var visit func(d *Definition, path []string) error
+	visit = func(d *Definition, path []string) error {
+		path = append(slices.Clone(path), d.Name)
+		if slices.Contains(path[:len(path)-1], d.Name) {
+			return fmt.Errorf("command chain contains a cycle: %s", strings.Join(path, " -> "))
+		}
+		chain = append(chain, d)
+		for _, name := range d.Then {
+			next, ok := registry[name]
+			if !ok {
+				return fmt.Errorf("command %q chains unknown command %q", d.Name, name)
+			}
+			if err := visit(next, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(def, nil); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// withChainedFiles moves the files changed by the previous steps of a chain
+// to the front of files, adding the ones the selection left out, so they are
+// the last to be dropped by the file token budget. Deleted files and files
+// excluded from the request are skipped.
+func withChainedFiles(rootFS fs.FS, def *Definition, files []string, previous []chainStep) []string {
+	chained := make(map[string]bool)
+	var result []string
+	for _, step := range previous {
+		for _, f := range step.ChangedFiles {
+			if chained[f] {
+				continue
+			}
+			if _, err := fs.Stat(rootFS, f); err != nil {
+				continue
+			}
+			if matcher.IsExcluded(rootFS, f, append(systemExclusionPatterns, def.RequestExclusionPatterns...)) {
+				continue
+			}
+			chained[f] = true
+			result = append(result, f)
+		}
+	}
+	if len(result) == 0 {
+		return files
+	}
+	for _, f := range files {
+		if !chained[f] {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// withPreviousSteps appends to systemMessage the summary of the steps
+// already applied by the command chain, if any.
+func withPreviousSteps(systemMessage string, previous []chainStep) string {
+	if len(previous) == 0 {
+		return systemMessage
+	}
+	var sb strings.Builder
+	sb.WriteString(systemMessage)
+	sb.WriteString("\n\n<!-- BEGIN previous_steps -->\n")
+	sb.WriteString("This command is a step of a chain. The changes of the previous steps, summarized below, are already applied to the files you received.\n")
+	for i, step := range previous {
+		fmt.Fprintf(&sb, "\n### Step %d: %s\n\n%s\n", i+1, step.Command, step.Summary)
+		if step.Description != "" {
+			fmt.Fprintf(&sb, "\n%s\n", step.Description)
+		}
+		if len(step.ChangedFiles) > 0 {
+			fmt.Fprintf(&sb, "\nChanged files: %s\n", strings.Join(step.ChangedFiles, ", "))
+		}
+		if len(step.DeletedFiles) > 0 {
+			fmt.Fprintf(&sb, "\nDeleted files: %s\n", strings.Join(step.DeletedFiles, ", "))
+		}
+	}
+	sb.WriteString("<!-- END previous_steps -->\n")
+	return sb.String()
+}
@@ -0,0 +1,161 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// scriptedCall records a request received by scriptedProvider.
+type scriptedCall struct {
+	SystemMessage string
+	Request       *payload.WorkspaceChangeRequest
+}
+
+// scriptedProvider replaces the LLM entry-point with one returning the given
+// proposals in order, one per call.
+func scriptedProvider(t *testing.T, proposals ...*payload.WorkspaceChangeProposal) *[]scriptedCall {
+	t.Helper()
+	var calls []scriptedCall
+	old := getWorkspaceChangeProposals
+	getWorkspaceChangeProposals = func(_ context.Context, _ *config.Config, _ config.ModelFamily, _ config.ModelSize, systemMessage string, req *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		if len(calls) >= len(proposals) {
+			t.Fatalf("unexpected LLM call #%d", len(calls)+1)
+		}
+		calls = append(calls, scriptedCall{SystemMessage: systemMessage, Request: req})
+		return proposals[len(calls)-1], payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getWorkspaceChangeProposals = old })
+	return &calls
+}
+
+// fakeRegistry replaces the definitions used to resolve `then` steps.
+func fakeRegistry(t *testing.T, defs ...*Definition) {
+	t.Helper()
+	old := lookupDefinitions
+	lookupDefinitions = func() map[string]*Definition {
+		byName := make(map[string]*Definition, len(defs))
+		for _, def := range defs {
+			byName[def.Name] = def
+		}
+		return byName
+	}
+	t.Cleanup(func() { lookupDefinitions = old })
+}
+
+func chainDefinitions() (refactor, testgen *Definition) {
+	refactor = &Definition{
+		Name:                          "refactor",
+		ArgInclusionPatterns:          []string{"*.go"},
+		ModificationInclusionPatterns: []string{"*.go"},
+		ModificationExclusionPatterns: []string{"*_test.go"},
+		Then:                          []string{"testgen"},
+	}
+	testgen = &Definition{
+		Name:                          "testgen",
+		ArgInclusionPatterns:          []string{"*.go"},
+		ModificationInclusionPatterns: []string{"*_test.go"},
+	}
+	return refactor, testgen
+}
+
+func TestExecute_Chain(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	refactor, testgen := chainDefinitions()
+	fakeRegistry(t, refactor, testgen)
+	calls := scriptedProvider(t,
+		&payload.WorkspaceChangeProposal{
+			Summary: "refactor: extract greet",
+			Proposals: []payload.FileChangeProposal{
+				{FileName: "main.go", Content: "package main\n\nfunc main() { greet() }\n"},
+				{FileName: "greet.go", Content: "package main\n\nfunc greet() {}\n"},
+			},
+		},
+		&payload.WorkspaceChangeProposal{
+			Summary:   "test: cover greet",
+			Proposals: []payload.FileChangeProposal{{FileName: "greet_test.go", Content: "package main\n"}},
+		},
+	)
+
+	cmd := newCommand(refactor)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"main.go"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(*calls) != 2 {
+		t.Fatalf("expected 2 LLM calls, got %d", len(*calls))
+	}
+	second := (*calls)[1]
+	var files []string
+	for _, f := range second.Request.Files {
+		files = append(files, f.Path)
+	}
+	if len(files) < 2 || files[0] != "main.go" || files[1] != "greet.go" {
+		t.Fatalf("expected files changed by step 1 first in step 2 request, got %v", files)
+	}
+	if !strings.Contains(second.SystemMessage, "refactor: extract greet") || !strings.Contains(second.SystemMessage, "Changed files: main.go, greet.go") {
+		t.Fatalf("expected step 1 summary in step 2 system message, got:\n%s", second.SystemMessage)
+	}
+	for _, want := range []string{"Step 1/2: refactor", "Step 2/2: testgen"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "greet_test.go")); err != nil {
+		t.Fatalf("expected step 2 changes to be applied: %v", err)
+	}
+}
+
+func TestExecute_ChainStopsOnFailure(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	refactor, testgen := chainDefinitions()
+	fakeRegistry(t, refactor, testgen)
+	scriptedProvider(t,
+		&payload.WorkspaceChangeProposal{
+			Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n\n// refactored\n"}},
+		},
+		&payload.WorkspaceChangeProposal{
+			Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n\n// overwritten\n"}},
+		},
+	)
+
+	cmd := newCommand(refactor)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"main.go"})
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "stopped at step 2 (testgen)") {
+		t.Fatalf("expected chain to stop at step 2, got %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(root, "main.go"))
+	if string(content) != "package main\n\n// refactored\n" {
+		t.Fatalf("expected step 1 changes to be kept, got %q", content)
+	}
+}
+
+func TestResolveChain_Errors(t *testing.T) {
+	a := &Definition{Name: "a", Then: []string{"b"}}
+	b := &Definition{Name: "b", Then: []string{"a"}}
+	fakeRegistry(t, a, b)
+	if _, err := resolveChain(a); err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	c := &Definition{Name: "c", Then: []string{"missing"}}
+	if _, err := resolveChain(c); err == nil || !strings.Contains(err.Error(), `unknown command "missing"`) {
+		t.Fatalf("expected unknown command error, got %v", err)
+	}
+}
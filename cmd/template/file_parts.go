@@ -0,0 +1,71 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// splitLargeFiles replaces the files of more than maxTokens tokens by
+// their parts, so they are sent whole but clearly segmented rather than
+// as a single block the model handles poorly. Tokens are counted in the
+// encoding enc. A maxTokens of zero or less leaves files untouched.
+func splitLargeFiles(files []payload.FileContent, maxTokens int, enc tokenizer.Encoding) ([]payload.FileContent, error) {
+	if maxTokens <= 0 {
+		return files, nil
+	}
+	var split []payload.FileContent
+	for _, f := range files {
+		n, err := project.CountTokensFor(enc, f.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count tokens of %s: %w", f.Path, err)
+		}
+		if n <= maxTokens {
+			split = append(split, f)
+			continue
+		}
+		parts, err := splitFile(f, maxTokens, enc)
+		if err != nil {
+			return nil, err
+		}
+		split = append(split, parts...)
+	}
+	return split, nil
+}
+
+// splitFile cuts f into parts of whole lines of at most maxTokens tokens
+// each, numbered and labeled with their line range. A line larger than
+// maxTokens makes a part on its own.
+func splitFile(f payload.FileContent, maxTokens int, enc tokenizer.Encoding) ([]payload.FileContent, error) {
+	lines := strings.SplitAfter(f.Content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var parts []payload.FileContent
+	var sb strings.Builder
+	start, tokens := 1, 0
+	for i, line := range lines {
+		n, err := project.CountTokensFor(enc, line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count tokens of %s: %w", f.Path, err)
+		}
+		if sb.Len() > 0 && tokens+n > maxTokens {
+			parts = append(parts, payload.FileContent{Path: f.Path, Content: sb.String(), StartLine: start, EndLine: i})
+			sb.Reset()
+			start, tokens = i+1, 0
+		}
+		sb.WriteString(line)
+		tokens += n
+	}
+	parts = append(parts, payload.FileContent{Path: f.Path, Content: sb.String(), StartLine: start, EndLine: len(lines)})
+
+	for i := range parts {
+		parts[i].Part = i + 1
+		parts[i].Parts = len(parts)
+	}
+	return parts, nil
+}
@@ -0,0 +1,64 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func TestSplitLargeFiles(t *testing.T) {
+	var sb strings.Builder
+	for i := 1; i <= 300; i++ {
+		fmt.Fprintf(&sb, "func f%d() int { return %d }\n", i, i)
+	}
+	large := payload.FileContent{Path: "large.go", Content: sb.String()}
+	small := payload.FileContent{Path: "small.go", Content: "package main\n"}
+
+	files, err := splitLargeFiles([]payload.FileContent{small, large}, 1000, project.DefaultEncoding)
+	if err != nil {
+		t.Fatalf("splitLargeFiles: %v", err)
+	}
+	if files[0] != small {
+		t.Fatalf("expected the small file to be kept whole, got %+v", files[0])
+	}
+	parts := files[1:]
+	if len(parts) < 2 {
+		t.Fatalf("expected the large file to be split, got %d part(s)", len(parts))
+	}
+
+	var joined strings.Builder
+	next := 1
+	for i, p := range parts {
+		if p.Path != "large.go" || p.Part != i+1 || p.Parts != len(parts) || p.StartLine != next {
+			t.Fatalf("unexpected part %d: %+v", i+1, p)
+		}
+		if want := strings.Count(p.Content, "\n"); p.EndLine-p.StartLine+1 != want {
+			t.Fatalf("part %d: lines %d-%d do not match its %d lines", i+1, p.StartLine, p.EndLine, want)
+		}
+		next = p.EndLine + 1
+		joined.WriteString(p.Content)
+	}
+	if next != 301 {
+		t.Fatalf("expected the parts to end at line 300, got %d", next-1)
+	}
+	if joined.String() != large.Content {
+		t.Fatal("expected the parts to hold the whole file")
+	}
+	if want := fmt.Sprintf("large.go (part 1/%d, lines 1-%d)", len(parts), parts[0].EndLine); parts[0].Label() != want {
+		t.Fatalf("Label() = %q, want %q", parts[0].Label(), want)
+	}
+}
+
+func TestSplitLargeFiles_Disabled(t *testing.T) {
+	files := []payload.FileContent{{Path: "a.go", Content: strings.Repeat("x\n", 1000)}}
+	got, err := splitLargeFiles(files, 0, project.DefaultEncoding)
+	if err != nil {
+		t.Fatalf("splitLargeFiles: %v", err)
+	}
+	if len(got) != 1 || got[0].Parts != 0 {
+		t.Fatalf("expected the file to be kept whole, got %+v", got)
+	}
+}
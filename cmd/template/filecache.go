@@ -0,0 +1,64 @@
+package template
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// maxCachedBytes bounds the content kept by a fileCache. Files read once
+// the cache is full are read again every time they are needed.
+const maxCachedBytes = 64 << 20
+
+// fileCache is an fs.FS keeping the content of the files read with
+// fs.ReadFile, so a command run reads every file once: when building the
+// metadata snapshot, then when listing, budgeting and sending them. What is
+// listed is therefore what is sent, even if a file changes in between.
+// Every other operation goes to the underlying filesystem.
+type fileCache struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	size  int
+	files map[string][]byte
+}
+
+func newFileCache(fsys fs.FS) *fileCache {
+	return &fileCache{fsys: fsys, files: make(map[string][]byte)}
+}
+
+func (c *fileCache) Open(name string) (fs.File, error) {
+	return c.fsys.Open(name)
+}
+
+func (c *fileCache) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(c.fsys, name)
+}
+
+func (c *fileCache) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(c.fsys, name)
+}
+
+// ReadFile returns the cached content of name, reading it from the
+// underlying filesystem the first time. The returned slice must not be
+// modified.
+func (c *fileCache) ReadFile(name string) ([]byte, error) {
+	c.mu.Lock()
+	data, ok := c.files[name]
+	c.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size+len(data) <= maxCachedBytes {
+		c.files[name] = data
+		c.size += len(data)
+	}
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package template
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+	wscontext "github.com/vybdev/vyb/workspace/context"
+)
+
+// countingFS counts the files opened, directories aside.
+type countingFS struct {
+	fsys fs.FS
+
+	mu     sync.Mutex
+	opened map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	f, err := c.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && !info.IsDir() {
+		c.mu.Lock()
+		c.opened[name]++
+		c.mu.Unlock()
+	}
+	return f, nil
+}
+
+func (c *countingFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(c.fsys, name)
+}
+
+func TestFileCache_ReadsFilesOnce(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go":     "package main\n\nfunc main() {}\n",
+		"util.go":     "package main\n\nfunc util() {}\n",
+		"README.md":   "# readme\n",
+		"pkg/lib.go":  "package pkg\n",
+		"pkg/doc.txt": "docs\n",
+	})
+	cfg := "provider: openai\nrequest:\n  prioritize_recent: true\n  max_file_tokens: 100000\n  max_request_tokens: 100000\n"
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte(cfg), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n"}},
+	})
+
+	counting := &countingFS{fsys: os.DirFS(root), opened: make(map[string]int)}
+	rootFS := newFileCache(counting)
+	state, err := loadWorkspaceState(root, rootFS)
+	if err != nil {
+		t.Fatalf("loadWorkspaceState: %v", err)
+	}
+	inv := &invocation{
+		def: &Definition{
+			Name:                          "code",
+			ArgInclusionPatterns:          []string{"*"},
+			ModificationInclusionPatterns: []string{"*"},
+		},
+		ec:         &wscontext.ExecutionContext{ProjectRoot: root, WorkingDir: root, TargetDir: root},
+		targets:    []string{"main.go"},
+		includeAll: true,
+	}
+	req, err := prepare(inv, state, rootFS)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if len(req.Request.Files) != 5 {
+		t.Fatalf("expected every file in the request, got %+v", req.Request.Files)
+	}
+	if _, err := req.propose(context.Background()); err != nil {
+		t.Fatalf("propose: %v", err)
+	}
+
+	for name, n := range counting.opened {
+		if n > 1 {
+			t.Errorf("%s opened %d times, want at most once", name, n)
+		}
+	}
+	if counting.opened["main.go"] != 1 {
+		t.Fatalf("expected main.go to be read, got %v", counting.opened)
+	}
+}
@@ -0,0 +1,52 @@
+package template
+
+import (
+	"strings"
+
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// languageGuidance holds the conventions the system prompt asks the LLM to
+// follow when the project is mostly written in a language.
+var languageGuidance = map[string]string{
+	"go":         "Write idiomatic Go formatted with gofmt: return errors instead of panicking, wrap them with %w, and document exported identifiers.",
+	"python":     "Follow PEP 8, use type hints on new functions, and prefer the standard library over new dependencies.",
+	"javascript": "Use modern ECMAScript (const and let, modules, async and await) and keep the existing formatting style.",
+	"typescript": "Keep the code strictly typed: avoid any, prefer interfaces and union types, and keep the existing formatting style.",
+	"java":       "Follow the existing package layout, prefer immutable types, and document public APIs with Javadoc.",
+	"kotlin":     "Prefer immutable values, null-safe types and data classes, following the official Kotlin style.",
+	"rust":       "Write idiomatic Rust formatted with rustfmt: propagate errors with Result and the ? operator rather than unwrap.",
+	"ruby":       "Follow the community Ruby style guide and keep methods small.",
+	"csharp":     "Follow the .NET naming conventions and use async methods for I/O.",
+	"cpp":        "Prefer RAII and standard library containers over manual memory management.",
+}
+
+// promptLanguages is the part of the system prompt template describing the
+// languages of the project.
+type promptLanguages struct {
+	// PrimaryLanguage is the display name of the primary language, empty
+	// when none was detected.
+	PrimaryLanguage string
+	// OtherLanguages lists the display names of the other languages, e.g.
+	// "Python and Shell".
+	OtherLanguages string
+	// LanguageGuidance holds the conventions of the primary language, if
+	// any.
+	LanguageGuidance string
+}
+
+// newPromptLanguages describes the languages detected in meta.
+func newPromptLanguages(meta *project.Metadata) promptLanguages {
+	if len(meta.Languages) == 0 {
+		return promptLanguages{}
+	}
+	var others []string
+	for _, lang := range meta.Languages[1:] {
+		others = append(others, project.LanguageName(lang))
+	}
+	return promptLanguages{
+		PrimaryLanguage:  project.LanguageName(meta.PrimaryLanguage()),
+		OtherLanguages:   strings.Join(others, " and "),
+		LanguageGuidance: languageGuidance[meta.PrimaryLanguage()],
+	}
+}
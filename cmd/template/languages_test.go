@@ -0,0 +1,42 @@
+package template
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestExecute_LanguageGuidance(t *testing.T) {
+	def := &Definition{Name: "code", Prompt: "Implement the TODOs.", ArgInclusionPatterns: []string{"*"}, ModificationInclusionPatterns: []string{"*"}}
+
+	setupWorkspace(t, map[string]string{
+		"main.go":      "package main\n",
+		"util.go":      "package main\n",
+		"tools/gen.py": "print(1)\n",
+		"README.md":    "# readme\n",
+	})
+	calls := scriptedProvider(t, &payload.WorkspaceChangeProposal{})
+	cmd := newCommand(def)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := (*calls)[0].SystemMessage
+	for _, want := range []string{"## Project Languages", "mostly written in Go, along with Python.", languageGuidance["go"]} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("expected %q in the system message, got:\n%s", want, msg)
+		}
+	}
+	if strings.Index(msg, "## Project Languages") > strings.Index(msg, "## Task Description") {
+		t.Fatalf("expected the languages before the task description, got:\n%s", msg)
+	}
+
+	setupWorkspace(t, map[string]string{"README.md": "# readme\n"})
+	calls = scriptedProvider(t, &payload.WorkspaceChangeProposal{})
+	if err := newCommand(def).Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg := (*calls)[0].SystemMessage; strings.Contains(msg, "Project Languages") {
+		t.Fatalf("expected no language section without source files, got:\n%s", msg)
+	}
+}
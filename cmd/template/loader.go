@@ -2,11 +2,15 @@ package template
 
 import (
 	"embed"
+	"fmt"
 	"github.com/vybdev/vyb/config"
-	"gopkg.in/yaml.v3"
+	"github.com/vybdev/vyb/internal/strictyaml"
+	"github.com/vybdev/vyb/logging"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -15,7 +19,8 @@ var embedded embed.FS
 
 // loadConfigs takes an fs.FS instance, reads all top-level *.yml or *.yaml
 // files in its root, unmarshals them into Definition, and returns
-// []*Definition.
+// []*Definition. Definitions that fail to parse are skipped with a warning
+// locating the problem.
 func loadConfigs(rootFS fs.FS) []*Definition {
 	var cmdDefinitions []*Definition
 
@@ -38,14 +43,9 @@ func loadConfigs(rootFS fs.FS) []*Definition {
 				continue
 			}
 
-			cmdDef := &Definition{
-				Model: Model{
-					Family: config.ModelFamilyReasoning,
-					Size:   config.ModelSizeLarge,
-				},
-			}
-			if err := yaml.Unmarshal(data, cmdDef); err != nil {
-				// Handle or log error as needed
+			cmdDef := newDefinition()
+			if _, err := strictyaml.Decode(entry.Name(), data, cmdDef); err != nil {
+				logging.Log.Warnf("skipping command definition: %v", err)
 				continue
 			}
 
@@ -56,6 +56,107 @@ func loadConfigs(rootFS fs.FS) []*Definition {
 	return cmdDefinitions
 }
 
+// newDefinition returns an empty Definition populated with the defaults
+// applied to every command definition.
+func newDefinition() *Definition {
+	return &Definition{
+		Model: Model{
+			Family: config.ModelFamilyReasoning,
+			Size:   config.ModelSizeLarge,
+		},
+	}
+}
+
+// LoadDefinition reads and validates a single command definition from the
+// .vyb file at path. Unknown fields, duplicate keys and values of the wrong
+// type are rejected with their line and column, so typos surface while
+// iterating on a prompt. When the definition has no name, the file name
+// (without extension) is used.
+func LoadDefinition(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command definition: %w", err)
+	}
+	def := newDefinition()
+	if _, err := strictyaml.Decode(path, data, def); err != nil {
+		return nil, fmt.Errorf("failed to parse command definition: %w", err)
+	}
+	def.Source = SourceFile
+	if def.Name == "" {
+		def.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := def.validate(); err != nil {
+		return nil, fmt.Errorf("invalid command definition %s: %w", path, err)
+	}
+	return def, nil
+}
+
+// validate checks that the definition can be executed.
+func (d *Definition) validate() error {
+	if strings.TrimSpace(d.Prompt) == "" {
+		return fmt.Errorf("prompt must not be empty")
+	}
+	switch d.Model.Family {
+	case config.ModelFamilyGPT, config.ModelFamilyReasoning:
+	default:
+		return fmt.Errorf("unknown model family %q", d.Model.Family)
+	}
+	switch d.Model.Size {
+	case config.ModelSizeLarge, config.ModelSizeSmall:
+	default:
+		return fmt.Errorf("unknown model size %q", d.Model.Size)
+	}
+	for _, name := range d.Then {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("then must not contain empty command names")
+		}
+	}
+	return nil
+}
+
+// Source identifies where a command definition was loaded from.
+type Source string
+
+const (
+	// SourceEmbedded marks the built-in definitions bundled with vyb.
+	SourceEmbedded Source = "embedded"
+	// SourceGlobal marks user-wide definitions under $VYB_HOME/cmd.
+	SourceGlobal Source = "global"
+	// SourceFile marks an ad-hoc definition passed to `vyb run`.
+	SourceFile Source = "file"
+)
+
+// Collision records a command name provided by more than one definition.
+type Collision struct {
+	Name string
+	// Winner is the source of the definition in use.
+	Winner Source
+	// Shadowed lists the sources of the overridden definitions, in the
+	// order they were loaded.
+	Shadowed []Source
+}
+
+func (c Collision) String() string {
+	shadowed := make([]string, len(c.Shadowed))
+	for i, s := range c.Shadowed {
+		shadowed[i] = string(s)
+	}
+	return fmt.Sprintf("command %q from %s overrides the %s definition", c.Name, c.Winner, strings.Join(shadowed, ", "))
+}
+
+// ShadowsBuiltin reports whether an embedded definition was overridden.
+func (c Collision) ShadowsBuiltin() bool {
+	return c.Winner != SourceEmbedded && slices.Contains(c.Shadowed, SourceEmbedded)
+}
+
+// withSource sets the Source of every definition in defs.
+func withSource(defs []*Definition, source Source) []*Definition {
+	for _, d := range defs {
+		d.Source = source
+	}
+	return defs
+}
+
 // loadEmbeddedConfigs reads configuration files from the embedded directory.
 func loadEmbeddedConfigs() []*Definition {
 	subFS, err := fs.Sub(embedded, "embedded")
@@ -63,7 +164,7 @@ func loadEmbeddedConfigs() []*Definition {
 		// Handle or log error as needed
 		return nil
 	}
-	return loadConfigs(subFS)
+	return withSource(loadConfigs(subFS), SourceEmbedded)
 }
 
 // loadGlobalConfigs reads configuration files from the directory specified
@@ -77,37 +178,41 @@ func loadGlobalConfigs() []*Definition {
 	if _, err := os.Stat(cmdPath); err != nil {
 		return nil
 	}
-	return loadConfigs(os.DirFS(cmdPath))
+	return withSource(loadConfigs(os.DirFS(cmdPath)), SourceGlobal)
 }
 
-// toMap converts a slice of *Definition into a map where the key is the Name field
-// and the value is the corresponding Definition struct.
-func toMap(cmdDefinitions []*Definition) map[string]*Definition {
-	result := make(map[string]*Definition)
-	for _, cmdDef := range cmdDefinitions {
-		if cmdDef != nil && cmdDef.Name != "" {
-			result[cmdDef.Name] = cmdDef
+// load combines the results of loadEmbeddedConfigs and loadGlobalConfigs
+// in order of precedence: embedded < global. Definitions are returned
+// sorted by name, along with every name collision found across (or within)
+// sources so overrides never go unnoticed.
+func load() ([]*Definition, []Collision) {
+	combined := make(map[string]*Definition)
+	shadowed := make(map[string][]Source)
+	for _, defs := range [][]*Definition{loadEmbeddedConfigs(), loadGlobalConfigs()} {
+		for _, def := range defs {
+			if def == nil || def.Name == "" {
+				continue
+			}
+			if prev, ok := combined[def.Name]; ok {
+				shadowed[def.Name] = append(shadowed[def.Name], prev.Source)
+			}
+			combined[def.Name] = def
 		}
 	}
-	return result
-}
-
-// load combines the results of loadEmbeddedConfigs, loadGlobalConfigs,
-// and loadLocalConfigs in order of precedence: embedded < global < local.
-func load() []*Definition {
-	// Combine results using precedence
-	combinedMap := toMap(loadEmbeddedConfigs())
 
-	// Override with global configs
-	for name, cmdDef := range toMap(loadGlobalConfigs()) {
-		combinedMap[name] = cmdDef
+	names := make([]string, 0, len(combined))
+	for name := range combined {
+		names = append(names, name)
 	}
-
-	// Convert the final map back to a slice
-	finalConfigs := make([]*Definition, 0, len(combinedMap))
-	for _, cmdDef := range combinedMap {
-		finalConfigs = append(finalConfigs, cmdDef)
+	sort.Strings(names)
+
+	finalConfigs := make([]*Definition, 0, len(names))
+	var collisions []Collision
+	for _, name := range names {
+		finalConfigs = append(finalConfigs, combined[name])
+		if sources, ok := shadowed[name]; ok {
+			collisions = append(collisions, Collision{Name: name, Winner: combined[name].Source, Shadowed: sources})
+		}
 	}
-
-	return finalConfigs
+	return finalConfigs, collisions
 }
@@ -1,7 +1,15 @@
 package template
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vybdev/vyb/config"
 )
 
 func Test_loadEmbeddedConfigs(t *testing.T) {
@@ -11,3 +19,124 @@ func Test_loadEmbeddedConfigs(t *testing.T) {
 		t.Errorf("loadEmbeddedConfigs() = %v, expected at least one", len(got))
 	}
 }
+
+func TestLoadDefinition(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			content: "prompt: do it\nmodel:\n  size: small\n",
+		},
+		{
+			name:    "missing prompt",
+			content: "name: x\n",
+			wantErr: "prompt must not be empty",
+		},
+		{
+			name:    "unknown field",
+			content: "prompt: do it\nprompts: typo\n",
+			wantErr: "field prompts not found",
+		},
+		{
+			name:    "unknown model family",
+			content: "prompt: do it\nmodel:\n  family: llama\n",
+			wantErr: "unknown model family",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "adhoc.vyb")
+			if err := os.WriteFile(path, []byte(c.content), 0o644); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			def, err := LoadDefinition(path)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if def.Name != "adhoc" {
+				t.Errorf("expected name derived from file, got %q", def.Name)
+			}
+			if def.Model.Family != config.ModelFamilyReasoning || def.Model.Size != config.ModelSizeSmall {
+				t.Errorf("unexpected model %+v", def.Model)
+			}
+		})
+	}
+}
+
+func TestLoadDefinition_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"duplicate_key.vyb":   `duplicate_key.vyb:2:1: duplicate key "prompt", already defined at line 1`,
+		"string_for_bool.vyb": `string_for_bool.vyb:2:14: allowDelete must be a boolean, got the string "yes" (use true or false)`,
+		"scalar_for_list.vyb": `scalar_for_list.vyb:2:7: then must be a list, got the string "code"`,
+		"int_for_string.vyb":  `int_for_string.vyb:3:9: model.size must be a string, got the integer 1`,
+	}
+	for name, want := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadDefinition(filepath.Join("testdata", "invalid", name))
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected error containing %q, got %v", want, err)
+			}
+		})
+	}
+}
+
+func Test_loadConfigs_SkipsInvalid(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ok.vyb":  {Data: []byte("name: ok\nprompt: do it\n")},
+		"bad.vyb": {Data: []byte("name: bad\nprompt: do it\nprompt: again\n")},
+	}
+	defs := loadConfigs(fsys)
+	if len(defs) != 1 || defs[0].Name != "ok" {
+		t.Fatalf("expected only the valid definition, got %+v", defs)
+	}
+}
+
+func Test_load_ReportsShadowedBuiltins(t *testing.T) {
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "cmd"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "cmd", "code.vyb"), []byte("name: code\nprompt: my own code command\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	t.Setenv("VYB_HOME", home)
+
+	defs, collisions := load()
+
+	want := []Collision{{Name: "code", Winner: SourceGlobal, Shadowed: []Source{SourceEmbedded}}}
+	if diff := cmp.Diff(want, collisions); diff != "" {
+		t.Fatalf("collisions (-want +got):\n%s", diff)
+	}
+	for _, d := range defs {
+		if d.Name == "code" && (d.Source != SourceGlobal || d.Prompt != "my own code command") {
+			t.Fatalf("expected the global definition to win, got %+v", d)
+		}
+	}
+
+	cmd := newCommandsCommand(defs, collisions)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var codeLine string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "code ") {
+			codeLine = line
+		}
+	}
+	if !strings.Contains(codeLine, "global") || !strings.Contains(codeLine, "shadows built-in") {
+		t.Fatalf("expected code to be listed as a global definition shadowing a built-in:\n%s", out.String())
+	}
+}
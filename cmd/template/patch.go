@@ -0,0 +1,48 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// Values accepted by the --output flag.
+const (
+	outputFiles = "files"
+	outputPatch = "patch"
+)
+
+// renderPatch renders the changes proposals would apply under absRoot as a
+// git-style patch, applicable with `git apply` or `patch -p1` from the
+// project root. Proposals that leave a file unchanged are omitted.
+func renderPatch(absRoot string, proposals []payload.FileChangeProposal) (string, error) {
+	rootFS := os.DirFS(absRoot)
+	diffs, err := proposalDiffs(rootFS, proposals)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, prop := range proposals {
+		if diffs[i] == "" {
+			continue
+		}
+		_, statErr := fs.Stat(rootFS, prop.FileName)
+		if prop.Delete && errors.Is(statErr, fs.ErrNotExist) {
+			// Nothing to delete.
+			continue
+		}
+		fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", prop.FileName, prop.FileName)
+		switch {
+		case prop.Delete:
+			sb.WriteString("deleted file mode 100644\n")
+		case errors.Is(statErr, fs.ErrNotExist):
+			sb.WriteString("new file mode 100644\n")
+		}
+		sb.WriteString(diffs[i])
+	}
+	return sb.String(), nil
+}
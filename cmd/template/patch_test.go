@@ -0,0 +1,117 @@
+package template
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestRenderPatch_Applies(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not available")
+	}
+	root := t.TempDir()
+	fixture := map[string]string{
+		"main.go":     "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n",
+		"old.go":      "package main\n\nvar old = 1\n",
+		"pkg/lib.go":  "package pkg\n",
+		"notrail.txt": "no trailing newline",
+	}
+	for name, content := range fixture {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	proposals := []payload.FileChangeProposal{
+		{FileName: "main.go", Content: "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n"},
+		{FileName: "pkg/new.go", Content: "package pkg\n\nconst New = true\n"},
+		{FileName: "old.go", Delete: true},
+		{FileName: "pkg/lib.go", Content: "package pkg\n"}, // unchanged
+		{FileName: "notrail.txt", Content: "still no trailing newline"},
+	}
+
+	patch, err := renderPatch(root, proposals)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(patch, "pkg/lib.go") {
+		t.Fatalf("unchanged files must be omitted:\n%s", patch)
+	}
+
+	cmd := exec.Command(git, "apply", "-")
+	cmd.Dir = root
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply failed: %v\n%s\npatch:\n%s", err, out, patch)
+	}
+
+	want := map[string]string{
+		"main.go":     proposals[0].Content,
+		"pkg/new.go":  proposals[1].Content,
+		"pkg/lib.go":  fixture["pkg/lib.go"],
+		"notrail.txt": proposals[4].Content,
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("%s = %q (%v), want %q", name, data, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "old.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected old.go to be deleted by the patch, stat error: %v", err)
+	}
+}
+
+func TestExecute_OutputPatch(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Summary: "Add a function",
+		Proposals: []payload.FileChangeProposal{
+			{FileName: "main.go", Content: "package main\n\nfunc f() {}\n"},
+		},
+	})
+
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		ModificationInclusionPatterns: []string{"*.go"},
+	}
+	cmd := newCommand(def)
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--output", "patch"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(stdout.String(), "diff --git a/main.go b/main.go\n") || !strings.Contains(stdout.String(), "+func f() {}\n") {
+		t.Fatalf("stdout must only hold the patch:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "main.go") {
+		t.Fatalf("progress output should go to stderr:\n%s", stderr.String())
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "main.go")); string(data) != "package main\n" {
+		t.Fatalf("--output patch must not modify files, got %q", data)
+	}
+
+	cmd = newCommand(def)
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--output", "zip"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unsupported output") {
+		t.Fatalf("expected an unsupported output error, got %v", err)
+	}
+}
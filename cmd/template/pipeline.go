@@ -0,0 +1,308 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/cbroglie/mustache"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/backup"
+	wscontext "github.com/vybdev/vyb/workspace/context"
+	"github.com/vybdev/vyb/workspace/matcher"
+	"github.com/vybdev/vyb/workspace/project"
+	"github.com/vybdev/vyb/workspace/selector"
+)
+
+// errHierarchyChanged is returned when modules were added or removed since
+// the metadata was last stored.
+var errHierarchyChanged = errors.New("module hierarchy has changed. Run 'vyb update' to refresh")
+
+// workspaceState is the metadata view an invocation operates on: the stored
+// metadata (with annotations) patched with a fresh snapshot of the
+// filesystem, along with the result of that patch. It must be treated as
+// read-only so it can be shared across invocations.
+type workspaceState struct {
+	Meta  *project.Metadata
+	Patch *project.PatchResult
+}
+
+// loadWorkspaceState merges the stored metadata of the project at absRoot
+// with a fresh snapshot of its files, read from rootFS. This guarantees we
+// operate with up-to-date file information while keeping previously
+// generated annotations intact.
+func loadWorkspaceState(absRoot string, rootFS fs.FS) (*workspaceState, error) {
+	storedMeta, err := project.LoadMetadata(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	freshMeta, err := project.BuildMetadataFS(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	patch := storedMeta.Patch(freshMeta)
+	return &workspaceState{Meta: storedMeta, Patch: patch}, nil
+}
+
+// invocation describes a single execution of a command definition.
+type invocation struct {
+	def *Definition
+	ec  *wscontext.ExecutionContext
+	// targets are the files passed as arguments (if any), relative to the
+	// project root.
+	targets    []string
+	includeAll bool
+	// depth, when set, includes the files of the descendant modules up to
+	// that many levels below the target module, regardless of includeAll.
+	depth  *int
+	recent bool
+	// provider, when set, replaces the configured LLM provider.
+	provider string
+	// previous lists the applied steps of the command chain this
+	// invocation belongs to, if any.
+	previous []chainStep
+	// timings, when set, is notified of the time spent in every stage.
+	timings stageReporter
+	// usage, when set, adds up the token usage of the LLM calls.
+	usage *payload.UsageStats
+}
+
+// origin describes the invocation in the backup set taken before its
+// proposal is applied.
+func (inv *invocation) origin() backup.Origin {
+	instructions := inv.def.Prompt
+	if len(inv.targets) > 0 && inv.def.TargetSpecificPrompt != "" {
+		instructions += "\n\n" + inv.def.TargetSpecificPrompt
+	}
+	return backup.Origin{Command: inv.def.Name, Instructions: instructions}
+}
+
+// isTarget reports whether file, relative to the project root, is a target
+// of the invocation: one of its target files or, when the command targets
+// a directory, a file directly inside it.
+func (inv *invocation) isTarget(file string) bool {
+	if inv.ec.DirTarget {
+		return path.Dir(file) == inv.ec.Rel(inv.ec.TargetDir)
+	}
+	return slices.Contains(inv.targets, file)
+}
+
+// preparedRequest holds everything needed to ask the LLM for a proposal.
+type preparedRequest struct {
+	inv    *invocation
+	cfg    *config.Config
+	rootFS fs.FS
+
+	// Files lists the files included in the request, and DroppedFiles
+	// those left out by the file token budget. SummarizedFiles lists the
+	// files replaced by the internal context of their module to fit the
+	// request token budget.
+	Files           []string
+	DroppedFiles    []string
+	SummarizedFiles []string
+	// ForcedTargets lists the targets matching the request exclusion
+	// patterns of the command, included anyway.
+	ForcedTargets []string
+	// StaleModules lists the modules changed since the last `vyb update`.
+	StaleModules map[string]project.ModuleChange
+
+	Request       *payload.WorkspaceChangeRequest
+	SystemMessage string
+}
+
+// prepare selects the files of an invocation and assembles the request
+// payload and system message sent to the LLM. Files are read from rootFS,
+// rooted at the project root.
+func prepare(inv *invocation, state *workspaceState, rootFS fs.FS) (*preparedRequest, error) {
+	def := inv.def
+	absRoot := inv.ec.ProjectRoot
+
+	cfg, err := config.Load(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	cfg = config.Overrides{Provider: inv.provider}.Apply(cfg)
+
+	stopSelect := timeStage(inv.timings, stageSelect)
+	for _, target := range inv.targets {
+		if matcher.IsExcluded(rootFS, target, systemExclusionPatterns) {
+			return nil, fmt.Errorf("target %s is never sent to the LLM: vyb excludes version control files, ignore files and its own .vyb folder", target)
+		}
+		if !matcher.IsIncluded(rootFS, target, append(systemExclusionPatterns, def.ArgExclusionPatterns...), def.ArgInclusionPatterns) {
+			return nil, fmt.Errorf("command \"%s\" does not support given target %s", def.Name, target)
+		}
+	}
+
+	selectFiles := selector.Select
+	if cfg.Request.CacheSelection {
+		selectFiles = selector.NewCache(absRoot).Select
+	}
+	files, err := selectFiles(rootFS, inv.ec, append(systemExclusionPatterns, def.ArgExclusionPatterns...), def.ArgInclusionPatterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTargetsSelected(rootFS, inv.targets, files); err != nil {
+		return nil, err
+	}
+	files, forced := withoutRequestExclusions(rootFS, def, files, inv.targets)
+
+	if len(state.Patch.AddedModules) > 0 || len(state.Patch.RemovedModules) > 0 {
+		return nil, errHierarchyChanged
+	}
+	meta := state.Meta
+
+	// ------------------------------------------------------------
+	// Unless --all is provided, filter out files that belong to
+	// descendant modules of the target module (i.e. keep only files
+	// whose module == targetModule), or to those deeper than --depth.
+	// The targets themselves are always kept, even when they live in a
+	// descendant module of their common ancestor. Only commands with
+	// targets, files or a directory, scope the root module to the target
+	// directory.
+	// ------------------------------------------------------------
+	depth := 0
+	if inv.depth != nil {
+		depth = *inv.depth
+	}
+	if (!inv.includeAll || inv.depth != nil) && meta.Modules != nil {
+		scopeRoot := len(inv.targets) > 0 || inv.ec.DirTarget
+		files = withTargets(filterToModuleDepth(meta.Modules, inv.ec.Rel(inv.ec.TargetDir), files, depth, scopeRoot), inv.targets)
+	}
+
+	if inv.recent || cfg.Request.PrioritizeRecent {
+		files = sortByRecency(rootFS, absRoot, files)
+	}
+	files = withChainedFiles(rootFS, def, files, inv.previous)
+	var dropped []string
+	enc := project.EncodingFor(cfg)
+	if cfg.Request.MaxFileTokens > 0 {
+		files, dropped, err = applyFileBudget(rootFS, files, inv.targets, cfg.Request.MaxFileTokens, enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stopSelect()
+
+	defer timeStage(inv.timings, stageBuild)()
+	budget := requestBudget{Tokens: cfg.Request.TokenBudget(def.Model.Size), Targets: inv.targets, Encoding: enc}
+	userRequest, err := buildWorkspaceChangeRequest(rootFS, meta, inv.ec, files, depth, budget)
+	if err != nil {
+		return nil, err
+	}
+	userRequest.TargetFiles = inv.targets
+	files, summarized := splitSummarized(files, userRequest.Files)
+	if userRequest.Files, err = splitLargeFiles(userRequest.Files, cfg.Request.MaxFilePartTokens, enc); err != nil {
+		return nil, err
+	}
+
+	promptGeneralInstructions, _ := embedded.ReadFile("embedded/prompts/instructions.md.mustache")
+	tmpl, err := mustache.ParseString(string(promptGeneralInstructions))
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := tmpl.Render(def, newPromptLanguages(meta))
+	if err != nil {
+		return nil, err
+	}
+
+	return &preparedRequest{
+		inv:             inv,
+		cfg:             cfg,
+		rootFS:          rootFS,
+		Files:           files,
+		DroppedFiles:    dropped,
+		SummarizedFiles: summarized,
+		ForcedTargets:   forced,
+		StaleModules:    state.Patch.ChangedModules,
+		Request:         userRequest,
+		SystemMessage:   applyPromptAffixes(cfg, withPreviousSteps(rendered, inv.previous)),
+	}, nil
+}
+
+// checkTargetsSelected returns an error explaining why a target is missing
+// from the selected files: the ignore files and the dependency directories
+// hide files from vyb altogether, so a target they exclude is not silently
+// put back in the request.
+func checkTargetsSelected(rootFS fs.FS, targets, selected []string) error {
+	for _, t := range targets {
+		if slices.Contains(selected, t) {
+			continue
+		}
+		if matcher.IsExcluded(rootFS, t, selector.DependencyExclusionPatterns) {
+			return fmt.Errorf("target %s is in a dependency directory, which vyb excludes: re-include it in a .vybignore file (e.g. \"!vendor/\") to change it", t)
+		}
+		return fmt.Errorf("target %s is excluded by a .gitignore or .vybignore file: remove it from there to change it", t)
+	}
+	return nil
+}
+
+// withoutRequestExclusions drops the files matching the request exclusion
+// patterns of def. The targets are kept, since the LLM cannot change a file
+// it does not see, and returned as forced.
+func withoutRequestExclusions(rootFS fs.FS, def *Definition, files, targets []string) (kept, forced []string) {
+	if len(def.RequestExclusionPatterns) == 0 {
+		return files, nil
+	}
+	for _, f := range files {
+		if matcher.IsExcluded(rootFS, f, def.RequestExclusionPatterns) {
+			if !slices.Contains(targets, f) {
+				continue
+			}
+			forced = append(forced, f)
+		}
+		kept = append(kept, f)
+	}
+	return kept, forced
+}
+
+// withTargets returns files followed by the targets it does not list yet.
+func withTargets(files, targets []string) []string {
+	for _, t := range targets {
+		if !slices.Contains(files, t) {
+			files = append(files, t)
+		}
+	}
+	return files
+}
+
+// splitSummarized separates the selected files whose content is part of
+// the request from those left out by the request token budget.
+func splitSummarized(selected []string, included []payload.FileContent) (kept, summarized []string) {
+	inRequest := make(map[string]bool, len(included))
+	for _, f := range included {
+		inRequest[f.Path] = true
+		kept = append(kept, f.Path)
+	}
+	for _, f := range selected {
+		if !inRequest[f] {
+			summarized = append(summarized, f)
+		}
+	}
+	return kept, summarized
+}
+
+// propose sends the prepared request to the LLM and validates every file
+// in the returned proposal against the command definition. Cancelling ctx
+// aborts the request.
+func (p *preparedRequest) propose(ctx context.Context) (*changePlan, error) {
+	def := p.inv.def
+	stopProvider := timeStage(p.inv.timings, stageProvider)
+	proposal, usage, err := getWorkspaceChangeProposals(ctx, p.cfg, def.Model.Family, def.Model.Size, p.SystemMessage, p.Request)
+	stopProvider()
+	if p.inv.usage != nil {
+		p.inv.usage.Add(usage)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer timeStage(p.inv.timings, stageValidate)()
+	vctx := ValidationContext{RootFS: p.rootFS, Exec: p.inv.ec, Command: def}
+	validations := validateProposals(proposalValidators(p.cfg.Validation), vctx, p.Request, proposal)
+	return newChangePlan(p.rootFS, project.EncodingFor(p.cfg), p.SystemMessage, p.Request, proposal, validations)
+}
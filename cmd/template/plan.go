@@ -0,0 +1,190 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/internal/diff"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// proposalValidation records whether a single proposed file change is
+// allowed by the proposal validators and, if not, which rules rejected it
+// and why.
+type proposalValidation struct {
+	FileName string `json:"file_name"`
+	Allowed  bool   `json:"allowed"`
+	Rule     string `json:"rule,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// tokenUsage holds the token estimates shown in a change plan.
+type tokenUsage struct {
+	// Request is the estimated number of tokens sent to the LLM.
+	Request int `json:"request"`
+	// Response is the estimated number of tokens in the returned proposal.
+	Response int `json:"response"`
+}
+
+// changePlan is the review surface rendered by `--plan`: everything the user
+// needs to decide whether a proposal should be applied.
+type changePlan struct {
+	Proposal    *payload.WorkspaceChangeProposal
+	Diffs       []string
+	Validations []proposalValidation
+	Usage       tokenUsage
+}
+
+// confirm asks the user a yes/no question.
+// NOTE: confirm is a var (not a func) to allow test overrides.
+var confirm = func(message string) (bool, error) {
+	return ui.Confirm(message, "--yes")
+}
+
+// newChangePlan builds a changePlan for proposal, reading the current version
+// of every touched file from rootFS to compute its diff. Token usage is
+// estimated in the encoding enc.
+func newChangePlan(rootFS fs.FS, enc tokenizer.Encoding, systemMessage string, request *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal, validations []proposalValidation) (*changePlan, error) {
+	plan := &changePlan{
+		Proposal:    proposal,
+		Validations: validations,
+		Usage:       estimateUsage(enc, systemMessage, request, proposal),
+	}
+	diffs, err := proposalDiffs(rootFS, proposal.Proposals)
+	if err != nil {
+		return nil, err
+	}
+	plan.Diffs = diffs
+	return plan, nil
+}
+
+// proposalDiffs returns the unified diff of every proposal against the
+// current version of the file in rootFS. Files that do not exist yet are
+// diffed against /dev/null.
+func proposalDiffs(rootFS fs.FS, proposals []payload.FileChangeProposal) ([]string, error) {
+	var diffs []string
+	for _, prop := range proposals {
+		current, err := fs.ReadFile(rootFS, prop.FileName)
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read file %s: %w", prop.FileName, err)
+		}
+		newContent := prop.Content
+		if prop.Delete {
+			newContent = ""
+		}
+		diffs = append(diffs, diff.Unified(prop.FileName, string(current), newContent, !exists, prop.Delete))
+	}
+	return diffs, nil
+}
+
+// renderProposalDiff renders the changes proposals would apply under absRoot
+// as a single unified diff. Every file is introduced by a "new file",
+// "deleted file" or "modified file" line so deletions stand out.
+func renderProposalDiff(absRoot string, proposals []payload.FileChangeProposal) (string, error) {
+	rootFS := os.DirFS(absRoot)
+	diffs, err := proposalDiffs(rootFS, proposals)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, prop := range proposals {
+		_, statErr := fs.Stat(rootFS, prop.FileName)
+		switch {
+		case prop.Delete:
+			fmt.Fprintf(&sb, "deleted file %s\n", prop.FileName)
+		case errors.Is(statErr, fs.ErrNotExist):
+			fmt.Fprintf(&sb, "new file %s\n", prop.FileName)
+		default:
+			fmt.Fprintf(&sb, "modified file %s\n", prop.FileName)
+		}
+		sb.WriteString(diffs[i])
+	}
+	return sb.String(), nil
+}
+
+// estimateUsage counts the tokens of the request and response payloads. The
+// counts are estimates: providers add their own framing around the messages,
+// and enc only approximates the tokenizer of some models.
+func estimateUsage(enc tokenizer.Encoding, systemMessage string, request *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) tokenUsage {
+	count := func(s string) int {
+		n, _ := project.CountTokensFor(enc, s)
+		return n
+	}
+	var usage tokenUsage
+	usage.Request += count(systemMessage)
+	if request != nil {
+		usage.Request += count(request.TargetModuleContext)
+		for _, mc := range request.ParentModuleContexts {
+			usage.Request += count(mc.Content)
+		}
+		for _, mc := range request.SubModuleContexts {
+			usage.Request += count(mc.Content)
+		}
+		for _, mc := range request.SummarizedModuleContexts {
+			usage.Request += count(mc.Content)
+		}
+		for _, f := range request.Files {
+			usage.Request += count(f.Content)
+		}
+	}
+	if proposal != nil {
+		usage.Response += count(proposal.Summary) + count(proposal.Description)
+		for _, p := range proposal.Proposals {
+			usage.Response += count(p.Content)
+		}
+	}
+	return usage
+}
+
+// render writes a human-readable version of the plan to p.
+func (plan *changePlan) render(p *ui.Printer) {
+	p.Heading("Summary")
+	p.Printf("%s\n\n", plan.Proposal.Summary)
+	p.Heading("Description")
+	p.Printf("%s\n\n", plan.Proposal.Description)
+
+	p.Heading("Diff")
+	for _, d := range plan.Diffs {
+		p.Diff(d)
+	}
+	p.Printf("\n")
+
+	p.Heading("Validation")
+	for _, v := range plan.Validations {
+		if v.Allowed {
+			p.Success("  [ok]      %s", v.FileName)
+		} else {
+			p.Error("  [blocked] %s (%s)", v.FileName, v.Reason)
+		}
+	}
+	p.Printf("\n")
+
+	p.Heading("Token usage (estimated)")
+	p.Printf("  request:  %d\n", plan.Usage.Request)
+	p.Printf("  response: %d\n", plan.Usage.Response)
+	if project.HeuristicTokenCounts() {
+		p.Warn("%s.", project.HeuristicTokensNote)
+	}
+}
+
+// validationError returns an error listing the proposed files the command
+// is not allowed to modify, or nil when every change is allowed.
+func (plan *changePlan) validationError() error {
+	var invalidFiles []string
+	for _, v := range plan.Validations {
+		if !v.Allowed {
+			invalidFiles = append(invalidFiles, fmt.Sprintf("%s (%s)", v.FileName, v.Reason))
+		}
+	}
+	if len(invalidFiles) > 0 {
+		return fmt.Errorf("change proposal contains modifications to unallowed files: %v", invalidFiles)
+	}
+	return nil
+}
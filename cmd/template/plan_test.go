@@ -0,0 +1,345 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+	"gopkg.in/yaml.v3"
+)
+
+// setupWorkspace creates a vyb project with the given files under a temp
+// directory, writes its metadata and changes the working directory to it.
+func setupWorkspace(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	meta, err := project.BuildMetadataFS(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("failed to build metadata: %v", err)
+	}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("failed to marshal metadata: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0o755); err != nil {
+		t.Fatalf("mkdir .vyb: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "metadata.yaml"), data, 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+	t.Chdir(root)
+	return root
+}
+
+// fakeProvider replaces the LLM entry-point with one returning proposal.
+// The returned pointer holds the last system message sent to the provider.
+func fakeProvider(t *testing.T, proposal *payload.WorkspaceChangeProposal) *string {
+	t.Helper()
+	var sysMsg string
+	old := getWorkspaceChangeProposals
+	getWorkspaceChangeProposals = func(_ context.Context, _ *config.Config, _ config.ModelFamily, _ config.ModelSize, systemMessage string, _ *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		sysMsg = systemMessage
+		return proposal, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getWorkspaceChangeProposals = old })
+	return &sysMsg
+}
+
+// fakeConfirm replaces the interactive confirmation with a fixed answer and
+// records whether it was asked.
+func fakeConfirm(t *testing.T, answer bool) *bool {
+	t.Helper()
+	asked := false
+	old := confirm
+	confirm = func(string) (bool, error) {
+		asked = true
+		return answer, nil
+	}
+	t.Cleanup(func() { confirm = old })
+	return &asked
+}
+
+func TestExecute_Plan(t *testing.T) {
+	for _, answer := range []bool{false, true} {
+		t.Run(map[bool]string{false: "declined", true: "confirmed"}[answer], func(t *testing.T) {
+			root := setupWorkspace(t, map[string]string{
+				"main.go": "package main\n\nfunc main() {}\n",
+			})
+			fakeProvider(t, &payload.WorkspaceChangeProposal{
+				Summary:     "feat: add greeting",
+				Description: "Prints a greeting on start-up.",
+				Proposals: []payload.FileChangeProposal{
+					{FileName: "main.go", Content: "package main\n\nfunc main() { println(\"hi\") }\n"},
+					{FileName: "greet.go", Content: "package main\n"},
+				},
+			})
+			asked := fakeConfirm(t, answer)
+
+			def := &Definition{
+				Name:                          "code",
+				ArgInclusionPatterns:          []string{"*"},
+				ModificationInclusionPatterns: []string{"*"},
+			}
+			cmd := newCommand(def)
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+			cmd.SetArgs([]string{"--plan"})
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := out.String()
+			for _, want := range []string{
+				"## Summary", "feat: add greeting",
+				"## Description", "Prints a greeting on start-up.",
+				"## Diff", "-func main() {}", "+func main() { println(\"hi\") }", "--- /dev/null\n+++ b/greet.go",
+				"## Validation", "[ok]      main.go", "[ok]      greet.go",
+				"## Token usage (estimated)",
+			} {
+				if !strings.Contains(got, want) {
+					t.Errorf("plan output missing %q:\n%s", want, got)
+				}
+			}
+			if !*asked {
+				t.Fatalf("expected confirmation prompt")
+			}
+
+			content, _ := os.ReadFile(filepath.Join(root, "main.go"))
+			applied := strings.Contains(string(content), "println")
+			if applied != answer {
+				t.Fatalf("changes applied = %v, want %v", applied, answer)
+			}
+			if _, err := os.Stat(filepath.Join(root, "greet.go")); (err == nil) != answer {
+				t.Fatalf("greet.go created = %v, want %v", err == nil, answer)
+			}
+		})
+	}
+}
+
+// pipeStdin replaces os.Stdin with the read end of a pipe, as when vyb runs
+// in CI or with piped input.
+func pipeStdin(t *testing.T) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		_ = r.Close()
+		_ = w.Close()
+	})
+}
+
+func TestExecute_PlanNotInteractive(t *testing.T) {
+	for _, yes := range []bool{false, true} {
+		t.Run(map[bool]string{false: "fails", true: "yes"}[yes], func(t *testing.T) {
+			root := setupWorkspace(t, map[string]string{
+				"main.go": "package main\n",
+			})
+			fakeProvider(t, &payload.WorkspaceChangeProposal{
+				Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n\n// changed\n"}},
+			})
+			pipeStdin(t)
+
+			def := &Definition{
+				Name:                          "code",
+				ArgInclusionPatterns:          []string{"*"},
+				ModificationInclusionPatterns: []string{"*"},
+			}
+			cmd := newCommand(def)
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetErr(&bytes.Buffer{})
+			args := []string{"--plan"}
+			if yes {
+				args = append(args, "--yes")
+			}
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+
+			content, _ := os.ReadFile(filepath.Join(root, "main.go"))
+			applied := strings.Contains(string(content), "changed")
+			if yes {
+				if err != nil || !applied {
+					t.Fatalf("expected --yes to apply the plan, got err=%v applied=%v", err, applied)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "interactive input required; pass --yes") {
+				t.Fatalf("expected non-interactive error, got %v", err)
+			}
+			if applied {
+				t.Fatalf("expected no changes to be applied")
+			}
+		})
+	}
+}
+
+func TestExecute_PlanBlockedFiles(t *testing.T) {
+	setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Proposals: []payload.FileChangeProposal{
+			{FileName: "README.md", Content: "# readme\n"},
+		},
+	})
+	asked := fakeConfirm(t, true)
+
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		ModificationInclusionPatterns: []string{"*.go"},
+	}
+	cmd := newCommand(def)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"--plan"})
+	if err := cmd.Execute(); err == nil {
+		t.Fatalf("expected error for blocked file")
+	}
+	if !strings.Contains(out.String(), "[blocked] README.md") {
+		t.Fatalf("plan output missing blocked file:\n%s", out.String())
+	}
+	if *asked {
+		t.Fatalf("confirmation must not be requested when validation fails")
+	}
+}
+
+func TestExecute_AllowDelete(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		t.Run(map[bool]string{false: "rejected", true: "permitted"}[allow], func(t *testing.T) {
+			root := setupWorkspace(t, map[string]string{
+				"main.go": "package main\n",
+				"old.go":  "package main\n",
+			})
+			fakeProvider(t, &payload.WorkspaceChangeProposal{
+				Proposals: []payload.FileChangeProposal{
+					{FileName: "old.go", Delete: true},
+				},
+			})
+
+			def := &Definition{
+				Name:                          "code",
+				ArgInclusionPatterns:          []string{"*"},
+				ModificationInclusionPatterns: []string{"*.go"},
+				AllowDelete:                   allow,
+			}
+			cmd := newCommand(def)
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+			cmd.SetErr(&out)
+			cmd.SetArgs([]string{})
+			err := cmd.Execute()
+
+			_, statErr := os.Stat(filepath.Join(root, "old.go"))
+			if allow {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !os.IsNotExist(statErr) {
+					t.Fatalf("expected old.go to be deleted, stat error: %v", statErr)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "deletion not allowed") {
+				t.Fatalf("expected deletion to be rejected, got %v", err)
+			}
+			if statErr != nil {
+				t.Fatalf("old.go must be kept when deletion is rejected: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestRenderProposalDiff(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{"keep.go": "a\nb\n", "gone.go": "x\n"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	got, err := renderProposalDiff(root, []payload.FileChangeProposal{
+		{FileName: "keep.go", Content: "a\nc\n"},
+		{FileName: "new.go", Content: "n\n"},
+		{FileName: "gone.go", Delete: true},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"modified file keep.go\n--- a/keep.go\n+++ b/keep.go\n",
+		"-b\n+c\n",
+		"new file new.go\n--- /dev/null\n+++ b/new.go\n",
+		"deleted file gone.go\n--- a/gone.go\n+++ /dev/null\n",
+		"-x\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("diff missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestExecute_DryRun(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Summary:     "Say hello",
+		Description: "Prints a greeting on start.",
+		Proposals: []payload.FileChangeProposal{
+			{FileName: "main.go", Content: "package main\n\nfunc main() { println(\"hello\") }\n"},
+			{FileName: "hello.go", Content: "package main\n"},
+		},
+	})
+	asked := fakeConfirm(t, true)
+
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		ModificationInclusionPatterns: []string{"*.go"},
+	}
+	cmd := newCommand(def)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"--dry-run"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "+func main() { println(\"hello\") }") || !strings.Contains(out.String(), "new file hello.go") {
+		t.Fatalf("dry run output missing the diff:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Say hello") || !strings.Contains(out.String(), "Prints a greeting on start.") {
+		t.Fatalf("dry run output missing the summary and description:\n%s", out.String())
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "main.go"))
+	if string(data) != "package main\n\nfunc main() {}\n" {
+		t.Fatalf("dry run must not modify files, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "hello.go")); !os.IsNotExist(err) {
+		t.Fatalf("dry run must not create files, stat error: %v", err)
+	}
+	if *asked {
+		t.Fatalf("dry run must not ask for confirmation")
+	}
+}
@@ -0,0 +1,124 @@
+package template
+
+import (
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/logging"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// maxGitLogCommits bounds how far back the git history is scanned. Files
+// whose latest change is older than that are considered the oldest.
+const maxGitLogCommits = 1000
+
+// gitModTimes returns, for every file changed in the last maxGitLogCommits
+// commits, the time of the latest commit touching it. Files with uncommitted
+// changes (including untracked ones) are reported as modified now. Paths
+// are relative to projectRoot.
+// NOTE: it is a var (not a func) to allow test overrides.
+var gitModTimes = func(projectRoot string) (map[string]time.Time, error) {
+	logCmd := exec.Command("git", "log", "--format=%x00%ct", "--name-only", "--relative", "-n", strconv.Itoa(maxGitLogCommits))
+	logCmd.Dir = projectRoot
+	out, err := logCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git history: %w", err)
+	}
+
+	times := make(map[string]time.Time)
+	var commitTime time.Time
+	for _, line := range strings.Split(string(out), "\n") {
+		if ts, ok := strings.CutPrefix(line, "\x00"); ok {
+			sec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected git log output %q: %w", line, err)
+			}
+			commitTime = time.Unix(sec, 0)
+			continue
+		}
+		// git log lists the most recent commits first.
+		if _, seen := times[line]; line != "" && !seen {
+			times[line] = commitTime
+		}
+	}
+
+	dirtyCmd := exec.Command("git", "ls-files", "--modified", "--others", "--exclude-standard")
+	dirtyCmd.Dir = projectRoot
+	out, err = dirtyCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uncommitted changes: %w", err)
+	}
+	now := time.Now()
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			times[line] = now
+		}
+	}
+	return times, nil
+}
+
+// sortByRecency returns files ordered from the most to the least recently
+// modified. Recency comes from the git history when projectRoot is a git
+// repository, and from the file modification time otherwise.
+func sortByRecency(rootFS fs.FS, projectRoot string, files []string) []string {
+	times, err := gitModTimes(projectRoot)
+	if err != nil {
+		logging.Log.Debugf("falling back to file modification times: %v\n", err)
+		times = make(map[string]time.Time, len(files))
+		for _, f := range files {
+			if info, err := fs.Stat(rootFS, f); err == nil {
+				times[f] = info.ModTime()
+			}
+		}
+	}
+
+	sorted := append([]string(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return times[sorted[i]].After(times[sorted[j]])
+	})
+	return sorted
+}
+
+// applyFileBudget keeps files, in the given order, while their cumulative
+// token count fits within budget. The pinned files (typically the command
+// targets) are always kept and are accounted for first. Order is preserved
+// in both returned slices. Tokens are counted in the encoding enc.
+func applyFileBudget(rootFS fs.FS, files []string, pinned []string, budget int, enc tokenizer.Encoding) (kept, dropped []string, err error) {
+	tokens := make(map[string]int, len(files))
+	for _, f := range files {
+		content, err := fs.ReadFile(rootFS, f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read file %s: %w", f, err)
+		}
+		n, err := project.CountTokensFor(enc, string(content))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to count tokens of %s: %w", f, err)
+		}
+		tokens[f] = n
+	}
+
+	used := 0
+	for _, p := range pinned {
+		used += tokens[p]
+	}
+	for _, f := range files {
+		if slices.Contains(pinned, f) {
+			kept = append(kept, f)
+			continue
+		}
+		if used+tokens[f] > budget {
+			dropped = append(dropped, f)
+			continue
+		}
+		used += tokens[f]
+		kept = append(kept, f)
+	}
+	return kept, dropped, nil
+}
@@ -0,0 +1,228 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// requestBudget bounds the tokens of a workspace change request.
+type requestBudget struct {
+	// Tokens caps the tokens of the request. Zero means no cap.
+	Tokens int
+	// Targets are the files the command was invoked on, if any. They are
+	// included first and never summarized.
+	Targets []string
+	// Encoding is the encoding tokens are counted in, see
+	// project.EncodingFor. Empty means project.DefaultEncoding.
+	Encoding tokenizer.Encoding
+}
+
+// maxReportedFiles is the number of files listed when a request does not
+// fit its budget.
+const maxReportedFiles = 5
+
+// fitFiles reads the given files into the request, within the tokens left
+// by the used ones. Files are ordered by proximity to the target module and
+// included in full while they fit. The remaining files are replaced by the
+// internal context of their module, returned as summaries. It fails when
+// even the summaries (and the target files) do not fit.
+func fitFiles(rootFS fs.FS, root, targetMod *project.Module, paths []string, budget requestBudget, used int) (files []payload.FileContent, summaries []payload.ModuleContext, err error) {
+	if budget.Tokens <= 0 {
+		files, err = readFiles(rootFS, paths)
+		return files, nil, err
+	}
+
+	ordered := orderByProximity(root, targetMod, paths, budget.Targets)
+	// Files are summarized by the module describing them, which is the
+	// closest annotated ancestor of the modules left without annotation.
+	targetOwner := project.AnnotatedAncestor(root, targetMod)
+	owners := make(map[string]*project.Module, len(ordered))
+	modules := make(map[string]*project.Module, len(ordered))
+	tokens := make(map[string]int, len(ordered))
+	for _, path := range ordered {
+		modules[path] = project.FindModule(root, path)
+		n, err := fileTokens(rootFS, modules[path], path, budget.Encoding)
+		if err != nil {
+			return nil, nil, err
+		}
+		tokens[path] = n
+		owners[path] = project.AnnotatedAncestor(root, modules[path])
+	}
+
+	// Reserve room for the summary of every module, so files included in
+	// full never leave too little room for the summaries of the others.
+	summaryTokens := make(map[*project.Module]int)
+	reserved := 0
+	for _, path := range ordered {
+		mod := owners[path]
+		if _, ok := summaryTokens[mod]; ok {
+			continue
+		}
+		n, err := project.CountTokensFor(budget.Encoding, internalContext(mod, targetOwner))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to count tokens of module %s: %w", modules[path].Name, err)
+		}
+		summaryTokens[mod] = n
+		reserved += n
+	}
+	needed := used + reserved
+	for _, target := range budget.Targets {
+		needed += tokens[target]
+	}
+	if needed > budget.Tokens {
+		return nil, nil, budgetError(budget.Tokens, needed, tokens)
+	}
+
+	used += reserved
+	included := 0
+	for _, path := range ordered {
+		if used+tokens[path] > budget.Tokens {
+			break
+		}
+		used += tokens[path]
+		included++
+	}
+
+	files, err = readFiles(rootFS, ordered[:included])
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[*project.Module]bool)
+	for _, path := range ordered[included:] {
+		mod := owners[path]
+		if seen[mod] {
+			continue
+		}
+		seen[mod] = true
+		if content := internalContext(mod, targetOwner); content != "" {
+			summaries = append(summaries, payload.ModuleContext{Name: mod.Name, Content: content})
+		}
+	}
+	return files, summaries, nil
+}
+
+// readFiles returns the content of the given files.
+func readFiles(rootFS fs.FS, paths []string) ([]payload.FileContent, error) {
+	var files []payload.FileContent
+	for _, path := range paths {
+		content, err := fs.ReadFile(rootFS, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		files = append(files, payload.FileContent{
+			Path:    path,
+			Content: string(content),
+		})
+	}
+	return files, nil
+}
+
+// orderByProximity sorts paths so targets come first, followed by the files
+// of the modules closest to targetMod in the module tree. The relative order
+// of equally close files is preserved.
+func orderByProximity(root, targetMod *project.Module, paths []string, targets []string) []string {
+	distance := make(map[string]int, len(paths))
+	for _, path := range paths {
+		distance[path] = moduleDistance(project.FindModule(root, path), targetMod)
+	}
+	ordered := append([]string(nil), paths...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ti, tj := slices.Contains(targets, ordered[i]), slices.Contains(targets, ordered[j])
+		if ti != tj {
+			return ti
+		}
+		return distance[ordered[i]] < distance[ordered[j]]
+	})
+	return ordered
+}
+
+// moduleDistance returns the number of edges between a and b in the module
+// tree.
+func moduleDistance(a, b *project.Module) int {
+	depth := make(map[*project.Module]int)
+	d := 0
+	for m := b; m != nil; m = m.Parent {
+		if _, ok := depth[m]; ok {
+			break
+		}
+		depth[m] = d
+		d++
+	}
+	d = 0
+	for m := a; m != nil; m = m.Parent {
+		if n, ok := depth[m]; ok {
+			return d + n
+		}
+		d++
+	}
+	return d + len(depth)
+}
+
+// fileTokens returns the token count of path stored in the metadata of mod,
+// counting the tokens of its content in the encoding enc when the file is
+// not known.
+func fileTokens(rootFS fs.FS, mod *project.Module, path string, enc tokenizer.Encoding) (int, error) {
+	if mod != nil {
+		for _, f := range mod.Files {
+			if f.Name == path {
+				return int(f.TokenCount), nil
+			}
+		}
+	}
+	content, err := fs.ReadFile(rootFS, path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	n, err := project.CountTokensFor(enc, string(content))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens of %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// internalContext returns the internal context summarizing the files of
+// mod, or an empty string when there is none or when mod is the target
+// module, whose context is already part of the request.
+func internalContext(mod, targetMod *project.Module) string {
+	if mod == nil || mod == targetMod || mod.Annotation == nil {
+		return ""
+	}
+	return mod.Annotation.InternalContext
+}
+
+// budgetError reports a request needing more than budget tokens, listing
+// the largest files.
+func budgetError(budget, needed int, tokens map[string]int) error {
+	paths := make([]string, 0, len(tokens))
+	for path := range tokens {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if tokens[paths[i]] != tokens[paths[j]] {
+			return tokens[paths[i]] > tokens[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > maxReportedFiles {
+		paths = paths[:maxReportedFiles]
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "request needs at least %d tokens, above the budget of %d. Largest files:", needed, budget)
+	for _, path := range paths {
+		fmt.Fprintf(&sb, "\n  - %s (%d tokens)", path, tokens[path])
+	}
+	sb.WriteString("\nNarrow the selection (e.g. exclude these files with .vybignore) or raise request.max_request_tokens in .vyb/config.yaml")
+	if project.HeuristicTokenCounts() {
+		fmt.Fprintf(&sb, "\nNote: %s", project.HeuristicTokensNote)
+	}
+	return errors.New(sb.String())
+}
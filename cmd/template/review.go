@@ -0,0 +1,61 @@
+package template
+
+import (
+	"fmt"
+
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// Answers offered for every file reviewed with --interactive.
+const (
+	reviewApply = "apply"
+	reviewSkip  = "skip"
+	reviewDiff  = "view diff"
+)
+
+// requireTerminal fails when --interactive cannot prompt the user. It is
+// checked before the LLM is called, so nothing is spent on a proposal that
+// could not be reviewed.
+// NOTE: requireTerminal and selectReview are vars (not direct calls) to
+// allow test overrides.
+var requireTerminal = func() error {
+	if err := ui.RequireInteractive(""); err != nil {
+		return fmt.Errorf("--interactive needs a terminal to review the proposals: %w", err)
+	}
+	return nil
+}
+
+var selectReview = func(message string) (string, error) {
+	return ui.Select(message, []string{reviewApply, reviewSkip, reviewDiff}, reviewApply, "")
+}
+
+// reviewProposals asks the user, file by file, whether each proposal should
+// be applied, showing its diff (diffs[i] for proposals[i]) on request. It
+// returns the approved and the skipped proposals, in their original order.
+func reviewProposals(out *ui.Printer, proposals []payload.FileChangeProposal, diffs []string) (approved, skipped []payload.FileChangeProposal, err error) {
+	for i, prop := range proposals {
+		action := "Modify"
+		if prop.Delete {
+			action = "Delete"
+		}
+		message := fmt.Sprintf("[%d/%d] %s %s?", i+1, len(proposals), action, prop.FileName)
+		for {
+			answer, err := selectReview(message)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to review %s: %w", prop.FileName, err)
+			}
+			if answer == reviewDiff {
+				out.Diff(diffs[i])
+				continue
+			}
+			if answer == reviewApply {
+				approved = append(approved, prop)
+			} else {
+				skipped = append(skipped, prop)
+			}
+			break
+		}
+	}
+	return approved, skipped, nil
+}
@@ -0,0 +1,112 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// fakeReview answers the --interactive prompts with answers, in order, and
+// lets the terminal check pass.
+func fakeReview(t *testing.T, answers ...string) *[]string {
+	t.Helper()
+	var asked []string
+	oldSelect, oldRequire := selectReview, requireTerminal
+	selectReview = func(message string) (string, error) {
+		asked = append(asked, message)
+		if len(answers) == 0 {
+			t.Fatalf("unexpected prompt %q", message)
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+	requireTerminal = func() error { return nil }
+	t.Cleanup(func() { selectReview, requireTerminal = oldSelect, oldRequire })
+	return &asked
+}
+
+func TestExecute_Interactive(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"a.go": "package main\n",
+		"b.go": "package main\n",
+	})
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Summary: "touch both",
+		Proposals: []payload.FileChangeProposal{
+			{FileName: "a.go", Content: "package main\n\n// a changed\n"},
+			{FileName: "b.go", Content: "package main\n\n// b changed\n"},
+		},
+	})
+	asked := fakeReview(t, reviewDiff, reviewSkip, reviewApply)
+
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		ModificationInclusionPatterns: []string{"*"},
+	}
+	cmd := newCommand(def)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--interactive"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"[1/2] Modify a.go?", "[1/2] Modify a.go?", "[2/2] Modify b.go?"}; strings.Join(*asked, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected prompts %q", *asked)
+	}
+	if !strings.Contains(out.String(), "+// a changed") {
+		t.Fatalf("expected the diff of a.go to be shown:\n%s", out.String())
+	}
+	if a, _ := os.ReadFile(filepath.Join(root, "a.go")); string(a) != "package main\n" {
+		t.Fatalf("skipped file was modified: %q", a)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "b.go")); !strings.Contains(string(b), "b changed") {
+		t.Fatalf("approved file was not modified: %q", b)
+	}
+	summary := out.String()[strings.Index(out.String(), "Skipped files"):]
+	if !strings.Contains(summary, "a.go") || strings.Contains(summary, "b.go") {
+		t.Fatalf("expected only a.go to be listed as skipped:\n%s", summary)
+	}
+}
+
+func TestExecute_InteractiveNotTerminal(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	called := false
+	old := getWorkspaceChangeProposals
+	getWorkspaceChangeProposals = func(context.Context, *config.Config, config.ModelFamily, config.ModelSize, string, *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		called = true
+		return &payload.WorkspaceChangeProposal{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getWorkspaceChangeProposals = old })
+	pipeStdin(t)
+
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		ModificationInclusionPatterns: []string{"*"},
+	}
+	cmd := newCommand(def)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--interactive"})
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--interactive needs a terminal") {
+		t.Fatalf("expected a terminal error, got %v", err)
+	}
+	if called {
+		t.Fatalf("the LLM must not be called")
+	}
+	if content, _ := os.ReadFile(filepath.Join(root, "main.go")); string(content) != "package main\n" {
+		t.Fatalf("expected no changes, got %q", content)
+	}
+}
@@ -0,0 +1,598 @@
+package template
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/logging"
+	"github.com/vybdev/vyb/workspace/backup"
+	wscontext "github.com/vybdev/vyb/workspace/context"
+	"github.com/vybdev/vyb/workspace/project"
+	"github.com/vybdev/vyb/workspace/selector"
+)
+
+// serveFile is where `vyb serve` publishes its address and access token,
+// relative to the project root. It is removed on shutdown.
+const serveFile = ".vyb/serve.json"
+
+// defaultServeCommand is the command used when a request does not name one.
+const defaultServeCommand = "code"
+
+// shutdownTimeout bounds how long in-flight requests may take to complete
+// once the server is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// serveInfo is the content of serveFile. Like every JSON document served,
+// it records payload.SchemaVersion.
+type serveInfo struct {
+	SchemaVersion string `json:"schema_version"`
+	Address       string `json:"address"`
+	Token         string `json:"token"`
+	PID           int    `json:"pid"`
+}
+
+// executionRequest selects a command and its target. It is the JSON body of
+// POST /plan and POST /execute, and the query string of GET /context.
+type executionRequest struct {
+	// Command is the name of a registered command definition.
+	Command string `json:"command"`
+	// Path is a file (the command target) or a directory (the working
+	// directory), absolute or relative to the project root. It defaults to
+	// the project root.
+	Path   string `json:"path"`
+	All    bool   `json:"all"`
+	Recent bool   `json:"recent"`
+}
+
+// contextBundle is everything vyb would send to the LLM for a request.
+type contextBundle struct {
+	SchemaVersion   string                          `json:"schema_version"`
+	Command         string                          `json:"command"`
+	Files           []string                        `json:"files"`
+	DroppedFiles    []string                        `json:"dropped_files,omitempty"`
+	SummarizedFiles []string                        `json:"summarized_files,omitempty"`
+	StaleModules    []string                        `json:"stale_modules,omitempty"`
+	SystemMessage   string                          `json:"system_message"`
+	Request         *payload.WorkspaceChangeRequest `json:"request"`
+}
+
+// planResponse is returned by POST /plan and POST /execute.
+type planResponse struct {
+	SchemaVersion string                       `json:"schema_version"`
+	Context       *contextBundle               `json:"context"`
+	Summary       string                       `json:"summary"`
+	Description   string                       `json:"description"`
+	Proposals     []payload.FileChangeProposal `json:"proposals"`
+	Diffs         []string                     `json:"diffs"`
+	Validations   []proposalValidation         `json:"validations"`
+	Usage         tokenUsage                   `json:"usage"`
+	Applied       bool                         `json:"applied"`
+	Error         string                       `json:"error,omitempty"`
+
+	// origin is recorded in the backup set taken before applying.
+	origin backup.Origin
+}
+
+// moduleInfo describes a module in GET /modules.
+type moduleInfo struct {
+	Name       string              `json:"name"`
+	TokenCount int64               `json:"token_count"`
+	Files      []string            `json:"files"`
+	Annotation *project.Annotation `json:"annotation,omitempty"`
+}
+
+// statusResponse is returned by GET /status.
+type statusResponse struct {
+	SchemaVersion    string        `json:"schema_version"`
+	Root             string        `json:"root"`
+	LoadedAt         time.Time     `json:"loaded_at"`
+	Error            string        `json:"error,omitempty"`
+	HierarchyChanged bool          `json:"hierarchy_changed"`
+	StaleModules     []staleModule `json:"stale_modules,omitempty"`
+}
+
+type staleModule struct {
+	Name             string  `json:"name"`
+	ChangePercentage float64 `json:"change_percentage"`
+}
+
+// server exposes the command pipeline over HTTP. The workspace state is
+// loaded once and reloaded by watch whenever the project files change.
+type server struct {
+	root  string
+	defs  map[string]*Definition
+	token string
+
+	mu          sync.RWMutex
+	state       *workspaceState
+	stateErr    error
+	loadedAt    time.Time
+	fingerprint uint64
+
+	// applyMu serializes POST /execute so concurrent requests never
+	// interleave their changes on disk.
+	applyMu sync.Mutex
+}
+
+func newServer(root string, defs []*Definition, token string) *server {
+	s := &server{root: root, defs: make(map[string]*Definition, len(defs)), token: token}
+	for _, def := range defs {
+		s.defs[def.Name] = def
+	}
+	s.refresh()
+	return s
+}
+
+// refresh reloads the workspace state when the project files changed since
+// the last load.
+func (s *server) refresh() {
+	fp, err := workspaceFingerprint(s.root)
+	s.mu.RLock()
+	unchanged := err == nil && !s.loadedAt.IsZero() && fp == s.fingerprint
+	s.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	var state *workspaceState
+	if err == nil {
+		state, err = loadWorkspaceState(s.root, os.DirFS(s.root))
+	}
+	s.mu.Lock()
+	s.state, s.stateErr, s.fingerprint, s.loadedAt = state, err, fp, time.Now()
+	s.mu.Unlock()
+
+	if err != nil {
+		logging.Log.Warnf("failed to load workspace state: %v\n", err)
+	} else {
+		logging.Log.Debugf("workspace state reloaded\n")
+	}
+}
+
+// watch polls the project files every interval until ctx is done.
+func (s *server) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refresh()
+		}
+	}
+}
+
+func (s *server) currentState() (*workspaceState, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state, s.loadedAt, s.stateErr
+}
+
+// fingerprintExclusions are the selector's system exclusions, except for
+// the ignore files, whose changes alter the selection.
+var fingerprintExclusions = []string{".git/", ".vyb/"}
+
+// workspaceFingerprint hashes the path, size and modification time of the
+// files the selector would pick under root, of the ignore files and of the
+// files under .vyb. Dependency directories, ignored paths, the selection
+// cache, the request/response logs, the backups and serveFile are skipped,
+// so polling does not walk them.
+func workspaceFingerprint(root string) (uint64, error) {
+	fsys := os.DirFS(root)
+	ec := &wscontext.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "."}
+	files, err := selector.Select(fsys, ec, fingerprintExclusions, []string{"*"})
+	if err != nil {
+		return 0, err
+	}
+	err = fs.WalkDir(fsys, ".vyb", func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == ".vyb" {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (p == selector.CacheDir || p == config.LogDir || p == backup.Dir) {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && p != serveFile {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	h := fnv.New64a()
+	for _, f := range files {
+		info, err := fs.Stat(fsys, f)
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed while walking, the next poll will notice.
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", f, info.Size(), info.ModTime().UnixNano())
+	}
+	return h.Sum64(), nil
+}
+
+// handler returns the HTTP API, guarded by the access token.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /modules", s.handleModules)
+	mux.HandleFunc("GET /context", s.handleContext)
+	mux.HandleFunc("POST /plan", s.handlePlan)
+	mux.HandleFunc("POST /execute", s.handleExecute)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests lacking an `Authorization: Bearer <token>`
+// header with the server token.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid access token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	state, loadedAt, err := s.currentState()
+	resp := statusResponse{SchemaVersion: payload.SchemaVersion, Root: s.root, LoadedAt: loadedAt}
+	if err != nil {
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	resp.HierarchyChanged = len(state.Patch.AddedModules) > 0 || len(state.Patch.RemovedModules) > 0
+	for name, change := range state.Patch.ChangedModules {
+		resp.StaleModules = append(resp.StaleModules, staleModule{Name: name, ChangePercentage: change.ChangePercentage()})
+	}
+	sort.Slice(resp.StaleModules, func(i, j int) bool { return resp.StaleModules[i].Name < resp.StaleModules[j].Name })
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleModules(w http.ResponseWriter, _ *http.Request) {
+	state, _, err := s.currentState()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	var modules []moduleInfo
+	var walk func(m *project.Module)
+	walk = func(m *project.Module) {
+		info := moduleInfo{Name: m.Name, TokenCount: m.TokenCount, Files: []string{}, Annotation: m.Annotation}
+		for _, f := range m.Files {
+			info.Files = append(info.Files, f.Name)
+		}
+		modules = append(modules, info)
+		for _, child := range m.Modules {
+			walk(child)
+		}
+	}
+	if state.Meta.Modules != nil {
+		walk(state.Meta.Modules)
+	}
+	writeJSON(w, http.StatusOK, modules)
+}
+
+func (s *server) handleContext(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	er := executionRequest{Command: q.Get("command"), Path: q.Get("path")}
+	for name, dst := range map[string]*bool{"all": &er.All, "recent": &er.Recent} {
+		if v := q.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s parameter: %w", name, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	req, status, err := s.prepare(er)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newContextBundle(req))
+}
+
+func (s *server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	resp, status, err := s.plan(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleExecute(w http.ResponseWriter, r *http.Request) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	resp, status, err := s.plan(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	if resp.Error != "" {
+		writeJSON(w, http.StatusUnprocessableEntity, resp)
+		return
+	}
+	if err := applyProposals(r.Context(), s.root, resp.origin, resp.Proposals, applyOptions{}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp.Applied = true
+	s.refresh()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// plan decodes an executionRequest from the body of r and asks the LLM for
+// a proposal. Proposals that fail validation are reported in the Error
+// field of the response rather than as an error.
+func (s *server) plan(r *http.Request) (*planResponse, int, error) {
+	var er executionRequest
+	if err := json.NewDecoder(r.Body).Decode(&er); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)
+	}
+	req, status, err := s.prepare(er)
+	if err != nil {
+		return nil, status, err
+	}
+	plan, err := req.propose(r.Context())
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	resp := &planResponse{
+		SchemaVersion: payload.SchemaVersion,
+		Context:       newContextBundle(req),
+		Summary:       plan.Proposal.Summary,
+		Description:   plan.Proposal.Description,
+		Proposals:     plan.Proposal.Proposals,
+		Diffs:         plan.Diffs,
+		Validations:   plan.Validations,
+		Usage:         plan.Usage,
+		origin:        req.inv.origin(),
+	}
+	if err := plan.validationError(); err != nil {
+		resp.Error = err.Error()
+	}
+	return resp, http.StatusOK, nil
+}
+
+// prepare resolves er against the cached workspace state. On failure it
+// also returns the HTTP status to report.
+func (s *server) prepare(er executionRequest) (*preparedRequest, int, error) {
+	name := er.Command
+	if name == "" {
+		name = defaultServeCommand
+	}
+	def, ok := s.defs[name]
+	if !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf("unknown command %q", name)
+	}
+
+	ec, target, err := s.executionContext(er.Path)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	var targets []string
+	if target != nil {
+		if len(def.ArgInclusionPatterns) == 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("command %q expects no target, but got %s", name, *target)
+		}
+		targets = []string{*target}
+	}
+
+	state, _, err := s.currentState()
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	req, err := prepare(&invocation{def: def, ec: ec, targets: targets, includeAll: er.All, recent: er.Recent}, state, newFileCache(os.DirFS(s.root)))
+	if errors.Is(err, errHierarchyChanged) {
+		return nil, http.StatusConflict, err
+	}
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	return req, http.StatusOK, nil
+}
+
+// executionContext maps the path of a request to an ExecutionContext. A
+// file becomes the command target, with the project root as working
+// directory. A directory becomes the working directory.
+func (s *server) executionContext(p string) (*wscontext.ExecutionContext, *string, error) {
+	abs := p
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(s.root, p)
+	}
+	abs = filepath.Clean(abs)
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid path %q: %w", p, err)
+	}
+	if info.IsDir() {
+		ec, err := wscontext.NewExecutionContext(s.root, abs, nil)
+		return ec, nil, err
+	}
+	ec, err := wscontext.NewExecutionContext(s.root, s.root, &abs)
+	if err != nil {
+		return nil, nil, err
+	}
+	rel, _ := filepath.Rel(s.root, abs)
+	return ec, &rel, nil
+}
+
+func newContextBundle(req *preparedRequest) *contextBundle {
+	b := &contextBundle{
+		SchemaVersion:   payload.SchemaVersion,
+		Command:         req.inv.def.Name,
+		Files:           req.Files,
+		DroppedFiles:    req.DroppedFiles,
+		SummarizedFiles: req.SummarizedFiles,
+		SystemMessage:   req.SystemMessage,
+		Request:         req.Request,
+	}
+	for name := range req.StaleModules {
+		b.StaleModules = append(b.StaleModules, name)
+	}
+	sort.Strings(b.StaleModules)
+	return b
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Log.Warnf("failed to write response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// isLoopback reports whether addr (host:port) only accepts local
+// connections. An empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate access token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeServeInfo publishes info to serveFile, readable only by the owner.
+func writeServeInfo(root string, info serveInfo) (string, error) {
+	path := filepath.Join(root, serveFile)
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// remove leftovers of a previous run so the file is re-created with
+	// restrictive permissions.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// newServeCommand builds `vyb serve`, which exposes context assembly and
+// the command pipeline to editor integrations over a local HTTP API.
+func newServeCommand(defs []*Definition) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the project context over a local HTTP API",
+		Long: `Loads the project metadata once and serves it to editor integrations
+over HTTP until interrupted. The metadata is reloaded whenever the project
+files change.
+
+The listening address and a freshly generated access token are written to
+.vyb/serve.json. Every request must carry the header
+"Authorization: Bearer <token>".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			addr, _ := cmd.Flags().GetString("addr")
+			allowRemote, _ := cmd.Flags().GetBool("allow-remote")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			if !allowRemote && !isLoopback(addr) {
+				return fmt.Errorf("refusing to listen on non-loopback address %q, pass --allow-remote to override", addr)
+			}
+			if interval <= 0 {
+				return fmt.Errorf("--interval must be positive, got %s", interval)
+			}
+
+			ec, err := prepareExecutionContext("", nil)
+			if err != nil {
+				return err
+			}
+			token, err := newToken()
+			if err != nil {
+				return err
+			}
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+
+			s := newServer(ec.ProjectRoot, defs, token)
+			infoPath, err := writeServeInfo(ec.ProjectRoot, serveInfo{SchemaVersion: payload.SchemaVersion, Address: ln.Addr().String(), Token: token, PID: os.Getpid()})
+			if err != nil {
+				ln.Close()
+				return &project.PersistError{Path: filepath.Join(ec.ProjectRoot, serveFile), Cause: err}
+			}
+			defer os.Remove(infoPath)
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go s.watch(ctx, interval)
+
+			srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+			errCh := make(chan error, 1)
+			go func() { errCh <- srv.Serve(ln) }()
+
+			out := ui.NewAuto(cmd.OutOrStdout())
+			out.Success("Serving %s on http://%s", ec.ProjectRoot, ln.Addr())
+			out.Printf("Access token written to %s\n", serveFile)
+
+			select {
+			case err := <-errCh:
+				return err
+			case <-ctx.Done():
+			}
+			out.Printf("Shutting down...\n")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			return srv.Shutdown(shutdownCtx)
+		},
+	}
+	cmd.Flags().String("addr", "127.0.0.1:0", "address to listen on; port 0 picks a free port")
+	cmd.Flags().Bool("allow-remote", false, "allow listening on a non-loopback address")
+	cmd.Flags().Duration("interval", 2*time.Second, "how often project files are checked for changes")
+	return cmd
+}
@@ -0,0 +1,207 @@
+package template
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func newTestServer(t *testing.T, files map[string]string) (*server, string) {
+	t.Helper()
+	root := setupWorkspace(t, files)
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		RequestInclusionPatterns:      []string{"*"},
+		ModificationInclusionPatterns: []string{"*.go"},
+	}
+	return newServer(root, []*Definition{def}, "secret"), root
+}
+
+func doRequest(t *testing.T, s *server, method, target, body string, out any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, r)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("failed to decode %s %s response %q: %v", method, target, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func TestServer_RequiresToken(t *testing.T) {
+	s, _ := newTestServer(t, map[string]string{"main.go": "package main\n"})
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		s.handler().ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", header, w.Code)
+		}
+	}
+}
+
+func TestServer_ContextAndModules(t *testing.T) {
+	s, _ := newTestServer(t, map[string]string{
+		"main.go":     "package main\n",
+		"pkg/lib.go":  "package pkg\n",
+		"pkg/util.go": "package pkg\n",
+	})
+
+	var bundle contextBundle
+	if code := doRequest(t, s, http.MethodGet, "/context?path=main.go", "", &bundle); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if bundle.Command != "code" || bundle.Request == nil || !strings.Contains(strings.Join(bundle.Files, ","), "main.go") {
+		t.Fatalf("unexpected context bundle: %+v", bundle)
+	}
+
+	var modules []moduleInfo
+	if code := doRequest(t, s, http.MethodGet, "/modules", "", &modules); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(modules) == 0 || modules[0].Name != "." {
+		t.Fatalf("expected root module first, got %+v", modules)
+	}
+
+	var errResp map[string]string
+	if code := doRequest(t, s, http.MethodGet, "/context?path=../outside", "", &errResp); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a path outside the project, got %d (%v)", code, errResp)
+	}
+	if code := doRequest(t, s, http.MethodGet, "/context?command=nope", "", &errResp); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown command, got %d (%v)", code, errResp)
+	}
+}
+
+func TestServer_PlanAndExecute(t *testing.T) {
+	s, root := newTestServer(t, map[string]string{"main.go": "package main\n"})
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Summary:   "feat: greet",
+		Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n\n// greet\n"}},
+	})
+	body := `{"command": "code", "path": "main.go"}`
+
+	var plan planResponse
+	if code := doRequest(t, s, http.MethodPost, "/plan", body, &plan); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if plan.Applied || len(plan.Diffs) != 1 || !strings.Contains(plan.Diffs[0], "+// greet") {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "main.go")); string(data) != "package main\n" {
+		t.Fatalf("/plan must not modify files, got %q", data)
+	}
+
+	var executed planResponse
+	if code := doRequest(t, s, http.MethodPost, "/execute", body, &executed); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", code, executed.Error)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "main.go")); !executed.Applied || string(data) != "package main\n\n// greet\n" {
+		t.Fatalf("expected change to be applied, got %q", data)
+	}
+}
+
+func TestServer_ExecuteRejectsUnallowedFiles(t *testing.T) {
+	s, root := newTestServer(t, map[string]string{"main.go": "package main\n"})
+	fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Proposals: []payload.FileChangeProposal{
+			{FileName: "main.go", Content: "package main\n\n// greet\n"},
+			{FileName: "notes.txt", Content: "nope"},
+		},
+	})
+
+	var resp planResponse
+	if code := doRequest(t, s, http.MethodPost, "/execute", `{"path": "main.go"}`, &resp); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", code)
+	}
+	if resp.Applied || !strings.Contains(resp.Error, "notes.txt") {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "main.go")); string(data) != "package main\n" {
+		t.Fatalf("expected main.go to be untouched, got %q", data)
+	}
+}
+
+func TestServer_StatusReportsStaleModules(t *testing.T) {
+	s, root := newTestServer(t, map[string]string{"main.go": "package main\n"})
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s.refresh()
+
+	var status statusResponse
+	if code := doRequest(t, s, http.MethodGet, "/status", "", &status); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if status.SchemaVersion != payload.SchemaVersion || status.Error != "" || len(status.StaleModules) != 1 || status.StaleModules[0].Name != "." {
+		t.Fatalf("expected the root module to be stale, got %+v", status)
+	}
+}
+
+func TestWorkspaceFingerprint(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go":                 "package main\n",
+		".gitignore":              "build/\n",
+		"build/out.bin":           "v1",
+		"vendor/dep/dep.go":       "package dep\n",
+		"node_modules/m/index.js": "v1",
+	})
+	fingerprint := func() uint64 {
+		t.Helper()
+		fp, err := workspaceFingerprint(root)
+		if err != nil {
+			t.Fatalf("workspaceFingerprint: %v", err)
+		}
+		return fp
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	base := fingerprint()
+	for _, name := range []string{"build/out.bin", "vendor/dep/dep.go", "node_modules/m/index.js", serveFile} {
+		write(name, "changed content")
+	}
+	if got := fingerprint(); got != base {
+		t.Fatal("expected changes to excluded files to keep the fingerprint")
+	}
+	for _, name := range []string{"main.go", ".gitignore"} {
+		write(name, "changed content")
+		if got := fingerprint(); got == base {
+			t.Fatalf("expected a change to %s to change the fingerprint", name)
+		}
+		base = fingerprint()
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1:0":    true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+		"invalid":        false,
+	}
+	for addr, want := range cases {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
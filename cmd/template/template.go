@@ -1,30 +1,47 @@
 package template
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/vybdev/vyb/cmd/ui"
 	"github.com/vybdev/vyb/config"
 	"github.com/vybdev/vyb/logging"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
-	"github.com/cbroglie/mustache"
 	"github.com/spf13/cobra"
 	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/llm/payload"
-	"github.com/vybdev/vyb/workspace/context"
-	"github.com/vybdev/vyb/workspace/matcher"
+	"github.com/vybdev/vyb/workspace/backup"
+	wscontext "github.com/vybdev/vyb/workspace/context"
 	"github.com/vybdev/vyb/workspace/project"
 	"github.com/vybdev/vyb/workspace/selector"
 )
 
-var systemExclusionPatterns = []string{
-	".git/",
-	".gitignore",
-	".vyb/",
-	// the following files are excluded here just temporarily until a .vybignore logic is implemented
-	"LICENSE",
-	"go.sum",
+// systemExclusionPatterns are excluded from the files of every command.
+var systemExclusionPatterns = selector.SystemExclusionPatterns
+
+// getWorkspaceChangeProposals is the LLM entry-point used by execute.
+// NOTE: it is a var (not a direct call) to allow test overrides.
+var getWorkspaceChangeProposals = requestProposals
+
+// requestProposals asks the LLM for a proposal, listing every proposed file
+// while the response streams in when http.stream is enabled.
+func requestProposals(ctx context.Context, cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	if !cfg.HTTP.Stream {
+		return llm.GetWorkspaceChangeProposals(ctx, cfg, fam, sz, sysMsg, request)
+	}
+	return llm.GetWorkspaceChangeProposalsStream(ctx, cfg, fam, sz, sysMsg, request, func(fileName string) {
+		logging.Log.Infof("Received the proposal for %s", fileName)
+	})
 }
 
 type Model struct {
@@ -51,6 +68,8 @@ type Definition struct {
 	ModificationExclusionPatterns []string `yaml:"modificationExclusionPatterns"`
 	// ModificationInclusionPatterns specifies patterns for files that could be modified when executing this command.
 	ModificationInclusionPatterns []string `yaml:"modificationInclusionPatterns"`
+	// AllowDelete lets the command delete files. Delete proposals of commands that don't opt in are rejected.
+	AllowDelete bool `yaml:"allowDelete"`
 
 	// Prompt specifies the command-specific user prompt that should be included in the LLM request
 	Prompt string `yaml:"prompt"`
@@ -60,39 +79,64 @@ type Definition struct {
 	ShortDescription string `yaml:"shortDescription"`
 	// LongDescription is a developer-provided description for the command.
 	LongDescription string `yaml:"longDescription"`
+
+	// Then lists commands to execute after this one, in order. The files
+	// changed by a step are added to the request of the next steps, along
+	// with the summary of its proposal.
+	Then []string `yaml:"then"`
+
+	// Source records where the definition was loaded from.
+	Source Source `yaml:"-"`
 }
 
 // prepareExecutionContext builds and validates an ExecutionContext based on
-// the current working directory and an optional *target* argument.
-func prepareExecutionContext(target *string) (*context.ExecutionContext, error) {
-	absWorkingDir, err := filepath.Abs(".")
+// the current working directory and the optional *target* arguments. A
+// non-empty workingDir overrides the working directory; it must be within
+// the project root of the current directory.
+func prepareExecutionContext(workingDir string, targets []string) (*wscontext.ExecutionContext, error) {
+	absCwd, err := filepath.Abs(".")
 	if err != nil {
 		return nil, fmt.Errorf("failed to determine absolute working dir: %w", err)
 	}
 
 	// Locate project root using existing helper.
-	distToRoot, err := project.FindDistanceToRoot(absWorkingDir)
+	distToRoot, err := project.FindDistanceToRoot(absCwd)
 	if err != nil {
 		return nil, fmt.Errorf("unable to determine project root: %w", err)
 	}
 
+	absWorkingDir := absCwd
+	if workingDir != "" {
+		absWorkingDir, err = filepath.Abs(workingDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve working dir %s: %w", workingDir, err)
+		}
+		fi, err := os.Stat(absWorkingDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid working dir %s: %w", workingDir, err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("working dir %s is not a directory", workingDir)
+		}
+	}
+
 	absRoot, err := filepath.Abs(distToRoot)
 	if err != nil {
 		return nil, fmt.Errorf("failed to determine absolute project root: %w", err)
 	}
 
-	// Resolve absolute target (if any).
-	var absTarget *string
-	if target != nil {
-		at, err := filepath.Abs(*target)
+	// Resolve absolute targets (if any).
+	var absTargets []string
+	for _, target := range targets {
+		at, err := filepath.Abs(target)
 		if err != nil {
-			return nil, fmt.Errorf("failed to resolve target %s: %w", *target, err)
+			return nil, fmt.Errorf("failed to resolve target %s: %w", target, err)
 		}
-		absTarget = &at
+		absTargets = append(absTargets, at)
 	}
 
 	// Let ExecutionContext enforce invariants.
-	ec, err := context.NewExecutionContext(absRoot, absWorkingDir, absTarget)
+	ec, err := wscontext.NewExecutionContextMulti(absRoot, absWorkingDir, absTargets)
 	if err != nil {
 		return nil, err
 	}
@@ -104,214 +148,769 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 		return fmt.Errorf("command \"%s\" expects no arguments, but got %v", cmd.Use, args)
 	}
 
-	// ---------------------------
-	// Retrieve --all flag value.
-	// ---------------------------
-	includeAll, _ := cmd.Flags().GetBool("all")
-
-	var target *string
-	if len(args) > 0 {
-		target = &args[0]
+	chain, err := resolveChain(def)
+	if err != nil {
+		return err
 	}
-
-	ec, err := prepareExecutionContext(target)
+	provider, modelSize, err := modelOverrides(cmd)
 	if err != nil {
 		return err
 	}
-
-	absRoot := ec.ProjectRoot
-
-	// relTarget is the *file* provided by the user (if any), relative to root.
-	var relTarget *string
-	if target != nil {
-		absTarget, _ := filepath.Abs(*target)
-		rt, _ := filepath.Rel(absRoot, absTarget)
-		relTarget = &rt
+	if modelSize != "" {
+		for i, step := range chain {
+			overridden := *step
+			overridden.Model.Size = modelSize
+			chain[i] = &overridden
+		}
 	}
 
-	rootFS := os.DirFS(absRoot)
-
-	cfg, err := config.Load(absRoot)
+	workingDir, _ := cmd.Flags().GetString("working-dir")
+	ec, err := prepareExecutionContext(workingDir, args)
 	if err != nil {
 		return err
 	}
 
-	if relTarget != nil {
-		if !matcher.IsIncluded(rootFS, *relTarget, append(systemExclusionPatterns, def.ArgExclusionPatterns...), def.ArgInclusionPatterns) {
-			return fmt.Errorf("command \"%s\" does not support given target %s", cmd.Use, *relTarget)
-		}
+	// relTargets are the *files* provided by the user (if any), relative
+	// to root. A directory target only sets ec.TargetDir.
+	var relTargets []string
+	for _, target := range ec.TargetFiles {
+		relTargets = append(relTargets, ec.Rel(target))
 	}
 
-	files, err := selector.Select(rootFS, ec, append(systemExclusionPatterns, def.ArgExclusionPatterns...), def.ArgInclusionPatterns)
-	if err != nil {
-		return err
+	includeAll, _ := cmd.Flags().GetBool("all")
+	var depth *int
+	if cmd.Flags().Changed("depth") {
+		d, _ := cmd.Flags().GetInt("depth")
+		if d < 0 {
+			return fmt.Errorf("--depth must not be negative, got %d", d)
+		}
+		depth = &d
+	}
+	recent, _ := cmd.Flags().GetBool("recent")
+	var opts stepOptions
+	opts.plan, _ = cmd.Flags().GetBool("plan")
+	opts.yes, _ = cmd.Flags().GetBool("yes")
+	opts.dryRun, _ = cmd.Flags().GetBool("dry-run")
+	opts.interactive, _ = cmd.Flags().GetBool("interactive")
+	if opts.chunkSize, _ = cmd.Flags().GetInt("chunk-size"); opts.chunkSize < 0 {
+		return fmt.Errorf("--chunk-size must not be negative, got %d", opts.chunkSize)
+	}
+	out := ui.NewAuto(cmd.OutOrStdout())
+	switch output, _ := cmd.Flags().GetString("output"); output {
+	case "", outputFiles:
+	case outputPatch:
+		// stdout only carries the patch, so it can be redirected to a file.
+		opts.patch = cmd.OutOrStdout()
+		out = ui.NewAuto(cmd.ErrOrStderr())
+	default:
+		return fmt.Errorf("unsupported output %q, expected %s or %s", output, outputFiles, outputPatch)
+	}
+	if opts.interactive && !opts.dryRun && opts.patch == nil {
+		if err := requireTerminal(); err != nil {
+			return err
+		}
 	}
 
 	// ------------------------------------------------------------
-	// Load stored metadata (with annotations) and merge with a fresh
-	// snapshot produced from the current filesystem state. This
-	// guarantees we operate with up-to-date file information while
-	// keeping previously generated annotations intact.
+	// Execute every step of the chain in order. Each step is validated
+	// (and reviewed, with --plan) on its own; a failure stops the chain
+	// and keeps the changes applied by the previous steps.
 	// ------------------------------------------------------------
-	storedMeta, err := project.LoadMetadata(absRoot)
-	if err != nil {
-		return err
+	timings := &stageTimings{}
+	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+		defer func() { out.Printf("Timings: %s\n", timings) }()
 	}
-	freshMeta, err := project.BuildMetadataFS(rootFS)
-	if err != nil {
-		return err
+	// The usage is reported even when a step fails, its calls were made.
+	usage := &payload.UsageStats{}
+	defer printUsage(out.Info(), usage)
+	var previous []chainStep
+	for i, step := range chain {
+		if len(chain) > 1 {
+			out.Info().Heading(fmt.Sprintf("Step %d/%d: %s", i+1, len(chain), step.Name))
+		}
+		stepTargets := relTargets
+		if len(step.ArgInclusionPatterns) == 0 {
+			stepTargets = nil
+		}
+		inv := &invocation{def: step, ec: ec, targets: stepTargets, includeAll: includeAll, depth: depth, recent: recent, provider: provider, previous: previous, timings: timings, usage: usage}
+		proposal, err := runStep(cmd.Context(), out, inv, opts)
+		if err != nil {
+			if i > 0 {
+				return fmt.Errorf("command chain stopped at step %d (%s), changes applied by the previous steps were kept: %w", i+1, step.Name, err)
+			}
+			return err
+		}
+		if proposal == nil {
+			if i < len(chain)-1 {
+				logging.Log.Info("Skipping the remaining steps of the command chain.")
+			}
+			return nil
+		}
+		previous = append(previous, newChainStep(step.Name, proposal))
 	}
+	return nil
+}
 
-	patchResult := storedMeta.Patch(freshMeta)
-
-	if len(patchResult.AddedModules) > 0 || len(patchResult.RemovedModules) > 0 {
-		return fmt.Errorf("module hierarchy has changed. Run 'vyb update' to refresh")
+// printUsage prints on p the token usage of the LLM calls made, when the
+// provider reported any.
+func printUsage(p *ui.Printer, usage *payload.UsageStats) {
+	if usage.Calls > 0 {
+		p.Printf("LLM usage: %s.\n", usage)
 	}
+}
 
-	if len(patchResult.ChangedModules) > 0 {
-		logging.Log.Warn("metadata is stale. Run 'vyb update' to refresh.")
-		for moduleName, change := range patchResult.ChangedModules {
-			logging.Log.Warnf("  - Module %s changed by %.2f%%\n", moduleName, change.ChangePercentage())
+// modelOverrides validates the --provider and --model-size flags of cmd,
+// returning empty values for the flags left unset.
+func modelOverrides(cmd *cobra.Command) (string, config.ModelSize, error) {
+	provider, _ := cmd.Flags().GetString("provider")
+	if provider != "" {
+		if !llm.IsSupportedProvider(provider) {
+			return "", "", fmt.Errorf("unsupported provider %q, expected one of %s", provider, strings.Join(llm.SupportedProviders(), ", "))
+		}
+		provider = strings.ToLower(provider)
+	}
+	var size config.ModelSize
+	if s, _ := cmd.Flags().GetString("model-size"); s != "" {
+		sz, err := config.ParseModelSize(s)
+		if err != nil {
+			return "", "", err
 		}
+		size = sz
 	}
+	return provider, size, nil
+}
 
-	meta := storedMeta
+// stepOptions holds the execution flags that decide whether and how a
+// proposal is applied.
+type stepOptions struct {
+	// plan renders the change plan and asks for confirmation, unless yes
+	// is set.
+	plan, yes bool
+	// dryRun prints the proposed diff and never writes files.
+	dryRun bool
+	// patch, when set, receives the proposal as a patch instead of it
+	// being applied.
+	patch io.Writer
+	// interactive asks the user to approve every proposed file; only the
+	// approved ones are applied.
+	interactive bool
+	// chunkSize, when positive, applies the proposal in chunks of that
+	// many files.
+	chunkSize int
+}
 
-	// ------------------------------------------------------------
-	// Unless --all is provided, filter out files that belong to
-	// descendant modules of the target module (i.e. keep only files
-	// whose module == targetModule).
-	// ------------------------------------------------------------
-	if !includeAll && meta.Modules != nil {
-		relTargetDir, _ := filepath.Rel(absRoot, ec.TargetDir)
-		relTargetDir = filepath.ToSlash(relTargetDir)
-		targetModule := project.FindModule(meta.Modules, relTargetDir)
-		if targetModule != nil {
-			var filtered []string
-			for _, f := range files {
-				if project.FindModule(meta.Modules, f) == targetModule {
-					filtered = append(filtered, f)
-				}
-			}
-			files = filtered
+// runStep executes a single command invocation and returns the applied
+// proposal, or nil when the user discarded it, in dry-run mode or when the
+// proposal is emitted as a patch. In plan
+// mode the user is asked to confirm the plan unless opts.yes is set.
+func runStep(ctx context.Context, out *ui.Printer, inv *invocation, opts stepOptions) (*payload.WorkspaceChangeProposal, error) {
+	absRoot := inv.ec.ProjectRoot
+	// Every file is read once per step: the metadata snapshot and the
+	// request share the same content.
+	rootFS := newFileCache(os.DirFS(absRoot))
+	stopMetadata := timeStage(inv.timings, stageMetadata)
+	state, err := loadWorkspaceState(absRoot, rootFS)
+	stopMetadata()
+	if err != nil {
+		return nil, err
+	}
+	req, err := prepare(inv, state, rootFS)
+	if err != nil {
+		return nil, err
+	}
+
+	// The details of the request are informational, left out in quiet mode.
+	info := out.Info()
+	if len(req.StaleModules) > 0 {
+		info.Warn("metadata is stale. Run 'vyb update' to refresh.")
+		for moduleName, change := range req.StaleModules {
+			info.Warn("  - Module %s changed by %.2f%%", moduleName, change.ChangePercentage())
+		}
+	}
+	for _, f := range req.ForcedTargets {
+		info.Warn("target %s matches the request exclusion patterns of %s, including it anyway", f, inv.def.Name)
+	}
+	if len(req.DroppedFiles) > 0 {
+		info.Warn("file token budget of %d exceeded, dropping %d file(s):", req.cfg.Request.MaxFileTokens, len(req.DroppedFiles))
+		for _, f := range req.DroppedFiles {
+			info.Warn("  - %s", f)
+		}
+	}
+	if len(req.SummarizedFiles) > 0 {
+		info.Warn("request token budget of %d exceeded, replacing %d file(s) by the context of their module:", req.cfg.Request.TokenBudget(inv.def.Model.Size), len(req.SummarizedFiles))
+		for _, f := range req.SummarizedFiles {
+			info.Warn("  - %s", f)
 		}
 	}
 
-	logging.Log.Infof("The following files will be included in the request:\n")
-	for _, file := range files {
-		if relTarget != nil && file == *relTarget {
-			logging.Log.Infof("  %s <-- TARGET\n", file)
+	info.Heading("Files included in the request")
+	for _, file := range req.Files {
+		if inv.isTarget(file) {
+			info.Printf("  %s <-- TARGET\n", file)
 		} else {
-			logging.Log.Infof("  %s\n", file)
+			info.Printf("  %s\n", file)
 		}
 	}
 
-	userRequest, err := buildWorkspaceChangeRequest(rootFS, meta, ec, files)
+	plan, err := req.propose(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	promptGeneralInstructions, _ := embedded.ReadFile("embedded/prompts/instructions.md.mustache")
-	tmpl, err := mustache.ParseString(string(promptGeneralInstructions))
-	if err != nil {
-		return err
+	if opts.plan {
+		plan.render(out)
 	}
 
-	rendered, err := tmpl.Render(def)
-	if err != nil {
-		return err
+	if err := plan.validationError(); err != nil {
+		return nil, err
 	}
 
-	systemMessage := rendered
+	if opts.dryRun {
+		d, err := renderProposalDiff(absRoot, plan.Proposal.Proposals)
+		if err != nil {
+			return nil, err
+		}
+		out.Heading("Change summary")
+		out.Printf("%s\n\n", plan.Proposal.Summary)
+		out.Heading("Change description")
+		out.Printf("%s\n\n", plan.Proposal.Description)
+		out.Heading("Proposed changes (dry run)")
+		out.Diff(d)
+		logging.Log.Info("Dry run, no files were modified.")
+		return nil, nil
+	}
 
-	proposal, err := llm.GetWorkspaceChangeProposals(cfg, def.Model.Family, def.Model.Size, systemMessage, userRequest)
-	if err != nil {
-		return err
+	if opts.patch != nil {
+		patch, err := renderPatch(absRoot, plan.Proposal.Proposals)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(opts.patch, patch); err != nil {
+			return nil, fmt.Errorf("failed to write patch: %w", err)
+		}
+		logging.Log.Info("Proposal written as a patch, no files were modified.")
+		return nil, nil
 	}
 
-	// --------------------------------------------------------
-	// Validate that every file in the proposal is allowed to be modified.
-	// --------------------------------------------------------
-	invalidFiles := []string{}
+	proposal := plan.Proposal
+	var skipped []payload.FileChangeProposal
+	if opts.interactive {
+		approved, s, err := reviewProposals(out, proposal.Proposals, plan.Diffs)
+		if err != nil {
+			return nil, err
+		}
+		if len(approved) == 0 {
+			logging.Log.Info("No file change approved, no files were modified.")
+			return nil, nil
+		}
+		reviewed := *proposal
+		reviewed.Proposals = approved
+		proposal, skipped = &reviewed, s
+	} else if opts.plan && !opts.yes {
+		ok, err := confirm("Apply the proposed changes?")
+		if err != nil {
+			return nil, fmt.Errorf("failed to confirm change plan: %w", err)
+		}
+		if !ok {
+			logging.Log.Info("Change plan discarded, no files were modified.")
+			return nil, nil
+		}
+	}
 
-	// helper closure to assert path containment using absolute paths.
-	isWithinDir := func(dir, candidate string) bool {
-		dir = filepath.Clean(dir)
-		candidate = filepath.Clean(candidate)
-		if dir == candidate {
-			return true
+	stopApply := timeStage(inv.timings, stageApply)
+	err = applyProposals(ctx, absRoot, inv.origin(), proposal.Proposals, applyOptions{progress: printProgress(info), chunkSize: opts.chunkSize})
+	stopApply()
+	if err != nil {
+		return nil, err
+	}
+
+	out.Heading("Change summary")
+	out.Printf("%s\n\n", proposal.Summary)
+	out.Heading("Change description")
+	out.Printf("%s\n\n", proposal.Description)
+	out.Heading("Changed files")
+	var rows [][]string
+	for _, file := range proposal.Proposals {
+		status := "modified"
+		if file.Delete {
+			status = "deleted"
+		}
+		rows = append(rows, []string{"  " + file.FileName, status})
+	}
+	out.Table(nil, rows)
+	if len(skipped) > 0 {
+		out.Heading("Skipped files (left untouched)")
+		rows = nil
+		for _, file := range skipped {
+			rows = append(rows, []string{"  " + file.FileName, "skipped"})
 		}
-		return strings.HasPrefix(candidate, dir+string(os.PathSeparator))
+		out.Table(nil, rows)
+		out.Success("Applied %d file change(s), skipped %d.", len(proposal.Proposals), len(skipped))
+		return proposal, nil
 	}
+	out.Success("Applied %d file change(s).", len(proposal.Proposals))
 
-	for _, prop := range proposal.Proposals {
-		// 1. Pattern based validation (existing behaviour).
-		if !matcher.IsIncluded(rootFS, prop.FileName, append(systemExclusionPatterns, def.ModificationExclusionPatterns...), def.ModificationInclusionPatterns) {
-			invalidFiles = append(invalidFiles, prop.FileName)
+	return proposal, nil
+}
+
+// applyPromptAffixes wraps systemMessage with the prompt prefix and suffix
+// configured in .vyb/config.yaml. Each affix is delimited so the LLM (and
+// anyone reading debug logs) can tell standing instructions apart from the
+// command's own prompt.
+func applyPromptAffixes(cfg *config.Config, systemMessage string) string {
+	var sb strings.Builder
+	if prefix := strings.TrimSpace(cfg.PromptPrefix); prefix != "" {
+		sb.WriteString("<!-- BEGIN prompt_prefix -->\n")
+		sb.WriteString(prefix)
+		sb.WriteString("\n<!-- END prompt_prefix -->\n\n")
+	}
+	sb.WriteString(systemMessage)
+	if suffix := strings.TrimSpace(cfg.PromptSuffix); suffix != "" {
+		sb.WriteString("\n\n<!-- BEGIN prompt_suffix -->\n")
+		sb.WriteString(suffix)
+		sb.WriteString("\n<!-- END prompt_suffix -->\n")
+	}
+	return sb.String()
+}
+
+// filterToTargetModule keeps only the files that belong to the module
+// containing relTargetDir, dropping files from its descendant modules.
+//
+// The root module is special: FindModule falls back to it for every file
+// that is not part of a nested module, so it may span many unrelated
+// directories. When the command has targets (scopeRoot) and the target
+// module is the root, only files living directly in relTargetDir are kept.
+// Without targets, the whole root module is kept.
+func filterToTargetModule(root *project.Module, relTargetDir string, files []string, scopeRoot bool) []string {
+	return filterToModuleDepth(root, relTargetDir, files, 0, scopeRoot)
+}
+
+// filterToModuleDepth keeps the files filterToTargetModule keeps, along
+// with the files of the descendant modules at most depth levels below the
+// target module in the module tree.
+func filterToModuleDepth(root *project.Module, relTargetDir string, files []string, depth int, scopeRoot bool) []string {
+	targetModule := project.FindModule(root, relTargetDir)
+	if targetModule == nil {
+		return files
+	}
+	var filtered []string
+	for _, f := range files {
+		mod := project.FindModule(root, f)
+		if mod != targetModule {
+			if d := moduleDepth(targetModule, mod); d < 1 || d > depth {
+				continue
+			}
+		} else if scopeRoot && targetModule == root && path.Dir(f) != path.Clean(relTargetDir) {
 			continue
 		}
-		// 2. Must reside within the working_dir using absolute paths.
-		absProp := filepath.Join(absRoot, prop.FileName)
-		if !isWithinDir(ec.WorkingDir, absProp) {
-			invalidFiles = append(invalidFiles, prop.FileName+" (outside working_dir)")
+		filtered = append(filtered, f)
+	}
+	return filtered
+}
+
+// moduleDepth returns the number of levels mod sits below ancestor in the
+// module tree, or -1 when it is not one of its descendants.
+func moduleDepth(ancestor, mod *project.Module) int {
+	d := 0
+	for m := mod; m != nil; m = m.Parent {
+		if m == ancestor {
+			return d
 		}
+		d++
 	}
+	return -1
+}
 
-	if len(invalidFiles) > 0 {
-		return fmt.Errorf("change proposal contains modifications to unallowed files: %v", invalidFiles)
+// maxPathLength is the longest absolute path applyProposals writes to.
+// NOTE: it is a var (not a const) to allow test overrides.
+var maxPathLength = pathLengthLimit(runtime.GOOS)
+
+// pathLengthLimit returns the longest absolute path, in bytes, of a file
+// created on goos: MAX_PATH on Windows (without the long path opt-in),
+// PATH_MAX elsewhere, minus the terminating NUL.
+func pathLengthLimit(goos string) int {
+	switch goos {
+	case "windows":
+		return 259
+	case "darwin", "ios", "freebsd", "openbsd", "netbsd", "dragonfly":
+		return 1023
+	default:
+		return 4095
 	}
+}
+
+// renameFile moves a staged file over its destination.
+// NOTE: it is a var (not a direct call) to allow test overrides.
+var renameFile = os.Rename
+
+// applyOptions tunes applyProposals.
+type applyOptions struct {
+	// progress, when set, is called once every file is written or
+	// deleted, with the number of files done out of total.
+	progress func(done, total int, file string)
+	// chunkSize, when positive, applies the proposals in chunks of that
+	// many files, each one synced to disk and recorded in the backup set
+	// before the next one starts.
+	chunkSize int
+}
 
-	if err := applyProposals(absRoot, proposal.Proposals); err != nil {
+// applyProposals applies all file modifications as proposed by the LLM,
+// after saving the affected files into a backup set `vyb undo` can restore,
+// recording origin. New contents are first staged in temporary files next
+// to their destination, then moved into place. When any step fails, or ctx
+// is canceled, the files already changed are restored from the backup set
+// and the set is dropped, so the workspace is left as it was.
+//
+// In chunked mode only the failed chunk is rolled back: the previous ones
+// are kept, and the proposal is saved in the backup set so `vyb apply` can
+// resume it.
+func applyProposals(ctx context.Context, absRoot string, origin backup.Origin, proposals []payload.FileChangeProposal, opts applyOptions) error {
+	// Check every path first, so a proposal is never half applied.
+	for _, prop := range proposals {
+		absPath := filepath.Join(absRoot, prop.FileName)
+		if !prop.Delete && len(absPath) > maxPathLength {
+			return fmt.Errorf("cannot write %s: its path is %d bytes long, above the limit of %d on %s", absPath, len(absPath), maxPathLength, runtime.GOOS)
+		}
+	}
+	changes := make([]backup.Change, len(proposals))
+	for i, prop := range proposals {
+		changes[i] = backup.Change{Path: prop.FileName, Delete: prop.Delete}
+	}
+	m, err := backup.Create(absRoot, origin, changes)
+	if err != nil {
 		return err
 	}
 
-	logging.Log.Infof("Change summary: %s\n\n", proposal.Summary)
-	logging.Log.Infof("Change description: %s\n\n", proposal.Description)
-	logging.Log.Infof("Changed files: \n")
-	for _, file := range proposal.Proposals {
-		logging.Log.Infof("  %s -- delete? %v\n", file.FileName, file.Delete)
+	if opts.chunkSize > 0 {
+		pending, err := json.Marshal(proposals)
+		if err == nil {
+			err = m.Begin(pending, len(proposals))
+		}
+		if err != nil {
+			return errors.Join(err, m.Discard())
+		}
+		return applyChunks(ctx, absRoot, m, proposals, 0, opts)
 	}
 
+	staged, err := stageProposals(absRoot, proposals)
+	if err != nil {
+		staged.cleanup()
+		return errors.Join(err, m.Discard())
+	}
+	if err := commitProposals(ctx, absRoot, proposals, staged, 0, len(proposals), opts.progress); err != nil {
+		rbErr := m.Revert(absRoot)
+		staged.cleanup()
+		if rbErr != nil {
+			return fmt.Errorf("%w; rolling back failed too, run `vyb undo` to restore the backup: %v", err, rbErr)
+		}
+		return fmt.Errorf("%w; the workspace was rolled back", err)
+	}
+	recordApplied(absRoot, m, proposals)
+	return nil
+}
+
+// applyChunks applies proposals in chunks of opts.chunkSize files, from the
+// one at index from, and records in m the files in place after every chunk.
+// When a chunk fails, or ctx is canceled, the files of that chunk already
+// changed are rolled back and the previous chunks are kept.
+func applyChunks(ctx context.Context, absRoot string, m *backup.Manifest, proposals []payload.FileChangeProposal, from int, opts applyOptions) error {
+	total := len(proposals)
+	interrupted := func(err error, applied int) error {
+		return fmt.Errorf("%w; %d of %d files applied, run `vyb apply` to resume or `vyb undo` to revert them", err, applied, total)
+	}
+	for start := from; start < total; start += opts.chunkSize {
+		if err := ctx.Err(); err != nil {
+			return interrupted(err, start)
+		}
+		chunk := proposals[start:min(start+opts.chunkSize, total)]
+		staged, err := stageProposals(absRoot, chunk)
+		if err != nil {
+			staged.cleanup()
+			return interrupted(err, start)
+		}
+		if err := commitProposals(ctx, absRoot, chunk, staged, start, total, opts.progress); err != nil {
+			paths := make([]string, len(chunk))
+			for i, prop := range chunk {
+				paths[i] = prop.FileName
+			}
+			rbErr := m.RevertPaths(absRoot, paths)
+			staged.cleanup()
+			if rbErr != nil {
+				return fmt.Errorf("%w; rolling back the chunk failed too, run `vyb undo` to restore the backup: %v", err, rbErr)
+			}
+			return interrupted(err, start)
+		}
+		syncDirs(staged)
+		if err := m.Advance(start + len(chunk)); err != nil {
+			return interrupted(err, start)
+		}
+	}
+	if err := m.Finish(); err != nil {
+		return err
+	}
+	recordApplied(absRoot, m, proposals)
 	return nil
 }
 
-// applyProposals applies all file modifications as proposed by the LLM.
-func applyProposals(absRoot string, proposals []payload.FileChangeProposal) error {
+// recordApplied records the digests of the applied files in m, and logs
+// every change.
+func recordApplied(absRoot string, m *backup.Manifest, proposals []payload.FileChangeProposal) {
+	if err := m.RecordApplied(absRoot, project.ContentHash); err != nil {
+		logging.Log.Warnf("vyb undo will not detect later changes of the applied files: %v", err)
+	}
 	for _, prop := range proposals {
-		absPath := filepath.Join(absRoot, prop.FileName)
 		if prop.Delete {
+			logging.Log.Infof("Deleted file: %s\n", prop.FileName)
+		} else {
+			logging.Log.Infof("Modified file: %s\n", prop.FileName)
+		}
+	}
+}
+
+// stagedFile is the new content of a file, written to Temp until it is
+// moved to Dest.
+type stagedFile struct {
+	Temp, Dest string
+}
+
+// stagedProposals holds the files staged by stageProposals, at the index of
+// their proposal (zero for deletions), and the directories created for
+// them, parents first.
+type stagedProposals struct {
+	files []stagedFile
+	dirs  []string
+}
+
+// stageProposals writes the content of every file proposal to a temporary
+// file next to its destination, creating the missing directories. The
+// destination of a symbolic link is its target, so the link is kept. The
+// mode of an existing file is kept too.
+func stageProposals(absRoot string, proposals []payload.FileChangeProposal) (*stagedProposals, error) {
+	staged := &stagedProposals{files: make([]stagedFile, len(proposals))}
+	for i, prop := range proposals {
+		if prop.Delete {
+			continue
+		}
+		dest := filepath.Join(absRoot, prop.FileName)
+		if target, err := filepath.EvalSymlinks(dest); err == nil {
+			dest = target
+		}
+		created, err := mkdirAll(filepath.Dir(dest))
+		staged.dirs = append(staged.dirs, created...)
+		if err != nil {
+			return staged, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dest), err)
+		}
+		mode := fs.FileMode(0644)
+		if info, err := os.Stat(dest); err == nil {
+			mode = info.Mode().Perm()
+		}
+		temp, err := writeTemp(dest, prop.Content, mode)
+		if err != nil {
+			return staged, fmt.Errorf("failed to write to file %s: %w", dest, err)
+		}
+		staged.files[i] = stagedFile{Temp: temp, Dest: dest}
+	}
+	return staged, nil
+}
+
+// commitProposals moves the staged files into place and deletes the files
+// proposed for deletion, in the order of proposals, the files from offset
+// of a proposal of total files. It stops before the next file once ctx is
+// canceled, and reports every file done to progress, when set.
+func commitProposals(ctx context.Context, absRoot string, proposals []payload.FileChangeProposal, staged *stagedProposals, offset, total int, progress func(done, total int, file string)) error {
+	for i, prop := range proposals {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("applying the proposal was interrupted: %w", err)
+		}
+		if prop.Delete {
+			absPath := filepath.Join(absRoot, prop.FileName)
 			if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
 				return fmt.Errorf("failed to delete file %s: %w", absPath, err)
 			}
-			logging.Log.Infof("Deleted file: %s\n", prop.FileName)
 		} else {
-			dir := filepath.Dir(absPath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", dir, err)
-			}
-			if err := os.WriteFile(absPath, []byte(prop.Content), 0644); err != nil {
-				return fmt.Errorf("failed to write to file %s: %w", absPath, err)
+			f := staged.files[i]
+			if err := renameFile(f.Temp, f.Dest); err != nil {
+				return fmt.Errorf("failed to write to file %s: %w", f.Dest, err)
 			}
-			logging.Log.Infof("Modified file: %s\n", prop.FileName)
+		}
+		if progress != nil {
+			progress(offset+i+1, total, prop.FileName)
 		}
 	}
 	return nil
 }
 
+// syncDirs flushes to disk the directories of the staged files, so the
+// files moved into them survive a crash. Directories cannot be synced on
+// every platform, so failures are ignored.
+func syncDirs(s *stagedProposals) {
+	seen := make(map[string]bool)
+	for _, f := range s.files {
+		dir := filepath.Dir(f.Dest)
+		if f.Dest == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if d, err := os.Open(dir); err == nil {
+			_ = d.Sync()
+			_ = d.Close()
+		}
+	}
+}
+
+// cleanup removes the staged files not moved into place, then the created
+// directories left empty. It is safe on a nil receiver.
+func (s *stagedProposals) cleanup() {
+	if s == nil {
+		return
+	}
+	for _, f := range s.files {
+		if f.Temp != "" {
+			_ = os.Remove(f.Temp)
+		}
+	}
+	for i := len(s.dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(s.dirs[i])
+	}
+}
+
+// mkdirAll creates dir and its missing parents, and returns the directories
+// it created, parents first.
+func mkdirAll(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	slices.Reverse(missing)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return missing, err
+	}
+	return missing, nil
+}
+
+// writeTemp writes content to a new temporary file, with the given mode, in
+// the directory of dest, syncs it to disk and returns its path.
+func writeTemp(dest, content string, mode fs.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".vyb-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
 func Register(rootCmd *cobra.Command) error {
 	// Register subcommands.
-	defs := load()
+	defs, collisions := load()
+	for _, c := range collisions {
+		logging.Log.Warnf("%s\n", c)
+	}
 	for _, def := range defs {
-		cmd := &cobra.Command{
-			Use:   def.Name,
-			Long:  def.LongDescription,
-			Short: def.ShortDescription,
-			RunE: func(cmd *cobra.Command, args []string) error {
-				return execute(cmd, args, def)
-			},
-		}
-		cmd.Flags().BoolP("all", "a", false, "include all files, even those in descendant modules")
-		rootCmd.AddCommand(cmd)
+		rootCmd.AddCommand(newCommand(def))
 	}
+	rootCmd.AddCommand(newCommandsCommand(defs, collisions))
+	rootCmd.AddCommand(newServeCommand(defs))
 	return nil
 }
+
+// newCommand builds the cobra command that executes the given definition.
+func newCommand(def *Definition) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   def.Name,
+		Long:  def.LongDescription,
+		Short: def.ShortDescription,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return execute(cmd, args, def)
+		},
+	}
+	addExecutionFlags(cmd)
+	return cmd
+}
+
+// NewRunCommand builds `vyb run`, which executes an ad-hoc command
+// definition loaded from a .vyb file instead of one registered at start-up.
+func NewRunCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run <definition-file> [target...]",
+		Short: "Execute a command definition from a .vyb file",
+		Long: `Loads a single command definition from the given .vyb file and executes
+it exactly like a registered command. This is handy when iterating on a
+custom prompt without installing it into $VYB_HOME/cmd.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			def, err := LoadDefinition(args[0])
+			if err != nil {
+				return err
+			}
+			return execute(cmd, args[1:], def)
+		},
+	}
+	addExecutionFlags(cmd)
+	return cmd
+}
+
+// addExecutionFlags registers the flags understood by execute.
+func addExecutionFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("all", "a", false, "include all files, even those in descendant modules")
+	cmd.Flags().Int("depth", 0, "include the files of descendant modules up to this many levels below the target module; deeper ones only contribute their public context")
+	cmd.Flags().Bool("plan", false, "review summary, diff, validation and token usage before applying changes")
+	cmd.Flags().BoolP("yes", "y", false, "with --plan, apply the plan without asking for confirmation")
+	cmd.Flags().Bool("dry-run", false, "print the diff of the proposed changes without writing any file")
+	cmd.Flags().BoolP("interactive", "i", false, "review every proposed file, choosing to apply or skip it")
+	cmd.Flags().String("output", outputFiles, "how proposals are delivered: \"files\" applies them, \"patch\" prints a patch for git apply instead")
+	cmd.Flags().Int("chunk-size", 0, "apply the proposal in chunks of this many files, each synced to disk before the next; an interrupted application is resumed with `vyb apply`")
+	cmd.Flags().Bool("recent", false, "prioritize recently modified files when the file token budget applies")
+	cmd.Flags().String("working-dir", "", "working directory of the command, instead of the current one; must be within the project")
+	cmd.Flags().String("provider", "", fmt.Sprintf("LLM provider (%s) used for this run only, instead of the configured one", strings.Join(llm.SupportedProviders(), ", ")))
+	cmd.Flags().String("model-size", "", "model size (small or large) used for this run only, instead of the one of the command")
+	cmd.Flags().BoolP("verbose", "v", false, "print the time spent in every stage of the command once it completes")
+}
+
+// newCommandsCommand builds `vyb commands`, which lists the registered
+// AI-driven commands, where each one is defined, and which built-ins are
+// shadowed by a user definition.
+func newCommandsCommand(defs []*Definition, collisions []Collision) *cobra.Command {
+	return &cobra.Command{
+		Use:   "commands",
+		Short: "List the AI-driven commands and where they are defined",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			shadowed := make(map[string]Collision, len(collisions))
+			for _, c := range collisions {
+				shadowed[c.Name] = c
+			}
+			var rows [][]string
+			for _, def := range defs {
+				note := ""
+				if c, ok := shadowed[def.Name]; ok {
+					if c.ShadowsBuiltin() {
+						note = "shadows built-in"
+					} else {
+						note = "overrides another " + string(c.Shadowed[0]) + " definition"
+					}
+				}
+				rows = append(rows, []string{def.Name, string(def.Source), note})
+			}
+			ui.NewAuto(cmd.OutOrStdout()).Table([]string{"NAME", "SOURCE", "NOTE"}, rows)
+		},
+	}
+}
@@ -0,0 +1,691 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/backup"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func Test_filterToTargetModule(t *testing.T) {
+	// Root module owns files spread across several directories (collapsed
+	// into it because they are small) plus one nested module "pkg".
+	root := &project.Module{Name: "."}
+	pkg := &project.Module{Name: "pkg", Parent: root}
+	root.Modules = []*project.Module{pkg}
+
+	files := []string{
+		"README.md",
+		"main.go",
+		"docs/guide.md",
+		"scripts/build.sh",
+		"pkg/lib.go",
+	}
+
+	cases := []struct {
+		name      string
+		targetDir string
+		scopeRoot bool
+		want      []string
+	}{
+		{
+			name:      "root target keeps only files in the root directory",
+			targetDir: ".",
+			scopeRoot: true,
+			want:      []string{"README.md", "main.go"},
+		},
+		{
+			name:      "root-module directory keeps only its own files",
+			targetDir: "docs",
+			scopeRoot: true,
+			want:      []string{"docs/guide.md"},
+		},
+		{
+			name:      "nested module keeps its files",
+			targetDir: "pkg",
+			scopeRoot: true,
+			want:      []string{"pkg/lib.go"},
+		},
+		{
+			name:      "untargeted root keeps the whole root module",
+			targetDir: ".",
+			want:      []string{"README.md", "main.go", "docs/guide.md", "scripts/build.sh"},
+		},
+		{
+			name:      "untargeted root-module directory keeps the whole root module",
+			targetDir: "docs",
+			want:      []string{"README.md", "main.go", "docs/guide.md", "scripts/build.sh"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := filterToTargetModule(root, c.targetDir, files, c.scopeRoot)
+			if diff := cmp.Diff(c.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Fatalf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_filterToModuleDepth(t *testing.T) {
+	// . > pkg > pkg/a > pkg/a/b, plus pkg/c next to pkg/a.
+	root := &project.Module{Name: "."}
+	pkg := &project.Module{Name: "pkg", Parent: root}
+	a := &project.Module{Name: "pkg/a", Parent: pkg}
+	b := &project.Module{Name: "pkg/a/b", Parent: a}
+	c := &project.Module{Name: "pkg/c", Parent: pkg}
+	root.Modules = []*project.Module{pkg}
+	pkg.Modules = []*project.Module{a, c}
+	a.Modules = []*project.Module{b}
+
+	files := []string{"main.go", "pkg/p.go", "pkg/a/a.go", "pkg/a/b/b.go", "pkg/c/c.go"}
+	want := map[int][]string{
+		0: {"pkg/p.go"},
+		1: {"pkg/p.go", "pkg/a/a.go", "pkg/c/c.go"},
+		2: {"pkg/p.go", "pkg/a/a.go", "pkg/a/b/b.go", "pkg/c/c.go"},
+	}
+	// The modules below the cutoff contribute their public context only.
+	wantContexts := map[int][]string{
+		0: {"pkg/a", "pkg/c"},
+		1: {"pkg/a/b"},
+		2: nil,
+	}
+	for depth := 0; depth <= 2; depth++ {
+		got := filterToModuleDepth(root, "pkg", files, depth, true)
+		if diff := cmp.Diff(want[depth], got); diff != "" {
+			t.Fatalf("depth %d (-want +got):\n%s", depth, diff)
+		}
+		var names []string
+		for _, m := range modulesAtDepth(pkg, depth+1) {
+			names = append(names, m.Name)
+		}
+		if diff := cmp.Diff(wantContexts[depth], names); diff != "" {
+			t.Fatalf("depth %d contexts (-want +got):\n%s", depth, diff)
+		}
+	}
+
+	// Depth 0 at the root keeps only the files of the root directory.
+	if diff := cmp.Diff([]string{"main.go"}, filterToModuleDepth(root, ".", files, 0, true)); diff != "" {
+		t.Fatalf("root (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecute_PromptAffixes(t *testing.T) {
+	setupWorkspace(t, map[string]string{
+		"main.go":          "package main\n",
+		".vyb/config.yaml": "provider: openai\nprompt_prefix: Use British spelling.\nprompt_suffix: Never add new dependencies.\n",
+	})
+	sysMsg := fakeProvider(t, &payload.WorkspaceChangeProposal{})
+
+	cmd := newCommand(&Definition{Name: "code", Prompt: "TASK PROMPT", ArgInclusionPatterns: []string{"*"}})
+	cmd.SetArgs([]string{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := *sysMsg
+	prefix := strings.Index(got, "<!-- BEGIN prompt_prefix -->\nUse British spelling.\n<!-- END prompt_prefix -->")
+	task := strings.Index(got, "TASK PROMPT")
+	suffix := strings.Index(got, "<!-- BEGIN prompt_suffix -->\nNever add new dependencies.\n<!-- END prompt_suffix -->")
+	if prefix != 0 || task < 0 || suffix < task {
+		t.Fatalf("expected delimited prefix, task prompt and suffix in order, got:\n%s", got)
+	}
+}
+
+func TestExecute_Quiet(t *testing.T) {
+	run := func(quiet bool) string {
+		setupWorkspace(t, map[string]string{
+			"main.go": "package main\n",
+		})
+		scriptedProvider(t, &payload.WorkspaceChangeProposal{
+			Summary:   "Add main",
+			Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n\nfunc main() {}\n"}},
+		})
+		ui.SetQuiet(quiet)
+		t.Cleanup(func() { ui.SetQuiet(false) })
+
+		var out bytes.Buffer
+		cmd := newCommand(&Definition{Name: "code", ArgInclusionPatterns: []string{"*.go"}, ModificationInclusionPatterns: []string{"*.go"}})
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"main.go"})
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out.String()
+	}
+
+	if got := run(false); !strings.Contains(got, "Files included in the request") {
+		t.Fatalf("expected the files of the request to be listed, got:\n%s", got)
+	}
+	got := run(true)
+	if strings.Contains(got, "Files included in the request") || strings.Contains(got, "<-- TARGET") {
+		t.Fatalf("expected quiet mode to leave out the files of the request, got:\n%s", got)
+	}
+	for _, want := range []string{"Add main", "Applied 1 file change(s)."} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected quiet output to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestExecute_Usage(t *testing.T) {
+	setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	old := getWorkspaceChangeProposals
+	getWorkspaceChangeProposals = func(_ context.Context, _ *config.Config, _ config.ModelFamily, _ config.ModelSize, _ string, _ *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		return &payload.WorkspaceChangeProposal{Summary: "Nothing to do"}, payload.UsageStats{Calls: 1, PromptTokens: 1500, CompletionTokens: 20, Cost: 0.5}, nil
+	}
+	t.Cleanup(func() { getWorkspaceChangeProposals = old })
+
+	var out bytes.Buffer
+	cmd := newCommand(&Definition{Name: "code", ArgInclusionPatterns: []string{"*.go"}, ModificationInclusionPatterns: []string{"*.go"}})
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"main.go"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "LLM usage: 1,500 prompt + 20 completion tokens (~$0.50)."; !strings.Contains(out.String(), want) {
+		t.Fatalf("expected output to contain %q, got:\n%s", want, out.String())
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	defPath := filepath.Join(t.TempDir(), "adhoc.vyb")
+	def := "prompt: ADHOC PROMPT\nargInclusionPatterns: [\"*\"]\nmodificationInclusionPatterns: [\"*.go\"]\n"
+	if err := os.WriteFile(defPath, []byte(def), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	sysMsg := fakeProvider(t, &payload.WorkspaceChangeProposal{
+		Summary: "feat: greet",
+		Proposals: []payload.FileChangeProposal{
+			{FileName: "main.go", Content: "package main\n\n// hello\n"},
+		},
+	})
+
+	cmd := NewRunCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{defPath, "main.go"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(*sysMsg, "ADHOC PROMPT") {
+		t.Fatalf("expected ad-hoc prompt in system message, got:\n%s", *sysMsg)
+	}
+	content, _ := os.ReadFile(filepath.Join(root, "main.go"))
+	if !strings.Contains(string(content), "// hello") {
+		t.Fatalf("expected proposal to be applied, got:\n%s", content)
+	}
+	if m, err := backup.Latest(root); err != nil || len(m.Files) != 1 || m.Files[0].Action != backup.Modified {
+		t.Fatalf("expected the overwritten file to be backed up, got %+v (%v)", m, err)
+	}
+}
+
+// TestRegister_DryRunFlag ensures every registered template command accepts
+// --dry-run.
+func TestRegister_DryRunFlag(t *testing.T) {
+	t.Setenv("VYB_HOME", t.TempDir())
+
+	rootCmd := &cobra.Command{Use: "vyb"}
+	if err := Register(rootCmd); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	defs, _ := load()
+	if len(defs) == 0 {
+		t.Fatalf("expected embedded command definitions")
+	}
+	for _, def := range defs {
+		cmd, _, err := rootCmd.Find([]string{def.Name})
+		if err != nil || cmd.Name() != def.Name {
+			t.Fatalf("command %q not registered", def.Name)
+		}
+		if cmd.Flags().Lookup("dry-run") == nil {
+			t.Fatalf("command %q has no --dry-run flag", def.Name)
+		}
+	}
+}
+
+func TestApplyFileBudget_PrefersRecentFiles(t *testing.T) {
+	root := t.TempDir()
+	content := "package main\n\n// some shared helper code\n"
+	for _, name := range []string{"old.go", "new.go"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(root, "old.go"), now, now.Add(-48*time.Hour)); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	// Outside of a git repository recency comes from the file mtime.
+	old := gitModTimes
+	gitModTimes = func(string) (map[string]time.Time, error) { return nil, errors.New("not a git repository") }
+	t.Cleanup(func() { gitModTimes = old })
+
+	// The budget only fits one of the two (equally sized) files.
+	budget, err := project.CountTokens(content)
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+
+	rootFS := os.DirFS(root)
+	files := sortByRecency(rootFS, root, []string{"old.go", "new.go"})
+	kept, dropped, err := applyFileBudget(rootFS, files, nil, budget, project.DefaultEncoding)
+	if err != nil {
+		t.Fatalf("applyFileBudget: %v", err)
+	}
+	if diff := cmp.Diff([]string{"new.go"}, kept); diff != "" {
+		t.Fatalf("kept (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"old.go"}, dropped); diff != "" {
+		t.Fatalf("dropped (-want +got):\n%s", diff)
+	}
+
+	// The pinned target is kept even when it is the oldest file.
+	kept, _, err = applyFileBudget(rootFS, files, []string{"old.go"}, budget, project.DefaultEncoding)
+	if err != nil {
+		t.Fatalf("applyFileBudget: %v", err)
+	}
+	if diff := cmp.Diff([]string{"old.go"}, kept); diff != "" {
+		t.Fatalf("kept with pinned target (-want +got):\n%s", diff)
+	}
+}
+
+func Test_prepareExecutionContext_WorkingDir(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go":     "package main",
+		"api/api.go":  "package api",
+		"api/v1/v.go": "package v1",
+	})
+
+	ec, err := prepareExecutionContext("api", nil)
+	if err != nil {
+		t.Fatalf("prepareExecutionContext returned error: %v", err)
+	}
+	want := filepath.Join(root, "api")
+	if ec.ProjectRoot != root || ec.WorkingDir != want || ec.TargetDir != want {
+		t.Fatalf("unexpected execution context %+v", ec)
+	}
+
+	target := filepath.Join("api", "v1", "v.go")
+	ec, err = prepareExecutionContext("api", []string{target})
+	if err != nil {
+		t.Fatalf("prepareExecutionContext with target returned error: %v", err)
+	}
+	if ec.WorkingDir != want || ec.TargetDir != filepath.Join(root, "api", "v1") {
+		t.Fatalf("unexpected execution context %+v", ec)
+	}
+
+	outside := t.TempDir()
+	if _, err := prepareExecutionContext(outside, nil); err == nil || !strings.Contains(err.Error(), "not within projectRoot") {
+		t.Fatalf("expected an error for a working dir outside the project, got %v", err)
+	}
+	if _, err := prepareExecutionContext("missing", nil); err == nil {
+		t.Fatal("expected an error for a missing working dir")
+	}
+}
+
+func TestExecute_DirTarget(t *testing.T) {
+	setupWorkspace(t, map[string]string{
+		"main.go":        "package main",
+		"pkg/a.go":       "package pkg",
+		"pkg/b.go":       "package pkg",
+		"pkg/sub/c.go":   "package sub",
+		"other/other.go": "package other",
+	})
+	scriptedProvider(t, &payload.WorkspaceChangeProposal{Summary: "Nothing to do"})
+
+	var out bytes.Buffer
+	cmd := newCommand(&Definition{Name: "code", ArgInclusionPatterns: []string{"*.go"}, ModificationInclusionPatterns: []string{"*.go"}})
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"pkg", "--all"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"pkg/a.go <-- TARGET", "pkg/b.go <-- TARGET", "  pkg/sub/c.go\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "other.go") || strings.Contains(got, "main.go") {
+		t.Fatalf("expected only the files of the target directory, got:\n%s", got)
+	}
+}
+
+func Test_prepare_MultipleTargets(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		"main.go":            "package main",
+		"pkg/doc.go":         "package pkg",
+		"pkg/a/a.go":         "package a",
+		"pkg/a/other.go":     "package a",
+		"pkg/b/b_test.go":    "package b",
+		"pkg/b/unrelated.md": "# notes",
+	})
+	targets := []string{"pkg/a/a.go", "pkg/b/b_test.go"}
+	ec, err := prepareExecutionContext("", targets)
+	if err != nil {
+		t.Fatalf("prepareExecutionContext: %v", err)
+	}
+	if want := filepath.Join(root, "pkg"); ec.TargetDir != want {
+		t.Fatalf("TargetDir = %s, want %s", ec.TargetDir, want)
+	}
+
+	state, err := loadWorkspaceState(root, os.DirFS(root))
+	if err != nil {
+		t.Fatalf("loadWorkspaceState: %v", err)
+	}
+	def := &Definition{Name: "code", ArgInclusionPatterns: []string{"*.go"}, ModificationInclusionPatterns: []string{"*"}}
+	req, err := prepare(&invocation{def: def, ec: ec, targets: targets}, state, os.DirFS(root))
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	for _, target := range targets {
+		if !slices.Contains(req.Files, target) {
+			t.Errorf("expected target %s in the request, got %v", target, req.Files)
+		}
+	}
+	if diff := cmp.Diff(targets, req.Request.TargetFiles); diff != "" {
+		t.Fatalf("TargetFiles (-want +got):\n%s", diff)
+	}
+
+	// Every target is validated against the argument inclusion patterns.
+	_, err = prepare(&invocation{def: def, ec: ec, targets: []string{"pkg/a/a.go", "pkg/b/unrelated.md"}}, state, os.DirFS(root))
+	if err == nil || !strings.Contains(err.Error(), "pkg/b/unrelated.md") {
+		t.Fatalf("expected an error naming the unsupported target, got %v", err)
+	}
+}
+
+func Test_prepare_ExcludedTargets(t *testing.T) {
+	root := setupWorkspace(t, map[string]string{
+		".vybignore":          "gen.go\n",
+		"main.go":             "package main",
+		"main_test.go":        "package main",
+		"other_test.go":       "package main",
+		"gen.go":              "package main",
+		"vendor/lib/lib.go":   "package lib",
+		"vendor/lib/extra.go": "package lib",
+	})
+	state, err := loadWorkspaceState(root, os.DirFS(root))
+	if err != nil {
+		t.Fatalf("loadWorkspaceState: %v", err)
+	}
+	def := &Definition{
+		Name:                          "code",
+		ArgInclusionPatterns:          []string{"*"},
+		RequestExclusionPatterns:      []string{"*_test.go"},
+		ModificationInclusionPatterns: []string{"*"},
+	}
+	prepareTarget := func(target string) (*preparedRequest, error) {
+		t.Helper()
+		ec, err := prepareExecutionContext("", []string{target})
+		if err != nil {
+			t.Fatalf("prepareExecutionContext: %v", err)
+		}
+		return prepare(&invocation{def: def, ec: ec, targets: []string{target}}, state, os.DirFS(root))
+	}
+
+	// A target excluded by the request patterns of the command is included
+	// anyway, unlike the other files these patterns exclude.
+	req, err := prepareTarget("main_test.go")
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if !slices.Contains(req.Files, "main_test.go") || slices.Contains(req.Files, "other_test.go") {
+		t.Fatalf("expected the target and no other test file in the request, got %v", req.Files)
+	}
+	if diff := cmp.Diff([]string{"main_test.go"}, req.ForcedTargets); diff != "" {
+		t.Fatalf("ForcedTargets (-want +got):\n%s", diff)
+	}
+
+	// Targets hidden from vyb altogether fail with the reason.
+	for target, reason := range map[string]string{
+		"gen.go":            ".vybignore",
+		"vendor/lib/lib.go": "dependency directory",
+		".vybignore":        "never sent to the LLM",
+	} {
+		if _, err := prepareTarget(target); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("target %s: expected an error mentioning %q, got %v", target, reason, err)
+		}
+	}
+}
+
+func TestExecute_ModelOverrides(t *testing.T) {
+	setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	var gotProvider string
+	var gotSize config.ModelSize
+	old := getWorkspaceChangeProposals
+	getWorkspaceChangeProposals = func(_ context.Context, cfg *config.Config, _ config.ModelFamily, sz config.ModelSize, _ string, _ *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		gotProvider, gotSize = cfg.Provider, sz
+		return &payload.WorkspaceChangeProposal{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getWorkspaceChangeProposals = old })
+	def := &Definition{
+		Name:                          "refactor",
+		Model:                         Model{Family: config.ModelFamilyGPT, Size: config.ModelSizeSmall},
+		ArgInclusionPatterns:          []string{"*.go"},
+		ModificationInclusionPatterns: []string{"*.go"},
+	}
+
+	cmd := newCommand(def)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--provider", "Gemini", "--model-size", "large", "main.go"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotProvider != "gemini" || gotSize != config.ModelSizeLarge {
+		t.Fatalf("expected the overrides to reach the LLM call, got provider=%q size=%q", gotProvider, gotSize)
+	}
+	if def.Model.Size != config.ModelSizeSmall {
+		t.Fatalf("expected the definition to be left untouched, got size %q", def.Model.Size)
+	}
+
+	for _, args := range [][]string{{"--provider", "acme"}, {"--model-size", "huge"}} {
+		cmd := newCommand(def)
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append(args, "main.go"))
+		if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), args[1]) {
+			t.Fatalf("%v: expected an error naming the invalid value, got %v", args, err)
+		}
+	}
+}
+
+// snapshot returns the files under root, outside .vyb, with their content
+// and mode.
+func snapshot(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if d.IsDir() {
+			if rel == ".vyb" {
+				return filepath.SkipDir
+			}
+			files[rel+"/"] = ""
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[rel] = info.Mode().String() + " " + string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	return files
+}
+
+func TestApplyProposals_RollsBack(t *testing.T) {
+	proposals := []payload.FileChangeProposal{
+		{FileName: "a.go", Content: "package a // new\n"},
+		{FileName: "new/dir/n.go", Content: "package n\n"},
+		{FileName: "b.go", Delete: true},
+		{FileName: "c.sh", Content: "echo new\n"},
+	}
+	setup := func(t *testing.T) string {
+		root := t.TempDir()
+		for name, content := range map[string]string{"a.go": "package a\n", "b.go": "package b\n", "c.sh": "echo old\n"} {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if err := os.Chmod(filepath.Join(root, "c.sh"), 0755); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+		return root
+	}
+
+	t.Run("commit failure", func(t *testing.T) {
+		root := setup(t)
+		before := snapshot(t, root)
+		old := renameFile
+		calls := 0
+		// a.go and n.go are moved into place and b.go deleted before c.sh fails.
+		renameFile = func(from, to string) error {
+			if calls++; calls == 3 {
+				return errors.New("disk full")
+			}
+			return old(from, to)
+		}
+		t.Cleanup(func() { renameFile = old })
+
+		err := applyProposals(context.Background(), root, backup.Origin{}, proposals, applyOptions{})
+		if err == nil || !strings.Contains(err.Error(), "disk full") || !strings.Contains(err.Error(), "rolled back") {
+			t.Fatalf("expected the failure to be reported with the rollback, got %v", err)
+		}
+		if diff := cmp.Diff(before, snapshot(t, root)); diff != "" {
+			t.Fatalf("expected the workspace to be untouched (-before +after):\n%s", diff)
+		}
+		if _, err := backup.Latest(root); !errors.Is(err, backup.ErrNoBackup) {
+			t.Fatalf("expected the backup set to be dropped, got %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		root := setup(t)
+		before := snapshot(t, root)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var done []string
+		// Canceled once a.go and n.go are in place.
+		progress := func(n, total int, file string) {
+			if done = append(done, file); n == 2 {
+				cancel()
+			}
+		}
+		err := applyProposals(ctx, root, backup.Origin{}, proposals, applyOptions{progress: progress})
+		if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "rolled back") {
+			t.Fatalf("expected the cancellation to be reported with the rollback, got %v", err)
+		}
+		if diff := cmp.Diff([]string{"a.go", "new/dir/n.go"}, done); diff != "" {
+			t.Fatalf("unexpected progress (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(before, snapshot(t, root)); diff != "" {
+			t.Fatalf("expected the workspace to be untouched (-before +after):\n%s", diff)
+		}
+		if _, err := backup.Latest(root); !errors.Is(err, backup.ErrNoBackup) {
+			t.Fatalf("expected the backup set to be dropped, got %v", err)
+		}
+	})
+
+	t.Run("staging failure", func(t *testing.T) {
+		root := setup(t)
+		before := snapshot(t, root)
+		// a.go is a file, so a.go/sub.go cannot be created.
+		err := applyProposals(context.Background(), root, backup.Origin{}, append(slices.Clone(proposals), payload.FileChangeProposal{FileName: "a.go/sub.go", Content: "x"}), applyOptions{})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if diff := cmp.Diff(before, snapshot(t, root)); diff != "" {
+			t.Fatalf("expected the workspace to be untouched (-before +after):\n%s", diff)
+		}
+		if _, err := backup.Latest(root); !errors.Is(err, backup.ErrNoBackup) {
+			t.Fatalf("expected the backup set to be dropped, got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		root := setup(t)
+		before := snapshot(t, root)
+		if err := applyProposals(context.Background(), root, backup.Origin{}, proposals, applyOptions{}); err != nil {
+			t.Fatalf("applyProposals: %v", err)
+		}
+		after := snapshot(t, root)
+		want := map[string]string{
+			"./":           "",
+			"a.go":         "-rw-r--r-- package a // new\n",
+			"c.sh":         "-rwxr-xr-x echo new\n",
+			"new/":         "",
+			"new/dir/":     "",
+			"new/dir/n.go": "-rw-r--r-- package n\n",
+		}
+		if diff := cmp.Diff(want, after); diff != "" {
+			t.Fatalf("unexpected workspace (-want +got):\n%s", diff)
+		}
+		if _, err := backup.Restore(root); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		restored := snapshot(t, root)
+		// undo removes the created files, not their directories.
+		delete(restored, "new/")
+		delete(restored, "new/dir/")
+		if diff := cmp.Diff(before, restored); diff != "" {
+			t.Fatalf("expected undo to restore the workspace (-before +after):\n%s", diff)
+		}
+	})
+}
+
+func TestApplyProposals_PathTooLong(t *testing.T) {
+	root := t.TempDir()
+	old := maxPathLength
+	maxPathLength = len(root) + 20
+	t.Cleanup(func() { maxPathLength = old })
+
+	long := strings.Repeat("nested/", 5) + "file.go"
+	err := applyProposals(context.Background(), root, backup.Origin{}, []payload.FileChangeProposal{
+		{FileName: "short.go", Content: "package main\n"},
+		{FileName: long, Content: "package nested\n"},
+	}, applyOptions{})
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(root, long)) {
+		t.Fatalf("expected an error naming the long path, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "short.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written, got %v", err)
+	}
+
+	if got := pathLengthLimit("windows"); got != 259 {
+		t.Fatalf("pathLengthLimit(windows) = %d, want 259", got)
+	}
+}
@@ -0,0 +1,78 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stages of a command execution, in the order they run.
+const (
+	stageMetadata = "metadata"
+	stageSelect   = "select"
+	stageBuild    = "build"
+	stageProvider = "provider"
+	stageValidate = "validate"
+	stageApply    = "apply"
+)
+
+// stageOrder lists the stages in the order they are reported.
+var stageOrder = []string{stageMetadata, stageSelect, stageBuild, stageProvider, stageValidate, stageApply}
+
+// stageReporter is notified of the time spent in every stage of a command
+// invocation.
+type stageReporter interface {
+	stageDone(stage string, elapsed time.Duration)
+}
+
+// stageClock returns the current time.
+// NOTE: it is a var (not a direct call) to allow test overrides.
+var stageClock = time.Now
+
+// timeStage starts timing stage and returns the function that stops it and
+// reports the elapsed time to r. A nil r disables timing.
+func timeStage(r stageReporter, stage string) func() {
+	if r == nil {
+		return func() {}
+	}
+	start := stageClock()
+	return func() { r.stageDone(stage, stageClock().Sub(start)) }
+}
+
+// stageTimings is a stageReporter summing the time spent in every stage.
+// The steps of a command chain add up. The zero value is ready to use.
+type stageTimings struct {
+	elapsed map[string]time.Duration
+}
+
+func (t *stageTimings) stageDone(stage string, elapsed time.Duration) {
+	if t.elapsed == nil {
+		t.elapsed = make(map[string]time.Duration)
+	}
+	t.elapsed[stage] += elapsed
+}
+
+// Total returns the time spent in all stages.
+func (t *stageTimings) Total() time.Duration {
+	var total time.Duration
+	for _, d := range t.elapsed {
+		total += d
+	}
+	return total
+}
+
+// String formats the timings as a one-line breakdown, skipping the stages
+// that did not run.
+func (t *stageTimings) String() string {
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	var parts []string
+	for _, stage := range stageOrder {
+		if d, ok := t.elapsed[stage]; ok {
+			parts = append(parts, fmt.Sprintf("%s %s", stage, round(d)))
+		}
+	}
+	if len(parts) == 0 {
+		return "no stage ran"
+	}
+	return fmt.Sprintf("%s (total %s)", strings.Join(parts, ", "), round(t.Total()))
+}
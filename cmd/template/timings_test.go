@@ -0,0 +1,62 @@
+package template
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestExecute_VerboseTimings(t *testing.T) {
+	setupWorkspace(t, map[string]string{
+		"main.go": "package main\n",
+	})
+	scriptedProvider(t, &payload.WorkspaceChangeProposal{
+		Summary:   "s",
+		Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n\nfunc main() {}\n"}},
+	})
+	// Every reading of the clock advances it by a second, so every stage
+	// lasts exactly one second.
+	now := time.Unix(0, 0)
+	old := stageClock
+	stageClock = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	t.Cleanup(func() { stageClock = old })
+
+	def := &Definition{
+		Name:                          "refactor",
+		ArgInclusionPatterns:          []string{"*.go"},
+		ModificationInclusionPatterns: []string{"*.go"},
+	}
+	var out bytes.Buffer
+	cmd := newCommand(def)
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--verbose", "main.go"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Timings: metadata 1s, select 1s, build 1s, provider 1s, validate 1s, apply 1s (total 6s)"
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("expected output to contain %q, got:\n%s", want, out.String())
+	}
+}
+
+func TestStageTimings_String(t *testing.T) {
+	var timings stageTimings
+	if got := timings.String(); got != "no stage ran" {
+		t.Fatalf("String() = %q for empty timings", got)
+	}
+	// Stages are reported in execution order, and repeated stages (e.g.
+	// by the steps of a chain) add up.
+	timings.stageDone(stageProvider, 1500*time.Millisecond)
+	timings.stageDone(stageSelect, 20*time.Millisecond)
+	timings.stageDone(stageProvider, 500*time.Millisecond)
+	want := "select 20ms, provider 2s (total 2.02s)"
+	if got := timings.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+}
@@ -3,19 +3,24 @@ package template
 import (
 	"fmt"
 	"io/fs"
-	"path/filepath"
 	"strings"
 
+	"github.com/tiktoken-go/tokenizer"
 	"github.com/vybdev/vyb/llm/payload"
 	"github.com/vybdev/vyb/workspace/context"
+	"github.com/vybdev/vyb/workspace/pathutil"
 	"github.com/vybdev/vyb/workspace/project"
 )
 
 // buildWorkspaceChangeRequest composes a payload.WorkspaceChangeRequest that will be
 // sent to the LLM. It prepends module context information — as dictated
 // by the specification — before the raw file contents. Both meta and
-// meta.Modules must be non-nil.
-func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *context.ExecutionContext, filePaths []string) (*payload.WorkspaceChangeRequest, error) {
+// meta.Modules must be non-nil. The files of the modules up to depth levels
+// below the target module are expected in filePaths; the public context of
+// the modules right below them is included instead. When budget caps the
+// request tokens, files that do not fit are replaced by the internal
+// context of their module (see fitFiles).
+func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *context.ExecutionContext, filePaths []string, depth int, budget requestBudget) (*payload.WorkspaceChangeRequest, error) {
 	if meta == nil {
 		return nil, fmt.Errorf("metadata cannot be nil")
 	}
@@ -25,17 +30,8 @@ func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *conte
 
 	request := &payload.WorkspaceChangeRequest{}
 
-	// Helper to clean/normalise relative paths
-	rel := func(abs string) string {
-		if abs == "" {
-			return ""
-		}
-		r, _ := filepath.Rel(ec.ProjectRoot, abs)
-		return filepath.ToSlash(r)
-	}
-
-	workingRel := rel(ec.WorkingDir)
-	targetRel := rel(ec.TargetDir)
+	workingRel := ec.Rel(ec.WorkingDir)
+	targetRel := ec.Rel(ec.TargetDir)
 
 	request.TargetDirectory = targetRel
 
@@ -50,9 +46,12 @@ func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *conte
 	// Set target module information
 	request.TargetModule = targetMod.Name
 
-	// Set target module context (combined internal and external context)
+	// Set target module context (combined internal and external context).
+	// A module left without annotation is described by its closest
+	// annotated ancestor.
 	var targetContext strings.Builder
-	if ann := targetMod.Annotation; ann != nil {
+	if owner := project.AnnotatedAncestor(meta.Modules, targetMod); owner != nil {
+		ann := owner.Annotation
 		if ann.ExternalContext != "" {
 			targetContext.WriteString("External Context: ")
 			targetContext.WriteString(ann.ExternalContext)
@@ -74,14 +73,10 @@ func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *conte
 	var subModuleContexts []payload.ModuleContext
 
 	// Collect parent and sibling module contexts
-	isAncestor := func(a, b string) bool {
-		return a == b || (a != "." && strings.HasPrefix(b, a+"/"))
-	}
-
 	for ancestor := targetMod.Parent; ancestor != nil; ancestor = ancestor.Parent {
 		for _, child := range ancestor.Modules {
 			// Skip the target itself and all its ancestor path.
-			if isAncestor(child.Name, targetMod.Name) {
+			if pathutil.IsPathUnderDir(child.Name, targetMod.Name) {
 				continue
 			}
 			if ann := child.Annotation; ann != nil && ann.PublicContext != "" {
@@ -96,8 +91,8 @@ func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *conte
 		}
 	}
 
-	// Collect immediate sub-modules of target module
-	for _, child := range targetMod.Modules {
+	// Collect the sub-modules of target module right below depth
+	for _, child := range modulesAtDepth(targetMod, depth+1) {
 		if ann := child.Annotation; ann != nil && ann.PublicContext != "" {
 			subModuleContexts = append(subModuleContexts, payload.ModuleContext{
 				Name:    child.Name,
@@ -110,18 +105,50 @@ func buildWorkspaceChangeRequest(rootFS fs.FS, meta *project.Metadata, ec *conte
 	request.SubModuleContexts = subModuleContexts
 
 	// Append file contents
-	var files []payload.FileContent
-	for _, path := range filePaths {
-		content, err := fs.ReadFile(rootFS, path)
+	used := 0
+	if budget.Tokens > 0 {
+		n, err := contextTokens(request, budget.Encoding)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+			return nil, err
 		}
-		files = append(files, payload.FileContent{
-			Path:    path,
-			Content: string(content),
-		})
+		used = n
+	}
+	files, summaries, err := fitFiles(rootFS, meta.Modules, targetMod, filePaths, budget, used)
+	if err != nil {
+		return nil, err
 	}
 	request.Files = files
+	request.SummarizedModuleContexts = summaries
 
 	return request, nil
 }
+
+// modulesAtDepth returns the descendant modules of mod exactly depth levels
+// below it, in tree order.
+func modulesAtDepth(mod *project.Module, depth int) []*project.Module {
+	if depth == 0 {
+		return []*project.Module{mod}
+	}
+	var modules []*project.Module
+	for _, child := range mod.Modules {
+		modules = append(modules, modulesAtDepth(child, depth-1)...)
+	}
+	return modules
+}
+
+// contextTokens returns the tokens spent by the module contexts of request,
+// in the encoding enc.
+func contextTokens(request *payload.WorkspaceChangeRequest, enc tokenizer.Encoding) (int, error) {
+	contents := []string{request.TargetModuleContext}
+	for _, mc := range request.ParentModuleContexts {
+		contents = append(contents, mc.Content)
+	}
+	for _, mc := range request.SubModuleContexts {
+		contents = append(contents, mc.Content)
+	}
+	n, err := project.CountTokensFor(enc, strings.Join(contents, "\n"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens of module contexts: %w", err)
+	}
+	return n, nil
+}
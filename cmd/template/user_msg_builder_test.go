@@ -3,6 +3,7 @@ package template
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/fstest"
 
@@ -50,7 +51,7 @@ func Test_buildExtendedUserMessage(t *testing.T) {
 		TargetDir:   "w/mid/child",
 	}
 
-	req, err := buildWorkspaceChangeRequest(mfs, meta, ec, []string{"w/mid/child/file.txt"})
+	req, err := buildWorkspaceChangeRequest(mfs, meta, ec, []string{"w/mid/child/file.txt"}, 0, requestBudget{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -99,15 +100,108 @@ func Test_buildExtendedUserMessage_nilValidation(t *testing.T) {
 	}
 
 	// Test nil metadata
-	_, err := buildWorkspaceChangeRequest(mfs, nil, ec, []string{"file.txt"})
+	_, err := buildWorkspaceChangeRequest(mfs, nil, ec, []string{"file.txt"}, 0, requestBudget{})
 	if err == nil || err.Error() != "metadata cannot be nil" {
 		t.Errorf("Expected 'metadata cannot be nil' error, got: %v", err)
 	}
 
 	// Test nil modules
 	meta := &project.Metadata{Modules: nil}
-	_, err = buildWorkspaceChangeRequest(mfs, meta, ec, []string{"file.txt"})
+	_, err = buildWorkspaceChangeRequest(mfs, meta, ec, []string{"file.txt"}, 0, requestBudget{})
 	if err == nil || err.Error() != "metadata.Modules cannot be nil" {
 		t.Errorf("Expected 'metadata.Modules cannot be nil' error, got: %v", err)
 	}
 }
+
+func Test_buildWorkspaceChangeRequest_budget(t *testing.T) {
+	ref := func(name string, tokens int64) *project.FileRef {
+		return &project.FileRef{Name: name, TokenCount: tokens}
+	}
+	// root -> a (target), b, c -> c/d
+	root := &project.Module{Name: "."}
+	a := &project.Module{Name: "a", Parent: root, Files: []*project.FileRef{ref("a/t.go", 100), ref("a/u.go", 100)}}
+	b := &project.Module{Name: "b", Parent: root, Files: []*project.FileRef{ref("b/x.go", 1000)}, Annotation: &project.Annotation{InternalContext: "B internal"}}
+	c := &project.Module{Name: "c", Parent: root}
+	d := &project.Module{Name: "c/d", Parent: c, Files: []*project.FileRef{ref("c/d/y.go", 1000)}, Annotation: &project.Annotation{InternalContext: "D internal"}}
+	root.Modules = []*project.Module{a, b, c}
+	c.Modules = []*project.Module{d}
+	meta := &project.Metadata{Modules: root}
+
+	mfs := fstest.MapFS{
+		"a/t.go":   &fstest.MapFile{Data: []byte("t")},
+		"a/u.go":   &fstest.MapFile{Data: []byte("u")},
+		"b/x.go":   &fstest.MapFile{Data: []byte("x")},
+		"c/d/y.go": &fstest.MapFile{Data: []byte("y")},
+	}
+	ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "a"}
+	selected := []string{"c/d/y.go", "b/x.go", "a/u.go", "a/t.go"}
+
+	paths := func(files []payload.FileContent) []string {
+		var out []string
+		for _, f := range files {
+			out = append(out, f.Path)
+		}
+		return out
+	}
+
+	// Everything fits: files are ordered by proximity to the target.
+	req, err := buildWorkspaceChangeRequest(mfs, meta, ec, selected, 0, requestBudget{Tokens: 100000, Targets: []string{"a/t.go"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := paths(req.Files), []string{"a/t.go", "a/u.go", "b/x.go", "c/d/y.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Files = %v, want %v", got, want)
+	}
+	if len(req.SummarizedModuleContexts) != 0 {
+		t.Errorf("expected no summarized module, got %+v", req.SummarizedModuleContexts)
+	}
+
+	// The furthest file is replaced by the internal context of its module.
+	req, err = buildWorkspaceChangeRequest(mfs, meta, ec, selected, 0, requestBudget{Tokens: 1500, Targets: []string{"a/t.go"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := paths(req.Files), []string{"a/t.go", "a/u.go", "b/x.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Files = %v, want %v", got, want)
+	}
+	wantSummaries := []payload.ModuleContext{{Name: "c/d", Content: "D internal"}}
+	if !reflect.DeepEqual(req.SummarizedModuleContexts, wantSummaries) {
+		t.Errorf("SummarizedModuleContexts = %+v, want %+v", req.SummarizedModuleContexts, wantSummaries)
+	}
+
+	// Not even the target and the summaries fit: the largest files are reported.
+	_, err = buildWorkspaceChangeRequest(mfs, meta, ec, selected, 0, requestBudget{Tokens: 50, Targets: []string{"a/t.go"}})
+	if err == nil {
+		t.Fatalf("expected a budget error")
+	}
+	for _, want := range []string{"b/x.go (1000 tokens)", "c/d/y.go (1000 tokens)", "max_request_tokens"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func Test_buildWorkspaceChangeRequest_unannotatedTarget(t *testing.T) {
+	// root -> small (below annotation.min_module_tokens, left unannotated)
+	root := &project.Module{Name: ".", Annotation: &project.Annotation{InternalContext: "Root internal", PublicContext: "Root public"}}
+	small := &project.Module{Name: "small", Parent: root, Files: []*project.FileRef{{Name: "small/s.go", TokenCount: 10}}}
+	root.Modules = []*project.Module{small}
+	meta := &project.Metadata{Modules: root}
+	mfs := fstest.MapFS{"small/s.go": &fstest.MapFile{Data: []byte("package small")}}
+	ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "small"}
+
+	req, err := buildWorkspaceChangeRequest(mfs, meta, ec, []string{"small/s.go"}, 0, requestBudget{Tokens: 100000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.TargetModule != "small" {
+		t.Errorf("TargetModule = %q, want small", req.TargetModule)
+	}
+	// The target is described by its closest annotated ancestor.
+	if req.TargetModuleContext != "Internal Context: Root internal" {
+		t.Errorf("TargetModuleContext = %q", req.TargetModuleContext)
+	}
+	if len(req.Files) != 1 || req.Files[0].Path != "small/s.go" {
+		t.Errorf("expected the target file in the request, got %+v", req.Files)
+	}
+}
@@ -0,0 +1,263 @@
+package template
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/context"
+	"github.com/vybdev/vyb/workspace/matcher"
+)
+
+// ValidationContext is what a ProposalValidator may inspect besides the
+// request and the proposal.
+type ValidationContext struct {
+	// RootFS is rooted at the project root and holds the current version of
+	// every file.
+	RootFS fs.FS
+	Exec   *context.ExecutionContext
+	// Command is the definition of the command that asked for the proposal.
+	Command *Definition
+}
+
+// Violation reports a proposed file change rejected by a ProposalValidator.
+type Violation struct {
+	FileName string
+	// Rule names the rule rejecting the change.
+	Rule   string
+	Reason string
+}
+
+// ProposalValidator checks a change proposal before it is applied. A
+// proposal with violations is not applied.
+type ProposalValidator interface {
+	Validate(ctx ValidationContext, req *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation
+}
+
+// ProposalValidatorFunc adapts a function to the ProposalValidator
+// interface.
+type ProposalValidatorFunc func(ctx ValidationContext, req *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation
+
+func (f ProposalValidatorFunc) Validate(ctx ValidationContext, req *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation {
+	return f(ctx, req, proposal)
+}
+
+// registeredValidators holds the validators added with RegisterValidator.
+var registeredValidators []ProposalValidator
+
+// RegisterValidator adds v to the validators run on every proposal, after the
+// built-in ones. It is meant for programs embedding vyb's commands, and must
+// be called before the commands are executed.
+func RegisterValidator(v ProposalValidator) {
+	registeredValidators = append(registeredValidators, v)
+}
+
+// proposalValidators returns the validators run on every proposal: the
+// command checks, the optional rules enabled in cfg and the registered
+// validators.
+func proposalValidators(cfg config.Validation) []ProposalValidator {
+	validators := []ProposalValidator{commandValidator{}}
+	if cfg.Enabled(config.RuleGeneratedFiles) {
+		validators = append(validators, generatedFilesValidator{})
+	}
+	if cfg.Enabled(config.RuleTestPairing) {
+		validators = append(validators, testPairingValidator{patterns: cfg.TestPairingPatterns})
+	}
+	if cfg.Enabled(config.RuleLicenseHeader) {
+		validators = append(validators, licenseHeaderValidator{header: cfg.LicenseHeader})
+	}
+	return append(validators, registeredValidators...)
+}
+
+// validateProposals runs validators on proposal and returns the validation
+// of every proposed file, in order.
+func validateProposals(validators []ProposalValidator, ctx ValidationContext, req *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []proposalValidation {
+	violations := make(map[string][]Violation)
+	for _, v := range validators {
+		for _, violation := range v.Validate(ctx, req, proposal) {
+			violations[violation.FileName] = append(violations[violation.FileName], violation)
+		}
+	}
+
+	var validations []proposalValidation
+	for _, prop := range proposal.Proposals {
+		v := proposalValidation{FileName: prop.FileName, Allowed: true}
+		var rules, reasons []string
+		for _, violation := range violations[prop.FileName] {
+			rules = append(rules, violation.Rule)
+			reasons = append(reasons, violation.Reason)
+		}
+		if len(reasons) > 0 {
+			v.Allowed = false
+			v.Rule = strings.Join(rules, ", ")
+			v.Reason = strings.Join(reasons, "; ")
+		}
+		validations = append(validations, v)
+	}
+	return validations
+}
+
+// commandRule names the checks derived from the command definition.
+const commandRule = "command"
+
+// commandValidator checks every proposed file against the command's
+// modification patterns, ensures it resides within the working directory and
+// that deletions are allowed by the command.
+type commandValidator struct{}
+
+func (commandValidator) Validate(ctx ValidationContext, _ *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation {
+	def := ctx.Command
+	var violations []Violation
+	for _, prop := range proposal.Proposals {
+		var reason string
+		// 1. Pattern based validation (existing behaviour).
+		if !matcher.IsIncluded(ctx.RootFS, prop.FileName, append(systemExclusionPatterns, def.ModificationExclusionPatterns...), def.ModificationInclusionPatterns) {
+			reason = "not allowed by modification patterns"
+		} else if !ctx.Exec.UnderWorking(prop.FileName) {
+			// 2. Must reside within the working_dir.
+			reason = "outside working_dir"
+		} else if prop.Delete && !def.AllowDelete {
+			// 3. Deletions require the command to opt in.
+			reason = "deletion not allowed by command"
+		}
+		if reason != "" {
+			violations = append(violations, Violation{FileName: prop.FileName, Rule: commandRule, Reason: reason})
+		}
+	}
+	return violations
+}
+
+// generatedMarker matches the markers of generated files: "@generated" and
+// Go's "Code generated ... DO NOT EDIT." convention.
+var generatedMarker = regexp.MustCompile(`@generated|(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// generatedFilesValidator implements config.RuleGeneratedFiles.
+type generatedFilesValidator struct{}
+
+func (generatedFilesValidator) Validate(ctx ValidationContext, _ *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation {
+	var violations []Violation
+	for _, prop := range proposal.Proposals {
+		current, err := fs.ReadFile(ctx.RootFS, prop.FileName)
+		if err != nil || !generatedMarker.Match(current) {
+			continue
+		}
+		violations = append(violations, Violation{FileName: prop.FileName, Rule: config.RuleGeneratedFiles, Reason: "file is generated"})
+	}
+	return violations
+}
+
+// sourceExtensions lists the extensions of the files the test-pairing and
+// license-header rules apply to.
+var sourceExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true,
+	".java": true, ".kt": true, ".rb": true, ".rs": true, ".c": true, ".cc": true,
+	".cpp": true, ".h": true, ".cs": true, ".php": true, ".swift": true, ".scala": true,
+}
+
+// isNewSourceFile reports whether prop creates a source file that does not
+// exist yet.
+func isNewSourceFile(rootFS fs.FS, prop payload.FileChangeProposal) bool {
+	if prop.Delete || !sourceExtensions[path.Ext(prop.FileName)] {
+		return false
+	}
+	_, err := fs.Stat(rootFS, prop.FileName)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// testPairingValidator implements config.RuleTestPairing.
+type testPairingValidator struct {
+	// patterns restricts the rule to the matching files. Empty means every
+	// file.
+	patterns []string
+}
+
+func (v testPairingValidator) Validate(ctx ValidationContext, _ *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation {
+	proposed := make(map[string]bool)
+	for _, prop := range proposal.Proposals {
+		if !prop.Delete {
+			proposed[prop.FileName] = true
+		}
+	}
+
+	var violations []Violation
+	for _, prop := range proposal.Proposals {
+		if !isNewSourceFile(ctx.RootFS, prop) || isTestFile(prop.FileName) {
+			continue
+		}
+		if len(v.patterns) > 0 && !matcher.IsIncluded(ctx.RootFS, prop.FileName, nil, v.patterns) {
+			continue
+		}
+		paired := false
+		for _, candidate := range testFileCandidates(prop.FileName) {
+			if _, err := fs.Stat(ctx.RootFS, candidate); proposed[candidate] || err == nil {
+				paired = true
+				break
+			}
+		}
+		if !paired {
+			violations = append(violations, Violation{FileName: prop.FileName, Rule: config.RuleTestPairing, Reason: "new file without a matching test"})
+		}
+	}
+	return violations
+}
+
+// isTestFile reports whether name follows a common test file naming
+// convention.
+func isTestFile(name string) bool {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(path.Base(name), ext)
+	return strings.HasSuffix(base, "_test") || strings.HasSuffix(base, ".test") ||
+		strings.HasSuffix(base, ".spec") || strings.HasPrefix(base, "test_") ||
+		strings.HasSuffix(base, "Test")
+}
+
+// testFileCandidates returns the paths a test of name may have, following
+// common naming conventions.
+func testFileCandidates(name string) []string {
+	dir, file := path.Split(name)
+	ext := path.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+	if ext == ".go" {
+		return []string{dir + base + "_test.go"}
+	}
+	return []string{
+		dir + base + "_test" + ext,
+		dir + base + ".test" + ext,
+		dir + base + ".spec" + ext,
+		dir + "test_" + base + ext,
+		dir + base + "Test" + ext,
+	}
+}
+
+// licenseHeaderSlack is the number of lines (shebang, build constraints, …)
+// allowed above the license header.
+const licenseHeaderSlack = 3
+
+// licenseHeaderValidator implements config.RuleLicenseHeader.
+type licenseHeaderValidator struct {
+	header string
+}
+
+func (v licenseHeaderValidator) Validate(ctx ValidationContext, _ *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation {
+	header := strings.TrimSpace(v.header)
+	lines := strings.Count(header, "\n") + 1 + licenseHeaderSlack
+
+	var violations []Violation
+	for _, prop := range proposal.Proposals {
+		if !isNewSourceFile(ctx.RootFS, prop) {
+			continue
+		}
+		top := strings.SplitN(prop.Content, "\n", lines+1)
+		if len(top) > lines {
+			top = top[:lines]
+		}
+		if !strings.Contains(strings.Join(top, "\n"), header) {
+			violations = append(violations, Violation{FileName: prop.FileName, Rule: config.RuleLicenseHeader, Reason: "new file without the license header"})
+		}
+	}
+	return violations
+}
@@ -0,0 +1,153 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/context"
+)
+
+// validate runs the validators enabled by cfg on proposals, with a command
+// allowed to modify and delete any file of the project.
+func validate(t *testing.T, fsys fstest.MapFS, cfg config.Validation, proposals ...payload.FileChangeProposal) []proposalValidation {
+	t.Helper()
+	ctx := ValidationContext{
+		RootFS:  fsys,
+		Exec:    &context.ExecutionContext{ProjectRoot: "/p", WorkingDir: "/p", TargetDir: "/p"},
+		Command: &Definition{ModificationInclusionPatterns: []string{"*"}, AllowDelete: true},
+	}
+	return validateProposals(proposalValidators(cfg), ctx, &payload.WorkspaceChangeRequest{}, &payload.WorkspaceChangeProposal{Proposals: proposals})
+}
+
+func TestValidateProposals_Command(t *testing.T) {
+	ctx := ValidationContext{
+		RootFS:  fstest.MapFS{},
+		Exec:    &context.ExecutionContext{ProjectRoot: "/p", WorkingDir: "/p/w", TargetDir: "/p/w"},
+		Command: &Definition{ModificationInclusionPatterns: []string{"*.go"}},
+	}
+	proposal := &payload.WorkspaceChangeProposal{Proposals: []payload.FileChangeProposal{
+		{FileName: "w/a.go"},
+		{FileName: "w/a.md"},
+		{FileName: "b.go"},
+		{FileName: "w/c.go", Delete: true},
+	}}
+	got := validateProposals(proposalValidators(config.Validation{}), ctx, &payload.WorkspaceChangeRequest{}, proposal)
+	want := []proposalValidation{
+		{FileName: "w/a.go", Allowed: true},
+		{FileName: "w/a.md", Rule: commandRule, Reason: "not allowed by modification patterns"},
+		{FileName: "b.go", Rule: commandRule, Reason: "outside working_dir"},
+		{FileName: "w/c.go", Rule: commandRule, Reason: "deletion not allowed by command"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("validateProposals =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestValidateProposals_GeneratedFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"gen.pb.go": {Data: []byte("// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage p\n")},
+		"schema.ts": {Data: []byte("/* @generated */\nexport {}\n")},
+		"manual.go": {Data: []byte("package p\n// mentions Code generated ... DO NOT EDIT. inline\n")},
+	}
+	cfg := config.Validation{Rules: []string{config.RuleGeneratedFiles}}
+	got := validate(t, fsys, cfg,
+		payload.FileChangeProposal{FileName: "gen.pb.go", Content: "package p\n"},
+		payload.FileChangeProposal{FileName: "schema.ts", Delete: true},
+		payload.FileChangeProposal{FileName: "manual.go", Content: "package p\n"},
+		payload.FileChangeProposal{FileName: "new.go", Content: "// @generated\n"},
+	)
+	want := []proposalValidation{
+		{FileName: "gen.pb.go", Rule: config.RuleGeneratedFiles, Reason: "file is generated"},
+		{FileName: "schema.ts", Rule: config.RuleGeneratedFiles, Reason: "file is generated"},
+		{FileName: "manual.go", Allowed: true},
+		{FileName: "new.go", Allowed: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("validateProposals =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestValidateProposals_TestPairing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/existing.go":    {Data: []byte("package api\n")},
+		"api/old_test.go":    {Data: []byte("package api\n")},
+		"web/widget.spec.ts": {Data: []byte("")},
+	}
+	cfg := config.Validation{Rules: []string{config.RuleTestPairing}, TestPairingPatterns: []string{"api/**", "web/**"}}
+	got := validate(t, fsys, cfg,
+		payload.FileChangeProposal{FileName: "api/existing.go", Content: "package api\n"},
+		payload.FileChangeProposal{FileName: "api/tested.go", Content: "package api\n"},
+		payload.FileChangeProposal{FileName: "api/tested_test.go", Content: "package api\n"},
+		payload.FileChangeProposal{FileName: "api/old.go", Content: "package api\n"},
+		payload.FileChangeProposal{FileName: "api/untested.go", Content: "package api\n"},
+		payload.FileChangeProposal{FileName: "api/README.md", Content: "# API\n"},
+		payload.FileChangeProposal{FileName: "web/widget.ts", Content: "export {}\n"},
+		payload.FileChangeProposal{FileName: "internal/helper.go", Content: "package internal\n"},
+	)
+	want := []proposalValidation{
+		{FileName: "api/existing.go", Allowed: true},
+		{FileName: "api/tested.go", Allowed: true},
+		{FileName: "api/tested_test.go", Allowed: true},
+		{FileName: "api/old.go", Allowed: true},
+		{FileName: "api/untested.go", Rule: config.RuleTestPairing, Reason: "new file without a matching test"},
+		{FileName: "api/README.md", Allowed: true},
+		{FileName: "web/widget.ts", Allowed: true},
+		{FileName: "internal/helper.go", Allowed: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("validateProposals =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestValidateProposals_LicenseHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"old.go": {Data: []byte("package p\n")},
+	}
+	cfg := config.Validation{Rules: []string{config.RuleLicenseHeader}, LicenseHeader: "// Copyright Acme Corp.\n// SPDX-License-Identifier: MIT\n"}
+	got := validate(t, fsys, cfg,
+		payload.FileChangeProposal{FileName: "old.go", Content: "package p\n"},
+		payload.FileChangeProposal{FileName: "a.go", Content: "//go:build linux\n\n// Copyright Acme Corp.\n// SPDX-License-Identifier: MIT\n\npackage p\n"},
+		payload.FileChangeProposal{FileName: "b.go", Content: "package p\n"},
+		payload.FileChangeProposal{FileName: "c.json", Content: "{}\n"},
+	)
+	want := []proposalValidation{
+		{FileName: "old.go", Allowed: true},
+		{FileName: "a.go", Allowed: true},
+		{FileName: "b.go", Rule: config.RuleLicenseHeader, Reason: "new file without the license header"},
+		{FileName: "c.json", Allowed: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("validateProposals =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestRegisterValidator(t *testing.T) {
+	old := registeredValidators
+	t.Cleanup(func() { registeredValidators = old })
+
+	RegisterValidator(ProposalValidatorFunc(func(_ ValidationContext, _ *payload.WorkspaceChangeRequest, proposal *payload.WorkspaceChangeProposal) []Violation {
+		var violations []Violation
+		for _, prop := range proposal.Proposals {
+			if prop.Delete {
+				violations = append(violations, Violation{FileName: prop.FileName, Rule: "no-delete", Reason: "deletions are reviewed manually"})
+			}
+		}
+		return violations
+	}))
+
+	fsys := fstest.MapFS{"gen.go": {Data: []byte("// @generated\n")}}
+	got := validate(t, fsys, config.Validation{Rules: []string{config.RuleGeneratedFiles}},
+		payload.FileChangeProposal{FileName: "gen.go", Delete: true},
+		payload.FileChangeProposal{FileName: "a.go", Content: "package p\n"},
+	)
+	want := []proposalValidation{
+		{FileName: "gen.go", Rule: "generated-files, no-delete", Reason: "file is generated; deletions are reviewed manually"},
+		{FileName: "a.go", Allowed: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("validateProposals =\n%+v\nwant\n%+v", got, want)
+	}
+}
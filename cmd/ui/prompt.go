@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// ErrNotInteractive is returned by the prompt helpers when stdin is not a
+// terminal, e.g. in CI or when input is piped.
+var ErrNotInteractive = errors.New("interactive input required")
+
+// RequireInteractive returns an error wrapping ErrNotInteractive when stdin
+// is not a terminal. hint names the flag that makes the prompt unnecessary,
+// e.g. "--yes".
+//
+// Every interactive prompt must go through this check: survey fails on a
+// non-terminal stdin, and callers used to swallow that error and silently
+// pick a default.
+func RequireInteractive(hint string) error {
+	if IsTerminal(os.Stdin) {
+		return nil
+	}
+	if hint == "" {
+		return ErrNotInteractive
+	}
+	return fmt.Errorf("%w; pass %s", ErrNotInteractive, hint)
+}
+
+// Confirm asks the user a yes/no question.
+func Confirm(message, hint string) (bool, error) {
+	if err := RequireInteractive(hint); err != nil {
+		return false, err
+	}
+	ok := false
+	if err := survey.AskOne(&survey.Confirm{Message: message}, &ok); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// Select asks the user to pick one of options, def being preselected.
+func Select(message string, options []string, def, hint string) (string, error) {
+	if err := RequireInteractive(hint); err != nil {
+		return "", err
+	}
+	var selection string
+	prompt := &survey.Select{Message: message, Options: options, Default: def}
+	if err := survey.AskOne(prompt, &selection); err != nil {
+		return "", err
+	}
+	return selection, nil
+}
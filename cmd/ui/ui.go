@@ -0,0 +1,180 @@
+// Package ui provides the styled output helpers shared by vyb commands.
+//
+// Colors are only emitted when the destination writer is a terminal, the
+// NO_COLOR environment variable is unset (see https://no-color.org) and
+// colors were not disabled with the global --no-color flag. Everything
+// else – CI logs, files, pipes and test buffers – receives plain text.
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	cyan   = "\x1b[36m"
+)
+
+// colorDisabled is set by the --no-color flag.
+var colorDisabled bool
+
+// DisableColor turns colors off for every Printer created afterwards.
+func DisableColor() {
+	colorDisabled = true
+}
+
+// quiet is set by the --quiet flag.
+var quiet bool
+
+// SetQuiet suppresses, or restores, the informational output of every
+// Printer (see Printer.Info).
+func SetQuiet(q bool) {
+	quiet = q
+}
+
+// Quiet reports whether informational output is suppressed.
+func Quiet() bool {
+	return quiet
+}
+
+// ColorEnabled reports whether styled output should be written to w.
+func ColorEnabled(w io.Writer) bool {
+	if colorDisabled || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	return ok && IsTerminal(f)
+}
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Printer writes styled output to an underlying writer.
+type Printer struct {
+	w     io.Writer
+	color bool
+}
+
+// New returns a Printer writing to w, emitting ANSI colors only when color
+// is true. Most callers should use NewAuto instead.
+func New(w io.Writer, color bool) *Printer {
+	return &Printer{w: w, color: color}
+}
+
+// NewAuto returns a Printer writing to w, with colors enabled according to
+// ColorEnabled.
+func NewAuto(w io.Writer) *Printer {
+	return New(w, ColorEnabled(w))
+}
+
+func (p *Printer) style(code, text string) string {
+	if !p.color || text == "" {
+		return text
+	}
+	return code + text + reset
+}
+
+// Printf writes unstyled formatted text.
+func (p *Printer) Printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(p.w, format, args...)
+}
+
+// Info returns the Printer to use for informational output, such as
+// progress or the files sent to the LLM: p itself, or a Printer discarding
+// everything in quiet mode. Results and errors are written to p directly.
+func (p *Printer) Info() *Printer {
+	if quiet {
+		return New(io.Discard, false)
+	}
+	return p
+}
+
+// Heading writes a section title on its own line.
+func (p *Printer) Heading(text string) {
+	_, _ = fmt.Fprintln(p.w, p.style(bold+cyan, "## "+text))
+}
+
+// Success writes a line reporting a successful outcome.
+func (p *Printer) Success(format string, args ...any) {
+	_, _ = fmt.Fprintln(p.w, p.style(green, fmt.Sprintf(format, args...)))
+}
+
+// Warn writes a line reporting a non-fatal problem.
+func (p *Printer) Warn(format string, args ...any) {
+	_, _ = fmt.Fprintln(p.w, p.style(yellow, "Warning: "+fmt.Sprintf(format, args...)))
+}
+
+// Error writes a line reporting a failure.
+func (p *Printer) Error(format string, args ...any) {
+	_, _ = fmt.Fprintln(p.w, p.style(red, "Error: "+fmt.Sprintf(format, args...)))
+}
+
+// Table writes rows as left-aligned columns separated by two spaces. The
+// header row is omitted when headers is empty.
+func (p *Printer) Table(headers []string, rows [][]string) {
+	widths := make([]int, len(headers))
+	measure := func(row []string) {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	measure(headers)
+	for _, r := range rows {
+		measure(r)
+	}
+
+	format := func(row []string) string {
+		var sb strings.Builder
+		for i, cell := range row {
+			sb.WriteString(cell)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-len([]rune(cell))+2))
+			}
+		}
+		return sb.String()
+	}
+	if len(headers) > 0 {
+		_, _ = fmt.Fprintln(p.w, p.style(bold, format(headers)))
+	}
+	for _, r := range rows {
+		_, _ = fmt.Fprintln(p.w, format(r))
+	}
+}
+
+// Diff writes a unified diff, coloring additions, removals and hunk
+// headers.
+func (p *Printer) Diff(d string) {
+	for _, line := range strings.SplitAfter(d, "\n") {
+		if line == "" {
+			continue
+		}
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(text, "+++"), strings.HasPrefix(text, "---"):
+			text = p.style(bold, text)
+		case strings.HasPrefix(text, "@@"):
+			text = p.style(cyan, text)
+		case strings.HasPrefix(text, "+"):
+			text = p.style(green, text)
+		case strings.HasPrefix(text, "-"):
+			text = p.style(red, text)
+		}
+		_, _ = fmt.Fprintln(p.w, text)
+	}
+}
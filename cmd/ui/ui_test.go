@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// render exercises every helper exposed by Printer.
+func render(p *Printer) {
+	p.Heading("Files")
+	p.Table([]string{"FILE", "STATUS"}, [][]string{
+		{"main.go", "modified"},
+		{"cmd/ui/ui.go", "created"},
+	})
+	p.Success("applied %d changes", 2)
+	p.Warn("metadata is stale")
+	p.Error("could not write %s", "x.go")
+	p.Printf("plain %s\n", "text")
+	p.Diff("--- a/f.txt\n+++ b/f.txt\n@@ -1,2 +1,2 @@\n a\n-b\n+B\n")
+}
+
+func TestPrinter_Golden(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		color bool
+	}{
+		{"plain", false},
+		{"color", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			render(New(&buf, c.color))
+
+			golden := filepath.Join("testdata", c.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Fatalf("output mismatch:\ngot:\n%q\nwant:\n%q", got, want)
+			}
+		})
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	// Buffers are never terminals.
+	if ColorEnabled(&bytes.Buffer{}) {
+		t.Fatalf("expected colors to be disabled for non-file writers")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if ColorEnabled(os.Stdout) {
+		t.Fatalf("expected NO_COLOR to disable colors")
+	}
+}
+
+// pipeStdin replaces os.Stdin with the read end of a pipe for the duration
+// of the test.
+func pipeStdin(t *testing.T) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		_ = r.Close()
+		_ = w.Close()
+	})
+}
+
+func TestPrompts_NotInteractive(t *testing.T) {
+	pipeStdin(t)
+
+	if _, err := Confirm("Apply?", "--yes"); !errors.Is(err, ErrNotInteractive) || err.Error() != "interactive input required; pass --yes" {
+		t.Fatalf("Confirm: expected ErrNotInteractive naming --yes, got %v", err)
+	}
+	if _, err := Select("Provider?", []string{"a", "b"}, "a", "--provider"); !errors.Is(err, ErrNotInteractive) {
+		t.Fatalf("Select: expected ErrNotInteractive, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/workspace/backup"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var undoList bool
+var undoForce bool
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Reverts the files changed by the last applied proposal.",
+	Long: `Restores the most recent backup taken under .vyb/backups/ before a
+proposal was applied: modified and deleted files get their previous content
+back, and files created by the proposal are removed. Running it again reverts
+the proposal applied before that one.
+
+Undo refuses to run when a file of the proposal was modified since it was
+applied, as it would lose those modifications; --force reverts it anyway.
+--list shows the proposals that can be reverted, newest first.`,
+	Args: cobra.NoArgs,
+	Run:  Undo,
+}
+
+func init() {
+	undoCmd.Flags().BoolVar(&undoList, "list", false, "list the applied proposals that can be reverted, newest first")
+	undoCmd.Flags().BoolVar(&undoForce, "force", false, "revert even the files modified since the proposal was applied")
+	undoCmd.MarkFlagsMutuallyExclusive("list", "force")
+}
+
+// Undo is the cobra handler for `vyb undo`.
+func Undo(cmd *cobra.Command, _ []string) {
+	projectRoot, err := workingProjectRoot()
+	if err != nil {
+		exitWithError("Error locating project root", err)
+	}
+
+	if undoList {
+		if err := runUndoList(cmd.OutOrStdout(), projectRoot); err != nil {
+			exitWithError("Error listing backups", err)
+		}
+		return
+	}
+	if err := runUndo(cmd.OutOrStdout(), projectRoot, undoForce); err != nil {
+		exitWithError("Error restoring backup", err)
+	}
+}
+
+// workingProjectRoot returns the root of the project containing the
+// working directory.
+func workingProjectRoot() (string, error) {
+	absWorkingDir, err := filepath.Abs(".")
+	if err != nil {
+		return "", fmt.Errorf("determining working directory: %w", err)
+	}
+	distToRoot, err := project.FindDistanceToRoot(absWorkingDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(absWorkingDir, distToRoot), nil
+}
+
+// runUndo reverts the last applied proposal, unless one of its files was
+// modified since and force is false.
+func runUndo(w io.Writer, projectRoot string, force bool) error {
+	m, err := backup.Latest(projectRoot)
+	if errors.Is(err, backup.ErrNoBackup) {
+		fmt.Fprintln(w, "Nothing to undo.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !force {
+		changed, err := m.Changed(projectRoot, project.ContentHash)
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			return fmt.Errorf("modified since the proposal was applied: %s; pass --force to revert them anyway", strings.Join(changed, ", "))
+		}
+	}
+	if err := m.Revert(projectRoot); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Reverted the proposal applied at %s:\n", m.Timestamp.Format("2006-01-02 15:04:05"))
+	for _, e := range m.Files {
+		switch e.Action {
+		case backup.Created:
+			fmt.Fprintf(w, "  removed  %s\n", e.Path)
+		case backup.Deleted:
+			fmt.Fprintf(w, "  restored %s\n", e.Path)
+		default:
+			fmt.Fprintf(w, "  reverted %s\n", e.Path)
+		}
+	}
+	return nil
+}
+
+// runUndoList lists the applied proposals that can be reverted, newest
+// first, with the command they came from and the files they touched.
+func runUndoList(w io.Writer, projectRoot string) error {
+	sets, err := backup.List(projectRoot)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		fmt.Fprintln(w, "Nothing to undo.")
+		return nil
+	}
+	for _, m := range sets {
+		command := m.Command
+		if command == "" {
+			command = "unknown command"
+		}
+		fmt.Fprintf(w, "%s  %s\n", m.Timestamp.Format("2006-01-02 15:04:05"), command)
+		for _, e := range m.Files {
+			fmt.Fprintf(w, "  %-8s %s\n", e.Action, e.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/workspace/backup"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// applied backs up main.go before it is rewritten, as applying a proposal
+// of the code command does.
+func applied(t *testing.T, root string) {
+	t.Helper()
+	writeFiles(t, root, map[string]string{"main.go": "package main\n"})
+	m, err := backup.Create(root, backup.Origin{Command: "code"}, []backup.Change{{Path: "main.go"}})
+	if err != nil {
+		t.Fatalf("backup.Create: %v", err)
+	}
+	writeFiles(t, root, map[string]string{"main.go": "package main // applied\n"})
+	if err := m.RecordApplied(root, project.ContentHash); err != nil {
+		t.Fatalf("RecordApplied: %v", err)
+	}
+}
+
+func TestRunUndo(t *testing.T) {
+	root := t.TempDir()
+	applied(t, root)
+
+	var out bytes.Buffer
+	if err := runUndo(&out, root, false); err != nil {
+		t.Fatalf("runUndo: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "main.go")); string(got) != "package main\n" {
+		t.Fatalf("expected main.go to be reverted, got %q", got)
+	}
+	if !strings.Contains(out.String(), "reverted main.go") {
+		t.Fatalf("expected the reverted file to be listed, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := runUndo(&out, root, false); err != nil {
+		t.Fatalf("runUndo: %v", err)
+	}
+	if out.String() != "Nothing to undo.\n" {
+		t.Fatalf("expected nothing left to undo, got %q", out.String())
+	}
+}
+
+func TestRunUndo_ModifiedSinceApplied(t *testing.T) {
+	root := t.TempDir()
+	applied(t, root)
+	writeFiles(t, root, map[string]string{"main.go": "package main // edited by hand\n"})
+
+	err := runUndo(&bytes.Buffer{}, root, false)
+	if err == nil || !strings.Contains(err.Error(), "main.go") || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected undo to refuse naming main.go, got %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "main.go")); string(got) != "package main // edited by hand\n" {
+		t.Fatalf("expected main.go to be left alone, got %q", got)
+	}
+
+	if err := runUndo(&bytes.Buffer{}, root, true); err != nil {
+		t.Fatalf("runUndo --force: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "main.go")); string(got) != "package main\n" {
+		t.Fatalf("expected main.go to be reverted, got %q", got)
+	}
+}
+
+func TestRunUndoList(t *testing.T) {
+	root := t.TempDir()
+	var out bytes.Buffer
+	if err := runUndoList(&out, root); err != nil || out.String() != "Nothing to undo.\n" {
+		t.Fatalf("runUndoList = %q, %v", out.String(), err)
+	}
+
+	applied(t, root)
+	out.Reset()
+	if err := runUndoList(&out, root); err != nil {
+		t.Fatalf("runUndoList: %v", err)
+	}
+	if !strings.Contains(out.String(), "  code\n") || !strings.Contains(out.String(), "  modified main.go\n") {
+		t.Fatalf("expected the command and its files, got %q", out.String())
+	}
+}
@@ -1,27 +1,200 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/config"
 	"github.com/vybdev/vyb/logging"
 	"github.com/vybdev/vyb/workspace/project"
-	"os"
+	"io"
+	"unicode/utf8"
 )
 
+// maxReportDiffLength bounds the annotation diff printed for each module in
+// the text report. The full diff is available with `--output json`.
+const maxReportDiffLength = 400
+
+var updateOutput string
+var updateProvider string
+var updateModelSize string
+var updateRegenerateFrom string
+var updateRegenerateAll bool
+var updateModule string
+var updateAncestors bool
+var updateYes bool
+
+// confirmRegeneration asks whether the regeneration plan may run.
+// NOTE: confirmRegeneration is a var (not a direct call) to allow test
+// overrides.
+var confirmRegeneration = func(message string) (bool, error) {
+	return ui.Confirm(message, "--yes")
+}
+
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update the project's metadata",
 	Long: `This command updates the project's metadata.
 It will regenerate all annotations for the current project, preserving any
-existing ones that are still valid.`,
+existing ones that are still valid.
+
+--regenerate-from-provider and --regenerate-all force the regeneration of
+annotations whose files did not change, e.g. after switching provider. The
+modules to regenerate and an estimate of the tokens sent are shown, and
+confirmed, before any LLM call.
+
+--module regenerates the annotation of a single module, named by its path
+relative to the project root, e.g. after editing its files by hand. With
+--ancestors, the external contexts of its ancestors are regenerated too.`,
 	Run: Update,
 }
 
-func Update(_ *cobra.Command, _ []string) {
+func init() {
+	updateCmd.Flags().StringVar(&updateOutput, "output", "text", "report format (text or json)")
+	updateCmd.Flags().StringVar(&updateProvider, "provider", "", "LLM provider used for this run only, instead of the configured one")
+	updateCmd.Flags().StringVar(&updateModelSize, "model-size", "", "model size (small or large) used for this run only")
+	updateCmd.Flags().StringVar(&updateRegenerateFrom, "regenerate-from-provider", "", "regenerate the annotations generated by this provider")
+	updateCmd.Flags().BoolVar(&updateRegenerateAll, "regenerate-all", false, "regenerate every annotation")
+	updateCmd.Flags().StringVar(&updateModule, "module", "", "regenerate the annotation of this module")
+	updateCmd.Flags().BoolVar(&updateAncestors, "ancestors", false, "with --module, also regenerate the external contexts of its ancestors")
+	updateCmd.Flags().BoolVarP(&updateYes, "yes", "y", false, "regenerate without asking for confirmation")
+}
+
+func Update(cmd *cobra.Command, _ []string) {
+	if updateOutput != "text" && updateOutput != "json" {
+		logging.Log.Fatalf("unsupported output format %q, expected text or json", updateOutput)
+	}
+	overrides, err := updateOverrides(updateProvider, updateModelSize)
+	if err != nil {
+		exitWithError("Error updating metadata", err)
+	}
+	if updateAncestors && updateModule == "" {
+		exitWithError("Error updating metadata", errors.New("--ancestors requires --module"))
+	}
+	opts := project.UpdateOptions{
+		Overrides: overrides,
+		Regenerate: project.Regenerate{
+			All:       updateRegenerateAll,
+			Module:    updateModule,
+			Ancestors: updateAncestors,
+		},
+	}
+	if updateRegenerateFrom != "" {
+		if opts.Regenerate.FromProvider, err = parseProvider(updateRegenerateFrom); err != nil {
+			exitWithError("Error updating metadata", err)
+		}
+	}
+	if !updateYes {
+		opts.Confirm = func(plan *project.RegenerationPlan) (bool, error) {
+			return previewRegeneration(cmd.OutOrStdout(), plan)
+		}
+	}
+	progress := newAnnotationProgress(cmd.ErrOrStderr())
+	opts.Reporter = progress
 	// for now, `vyb update` only works when executed on the root of the project
-	err := project.Update(".")
+	report, err := project.UpdateWithOptions(cmd.Context(), ".", opts)
+	progress.logTimings()
+	if errors.Is(err, project.ErrUpdateDeclined) {
+		fmt.Fprintln(cmd.OutOrStdout(), "Update cancelled, nothing was changed.")
+		return
+	}
 	if err != nil {
-		logging.Log.Fatalf("Error creating metadata: %v\n", err)
-		os.Exit(1)
+		exitWithError("Error updating metadata", err)
+	}
+
+	for _, change := range report.AnnotationChanges {
+		logging.Log.Debugf("internal context diff for module %q:\n%s\n", change.Module, change.InternalContextDiff)
+	}
+
+	if updateOutput == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logging.Log.Fatalf("Error marshalling update report: %v\n", err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(data))
+		return
+	}
+
+	out := ui.NewAuto(cmd.OutOrStdout())
+	if len(report.AddedModules) > 0 || len(report.RemovedModules) > 0 {
+		out.Heading("Modules")
+		var rows [][]string
+		for _, m := range report.AddedModules {
+			rows = append(rows, []string{"  " + m, "added"})
+		}
+		for _, m := range report.RemovedModules {
+			rows = append(rows, []string{"  " + m, "removed"})
+		}
+		out.Table(nil, rows)
+	}
+	if len(report.AnnotationChanges) > 0 {
+		out.Heading("Annotation changes")
+	}
+	for _, change := range report.AnnotationChanges {
+		if change.InternalContextDiff == "" {
+			out.Printf("  %s: re-annotated, internal context unchanged.\n", change.Module)
+			continue
+		}
+		out.Printf("  %s: internal context changed:\n    %s\n", change.Module, truncate(change.InternalContextDiff, maxReportDiffLength))
+	}
+	if report.Metrics.Calls > 0 {
+		out.Info().Printf("Annotations: %s.\n", report.Metrics)
+	}
+	if report.Metrics.Usage.Calls > 0 {
+		out.Info().Printf("LLM usage: %s.\n", report.Metrics.Usage)
+	}
+	out.Success("Project metadata updated successfully.")
+}
+
+// previewRegeneration prints plan and asks whether it may run.
+func previewRegeneration(w io.Writer, plan *project.RegenerationPlan) (bool, error) {
+	out := ui.NewAuto(w)
+	out.Heading("Annotations to regenerate")
+	rows := make([][]string, 0, len(plan.Modules)+len(plan.ExternalContexts))
+	for _, m := range plan.Modules {
+		rows = append(rows, []string{"  " + m})
+	}
+	for _, m := range plan.ExternalContexts {
+		rows = append(rows, []string{"  " + m, "external context only"})
+	}
+	out.Table(nil, rows)
+	out.Printf("%d module(s), about %d tokens of file contents sent to the LLM, plus the external contexts.\n", len(plan.Modules), plan.Tokens)
+	if project.HeuristicTokenCounts() {
+		out.Warn("%s.", project.HeuristicTokensNote)
+	}
+	return confirmRegeneration("Regenerate these annotations?")
+}
+
+// updateOverrides validates the --provider and --model-size flags.
+func updateOverrides(provider, modelSize string) (config.Overrides, error) {
+	var overrides config.Overrides
+	if provider != "" {
+		p, err := parseProvider(provider)
+		if err != nil {
+			return overrides, err
+		}
+		overrides.Provider = p
+	}
+	if modelSize != "" {
+		sz, err := config.ParseModelSize(modelSize)
+		if err != nil {
+			return overrides, err
+		}
+		overrides.ModelSize = sz
+	}
+	return overrides, nil
+}
+
+// truncate shortens s to at most n bytes, marking the cut with an ellipsis.
+// The cut falls on a rune boundary, so multi-byte characters stay whole.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	logging.Log.Info("Project metadata updated successfully.")
+	return s[:n] + "…"
 }
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"abcdef", 3, "abc…"},
+		// "é" is two bytes: a cut inside it drops it whole.
+		{"café au lait", 4, "caf…"},
+		{"café au lait", 5, "café…"},
+		{"世界", 2, "…"},
+	}
+	for _, c := range cases {
+		got := truncate(c.s, c.n)
+		if got != c.want || !utf8.ValidString(got) {
+			t.Fatalf("truncate(%q, %d) = %q, want %q", c.s, c.n, got, c.want)
+		}
+	}
+}
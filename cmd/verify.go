@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/ui"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the project metadata matches the project files",
+	Long: `This command builds a fresh snapshot of the project structure, without
+calling the LLM, and compares its module hierarchy and file hashes with
+.vyb/metadata.yaml. Annotations are ignored. When they differ the diff is
+printed and the command exits with code 6, which makes it suitable for CI.
+It must be executed on the project root.`,
+	Args: cobra.NoArgs,
+	Run:  Verify,
+}
+
+func Verify(cmd *cobra.Command, _ []string) {
+	code, err := runVerify(cmd.OutOrStdout(), ".")
+	if err != nil {
+		exitWithError("Error verifying metadata", err)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// runVerify reports whether the metadata of the project at projectRoot is
+// up to date, returning the exit code to use.
+func runVerify(w io.Writer, projectRoot string) (int, error) {
+	diff, err := project.Verify(projectRoot)
+	if err != nil {
+		return 0, err
+	}
+	out := ui.NewAuto(w)
+	if diff.Empty() {
+		out.Success("Project metadata is up to date.")
+		return 0, nil
+	}
+	out.Error("Project metadata is out of date. Run 'vyb update' and commit .vyb/metadata.yaml.")
+	out.Diff(diff.String())
+	return exitOutdatedMetadata, nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// writeFiles writes files, keyed by their path relative to root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+}
+
+// writeMetadata stores a snapshot of the files under root as its
+// .vyb/metadata.yaml.
+func writeMetadata(t *testing.T, root string) {
+	t.Helper()
+	meta, err := project.BuildMetadataFS(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("BuildMetadataFS: %v", err)
+	}
+	var annotateAll func(m *project.Module)
+	annotateAll = func(m *project.Module) {
+		m.Annotation = &project.Annotation{InternalContext: "not a structural change"}
+		for _, child := range m.Modules {
+			annotateAll(child)
+		}
+	}
+	annotateAll(meta.Modules)
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	writeFiles(t, root, map[string]string{".vyb/metadata.yaml": string(data)})
+}
+
+func TestRunVerify(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":    "package main\n",
+		"pkg/lib.go": "package pkg\n",
+	})
+	writeMetadata(t, root)
+
+	var out bytes.Buffer
+	code, err := runVerify(&out, root)
+	if err != nil || code != 0 {
+		t.Fatalf("expected up-to-date metadata to exit 0, got %d, %v:\n%s", code, err, out.String())
+	}
+
+	writeFiles(t, root, map[string]string{
+		"main.go":  "package main\n\nfunc main() {}\n",
+		"extra.go": "package main\n",
+	})
+	if err := os.Remove(filepath.Join(root, "pkg", "lib.go")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	out.Reset()
+	code, err = runVerify(&out, root)
+	if err != nil {
+		t.Fatalf("runVerify: %v", err)
+	}
+	if code != exitOutdatedMetadata {
+		t.Fatalf("expected exit code %d for drifted metadata, got %d", exitOutdatedMetadata, code)
+	}
+	for _, want := range []string{"+file   extra.go", "-file   pkg/lib.go", "~file   main.go"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("diff missing %q:\n%s", want, out.String())
+		}
+	}
+}
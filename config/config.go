@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/vybdev/vyb/internal/strictyaml"
 )
 
 // Config captures user-level settings stored in .vyb/config.yaml.
@@ -17,6 +21,42 @@ import (
 // Example YAML:
 //
 //	provider: openai
+//	prompt_prefix: |
+//	  Always write Go code compatible with Go 1.22.
+//	request:
+//	  prioritize_recent: true
+//	  max_file_tokens: 50000
+//	  max_file_part_tokens: 8000
+//	  max_request_tokens: 80000
+//	validation:
+//	  rules: [generated-files, test-pairing]
+//	annotation:
+//	  max_context_tokens: 1500
+//	  model_size: large
+//	  external_context_batch_tokens: 20000
+//	  require_provider: gemini
+//	  min_module_tokens: 2000
+//	  single_call_tokens: 8000
+//	ollama:
+//	  small_model: qwen2.5-coder:7b
+//	models:
+//	  openai:
+//	    reasoning-large: o3-pro
+//	pricing:
+//	  o3-pro:
+//	    prompt: 20
+//	    completion: 80
+//	request_formats:
+//	  openai: xml
+//	output_footer:
+//	  enabled: true
+//	openai:
+//	  base_url: https://gateway.example.com/v1
+//	  api_key_env: OPENAI_PROXY_KEY
+//	http:
+//	  timeout: 5m
+//	  max_retries: 3
+//	  stream: true
 //
 // Zero-value Config is invalid – use Default() when no config file is
 // found.
@@ -30,14 +70,445 @@ import (
 type Config struct {
 	Provider string `yaml:"provider"`
 	Logging  `yaml:"logging"`
+
+	// PromptPrefix and PromptSuffix hold standing instructions (coding
+	// standards, language preferences, …) that are prepended/appended to
+	// the system message of every command.
+	PromptPrefix string `yaml:"prompt_prefix,omitempty"`
+	PromptSuffix string `yaml:"prompt_suffix,omitempty"`
+
+	// OutputFooter reminds the model of the expected response format at
+	// the end of every request.
+	OutputFooter OutputFooter `yaml:"output_footer,omitempty"`
+
+	// Request tunes how workspace change requests are assembled.
+	Request Request `yaml:"request,omitempty"`
+
+	// Annotation bounds the module contexts generated by `vyb update`.
+	Annotation Annotation `yaml:"annotation,omitempty"`
+
+	// Validation enables optional rules checked on every change proposal
+	// before it is applied.
+	Validation Validation `yaml:"validation,omitempty"`
+
+	// Modules shapes the module hierarchy built from the project files.
+	Modules Modules `yaml:"modules,omitempty"`
+
+	// Ollama configures the local models used by the "ollama" provider.
+	Ollama Ollama `yaml:"ollama,omitempty"`
+
+	// Models pins the model used by a provider for a family and size,
+	// instead of the built-in one, e.g. {openai: {reasoning-large: o3}}.
+	// Keys are "<family>-<size>".
+	Models map[string]map[string]string `yaml:"models,omitempty"`
+	// FallbackModels lists, keyed like Models, the model retried when the
+	// provider no longer serves the primary one. Without an entry, the
+	// model of the other size is retried.
+	FallbackModels map[string]map[string]string `yaml:"fallback_models,omitempty"`
+	// Pricing sets the price of models, keyed by model identifier, over
+	// the built-in prices the cost of the LLM calls is estimated from.
+	Pricing map[string]ModelPrice `yaml:"pricing,omitempty"`
+	// RequestFormats overrides, keyed by provider, the layout of the
+	// context sent to the model: "markdown" or "xml". By default Anthropic
+	// gets XML-tagged context and the other providers markdown.
+	RequestFormats map[string]string `yaml:"request_formats,omitempty"`
+
+	// OpenAI and Gemini configure the endpoints of the matching providers.
+	OpenAI Endpoint `yaml:"openai,omitempty"`
+	Gemini Endpoint `yaml:"gemini,omitempty"`
+
+	// HTTP tunes the requests sent to the LLM provider.
+	HTTP HTTP `yaml:"http,omitempty"`
+
+	// ProjectRoot is the absolute path of the project the configuration
+	// was loaded from, empty when it was not loaded from disk.
+	ProjectRoot string `yaml:"-"`
+}
+
+// ModelPrice is the price of a model, in US dollars per million tokens.
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// OutputFooter captures the reminder appended to every user message, asking
+// the model to answer only with JSON matching the schema of the request.
+// Providers enforcing the schema (OpenAI, Gemini, Anthropic) do not need it,
+// it mostly helps weaker local models that wrap their answer in code fences
+// or prose.
+type OutputFooter struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Text is appended to the default reminder.
+	Text string `yaml:"text,omitempty"`
+}
+
+// HTTP captures settings applied to every request sent to the LLM provider.
+type HTTP struct {
+	// Timeout bounds a single request, including reading the response
+	// (e.g. "90s", "10m"). Zero means DefaultHTTPTimeout, a negative value
+	// disables it.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxRetries is the number of times a request is retried after a 429 or
+	// 5xx response, with exponential backoff. Zero means DefaultMaxRetries,
+	// a negative value disables retries.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// Stream consumes the responses of the providers supporting it (OpenAI
+	// and Gemini) as they are generated, reporting the tokens received so
+	// far instead of waiting silently for the whole response.
+	Stream bool `yaml:"stream,omitempty"`
+}
+
+// DefaultHTTPTimeout is applied to provider requests when HTTP.Timeout is not
+// set. Large proposals from reasoning models can take several minutes.
+const DefaultHTTPTimeout = 10 * time.Minute
+
+// DefaultMaxRetries is applied when HTTP.MaxRetries is not set.
+const DefaultMaxRetries = 3
+
+// RequestTimeout returns the effective request timeout, or 0 when there is
+// none.
+func (h HTTP) RequestTimeout() time.Duration {
+	switch {
+	case h.Timeout < 0:
+		return 0
+	case h.Timeout == 0:
+		return DefaultHTTPTimeout
+	}
+	return h.Timeout
+}
+
+// Retries returns the effective number of retries.
+func (h HTTP) Retries() int {
+	switch {
+	case h.MaxRetries < 0:
+		return 0
+	case h.MaxRetries == 0:
+		return DefaultMaxRetries
+	}
+	return h.MaxRetries
+}
+
+// Ollama names the model tags pulled in the local Ollama server. Empty
+// fields fall back to the provider defaults.
+type Ollama struct {
+	SmallModel string `yaml:"small_model,omitempty"`
+	LargeModel string `yaml:"large_model,omitempty"`
+}
+
+// Endpoint overrides where the requests of a provider are sent.
+type Endpoint struct {
+	// BaseURL replaces the public API base URL, including its version (e.g.
+	// "https://gateway.example.com/v1"). It wins over the OPENAI_BASE_URL
+	// and GEMINI_BASE_URL environment variables.
+	BaseURL string `yaml:"base_url,omitempty"`
+	// APIKeyEnv names the environment variable holding the API key, for
+	// gateways expecting their own key (e.g. "OPENAI_PROXY_KEY"). Empty
+	// means OPENAI_API_KEY or GEMINI_API_KEY.
+	APIKeyEnv string `yaml:"api_key_env,omitempty"`
+}
+
+// Request captures settings applied when building the request payload of
+// AI-driven commands.
+type Request struct {
+	// PrioritizeRecent orders the included files by modification recency
+	// (latest git commit, or file mtime outside of a git repository), so
+	// recently changed files survive when MaxFileTokens is reached.
+	PrioritizeRecent bool `yaml:"prioritize_recent,omitempty"`
+	// MaxFileTokens caps the tokens spent on file contents. Files beyond
+	// the cap are dropped, except the command target. Zero means no cap.
+	MaxFileTokens int `yaml:"max_file_tokens,omitempty"`
+	// MaxFilePartTokens splits the files larger than this many tokens into
+	// parts of whole lines, labeled with their part number and line range,
+	// so the LLM sees large files whole but clearly segmented. Zero sends
+	// every file in one piece.
+	MaxFilePartTokens int `yaml:"max_file_part_tokens,omitempty"`
+	// MaxRequestTokens caps the tokens of the whole request. Files beyond
+	// the cap, starting with those furthest from the command target, are
+	// replaced by the internal context of their module. Zero means the
+	// default cap of the command's model size (DefaultRequestTokens), a
+	// negative value disables it.
+	MaxRequestTokens int `yaml:"max_request_tokens,omitempty"`
+	// CacheSelection stores the files selected for a request under
+	// .vyb/cache/selection/ and reuses them while the walked directories
+	// are unchanged, which speeds up repeated commands on large
+	// repositories.
+	CacheSelection bool `yaml:"cache_selection,omitempty"`
+}
+
+// DefaultRequestTokens is the cap applied to requests sent to models of each
+// size when Request.MaxRequestTokens is not set. It leaves headroom below
+// the context window of the mapped models for the system message and the
+// response.
+var DefaultRequestTokens = map[ModelSize]int{
+	ModelSizeLarge: 100000,
+	ModelSizeSmall: 60000,
+}
+
+// TokenBudget returns the effective cap on requests sent to models of size
+// sz, or 0 when there is none.
+func (r Request) TokenBudget(sz ModelSize) int {
+	switch {
+	case r.MaxRequestTokens < 0:
+		return 0
+	case r.MaxRequestTokens == 0:
+		return DefaultRequestTokens[sz]
+	}
+	return r.MaxRequestTokens
+}
+
+// Validation captures the optional rules checked on change proposals, on top
+// of the modification patterns of each command.
+type Validation struct {
+	// Rules lists the enabled rules, among ValidationRules.
+	Rules []string `yaml:"rules,omitempty"`
+	// TestPairingPatterns restricts RuleTestPairing to the new files
+	// matching these .gitignore-style patterns. Empty means every new
+	// source file.
+	TestPairingPatterns []string `yaml:"test_pairing_patterns,omitempty"`
+	// LicenseHeader is the text RuleLicenseHeader requires at the top of
+	// every new source file.
+	LicenseHeader string `yaml:"license_header,omitempty"`
+}
+
+const (
+	// RuleGeneratedFiles rejects changes to files marked as generated
+	// ("@generated", or Go's "Code generated ... DO NOT EDIT.").
+	RuleGeneratedFiles = "generated-files"
+	// RuleTestPairing rejects new source files proposed without a matching
+	// test file.
+	RuleTestPairing = "test-pairing"
+	// RuleLicenseHeader rejects new source files missing
+	// Validation.LicenseHeader.
+	RuleLicenseHeader = "license-header"
+)
+
+// ValidationRules lists the optional rules Validation.Rules may enable.
+var ValidationRules = []string{RuleGeneratedFiles, RuleTestPairing, RuleLicenseHeader}
+
+// Enabled reports whether rule is listed in Rules.
+func (v Validation) Enabled(rule string) bool {
+	for _, r := range v.Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that every rule is known and has the settings it needs.
+func (v Validation) validate() error {
+	for _, rule := range v.Rules {
+		known := false
+		for _, r := range ValidationRules {
+			known = known || r == rule
+		}
+		if !known {
+			return fmt.Errorf("unknown rule %q, expected one of %s", rule, strings.Join(ValidationRules, ", "))
+		}
+	}
+	if v.Enabled(RuleLicenseHeader) && strings.TrimSpace(v.LicenseHeader) == "" {
+		return fmt.Errorf("rule %q requires license_header", RuleLicenseHeader)
+	}
+	return nil
+}
+
+// Annotation captures settings applied to generated module annotations.
+type Annotation struct {
+	// MaxContextTokens caps the tokens of every context field returned by
+	// the LLM. Longer fields are truncated and marked as such. Zero means
+	// the default cap (DefaultMaxContextTokens), a negative value disables
+	// it.
+	MaxContextTokens int `yaml:"max_context_tokens,omitempty"`
+	// ModelSize selects the model tier used to generate annotations.
+	// Empty means ModelSizeSmall.
+	ModelSize ModelSize `yaml:"model_size,omitempty"`
+	// RequireProvider and RequireModelSize are the annotation policy of the
+	// project: annotations written by another provider or model tier are
+	// reported by `vyb doctor`. Empty means any.
+	RequireProvider  string    `yaml:"require_provider,omitempty"`
+	RequireModelSize ModelSize `yaml:"require_model_size,omitempty"`
+	// ExternalContextBatchTokens caps the tokens of the module contexts
+	// sent in a single external context request; modules are split into
+	// batches under it. Zero means DefaultExternalContextBatchTokens, a
+	// negative value sends every module at once.
+	ExternalContextBatchTokens int `yaml:"external_context_batch_tokens,omitempty"`
+	// ExternalContextWorkers bounds the external context requests sent
+	// concurrently. Zero means DefaultExternalContextWorkers.
+	ExternalContextWorkers int `yaml:"external_context_workers,omitempty"`
+	// MaxConcurrency bounds the module contexts generated concurrently.
+	// Zero means DefaultMaxConcurrency.
+	MaxConcurrency int `yaml:"max_concurrency,omitempty"`
+	// MinModuleTokens skips the annotation of the modules holding fewer
+	// tokens, the root module aside. Their files are described by the
+	// annotation of their parent instead. Zero annotates every module.
+	MinModuleTokens int `yaml:"min_module_tokens,omitempty"`
+	// SingleCallTokens annotates every module in a single call, instead
+	// of a call per module, when the files of the modules to annotate hold
+	// at most this many tokens. It saves round-trips on small projects.
+	// Zero disables it.
+	SingleCallTokens int `yaml:"single_call_tokens,omitempty"`
+}
+
+// DefaultExternalContextBatchTokens is the cap applied to external context
+// requests when Annotation.ExternalContextBatchTokens is not set. It fits
+// within the context window of the small models of every provider.
+const DefaultExternalContextBatchTokens = 30000
+
+// DefaultExternalContextWorkers is the number of external context requests
+// sent concurrently when Annotation.ExternalContextWorkers is not set.
+const DefaultExternalContextWorkers = 4
+
+// BatchTokenLimit returns the effective cap on the tokens of an external
+// context request, or 0 when there is none.
+func (a Annotation) BatchTokenLimit() int {
+	switch {
+	case a.ExternalContextBatchTokens < 0:
+		return 0
+	case a.ExternalContextBatchTokens == 0:
+		return DefaultExternalContextBatchTokens
+	}
+	return a.ExternalContextBatchTokens
+}
+
+// Workers returns the number of external context requests sent
+// concurrently.
+func (a Annotation) Workers() int {
+	if a.ExternalContextWorkers <= 0 {
+		return DefaultExternalContextWorkers
+	}
+	return a.ExternalContextWorkers
+}
+
+// DefaultMaxConcurrency is the number of module contexts generated
+// concurrently when Annotation.MaxConcurrency is not set, low enough to stay
+// clear of the rate limits of every provider.
+const DefaultMaxConcurrency = 4
+
+// Concurrency returns the number of module contexts generated
+// concurrently.
+func (a Annotation) Concurrency() int {
+	if a.MaxConcurrency <= 0 {
+		return DefaultMaxConcurrency
+	}
+	return a.MaxConcurrency
+}
+
+// DefaultMaxContextTokens is the cap applied to annotation context fields
+// when Annotation.MaxContextTokens is not set. The annotation prompt asks
+// for around one thousand tokens per field, this leaves ample headroom.
+const DefaultMaxContextTokens = 2000
+
+// ContextTokenLimit returns the effective cap on annotation context fields,
+// or 0 when there is none.
+func (a Annotation) ContextTokenLimit() int {
+	switch {
+	case a.MaxContextTokens < 0:
+		return 0
+	case a.MaxContextTokens == 0:
+		return DefaultMaxContextTokens
+	}
+	return a.MaxContextTokens
+}
+
+// Size returns the model tier used to generate annotations.
+func (a Annotation) Size() ModelSize {
+	if a.ModelSize == "" {
+		return ModelSizeSmall
+	}
+	return a.ModelSize
+}
+
+// Modules captures settings applied when grouping files into modules.
+type Modules struct {
+	// MaxDepth caps the number of path segments of a module name. The
+	// files of deeper directories belong to their ancestor at that depth.
+	// Zero means DefaultMaxModuleDepth, a negative value disables the cap.
+	MaxDepth int `yaml:"max_depth,omitempty"`
+}
+
+// DefaultMaxModuleDepth is the cap applied to module names when
+// Modules.MaxDepth is not set. Deeper directories are almost always
+// generated or vendored trees.
+const DefaultMaxModuleDepth = 12
+
+// Depth returns the maximum number of path segments of a module name, or 0
+// when there is no cap.
+func (m Modules) Depth() int {
+	switch {
+	case m.MaxDepth < 0:
+		return 0
+	case m.MaxDepth == 0:
+		return DefaultMaxModuleDepth
+	}
+	return m.MaxDepth
+}
+
+// Overrides holds command-line replacements for configuration values. They
+// apply to a single run and are never written to .vyb/config.yaml.
+type Overrides struct {
+	Provider string
+	// ModelSize replaces Annotation.ModelSize.
+	ModelSize ModelSize
+}
+
+// Apply returns a copy of cfg with the non-empty overrides applied. cfg is
+// left untouched.
+func (o Overrides) Apply(cfg *Config) *Config {
+	out := *cfg
+	if o.Provider != "" {
+		out.Provider = o.Provider
+	}
+	if o.ModelSize != "" {
+		out.Annotation.ModelSize = o.ModelSize
+	}
+	return &out
 }
 
 // Logging captures logging-specific settings.
 type Logging struct {
-	Level                string `yaml:"level"`
-	RequestResponseDebug bool   `yaml:"request-response-debug"`
+	// Level is the level of the application logs, one of LogLevels,
+	// "info" when empty. --log-level takes precedence over it. It has no
+	// effect on request/response recording.
+	Level string `yaml:"level"`
+	// RequestResponseDebug records every request/response pair exchanged
+	// with the LLM provider under .vyb/logs/ (also enabled by --debug).
+	RequestResponseDebug bool `yaml:"request-response-debug"`
+	// RetainLogs is the number of request/response logs kept under
+	// .vyb/logs/, older ones are pruned. Zero means DefaultRetainLogs, a
+	// negative value keeps every log.
+	RetainLogs int `yaml:"retain-logs,omitempty"`
 }
 
+// DefaultRetainLogs is the number of request/response logs kept when
+// Logging.RetainLogs is not set.
+const DefaultRetainLogs = 20
+
+// LogRetention returns the number of request/response logs to keep, or 0
+// to keep them all.
+func (l Logging) LogRetention() int {
+	switch {
+	case l.RetainLogs < 0:
+		return 0
+	case l.RetainLogs == 0:
+		return DefaultRetainLogs
+	}
+	return l.RetainLogs
+}
+
+// LogDir is the directory, relative to the project root, holding the
+// request/response logs.
+const LogDir = ".vyb/logs"
+
+// Providers lists the supported LLM providers, in lowercase as they are
+// written to .vyb/config.yaml. The provider string is case-insensitive.
+var Providers = []string{"openai", "gemini", "anthropic", "ollama"}
+
+// RequestFormats lists the accepted values of request_formats, in any case.
+var RequestFormats = []string{"markdown", "xml"}
+
+// LogLevels lists the accepted values of logging.level, in any case.
+var LogLevels = []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}
+
 // defaultProvider is used when no configuration file exists or it cannot
 // be parsed.  The value must always map to a known provider in the llm
 // dispatcher.
@@ -63,7 +534,44 @@ func Load(projectRoot string) (*Config, error) {
 	if projectRoot == "" {
 		return nil, fmt.Errorf("projectRoot must not be empty")
 	}
-	return LoadFS(os.DirFS(projectRoot))
+	absRoot, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %s: %w", projectRoot, err)
+	}
+	cfg, err := LoadFS(os.DirFS(absRoot))
+	if err != nil {
+		return nil, err
+	}
+	cfg.ProjectRoot = absRoot
+	return cfg, nil
+}
+
+// Discover ascends from startDir looking for the closest directory that
+// contains a .vyb folder and loads the configuration stored there. This
+// mirrors how project.FindDistanceToRoot locates the project root, so
+// commands executed from a sub-directory pick up the project's settings.
+//
+// When startDir is not within a vyb project the function returns Default()
+// with a nil error – commands such as `init` or `version` must keep working
+// outside of a project.
+func Discover(startDir string) (*Config, error) {
+	if startDir == "" {
+		return nil, fmt.Errorf("startDir must not be empty")
+	}
+	curr, err := filepath.Abs(startDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %s: %w", startDir, err)
+	}
+	for {
+		if fi, err := os.Stat(filepath.Join(curr, ".vyb")); err == nil && fi.IsDir() {
+			return Load(curr)
+		}
+		parent := filepath.Dir(curr)
+		if parent == curr {
+			return Default(), nil
+		}
+		curr = parent
+	}
 }
 
 // LoadFS performs the same operation as Load but works directly on an
@@ -81,13 +589,68 @@ func LoadFS(fsys fs.FS) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal %s: %w", relPath, err)
+	doc, err := strictyaml.Decode(relPath, data, &cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	// Basic sanity check – default when Provider is empty.
 	if cfg.Provider == "" {
 		cfg.Provider = defaultProvider
+	} else if !slices.Contains(Providers, strings.ToLower(cfg.Provider)) {
+		return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "provider"), strictyaml.Suggest(cfg.Provider, Providers), "unsupported provider %q", cfg.Provider)
+	}
+	if lvl := cfg.Logging.Level; lvl != "" && !slices.Contains(LogLevels, strings.ToLower(lvl)) {
+		return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "logging", "level"), strictyaml.Suggest(lvl, LogLevels), "unsupported log level %q", lvl)
+	}
+	sizes := []string{string(ModelSizeLarge), string(ModelSizeSmall)}
+	for _, key := range []string{"model_size", "require_model_size"} {
+		node := strictyaml.Lookup(doc, "annotation", key)
+		if node == nil || node.Value == "" {
+			continue
+		}
+		if _, err := ParseModelSize(node.Value); err != nil {
+			return nil, strictyaml.At(relPath, node, strictyaml.Suggest(node.Value, sizes), "unsupported annotation.%s %q", key, node.Value)
+		}
+	}
+	if err := cfg.Validation.validate(); err != nil {
+		return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "validation"), "", "invalid validation section: %v", err)
+	}
+	if err := validateModels(cfg.Models); err != nil {
+		return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "models"), "", "invalid models section: %v", err)
+	}
+	if err := validateModels(cfg.FallbackModels); err != nil {
+		return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "fallback_models"), "", "invalid fallback_models section: %v", err)
+	}
+	for provider, format := range cfg.RequestFormats {
+		if !slices.Contains(Providers, strings.ToLower(provider)) {
+			return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "request_formats"), strictyaml.Suggest(provider, Providers), "unsupported provider %q in request_formats", provider)
+		}
+		if !slices.Contains(RequestFormats, strings.ToLower(format)) {
+			return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "request_formats", provider), strictyaml.Suggest(format, RequestFormats), "unsupported request format %q", format)
+		}
+	}
+	for model, price := range cfg.Pricing {
+		if price.Prompt < 0 || price.Completion < 0 {
+			return nil, strictyaml.At(relPath, strictyaml.Lookup(doc, "pricing", model), "", "invalid price of %s: prices must not be negative", model)
+		}
 	}
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// validateModels rejects the keys of a models section not naming a family
+// and a size.
+func validateModels(models map[string]map[string]string) error {
+	for provider, byKey := range models {
+		for key := range byKey {
+			fam, sz, ok := strings.Cut(key, "-")
+			if !ok || (ModelFamily(fam) != ModelFamilyGPT && ModelFamily(fam) != ModelFamilyReasoning) {
+				return fmt.Errorf("%s: unsupported key %q, expected <family>-<size> with family %s or %s", provider, key, ModelFamilyGPT, ModelFamilyReasoning)
+			}
+			if _, err := ParseModelSize(sz); err != nil {
+				return fmt.Errorf("%s: unsupported key %q: %w", provider, key, err)
+			}
+		}
+	}
+	return nil
+}
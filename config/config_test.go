@@ -1,9 +1,12 @@
 package config
 
 import (
+    "os"
     "path/filepath"
+    "strings"
     "testing"
     "testing/fstest"
+    "time"
 )
 
 func TestLoadFS_Default(t *testing.T) {
@@ -20,14 +23,163 @@ func TestLoadFS_Default(t *testing.T) {
 
 func TestLoadFS_FromFile(t *testing.T) {
     fsys := fstest.MapFS{
-        filepath.ToSlash(".vyb/config.yaml"): &fstest.MapFile{Data: []byte("provider: fooai\n")},
+        filepath.ToSlash(".vyb/config.yaml"): &fstest.MapFile{Data: []byte("provider: Anthropic\n")},
     }
 
     cfg, err := LoadFS(fsys)
     if err != nil {
         t.Fatalf("unexpected error: %v", err)
     }
-    if cfg.Provider != "fooai" {
-        t.Fatalf("expected provider 'fooai', got %s", cfg.Provider)
+    if cfg.Provider != "Anthropic" {
+        t.Fatalf("expected provider 'Anthropic', got %s", cfg.Provider)
+    }
+}
+
+func TestLoadFS_HTTP(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("http:\n  timeout: 90s\n  max_retries: -1\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if got := cfg.HTTP.RequestTimeout(); got != 90*time.Second {
+        t.Fatalf("expected a 90s timeout, got %s", got)
+    }
+    if got := cfg.HTTP.Retries(); got != 0 {
+        t.Fatalf("expected retries to be disabled, got %d", got)
+    }
+    if got := Default().HTTP; got.RequestTimeout() != DefaultHTTPTimeout || got.Retries() != DefaultMaxRetries {
+        t.Fatalf("unexpected defaults: %s, %d", got.RequestTimeout(), got.Retries())
+    }
+}
+
+func TestLoadFS_Endpoints(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("openai:\n  base_url: https://gateway.example.com/v1\n  api_key_env: OPENAI_PROXY_KEY\ngemini:\n  base_url: https://gateway.example.com/gemini\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    want := Endpoint{BaseURL: "https://gateway.example.com/v1", APIKeyEnv: "OPENAI_PROXY_KEY"}
+    if cfg.OpenAI != want {
+        t.Fatalf("openai = %+v, want %+v", cfg.OpenAI, want)
+    }
+    if cfg.Gemini != (Endpoint{BaseURL: "https://gateway.example.com/gemini"}) {
+        t.Fatalf("unexpected gemini endpoint %+v", cfg.Gemini)
+    }
+}
+
+func TestDiscover(t *testing.T) {
+    root := t.TempDir()
+    nested := filepath.Join(root, "a", "b")
+    if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0o755); err != nil {
+        t.Fatalf("mkdir: %v", err)
+    }
+    if err := os.MkdirAll(nested, 0o755); err != nil {
+        t.Fatalf("mkdir: %v", err)
+    }
+    if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte("provider: gemini\nlogging:\n  level: debug\n"), 0o644); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+
+    for _, dir := range []string{root, filepath.Join(root, "a"), nested} {
+        cfg, err := Discover(dir)
+        if err != nil {
+            t.Fatalf("Discover(%s) unexpected error: %v", dir, err)
+        }
+        if cfg.Provider != "gemini" || cfg.Logging.Level != "debug" {
+            t.Fatalf("Discover(%s) = %+v, want project config", dir, cfg)
+        }
+    }
+}
+
+func TestDiscover_OutsideProject(t *testing.T) {
+    dir := t.TempDir()
+
+    cfg, err := Discover(dir)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if cfg.Provider != Default().Provider {
+        t.Fatalf("expected default provider %q, got %q", Default().Provider, cfg.Provider)
+    }
+}
+
+func TestLoadFS_Validation(t *testing.T) {
+    for yml, wantErr := range map[string]bool{
+        "validation:\n  rules: [generated-files, test-pairing]\n": false,
+        "validation:\n  rules: [no-such-rule]\n":                  true,
+        "validation:\n  rules: [license-header]\n":                true,
+        "validation:\n  rules: [license-header]\n  license_header: \"// MIT\"\n": false,
+    } {
+        _, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(yml)}})
+        if (err != nil) != wantErr {
+            t.Fatalf("LoadFS(%q) error = %v, want error: %v", yml, err, wantErr)
+        }
+    }
+}
+
+func TestLoadFS_Models(t *testing.T) {
+    for yml, wantErr := range map[string]bool{
+        "models:\n  openai:\n    reasoning-large: o3-pro\n    gpt-small: gpt-4.1-nano\n": false,
+        "models:\n  openai:\n    reasoning-medium: o3\n":                               true,
+        "models:\n  openai:\n    chat-large: gpt-4o\n":                                 true,
+        "models:\n  openai:\n    large: o3\n":                                          true,
+        "fallback_models:\n  gemini:\n    gpt-huge: gemini-2.5-pro\n":                 true,
+    } {
+        cfg, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(yml)}})
+        if (err != nil) != wantErr {
+            t.Fatalf("LoadFS(%q) error = %v, want error: %v", yml, err, wantErr)
+        }
+        if err == nil && cfg.Models["openai"]["reasoning-large"] != "o3-pro" {
+            t.Fatalf("LoadFS(%q) models = %v", yml, cfg.Models)
+        }
+    }
+}
+
+func TestLoadFS_Pricing(t *testing.T) {
+    yml := "pricing:\n  o3-pro:\n    prompt: 20\n    completion: 80\n"
+    cfg, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(yml)}})
+    if err != nil {
+        t.Fatalf("LoadFS: %v", err)
+    }
+    if got := cfg.Pricing["o3-pro"]; got != (ModelPrice{Prompt: 20, Completion: 80}) {
+        t.Fatalf("unexpected price %+v", got)
+    }
+
+    yml = "pricing:\n  o3-pro:\n    prompt: -1\n"
+    if _, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(yml)}}); err == nil || !strings.Contains(err.Error(), "must not be negative") {
+        t.Fatalf("expected an error for a negative price, got %v", err)
+    }
+}
+
+func TestLoadFS_Invalid(t *testing.T) {
+    cases := map[string]string{
+        "duplicate_key.yaml":   `.vyb/config.yaml:4:3: duplicate key "max_file_tokens", already defined at line 3 (remove one of them)`,
+        "int_for_string.yaml":  `.vyb/config.yaml:3:10: logging.level must be a string, got the integer 2 (quote it: "2")`,
+        "string_for_int.yaml":  `.vyb/config.yaml:3:20: request.max_file_tokens must be a whole number, got the string "lots"`,
+        "list_for_scalar.yaml": `.vyb/config.yaml:3:12: http.timeout must be a duration, got a list`,
+        "unknown_field.yaml":   `.vyb/config.yaml:2:1: field anotation not found at the top level (did you mean "annotation"?)`,
+        "bad_log_level.yaml":   `.vyb/config.yaml:2:10: unsupported log level "verbose" (expected one of panic, fatal, error, warn, warning, info, debug, trace)`,
+        "bad_provider.yaml":    `.vyb/config.yaml:1:11: unsupported provider "gemni" (did you mean "gemini"?)`,
+        "bad_model_size.yaml":  `.vyb/config.yaml:2:15: unsupported annotation.model_size "huge" (expected one of large, small)`,
+        "syntax_error.yaml":    `.vyb/config.yaml:4: mapping values are not allowed in this context`,
+        "bad_request_format.yaml": `.vyb/config.yaml:2:14: unsupported request format "plaintext" (expected one of markdown, xml)`,
+    }
+    for name, want := range cases {
+        t.Run(name, func(t *testing.T) {
+            data, err := os.ReadFile(filepath.Join("testdata", "invalid", name))
+            if err != nil {
+                t.Fatalf("read fixture: %v", err)
+            }
+            _, err = LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: data}})
+            if err == nil || !strings.HasPrefix(err.Error(), want) {
+                t.Fatalf("expected error %q, got %v", want, err)
+            }
+        })
     }
 }
@@ -1,5 +1,7 @@
 package config
 
+import "fmt"
+
 // ModelFamily represents the generic family of a language model.
 //
 // The enumeration is intentionally small for now – new families can be
@@ -48,3 +50,13 @@ const (
 )
 
 func (m ModelSize) String() string { return string(m) }
+
+// ParseModelSize converts s, as passed on the command line, into a
+// ModelSize.
+func ParseModelSize(s string) (ModelSize, error) {
+	switch sz := ModelSize(s); sz {
+	case ModelSizeLarge, ModelSizeSmall:
+		return sz, nil
+	}
+	return "", fmt.Errorf("unsupported model size %q, expected %s or %s", s, ModelSizeLarge, ModelSizeSmall)
+}
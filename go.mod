@@ -6,7 +6,9 @@ require (
 	github.com/AlecAivazis/survey/v2 v2.3.7
 	github.com/cbroglie/mustache v1.2.0
 	github.com/google/go-cmp v0.7.0
+	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.6.1
+	github.com/stretchr/testify v1.6.1
 	github.com/tiktoken-go/tokenizer v0.6.2
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -78,7 +80,6 @@ require (
 	github.com/ryancurrah/gomodguard v1.1.0 // indirect
 	github.com/ryanrolds/sqlclosecheck v0.3.0 // indirect
 	github.com/securego/gosec/v2 v2.3.0 // indirect
-	github.com/sirupsen/logrus v1.6.0 // indirect
 	github.com/sonatard/noctx v0.0.1 // indirect
 	github.com/sourcegraph/go-diff v0.5.3 // indirect
 	github.com/spf13/afero v1.1.2 // indirect
@@ -87,7 +88,6 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.7.0 // indirect
 	github.com/stretchr/objx v0.1.1 // indirect
-	github.com/stretchr/testify v1.6.1 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
 	github.com/tdakkota/asciicheck v0.0.0-20200416190851-d7f85be797a2 // indirect
 	github.com/tetafro/godot v0.4.2 // indirect
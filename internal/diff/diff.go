@@ -0,0 +1,245 @@
+// Package diff implements the small text diffing helpers used to preview
+// proposed changes and to compare annotation versions.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines surrounding each change
+// in a unified diff hunk (same default as `diff -u`).
+const contextLines = 3
+
+// Op identifies the kind of a single Edit.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is one step of an edit script turning a sequence into another.
+type Edit struct {
+	Op   Op
+	Text string
+}
+
+// Unified renders the difference between oldContent and newContent as a
+// unified diff for the given path. When created is true the old side is
+// rendered as /dev/null, and when deleted is true the new side is. It
+// returns an empty string when both contents are identical.
+func Unified(path, oldContent, newContent string, created, deleted bool) string {
+	if oldContent == newContent && !created && !deleted {
+		return ""
+	}
+
+	script := Compute(splitLines(oldContent), splitLines(newContent))
+
+	var sb strings.Builder
+	if created {
+		sb.WriteString("--- /dev/null\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("--- a/%s\n", path))
+	}
+	if deleted {
+		sb.WriteString("+++ /dev/null\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("+++ b/%s\n", path))
+	}
+
+	// Pre-compute the (old,new) line offsets at every script position so
+	// hunk headers can be derived without re-walking the script.
+	oldPos := make([]int, len(script)+1)
+	newPos := make([]int, len(script)+1)
+	for i, e := range script {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if e.Op != Insert {
+			oldPos[i+1]++
+		}
+		if e.Op != Delete {
+			newPos[i+1]++
+		}
+	}
+
+	for _, h := range groupHunks(script) {
+		oldCount := oldPos[h[1]] - oldPos[h[0]]
+		newCount := newPos[h[1]] - newPos[h[0]]
+		sb.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(oldPos[h[0]], oldCount), hunkRange(newPos[h[0]], newCount)))
+		for _, e := range script[h[0]:h[1]] {
+			switch e.Op {
+			case Equal:
+				sb.WriteString(" ")
+			case Delete:
+				sb.WriteString("-")
+			case Insert:
+				sb.WriteString("+")
+			}
+			sb.WriteString(strings.TrimSuffix(e.Text, "\n"))
+			sb.WriteString("\n")
+			if !strings.HasSuffix(e.Text, "\n") {
+				sb.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Words renders a word-level diff between oldText and newText in the style
+// of `git diff --word-diff=plain`: removed words are wrapped in [-…-] and
+// added words in {+…+}. Whitespace is normalised to single spaces. It
+// returns an empty string when both texts contain the same words.
+func Words(oldText, newText string) string {
+	script := Compute(strings.Fields(oldText), strings.Fields(newText))
+
+	changed := false
+	var parts []string
+	for i := 0; i < len(script); {
+		op := script[i].Op
+		j := i
+		var run []string
+		for j < len(script) && script[j].Op == op {
+			run = append(run, script[j].Text)
+			j++
+		}
+		text := strings.Join(run, " ")
+		switch op {
+		case Equal:
+			parts = append(parts, text)
+		case Delete:
+			changed = true
+			parts = append(parts, "[-"+text+"-]")
+		case Insert:
+			changed = true
+			parts = append(parts, "{+"+text+"+}")
+		}
+		i = j
+	}
+	if !changed {
+		return ""
+	}
+	return strings.Join(parts, " ")
+}
+
+// hunkRange formats a "start,count" pair following the unified diff
+// convention (1-based start, start of the previous line when count is 0).
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// groupHunks returns [start,end) index pairs into script, one per hunk,
+// each padded with up to contextLines of unchanged lines.
+func groupHunks(script []Edit) [][2]int {
+	var hunks [][2]int
+	for i := 0; i < len(script); {
+		if script[i].Op == Equal {
+			i++
+			continue
+		}
+		start := max(0, i-contextLines)
+		end := i
+		for end < len(script) {
+			if script[end].Op != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(script) && script[run].Op == Equal {
+				run++
+			}
+			// Merge with the next change when the gap is small enough for
+			// both context windows to overlap.
+			if run == len(script) || run-end > 2*contextLines {
+				end = min(len(script), end+contextLines)
+				break
+			}
+			end = run
+		}
+		hunks = append(hunks, [2]int{start, end})
+		i = end
+	}
+	return hunks
+}
+
+// splitLines splits s into lines, keeping the trailing newline of each one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Compute returns the shortest edit script turning a into b using the
+// Myers O(ND) algorithm.
+func Compute(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Backtrack through the recorded frontiers to rebuild the script.
+	var reversed []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		fv := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && fv[offset+k-1] < fv[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := fv[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, Edit{Op: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, Edit{Op: Insert, Text: b[y-1]})
+			} else {
+				reversed = append(reversed, Edit{Op: Delete, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	script := make([]Edit, len(reversed))
+	for i, e := range reversed {
+		script[len(reversed)-1-i] = e
+	}
+	return script
+}
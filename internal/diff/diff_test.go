@@ -0,0 +1,84 @@
+package diff
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name    string
+		old     string
+		new     string
+		created bool
+		deleted bool
+		want    string
+	}{
+		{
+			name: "identical",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "single line change",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- a/f.txt\n+++ b/f.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:    "created",
+			new:     "x\n",
+			created: true,
+			want:    "--- /dev/null\n+++ b/f.txt\n@@ -0,0 +1,1 @@\n+x\n",
+		},
+		{
+			name:    "deleted",
+			old:     "x\n",
+			deleted: true,
+			want:    "--- a/f.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-x\n",
+		},
+		{
+			name: "missing trailing newline",
+			old:  "a\n",
+			new:  "a\nb",
+			want: "--- a/f.txt\n+++ b/f.txt\n@@ -1,1 +1,2 @@\n a\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "distant changes produce separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a/f.txt\n+++ b/f.txt\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Unified("f.txt", c.old, c.new, c.created, c.deleted)
+			if got != c.want {
+				t.Fatalf("Unified() =\n%s\nwant:\n%s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWords(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{name: "identical", old: "a b  c", new: "a b c", want: ""},
+		{name: "replacement", old: "the quick fox", new: "the slow fox", want: "the [-quick-] {+slow+} fox"},
+		{name: "insertion", old: "parses files", new: "parses YAML files", want: "parses {+YAML+} files"},
+		{name: "deletion", old: "a b c d", new: "a d", want: "a [-b c-] d"},
+		{name: "from empty", old: "", new: "new text", want: "{+new text+}"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Words(c.old, c.new); got != c.want {
+				t.Fatalf("Words(%q, %q) = %q, want %q", c.old, c.new, got, c.want)
+			}
+		})
+	}
+}
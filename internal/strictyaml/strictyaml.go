@@ -0,0 +1,337 @@
+// Package strictyaml decodes YAML files into Go structs strictly: unknown
+// fields, duplicate keys and scalars of the wrong type (e.g. `level: 2` for
+// a string) are rejected, with the file, line and column of the offending
+// node and, when possible, a suggestion.
+package strictyaml
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem found at a position of a YAML file.
+type Error struct {
+	Path string
+	// Line and Column are 1-based, zero when unknown.
+	Line, Column int
+	Msg          string
+	// Hint suggests a fix, empty when there is none.
+	Hint string
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Path)
+	if e.Line > 0 {
+		fmt.Fprintf(&sb, ":%d", e.Line)
+		if e.Column > 0 {
+			fmt.Fprintf(&sb, ":%d", e.Column)
+		}
+	}
+	sb.WriteString(": " + e.Msg)
+	if e.Hint != "" {
+		sb.WriteString(" (" + e.Hint + ")")
+	}
+	return sb.String()
+}
+
+// At returns an Error located at node, which may be nil, of the file path.
+func At(path string, node *yaml.Node, hint, format string, args ...any) *Error {
+	e := &Error{Path: path, Msg: fmt.Sprintf(format, args...), Hint: hint}
+	if node != nil {
+		e.Line, e.Column = node.Line, node.Column
+	}
+	return e
+}
+
+// Suggest returns a hint naming the option closest to value, or listing
+// the options when none is close.
+func Suggest(value string, options []string) string {
+	best, bestDist := "", -1
+	for _, o := range options {
+		d := distance(strings.ToLower(value), strings.ToLower(o))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = o, d
+		}
+	}
+	if bestDist >= 0 && bestDist <= max(2, len(value)/3) {
+		return fmt.Sprintf("did you mean %q?", best)
+	}
+	return "expected one of " + strings.Join(options, ", ")
+}
+
+// syntaxLine extracts the line of a yaml.v3 syntax error.
+var syntaxLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Decode parses data, the content of the file path, checks it against the
+// type of out, a pointer to a struct, then decodes it into out. It returns
+// the document node, whose nodes Lookup finds to locate later validation
+// errors. Every problem found is reported, joined.
+func Decode(path string, data []byte, out any) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if m := syntaxLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, &Error{Path: path, Line: line, Msg: m[2]}
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		// Empty file.
+		return &doc, nil
+	}
+	c := checker{path: path}
+	c.check(doc.Content[0], reflect.TypeOf(out).Elem(), "")
+	if len(c.errs) > 0 {
+		return nil, errors.Join(c.errs...)
+	}
+	if err := doc.Decode(out); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// Lookup returns the value node found by following keys from root, a
+// document or mapping node, or nil when there is none.
+func Lookup(root *yaml.Node, keys ...string) *yaml.Node {
+	node := root
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// checker collects the problems of a document.
+type checker struct {
+	path string
+	errs []error
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	durationType    = reflect.TypeOf(time.Duration(0))
+)
+
+func (c *checker) fail(node *yaml.Node, hint, format string, args ...any) {
+	c.errs = append(c.errs, At(c.path, node, hint, format, args...))
+}
+
+// check reports the problems of node, decoded into a value of type t found
+// at name, the dotted path of its keys.
+func (c *checker) check(node *yaml.Node, t reflect.Type, name string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Tag == "!!null" || t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+	if name == "" {
+		name = topLevel
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if c.expect(node, yaml.MappingNode, "a mapping", name) {
+			c.checkStruct(node, t, name)
+		}
+	case reflect.Map:
+		if c.expect(node, yaml.MappingNode, "a mapping", name) {
+			c.checkKeys(node, func(key *yaml.Node, value *yaml.Node) {
+				c.check(value, t.Elem(), join(name, key.Value))
+			})
+		}
+	case reflect.Slice, reflect.Array:
+		if c.expect(node, yaml.SequenceNode, "a list", name) {
+			for i, item := range node.Content {
+				c.check(item, t.Elem(), fmt.Sprintf("%s[%d]", name, i))
+			}
+		}
+	case reflect.String:
+		if c.expect(node, yaml.ScalarNode, "a string", name) && node.Tag != "!!str" {
+			c.fail(node, fmt.Sprintf("quote it: %q", node.Value), "%s must be a string, got %s %s", name, tagName(node.Tag), node.Value)
+		}
+	case reflect.Bool:
+		if c.expect(node, yaml.ScalarNode, "a boolean", name) && node.Tag != "!!bool" {
+			c.fail(node, "use true or false", "%s must be a boolean, got %s %q", name, tagName(node.Tag), node.Value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t == durationType {
+			if c.expect(node, yaml.ScalarNode, "a duration", name) && node.Tag != "!!str" && node.Tag != "!!int" {
+				c.fail(node, `use a duration such as "90s" or "10m"`, "%s must be a duration, got %s %q", name, tagName(node.Tag), node.Value)
+			}
+			return
+		}
+		if c.expect(node, yaml.ScalarNode, "a whole number", name) && node.Tag != "!!int" {
+			c.fail(node, "use a whole number, without quotes", "%s must be a whole number, got %s %q", name, tagName(node.Tag), node.Value)
+		}
+	case reflect.Float32, reflect.Float64:
+		if c.expect(node, yaml.ScalarNode, "a number", name) && node.Tag != "!!float" && node.Tag != "!!int" {
+			c.fail(node, "use a number, without quotes", "%s must be a number, got %s %q", name, tagName(node.Tag), node.Value)
+		}
+	}
+}
+
+// expect reports node when it is not of the given kind, and tells whether
+// it is.
+func (c *checker) expect(node *yaml.Node, kind yaml.Kind, what, name string) bool {
+	if node.Kind == kind {
+		return true
+	}
+	c.fail(node, "", "%s must be %s, got %s", name, what, kindName(node))
+	return false
+}
+
+// checkKeys reports the duplicate keys of the mapping node, and calls
+// visit for the first occurrence of every key.
+func (c *checker) checkKeys(node *yaml.Node, visit func(key, value *yaml.Node)) {
+	seen := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if first, ok := seen[key.Value]; ok {
+			c.fail(key, "remove one of them", "duplicate key %q, already defined at line %d", key.Value, first.Line)
+			continue
+		}
+		seen[key.Value] = key
+		visit(key, value)
+	}
+}
+
+// checkStruct reports the unknown and duplicate keys of node, decoded into
+// the struct type t, and checks the value of every known one.
+func (c *checker) checkStruct(node *yaml.Node, t reflect.Type, name string) {
+	fields := make(map[string]reflect.Type)
+	collectFields(t, fields)
+	c.checkKeys(node, func(key, value *yaml.Node) {
+		ft, ok := fields[key.Value]
+		if !ok {
+			names := make([]string, 0, len(fields))
+			for n := range fields {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			where := "in " + name
+			if name == topLevel {
+				where = "at the top level"
+			}
+			c.fail(key, Suggest(key.Value, names), "field %s not found %s", key.Value, where)
+			return
+		}
+		c.check(value, ft, join(name, key.Value))
+	})
+}
+
+// collectFields adds to fields the key and type of every field of the
+// struct type t decoded by yaml, inline fields included.
+func collectFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectFields(ft, fields)
+			}
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields[key] = f.Type
+	}
+}
+
+// topLevel names the root node of a document in messages.
+const topLevel = "the document"
+
+// join appends key to the dotted path name.
+func join(name, key string) string {
+	if name == topLevel {
+		return key
+	}
+	return name + "." + key
+}
+
+// tagName describes a resolved YAML tag, e.g. "!!int" as "the integer".
+func tagName(tag string) string {
+	switch tag {
+	case "!!int":
+		return "the integer"
+	case "!!float":
+		return "the number"
+	case "!!bool":
+		return "the boolean"
+	case "!!str":
+		return "the string"
+	case "!!timestamp":
+		return "the timestamp"
+	}
+	return "the value"
+}
+
+// kindName describes the kind of node.
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.ScalarNode:
+		return fmt.Sprintf("%s %q", tagName(node.Tag), node.Value)
+	}
+	return "another kind of value"
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
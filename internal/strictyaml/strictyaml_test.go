@@ -0,0 +1,120 @@
+package strictyaml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	Size  string   `yaml:"size"`
+	Names []string `yaml:"names"`
+}
+
+type embedded struct {
+	Extra string `yaml:"extra"`
+}
+
+type doc struct {
+	embedded `yaml:",inline"`
+	Name     string            `yaml:"name"`
+	Count    int               `yaml:"count"`
+	Ratio    float64           `yaml:"ratio"`
+	Enabled  bool              `yaml:"enabled"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Inner    inner             `yaml:"inner"`
+	Labels   map[string]string `yaml:"labels"`
+	Ignored  string            `yaml:"-"`
+}
+
+func TestDecode(t *testing.T) {
+	data := "name: x\ncount: 3\nratio: 1\nenabled: true\ntimeout: 90s\nextra: e\ninner:\n  size: small\n  names: [a, b]\nlabels:\n  k: v\n"
+	var d doc
+	root, err := Decode("f.yaml", []byte(data), &d)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if d.Name != "x" || d.Count != 3 || d.Ratio != 1 || !d.Enabled || d.Timeout != 90*time.Second || d.Extra != "e" || d.Inner.Names[1] != "b" || d.Labels["k"] != "v" {
+		t.Fatalf("unexpected value %+v", d)
+	}
+	if n := Lookup(root, "inner", "size"); n == nil || n.Line != 8 || n.Column != 9 {
+		t.Fatalf("Lookup(inner.size) = %+v", n)
+	}
+	if n := Lookup(root, "inner", "missing"); n != nil {
+		t.Fatalf("expected no node, got %+v", n)
+	}
+
+	if _, err := Decode("empty.yaml", nil, &d); err != nil {
+		t.Fatalf("expected an empty file to decode, got %v", err)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	cases := []struct {
+		data string
+		want []string
+	}{
+		{"name: a\nname: b\n", []string{`f.yaml:2:1: duplicate key "name", already defined at line 1 (remove one of them)`}},
+		{"labels:\n  k: a\n  k: b\n", []string{`f.yaml:3:3: duplicate key "k", already defined at line 2`}},
+		{"nmae: a\n", []string{`f.yaml:1:1: field nmae not found at the top level (did you mean "name"?)`}},
+		{"inner:\n  colour: red\n", []string{`f.yaml:2:3: field colour not found in inner`}},
+		{"name: 2\n", []string{`f.yaml:1:7: name must be a string, got the integer 2 (quote it: "2")`}},
+		{"count: \"3\"\n", []string{`f.yaml:1:8: count must be a whole number, got the string "3"`}},
+		{"ratio: high\n", []string{`f.yaml:1:8: ratio must be a number, got the string "high"`}},
+		{"enabled: yes\n", []string{`f.yaml:1:10: enabled must be a boolean, got the string "yes"`}},
+		{"timeout: true\n", []string{`f.yaml:1:10: timeout must be a duration, got the boolean "true"`}},
+		{"inner: small\n", []string{`f.yaml:1:8: inner must be a mapping, got the string "small"`}},
+		{"inner:\n  names: a\n", []string{`f.yaml:2:10: inner.names must be a list, got the string "a"`}},
+		{"inner:\n  names: [a, 1]\n", []string{`f.yaml:2:14: inner.names[1] must be a string, got the integer 1`}},
+		{"name: a\n  count: 1\n", []string{`f.yaml:2: mapping values are not allowed in this context`}},
+		// Every problem is reported.
+		{"name: 1\nnmae: a\n", []string{`f.yaml:1:7: name must be a string`, `f.yaml:2:1: field nmae not found`}},
+	}
+	for _, c := range cases {
+		var d doc
+		_, err := Decode("f.yaml", []byte(c.data), &d)
+		if err == nil {
+			t.Errorf("Decode(%q): expected an error", c.data)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Decode(%q) = %v, want %q", c.data, err, want)
+			}
+		}
+		var e *Error
+		if !errors.As(err, &e) || e.Path != "f.yaml" || e.Line == 0 {
+			t.Errorf("Decode(%q): expected a located *Error, got %#v", c.data, err)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	for _, c := range []struct {
+		err  Error
+		want string
+	}{
+		{Error{Path: "f", Line: 2, Column: 3, Msg: "m", Hint: "h"}, "f:2:3: m (h)"},
+		{Error{Path: "f", Line: 2, Msg: "m"}, "f:2: m"},
+		{Error{Path: "f", Msg: "m"}, "f: m"},
+	} {
+		if got := c.err.Error(); got != c.want {
+			t.Errorf("Error() = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	options := []string{"openai", "gemini", "anthropic"}
+	for value, want := range map[string]string{
+		"gemni":     `did you mean "gemini"?`,
+		"OpenAI":    `did you mean "openai"?`,
+		"antropic":  `did you mean "anthropic"?`,
+		"something": "expected one of openai, gemini, anthropic",
+	} {
+		if got := Suggest(value, options); got != want {
+			t.Errorf("Suggest(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -1,13 +1,27 @@
 package llm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/anthropic"
+	"github.com/vybdev/vyb/llm/internal/debuglog"
+	"github.com/vybdev/vyb/llm/internal/footer"
 	"github.com/vybdev/vyb/llm/internal/gemini"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/internal/ollama"
 	"github.com/vybdev/vyb/llm/internal/openai"
+	"github.com/vybdev/vyb/llm/internal/render"
+	"github.com/vybdev/vyb/llm/internal/repair"
+	"github.com/vybdev/vyb/llm/models"
 	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/logging"
 )
 
 // provider captures the common operations expected from any LLM backend.
@@ -15,100 +29,472 @@ import (
 // package stays minimal while allowing internal dispatch based on user
 // configuration.
 //
+// Every method returns, along with its typed response, the usage of the
+// calls it made, priced from the model registry.
+//
 // Additional methods should be appended here whenever new high-level
 // helpers are added to the llm façade.
 type provider interface {
-	GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error)
-	GetModuleContext(systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error)
-	GetModuleExternalContexts(systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error)
+	GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error)
+	GetModuleContext(ctx context.Context, sz config.ModelSize, systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error)
+	GetModuleExternalContexts(ctx context.Context, sz config.ModelSize, systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error)
+	GetModuleContexts(ctx context.Context, sz config.ModelSize, systemMessage string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error)
+	GetChangeNarrative(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, systemMessage string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error)
+}
+
+// Every provider sends its requests through client, configured from the
+// http section of .vyb/config.yaml, to the model resolved by model.
+
+// providerModels resolves the models of a provider.
+type providerModels struct {
+	name     string
+	registry models.Registry
+	// fallbacks holds the fallback_models section of the configuration.
+	fallbacks models.Registry
+	prices    models.Prices
+}
+
+// model resolves the model of fam and sz.
+func (m providerModels) model(fam config.ModelFamily, sz config.ModelSize) (string, error) {
+	return m.registry.Resolve(m.name, fam, sz)
+}
+
+// fallback returns the model retried when the provider no longer serves
+// the model of fam and sz: the configured fallback, or else the model of
+// the other size. It returns "" when there is none.
+func (m providerModels) fallback(fam config.ModelFamily, sz config.ModelSize) string {
+	if alt := m.fallbacks[m.name][models.Key(fam, sz)]; alt != "" {
+		return alt
+	}
+	other := config.ModelSizeSmall
+	if sz == config.ModelSizeSmall {
+		other = config.ModelSizeLarge
+	}
+	alt, _ := m.model(fam, other)
+	return alt
+}
+
+// price sets the estimated cost of usage, the usage of calls to model.
+// Calls to a model without a known price are counted as unpriced.
+func (m providerModels) price(model string, usage payload.UsageStats) payload.UsageStats {
+	if usage.Calls == 0 {
+		return usage
+	}
+	cost, ok := m.prices.Cost(model, usage.PromptTokens, usage.CompletionTokens)
+	if !ok {
+		usage.Unpriced = usage.Calls
+		return usage
+	}
+	usage.Cost = cost
+	return usage
+}
+
+// withFallback calls call with the model of fam and sz and, when the
+// provider does not serve it (e.g. a retired preview model), once more with
+// its fallback model, warning about the substitution. The usage returned
+// by call is priced for the model it was made with.
+func withFallback[T any](m providerModels, fam config.ModelFamily, sz config.ModelSize, call func(model string) (*T, payload.UsageStats, error)) (*T, payload.UsageStats, error) {
+	model, err := m.model(fam, sz)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
+	}
+	out, usage, err := call(model)
+	if !errors.Is(err, httpclient.ErrModelNotFound) {
+		return out, m.price(model, usage), err
+	}
+	alt := m.fallback(fam, sz)
+	if alt == "" || alt == model {
+		return nil, m.price(model, usage), err
+	}
+	logging.Log.Warnf("%s model %s is unavailable, falling back to %s: %v", m.name, model, alt, err)
+	out, usage, err = call(alt)
+	return out, m.price(alt, usage), err
+}
+
+// noUsage adapts the calls of the providers that do not report their
+// usage yet (Anthropic and Ollama).
+func noUsage[T any](out *T, err error) (*T, payload.UsageStats, error) {
+	return out, payload.UsageStats{}, err
+}
+
+type openAIProvider struct {
+	providerModels
+	client httpclient.Client
+}
+
+type geminiProvider struct {
+	providerModels
+	client httpclient.Client
+}
+
+type anthropicProvider struct {
+	providerModels
+	client httpclient.Client
+}
+
+// ollamaProvider talks to a local Ollama server.
+type ollamaProvider struct {
+	providerModels
+	client httpclient.Client
 }
 
-type openAIProvider struct{}
+// unknownProvider is resolved for provider names missing from
+// supportedProviders; every call fails naming the supported ones.
+type unknownProvider struct {
+	name string
+}
 
-type geminiProvider struct{}
+func (p *unknownProvider) err() error {
+	return fmt.Errorf("unknown provider %q, expected one of %s", p.name, strings.Join(supportedProviders, ", "))
+}
 
-type unknownProvider struct{}
+func (p *openAIProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		return openai.GetWorkspaceChangeProposals(ctx, p.client, model, sysMsg, request)
+	})
+}
 
-func (*openAIProvider) GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
-	return openai.GetWorkspaceChangeProposals(fam, sz, sysMsg, request)
+func (p *openAIProvider) GetModuleContext(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return openai.GetModuleContext(ctx, p.client, model, sysMsg, request)
+	})
 }
 
-func (*openAIProvider) GetModuleContext(sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
-	return openai.GetModuleContext(sysMsg, request)
+func (p *openAIProvider) GetModuleExternalContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return openai.GetModuleExternalContexts(ctx, p.client, model, sysMsg, request)
+	})
 }
 
-func (*openAIProvider) GetModuleExternalContexts(sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
-	return openai.GetModuleExternalContexts(sysMsg, request)
+func (p *openAIProvider) GetModuleContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+		return openai.GetModuleContexts(ctx, p.client, model, sysMsg, request)
+	})
+}
+
+func (p *openAIProvider) GetChangeNarrative(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.ChangeNarrative, payload.UsageStats, error) {
+		return openai.GetChangeNarrative(ctx, p.client, model, sysMsg, request)
+	})
 }
 
 // -----------------------------------------------------------------------------
 //  Gemini provider implementation
 // -----------------------------------------------------------------------------
 
-func mapGeminiModel(fam config.ModelFamily, sz config.ModelSize) (string, error) {
-	switch sz {
-	case config.ModelSizeSmall:
-		return "gemini-2.5-flash-preview-05-20", nil
-	case config.ModelSizeLarge:
-		return "gemini-2.5-pro-preview-06-05", nil
-	default:
-		return "", fmt.Errorf("gemini: unsupported model size %s", sz)
-	}
+func (p *geminiProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		return gemini.GetWorkspaceChangeProposals(ctx, p.client, model, sysMsg, request)
+	})
+}
+
+func (p *geminiProvider) GetModuleContext(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return gemini.GetModuleContext(ctx, p.client, model, sysMsg, request)
+	})
+}
+
+func (p *geminiProvider) GetModuleExternalContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return gemini.GetModuleExternalContexts(ctx, p.client, model, sysMsg, request)
+	})
+}
+
+func (p *geminiProvider) GetModuleContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+		return gemini.GetModuleContexts(ctx, p.client, model, sysMsg, request)
+	})
+}
+
+func (p *geminiProvider) GetChangeNarrative(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.ChangeNarrative, payload.UsageStats, error) {
+		return gemini.GetChangeNarrative(ctx, p.client, model, sysMsg, request)
+	})
+}
+
+// -----------------------------------------------------------------------------
+//  Anthropic provider implementation
+// -----------------------------------------------------------------------------
+
+func (p *anthropicProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		return noUsage(anthropic.GetWorkspaceChangeProposals(ctx, p.client, model, sysMsg, request))
+	})
+}
+
+func (p *anthropicProvider) GetModuleContext(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return noUsage(anthropic.GetModuleContext(ctx, p.client, model, sysMsg, request))
+	})
+}
+
+func (p *anthropicProvider) GetModuleExternalContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return noUsage(anthropic.GetModuleExternalContexts(ctx, p.client, model, sysMsg, request))
+	})
+}
+
+func (p *anthropicProvider) GetModuleContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+		return noUsage(anthropic.GetModuleContexts(ctx, p.client, model, sysMsg, request))
+	})
+}
+
+func (p *anthropicProvider) GetChangeNarrative(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.ChangeNarrative, payload.UsageStats, error) {
+		return noUsage(anthropic.GetChangeNarrative(ctx, p.client, model, sysMsg, request))
+	})
+}
+
+// -----------------------------------------------------------------------------
+//  Ollama provider implementation
+// -----------------------------------------------------------------------------
+
+func (p *ollamaProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+		return noUsage(ollama.GetWorkspaceChangeProposals(ctx, p.client, model, sysMsg, request))
+	})
+}
+
+func (p *ollamaProvider) GetModuleContext(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return noUsage(ollama.GetModuleContext(ctx, p.client, model, sysMsg, request))
+	})
 }
 
-func (*geminiProvider) GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
-	return gemini.GetWorkspaceChangeProposals(fam, sz, sysMsg, request)
+func (p *ollamaProvider) GetModuleExternalContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return noUsage(ollama.GetModuleExternalContexts(ctx, p.client, model, sysMsg, request))
+	})
 }
 
-func (*geminiProvider) GetModuleContext(sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
-	return gemini.GetModuleContext(sysMsg, request)
+func (p *ollamaProvider) GetModuleContexts(ctx context.Context, sz config.ModelSize, sysMsg string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	return withFallback(p.providerModels, config.ModelFamilyReasoning, sz, func(model string) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+		return noUsage(ollama.GetModuleContexts(ctx, p.client, model, sysMsg, request))
+	})
 }
 
-func (*geminiProvider) GetModuleExternalContexts(sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
-	return gemini.GetModuleExternalContexts(sysMsg, request)
+func (p *ollamaProvider) GetChangeNarrative(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	return withFallback(p.providerModels, fam, sz, func(model string) (*payload.ChangeNarrative, payload.UsageStats, error) {
+		return noUsage(ollama.GetChangeNarrative(ctx, p.client, model, sysMsg, request))
+	})
 }
 
 // -----------------------------------------------------------------------------
 //	Unknown Provider is a throwing stub
 // -----------------------------------------------------------------------------
 
-func (*unknownProvider) GetWorkspaceChangeProposals(_ config.ModelFamily, _ config.ModelSize, _ string, _ *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
-	return nil, fmt.Errorf("unknown provider")
+func (p *unknownProvider) GetWorkspaceChangeProposals(_ context.Context, _ config.ModelFamily, _ config.ModelSize, _ string, _ *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	return nil, payload.UsageStats{}, p.err()
+}
+
+func (p *unknownProvider) GetModuleContext(_ context.Context, _ config.ModelSize, _ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	return nil, payload.UsageStats{}, p.err()
 }
 
-func (*unknownProvider) GetModuleContext(_ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
-	return nil, fmt.Errorf("unknown provider")
+func (p *unknownProvider) GetModuleExternalContexts(_ context.Context, _ config.ModelSize, _ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	return nil, payload.UsageStats{}, p.err()
 }
 
-func (*unknownProvider) GetModuleExternalContexts(_ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
-	return nil, fmt.Errorf("unknown provider")
+func (p *unknownProvider) GetModuleContexts(_ context.Context, _ config.ModelSize, _ string, _ *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	return nil, payload.UsageStats{}, p.err()
+}
+
+func (p *unknownProvider) GetChangeNarrative(_ context.Context, _ config.ModelFamily, _ config.ModelSize, _ string, _ *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	return nil, payload.UsageStats{}, p.err()
 }
 
 // -----------------------------------------------------------------------------
 //  Public façade helpers remain unchanged (dispatcher section).
 // -----------------------------------------------------------------------------
 
-func GetModuleExternalContexts(cfg *config.Config, sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
-	return resolveProvider(cfg).GetModuleExternalContexts(sysMsg, request)
+func GetModuleExternalContexts(ctx context.Context, cfg *config.Config, sysMsg string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	return resolveProvider(cfg).GetModuleExternalContexts(ctx, cfg.Annotation.Size(), sysMsg, request)
+}
+
+func GetModuleContext(ctx context.Context, cfg *config.Config, sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	return resolveProvider(cfg).GetModuleContext(ctx, cfg.Annotation.Size(), sysMsg, request)
+
+}
+
+// GetModuleContexts asks for the internal and public contexts of every
+// module of request in a single call.
+func GetModuleContexts(ctx context.Context, cfg *config.Config, sysMsg string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	return resolveProvider(cfg).GetModuleContexts(ctx, cfg.Annotation.Size(), sysMsg, request)
+}
+
+func GetWorkspaceChangeProposals(ctx context.Context, cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	return proposeWithRepair(ctx, resolveProvider(cfg), fam, sz, sysMsg, request)
+}
+
+// GetChangeNarrative asks the model of fam and sz for the narrative of the
+// changes described by request.
+func GetChangeNarrative(ctx context.Context, cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	return resolveProvider(cfg).GetChangeNarrative(ctx, fam, sz, sysMsg, request)
 }
 
-func GetModuleContext(cfg *config.Config, sysMsg string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
-	return resolveProvider(cfg).GetModuleContext(sysMsg, request)
+// GetWorkspaceChangeProposalsStream is GetWorkspaceChangeProposals with
+// streaming enabled, regardless of http.stream: onProposal is called with
+// the name of every proposed file as soon as its proposal is received.
+// Providers that cannot stream (Anthropic, Ollama) answer synchronously and
+// never call onProposal.
+func GetWorkspaceChangeProposalsStream(ctx context.Context, cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest, onProposal func(fileName string)) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	name := strings.ToLower(cfg.Provider)
+	client := newClient(cfg, name)
+	client.Stream = true
+	client.Progress = progressOutput
+	client.OnProposal = onProposal
+	return proposeWithRepair(ctx, newProvider(cfg, name, client), fam, sz, sysMsg, request)
+}
 
+// proposeWithRepair asks p for a proposal. When the model answers with
+// invalid JSON or a malformed proposal, it asks once more, with the error
+// appended to the system message, before giving up. The usage of both
+// attempts is returned.
+func proposeWithRepair(ctx context.Context, p provider, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	proposal, usage, err := p.GetWorkspaceChangeProposals(ctx, fam, sz, sysMsg, request)
+	if err == nil || !malformedProposal(err) || ctx.Err() != nil {
+		return proposal, usage, err
+	}
+	logging.Log.Warnf("the model returned a malformed proposal, asking again: %v", err)
+	repair := sysMsg + "\n\nYour previous answer was rejected because it was not a valid workspace change proposal:\n" +
+		err.Error() + "\nAnswer again with a complete proposal matching the schema."
+	proposal, retried, err := p.GetWorkspaceChangeProposals(ctx, fam, sz, repair, request)
+	usage.Add(retried)
+	if err != nil && malformedProposal(err) {
+		return nil, usage, fmt.Errorf("the model returned a malformed proposal twice: %w", err)
+	}
+	return proposal, usage, err
 }
-func GetWorkspaceChangeProposals(cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, sysMsg string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
-	return resolveProvider(cfg).GetWorkspaceChangeProposals(fam, sz, sysMsg, request)
+
+// malformedProposal tells whether err reports a model answer that is not
+// a valid WorkspaceChangeProposal, as opposed to a failed request. Answers
+// the provider already asked the model to repair are not retried again.
+func malformedProposal(err error) bool {
+	if errors.Is(err, repair.ErrFailed) {
+		return false
+	}
+	return errors.Is(err, payload.ErrMalformedProposal) || repair.InvalidJSON(err)
 }
 
 // resolveProvider resolves the value of cfg.Provider to one of the known providers.
 // Returns a throwing stub if it can't map the value to any known provider.
 func resolveProvider(cfg *config.Config) provider {
-	switch strings.ToLower(cfg.Provider) {
+	name := strings.ToLower(cfg.Provider)
+	return newProvider(cfg, name, newClient(cfg, name))
+}
+
+// newProvider returns the provider name, sending its requests with client.
+func newProvider(cfg *config.Config, name string, client httpclient.Client) provider {
+	pm := providerModels{name: name, registry: modelRegistry(cfg), fallbacks: fallbackModels(cfg), prices: modelPrices(cfg)}
+	switch name {
 	case "openai":
-		return &openAIProvider{}
+		return &openAIProvider{providerModels: pm, client: client}
 	case "gemini":
-		return &geminiProvider{}
+		return &geminiProvider{providerModels: pm, client: client}
+	case "anthropic":
+		return &anthropicProvider{providerModels: pm, client: client}
+	case "ollama":
+		return &ollamaProvider{providerModels: pm, client: client}
 	default:
-		return &unknownProvider{}
+		return &unknownProvider{name: cfg.Provider}
+	}
+}
+
+// modelRegistry returns the built-in models with the overrides of cfg: the
+// models section, and the ollama model tags for both families.
+func modelRegistry(cfg *config.Config) models.Registry {
+	overrides := models.Registry{}
+	for provider, m := range cfg.Models {
+		overrides[strings.ToLower(provider)] = m
+	}
+	ollamaModels := map[string]string{}
+	for _, fam := range []config.ModelFamily{config.ModelFamilyGPT, config.ModelFamilyReasoning} {
+		ollamaModels[models.Key(fam, config.ModelSizeSmall)] = cfg.Ollama.SmallModel
+		ollamaModels[models.Key(fam, config.ModelSizeLarge)] = cfg.Ollama.LargeModel
+	}
+	// The models section wins over the ollama model tags.
+	for k, m := range overrides["ollama"] {
+		ollamaModels[k] = m
+	}
+	overrides["ollama"] = ollamaModels
+	return models.New(overrides)
+}
+
+// fallbackModels returns the fallback_models section of cfg, keyed by
+// lower-cased provider name.
+func fallbackModels(cfg *config.Config) models.Registry {
+	fallbacks := models.Registry{}
+	for provider, m := range cfg.FallbackModels {
+		fallbacks[strings.ToLower(provider)] = m
+	}
+	return fallbacks
+}
+
+// modelPrices returns the built-in prices with the pricing section of cfg
+// applied on top of them.
+func modelPrices(cfg *config.Config) models.Prices {
+	overrides := models.Prices{}
+	for model, p := range cfg.Pricing {
+		overrides[model] = models.Price{Prompt: p.Prompt, Completion: p.Completion}
+	}
+	return models.NewPrices(overrides)
+}
+
+// requestResponseDebug forces request/response logging on, regardless of
+// the configuration.
+var requestResponseDebug bool
+
+// EnableRequestResponseDebug records every request/response pair under
+// .vyb/logs/ for the rest of the process, as if
+// logging.request-response-debug were set in .vyb/config.yaml.
+func EnableRequestResponseDebug() {
+	requestResponseDebug = true
+}
+
+// progressOutput receives the number of tokens streamed so far, nil when
+// progress reporting is disabled.
+var progressOutput io.Writer = os.Stderr
+
+// DisableProgress stops reporting the progress of streamed responses on
+// stderr for the rest of the process.
+func DisableProgress() {
+	progressOutput = nil
+}
+
+// newClient returns the HTTP client used by the provider name, configured
+// from cfg.
+func newClient(cfg *config.Config, name string) httpclient.Client {
+	client := httpclient.Client{Timeout: cfg.HTTP.RequestTimeout(), MaxRetries: cfg.HTTP.Retries()}
+	if cfg.HTTP.Stream {
+		client.Stream = true
+		client.Progress = progressOutput
+	}
+	if cfg.OutputFooter.Enabled {
+		client.Footer = &footer.Footer{Text: cfg.OutputFooter.Text}
+	}
+	for provider, format := range cfg.RequestFormats {
+		if strings.EqualFold(provider, name) {
+			client.Format = render.Format(strings.ToLower(format))
+		}
+	}
+	switch name {
+	case "openai":
+		client.BaseURL, client.APIKeyEnv = cfg.OpenAI.BaseURL, cfg.OpenAI.APIKeyEnv
+	case "gemini":
+		client.BaseURL, client.APIKeyEnv = cfg.Gemini.BaseURL, cfg.Gemini.APIKeyEnv
+	}
+	if !requestResponseDebug && !cfg.Logging.RequestResponseDebug {
+		return client
+	}
+	if cfg.ProjectRoot == "" {
+		logging.Log.Warn("request/response debugging is enabled outside of a project, nothing will be logged")
+		return client
+	}
+	client.Debug = &debuglog.Logger{
+		Dir:      filepath.Join(cfg.ProjectRoot, filepath.FromSlash(config.LogDir)),
+		Provider: name,
+		Retain:   cfg.Logging.LogRetention(),
 	}
+	return client
 }
@@ -1,45 +1,339 @@
 package llm
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
     "testing"
 
     "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/render"
+    "github.com/vybdev/vyb/llm/payload"
 )
 
 // The following checks ensure that the provider implementations adhere to the
 // provider interface.
 var _ provider = (*openAIProvider)(nil)
 var _ provider = (*geminiProvider)(nil)
+var _ provider = (*anthropicProvider)(nil)
+var _ provider = (*ollamaProvider)(nil)
 
-// TestMapGeminiModel ensures that the (family,size) tuple is translated to
-// the correct concrete model identifier and that unsupported sizes are
-// properly rejected.
-func TestMapGeminiModel(t *testing.T) {
+// TestModelRegistry_Defaults ensures every (family,size) tuple of every
+// provider resolves to the built-in model, and unsupported sizes are
+// rejected.
+func TestModelRegistry_Defaults(t *testing.T) {
     t.Parallel()
 
+    want := map[string][4]string{ // gpt-small, gpt-large, reasoning-small, reasoning-large
+        "openai":    {"GPT-4.1-mini", "GPT-4.1", "o4-mini", "o3"},
+        "gemini":    {"gemini-2.5-flash-preview-05-20", "gemini-2.5-pro-preview-06-05", "gemini-2.5-flash", "gemini-2.5-pro"},
+        "anthropic": {"claude-3-5-haiku-latest", "claude-3-5-sonnet-latest", "claude-3-5-haiku-latest", "claude-3-5-sonnet-latest"},
+        "ollama":    {"qwen2.5-coder:7b", "qwen2.5-coder:32b", "qwen2.5-coder:7b", "qwen2.5-coder:32b"},
+    }
+    registry := modelRegistry(&config.Config{})
+    for _, name := range SupportedProviders() {
+        i := 0
+        for _, fam := range []config.ModelFamily{config.ModelFamilyGPT, config.ModelFamilyReasoning} {
+            for _, sz := range []config.ModelSize{config.ModelSizeSmall, config.ModelSizeLarge} {
+                got, err := registry.Resolve(name, fam, sz)
+                if err != nil {
+                    t.Fatalf("Resolve(%s,%s,%s) returned unexpected error: %v", name, fam, sz, err)
+                }
+                if got != want[name][i] {
+                    t.Fatalf("Resolve(%s,%s,%s) = %q, want %q", name, fam, sz, got, want[name][i])
+                }
+                i++
+            }
+        }
+        if _, err := registry.Resolve(name, config.ModelFamilyGPT, config.ModelSize("medium")); err == nil {
+            t.Fatalf("expected error for unsupported model size of %s, got nil", name)
+        }
+    }
+}
+
+// TestModelRegistry_Overrides ensures the models section and the ollama
+// model tags of .vyb/config.yaml replace the built-in models.
+func TestModelRegistry_Overrides(t *testing.T) {
+    t.Parallel()
+
+    cfg := &config.Config{
+        Provider: "openai",
+        Models: map[string]map[string]string{
+            "OpenAI": {"reasoning-large": "o3-pro"},
+            "ollama": {"gpt-large": "llama3.3:70b"},
+        },
+        Ollama: config.Ollama{SmallModel: "qwen2.5-coder:3b", LargeModel: "qwen2.5-coder:14b"},
+    }
     cases := []struct {
-        fam  config.ModelFamily
-        size config.ModelSize
-        want string
+        provider string
+        fam      config.ModelFamily
+        size     config.ModelSize
+        want     string
     }{
-        {config.ModelFamilyGPT, config.ModelSizeSmall, "gemini-2.5-flash-preview-05-20"},
-        {config.ModelFamilyGPT, config.ModelSizeLarge, "gemini-2.5-pro-preview-06-05"},
-        {config.ModelFamilyReasoning, config.ModelSizeSmall, "gemini-2.5-flash-preview-05-20"},
-        {config.ModelFamilyReasoning, config.ModelSizeLarge, "gemini-2.5-pro-preview-06-05"},
+        {"openai", config.ModelFamilyReasoning, config.ModelSizeLarge, "o3-pro"},
+        {"openai", config.ModelFamilyReasoning, config.ModelSizeSmall, "o4-mini"},
+        {"ollama", config.ModelFamilyGPT, config.ModelSizeLarge, "llama3.3:70b"},
+        {"ollama", config.ModelFamilyReasoning, config.ModelSizeLarge, "qwen2.5-coder:14b"},
+        {"ollama", config.ModelFamilyGPT, config.ModelSizeSmall, "qwen2.5-coder:3b"},
     }
-
     for _, c := range cases {
-        got, err := mapGeminiModel(c.fam, c.size)
+        cfg.Provider = c.provider
+        p := resolveProvider(cfg)
+        var pm providerModels
+        switch p := p.(type) {
+        case *openAIProvider:
+            pm = p.providerModels
+        case *ollamaProvider:
+            pm = p.providerModels
+        }
+        got, err := pm.model(c.fam, c.size)
         if err != nil {
-            t.Fatalf("mapGeminiModel(%s,%s) returned unexpected error: %v", c.fam, c.size, err)
+            t.Fatalf("model(%s,%s) of %s returned unexpected error: %v", c.fam, c.size, c.provider, err)
         }
         if got != c.want {
-            t.Fatalf("mapGeminiModel(%s,%s) = %q, want %q", c.fam, c.size, got, c.want)
+            t.Fatalf("model(%s,%s) of %s = %q, want %q", c.fam, c.size, c.provider, got, c.want)
+        }
+    }
+}
+
+// TestResolveProvider ensures every supported provider name, in any case,
+// resolves to its implementation.
+func TestResolveProvider(t *testing.T) {
+    t.Parallel()
+
+    cases := map[string]provider{
+        "openai":    &openAIProvider{},
+        "Gemini":    &geminiProvider{},
+        "ANTHROPIC": &anthropicProvider{},
+        "ollama":    &ollamaProvider{},
+        "acme":      &unknownProvider{},
+    }
+    for name, want := range cases {
+        got := resolveProvider(&config.Config{Provider: name})
+        if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", want) {
+            t.Fatalf("resolveProvider(%q) = %T, want %T", name, got, want)
+        }
+    }
+
+    for _, name := range SupportedProviders() {
+        if _, unknown := resolveProvider(&config.Config{Provider: name}).(*unknownProvider); unknown {
+            t.Fatalf("supported provider %q is not wired into resolveProvider", name)
+        }
+    }
+}
+
+// TestNewClient_Debug ensures request/response logs are only written when
+// enabled, under the project's .vyb/logs directory.
+func TestNewClient_Debug(t *testing.T) {
+    root := t.TempDir()
+
+    cfg := &config.Config{ProjectRoot: root}
+    if c := newClient(cfg, "openai"); c.Debug != nil {
+        t.Fatalf("request/response logging must be disabled by default")
+    }
+
+    cfg.Logging.RequestResponseDebug = true
+    c := newClient(cfg, "openai")
+    if c.Debug == nil {
+        t.Fatalf("expected request/response logging to be enabled")
+    }
+    if want := filepath.Join(root, ".vyb", "logs"); c.Debug.Dir != want || c.Debug.Provider != "openai" || c.Debug.Retain != config.DefaultRetainLogs {
+        t.Fatalf("unexpected logger %+v", c.Debug)
+    }
+
+    // Outside of a project there is nowhere to write the logs.
+    cfg.ProjectRoot = ""
+    if c := newClient(cfg, "openai"); c.Debug != nil {
+        t.Fatalf("request/response logging requires a project root")
+    }
+}
+
+func TestNewClient_Format(t *testing.T) {
+    cfg := &config.Config{RequestFormats: map[string]string{"Anthropic": "Markdown", "openai": "xml"}}
+    if c := newClient(cfg, "anthropic"); c.Format != render.Markdown {
+        t.Fatalf("expected the anthropic override, got %q", c.Format)
+    }
+    if c := newClient(cfg, "openai"); c.Format != render.XML {
+        t.Fatalf("expected the openai override, got %q", c.Format)
+    }
+    if c := newClient(cfg, "gemini"); c.Format != "" {
+        t.Fatalf("expected the preferred format of gemini, got %q", c.Format)
+    }
+}
+
+// TestNewClient_DebugFiles ensures a request/response log file is written
+// under .vyb/logs for every call when logging.request-response-debug is
+// set, and none otherwise.
+func TestNewClient_DebugFiles(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+        fmt.Fprint(w, `{"ok":true}`)
+    }))
+    t.Cleanup(srv.Close)
+
+    logFiles := func(root string, debug bool) []string {
+        t.Helper()
+        cfg := &config.Config{ProjectRoot: root}
+        cfg.Logging.RequestResponseDebug = debug
+        req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"q":1}`))
+        if err != nil {
+            t.Fatalf("NewRequest: %v", err)
+        }
+        resp, err := newClient(cfg, "openai").Do(req)
+        if err != nil {
+            t.Fatalf("Do: %v", err)
+        }
+        resp.Body.Close()
+        matches, _ := filepath.Glob(filepath.Join(root, ".vyb", "logs", "*-openai-*.json"))
+        return matches
+    }
+
+    if files := logFiles(t.TempDir(), false); len(files) != 0 {
+        t.Fatalf("expected no log file when disabled, got %v", files)
+    }
+    root := t.TempDir()
+    files := logFiles(root, true)
+    if len(files) != 1 {
+        t.Fatalf("expected one log file when enabled, got %v", files)
+    }
+    if data, err := os.ReadFile(files[0]); err != nil || !strings.Contains(string(data), `"ok": true`) {
+        t.Fatalf("expected the response in the log file, got %q (%v)", data, err)
+    }
+}
+
+// TestNewClient_BaseURL ensures each provider gets its own configured base
+// URL and API key variable.
+func TestNewClient_BaseURL(t *testing.T) {
+    cfg := &config.Config{
+        OpenAI: config.Endpoint{BaseURL: "https://openai.example.com/v1", APIKeyEnv: "OPENAI_PROXY_KEY"},
+        Gemini: config.Endpoint{BaseURL: "https://gemini.example.com/v1beta"},
+    }
+    for name, want := range map[string]string{
+        "openai":    "https://openai.example.com/v1",
+        "gemini":    "https://gemini.example.com/v1beta",
+        "anthropic": "",
+    } {
+        if got := newClient(cfg, name).BaseURL; got != want {
+            t.Fatalf("newClient(%q).BaseURL = %q, want %q", name, got, want)
+        }
+    }
+    for name, want := range map[string]string{"openai": "OPENAI_PROXY_KEY", "gemini": ""} {
+        if got := newClient(cfg, name).APIKeyEnv; got != want {
+            t.Fatalf("newClient(%q).APIKeyEnv = %q, want %q", name, got, want)
         }
     }
+}
+
+// TestWithFallback ensures a model the provider no longer serves is retried
+// with the configured fallback, or else with the model of the other size.
+func TestWithFallback(t *testing.T) {
+    var requested []string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        model := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/models/"), ":generateContent")
+        requested = append(requested, model)
+        if model == "retired-preview" {
+            w.WriteHeader(http.StatusNotFound)
+            fmt.Fprint(w, `{"error":{"code":404,"message":"models/retired-preview is not found","status":"NOT_FOUND"}}`)
+            return
+        }
+        fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"{\"internal_context\":\"i\",\"public_context\":\"p\"}"}]}}],"usageMetadata":{"promptTokenCount":2000000,"candidatesTokenCount":1000000}}`)
+    }))
+    t.Cleanup(srv.Close)
+    t.Setenv("GEMINI_API_KEY", "x")
+
+    // The usage is priced for the model that answered.
+    cases := []struct {
+        name      string
+        fallbacks map[string]map[string]string
+        want      []string
+        wantUsage payload.UsageStats
+    }{
+        {"configured fallback", map[string]map[string]string{"Gemini": {"reasoning-large": "gemini-2.5-pro"}}, []string{"retired-preview", "gemini-2.5-pro"},
+            payload.UsageStats{Calls: 1, PromptTokens: 2000000, CompletionTokens: 1000000, Cost: 15}},
+        {"other size", nil, []string{"retired-preview", "gemini-2.5-flash"},
+            payload.UsageStats{Calls: 1, PromptTokens: 2000000, CompletionTokens: 1000000, Cost: 3.1}},
+    }
+    for _, c := range cases {
+        requested = nil
+        cfg := &config.Config{
+            Provider:       "gemini",
+            Models:         map[string]map[string]string{"gemini": {"reasoning-large": "retired-preview"}},
+            FallbackModels: c.fallbacks,
+            Gemini:         config.Endpoint{BaseURL: srv.URL},
+            Pricing:        map[string]config.ModelPrice{"Gemini-2.5-Pro": {Prompt: 5, Completion: 5}},
+        }
+        cfg.Annotation.ModelSize = config.ModelSizeLarge
+        got, usage, err := GetModuleContext(context.Background(), cfg, "sys", &payload.ModuleContextRequest{TargetModuleName: "m"})
+        if err != nil {
+            t.Fatalf("%s: unexpected error: %v", c.name, err)
+        }
+        if got.InternalContext != "i" || !reflect.DeepEqual(requested, c.want) {
+            t.Fatalf("%s: got %+v after requesting %v, want %v", c.name, got, requested, c.want)
+        }
+        if usage.Calls != 1 || usage.Unpriced != 0 || fmt.Sprintf("%.4f", usage.Cost) != fmt.Sprintf("%.4f", c.wantUsage.Cost) {
+            t.Fatalf("%s: got usage %+v, want %+v", c.name, usage, c.wantUsage)
+        }
+    }
+
+    // Without a distinct fallback, the model-not-found error is returned.
+    requested = nil
+    cfg := &config.Config{
+        Provider: "gemini",
+        Models:   map[string]map[string]string{"gemini": {"reasoning-large": "retired-preview", "reasoning-small": "retired-preview"}},
+        Gemini:   config.Endpoint{BaseURL: srv.URL},
+    }
+    cfg.Annotation.ModelSize = config.ModelSizeLarge
+    if _, _, err := GetModuleContext(context.Background(), cfg, "sys", &payload.ModuleContextRequest{TargetModuleName: "m"}); !errors.Is(err, ErrModelNotFound) || len(requested) != 1 {
+        t.Fatalf("expected ErrModelNotFound after a single request, got %v after requesting %v", err, requested)
+    }
+}
+
+func TestGetWorkspaceChangeProposals_Repair(t *testing.T) {
+    var answers, bodies []string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        bodies = append(bodies, string(body))
+        text := answers[0]
+        answers = answers[1:]
+        resp, _ := json.Marshal(map[string]any{
+            "candidates":    []any{map[string]any{"content": map[string]any{"parts": []any{map[string]any{"text": text}}}}},
+            "usageMetadata": map[string]any{"promptTokenCount": 100, "candidatesTokenCount": 10},
+        })
+        w.Write(resp)
+    }))
+    t.Cleanup(srv.Close)
+    t.Setenv("GEMINI_API_KEY", "x")
+    cfg := &config.Config{Provider: "gemini", Gemini: config.Endpoint{BaseURL: srv.URL}, Models: map[string]map[string]string{"gemini": {"gpt-small": "unpriced-model"}}}
+    request := &payload.WorkspaceChangeRequest{TargetModule: ".", TargetDirectory: "."}
+    valid := `{"description":"d","summary":"s","proposals":[{"file_name":"a.go","content":"package a","delete":false}]}`
+
+    // A malformed answer is repaired by asking again with the error.
+    answers, bodies = []string{`{"description":"d","summary":"s","proposals":[{"file_name":"","content":"x","delete":false}]}`, valid}, nil
+    got, usage, err := GetWorkspaceChangeProposals(context.Background(), cfg, config.ModelFamilyGPT, config.ModelSizeSmall, "sys", request)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if usage != (payload.UsageStats{Calls: 2, PromptTokens: 200, CompletionTokens: 20, Unpriced: 2}) {
+        t.Fatalf("expected the usage of both requests, got %+v", usage)
+    }
+    if len(got.Proposals) != 1 || got.Proposals[0].FileName != "a.go" {
+        t.Fatalf("unexpected proposal %+v", got)
+    }
+    if len(bodies) != 2 || !strings.Contains(bodies[1], "proposals[0].file_name is empty") {
+        t.Fatalf("expected a second request naming the error, got %v", bodies)
+    }
 
-    // Ensure an unsupported size triggers an error.
-    if _, err := mapGeminiModel(config.ModelFamilyGPT, config.ModelSize("medium")); err == nil {
-        t.Fatalf("expected error for unsupported model size, got nil")
+    // Truncated JSON is repaired too, but only once.
+    answers, bodies = []string{`{"description":"d","proposals":[{"file_na`, `{"description":"d","summary":"s","proposals":[{"file_name":"../x.go","content":"x","delete":false}]}`}, nil
+    _, _, err = GetWorkspaceChangeProposals(context.Background(), cfg, config.ModelFamilyGPT, config.ModelSizeSmall, "sys", request)
+    if !errors.Is(err, payload.ErrMalformedProposal) || !strings.Contains(err.Error(), "proposals[0].file_name") || len(bodies) != 2 {
+        t.Fatalf("expected the malformed proposal error after two requests, got %v after %d requests", err, len(bodies))
     }
 }
@@ -0,0 +1,16 @@
+package llm
+
+import "github.com/vybdev/vyb/llm/internal/httpclient"
+
+// ErrRequestTooLarge is matched, with errors.Is, by the error returned when a
+// request exceeds the body size accepted by the provider. Nothing is sent in
+// that case.
+var ErrRequestTooLarge = httpclient.ErrRequestTooLarge
+
+// RequestTooLargeError details an ErrRequestTooLarge failure: the measured
+// size of the request body and the provider limit, in bytes.
+type RequestTooLargeError = httpclient.RequestTooLargeError
+
+// ErrModelNotFound is matched, with errors.Is, by the error returned when
+// the provider does not serve the requested model, nor its fallback.
+var ErrModelNotFound = httpclient.ErrModelNotFound
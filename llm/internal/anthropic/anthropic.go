@@ -0,0 +1,274 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vybdev/vyb/llm/internal/anthropic/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/footer"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/internal/render"
+	"github.com/vybdev/vyb/llm/payload"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// maxRequestBytes is the largest request body accepted by the Messages API
+// (32 MB).
+const maxRequestBytes = 32 << 20
+
+// preferredFormat is the layout of the requests sent to Claude, which
+// responds better to XML-tagged context, unless the configuration
+// overrides it.
+const preferredFormat = render.XML
+
+// GetWorkspaceChangeProposals composes the request, sends it to Claude and
+// converts the response into a strongly-typed WorkspaceChangeProposal.
+func GetWorkspaceChangeProposals(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
+	userMessage, err := render.WorkspaceChangeRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to serialize workspace change request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "workspace_change_proposal", footer.Fields(schema.GetWorkspaceChangeProposalTool().InputSchema.Properties))
+
+	raw, err := callAnthropic(ctx, client, systemMessage, userMessage, schema.GetWorkspaceChangeProposalTool(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposal payload.WorkspaceChangeProposal
+	if err := json.Unmarshal(raw, &proposal); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal WorkspaceChangeProposal: %w", err)
+	}
+	return &proposal, nil
+}
+
+func GetModuleContext(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
+	userMessage, err := render.ModuleContextRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to serialize module context request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "module_selfcontained_context", footer.Fields(schema.GetModuleContextTool().InputSchema.Properties))
+
+	raw, err := callAnthropic(ctx, client, systemMessage, userMessage, schema.GetModuleContextTool(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var out payload.ModuleSelfContainedContext
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal ModuleSelfContainedContext: %w", err)
+	}
+	return &out, nil
+}
+
+func GetModuleExternalContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
+	userMessage, err := render.ExternalContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to serialize external contexts request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "module_external_context", footer.Fields(schema.GetModuleExternalContextTool().InputSchema.Properties))
+
+	raw, err := callAnthropic(ctx, client, systemMessage, userMessage, schema.GetModuleExternalContextTool(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var ext payload.ModuleExternalContextResponse
+	if err := json.Unmarshal(raw, &ext); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal ModuleExternalContextResponse: %w", err)
+	}
+	return &ext, nil
+}
+
+// GetModuleContexts calls the LLM and returns the internal and public
+// contexts of every module of request, generated in a single call with the
+// given model.
+func GetModuleContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, error) {
+	userMessage, err := render.ModuleContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to serialize module contexts request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "module_contexts", footer.Fields(schema.GetModuleContextsTool().InputSchema.Properties))
+
+	raw, err := callAnthropic(ctx, client, systemMessage, userMessage, schema.GetModuleContextsTool(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var out payload.ModuleContextsResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal ModuleContextsResponse: %w", err)
+	}
+	return &out, nil
+}
+
+func GetChangeNarrative(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, error) {
+	userMessage, err := render.ChangeNarrativeRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to serialize change narrative request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "change_narrative", footer.Fields(schema.GetChangeNarrativeTool().InputSchema.Properties))
+
+	raw, err := callAnthropic(ctx, client, systemMessage, userMessage, schema.GetChangeNarrativeTool(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var narrative payload.ChangeNarrative
+	if err := json.Unmarshal(raw, &narrative); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal ChangeNarrative: %w", err)
+	}
+	return &narrative, nil
+}
+
+// -----------------------------------------------------------------------------
+// Provider-specific data structures & helpers (non-exported)
+// -----------------------------------------------------------------------------
+
+// NOTE: baseEndpoint is a var (not const) to allow test overrides.
+var baseEndpoint = "https://api.anthropic.com/v1"
+
+const (
+	messagesPath = "/messages"
+	apiVersion   = "2023-06-01"
+	// maxTokens caps the length of the response. Workspace change proposals
+	// carry whole files, so keep it at the maximum output of the models.
+	maxTokens = 8192
+)
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type requestPayload struct {
+	Model      string        `json:"model"`
+	MaxTokens  int           `json:"max_tokens"`
+	System     string        `json:"system,omitempty"`
+	Messages   []message     `json:"messages"`
+	Tools      []schema.Tool `json:"tools"`
+	ToolChoice toolChoice    `json:"tool_choice"`
+}
+
+// anthropicResponse mirrors the minimal subset of the response envelope we
+// care about.
+//
+// { "content": [ {"type": "tool_use", "name": "...", "input": {...}} ], "stop_reason": "tool_use" }
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+type anthropicErrorResponse struct {
+	Err struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e anthropicErrorResponse) Error() string {
+	return fmt.Sprintf("Anthropic API error (%s): %s", e.Err.Type, e.Err.Message)
+}
+
+func buildRequest(systemMessage, userMessage string, tool schema.Tool, model string) ([]byte, error) {
+	if userMessage == "" {
+		return nil, errors.New("anthropic: user message cannot be empty")
+	}
+	r := requestPayload{
+		Model:     model,
+		MaxTokens: maxTokens,
+		System:    systemMessage,
+		Messages:  []message{{Role: "user", Content: userMessage}},
+		Tools:     []schema.Tool{tool},
+		// Forcing the tool makes Claude answer with a tool_use block whose
+		// input follows the tool's input_schema.
+		ToolChoice: toolChoice{Type: "tool", Name: tool.Name},
+	}
+	return json.Marshal(r)
+}
+
+// toolInput returns the JSON input of the tool_use block named toolName. A
+// plain text answer is accepted as a fallback when it holds JSON.
+func (r *anthropicResponse) toolInput(toolName string) ([]byte, error) {
+	for _, c := range r.Content {
+		if c.Type == "tool_use" && c.Name == toolName && len(c.Input) > 0 {
+			return c.Input, nil
+		}
+	}
+	for _, c := range r.Content {
+		if text := strings.TrimSpace(c.Text); c.Type == "text" && json.Valid([]byte(text)) {
+			return []byte(text), nil
+		}
+	}
+	if r.StopReason == "max_tokens" {
+		return nil, errors.New("anthropic: response truncated, max_tokens reached")
+	}
+	return nil, errors.New("anthropic: empty response")
+}
+
+// callAnthropic sends a request to the Messages API and returns the
+// structured output produced through tool.
+func callAnthropic(ctx context.Context, client httpclient.Client, systemMessage, userMessage string, tool schema.Tool, model string) ([]byte, error) {
+	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	if apiKey == "" {
+		return nil, errors.New("ANTHROPIC_API_KEY is not set")
+	}
+
+	if model == "" {
+		return nil, errors.New("anthropic: model must not be empty")
+	}
+
+	bodyBytes, err := buildRequest(systemMessage, userMessage, tool, model)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseEndpoint+messagesPath, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", apiVersion)
+
+	client.MaxBodyBytes = maxRequestBytes
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var aErr anthropicErrorResponse
+		if jsonErr := json.Unmarshal(respBytes, &aErr); jsonErr == nil && aErr.Err.Message != "" {
+			return nil, httpclient.StatusError(resp.StatusCode, model, aErr)
+		}
+		return nil, httpclient.StatusError(resp.StatusCode, model, fmt.Errorf("anthropic: http %d – %s", resp.StatusCode, string(respBytes)))
+	}
+
+	var out anthropicResponse
+	if err := json.Unmarshal(respBytes, &out); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal response: %w", err)
+	}
+	return out.toolInput(tool.Name)
+}
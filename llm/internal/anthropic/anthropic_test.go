@@ -0,0 +1,139 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// toolUseServer returns a dummy Messages API answering every request with a
+// tool_use block holding input.
+func toolUseServer(t *testing.T, input string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != messagesPath {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "x" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("missing authentication headers: %v", r.Header)
+		}
+		var req requestPayload
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if req.System != "sys" || len(req.Tools) != 1 || req.ToolChoice.Name != req.Tools[0].Name {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		resp := map[string]any{
+			"content": []any{
+				map[string]any{
+					"type":  "tool_use",
+					"name":  req.Tools[0].Name,
+					"input": json.RawMessage(input),
+				},
+			},
+			"stop_reason": "tool_use",
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestGetWorkspaceChangeProposals(t *testing.T) {
+	srv := toolUseServer(t, `{"summary":"s","description":"d","proposals":[]}`)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	t.Setenv("ANTHROPIC_API_KEY", "x")
+
+	req := &payload.WorkspaceChangeRequest{
+		TargetModule:        "test-module",
+		TargetModuleContext: "Test module context",
+		TargetDirectory:     "src/",
+		Files: []payload.FileContent{
+			{Path: "test.go", Content: "package main"},
+		},
+	}
+	got, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "claude-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.WorkspaceChangeProposal{Summary: "s", Description: "d", Proposals: []payload.FileChangeProposal{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected proposal: got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetModuleContext(t *testing.T) {
+	srv := toolUseServer(t, `{"internal_context":"i","public_context":"p"}`)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	t.Setenv("ANTHROPIC_API_KEY", "x")
+
+	got, err := GetModuleContext(context.Background(), httpclient.Client{}, "claude-test", "sys", &payload.ModuleContextRequest{TargetModuleName: "test-module"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ctx: %+v", got)
+	}
+}
+
+func TestGetModuleExternalContexts(t *testing.T) {
+	srv := toolUseServer(t, `{"modules":[{"name":"foo","external_context":"bar"}]}`)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	t.Setenv("ANTHROPIC_API_KEY", "x")
+
+	req := &payload.ExternalContextsRequest{
+		Modules: []payload.ModuleInfoForExternalContext{
+			{Name: "foo"},
+		},
+	}
+	got, err := GetModuleExternalContexts(context.Background(), httpclient.Client{}, "claude-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleExternalContextResponse{Modules: []payload.ModuleExternalContext{{Name: "foo", ExternalContext: "bar"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ext ctx: %+v", got)
+	}
+}
+
+func TestCallAnthropic_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+	}))
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	t.Setenv("ANTHROPIC_API_KEY", "x")
+
+	_, err := GetModuleContext(context.Background(), httpclient.Client{}, "claude-test", "sys", &payload.ModuleContextRequest{TargetModuleName: "test-module"})
+	var apiErr anthropicErrorResponse
+	if !errors.As(err, &apiErr) || apiErr.Err.Type != "rate_limit_error" {
+		t.Fatalf("expected a typed rate limit error, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"github.com/vybdev/vyb/llm/internal/schemacheck"
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// Tool describes the single tool Claude is forced to call. Its input_schema
+// carries the JSON schema of the expected response, so the tool input is the
+// structured output.
+type Tool struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	InputSchema schemas.JSONSchema `json:"input_schema"`
+}
+
+// GetWorkspaceChangeProposalTool returns the tool used to request workspace
+// change proposals.
+func GetWorkspaceChangeProposalTool() Tool {
+	return getTool("workspace_change_proposal", "Propose changes to files in the user's workspace.", schemas.WorkspaceChangeProposal)
+}
+
+// GetModuleContextTool returns the tool used for module context generation.
+func GetModuleContextTool() Tool {
+	return getTool("module_selfcontained_context", "Record the internal and public context of a module.", schemas.ModuleContext)
+}
+
+// GetModuleExternalContextTool returns the tool used when requesting
+// external contexts in bulk.
+func GetModuleExternalContextTool() Tool {
+	return getTool("module_external_context", "Record the external context of each module.", schemas.ModuleExternalContext)
+}
+
+// GetModuleContextsTool returns the tool used when requesting the contexts
+// of several modules at once.
+func GetModuleContextsTool() Tool {
+	return getTool("module_contexts", "Record the internal and public context of each module.", schemas.ModuleContexts)
+}
+
+// GetChangeNarrativeTool returns the tool used when describing applied
+// changes.
+func GetChangeNarrativeTool() Tool {
+	return getTool("change_narrative", "Record the description of changes applied to the workspace.", schemas.ChangeNarrative)
+}
+
+func getTool(name, description, schema string) Tool {
+	return Tool{Name: name, Description: description, InputSchema: schemas.MustLoad[schemas.JSONSchema](schema, dialect)}
+}
+
+// dialect is the subset of JSON Schema accepted as a tool input_schema.
+var dialect = schemacheck.Dialect{Keywords: schemacheck.Keywords("additionalProperties")}
@@ -0,0 +1,15 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// TestDialect ensures the shared schemas are valid tool input schemas, so a
+// malformed edit fails here rather than at run time.
+func TestDialect(t *testing.T) {
+	if err := schemas.Check(dialect); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package debuglog records the request/response pairs exchanged with LLM
+// providers, when request/response debugging is enabled.
+package debuglog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vybdev/vyb/logging"
+)
+
+// Logger writes every request/response pair to its own JSON file under Dir,
+// named after the time of the exchange and the provider so files sort
+// chronologically. Only the Retain most recent files are kept.
+type Logger struct {
+	Dir      string
+	Provider string
+	// Retain is the number of log files kept in Dir. Zero or less keeps
+	// every file.
+	Retain int
+}
+
+// NOTE: now is a var (not a direct call) to allow test overrides.
+var now = time.Now
+
+// timeLayout sorts lexicographically in chronological order.
+const timeLayout = "20060102-150405.000"
+
+// Write records request and response. Failures are logged and otherwise
+// ignored: debugging must never break a command.
+func (l *Logger) Write(request, response []byte) {
+	if l == nil {
+		return
+	}
+	path, err := l.write(request, response)
+	if err != nil {
+		logging.Log.Warnf("failed to write %s request/response log: %v", l.Provider, err)
+		return
+	}
+	logging.Log.Infof("Wrote %s request/response log to %s", l.Provider, path)
+	if err := l.prune(); err != nil {
+		logging.Log.Warnf("failed to prune request/response logs in %s: %v", l.Dir, err)
+	}
+}
+
+func (l *Logger) write(request, response []byte) (string, error) {
+	entry := struct {
+		RequestBytes  int             `json:"request_bytes"`
+		ResponseBytes int             `json:"response_bytes"`
+		Request       json.RawMessage `json:"request"`
+		Response      json.RawMessage `json:"response"`
+	}{
+		RequestBytes:  len(request),
+		ResponseBytes: len(response),
+		Request:       asJSON(request),
+		Response:      asJSON(response),
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(l.Dir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(l.Dir, fmt.Sprintf("%s-%s-*.json", now().UTC().Format(timeLayout), l.Provider))
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// prune removes the oldest log files beyond l.Retain.
+func (l *Logger) prune() error {
+	if l.Retain <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= l.Retain {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-l.Retain] {
+		if err := os.Remove(filepath.Join(l.Dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// asJSON returns data as is when it is valid JSON, or as a JSON string
+// otherwise (e.g. an HTML error page).
+func asJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return data
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
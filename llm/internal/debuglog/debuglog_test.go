@@ -0,0 +1,59 @@
+package debuglog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite_RetainsRecentLogs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := now
+	now = func() time.Time { return clock }
+	defer func() { now = old }()
+
+	l := &Logger{Dir: dir, Provider: "openai", Retain: 2}
+	for i := 0; i < 3; i++ {
+		l.Write([]byte(`{"model":"m"}`), []byte("<html>bad gateway</html>"))
+		clock = clock.Add(time.Second)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 retained logs, got %d", len(entries))
+	}
+	// The oldest log (12:00:00) was pruned.
+	for i, e := range entries {
+		want := []string{"20250101-120001.000-openai-", "20250101-120002.000-openai-"}[i]
+		if !strings.HasPrefix(e.Name(), want) {
+			t.Fatalf("unexpected log file %s, want prefix %s", e.Name(), want)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	var entry struct {
+		Request  map[string]string `json:"request"`
+		Response string            `json:"response"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("invalid log entry: %v\n%s", err, data)
+	}
+	if entry.Request["model"] != "m" || entry.Response != "<html>bad gateway</html>" {
+		t.Fatalf("unexpected log entry %+v", entry)
+	}
+}
+
+func TestWrite_Disabled(t *testing.T) {
+	var l *Logger
+	l.Write([]byte("{}"), []byte("{}")) // must not panic
+}
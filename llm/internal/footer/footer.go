@@ -0,0 +1,50 @@
+// Package footer builds the reminder of the expected response format that
+// providers append to user messages, for models drifting from structured
+// output (fenced or prose answers).
+package footer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Footer reminds the model of the output constraints at the end of every
+// user message.
+type Footer struct {
+	// Text is appended after the default reminder, e.g. to add advice
+	// specific to a local model.
+	Text string
+}
+
+// Append returns msg followed by a reminder to answer with a single JSON
+// object matching the schema called name, made of the given top-level
+// fields. A nil Footer returns msg unchanged.
+func (f *Footer) Append(msg, name string, fields []string) string {
+	if f == nil {
+		return msg
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(msg, "\n"))
+	sb.WriteString("\n\n---\n")
+	fmt.Fprintf(&sb, "Respond ONLY with a single JSON object matching the `%s` schema", name)
+	if len(fields) > 0 {
+		fmt.Fprintf(&sb, ", with the top-level fields `%s`", strings.Join(fields, "`, `"))
+	}
+	sb.WriteString(". Do not wrap it in markdown code fences and do not add any prose before or after it.\n")
+	if text := strings.TrimSpace(f.Text); text != "" {
+		sb.WriteString(text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Fields returns the sorted names of the given schema properties.
+func Fields[T any](properties map[string]T) []string {
+	fields := make([]string, 0, len(properties))
+	for name := range properties {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
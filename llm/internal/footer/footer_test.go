@@ -0,0 +1,27 @@
+package footer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	fields := Fields(map[string]int{"summary": 0, "proposals": 0})
+
+	var disabled *Footer
+	if got := disabled.Append("# Files\n", "workspace_change_proposal", fields); got != "# Files\n" {
+		t.Fatalf("expected a nil footer to leave the message unchanged, got %q", got)
+	}
+
+	got := (&Footer{Text: "Never use tabs."}).Append("# Files\n", "workspace_change_proposal", fields)
+	for _, want := range []string{
+		"# Files\n\n---\n",
+		"matching the `workspace_change_proposal` schema, with the top-level fields `proposals`, `summary`.",
+		"Do not wrap it in markdown code fences",
+		"Never use tabs.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected footer to contain %q, got:\n%s", want, got)
+		}
+	}
+}
@@ -2,311 +2,180 @@ package gemini
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/footer"
 	"github.com/vybdev/vyb/llm/internal/gemini/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/internal/render"
+	"github.com/vybdev/vyb/llm/internal/repair"
 	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/logging"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
-// mapModel converts the (family,size) tuple into the concrete Gemini
-// model identifier expected by the REST endpoint.
-func mapModel(fam config.ModelFamily, sz config.ModelSize) (string, error) {
-	// The same resolution logic lives also inside llm/dispatcher for the
-	// compile-time tests that exercise dispatch mapping. Keep both in
-	// sync until the refactor that centralises it lands.
-	switch sz {
-	case config.ModelSizeSmall:
-		return "gemini-2.5-flash-preview-05-20", nil
-	case config.ModelSizeLarge:
-		return "gemini-2.5-pro-preview-06-05", nil
-	default:
-		return "", fmt.Errorf("gemini: unsupported model size %s", sz)
-	}
-}
+// maxRequestBytes is the largest request body accepted by the
+// generateContent endpoint (20 MB).
+const maxRequestBytes = 20 << 20
+
+// preferredFormat is the layout of the requests sent to Gemini models,
+// unless the configuration overrides it.
+const preferredFormat = render.Markdown
 
 // GetWorkspaceChangeProposals composes the request, sends it to Gemini and
-// converts the response into a strongly-typed WorkspaceChangeProposal.
+// converts the response into a strongly-typed WorkspaceChangeProposal,
+// returned with the usage of the calls made.
 //
 // The function mirrors the public surface exposed by the OpenAI provider so
 // callers can remain provider-agnostic.
-func GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
-	userMessage, err := serializeWorkspaceChangeRequest(request)
-	if err != nil {
-		return nil, fmt.Errorf("gemini: failed to serialize workspace change request: %w", err)
-	}
-	model, err := mapModel(fam, sz)
+func GetWorkspaceChangeProposals(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	userMessage, err := render.WorkspaceChangeRequest(client.Format.Or(preferredFormat), request)
 	if err != nil {
-		return nil, err
+		return nil, payload.UsageStats{}, fmt.Errorf("gemini: failed to serialize workspace change request: %w", err)
 	}
+	userMessage = client.Footer.Append(userMessage, "workspace_change_proposal", footer.Fields(schema.GetWorkspaceChangeProposalSchema().Properties))
 
-	if os.Getenv("GEMINI_API_KEY") == "" {
-		return nil, errors.New("GEMINI_API_KEY is not set")
+	if _, err := lookupAPIKey(client); err != nil {
+		return nil, payload.UsageStats{}, err
 	}
 
-	resp, err := callGemini([]string{systemMessage, userMessage}, schema.GetWorkspaceChangeProposalSchema(), model)
+	contents, err := userContents([]string{systemMessage, userMessage})
 	if err != nil {
-		return nil, err
-	}
-
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return nil, errors.New("gemini: empty response")
+		return nil, payload.UsageStats{}, err
 	}
-
-	raw := resp.Candidates[0].Content.Parts[0].Text
-
-	var proposal payload.WorkspaceChangeProposal
-	if err := json.Unmarshal([]byte(raw), &proposal); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal WorkspaceChangeProposal: %w", err)
-	}
-	return &proposal, nil
-}
-
-func GetModuleContext(systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
-	userMessage, err := serializeModuleContextRequest(request)
+	resp, err := callGeminiContents(ctx, client, contents, schema.GetWorkspaceChangeProposalSchema(), model)
 	if err != nil {
-		return nil, fmt.Errorf("gemini: failed to serialize module context request: %w", err)
+		return nil, payload.UsageStats{}, err
 	}
-	model, err := mapModel(config.ModelFamilyReasoning, config.ModelSizeSmall)
-	if err != nil {
-		return nil, err
+	usage := resp.stats()
+	proposal, err := decodeCandidate[payload.WorkspaceChangeProposal](resp)
+	if !repair.InvalidJSON(err) || ctx.Err() != nil {
+		return proposal, usage, err
 	}
 
-	resp, err := callGemini([]string{systemMessage, userMessage}, schema.GetModuleContextSchema(), model)
+	// Send the invalid answer back once, so the model can fix it.
+	logging.Log.Warnf("Gemini model %s returned invalid JSON, asking it to repair it: %v", model, err)
+	contents = append(contents,
+		content{Role: "model", Parts: []part{{Text: candidateText(resp)}}},
+		content{Role: "user", Parts: []part{{Text: repair.Prompt(err)}}})
+	resp, err = callGeminiContents(ctx, client, contents, schema.GetWorkspaceChangeProposalSchema(), model)
 	if err != nil {
-		return nil, err
+		return nil, usage, err
 	}
-
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return nil, errors.New("gemini: empty response")
+	usage.Add(resp.stats())
+	proposal, err = decodeCandidate[payload.WorkspaceChangeProposal](resp)
+	if err != nil {
+		return nil, usage, repair.Failed(err)
 	}
+	return proposal, usage, err
+}
 
-	raw := resp.Candidates[0].Content.Parts[0].Text
-
-	var ctx payload.ModuleSelfContainedContext
-	if err := json.Unmarshal([]byte(raw), &ctx); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal ModuleSelfContainedContext: %w", err)
+// candidateText returns the text of the first candidate of resp.
+func candidateText(resp *geminiResponse) string {
+	for _, c := range resp.Candidates {
+		if len(c.Content.Parts) > 0 {
+			return c.Content.Parts[0].Text
+		}
 	}
-	return &ctx, nil
+	return ""
 }
 
-func GetModuleExternalContexts(systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
-	userMessage, err := serializeExternalContextsRequest(request)
-	if err != nil {
-		return nil, fmt.Errorf("gemini: failed to serialize external contexts request: %w", err)
-	}
-	model, err := mapModel(config.ModelFamilyReasoning, config.ModelSizeSmall)
+func GetModuleContext(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	userMessage, err := render.ModuleContextRequest(client.Format.Or(preferredFormat), request)
 	if err != nil {
-		return nil, err
+		return nil, payload.UsageStats{}, fmt.Errorf("gemini: failed to serialize module context request: %w", err)
 	}
+	userMessage = client.Footer.Append(userMessage, "module_selfcontained_context", footer.Fields(schema.GetModuleContextSchema().Properties))
 
-	resp, err := callGemini([]string{systemMessage, userMessage}, schema.GetModuleExternalContextSchema(), model)
+	resp, err := callGemini(ctx, client, []string{systemMessage, userMessage}, schema.GetModuleContextSchema(), model)
 	if err != nil {
-		return nil, err
+		return nil, payload.UsageStats{}, err
 	}
 
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return nil, errors.New("gemini: empty response")
-	}
-
-	raw := resp.Candidates[0].Content.Parts[0].Text
-
-	var ext payload.ModuleExternalContextResponse
-	if err := json.Unmarshal([]byte(raw), &ext); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal ModuleExternalContextResponse: %w", err)
-	}
-	return &ext, nil
+	out, err := decodeCandidate[payload.ModuleSelfContainedContext](resp)
+	return out, resp.stats(), err
 }
 
-// -----------------------------------------------------------------------------
-//
-//	Request Serializers
-//
-// -----------------------------------------------------------------------------
-
-func serializeWorkspaceChangeRequest(request *payload.WorkspaceChangeRequest) (string, error) {
-	if request == nil {
-		return "", fmt.Errorf("WorkspaceChangeRequest must not be nil")
-	}
-	if request.TargetModule == "" {
-		return "", fmt.Errorf("TargetModule is required")
-	}
-	if request.TargetDirectory == "" {
-		return "", fmt.Errorf("TargetDirectory is required")
-	}
-
-	var sb strings.Builder
-
-	// Write target module information (these are now required)
-	sb.WriteString(fmt.Sprintf("# Target Module: `%s`\n", request.TargetModule))
-	sb.WriteString("## Target Module Context\n")
-	sb.WriteString(fmt.Sprintf("%s\n\n", request.TargetModuleContext))
-	sb.WriteString(fmt.Sprintf("## Target Directory: `%s`\n\n", request.TargetDirectory))
-
-	// Write parent module contexts
-	if len(request.ParentModuleContexts) > 0 {
-		sb.WriteString("# Parent Module Contexts\n")
-		for _, mc := range request.ParentModuleContexts {
-			ctx := &payload.ModuleSelfContainedContext{
-				Name:          mc.Name,
-				PublicContext: mc.Content,
-			}
-			writeModule(&sb, mc.Name, ctx)
-		}
-		sb.WriteString("\n")
-	}
-
-	// Write sub-module contexts
-	if len(request.SubModuleContexts) > 0 {
-		sb.WriteString("# Sub-Module Contexts\n")
-		for _, mc := range request.SubModuleContexts {
-			ctx := &payload.ModuleSelfContainedContext{
-				Name:          mc.Name,
-				PublicContext: mc.Content,
-			}
-			writeModule(&sb, mc.Name, ctx)
-		}
-		sb.WriteString("\n")
+func GetModuleExternalContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	userMessage, err := render.ExternalContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, payload.UsageStats{}, fmt.Errorf("gemini: failed to serialize external contexts request: %w", err)
 	}
+	userMessage = client.Footer.Append(userMessage, "module_external_context", footer.Fields(schema.GetModuleExternalContextSchema().Properties))
 
-	// Write files
-	if len(request.Files) > 0 {
-		sb.WriteString("# Files\n")
-		for _, f := range request.Files {
-			writeFile(&sb, f.Path, f.Content)
-		}
+	resp, err := callGemini(ctx, client, []string{systemMessage, userMessage}, schema.GetModuleExternalContextSchema(), model)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
 	}
 
-	return sb.String(), nil
+	out, err := decodeCandidate[payload.ModuleExternalContextResponse](resp)
+	return out, resp.stats(), err
 }
 
-func serializeModuleContextRequest(request *payload.ModuleContextRequest) (string, error) {
-	if request == nil {
-		return "", fmt.Errorf("ModuleContextRequest must not be nil")
-	}
-
-	var sb strings.Builder
-	rootPrefix := request.TargetModuleName
-
-	// Only spend these tokens if we need to teach the LLM that a directory != module.
-	if len(request.TargetModuleDirectories) > 1 {
-		sb.WriteString(fmt.Sprintf("## Directories in module `%s`\n", rootPrefix))
-		sb.WriteString(fmt.Sprintf("The following is a list of directories that are part of the module `%s`\n.", rootPrefix))
-		sb.WriteString(fmt.Sprintf("These ARE NOT MODULES, they are directories within the module. When summarizing their file contents, include them in the summary of `%s`, do not make up modules for them.\n", rootPrefix))
-		for _, dir := range request.TargetModuleDirectories {
-			sb.WriteString(fmt.Sprintf("- %s\n", dir))
-		}
-	}
-
-	sb.WriteString(fmt.Sprintf("## Files in module `%s`\n", rootPrefix))
-	// Emit root-module files.
-	for _, file := range request.TargetModuleFiles {
-		writeFile(&sb, file.Path, file.Content)
+// GetModuleContexts calls the LLM and returns the internal and public
+// contexts of every module of request, generated in a single call with the
+// given model, and the usage of the call.
+func GetModuleContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	userMessage, err := render.ModuleContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, payload.UsageStats{}, fmt.Errorf("gemini: failed to serialize module contexts request: %w", err)
 	}
+	userMessage = client.Footer.Append(userMessage, "module_contexts", footer.Fields(schema.GetModuleContextsSchema().Properties))
 
-	// Emit public context of immediate sub-modules.
-	for _, sub := range request.SubModulesPublicContexts {
-		// We only expose the public context of immediate sub-modules.
-		if sub.Content == "" && sub.Name == "" {
-			continue
-		}
-
-		trimmedCtx := &payload.ModuleSelfContainedContext{
-			Name:          sub.Name,
-			PublicContext: sub.Content,
-		}
-		writeModule(&sb, trimmedCtx.Name, trimmedCtx)
+	resp, err := callGemini(ctx, client, []string{systemMessage, userMessage}, schema.GetModuleContextsSchema(), model)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
 	}
 
-	return sb.String(), nil
+	out, err := decodeCandidate[payload.ModuleContextsResponse](resp)
+	return out, resp.stats(), err
 }
 
-func serializeExternalContextsRequest(request *payload.ExternalContextsRequest) (string, error) {
-	if request == nil {
-		return "", fmt.Errorf("ExternalContextsRequest must not be nil")
-	}
-
-	var sb strings.Builder
-
-	// Write each module with H1 headers
-	for _, module := range request.Modules {
-		if module.Name == "" {
+// decodeCandidate unmarshals the text of the first candidate of resp holding
+// valid JSON for T. Candidates are tried in order, so an alternative can make
+// up for a malformed first candidate; the error of the first candidate is
+// returned when none is valid.
+func decodeCandidate[T any](resp *geminiResponse) (*T, error) {
+	var firstErr error
+	for i, c := range resp.Candidates {
+		if len(c.Content.Parts) == 0 {
 			continue
 		}
-		sb.WriteString(fmt.Sprintf("# Module: `%s`\n", module.Name))
-		if module.ParentName != "" {
-			sb.WriteString(fmt.Sprintf("Parent Module: `%s`\n\n", module.ParentName))
-		}
-		if module.InternalContext != "" {
-			sb.WriteString("## Internal Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n\n", module.InternalContext))
+		var out T
+		err := json.Unmarshal([]byte(c.Content.Parts[0].Text), &out)
+		if err == nil {
+			return &out, nil
 		}
-		if module.PublicContext != "" {
-			sb.WriteString("## Public Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n\n", module.PublicContext))
+		if firstErr == nil {
+			firstErr = fmt.Errorf("gemini: failed to unmarshal candidate %d into %T: %w", i, out, err)
 		}
 	}
-
-	return sb.String(), nil
-}
-
-func writeModule(sb *strings.Builder, path string, context *payload.ModuleSelfContainedContext) {
-	if sb == nil {
-		return
-	}
-	if path == "" && (context == nil || (context.ExternalContext == "" && context.InternalContext == "" && context.PublicContext == "")) {
-		return
-	}
-	sb.WriteString(fmt.Sprintf("# Module: `%s`\n", path))
-	if context != nil {
-		if context.ExternalContext != "" {
-			sb.WriteString("## External Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n", context.ExternalContext))
-		}
-		if context.InternalContext != "" {
-			sb.WriteString("## Internal Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n", context.InternalContext))
-		}
-		if context.PublicContext != "" {
-			sb.WriteString("## Public Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n", context.PublicContext))
-		}
+	if firstErr == nil {
+		return nil, errors.New("gemini: empty response")
 	}
+	return nil, firstErr
 }
 
-func writeFile(sb *strings.Builder, filepath, content string) {
-	if sb == nil {
-		return
+func GetChangeNarrative(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	userMessage, err := render.ChangeNarrativeRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, payload.UsageStats{}, fmt.Errorf("gemini: failed to serialize change narrative request: %w", err)
 	}
-	lang := getLanguageFromFilename(filepath)
-	sb.WriteString(fmt.Sprintf("### %s\n", filepath))
-	sb.WriteString(fmt.Sprintf("```%s\n", lang))
-	sb.WriteString(content)
-	// Ensure a trailing newline before closing the code block.
-	if !strings.HasSuffix(content, "\n") {
-		sb.WriteString("\n")
+	userMessage = client.Footer.Append(userMessage, "change_narrative", footer.Fields(schema.GetChangeNarrativeSchema().Properties))
+
+	resp, err := callGemini(ctx, client, []string{systemMessage, userMessage}, schema.GetChangeNarrativeSchema(), model)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
 	}
-	sb.WriteString("```\n\n")
-}
 
-// getLanguageFromFilename returns a language identifier based on file extension.
-func getLanguageFromFilename(filename string) string {
-	if strings.HasSuffix(filename, ".go") {
-		return "go"
-	} else if strings.HasSuffix(filename, ".md") {
-		return "markdown"
-	} else if strings.HasSuffix(filename, ".json") {
-		return "json"
-	} else if strings.HasSuffix(filename, ".txt") {
-		return "text"
-	}
-	// Default: no language specified.
-	return ""
+	out, err := decodeCandidate[payload.ChangeNarrative](resp)
+	return out, resp.stats(), err
 }
 
 // -----------------------------------------------------------------------------
@@ -316,12 +185,44 @@ func getLanguageFromFilename(filename string) string {
 // NOTE: baseEndpoint is a var (not const) to allow test overrides.
 var baseEndpoint = "https://generativelanguage.googleapis.com/v1beta"
 
+// apiBase returns the base URL requests are sent to. The base URL configured
+// on client wins over GEMINI_BASE_URL, which wins over baseEndpoint. The base
+// URL ends with the API version; a trailing slash or "/models" is tolerated.
+func apiBase(client httpclient.Client) string {
+	base := client.BaseURL
+	if base == "" {
+		base = os.Getenv("GEMINI_BASE_URL")
+	}
+	if base == "" {
+		base = baseEndpoint
+	}
+	return strings.TrimSuffix(strings.TrimRight(base, "/"), "/models")
+}
+
+// lookupAPIKey returns the API key, read from the environment variable
+// configured on client, or GEMINI_API_KEY.
+func lookupAPIKey(client httpclient.Client) (string, error) {
+	name := client.APIKeyEnv
+	if name == "" {
+		name = "GEMINI_API_KEY"
+	}
+	key := os.Getenv(name)
+	if key == "" {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	return key, nil
+}
+
 // generateContentTmpl is the relative path (fmt formatted) used to call
 // the "generateContent" method on a specific model, e.g.:
 //
 //	fmt.Sprintf(generateContentTmpl, "gemini-2.5-flash", apiKey)
 const generateContentTmpl = "/models/%s:generateContent?key=%s"
 
+// streamGenerateContentTmpl is the streaming counterpart of
+// generateContentTmpl, answering server-sent events.
+const streamGenerateContentTmpl = "/models/%s:streamGenerateContent?alt=sse&key=%s"
+
 type part struct {
 	Text string `json:"text,omitempty"`
 }
@@ -344,18 +245,38 @@ type requestPayload struct {
 // geminiResponse mirrors the minimal subset of the response envelope we
 // care about.
 //
-// { "candidates": [ { "content": {"parts": [ {"text": "..."} ] } } ] }
+// { "candidates": [ { "content": {"parts": [ {"text": "..."} ] } } ],
+//   "usageMetadata": { "promptTokenCount": 12, "candidatesTokenCount": 34 } }
 
 type geminiResponse struct {
-	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-	} `json:"candidates"`
+	Candidates    []candidate    `json:"candidates"`
+	UsageMetadata *usageMetadata `json:"usageMetadata,omitempty"`
+}
+
+// usageMetadata is the token usage of a response.
+type usageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+}
+
+// stats returns the usage reported in resp, if any, as the usage of a
+// single call.
+func (resp *geminiResponse) stats() payload.UsageStats {
+	if resp == nil || resp.UsageMetadata == nil {
+		return payload.UsageStats{}
+	}
+	return payload.UsageStats{Calls: 1, PromptTokens: resp.UsageMetadata.PromptTokenCount, CompletionTokens: resp.UsageMetadata.CandidatesTokenCount}
+}
+
+type candidate struct {
+	Content content `json:"content"`
 }
 
+// streamChunk is a single server-sent event of a streamed response. Every
+// chunk carries the next fragment of the candidate text, and the usage so
+// far.
+type streamChunk = geminiResponse
+
 type geminiErrorResponse struct {
 	Err struct {
 		Code    int    `json:"code"`
@@ -368,7 +289,44 @@ func (e geminiErrorResponse) Error() string {
 	return fmt.Sprintf("Gemini API error (%d %s): %s", e.Err.Code, e.Err.Status, e.Err.Message)
 }
 
+// retryDelay returns the delay requested by a RESOURCE_EXHAUSTED error,
+// carried by its google.rpc.RetryInfo detail (e.g. "retryDelay": "31s"),
+// or zero when there is none.
+func retryDelay(body []byte) time.Duration {
+	var errResp struct {
+		Err struct {
+			Status  string `json:"status"`
+			Details []struct {
+				Type       string `json:"@type"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Err.Status != "RESOURCE_EXHAUSTED" {
+		return 0
+	}
+	for _, d := range errResp.Err.Details {
+		if !strings.HasSuffix(d.Type, "google.rpc.RetryInfo") {
+			continue
+		}
+		if delay, err := time.ParseDuration(d.RetryDelay); err == nil && delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
 func buildRequest(messages []string, schema interface{}) ([]byte, error) {
+	contents, err := userContents(messages)
+	if err != nil {
+		return nil, err
+	}
+	return buildContentsRequest(contents, schema)
+}
+
+// userContents returns a single user turn holding a part for each
+// non-empty message.
+func userContents(messages []string) ([]content, error) {
 	if len(messages) == 0 {
 		return nil, errors.New("gemini: messages cannot be empty")
 	}
@@ -384,14 +342,14 @@ func buildRequest(messages []string, schema interface{}) ([]byte, error) {
 	if len(parts) == 0 {
 		return nil, errors.New("gemini: all messages are empty")
 	}
+	return []content{{Role: "user", Parts: parts}}, nil
+}
 
+// buildContentsRequest returns the body of a request sending the
+// conversation contents.
+func buildContentsRequest(contents []content, schema interface{}) ([]byte, error) {
 	r := requestPayload{
-		Contents: []content{
-			{
-				Role:  "user",
-				Parts: parts,
-			},
-		},
+		Contents: contents,
 		GenerationConfig: generationConfig{
 			ResponseMimeType: "application/json",
 			ResponseSchema:   schema,
@@ -401,10 +359,19 @@ func buildRequest(messages []string, schema interface{}) ([]byte, error) {
 	return json.Marshal(r)
 }
 
-func callGemini(messages []string, schema interface{}, model string) (*geminiResponse, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("GEMINI_API_KEY is not set")
+func callGemini(ctx context.Context, client httpclient.Client, messages []string, schema interface{}, model string) (*geminiResponse, error) {
+	contents, err := userContents(messages)
+	if err != nil {
+		return nil, err
+	}
+	return callGeminiContents(ctx, client, contents, schema, model)
+}
+
+// callGeminiContents sends the conversation contents to the Gemini API.
+func callGeminiContents(ctx context.Context, client httpclient.Client, contents []content, schema interface{}, model string) (*geminiResponse, error) {
+	apiKey, err := lookupAPIKey(client)
+	if err != nil {
+		return nil, err
 	}
 
 	if model == "" {
@@ -412,57 +379,49 @@ func callGemini(messages []string, schema interface{}, model string) (*geminiRes
 	}
 
 	// Build request body.
-	bodyBytes, err := buildRequest(messages, schema)
+	bodyBytes, err := buildContentsRequest(contents, schema)
 	if err != nil {
 		return nil, err
 	}
 
 	// Compose endpoint URL.
-	url := fmt.Sprintf("%s"+generateContentTmpl, baseEndpoint, model, apiKey)
+	tmpl := generateContentTmpl
+	if client.Stream {
+		tmpl = streamGenerateContentTmpl
+	}
+	url := fmt.Sprintf("%s"+tmpl, apiBase(client), model, apiKey)
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, fmt.Errorf("gemini: failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	client.RetryDelay = retryDelay
+	client.MaxBodyBytes = maxRequestBytes
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("gemini: request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if client.Stream && resp.StatusCode == http.StatusOK {
+		progress := httpclient.NewProgress(client.Progress, "Gemini")
+		return readStream(resp.Body, progress, httpclient.NewProposalWatcher(client.OnProposal, progress))
+	}
+
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("gemini: failed to read response body: %w", err)
 	}
 
-	// ---------------------------------------------------------------------
-	// Persist request/response pair for debugging – same approach as OpenAI.
-	// ---------------------------------------------------------------------
-	logEntry := struct {
-		Request  json.RawMessage `json:"request"`
-		Response json.RawMessage `json:"response"`
-	}{
-		Request:  bodyBytes,
-		Response: respBytes,
-	}
-
-	if logBytes, err := json.MarshalIndent(logEntry, "", "  "); err == nil {
-		if f, err := os.CreateTemp("", "vyb-gemini-*.json"); err == nil {
-			if _, wErr := f.Write(logBytes); wErr == nil {
-				_ = f.Close()
-			}
-		}
-	}
-
 	if resp.StatusCode != http.StatusOK {
 		// Try to decode structured error first.
 		var gErr geminiErrorResponse
 		if jsonErr := json.Unmarshal(respBytes, &gErr); jsonErr == nil && gErr.Err.Message != "" {
-			return nil, gErr
+			return nil, httpclient.StatusError(resp.StatusCode, model, gErr)
 		}
-		return nil, fmt.Errorf("gemini: http %d – %s", resp.StatusCode, string(respBytes))
+		return nil, httpclient.StatusError(resp.StatusCode, model, fmt.Errorf("gemini: http %d – %s", resp.StatusCode, string(respBytes)))
 	}
 
 	var out geminiResponse
@@ -472,3 +431,46 @@ func callGemini(messages []string, schema interface{}, model string) (*geminiRes
 
 	return &out, nil
 }
+
+// readStream assembles the chunks of a streamed response into the response
+// generateContent would have returned, reporting the tokens generated so far
+// to progress and the generated text to watcher.
+func readStream(body io.Reader, progress *httpclient.Progress, watcher *httpclient.ProposalWatcher) (*geminiResponse, error) {
+	var sb strings.Builder
+	var usage *usageMetadata
+	chunks := 0
+	err := httpclient.ReadEvents(body, func(data []byte) error {
+		var gErr geminiErrorResponse
+		if err := json.Unmarshal(data, &gErr); err == nil && gErr.Err.Message != "" {
+			return gErr
+		}
+		var chunk streamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("gemini: failed to unmarshal stream chunk: %w", err)
+		}
+		chunks++
+		if len(chunk.Candidates) > 0 {
+			for _, p := range chunk.Candidates[0].Content.Parts {
+				sb.WriteString(p.Text)
+				watcher.Write(p.Text)
+			}
+		}
+		if chunk.UsageMetadata != nil {
+			usage = chunk.UsageMetadata
+		}
+		if usage != nil && usage.CandidatesTokenCount > 0 {
+			progress.Report(usage.CandidatesTokenCount)
+		} else {
+			progress.Report(chunks)
+		}
+		return nil
+	})
+	progress.Done()
+	if err != nil {
+		return nil, err
+	}
+	if chunks == 0 {
+		return &geminiResponse{}, nil
+	}
+	return &geminiResponse{Candidates: []candidate{{Content: content{Parts: []part{{Text: sb.String()}}}}}, UsageMetadata: usage}, nil
+}
@@ -1,14 +1,21 @@
 package gemini
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/internal/repair"
 	"github.com/vybdev/vyb/llm/payload"
 )
 
@@ -46,7 +53,7 @@ func TestGetWorkspaceChangeProposals(t *testing.T) {
 			{Path: "test.go", Content: "package main"},
 		},
 	}
-	got, err := GetWorkspaceChangeProposals(config.ModelFamilyGPT, config.ModelSizeSmall, "sys", req)
+	got, _, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -71,6 +78,7 @@ func TestGetModuleContext(t *testing.T) {
 					},
 				},
 			},
+			"usageMetadata": map[string]any{"promptTokenCount": 12, "candidatesTokenCount": 3, "totalTokenCount": 15},
 		}
 		_ = json.NewEncoder(w).Encode(resp)
 	}))
@@ -87,7 +95,7 @@ func TestGetModuleContext(t *testing.T) {
 		TargetModuleName: "test-module",
 	}
 
-	got, err := GetModuleContext("sys", req)
+	got, usage, err := GetModuleContext(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -95,6 +103,9 @@ func TestGetModuleContext(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("unexpected ctx: %+v", got)
 	}
+	if usage != (payload.UsageStats{Calls: 1, PromptTokens: 12, CompletionTokens: 3}) {
+		t.Fatalf("expected the usage of the response, got %+v", usage)
+	}
 }
 
 func TestGetModuleExternalContexts(t *testing.T) {
@@ -129,7 +140,7 @@ func TestGetModuleExternalContexts(t *testing.T) {
 		},
 	}
 
-	got, err := GetModuleExternalContexts("sys", req)
+	got, _, err := GetModuleExternalContexts(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -138,3 +149,177 @@ func TestGetModuleExternalContexts(t *testing.T) {
 		t.Fatalf("unexpected ext ctx: %+v", got)
 	}
 }
+
+func TestRetryDelay(t *testing.T) {
+	body := `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","message":"quota","details":[
+		{"@type":"type.googleapis.com/google.rpc.QuotaFailure"},
+		{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"31s"}]}}`
+	if got := retryDelay([]byte(body)); got != 31*time.Second {
+		t.Fatalf("retryDelay = %s, want 31s", got)
+	}
+	if got := retryDelay([]byte(`{"error":{"code":503,"status":"UNAVAILABLE"}}`)); got != 0 {
+		t.Fatalf("retryDelay = %s, want 0", got)
+	}
+}
+
+func TestGetModuleExternalContexts_Stream(t *testing.T) {
+	const text = `{"modules":[{"name":"foo","external_context":"bar"},{"name":"baz","external_context":"qux"}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, ":streamGenerateContent") {
+			_ = json.NewEncoder(w).Encode(geminiResponse{Candidates: []candidate{{Content: content{Parts: []part{{Text: text}}}}}, UsageMetadata: &usageMetadata{PromptTokenCount: 50, CandidatesTokenCount: 10}})
+			return
+		}
+		if r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("expected server-sent events to be requested, got %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < len(text); i += 10 {
+			fragment, _ := json.Marshal(text[i:min(i+10, len(text))])
+			fmt.Fprintf(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":%s}]}}],\"usageMetadata\":{\"promptTokenCount\":50,\"candidatesTokenCount\":%d}}\r\n\r\n", fragment, i/10+1)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("GEMINI_API_KEY", "x")
+
+	req := &payload.ExternalContextsRequest{Modules: []payload.ModuleInfoForExternalContext{{Name: "foo"}, {Name: "baz"}}}
+	want, wantUsage, err := GetModuleExternalContexts(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var progress bytes.Buffer
+	got, gotUsage, err := GetModuleExternalContexts(context.Background(), httpclient.Client{Stream: true, Progress: &progress}, "gemini-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected streaming error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("streamed contexts %+v differ from %+v", got, want)
+	}
+	if wantUsage != (payload.UsageStats{Calls: 1, PromptTokens: 50, CompletionTokens: 10}) || gotUsage != wantUsage {
+		t.Fatalf("expected the usage of the last chunk, got %+v streamed and %+v", gotUsage, wantUsage)
+	}
+	if !strings.Contains(progress.String(), "Gemini: 10 tokens received") {
+		t.Fatalf("expected the reported token count, got %q", progress.String())
+	}
+}
+
+func TestGetModuleContext_SkipsInvalidCandidates(t *testing.T) {
+	var texts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp geminiResponse
+		for _, text := range texts {
+			resp.Candidates = append(resp.Candidates, candidate{Content: content{Parts: []part{{Text: text}}}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("GEMINI_API_KEY", "x")
+
+	req := &payload.ModuleContextRequest{TargetModuleName: "test-module"}
+
+	texts = []string{`{"internal_context":"trunc`, `{"internal_context":"i","public_context":"p"}`}
+	got, _, err := GetModuleContext(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
+	if err != nil {
+		t.Fatalf("expected the second candidate to be used, got %v", err)
+	}
+	want := &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ctx: %+v", got)
+	}
+
+	texts = []string{`{"internal_context":"trunc`, "not json"}
+	if _, _, err := GetModuleContext(context.Background(), httpclient.Client{}, "gemini-test", "sys", req); err == nil || !strings.Contains(err.Error(), "candidate 0") {
+		t.Fatalf("expected the error of the first candidate, got %v", err)
+	}
+}
+
+func TestAPIBase(t *testing.T) {
+	t.Setenv("GEMINI_BASE_URL", "")
+	if got, want := apiBase(httpclient.Client{}), "https://generativelanguage.googleapis.com/v1beta"; got != want {
+		t.Fatalf("default base = %q, want %q", got, want)
+	}
+
+	t.Setenv("GEMINI_BASE_URL", "https://env.example.com/v1beta/models/")
+	if got, want := apiBase(httpclient.Client{}), "https://env.example.com/v1beta"; got != want {
+		t.Fatalf("env base = %q, want %q", got, want)
+	}
+
+	// The configured base URL wins over the environment.
+	if got, want := apiBase(httpclient.Client{BaseURL: "https://gateway.example.com/gemini/v1beta/"}), "https://gateway.example.com/gemini/v1beta"; got != want {
+		t.Fatalf("configured base = %q, want %q", got, want)
+	}
+}
+
+func TestLookupAPIKey(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "public")
+	t.Setenv("GEMINI_PROXY_KEY", "")
+	if key, err := lookupAPIKey(httpclient.Client{}); err != nil || key != "public" {
+		t.Fatalf("default key = %q, %v", key, err)
+	}
+
+	// The configured variable replaces GEMINI_API_KEY.
+	client := httpclient.Client{APIKeyEnv: "GEMINI_PROXY_KEY"}
+	if _, err := lookupAPIKey(client); err == nil || !strings.Contains(err.Error(), "GEMINI_PROXY_KEY is not set") {
+		t.Fatalf("expected the configured variable to be required, got %v", err)
+	}
+	t.Setenv("GEMINI_PROXY_KEY", "proxy")
+	if key, err := lookupAPIKey(client); err != nil || key != "proxy" {
+		t.Fatalf("configured key = %q, %v", key, err)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_RepairsInvalidJSON(t *testing.T) {
+	var answers []string
+	var requests []requestPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req requestPayload
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		requests = append(requests, req)
+		resp := geminiResponse{Candidates: []candidate{{Content: content{Parts: []part{{Text: answers[0]}}}}}}
+		answers = answers[1:]
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("GEMINI_API_KEY", "x")
+	req := &payload.WorkspaceChangeRequest{TargetModule: "m", TargetDirectory: "m/"}
+	valid := `{"description":"d","summary":"s","proposals":[{"file_name":"a.go","content":"package a","delete":false}]}`
+
+	answers = []string{`{"description":"d","proposals":[{"file_na`, valid}
+	got, _, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Proposals) != 1 || got.Proposals[0].FileName != "a.go" {
+		t.Fatalf("unexpected proposal %+v", got)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected a single repair request, got %d requests", len(requests))
+	}
+	contents := requests[1].Contents
+	if len(contents) != 3 || contents[1].Role != "model" || contents[1].Parts[0].Text != `{"description":"d","proposals":[{"file_na` ||
+		contents[2].Role != "user" || !strings.Contains(contents[2].Parts[0].Text, "invalid JSON") {
+		t.Fatalf("expected the invalid answer to be sent back with a repair request, got %+v", contents)
+	}
+
+	// The repair is attempted once.
+	answers, requests = []string{"garbage", "more garbage"}, nil
+	_, _, err = GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gemini-test", "sys", req)
+	if !errors.Is(err, repair.ErrFailed) || len(requests) != 2 {
+		t.Fatalf("expected ErrFailed after two requests, got %v after %d requests", err, len(requests))
+	}
+}
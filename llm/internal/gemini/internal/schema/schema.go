@@ -1,13 +1,10 @@
 package schema
 
 import (
-	"embed"
-	"encoding/json"
+	"github.com/vybdev/vyb/llm/internal/schemacheck"
+	"github.com/vybdev/vyb/llm/internal/schemas"
 )
 
-//go:embed schemas/*
-var embedded embed.FS
-
 // StructuredOutputSchema mirrors the structure used by the OpenAI provider so
 // we can reuse the same JSON schema files. Only the `Schema` field is used by
 // the Gemini client – the wrapper itself is kept for parity and potential
@@ -30,24 +27,39 @@ type JSONSchema struct {
 // GetWorkspaceChangeProposalSchema parses and returns the schema definition
 // for workspace change proposals.
 func GetWorkspaceChangeProposalSchema() JSONSchema {
-	return getSchema("schemas/workspace_change_proposal_schema.json")
+	return MustLoad(schemas.WorkspaceChangeProposal)
 }
 
 // GetModuleContextSchema returns the schema definition for module context
 // generation.
 func GetModuleContextSchema() JSONSchema {
-	return getSchema("schemas/module_selfcontained_context_schema.json")
+	return MustLoad(schemas.ModuleContext)
 }
 
 // GetModuleExternalContextSchema returns the schema definition used when
 // requesting external contexts in bulk.
 func GetModuleExternalContextSchema() JSONSchema {
-	return getSchema("schemas/module_external_context_schema.json")
+	return MustLoad(schemas.ModuleExternalContext)
+}
+
+// GetModuleContextsSchema returns the schema definition used when
+// requesting the contexts of several modules at once.
+func GetModuleContextsSchema() JSONSchema {
+	return MustLoad(schemas.ModuleContexts)
 }
 
-func getSchema(path string) JSONSchema {
-	data, _ := embedded.ReadFile(path)
-	var s JSONSchema
-	_ = json.Unmarshal(data, &s) // the embedded asset is trusted
-	return s
+// GetChangeNarrativeSchema returns the schema definition used when
+// describing applied changes.
+func GetChangeNarrativeSchema() JSONSchema {
+	return MustLoad(schemas.ChangeNarrative)
+}
+
+// dialect is the subset of OpenAPI schemas accepted as a
+// responseSchema.
+var dialect = schemacheck.Dialect{Keywords: schemacheck.Keywords("format", "nullable", "propertyOrdering")}
+
+// MustLoad parses the shared schema name, see package schemas, panicking
+// with the file name when it is invalid in the dialect of this provider.
+func MustLoad(name string) JSONSchema {
+	return schemas.MustLoad[JSONSchema](name, dialect)
 }
@@ -0,0 +1,15 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// TestDialect ensures the shared schemas only use the OpenAPI subset Gemini
+// accepts as a responseSchema.
+func TestDialect(t *testing.T) {
+	if err := schemas.Check(dialect); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,230 @@
+// Package httpclient sends the HTTP requests of every LLM provider, bounding
+// them with a timeout and retrying transient failures.
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vybdev/vyb/llm/internal/debuglog"
+	"github.com/vybdev/vyb/llm/internal/footer"
+	"github.com/vybdev/vyb/llm/internal/render"
+	"github.com/vybdev/vyb/llm/internal/retry"
+	"github.com/vybdev/vyb/logging"
+)
+
+// Client sends provider requests. The zero value sends a single attempt
+// without timeout.
+type Client struct {
+	// Timeout bounds every attempt, including reading the response body.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// MaxRetries is the number of attempts made after the first one when
+	// the server answers 429 or 5xx, or the connection fails.
+	MaxRetries int
+	// RetryDelay optionally extracts the delay requested by the server from
+	// the body of a transient error response, for APIs that do not send a
+	// Retry-After header. It returns zero when the body holds no delay.
+	RetryDelay func(body []byte) time.Duration
+	// Debug records the request and final response of every call when set.
+	Debug *debuglog.Logger
+	// MaxBodyBytes is the largest request body accepted by the provider.
+	// Larger requests fail with a RequestTooLargeError before anything is
+	// sent. Zero means no limit.
+	MaxBodyBytes int64
+	// Stream asks providers supporting it to stream their responses,
+	// reporting the tokens received on Progress while they arrive.
+	Stream bool
+	// Progress receives the progress of streamed responses. Nil means no
+	// progress is shown.
+	Progress io.Writer
+	// OnProposal, when set, is called by streaming providers with the name
+	// of every file proposal of a WorkspaceChangeProposal as soon as it is
+	// received.
+	OnProposal func(fileName string)
+	// BaseURL replaces the API base URL of the provider, e.g. to go through
+	// a corporate gateway. Empty means the provider's environment variable
+	// (OPENAI_BASE_URL, GEMINI_BASE_URL), or its public API.
+	BaseURL string
+	// APIKeyEnv names the environment variable holding the API key. Empty
+	// means the provider's own (OPENAI_API_KEY, GEMINI_API_KEY).
+	APIKeyEnv string
+	// Footer, when set, is appended by providers to every user message to
+	// remind the model of the expected response format.
+	Footer *footer.Footer
+	// Format replaces the request format preferred by the provider. Empty
+	// means the provider's own.
+	Format render.Format
+}
+
+// ErrRequestTooLarge is matched, with errors.Is, by every
+// RequestTooLargeError.
+var ErrRequestTooLarge = errors.New("request too large")
+
+// RequestTooLargeError reports a request body exceeding the limit of the
+// provider.
+type RequestTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("request body of %d bytes exceeds the provider limit of %d bytes", e.Size, e.Limit)
+}
+
+func (e *RequestTooLargeError) Is(target error) bool {
+	return target == ErrRequestTooLarge
+}
+
+// ErrModelNotFound is matched, with errors.Is, by every ModelNotFoundError.
+var ErrModelNotFound = errors.New("model not found")
+
+// ModelNotFoundError reports a request rejected because the provider does
+// not serve Model, e.g. a retired preview model.
+type ModelNotFoundError struct {
+	Model string
+	Err   error
+}
+
+func (e *ModelNotFoundError) Error() string {
+	return fmt.Sprintf("model %s not found: %v", e.Model, e.Err)
+}
+
+func (e *ModelNotFoundError) Unwrap() error { return e.Err }
+
+func (e *ModelNotFoundError) Is(target error) bool {
+	return target == ErrModelNotFound
+}
+
+// StatusError returns err, decoded from a response answered with status to
+// a request for model, as a ModelNotFoundError when the status is 404.
+func StatusError(status int, model string, err error) error {
+	if status == http.StatusNotFound {
+		return &ModelNotFoundError{Model: model, Err: err}
+	}
+	return err
+}
+
+// NOTE: clock is a var (not a direct call) to allow test overrides.
+var clock = retry.SystemClock
+
+// statusError records a transient status answered by the server.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("http %d", e.status)
+}
+
+// Do sends req, retrying with exponential backoff and jitter while the
+// response status is transient (429 or 5xx), or the connection fails, and
+// retries are left. A Retry-After header, or the delay found by RetryDelay,
+// takes precedence over the backoff schedule.
+//
+// Once retries are exhausted the response of the last attempt is returned as
+// is, so callers keep decoding provider error payloads; the error of the
+// last attempt is returned when it got no response at all. Timeouts are not
+// retried, and neither is a request whose context is done: cancelling it
+// aborts the attempt in flight as well as the wait for the next one.
+//
+// Requests whose body exceeds MaxBodyBytes are rejected upfront. The request
+// body is replayed through req.GetBody, which http.NewRequest sets, along
+// with ContentLength, for in-memory bodies.
+func (c Client) Do(req *http.Request) (*http.Response, error) {
+	logging.Log.Debugf("sending %s %s with a %d bytes body", req.Method, req.URL.Redacted(), req.ContentLength)
+	if c.MaxBodyBytes > 0 && req.ContentLength > c.MaxBodyBytes {
+		return nil, &RequestTooLargeError{Size: req.ContentLength, Limit: c.MaxBodyBytes}
+	}
+	httpClient := &http.Client{Timeout: c.Timeout}
+	policy := retry.Policy{MaxAttempts: c.MaxRetries + 1, Clock: clock, Context: req.Context()}
+
+	var last *http.Response
+	attempt := 0
+	err := policy.Do(func() error {
+		attempt++
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			req.Body = body
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return err
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return err
+			}
+			return retry.Temporary(err, 0)
+		}
+		last = resp
+		if !isTransient(resp.StatusCode) {
+			return nil
+		}
+		// Buffer the body: it is needed to find the requested delay, and
+		// handed back to the caller after the last attempt.
+		body, readErr := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if readErr != nil {
+			return retry.Temporary(readErr, 0)
+		}
+		return retry.Temporary(&statusError{status: resp.StatusCode}, c.retryAfter(resp, body))
+	})
+
+	var se *statusError
+	if err != nil && !errors.As(err, &se) {
+		return nil, err
+	}
+	if c.Debug != nil {
+		if err := c.logExchange(req, last); err != nil {
+			return nil, err
+		}
+	}
+	return last, nil
+}
+
+// logExchange records the body of req and resp in c.Debug. The response body
+// is buffered and handed back intact.
+func (c Client) logExchange(req *http.Request, resp *http.Response) error {
+	var reqBody []byte
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = io.ReadAll(body)
+		}
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	c.Debug.Write(reqBody, respBody)
+	return nil
+}
+
+// retryAfter returns the delay requested by the server, or zero.
+func (c Client) retryAfter(resp *http.Response, body []byte) time.Duration {
+	if d, ok := retry.ParseRetryAfter(resp.Header.Get("Retry-After"), clock.Now()); ok {
+		return d
+	}
+	if c.RetryDelay != nil {
+		return c.RetryDelay(body)
+	}
+	return 0
+}
+
+// isTransient reports whether a response with the given status is worth
+// retrying.
+func isTransient(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
@@ -0,0 +1,226 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock records the requested sleeps instead of sleeping.
+type fakeClock struct {
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+func (c *fakeClock) Sleep(d time.Duration) { c.sleeps = append(c.sleeps, d) }
+
+func useFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	fc := &fakeClock{}
+	old := clock
+	clock = fc
+	t.Cleanup(func() { clock = old })
+	return fc
+}
+
+// flakyServer answers status to the first failures requests, then 200. It
+// checks that every attempt carries the full request body.
+func flakyServer(t *testing.T, failures, status int, header http.Header) (*httptest.Server, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("attempt %d got body %q", calls, body)
+		}
+		if calls <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error":"busy"}`))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestDo_RetriesTransientErrors(t *testing.T) {
+	fc := useFakeClock(t)
+	srv, calls := flakyServer(t, 2, http.StatusServiceUnavailable, nil)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	resp, err := Client{MaxRetries: 3}.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || *calls != 3 {
+		t.Fatalf("got status %d after %d calls, want 200 after 3", resp.StatusCode, *calls)
+	}
+	// Exponential backoff with jitter on the upper half of every delay.
+	if len(fc.sleeps) != 2 {
+		t.Fatalf("expected 2 backoff delays, got %v", fc.sleeps)
+	}
+	for i, d := range fc.sleeps {
+		base := time.Second << i
+		if d < base/2 || d >= base {
+			t.Fatalf("delay %d = %s, want within [%s,%s)", i, d, base/2, base)
+		}
+	}
+}
+
+func TestDo_RetryAfter(t *testing.T) {
+	fc := useFakeClock(t)
+	srv, _ := flakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"7"}})
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	resp, err := Client{MaxRetries: 1}.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if len(fc.sleeps) != 1 || fc.sleeps[0] != 7*time.Second {
+		t.Fatalf("expected the Retry-After delay to be honored, got %v", fc.sleeps)
+	}
+}
+
+func TestDo_RetryDelayFromBody(t *testing.T) {
+	fc := useFakeClock(t)
+	srv, _ := flakyServer(t, 1, http.StatusTooManyRequests, nil)
+
+	client := Client{MaxRetries: 1, RetryDelay: func(body []byte) time.Duration {
+		if string(body) != `{"error":"busy"}` {
+			t.Errorf("unexpected body %q", body)
+		}
+		return 3 * time.Second
+	}}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if len(fc.sleeps) != 1 || fc.sleeps[0] != 3*time.Second {
+		t.Fatalf("expected the body delay to be honored, got %v", fc.sleeps)
+	}
+}
+
+func TestDo_ExhaustedRetries(t *testing.T) {
+	useFakeClock(t)
+	srv, calls := flakyServer(t, 2, http.StatusServiceUnavailable, nil)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	resp, err := Client{MaxRetries: 1}.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || *calls != 2 {
+		t.Fatalf("got status %d after %d calls, want 503 after 2", resp.StatusCode, *calls)
+	}
+	// The last response is handed back intact for the caller to decode.
+	if body, _ := io.ReadAll(resp.Body); string(body) != `{"error":"busy"}` {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestDo_ConnectionError(t *testing.T) {
+	fc := useFakeClock(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if _, err := (Client{MaxRetries: 2}).Do(req); err == nil {
+		t.Fatalf("expected the last connection error")
+	}
+	if len(fc.sleeps) != 2 {
+		t.Fatalf("expected 2 retries, got %v", fc.sleeps)
+	}
+}
+
+func TestDo_Timeout(t *testing.T) {
+	fc := useFakeClock(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := (Client{Timeout: 20 * time.Millisecond, MaxRetries: 2}).Do(req); err == nil {
+		t.Fatalf("expected a timeout error")
+	}
+	if len(fc.sleeps) != 0 {
+		t.Fatalf("timeouts must not be retried, got %v", fc.sleeps)
+	}
+}
+
+func TestDo_ContextCanceled(t *testing.T) {
+	fc := useFakeClock(t)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	_, err := (Client{MaxRetries: 2}).Do(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("cancellation took %s", elapsed)
+	}
+	if len(fc.sleeps) != 0 {
+		t.Fatalf("cancelled requests must not be retried, got %v", fc.sleeps)
+	}
+}
+
+func TestDo_MaxBodyBytes(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	client := Client{MaxBodyBytes: int64(len("payload"))}
+
+	// A body exactly at the limit is sent.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error at the limit: %v", err)
+	}
+	resp.Body.Close()
+
+	// One byte more is rejected before anything is sent.
+	req, _ = http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload!"))
+	_, err = client.Do(req)
+	var tooLarge *RequestTooLargeError
+	if !errors.As(err, &tooLarge) || !errors.Is(err, ErrRequestTooLarge) {
+		t.Fatalf("expected a RequestTooLargeError, got %v", err)
+	}
+	if tooLarge.Size != 8 || tooLarge.Limit != 7 {
+		t.Fatalf("unexpected size/limit %d/%d", tooLarge.Size, tooLarge.Limit)
+	}
+	if calls != 1 {
+		t.Fatalf("the oversized request must not be sent, got %d calls", calls)
+	}
+}
@@ -0,0 +1,79 @@
+package httpclient
+
+import "encoding/json"
+
+// ProposalWatcher scans the text of a streamed WorkspaceChangeProposal and
+// reports every file proposal as soon as it is complete. A nil
+// *ProposalWatcher ignores its input.
+type ProposalWatcher struct {
+	fn       func(fileName string)
+	progress *Progress
+
+	depth    int
+	inString bool
+	escaped  bool
+	// current holds the text of the file proposal being received.
+	current []byte
+}
+
+// NewProposalWatcher returns a ProposalWatcher calling fn with the name of
+// every completed file proposal, or nil when fn is nil. The progress line,
+// if any, is terminated before each call.
+func NewProposalWatcher(fn func(fileName string), progress *Progress) *ProposalWatcher {
+	if fn == nil {
+		return nil
+	}
+	return &ProposalWatcher{fn: fn, progress: progress}
+}
+
+// Write consumes the next chunk of the response text.
+func (w *ProposalWatcher) Write(text string) {
+	if w == nil {
+		return
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		// File proposals are the objects of the "proposals" array, the only
+		// objects nested at depth 3 of the response.
+		if w.depth >= 3 {
+			w.current = append(w.current, c)
+		}
+		if w.inString {
+			switch {
+			case w.escaped:
+				w.escaped = false
+			case c == '\\':
+				w.escaped = true
+			case c == '"':
+				w.inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			w.inString = true
+		case '{', '[':
+			w.depth++
+			if w.depth == 3 && c == '{' {
+				w.current = append(w.current[:0], c)
+			}
+		case '}', ']':
+			if w.depth == 3 && c == '}' {
+				w.report()
+			}
+			w.depth--
+		}
+	}
+}
+
+// report calls fn with the file name of the proposal just completed.
+func (w *ProposalWatcher) report() {
+	var proposal struct {
+		FileName string `json:"file_name"`
+	}
+	if err := json.Unmarshal(w.current, &proposal); err != nil || proposal.FileName == "" {
+		return
+	}
+	w.progress.Done()
+	w.fn(proposal.FileName)
+}
@@ -0,0 +1,90 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ReadEvents decodes the server-sent events read from r, calling fn with the
+// data of every event in order. Multi-line data is joined with newlines,
+// comments and fields other than data are ignored. It returns the first
+// error returned by fn.
+func ReadEvents(r io.Reader, fn func(data []byte) error) error {
+	br := bufio.NewReader(r)
+	var data []byte
+	hasData := false
+	dispatch := func() error {
+		if !hasData {
+			return nil
+		}
+		ev := data
+		data, hasData = nil, false
+		return fn(ev)
+	}
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read event stream: %w", err)
+		}
+		eof := err != nil
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			v := bytes.TrimPrefix(line[len("data:"):], []byte(" "))
+			if hasData {
+				data = append(data, '\n')
+			}
+			data = append(data, v...)
+			hasData = true
+		}
+		if eof {
+			return dispatch()
+		}
+	}
+}
+
+// Progress reports on w the number of tokens received so far from a
+// streamed response. A nil *Progress reports nothing.
+type Progress struct {
+	w      io.Writer
+	label  string
+	tokens int
+	// open is set while the progress line is not terminated.
+	open bool
+}
+
+// NewProgress returns a Progress writing to w, or nil when w is nil.
+func NewProgress(w io.Writer, label string) *Progress {
+	if w == nil {
+		return nil
+	}
+	return &Progress{w: w, label: label}
+}
+
+// Report records that tokens have been received in total, rewriting the
+// progress line when the count changed.
+func (p *Progress) Report(tokens int) {
+	if p == nil || tokens == p.tokens {
+		return
+	}
+	p.tokens = tokens
+	p.open = true
+	fmt.Fprintf(p.w, "\r%s: %d tokens received", p.label, tokens)
+}
+
+// Done terminates the progress line, if anything was reported since the
+// last call.
+func (p *Progress) Done() {
+	if p == nil || !p.open {
+		return
+	}
+	p.open = false
+	fmt.Fprintln(p.w)
+}
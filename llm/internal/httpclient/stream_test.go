@@ -0,0 +1,93 @@
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\r\n" +
+		"data: {\"a\":1}\r\n\r\n" +
+		"event: message\n" +
+		"data:first\n" +
+		"data: second\n\n" +
+		"\n" +
+		"data: [DONE]"
+
+	var got []string
+	err := ReadEvents(strings.NewReader(stream), func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{`{"a":1}`, "first\nsecond", "[DONE]"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadEvents_StopsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	calls := 0
+	err := ReadEvents(strings.NewReader("data: 1\n\ndata: 2\n\n"), func([]byte) error {
+		calls++
+		return boom
+	})
+	if !errors.Is(err, boom) || calls != 1 {
+		t.Fatalf("expected the first event error, got %v after %d calls", err, calls)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf, "Acme")
+	p.Report(1)
+	p.Report(1)
+	p.Report(3)
+	p.Done()
+	if want := "\rAcme: 1 tokens received\rAcme: 3 tokens received\n"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	// A nil Progress reports nothing.
+	nilProgress := NewProgress(nil, "Acme")
+	nilProgress.Report(1)
+	nilProgress.Done()
+}
+
+func TestProposalWatcher(t *testing.T) {
+	text := `{"description":"d {[","summary":"s","proposals":[` +
+		`{"file_name":"a.go","content":"func f() { \"}\" }","delete":false},` +
+		`{"file_name":"b.go","content":"","delete":true}]}`
+
+	var buf bytes.Buffer
+	progress := NewProgress(&buf, "Acme")
+	var got []string
+	w := NewProposalWatcher(func(name string) {
+		got = append(got, name)
+		buf.WriteString(name + "\n")
+	}, progress)
+	// Feed the text in small chunks, as a stream would.
+	for i := 0; i < len(text); i += 7 {
+		end := min(i+7, len(text))
+		w.Write(text[i:end])
+		progress.Report(end)
+	}
+	progress.Done()
+
+	if diff := cmp.Diff([]string{"a.go", "b.go"}, got); diff != "" {
+		t.Fatalf("unexpected proposals (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), " tokens received\na.go\n") {
+		t.Fatalf("progress line not terminated before the callback: %q", buf.String())
+	}
+
+	// A nil watcher ignores its input.
+	NewProposalWatcher(nil, nil).Write(text)
+}
@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"github.com/vybdev/vyb/llm/internal/schemacheck"
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// GetWorkspaceChangeProposalSchema parses and returns the schema definition
+// for workspace change proposals.
+func GetWorkspaceChangeProposalSchema() schemas.JSONSchema {
+	return MustLoad(schemas.WorkspaceChangeProposal)
+}
+
+// GetModuleContextSchema returns the schema definition for module context
+// generation.
+func GetModuleContextSchema() schemas.JSONSchema {
+	return MustLoad(schemas.ModuleContext)
+}
+
+// GetModuleExternalContextSchema returns the schema definition used when
+// requesting external contexts in bulk.
+func GetModuleExternalContextSchema() schemas.JSONSchema {
+	return MustLoad(schemas.ModuleExternalContext)
+}
+
+// GetModuleContextsSchema returns the schema definition used when
+// requesting the contexts of several modules at once.
+func GetModuleContextsSchema() schemas.JSONSchema {
+	return MustLoad(schemas.ModuleContexts)
+}
+
+// GetChangeNarrativeSchema returns the schema definition used when
+// describing applied changes.
+func GetChangeNarrativeSchema() schemas.JSONSchema {
+	return MustLoad(schemas.ChangeNarrative)
+}
+
+// dialect is the subset of JSON Schema accepted as the format of
+// a chat request.
+var dialect = schemacheck.Dialect{Keywords: schemacheck.Keywords("additionalProperties")}
+
+// MustLoad parses the shared schema name, see package schemas, panicking
+// with the file name when it is invalid as the format of a chat request.
+func MustLoad(name string) schemas.JSONSchema {
+	return schemas.MustLoad[schemas.JSONSchema](name, dialect)
+}
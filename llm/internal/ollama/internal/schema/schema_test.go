@@ -0,0 +1,15 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// TestDialect ensures the shared schemas are valid as the format of a chat
+// request.
+func TestDialect(t *testing.T) {
+	if err := schemas.Check(dialect); err != nil {
+		t.Fatal(err)
+	}
+}
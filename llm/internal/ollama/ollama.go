@@ -0,0 +1,239 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vybdev/vyb/llm/internal/footer"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/internal/ollama/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/render"
+	"github.com/vybdev/vyb/llm/internal/schemas"
+	"github.com/vybdev/vyb/llm/payload"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// preferredFormat is the layout of the requests sent to local models,
+// unless the configuration overrides it.
+const preferredFormat = render.Markdown
+
+// GetWorkspaceChangeProposals composes the request, sends it to Ollama and
+// converts the response into a strongly-typed WorkspaceChangeProposal.
+func GetWorkspaceChangeProposals(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
+	userMessage, err := render.WorkspaceChangeRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to serialize workspace change request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "workspace_change_proposal", footer.Fields(schema.GetWorkspaceChangeProposalSchema().Properties))
+
+	raw, err := callOllama(ctx, client, systemMessage, userMessage, schema.GetWorkspaceChangeProposalSchema(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposal payload.WorkspaceChangeProposal
+	if err := json.Unmarshal(raw, &proposal); err != nil {
+		return nil, fmt.Errorf("ollama: failed to unmarshal WorkspaceChangeProposal: %w", err)
+	}
+	return &proposal, nil
+}
+
+func GetModuleContext(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
+	userMessage, err := render.ModuleContextRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to serialize module context request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "module_selfcontained_context", footer.Fields(schema.GetModuleContextSchema().Properties))
+
+	raw, err := callOllama(ctx, client, systemMessage, userMessage, schema.GetModuleContextSchema(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var out payload.ModuleSelfContainedContext
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("ollama: failed to unmarshal ModuleSelfContainedContext: %w", err)
+	}
+	return &out, nil
+}
+
+func GetModuleExternalContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
+	userMessage, err := render.ExternalContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to serialize external contexts request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "module_external_context", footer.Fields(schema.GetModuleExternalContextSchema().Properties))
+
+	raw, err := callOllama(ctx, client, systemMessage, userMessage, schema.GetModuleExternalContextSchema(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var ext payload.ModuleExternalContextResponse
+	if err := json.Unmarshal(raw, &ext); err != nil {
+		return nil, fmt.Errorf("ollama: failed to unmarshal ModuleExternalContextResponse: %w", err)
+	}
+	return &ext, nil
+}
+
+// GetModuleContexts calls the LLM and returns the internal and public
+// contexts of every module of request, generated in a single call with the
+// given model.
+func GetModuleContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, error) {
+	userMessage, err := render.ModuleContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to serialize module contexts request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "module_contexts", footer.Fields(schema.GetModuleContextsSchema().Properties))
+
+	raw, err := callOllama(ctx, client, systemMessage, userMessage, schema.GetModuleContextsSchema(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var out payload.ModuleContextsResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("ollama: failed to unmarshal ModuleContextsResponse: %w", err)
+	}
+	return &out, nil
+}
+
+func GetChangeNarrative(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, error) {
+	userMessage, err := render.ChangeNarrativeRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to serialize change narrative request: %w", err)
+	}
+	userMessage = client.Footer.Append(userMessage, "change_narrative", footer.Fields(schema.GetChangeNarrativeSchema().Properties))
+
+	raw, err := callOllama(ctx, client, systemMessage, userMessage, schema.GetChangeNarrativeSchema(), model)
+	if err != nil {
+		return nil, err
+	}
+
+	var narrative payload.ChangeNarrative
+	if err := json.Unmarshal(raw, &narrative); err != nil {
+		return nil, fmt.Errorf("ollama: failed to unmarshal ChangeNarrative: %w", err)
+	}
+	return &narrative, nil
+}
+
+// -----------------------------------------------------------------------------
+// Provider-specific data structures & helpers (non-exported)
+// -----------------------------------------------------------------------------
+
+// NOTE: baseEndpoint is a var (not const) to allow test overrides.
+var baseEndpoint = "http://localhost:11434"
+
+const chatPath = "/api/chat"
+
+// endpoint returns the chat URL, honoring OLLAMA_HOST like the ollama CLI
+// does. A host without scheme (e.g. "127.0.0.1:11434") is served over http.
+func endpoint() string {
+	host := strings.TrimRight(os.Getenv("OLLAMA_HOST"), "/")
+	if host == "" {
+		return baseEndpoint + chatPath
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return host + chatPath
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type requestPayload struct {
+	Model    string             `json:"model"`
+	Messages []message          `json:"messages"`
+	Stream   bool               `json:"stream"`
+	Format   schemas.JSONSchema `json:"format"`
+}
+
+// ollamaResponse mirrors the subset of a non-streamed chat response we
+// care about.
+type ollamaResponse struct {
+	Message message `json:"message"`
+	Done    bool    `json:"done"`
+}
+
+type ollamaErrorResponse struct {
+	Message string `json:"error"`
+}
+
+func (e ollamaErrorResponse) Error() string {
+	return fmt.Sprintf("Ollama API error: %s", e.Message)
+}
+
+// extractJSON returns the first valid JSON object embedded in text. Local
+// models do not always honor the requested format: the object may be
+// wrapped in a code fence, or preceded and followed by prose.
+func extractJSON(text string) ([]byte, error) {
+	for i := strings.IndexByte(text, '{'); i >= 0; {
+		var obj json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(text[i:])).Decode(&obj); err == nil {
+			return obj, nil
+		}
+		next := strings.IndexByte(text[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, errors.New("ollama: no JSON object found in the response")
+}
+
+func callOllama(ctx context.Context, client httpclient.Client, systemMessage, userMessage string, format schemas.JSONSchema, model string) ([]byte, error) {
+	if model == "" {
+		return nil, errors.New("ollama: model must not be empty")
+	}
+
+	bodyBytes, err := json.Marshal(requestPayload{
+		Model: model,
+		Messages: []message{
+			{Role: "system", Content: systemMessage},
+			{Role: "user", Content: userMessage},
+		},
+		Format: format,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint(), bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: request failed (is `ollama serve` running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oErr ollamaErrorResponse
+		if jsonErr := json.Unmarshal(respBytes, &oErr); jsonErr == nil && oErr.Message != "" {
+			return nil, httpclient.StatusError(resp.StatusCode, model, oErr)
+		}
+		return nil, httpclient.StatusError(resp.StatusCode, model, fmt.Errorf("ollama: http %d – %s", resp.StatusCode, string(respBytes)))
+	}
+
+	var out ollamaResponse
+	if err := json.Unmarshal(respBytes, &out); err != nil {
+		return nil, fmt.Errorf("ollama: failed to unmarshal response: %w", err)
+	}
+	return extractJSON(out.Message.Content)
+}
@@ -0,0 +1,230 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/footer"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// chatServer returns a dummy chat endpoint answering every request with
+// content, and records the requested model.
+func chatServer(t *testing.T, content string, model *string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != chatPath {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		var req requestPayload
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if req.Stream || len(req.Messages) != 2 || req.Format.Type != "object" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		if model != nil {
+			*model = req.Model
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"message": map[string]any{"role": "assistant", "content": content},
+			"done":    true,
+		})
+	}))
+}
+
+func TestGetWorkspaceChangeProposals(t *testing.T) {
+	var model string
+	// Local models may wrap the object in prose and code fences.
+	srv := chatServer(t, "Sure! Here you go:\n```json\n{\"summary\":\"s\",\"description\":\"d\",\"proposals\":[]}\n```\nLet me know {if} you need more.", &model)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("OLLAMA_HOST", "")
+
+	req := &payload.WorkspaceChangeRequest{
+		TargetModule:        "test-module",
+		TargetModuleContext: "Test module context",
+		TargetDirectory:     "src/",
+		Files: []payload.FileContent{
+			{Path: "test.go", Content: "package main"},
+		},
+	}
+	got, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "llama3.3:70b", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.WorkspaceChangeProposal{Summary: "s", Description: "d", Proposals: []payload.FileChangeProposal{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected proposal: got %+v, want %+v", got, want)
+	}
+	if model != "llama3.3:70b" {
+		t.Fatalf("expected the configured model tag, got %q", model)
+	}
+}
+
+func TestGetModuleContext_OllamaHost(t *testing.T) {
+	var model string
+	srv := chatServer(t, `{"internal_context":"i","public_context":"p"}`, &model)
+	defer srv.Close()
+
+	// OLLAMA_HOST takes precedence over the default endpoint, with or
+	// without a scheme.
+	t.Setenv("OLLAMA_HOST", srv.Listener.Addr().String())
+
+	got, err := GetModuleContext(context.Background(), httpclient.Client{}, "qwen-test", "sys", &payload.ModuleContextRequest{TargetModuleName: "test-module"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ctx: %+v", got)
+	}
+	if model != "qwen-test" {
+		t.Fatalf("expected the given model, got %q", model)
+	}
+}
+
+func TestGetModuleExternalContexts(t *testing.T) {
+	srv := chatServer(t, `{"modules":[{"name":"foo","external_context":"bar"}]}`, nil)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("OLLAMA_HOST", "")
+
+	req := &payload.ExternalContextsRequest{
+		Modules: []payload.ModuleInfoForExternalContext{
+			{Name: "foo"},
+		},
+	}
+	got, err := GetModuleExternalContexts(context.Background(), httpclient.Client{}, "qwen-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleExternalContextResponse{Modules: []payload.ModuleExternalContext{{Name: "foo", ExternalContext: "bar"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ext ctx: %+v", got)
+	}
+}
+
+func TestGetChangeNarrative(t *testing.T) {
+	srv := chatServer(t, `{"title":"t","body":"b","risk_notes":"r","test_plan":"p"}`, nil)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("OLLAMA_HOST", "")
+
+	req := &payload.ChangeNarrativeRequest{
+		Instructions: "add a greeting",
+		Diffs:        []payload.FileDiff{{Path: "main.go", Diff: "--- a/main.go\n+++ b/main.go\n"}},
+	}
+	got, err := GetChangeNarrative(context.Background(), httpclient.Client{}, "qwen-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ChangeNarrative{Title: "t", Body: "b", RiskNotes: "r", TestPlan: "p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected narrative: %+v", got)
+	}
+
+	if _, err := GetChangeNarrative(context.Background(), httpclient.Client{}, "qwen-test", "sys", &payload.ChangeNarrativeRequest{}); err == nil {
+		t.Fatal("expected an error for a request without diffs")
+	}
+}
+
+func TestGetModuleContexts(t *testing.T) {
+	srv := chatServer(t, `{"modules":[{"name":"a","internal_context":"ai","public_context":"ap"},{"name":".","internal_context":"ri","public_context":"rp"}]}`, nil)
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("OLLAMA_HOST", "")
+
+	req := &payload.ModuleContextsRequest{Modules: []payload.ModuleContextRequest{
+		{TargetModuleName: "a", TargetModuleFiles: []payload.FileContent{{Path: "a/a.go", Content: "package a"}}},
+		{TargetModuleName: ".", SubModulesPublicContexts: []payload.ModuleContext{{Name: "a"}}},
+	}}
+	got, err := GetModuleContexts(context.Background(), httpclient.Client{}, "qwen-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleContextsResponse{Modules: []payload.ModuleSelfContainedContext{
+		{Name: "a", InternalContext: "ai", PublicContext: "ap"},
+		{Name: ".", InternalContext: "ri", PublicContext: "rp"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected contexts: %+v", got)
+	}
+
+	if _, err := GetModuleContexts(context.Background(), httpclient.Client{}, "qwen-test", "sys", &payload.ModuleContextsRequest{}); err == nil {
+		t.Fatal("expected an error for a request without modules")
+	}
+}
+
+func TestGetModuleContext_Footer(t *testing.T) {
+	var userMessage string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req requestPayload
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		userMessage = req.Messages[len(req.Messages)-1].Content
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"message": map[string]any{"role": "assistant", "content": `{"internal_context":"i","public_context":"p"}`},
+			"done":    true,
+		})
+	}))
+	defer srv.Close()
+
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("OLLAMA_HOST", "")
+
+	const reminder = "Respond ONLY with a single JSON object matching the `module_selfcontained_context` schema"
+	req := &payload.ModuleContextRequest{TargetModuleName: "test-module"}
+	for _, tc := range []struct {
+		footer *footer.Footer
+		want   bool
+	}{
+		{footer: nil, want: false},
+		{footer: &footer.Footer{}, want: true},
+	} {
+		if _, err := GetModuleContext(context.Background(), httpclient.Client{Footer: tc.footer}, "qwen-test", "sys", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := strings.Contains(userMessage, reminder); got != tc.want {
+			t.Fatalf("footer enabled=%v, got user message:\n%s", tc.want, userMessage)
+		}
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	cases := map[string]string{
+		`{"a":1}`:                         `{"a":1}`,
+		"text {not json} then {\"a\":{}}": `{"a":{}}`,
+		"```json\n{\"a\":\"}\"}\n```\n{}": `{"a":"}"}`,
+	}
+	for in, want := range cases {
+		got, err := extractJSON(in)
+		if err != nil || string(got) != want {
+			t.Errorf("extractJSON(%q) = %s, %v; want %s", in, got, err, want)
+		}
+	}
+	if _, err := extractJSON("no object here"); err == nil {
+		t.Errorf("expected an error when no object is present")
+	}
+}
@@ -2,7 +2,8 @@ package schema
 
 import (
 	"embed"
-	"encoding/json"
+
+	"github.com/vybdev/vyb/llm/internal/schemacheck"
 )
 
 //go:embed schemas/*
@@ -10,26 +11,40 @@ var embedded embed.FS
 
 // GetWorkspaceChangeProposalSchema reads configuration files from the embedded directory and parses the JSON schema.
 func GetWorkspaceChangeProposalSchema() StructuredOutputSchema {
-	return getSchema("schemas/workspace_change_proposal_schema.json")
+	return MustLoad("schemas/workspace_change_proposal_schema.json")
 }
 
 // GetModuleContextSchema retrieves the structured output schema for the module context from an embedded JSON file.
 func GetModuleContextSchema() StructuredOutputSchema {
-	return getSchema("schemas/module_selfcontained_context_schema.json")
+	return MustLoad("schemas/module_selfcontained_context_schema.json")
 }
 
 // GetModuleExternalContextSchema retrieves the structured output schema for
 // module external context generation from an embedded JSON file.
 func GetModuleExternalContextSchema() StructuredOutputSchema {
-	return getSchema("schemas/module_external_context_schema.json")
+	return MustLoad("schemas/module_external_context_schema.json")
+}
+
+// GetModuleContextsSchema retrieves the structured output schema for the
+// contexts of several modules requested at once from an embedded JSON file.
+func GetModuleContextsSchema() StructuredOutputSchema {
+	return MustLoad("schemas/module_contexts_schema.json")
 }
 
-func getSchema(schemaName string) StructuredOutputSchema {
-	data, _ := embedded.ReadFile(schemaName)
-	var resp StructuredOutputSchema
-	// this file is embedded, so ignore the error
-	_ = json.Unmarshal(data, &resp)
-	return resp
+// GetChangeNarrativeSchema retrieves the structured output schema for the
+// narrative of applied changes from an embedded JSON file.
+func GetChangeNarrativeSchema() StructuredOutputSchema {
+	return MustLoad("schemas/change_narrative_schema.json")
+}
+
+// dialect is the subset of JSON Schema accepted by strict structured
+// outputs.
+var dialect = schemacheck.Dialect{Keywords: schemacheck.Keywords("additionalProperties"), Strict: true}
+
+// MustLoad parses the embedded schema file name, panicking with the file
+// name when its schema is invalid.
+func MustLoad(name string) StructuredOutputSchema {
+	return schemacheck.MustLoad[StructuredOutputSchema](embedded, name, "schema", dialect)
 }
 
 type StructuredOutputSchema struct {
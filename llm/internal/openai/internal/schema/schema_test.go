@@ -2,6 +2,7 @@ package schema
 
 import (
 	"encoding/json"
+	"io/fs"
 	"testing"
 )
 
@@ -20,3 +21,22 @@ func TestGetResponseSchema(t *testing.T) {
 	}
 	t.Logf("Loaded JSON Schema:\n%s", string(b))
 }
+
+// TestEmbeddedSchemas ensures every embedded schema passes the checks of
+// MustLoad, so a malformed edit fails here rather than at run time.
+func TestEmbeddedSchemas(t *testing.T) {
+	names, err := fs.Glob(embedded, "schemas/*.json")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no embedded schema found: %v", err)
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatal(r)
+				}
+			}()
+			MustLoad(name)
+		})
+	}
+}
@@ -2,18 +2,24 @@ package openai
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/footer"
+	"github.com/vybdev/vyb/llm/internal/httpclient"
 	"github.com/vybdev/vyb/llm/internal/openai/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/render"
+	"github.com/vybdev/vyb/llm/internal/repair"
 	"io"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/vybdev/vyb/llm/payload"
-	"time"
+	"github.com/vybdev/vyb/logging"
 )
 
 // message represents a single message in the chat conversation.
@@ -27,6 +33,14 @@ type request struct {
 	Model          string         `json:"model"`
 	Messages       []message      `json:"messages"`
 	ResponseFormat responseFormat `json:"response_format"`
+	Stream         bool           `json:"stream,omitempty"`
+	StreamOptions  *streamOptions `json:"stream_options,omitempty"`
+}
+
+// streamOptions asks for the usage of a streamed response, sent in a last
+// chunk without choices.
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type responseFormat struct {
@@ -36,9 +50,37 @@ type responseFormat struct {
 
 // openaiResponse defines the expected response structure from the OpenAI API.
 type openaiResponse struct {
+	Choices []choice    `json:"choices"`
+	Usage   *tokenUsage `json:"usage,omitempty"`
+}
+
+// tokenUsage is the usage block of a response.
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// stats returns the usage reported in resp, if any, as the usage of a
+// single call.
+func (resp *openaiResponse) stats() payload.UsageStats {
+	if resp == nil || resp.Usage == nil {
+		return payload.UsageStats{}
+	}
+	return payload.UsageStats{Calls: 1, PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+}
+
+type choice struct {
+	Message message `json:"message"`
+}
+
+// streamChunk is a single server-sent event of a streamed response.
+type streamChunk struct {
 	Choices []struct {
-		Message message `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
 	} `json:"choices"`
+	Usage *tokenUsage `json:"usage"`
 }
 
 type openaiErrorResponse struct {
@@ -54,113 +96,138 @@ func (o openaiErrorResponse) Error() string {
 	return fmt.Sprintf("OpenAI API error: %s", o.OpenAIError.Message)
 }
 
-// -----------------------------------------------------------------------------
-//
-//	Model resolver
-//
-// -----------------------------------------------------------------------------
-// mapModel converts a generic (family,size) pair into a concrete OpenAI model
-// string.  The mapping is local to this provider so business-level code never
-// depends on provider-specific identifiers.
-func mapModel(fam config.ModelFamily, sz config.ModelSize) (string, error) {
-	switch fam {
-	case config.ModelFamilyGPT:
-		switch sz {
-		case config.ModelSizeLarge:
-			return "GPT-4.1", nil
-		case config.ModelSizeSmall:
-			return "GPT-4.1-mini", nil
-		}
-	case config.ModelFamilyReasoning:
-		switch sz {
-		case config.ModelSizeLarge:
-			return "o3", nil
-		case config.ModelSizeSmall:
-			return "o4-mini", nil
-		}
-	}
-	return "", fmt.Errorf("openai: unsupported model mapping for family=%s size=%s", fam, sz)
-}
-
 // GetModuleContext calls the LLM and returns a parsed ModuleSelfContainedContext
-// value using the model derived from family/size.
-func GetModuleContext(systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, error) {
-	userMessage, err := serializeModuleContextRequest(request)
+// value using the given model, and the usage of the call.
+func GetModuleContext(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+	userMessage, err := render.ModuleContextRequest(client.Format.Or(preferredFormat), request)
 	if err != nil {
-		return nil, fmt.Errorf("openai: failed to serialize module context request: %w", err)
+		return nil, payload.UsageStats{}, fmt.Errorf("openai: failed to serialize module context request: %w", err)
 	}
-	model := "o4-mini"
-	openaiResp, err := callOpenAI(systemMessage, userMessage, schema.GetModuleContextSchema(), model)
+	userMessage = client.Footer.Append(userMessage, "module_selfcontained_context", footer.Fields(schema.GetModuleContextSchema().Schema.Properties))
+	openaiResp, err := callOpenAI(ctx, client, systemMessage, userMessage, schema.GetModuleContextSchema(), model)
 	if err != nil {
-		var openAIErrResp openaiErrorResponse
-		if errors.As(err, &openAIErrResp) {
-			if openAIErrResp.OpenAIError.Code == "rate_limit_exceeded" {
-				fmt.Printf("Rate limit exceeded, retrying after 30s\n")
-				<-time.After(30 * time.Second)
-				return GetModuleContext(systemMessage, request)
-			}
-		}
-		return nil, err
-	}
-	var ctx payload.ModuleSelfContainedContext
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &ctx); err != nil {
-		return nil, err
+		return nil, payload.UsageStats{}, err
 	}
-	return &ctx, nil
+	out, err := decodeChoice[payload.ModuleSelfContainedContext](openaiResp)
+	return out, openaiResp.stats(), err
 }
 
 // GetWorkspaceChangeProposals sends the given messages to the OpenAI API and
-// returns the structured workspace change proposal.
-func GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, error) {
-	userMessage, err := serializeWorkspaceChangeRequest(request)
+// returns the structured workspace change proposal, and the usage of the
+// calls made.
+func GetWorkspaceChangeProposals(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.WorkspaceChangeRequest) (*payload.WorkspaceChangeProposal, payload.UsageStats, error) {
+	userMessage, err := render.WorkspaceChangeRequest(client.Format.Or(preferredFormat), request)
 	if err != nil {
-		return nil, fmt.Errorf("openai: failed to serialize workspace change request: %w", err)
+		return nil, payload.UsageStats{}, fmt.Errorf("openai: failed to serialize workspace change request: %w", err)
 	}
-	model, err := mapModel(fam, sz)
+	userMessage = client.Footer.Append(userMessage, "workspace_change_proposal", footer.Fields(schema.GetWorkspaceChangeProposalSchema().Schema.Properties))
+
+	messages := []message{{Role: "system", Content: systemMessage}, {Role: "user", Content: userMessage}}
+	openaiResp, err := callOpenAIMessages(ctx, client, messages, schema.GetWorkspaceChangeProposalSchema(), model)
 	if err != nil {
-		return nil, err
+		return nil, payload.UsageStats{}, err
+	}
+	usage := openaiResp.stats()
+	proposal, err := decodeChoice[payload.WorkspaceChangeProposal](openaiResp)
+	if !repair.InvalidJSON(err) || ctx.Err() != nil {
+		return proposal, usage, err
 	}
 
-	openaiResp, err := callOpenAI(systemMessage, userMessage, schema.GetWorkspaceChangeProposalSchema(), model)
+	// Send the invalid answer back once, so the model can fix it.
+	logging.Log.Warnf("OpenAI model %s returned invalid JSON, asking it to repair it: %v", model, err)
+	messages = append(messages,
+		message{Role: "assistant", Content: openaiResp.Choices[0].Message.Content},
+		message{Role: "user", Content: repair.Prompt(err)})
+	openaiResp, err = callOpenAIMessages(ctx, client, messages, schema.GetWorkspaceChangeProposalSchema(), model)
 	if err != nil {
-		return nil, err
+		return nil, usage, err
 	}
-
-	var proposal payload.WorkspaceChangeProposal
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &proposal); err != nil {
-		return nil, err
+	usage.Add(openaiResp.stats())
+	proposal, err = decodeChoice[payload.WorkspaceChangeProposal](openaiResp)
+	if err != nil {
+		return nil, usage, repair.Failed(err)
 	}
-	return &proposal, nil
+	return proposal, usage, err
 }
 
 // NOTE: baseEndpoint is a var (not const) to allow test overrides.
-var baseEndpoint = "https://api.openai.com/v1/chat/completions"
+var baseEndpoint = "https://api.openai.com/v1"
+
+const chatCompletionsPath = "/chat/completions"
+
+// endpoint returns the chat completions URL. The base URL configured on
+// client wins over OPENAI_BASE_URL, which wins over baseEndpoint. Like the
+// OpenAI SDKs, the base URL ends with the API version (e.g.
+// "https://gateway.example.com/v1"); a trailing slash or chat completions
+// path is tolerated.
+func endpoint(client httpclient.Client) string {
+	base := client.BaseURL
+	if base == "" {
+		base = os.Getenv("OPENAI_BASE_URL")
+	}
+	if base == "" {
+		base = baseEndpoint
+	}
+	base = strings.TrimSuffix(strings.TrimRight(base, "/"), chatCompletionsPath)
+	return base + chatCompletionsPath
+}
+
+// lookupAPIKey returns the API key, read from the environment variable configured
+// on client, or OPENAI_API_KEY.
+func lookupAPIKey(client httpclient.Client) (string, error) {
+	name := client.APIKeyEnv
+	if name == "" {
+		name = "OPENAI_API_KEY"
+	}
+	key := os.Getenv(name)
+	if key == "" {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	return key, nil
+}
+
+// maxRequestBytes is the largest request body accepted by the chat
+// completions endpoint, which answers 413 beyond it.
+const maxRequestBytes = 32 << 20
+
+// preferredFormat is the layout of the requests sent to OpenAI models,
+// unless the configuration overrides it.
+const preferredFormat = render.Markdown
 
 // callOpenAI sends a request to OpenAI, returns the parsed response, and logs
 // the request/response pair to a uniquely-named JSON file in the OS temp dir.
-func callOpenAI(systemMessage, userMessage string, structuredOutput schema.StructuredOutputSchema, model string) (*openaiResponse, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("OPENAI_API_KEY is not set")
+func callOpenAI(ctx context.Context, client httpclient.Client, systemMessage, userMessage string, structuredOutput schema.StructuredOutputSchema, model string) (*openaiResponse, error) {
+	return callOpenAIMessages(ctx, client, []message{
+		{
+			Role:    "system",
+			Content: systemMessage,
+		},
+		{
+			Role:    "user",
+			Content: userMessage,
+		},
+	}, structuredOutput, model)
+}
+
+// callOpenAIMessages sends the conversation messages to the OpenAI API.
+func callOpenAIMessages(ctx context.Context, client httpclient.Client, messages []message, structuredOutput schema.StructuredOutputSchema, model string) (*openaiResponse, error) {
+	apiKey, err := lookupAPIKey(client)
+	if err != nil {
+		return nil, err
 	}
 
 	// Construct request payload.
 	reqPayload := request{
-		Model: model,
-		Messages: []message{
-			{
-				Role:    "system",
-				Content: systemMessage,
-			},
-			{
-				Role:    "user",
-				Content: userMessage,
-			},
-		},
+		Model:    model,
+		Messages: messages,
 		ResponseFormat: responseFormat{
 			Type:       "json_schema",
 			JSONSchema: structuredOutput,
 		},
+		Stream: client.Stream,
+	}
+	if client.Stream {
+		reqPayload.StreamOptions = &streamOptions{IncludeUsage: true}
 	}
 
 	reqBytes, err := json.MarshalIndent(reqPayload, "", "  ")
@@ -168,20 +235,23 @@ func callOpenAI(systemMessage, userMessage string, structuredOutput schema.Struc
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", baseEndpoint, bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint(client), bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	// client.Do retries req with the same key, so OpenAI does not process
+	// (and bill) twice a request it received but failed to answer in time.
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 
-	fmt.Printf("About to call OpenAI\n")
-	client := &http.Client{}
+	logging.Log.Debugf("calling OpenAI model %s", model)
+	client.MaxBodyBytes = maxRequestBytes
 	resp, err := client.Do(req)
-	fmt.Printf("Fininshed calling OpenAI\n")
+	logging.Log.Debugf("finished calling OpenAI model %s", model)
 
 	if err != nil {
-		fmt.Printf("Got an error back %v\n", err)
+		logging.Log.Errorf("OpenAI request failed: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -191,16 +261,21 @@ func callOpenAI(systemMessage, userMessage string, structuredOutput schema.Struc
 
 		var errorResp openaiErrorResponse
 		if err := json.Unmarshal(bodyBytes, &errorResp); err != nil {
-			fmt.Printf("Response code %d, aborting\nOpenAI API error: %s\n", resp.StatusCode, string(bodyBytes))
-			return nil, fmt.Errorf("OpenAI API error: %s", string(bodyBytes))
+			logging.Log.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(bodyBytes))
+			return nil, httpclient.StatusError(resp.StatusCode, model, fmt.Errorf("OpenAI API error: %s", string(bodyBytes)))
 		}
 
-		return nil, errorResp
+		return nil, httpclient.StatusError(resp.StatusCode, model, errorResp)
+	}
+
+	if client.Stream {
+		progress := httpclient.NewProgress(client.Progress, "OpenAI")
+		return readStream(resp.Body, progress, httpclient.NewProposalWatcher(client.OnProposal, progress))
 	}
 
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Error when reading response body%v\n", err)
+		logging.Log.Errorf("failed to read the OpenAI response body: %v", err)
 		return nil, err
 	}
 
@@ -213,237 +288,130 @@ func callOpenAI(systemMessage, userMessage string, structuredOutput schema.Struc
 		return nil, errors.New("no choices returned from OpenAI")
 	}
 
-	// ------------------------------------------------------------
-	// Persist request and response to a unique temp-file for debug.
-	// ------------------------------------------------------------
-	logEntry := struct {
-		Request  json.RawMessage `json:"request"`
-		Response json.RawMessage `json:"response"`
-	}{
-		Request:  reqBytes,
-		Response: respBytes,
-	}
-
-	if logBytes, err := json.MarshalIndent(logEntry, "", "  "); err == nil {
-		if f, err := os.CreateTemp("", "vyb-openai-*.json"); err == nil {
-			if _, wErr := f.Write(logBytes); wErr == nil {
-				_ = f.Close()
-			} else {
-				fmt.Printf("error writing OpenAI log file: %v\n", wErr)
-			}
-			fmt.Printf("Wrote OpenAI log file to %s\n", f.Name())
-		} else {
-			fmt.Printf("error creating OpenAI log file: %v\n", err)
-		}
-	} else {
-		fmt.Printf("error marshalling OpenAI log entry: %v\n", err)
-	}
-
 	return &openaiResp, nil
 }
 
-// GetModuleExternalContexts calls the LLM and returns a list of external
-// context strings – one per module.
-func GetModuleExternalContexts(systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, error) {
-	userMessage, err := serializeExternalContextsRequest(request)
-	if err != nil {
-		return nil, fmt.Errorf("openai: failed to serialize external contexts request: %w", err)
-	}
-	model := "o4-mini"
-	openaiResp, err := callOpenAI(systemMessage, userMessage, schema.GetModuleExternalContextSchema(), model)
-	if err != nil {
-		return nil, err
-	}
-
-	var resp payload.ModuleExternalContextResponse
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &resp); err != nil {
-		return nil, err
-	}
-	return &resp, nil
+// newIdempotencyKey returns a random key identifying a logical request.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "vyb-" + hex.EncodeToString(b)
 }
 
-// -----------------------------------------------------------------------------
-//
-//	Request Serializers
-//
-// -----------------------------------------------------------------------------
-
-func serializeWorkspaceChangeRequest(request *payload.WorkspaceChangeRequest) (string, error) {
-	if request == nil {
-		return "", fmt.Errorf("WorkspaceChangeRequest must not be nil")
-	}
-	if request.TargetModule == "" {
-		return "", fmt.Errorf("TargetModule is required")
-	}
-	if request.TargetDirectory == "" {
-		return "", fmt.Errorf("TargetDirectory is required")
-	}
-	
-	var sb strings.Builder
-	
-	// Write target module information (these are now required)
-	sb.WriteString(fmt.Sprintf("# Target Module: `%s`\n", request.TargetModule))
-	sb.WriteString("## Target Module Context\n")
-	sb.WriteString(fmt.Sprintf("%s\n\n", request.TargetModuleContext))
-	sb.WriteString(fmt.Sprintf("## Target Directory: `%s`\n\n", request.TargetDirectory))
-	
-	// Write parent module contexts
-	if len(request.ParentModuleContexts) > 0 {
-		sb.WriteString("# Parent Module Contexts\n")
-		for _, mc := range request.ParentModuleContexts {
-			ctx := &payload.ModuleSelfContainedContext{
-				Name: mc.Name,
-				PublicContext: mc.Content,
-			}
-			writeModule(&sb, mc.Name, ctx)
+// decodeChoice unmarshals the content of the first choice of resp holding
+// valid JSON for T. Choices are tried in order, so an alternative can make up
+// for a malformed first choice; the error of the first choice is returned
+// when none is valid.
+func decodeChoice[T any](resp *openaiResponse) (*T, error) {
+	var firstErr error
+	for i, c := range resp.Choices {
+		var out T
+		err := json.Unmarshal([]byte(c.Message.Content), &out)
+		if err == nil {
+			return &out, nil
 		}
-		sb.WriteString("\n")
-	}
-	
-	// Write sub-module contexts
-	if len(request.SubModuleContexts) > 0 {
-		sb.WriteString("# Sub-Module Contexts\n")
-		for _, mc := range request.SubModuleContexts {
-			ctx := &payload.ModuleSelfContainedContext{
-				Name: mc.Name,
-				PublicContext: mc.Content,
-			}
-			writeModule(&sb, mc.Name, ctx)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("failed to unmarshal choice %d into %T: %w", i, out, err)
 		}
-		sb.WriteString("\n")
 	}
-
-	// Write files
-	if len(request.Files) > 0 {
-		sb.WriteString("# Files\n")
-		for _, f := range request.Files {
-			writeFile(&sb, f.Path, f.Content)
-		}
+	if firstErr == nil {
+		return nil, errors.New("no choices returned from OpenAI")
 	}
-
-	return sb.String(), nil
+	return nil, firstErr
 }
 
-func serializeModuleContextRequest(request *payload.ModuleContextRequest) (string, error) {
-	if request == nil {
-		return "", fmt.Errorf("ModuleContextRequest must not be nil")
-	}
-
+// readStream assembles the chunks of a streamed response into the response
+// the API would have returned without streaming, reporting every received
+// chunk (a token, in practice) to progress and its content to watcher.
+func readStream(body io.Reader, progress *httpclient.Progress, watcher *httpclient.ProposalWatcher) (*openaiResponse, error) {
 	var sb strings.Builder
-	rootPrefix := request.TargetModuleName
-
-	// Only spend these tokens if we need to teach the LLM that a directory != module.
-	if len(request.TargetModuleDirectories) > 1 {
-		sb.WriteString(fmt.Sprintf("## Directories in module `%s`\n", rootPrefix))
-		sb.WriteString(fmt.Sprintf("The following is a list of directories that are part of the module `%s`\n.", rootPrefix))
-		sb.WriteString(fmt.Sprintf("These ARE NOT MODULES, they are directories within the module. When summarizing their file contents, include them in the summary of `%s`, do not make up modules for them.\n", rootPrefix))
-		for _, dir := range request.TargetModuleDirectories {
-			sb.WriteString(fmt.Sprintf("- %s\n", dir))
+	var usage *tokenUsage
+	tokens := 0
+	err := httpclient.ReadEvents(body, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
 		}
-	}
-
-	sb.WriteString(fmt.Sprintf("## Files in module `%s`\n", rootPrefix))
-	// Emit root-module files.
-	for _, file := range request.TargetModuleFiles {
-		writeFile(&sb, file.Path, file.Content)
-	}
-
-	// Emit public context of immediate sub-modules.
-	for _, sub := range request.SubModulesPublicContexts {
-		// We only expose the public context of immediate sub-modules.
-		if sub.Content == "" && sub.Name == "" {
-			continue
+		var errorResp openaiErrorResponse
+		if err := json.Unmarshal(data, &errorResp); err == nil && errorResp.OpenAIError.Message != "" {
+			return errorResp
 		}
-
-		trimmedCtx := &payload.ModuleSelfContainedContext{
-			Name:          sub.Name,
-			PublicContext: sub.Content,
+		var chunk streamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("failed to decode OpenAI stream chunk: %w", err)
 		}
-		writeModule(&sb, trimmedCtx.Name, trimmedCtx)
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content == "" {
+				continue
+			}
+			sb.WriteString(c.Delta.Content)
+			watcher.Write(c.Delta.Content)
+			tokens++
+		}
+		progress.Report(tokens)
+		return nil
+	})
+	progress.Done()
+	if err != nil {
+		return nil, err
+	}
+	if tokens == 0 {
+		return nil, errors.New("no choices returned from OpenAI")
 	}
 
-	return sb.String(), nil
+	return &openaiResponse{Choices: []choice{{Message: message{Role: "assistant", Content: sb.String()}}}, Usage: usage}, nil
 }
 
-func serializeExternalContextsRequest(request *payload.ExternalContextsRequest) (string, error) {
-	if request == nil {
-		return "", fmt.Errorf("ExternalContextsRequest must not be nil")
+// GetModuleExternalContexts calls the LLM and returns a list of external
+// context strings – one per module – and the usage of the call.
+func GetModuleExternalContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+	userMessage, err := render.ExternalContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, payload.UsageStats{}, fmt.Errorf("openai: failed to serialize external contexts request: %w", err)
 	}
-
-	var sb strings.Builder
-
-	// Write each module with H1 headers
-	for _, module := range request.Modules {
-		if module.Name == "" {
-			continue
-		}
-		sb.WriteString(fmt.Sprintf("# Module: `%s`\n", module.Name))
-		if module.ParentName != "" {
-			sb.WriteString(fmt.Sprintf("Parent Module: `%s`\n\n", module.ParentName))
-		}
-		if module.InternalContext != "" {
-			sb.WriteString("## Internal Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n\n", module.InternalContext))
-		}
-		if module.PublicContext != "" {
-			sb.WriteString("## Public Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n\n", module.PublicContext))
-		}
+	userMessage = client.Footer.Append(userMessage, "module_external_context", footer.Fields(schema.GetModuleExternalContextSchema().Schema.Properties))
+	openaiResp, err := callOpenAI(ctx, client, systemMessage, userMessage, schema.GetModuleExternalContextSchema(), model)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
 	}
 
-	return sb.String(), nil
+	out, err := decodeChoice[payload.ModuleExternalContextResponse](openaiResp)
+	return out, openaiResp.stats(), err
 }
 
-func writeModule(sb *strings.Builder, path string, context *payload.ModuleSelfContainedContext) {
-	if sb == nil {
-		return
-	}
-	if path == "" && (context == nil || (context.ExternalContext == "" && context.InternalContext == "" && context.PublicContext == "")) {
-		return
+// GetModuleContexts calls the LLM and returns the internal and public
+// contexts of every module of request, generated in a single call with the
+// given model, and the usage of the call.
+func GetModuleContexts(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+	userMessage, err := render.ModuleContextsRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, payload.UsageStats{}, fmt.Errorf("openai: failed to serialize module contexts request: %w", err)
 	}
-	sb.WriteString(fmt.Sprintf("# Module: `%s`\n", path))
-	if context != nil {
-		if context.ExternalContext != "" {
-			sb.WriteString("## External Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n", context.ExternalContext))
-		}
-		if context.InternalContext != "" {
-			sb.WriteString("## Internal Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n", context.InternalContext))
-		}
-		if context.PublicContext != "" {
-			sb.WriteString("## Public Context\n")
-			sb.WriteString(fmt.Sprintf("%s\n", context.PublicContext))
-		}
+	userMessage = client.Footer.Append(userMessage, "module_contexts", footer.Fields(schema.GetModuleContextsSchema().Schema.Properties))
+	openaiResp, err := callOpenAI(ctx, client, systemMessage, userMessage, schema.GetModuleContextsSchema(), model)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
 	}
+
+	out, err := decodeChoice[payload.ModuleContextsResponse](openaiResp)
+	return out, openaiResp.stats(), err
 }
 
-func writeFile(sb *strings.Builder, filepath, content string) {
-	if sb == nil {
-		return
+// GetChangeNarrative calls the LLM and returns the narrative of the changes
+// described by request, using the given model, and the usage of the call.
+func GetChangeNarrative(ctx context.Context, client httpclient.Client, model, systemMessage string, request *payload.ChangeNarrativeRequest) (*payload.ChangeNarrative, payload.UsageStats, error) {
+	userMessage, err := render.ChangeNarrativeRequest(client.Format.Or(preferredFormat), request)
+	if err != nil {
+		return nil, payload.UsageStats{}, fmt.Errorf("openai: failed to serialize change narrative request: %w", err)
 	}
-	lang := getLanguageFromFilename(filepath)
-	sb.WriteString(fmt.Sprintf("### %s\n", filepath))
-	sb.WriteString(fmt.Sprintf("```%s\n", lang))
-	sb.WriteString(content)
-	// Ensure a trailing newline before closing the code block.
-	if !strings.HasSuffix(content, "\n") {
-		sb.WriteString("\n")
+	userMessage = client.Footer.Append(userMessage, "change_narrative", footer.Fields(schema.GetChangeNarrativeSchema().Schema.Properties))
+	openaiResp, err := callOpenAI(ctx, client, systemMessage, userMessage, schema.GetChangeNarrativeSchema(), model)
+	if err != nil {
+		return nil, payload.UsageStats{}, err
 	}
-	sb.WriteString("```\n\n")
-}
 
-// getLanguageFromFilename returns a language identifier based on file extension.
-func getLanguageFromFilename(filename string) string {
-	if strings.HasSuffix(filename, ".go") {
-		return "go"
-	} else if strings.HasSuffix(filename, ".md") {
-		return "markdown"
-	} else if strings.HasSuffix(filename, ".json") {
-		return "json"
-	} else if strings.HasSuffix(filename, ".txt") {
-		return "text"
-	}
-	// Default: no language specified.
-	return ""
+	out, err := decodeChoice[payload.ChangeNarrative](openaiResp)
+	return out, openaiResp.stats(), err
 }
@@ -0,0 +1,245 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/httpclient"
+	"github.com/vybdev/vyb/llm/internal/repair"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+const proposalJSON = `{"summary":"s","description":"d","proposals":[{"file_name":"a.go","content":"package a\n","delete":false}]}`
+
+// testUsage is the usage reported by newServer.
+var testUsage = tokenUsage{PromptTokens: 12, CompletionTokens: 34}
+
+// newServer answers proposalJSON, streamed in small chunks when the request
+// asks for it, and reports testUsage.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if !req.Stream {
+			_ = json.NewEncoder(w).Encode(openaiResponse{Choices: []choice{{Message: message{Role: "assistant", Content: proposalJSON}}}, Usage: &testUsage})
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < len(proposalJSON); i += 7 {
+			delta, _ := json.Marshal(proposalJSON[i:min(i+7, len(proposalJSON))])
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%s}}]}\n\n", delta)
+			w.(http.Flusher).Flush()
+		}
+		if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+			usage, _ := json.Marshal(testUsage)
+			fmt.Fprintf(w, "data: {\"choices\":[],\"usage\":%s}\n\n", usage)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func useServer(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	old := baseEndpoint
+	baseEndpoint = srv.URL
+	t.Cleanup(func() { baseEndpoint = old })
+	t.Setenv("OPENAI_API_KEY", "x")
+	t.Setenv("OPENAI_BASE_URL", "")
+}
+
+func TestEndpoint(t *testing.T) {
+	t.Setenv("OPENAI_BASE_URL", "")
+	if got, want := endpoint(httpclient.Client{}), "https://api.openai.com/v1/chat/completions"; got != want {
+		t.Fatalf("default endpoint = %q, want %q", got, want)
+	}
+
+	t.Setenv("OPENAI_BASE_URL", "https://env.example.com/v1/")
+	if got, want := endpoint(httpclient.Client{}), "https://env.example.com/v1/chat/completions"; got != want {
+		t.Fatalf("env endpoint = %q, want %q", got, want)
+	}
+
+	// The configured base URL wins over the environment.
+	client := httpclient.Client{BaseURL: "https://gateway.example.com/openai/v1/chat/completions/"}
+	if got, want := endpoint(client), "https://gateway.example.com/openai/v1/chat/completions"; got != want {
+		t.Fatalf("configured endpoint = %q, want %q", got, want)
+	}
+}
+
+func TestLookupAPIKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "public")
+	t.Setenv("OPENAI_PROXY_KEY", "")
+	if key, err := lookupAPIKey(httpclient.Client{}); err != nil || key != "public" {
+		t.Fatalf("default key = %q, %v", key, err)
+	}
+
+	// The configured variable replaces OPENAI_API_KEY.
+	client := httpclient.Client{APIKeyEnv: "OPENAI_PROXY_KEY"}
+	if _, err := lookupAPIKey(client); err == nil || !strings.Contains(err.Error(), "OPENAI_PROXY_KEY is not set") {
+		t.Fatalf("expected the configured variable to be required, got %v", err)
+	}
+	t.Setenv("OPENAI_PROXY_KEY", "proxy")
+	if key, err := lookupAPIKey(client); err != nil || key != "proxy" {
+		t.Fatalf("configured key = %q, %v", key, err)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_Stream(t *testing.T) {
+	useServer(t, newServer(t))
+
+	req := &payload.WorkspaceChangeRequest{
+		TargetModule:    "m",
+		TargetDirectory: "m/",
+		Files:           []payload.FileContent{{Path: "m/a.go", Content: "package a"}},
+	}
+	want, wantUsage, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gpt-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wantUsage != (payload.UsageStats{Calls: 1, PromptTokens: 12, CompletionTokens: 34}) {
+		t.Fatalf("expected the usage of the response, got %+v", wantUsage)
+	}
+
+	var progress bytes.Buffer
+	var received []string
+	client := httpclient.Client{Stream: true, Progress: &progress, OnProposal: func(name string) { received = append(received, name) }}
+	got, gotUsage, err := GetWorkspaceChangeProposals(context.Background(), client, "gpt-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected streaming error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) || gotUsage != wantUsage {
+		t.Fatalf("streamed proposal %+v (usage %+v) differs from %+v (usage %+v)", got, gotUsage, want, wantUsage)
+	}
+	if !strings.Contains(progress.String(), "tokens received") {
+		t.Fatalf("expected progress to be reported, got %q", progress.String())
+	}
+	if !reflect.DeepEqual(received, []string{"a.go"}) {
+		t.Fatalf("expected the proposal of a.go to be reported, got %v", received)
+	}
+}
+
+func TestReadStream_Error(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"{\"}}]}\n\n" +
+		"data: {\"error\":{\"message\":\"overloaded\"}}\n\n"
+	_, err := readStream(strings.NewReader(stream), nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("expected the stream error, got %v", err)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_SkipsInvalidChoices(t *testing.T) {
+	respond := func(choices ...string) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var resp openaiResponse
+			for _, c := range choices {
+				resp.Choices = append(resp.Choices, choice{Message: message{Role: "assistant", Content: c}})
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		}))
+		t.Cleanup(srv.Close)
+		useServer(t, srv)
+	}
+	req := &payload.WorkspaceChangeRequest{TargetModule: "m", TargetDirectory: "m/"}
+
+	respond(`{"summary": "truncated`, proposalJSON)
+	got, _, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gpt-test", "sys", req)
+	if err != nil {
+		t.Fatalf("expected the second choice to be used, got %v", err)
+	}
+	if len(got.Proposals) != 1 || got.Proposals[0].FileName != "a.go" {
+		t.Fatalf("unexpected proposal %+v", got)
+	}
+
+	respond(`{"summary": "truncated`, "not json")
+	if _, _, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gpt-test", "sys", req); err == nil || !strings.Contains(err.Error(), "choice 0") {
+		t.Fatalf("expected the error of the first choice, got %v", err)
+	}
+}
+
+func TestCallOpenAI_IdempotencyKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(openaiResponse{Choices: []choice{{Message: message{Role: "assistant", Content: proposalJSON}}}})
+	}))
+	t.Cleanup(srv.Close)
+	useServer(t, srv)
+
+	req := &payload.WorkspaceChangeRequest{TargetModule: "m", TargetDirectory: "m/"}
+	if _, _, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{MaxRetries: 1}, "gpt-test", "sys", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("expected the retry to send the key of the first attempt, got %q", keys)
+	}
+
+	// Another logical request gets another key.
+	if _, _, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gpt-test", "sys", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 3 || keys[2] == keys[0] {
+		t.Fatalf("expected a new key for a new request, got %q", keys)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_RepairsInvalidJSON(t *testing.T) {
+	var answers []string
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		requests = append(requests, req)
+		answer := answers[0]
+		answers = answers[1:]
+		_ = json.NewEncoder(w).Encode(openaiResponse{Choices: []choice{{Message: message{Role: "assistant", Content: answer}}}, Usage: &testUsage})
+	}))
+	t.Cleanup(srv.Close)
+	useServer(t, srv)
+	req := &payload.WorkspaceChangeRequest{TargetModule: "m", TargetDirectory: "m/"}
+
+	answers = []string{"Sure! Here is the proposal:", proposalJSON}
+	got, usage, err := GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gpt-test", "sys", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage != (payload.UsageStats{Calls: 2, PromptTokens: 24, CompletionTokens: 68}) {
+		t.Fatalf("expected the usage of both calls, got %+v", usage)
+	}
+	if len(got.Proposals) != 1 || got.Proposals[0].FileName != "a.go" {
+		t.Fatalf("unexpected proposal %+v", got)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected a single repair request, got %d requests", len(requests))
+	}
+	msgs := requests[1].Messages
+	if len(msgs) != 4 || msgs[2].Role != "assistant" || msgs[2].Content != "Sure! Here is the proposal:" ||
+		msgs[3].Role != "user" || !strings.Contains(msgs[3].Content, "invalid JSON") {
+		t.Fatalf("expected the invalid answer to be sent back with a repair request, got %+v", msgs)
+	}
+
+	// The repair is attempted once.
+	answers, requests = []string{"garbage", "more garbage"}, nil
+	_, _, err = GetWorkspaceChangeProposals(context.Background(), httpclient.Client{}, "gpt-test", "sys", req)
+	if !errors.Is(err, repair.ErrFailed) || len(requests) != 2 {
+		t.Fatalf("expected ErrFailed after two requests, got %v after %d requests", err, len(requests))
+	}
+}
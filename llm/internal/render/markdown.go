@@ -0,0 +1,226 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func markdownWorkspaceChangeRequest(request *payload.WorkspaceChangeRequest) string {
+	var sb strings.Builder
+
+	// Write target module information (these are now required)
+	sb.WriteString(fmt.Sprintf("# Target Module: `%s`\n", request.TargetModule))
+	sb.WriteString("## Target Module Context\n")
+	sb.WriteString(fmt.Sprintf("%s\n\n", request.TargetModuleContext))
+	sb.WriteString(fmt.Sprintf("## Target Directory: `%s`\n\n", request.TargetDirectory))
+
+	// Write the files the user is focused on
+	if len(request.TargetFiles) > 0 {
+		sb.WriteString("## Target Files\n")
+		sb.WriteString("The user invoked the command on these files, focus the changes on them.\n")
+		for _, f := range request.TargetFiles {
+			sb.WriteString(fmt.Sprintf("- `%s`\n", f))
+		}
+		sb.WriteString("\n")
+	}
+
+	// Write parent module contexts
+	if len(request.ParentModuleContexts) > 0 {
+		sb.WriteString("# Parent Module Contexts\n")
+		for _, mc := range request.ParentModuleContexts {
+			ctx := &payload.ModuleSelfContainedContext{
+				Name:          mc.Name,
+				PublicContext: mc.Content,
+			}
+			writeModule(&sb, mc.Name, ctx)
+		}
+		sb.WriteString("\n")
+	}
+
+	// Write sub-module contexts
+	if len(request.SubModuleContexts) > 0 {
+		sb.WriteString("# Sub-Module Contexts\n")
+		for _, mc := range request.SubModuleContexts {
+			ctx := &payload.ModuleSelfContainedContext{
+				Name:          mc.Name,
+				PublicContext: mc.Content,
+			}
+			writeModule(&sb, mc.Name, ctx)
+		}
+		sb.WriteString("\n")
+	}
+
+	// Write the modules whose files were left out by the token budget
+	if len(request.SummarizedModuleContexts) > 0 {
+		sb.WriteString("# Summarized Module Contexts\n")
+		sb.WriteString(summarizedNote + "\n")
+		for _, mc := range request.SummarizedModuleContexts {
+			ctx := &payload.ModuleSelfContainedContext{
+				Name:            mc.Name,
+				InternalContext: mc.Content,
+			}
+			writeModule(&sb, mc.Name, ctx)
+		}
+		sb.WriteString("\n")
+	}
+
+	// Write files
+	if len(request.Files) > 0 {
+		sb.WriteString("# Files\n")
+		for _, f := range request.Files {
+			writeFile(&sb, f)
+		}
+	}
+
+	return sb.String()
+}
+
+func markdownModuleContextsRequest(request *payload.ModuleContextsRequest) string {
+	var sb strings.Builder
+	for i := range request.Modules {
+		sb.WriteString(fmt.Sprintf("# Module `%s`\n", request.Modules[i].TargetModuleName))
+		sb.WriteString(markdownModuleContextRequest(&request.Modules[i]))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func markdownModuleContextRequest(request *payload.ModuleContextRequest) string {
+	var sb strings.Builder
+	rootPrefix := request.TargetModuleName
+
+	// Only spend these tokens if we need to teach the LLM that a directory != module.
+	if len(request.TargetModuleDirectories) > 1 {
+		sb.WriteString(fmt.Sprintf("## Directories in module `%s`\n", rootPrefix))
+		sb.WriteString(fmt.Sprintf("The following is a list of directories that are part of the module `%s`\n.", rootPrefix))
+		sb.WriteString(fmt.Sprintf("These ARE NOT MODULES, they are directories within the module. When summarizing their file contents, include them in the summary of `%s`, do not make up modules for them.\n", rootPrefix))
+		for _, dir := range request.TargetModuleDirectories {
+			sb.WriteString(fmt.Sprintf("- %s\n", dir))
+		}
+	}
+
+	sb.WriteString(fmt.Sprintf("## Files in module `%s`\n", rootPrefix))
+	// Emit root-module files.
+	for _, file := range request.TargetModuleFiles {
+		writeFile(&sb, file)
+	}
+
+	// Emit public context of immediate sub-modules.
+	for _, sub := range request.SubModulesPublicContexts {
+		// We only expose the public context of immediate sub-modules.
+		if sub.Content == "" && sub.Name == "" {
+			continue
+		}
+
+		trimmedCtx := &payload.ModuleSelfContainedContext{
+			Name:          sub.Name,
+			PublicContext: sub.Content,
+		}
+		writeModule(&sb, trimmedCtx.Name, trimmedCtx)
+	}
+
+	return sb.String()
+}
+
+func markdownChangeNarrativeRequest(request *payload.ChangeNarrativeRequest) string {
+	var sb strings.Builder
+	if request.Instructions != "" {
+		sb.WriteString("# Instructions\n")
+		sb.WriteString(fmt.Sprintf("%s\n\n", request.Instructions))
+	}
+	for _, module := range request.ModuleContexts {
+		sb.WriteString(fmt.Sprintf("# Module: `%s`\n", module.Name))
+		sb.WriteString("## Internal Context\n")
+		sb.WriteString(fmt.Sprintf("%s\n\n", module.Content))
+	}
+	for _, d := range request.Diffs {
+		if d.Summarized {
+			sb.WriteString(fmt.Sprintf("# Diff summary: `%s`\n%s\n\n", d.Path, d.Diff))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("# Diff: `%s`\n```diff\n%s\n```\n\n", d.Path, strings.TrimRight(d.Diff, "\n")))
+	}
+	return sb.String()
+}
+
+func markdownExternalContextsRequest(request *payload.ExternalContextsRequest) string {
+	var sb strings.Builder
+
+	// Write each module with H1 headers
+	for _, module := range request.Modules {
+		if module.Name == "" {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("# Module: `%s`\n", module.Name))
+		if module.ParentName != "" {
+			sb.WriteString(fmt.Sprintf("Parent Module: `%s`\n\n", module.ParentName))
+		}
+		if module.InternalContext != "" {
+			sb.WriteString("## Internal Context\n")
+			sb.WriteString(fmt.Sprintf("%s\n\n", module.InternalContext))
+		}
+		if module.PublicContext != "" {
+			sb.WriteString("## Public Context\n")
+			sb.WriteString(fmt.Sprintf("%s\n\n", module.PublicContext))
+		}
+	}
+
+	return sb.String()
+}
+
+func writeModule(sb *strings.Builder, path string, context *payload.ModuleSelfContainedContext) {
+	if sb == nil {
+		return
+	}
+	if path == "" && (context == nil || (context.ExternalContext == "" && context.InternalContext == "" && context.PublicContext == "")) {
+		return
+	}
+	sb.WriteString(fmt.Sprintf("# Module: `%s`\n", path))
+	if context != nil {
+		if context.ExternalContext != "" {
+			sb.WriteString("## External Context\n")
+			sb.WriteString(fmt.Sprintf("%s\n", context.ExternalContext))
+		}
+		if context.InternalContext != "" {
+			sb.WriteString("## Internal Context\n")
+			sb.WriteString(fmt.Sprintf("%s\n", context.InternalContext))
+		}
+		if context.PublicContext != "" {
+			sb.WriteString("## Public Context\n")
+			sb.WriteString(fmt.Sprintf("%s\n", context.PublicContext))
+		}
+	}
+}
+
+func writeFile(sb *strings.Builder, file payload.FileContent) {
+	if sb == nil {
+		return
+	}
+	content := file.Content
+	lang := getLanguageFromFilename(file.Path)
+	sb.WriteString(fmt.Sprintf("### %s\n", file.Label()))
+	sb.WriteString(fmt.Sprintf("```%s\n", lang))
+	sb.WriteString(content)
+	// Ensure a trailing newline before closing the code block.
+	if !strings.HasSuffix(content, "\n") {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("```\n\n")
+}
+
+// getLanguageFromFilename returns a language identifier based on file extension.
+func getLanguageFromFilename(filename string) string {
+	if strings.HasSuffix(filename, ".go") {
+		return "go"
+	} else if strings.HasSuffix(filename, ".md") {
+		return "markdown"
+	} else if strings.HasSuffix(filename, ".json") {
+		return "json"
+	} else if strings.HasSuffix(filename, ".txt") {
+		return "text"
+	}
+	// Default: no language specified.
+	return ""
+}
@@ -0,0 +1,101 @@
+// Package render serializes the request payloads into the user messages
+// sent to every provider, in the format the model handles best: markdown
+// sections or XML-tagged context.
+package render
+
+import (
+	"fmt"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// Format is the layout of a serialized request.
+type Format string
+
+const (
+	// Markdown lays the request out in headings and fenced code blocks.
+	Markdown Format = "markdown"
+	// XML wraps every part of the request in a tag, e.g.
+	// <file path="main.go">...</file>, with escaped contents.
+	XML Format = "xml"
+)
+
+// summarizedNote introduces the modules whose files were left out of a
+// workspace change request.
+const summarizedNote = "The files of these modules were left out of the request, only their internal context is provided."
+
+// Or returns f, or preferred when f is empty.
+func (f Format) Or(preferred Format) Format {
+	if f == "" {
+		return preferred
+	}
+	return f
+}
+
+// WorkspaceChangeRequest serializes request in format f.
+func WorkspaceChangeRequest(f Format, request *payload.WorkspaceChangeRequest) (string, error) {
+	if request == nil {
+		return "", fmt.Errorf("WorkspaceChangeRequest must not be nil")
+	}
+	if request.TargetModule == "" {
+		return "", fmt.Errorf("TargetModule is required")
+	}
+	if request.TargetDirectory == "" {
+		return "", fmt.Errorf("TargetDirectory is required")
+	}
+	if f == XML {
+		return xmlWorkspaceChangeRequest(request), nil
+	}
+	return markdownWorkspaceChangeRequest(request), nil
+}
+
+// ModuleContextRequest serializes request in format f.
+func ModuleContextRequest(f Format, request *payload.ModuleContextRequest) (string, error) {
+	if request == nil {
+		return "", fmt.Errorf("ModuleContextRequest must not be nil")
+	}
+	if f == XML {
+		return xmlModuleContextRequest(request), nil
+	}
+	return markdownModuleContextRequest(request), nil
+}
+
+// ModuleContextsRequest serializes every module of request like
+// ModuleContextRequest, in format f, under a heading naming it.
+func ModuleContextsRequest(f Format, request *payload.ModuleContextsRequest) (string, error) {
+	if request == nil {
+		return "", fmt.Errorf("ModuleContextsRequest must not be nil")
+	}
+	if len(request.Modules) == 0 {
+		return "", fmt.Errorf("ModuleContextsRequest must hold at least one module")
+	}
+	if f == XML {
+		return xmlModuleContextsRequest(request), nil
+	}
+	return markdownModuleContextsRequest(request), nil
+}
+
+// ExternalContextsRequest serializes request in format f.
+func ExternalContextsRequest(f Format, request *payload.ExternalContextsRequest) (string, error) {
+	if request == nil {
+		return "", fmt.Errorf("ExternalContextsRequest must not be nil")
+	}
+	if f == XML {
+		return xmlExternalContextsRequest(request), nil
+	}
+	return markdownExternalContextsRequest(request), nil
+}
+
+// ChangeNarrativeRequest serializes request in format f.
+func ChangeNarrativeRequest(f Format, request *payload.ChangeNarrativeRequest) (string, error) {
+	if request == nil {
+		return "", fmt.Errorf("ChangeNarrativeRequest must not be nil")
+	}
+	if len(request.Diffs) == 0 {
+		return "", fmt.Errorf("ChangeNarrativeRequest must hold at least one diff")
+	}
+	if f == XML {
+		return xmlChangeNarrativeRequest(request), nil
+	}
+	return markdownChangeNarrativeRequest(request), nil
+}
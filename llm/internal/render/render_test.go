@@ -0,0 +1,219 @@
+package render
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// requests renders one request of every type, in format f.
+var requests = []struct {
+	name   string
+	render func(f Format) (string, error)
+}{
+	{"WorkspaceChangeRequest", func(f Format) (string, error) {
+		return WorkspaceChangeRequest(f, &payload.WorkspaceChangeRequest{
+			TargetModule:             "w/t",
+			TargetModuleContext:      "target context",
+			TargetDirectory:          "w/t",
+			TargetFiles:              []string{"w/t/main.go"},
+			ParentModuleContexts:     []payload.ModuleContext{{Name: "w/p", Content: "parent context"}},
+			SubModuleContexts:        []payload.ModuleContext{{Name: "w/t/s", Content: "sub context"}},
+			SummarizedModuleContexts: []payload.ModuleContext{{Name: "w/o", Content: "summary"}},
+			Files: []payload.FileContent{
+				{Path: "w/t/main.go", Content: "package main\n\nfunc less(a, b int) bool { return a < b && b > 0 }\n"},
+				{Path: "w/t/big.go", Content: "package t\n", Part: 1, Parts: 2, StartLine: 1, EndLine: 1},
+				{Path: "w/t/big.go", Content: "var s = \"<tag>\"", Part: 2, Parts: 2, StartLine: 2, EndLine: 2},
+			},
+		})
+	}},
+	{"ModuleContextRequest", func(f Format) (string, error) {
+		return ModuleContextRequest(f, &payload.ModuleContextRequest{
+			TargetModuleName:         "m",
+			TargetModuleFiles:        []payload.FileContent{{Path: "m/a.go", Content: "package m\n"}},
+			TargetModuleDirectories:  []string{"m", "m/internal"},
+			SubModulesPublicContexts: []payload.ModuleContext{{Name: "m/s", Content: "public"}},
+		})
+	}},
+	{"ModuleContextsRequest", func(f Format) (string, error) {
+		return ModuleContextsRequest(f, &payload.ModuleContextsRequest{
+			Modules: []payload.ModuleContextRequest{
+				{TargetModuleName: "m/sub", TargetModuleFiles: []payload.FileContent{{Path: "m/sub/a.go", Content: "package sub\n"}}},
+				{TargetModuleName: "m", SubModulesPublicContexts: []payload.ModuleContext{{Name: "m/sub", Content: "public"}}},
+			},
+		})
+	}},
+	{"ExternalContextsRequest", func(f Format) (string, error) {
+		return ExternalContextsRequest(f, &payload.ExternalContextsRequest{
+			Modules: []payload.ModuleInfoForExternalContext{
+				{Name: ".", InternalContext: "root internal"},
+				{Name: "m", ParentName: ".", InternalContext: "internal", PublicContext: "public"},
+			},
+		})
+	}},
+	{"ChangeNarrativeRequest", func(f Format) (string, error) {
+		return ChangeNarrativeRequest(f, &payload.ChangeNarrativeRequest{
+			Instructions:   "instructions",
+			ModuleContexts: []payload.ModuleContext{{Name: "m", Content: "internal"}},
+			Diffs: []payload.FileDiff{
+				{Path: "m/a.go", Diff: "--- a/m/a.go\n+++ b/m/a.go\n@@ -1 +1 @@\n-a & b\n+a && b\n"},
+				{Path: "m/b.go", Diff: "900 lines added", Summarized: true},
+			},
+		})
+	}},
+}
+
+// TestRender_Golden pins the user message of every request type, in both
+// formats.
+func TestRender_Golden(t *testing.T) {
+	for _, f := range []struct {
+		format Format
+		ext    string
+	}{{Markdown, ".md"}, {XML, ".xml"}} {
+		for _, req := range requests {
+			t.Run(req.name+f.ext, func(t *testing.T) {
+				got, err := req.render(f.format)
+				if err != nil {
+					t.Fatalf("render: %v", err)
+				}
+				golden := filepath.Join("testdata", req.name+f.ext)
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+						t.Fatalf("failed to update golden file: %v", err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("failed to read golden file: %v", err)
+				}
+				if got != string(want) {
+					t.Fatalf("output differs from %s (run with -update to accept):\n%s", golden, got)
+				}
+			})
+		}
+	}
+}
+
+func TestRender_XMLWellFormed(t *testing.T) {
+	for _, req := range requests {
+		got, err := req.render(XML)
+		if err != nil {
+			t.Fatalf("%s: %v", req.name, err)
+		}
+		if err := checkWellFormed(got); err != nil {
+			t.Fatalf("%s: %v\n%s", req.name, err, got)
+		}
+	}
+}
+
+// TestRender_XMLArbitraryContent renders files of random content, markup,
+// control characters and invalid UTF-8 included, and checks the output is
+// always well-formed and keeps the content intact when XML allows it.
+func TestRender_XMLArbitraryContent(t *testing.T) {
+	alphabet := []string{"a", " ", "\n", "\t", "\r", "<", ">", "&", "\"", "'", "]]>", "<!--", "-->", "</file>", "<![CDATA[", "&amp;", "\x00", "\x1b", "\xff", "\xc3", "é", "世", "\U0001F600", "￾"}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		var sb strings.Builder
+		for n := rng.Intn(40); n > 0; n-- {
+			sb.WriteString(alphabet[rng.Intn(len(alphabet))])
+		}
+		content := sb.String()
+		got, err := WorkspaceChangeRequest(XML, &payload.WorkspaceChangeRequest{
+			TargetModule:    "m" + content,
+			TargetDirectory: "d",
+			Files:           []payload.FileContent{{Path: content, Content: content}},
+		})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if err := checkWellFormed(got); err != nil {
+			t.Fatalf("content %q: %v\n%s", content, err, got)
+		}
+
+		var decoded struct {
+			Files []struct {
+				Path    string `xml:"path,attr"`
+				Content string `xml:",chardata"`
+			} `xml:"files>file"`
+		}
+		if err := xml.Unmarshal([]byte(got), &decoded); err != nil {
+			t.Fatalf("content %q: %v", content, err)
+		}
+		if len(decoded.Files) != 1 {
+			t.Fatalf("content %q: expected one file, got %+v", content, decoded.Files)
+		}
+		if !utf8.ValidString(content) || strings.IndexFunc(content, func(r rune) bool { return !allowed(r) }) >= 0 {
+			continue
+		}
+		want := "\n" + content
+		if !strings.HasSuffix(content, "\n") {
+			want += "\n"
+		}
+		if decoded.Files[0].Path != content || decoded.Files[0].Content != want {
+			t.Fatalf("content %q decoded as %+v", content, decoded.Files[0])
+		}
+	}
+}
+
+// checkWellFormed returns an error when doc is not a single well-formed
+// XML element.
+func checkWellFormed(doc string) error {
+	d := xml.NewDecoder(strings.NewReader(doc))
+	depth, roots := 0, 0
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if roots != 1 {
+		return errors.New("expected a single root element")
+	}
+	return nil
+}
+
+func TestRender_Errors(t *testing.T) {
+	for _, f := range []Format{Markdown, XML} {
+		if _, err := WorkspaceChangeRequest(f, &payload.WorkspaceChangeRequest{TargetDirectory: "d"}); err == nil {
+			t.Fatalf("%s: expected an error without target module", f)
+		}
+		if _, err := ModuleContextsRequest(f, &payload.ModuleContextsRequest{}); err == nil {
+			t.Fatalf("%s: expected an error without modules", f)
+		}
+		if _, err := ChangeNarrativeRequest(f, &payload.ChangeNarrativeRequest{}); err == nil {
+			t.Fatalf("%s: expected an error without diffs", f)
+		}
+	}
+}
+
+func TestFormat_Or(t *testing.T) {
+	if got := Format("").Or(XML); got != XML {
+		t.Fatalf("expected the preferred format, got %q", got)
+	}
+	if got := Markdown.Or(XML); got != Markdown {
+		t.Fatalf("expected the override, got %q", got)
+	}
+}
@@ -0,0 +1,205 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func xmlWorkspaceChangeRequest(request *payload.WorkspaceChangeRequest) string {
+	var sb strings.Builder
+	sb.WriteString("<workspace_change_request>\n")
+	fmt.Fprintf(&sb, "<target_module name=\"%s\">\n", escape(request.TargetModule))
+	writeElement(&sb, "context", request.TargetModuleContext)
+	sb.WriteString("</target_module>\n")
+	writeElement(&sb, "target_directory", request.TargetDirectory)
+
+	if len(request.TargetFiles) > 0 {
+		sb.WriteString("<target_files>\n")
+		sb.WriteString("The user invoked the command on these files, focus the changes on them.\n")
+		for _, f := range request.TargetFiles {
+			fmt.Fprintf(&sb, "<file path=\"%s\"/>\n", escape(f))
+		}
+		sb.WriteString("</target_files>\n")
+	}
+
+	writeXMLModules(&sb, "parent_module_contexts", "", "public_context", request.ParentModuleContexts)
+	writeXMLModules(&sb, "sub_module_contexts", "", "public_context", request.SubModuleContexts)
+	writeXMLModules(&sb, "summarized_module_contexts", summarizedNote, "internal_context", request.SummarizedModuleContexts)
+
+	if len(request.Files) > 0 {
+		sb.WriteString("<files>\n")
+		for _, f := range request.Files {
+			writeXMLFile(&sb, f)
+		}
+		sb.WriteString("</files>\n")
+	}
+	sb.WriteString("</workspace_change_request>\n")
+	return sb.String()
+}
+
+func xmlModuleContextsRequest(request *payload.ModuleContextsRequest) string {
+	var sb strings.Builder
+	sb.WriteString("<module_contexts_request>\n")
+	for i := range request.Modules {
+		sb.WriteString(xmlModuleContextRequest(&request.Modules[i]))
+	}
+	sb.WriteString("</module_contexts_request>\n")
+	return sb.String()
+}
+
+func xmlModuleContextRequest(request *payload.ModuleContextRequest) string {
+	var sb strings.Builder
+	name := escape(request.TargetModuleName)
+	fmt.Fprintf(&sb, "<module_context_request module=\"%s\">\n", name)
+
+	// Only spend these tokens if we need to teach the LLM that a directory != module.
+	if len(request.TargetModuleDirectories) > 1 {
+		sb.WriteString("<directories>\n")
+		fmt.Fprintf(&sb, "These ARE NOT MODULES, they are directories within the module `%s`. When summarizing their file contents, include them in the summary of `%s`, do not make up modules for them.\n", name, name)
+		for _, dir := range request.TargetModuleDirectories {
+			writeElement(&sb, "directory", dir)
+		}
+		sb.WriteString("</directories>\n")
+	}
+
+	sb.WriteString("<files>\n")
+	for _, file := range request.TargetModuleFiles {
+		writeXMLFile(&sb, file)
+	}
+	sb.WriteString("</files>\n")
+
+	var subs []payload.ModuleContext
+	for _, sub := range request.SubModulesPublicContexts {
+		if sub.Content == "" && sub.Name == "" {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	writeXMLModules(&sb, "sub_module_contexts", "", "public_context", subs)
+
+	sb.WriteString("</module_context_request>\n")
+	return sb.String()
+}
+
+func xmlChangeNarrativeRequest(request *payload.ChangeNarrativeRequest) string {
+	var sb strings.Builder
+	sb.WriteString("<change_narrative_request>\n")
+	if request.Instructions != "" {
+		writeElement(&sb, "instructions", request.Instructions)
+	}
+	writeXMLModules(&sb, "module_contexts", "", "internal_context", request.ModuleContexts)
+	for _, d := range request.Diffs {
+		tag := "diff"
+		if d.Summarized {
+			tag = "diff_summary"
+		}
+		fmt.Fprintf(&sb, "<%s path=\"%s\">\n%s\n</%s>\n", tag, escape(d.Path), escape(strings.TrimRight(d.Diff, "\n")), tag)
+	}
+	sb.WriteString("</change_narrative_request>\n")
+	return sb.String()
+}
+
+func xmlExternalContextsRequest(request *payload.ExternalContextsRequest) string {
+	var sb strings.Builder
+	sb.WriteString("<external_contexts_request>\n")
+	for _, module := range request.Modules {
+		if module.Name == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "<module name=\"%s\"", escape(module.Name))
+		if module.ParentName != "" {
+			fmt.Fprintf(&sb, " parent=\"%s\"", escape(module.ParentName))
+		}
+		sb.WriteString(">\n")
+		if module.InternalContext != "" {
+			writeElement(&sb, "internal_context", module.InternalContext)
+		}
+		if module.PublicContext != "" {
+			writeElement(&sb, "public_context", module.PublicContext)
+		}
+		sb.WriteString("</module>\n")
+	}
+	sb.WriteString("</external_contexts_request>\n")
+	return sb.String()
+}
+
+// writeXMLModules writes modules under the tag group, preceded by note, with
+// the content of every module under the tag context. Nothing is written
+// when modules is empty.
+func writeXMLModules(sb *strings.Builder, group, note, context string, modules []payload.ModuleContext) {
+	if len(modules) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "<%s>\n", group)
+	if note != "" {
+		sb.WriteString(note + "\n")
+	}
+	for _, mc := range modules {
+		fmt.Fprintf(sb, "<module name=\"%s\">\n", escape(mc.Name))
+		if mc.Content != "" {
+			writeElement(sb, context, mc.Content)
+		}
+		sb.WriteString("</module>\n")
+	}
+	fmt.Fprintf(sb, "</%s>\n", group)
+}
+
+// writeXMLFile writes file in a file tag, with the part it is as
+// attributes when it is one part of a large file.
+func writeXMLFile(sb *strings.Builder, file payload.FileContent) {
+	fmt.Fprintf(sb, "<file path=\"%s\"", escape(file.Path))
+	if file.Parts > 0 {
+		fmt.Fprintf(sb, " part=\"%d\" parts=\"%d\" lines=\"%d-%d\"", file.Part, file.Parts, file.StartLine, file.EndLine)
+	}
+	sb.WriteString(">\n")
+	sb.WriteString(escape(file.Content))
+	if !strings.HasSuffix(file.Content, "\n") {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</file>\n")
+}
+
+// writeElement writes text, escaped, in the tag name.
+func writeElement(sb *strings.Builder, name, text string) {
+	fmt.Fprintf(sb, "<%s>\n%s\n</%s>\n", name, escape(strings.TrimRight(text, "\n")), name)
+}
+
+// escape escapes s for XML character data and attribute values. Unlike
+// xml.EscapeText, it keeps line feeds and tabs as they are, so code stays
+// readable. Characters XML does not allow, such as most control characters
+// and invalid UTF-8, are replaced by U+FFFD.
+func escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRuneInString(s[i:])
+		i += width
+		switch {
+		case r == '&':
+			sb.WriteString("&amp;")
+		case r == '<':
+			sb.WriteString("&lt;")
+		case r == '>':
+			sb.WriteString("&gt;")
+		case r == '"':
+			sb.WriteString("&quot;")
+		case r == '\r':
+			sb.WriteString("&#xD;")
+		case r == utf8.RuneError && width == 1, !allowed(r):
+			sb.WriteRune(utf8.RuneError)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// allowed reports whether r is a character allowed in XML documents.
+func allowed(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
@@ -0,0 +1,34 @@
+// Package repair lets providers ask the model, once, to fix an answer that
+// is not valid JSON, sending the invalid answer back in the conversation.
+package repair
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrFailed is matched, with errors.Is, by the error returned when the
+// answer to the repair request cannot be decoded either. The provider
+// already spent its retry, so callers should not ask again.
+var ErrFailed = errors.New("the model's answer was still invalid after a repair request")
+
+// InvalidJSON tells whether err reports an answer that could not be
+// decoded: malformed JSON, or JSON values of the wrong type.
+func InvalidJSON(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// Prompt returns the follow-up user message sent after the invalid answer,
+// which failed to decode with err.
+func Prompt(err error) string {
+	return fmt.Sprintf("Your previous output was invalid JSON (%v). Return only valid JSON matching the schema, with no other text.", err)
+}
+
+// Failed returns the error decoding the repaired answer, matching ErrFailed
+// as well as err.
+func Failed(err error) error {
+	return fmt.Errorf("%w: %w", ErrFailed, err)
+}
@@ -0,0 +1,30 @@
+package repair
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestInvalidJSON(t *testing.T) {
+	var v struct{ N int }
+	syntaxErr := json.Unmarshal([]byte("not json"), &v)
+	typeErr := json.Unmarshal([]byte(`{"N": "one"}`), &v)
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{syntaxErr, true},
+		{fmt.Errorf("decoding choice 0: %w", typeErr), true},
+		{errors.New("HTTP 500"), false},
+		{nil, false},
+	} {
+		if got := InvalidJSON(tc.err); got != tc.want {
+			t.Errorf("InvalidJSON(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+	if err := Failed(syntaxErr); !errors.Is(err, ErrFailed) || !InvalidJSON(err) {
+		t.Fatalf("expected Failed to match ErrFailed and keep the cause, got %v", err)
+	}
+}
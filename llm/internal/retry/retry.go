@@ -0,0 +1,166 @@
+// Package retry runs an operation until it succeeds, waiting with
+// exponential backoff and jitter between attempts, or for the delay
+// requested by the server when there is one.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock abstracts time so the backoff schedule can be tested without
+// sleeping.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+// Default backoff bounds, used when the Policy leaves them unset.
+const (
+	DefaultBaseDelay = time.Second
+	DefaultMaxDelay  = time.Minute
+)
+
+// Policy configures Do.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below 1 mean a single attempt.
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt. It doubles with
+	// every further attempt, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Clock defaults to SystemClock.
+	Clock Clock
+	// Rand returns a number in [0,1) used for jitter. It defaults to
+	// math/rand.
+	Rand func() float64
+	// Context, when set, stops the retries once it is done, without
+	// waiting for the end of the current delay.
+	Context context.Context
+}
+
+// TemporaryError marks an error as worth retrying.
+type TemporaryError struct {
+	Err error
+	// After is the delay requested by the server before the next attempt,
+	// or zero to use the backoff schedule.
+	After time.Duration
+}
+
+func (e *TemporaryError) Error() string { return e.Err.Error() }
+
+func (e *TemporaryError) Unwrap() error { return e.Err }
+
+// Temporary wraps err so Do retries it, after the given delay when positive.
+func Temporary(err error, after time.Duration) error {
+	return &TemporaryError{Err: err, After: after}
+}
+
+// Do calls fn until it returns nil, a permanent error (anything not wrapped
+// by Temporary) or the attempts are exhausted. The error of the last attempt
+// is returned unwrapped from its TemporaryError. When p.Context is done
+// between two attempts, its error is returned instead.
+func (p Policy) Do(fn func() error) error {
+	clock := p.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var tmp *TemporaryError
+		if err == nil || !errors.As(err, &tmp) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			return tmp.Err
+		}
+		delay := tmp.After
+		if delay <= 0 {
+			delay = p.Backoff(attempt)
+		}
+		if err := p.wait(clock, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps for d on clock, returning the error of p.Context as soon as
+// it is done.
+func (p Policy) wait(clock Clock, d time.Duration) error {
+	if p.Context == nil || p.Context.Done() == nil {
+		clock.Sleep(d)
+		return nil
+	}
+	slept := make(chan struct{})
+	go func() {
+		clock.Sleep(d)
+		close(slept)
+	}()
+	select {
+	case <-slept:
+		return p.Context.Err()
+	case <-p.Context.Done():
+		return p.Context.Err()
+	}
+}
+
+// Backoff returns the delay after the given failed attempt (starting at 1):
+// an exponentially growing delay capped at MaxDelay, of which the upper half
+// is randomized so concurrent clients do not retry in lockstep.
+func (p Policy) Backoff(attempt int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	d := base
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	random := p.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	return d/2 + time.Duration(random()*float64(d/2))
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, either a number
+// of seconds or an HTTP date relative to now.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
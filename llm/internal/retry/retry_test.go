@@ -0,0 +1,140 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock records the requested sleeps and advances its time accordingly.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func TestDo_BackoffSchedule(t *testing.T) {
+	clock := &fakeClock{}
+	p := Policy{
+		MaxAttempts: 6,
+		BaseDelay:   time.Second,
+		MaxDelay:    8 * time.Second,
+		Clock:       clock,
+		Rand:        func() float64 { return 0.5 },
+	}
+	calls := 0
+	err := p.Do(func() error {
+		calls++
+		if calls < 6 {
+			return Temporary(errors.New("busy"), 0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Half of every delay is fixed, the other half is jitter (here 50%).
+	want := []time.Duration{
+		750 * time.Millisecond,
+		1500 * time.Millisecond,
+		3 * time.Second,
+		6 * time.Second,
+		6 * time.Second, // capped at MaxDelay
+	}
+	if !reflect.DeepEqual(clock.sleeps, want) {
+		t.Fatalf("unexpected schedule %v, want %v", clock.sleeps, want)
+	}
+}
+
+func TestDo_JitterBounds(t *testing.T) {
+	for _, r := range []float64{0, 0.999} {
+		p := Policy{BaseDelay: 4 * time.Second, Rand: func() float64 { return r }}
+		if d := p.Backoff(1); d < 2*time.Second || d >= 4*time.Second {
+			t.Fatalf("Backoff(1) with rand %v = %s, want within [2s,4s)", r, d)
+		}
+	}
+}
+
+func TestDo_ServerDelay(t *testing.T) {
+	clock := &fakeClock{}
+	p := Policy{MaxAttempts: 2, Clock: clock}
+	calls := 0
+	_ = p.Do(func() error {
+		calls++
+		if calls == 1 {
+			return Temporary(errors.New("rate limited"), 30*time.Second)
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(clock.sleeps, []time.Duration{30 * time.Second}) {
+		t.Fatalf("expected the server delay to be honored, got %v", clock.sleeps)
+	}
+}
+
+func TestDo_LastError(t *testing.T) {
+	clock := &fakeClock{}
+	p := Policy{MaxAttempts: 3, Clock: clock}
+	calls := 0
+	var last error
+	err := p.Do(func() error {
+		calls++
+		last = errors.New("attempt failed")
+		return Temporary(last, 0)
+	})
+	if err != last || calls != 3 {
+		t.Fatalf("got %v after %d calls, want the last error after 3", err, calls)
+	}
+
+	// Permanent errors are not retried.
+	permanent := errors.New("bad request")
+	calls = 0
+	if err := p.Do(func() error { calls++; return permanent }); err != permanent || calls != 1 {
+		t.Fatalf("got %v after %d calls, want the permanent error after 1", err, calls)
+	}
+}
+
+// blockingClock never wakes up from Sleep.
+type blockingClock struct{}
+
+func (blockingClock) Now() time.Time        { return time.Time{} }
+func (blockingClock) Sleep(d time.Duration) { select {} }
+
+func TestDo_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{MaxAttempts: 3, Clock: blockingClock{}, Context: ctx}
+	calls := 0
+	err := p.Do(func() error {
+		calls++
+		cancel()
+		return Temporary(errors.New("busy"), time.Hour)
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("expected the retries to stop on cancellation, got %v after %d call(s)", err, calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2025 00:00:30 GMT": 30 * time.Second,
+	}
+	for in, want := range cases {
+		if got, ok := ParseRetryAfter(in, now); !ok || got != want {
+			t.Errorf("ParseRetryAfter(%q) = %s, %v; want %s", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "soon", "-1"} {
+		if _, ok := ParseRetryAfter(in, now); ok {
+			t.Errorf("ParseRetryAfter(%q) should fail", in)
+		}
+	}
+}
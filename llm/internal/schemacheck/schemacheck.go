@@ -0,0 +1,171 @@
+// Package schemacheck verifies the JSON schemas embedded by the providers,
+// so a malformed edit fails loudly instead of silently degrading the
+// structured output of the models.
+package schemacheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// Dialect describes the subset of JSON Schema accepted by a provider.
+type Dialect struct {
+	// Keywords lists the keywords a schema may use.
+	Keywords []string
+	// Strict requires every object to list all its properties as required
+	// and to set additionalProperties to false, as OpenAI's strict
+	// structured outputs do.
+	Strict bool
+}
+
+// types lists the values accepted for the type keyword.
+var types = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Check parses data as a JSON schema and verifies its structural
+// invariants: every schema has a known type, objects have properties and
+// only require existing ones, arrays have items, and only the keywords of
+// d are used.
+func Check(data []byte, d Dialect) error {
+	var s map[string]any
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	allowed := make(map[string]bool, len(d.Keywords))
+	for _, k := range d.Keywords {
+		allowed[k] = true
+	}
+	return check(s, "#", allowed, d.Strict)
+}
+
+func check(s map[string]any, at string, allowed map[string]bool, strict bool) error {
+	var keys []string
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !allowed[k] {
+			return fmt.Errorf("%s: unsupported keyword %q", at, k)
+		}
+	}
+
+	typ, _ := s["type"].(string)
+	if !types[typ] {
+		return fmt.Errorf("%s: missing or unknown type %v", at, s["type"])
+	}
+
+	switch typ {
+	case "object":
+		props, ok := s["properties"].(map[string]any)
+		if !ok || len(props) == 0 {
+			return fmt.Errorf("%s: object without properties", at)
+		}
+		required, err := stringList(s["required"], at+"/required")
+		if err != nil {
+			return err
+		}
+		for _, r := range required {
+			if _, ok := props[r]; !ok {
+				return fmt.Errorf("%s: required property %q is not defined", at, r)
+			}
+		}
+		if strict {
+			if len(required) != len(props) {
+				return fmt.Errorf("%s: strict schemas must require every property", at)
+			}
+			if ap, ok := s["additionalProperties"].(bool); !ok || ap {
+				return fmt.Errorf("%s: strict schemas must set additionalProperties to false", at)
+			}
+		}
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s/properties/%s: not a schema", at, name)
+			}
+			if err := check(prop, at+"/properties/"+name, allowed, strict); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := s["items"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: array without items", at)
+		}
+		return check(items, at+"/items", allowed, strict)
+	}
+	return nil
+}
+
+// stringList converts the value of a keyword holding a list of strings.
+func stringList(v any, at string) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a list", at)
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: %v is not a string", at, item)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Keywords returns the keywords shared by every dialect, followed by extra.
+func Keywords(extra ...string) []string {
+	return append([]string{"type", "description", "properties", "items", "required", "enum"}, extra...)
+}
+
+// Load reads name from fsys, checks the schema it holds and decodes the
+// whole document into a T. The schema is the document itself, or its
+// member field when member is not empty.
+func Load[T any](fsys fs.FS, name, member string, d Dialect) (T, error) {
+	var out T
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return out, err
+	}
+	schema := data
+	if member != "" {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return out, fmt.Errorf("%s: invalid JSON: %w", name, err)
+		}
+		if schema = doc[member]; schema == nil {
+			return out, fmt.Errorf("%s: missing %q member", name, member)
+		}
+	}
+	if err := Check(schema, d); err != nil {
+		return out, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// MustLoad is like Load but panics, naming the file, when the schema is
+// invalid. Embedded schemas are part of the binary, so an invalid one is a
+// programming error.
+func MustLoad[T any](fsys fs.FS, name, member string, d Dialect) T {
+	out, err := Load[T](fsys, name, member, d)
+	if err != nil {
+		panic("invalid embedded schema " + err.Error())
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package schemacheck
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheck(t *testing.T) {
+	loose := Dialect{Keywords: Keywords()}
+	strict := Dialect{Keywords: Keywords("additionalProperties"), Strict: true}
+
+	cases := []struct {
+		name    string
+		schema  string
+		dialect Dialect
+		wantErr string
+	}{
+		{"valid", `{"type":"object","properties":{"a":{"type":"array","items":{"type":"string"}}},"required":["a"]}`, loose, ""},
+		{"valid strict", `{"type":"object","properties":{"a":{"type":"string"}},"required":["a"],"additionalProperties":false}`, strict, ""},
+		{"malformed", `{"type":"object",`, loose, "invalid JSON"},
+		{"missing type", `{"type":"object","properties":{"a":{"description":"x"}}}`, loose, "#/properties/a: missing or unknown type"},
+		{"unknown type", `{"type":"map"}`, loose, "missing or unknown type"},
+		{"no properties", `{"type":"object"}`, loose, "object without properties"},
+		{"undefined required", `{"type":"object","properties":{"a":{"type":"string"}},"required":["b"]}`, loose, `required property "b" is not defined`},
+		{"no items", `{"type":"array"}`, loose, "array without items"},
+		{"unsupported keyword", `{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false}`, loose, `unsupported keyword "additionalProperties"`},
+		{"strict optional property", `{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false}`, strict, "must require every property"},
+		{"strict additional properties", `{"type":"object","properties":{"a":{"type":"string"}},"required":["a"]}`, strict, "additionalProperties to false"},
+	}
+	for _, c := range cases {
+		err := Check([]byte(c.schema), c.dialect)
+		if c.wantErr == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Fatalf("%s: error = %v, want one containing %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestMustLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ok.json":  {Data: []byte(`{"name":"n","schema":{"type":"object","properties":{"a":{"type":"string"}}}}`)},
+		"bad.json": {Data: []byte(`{"name":"n","schema":{"type":"object"}}`)},
+	}
+	d := Dialect{Keywords: Keywords()}
+
+	got := MustLoad[struct{ Name string }](fsys, "ok.json", "schema", d)
+	if got.Name != "n" {
+		t.Fatalf("unexpected document %+v", got)
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "bad.json") {
+			t.Fatalf("expected a panic naming the file, got %v", r)
+		}
+	}()
+	MustLoad[struct{ Name string }](fsys, "bad.json", "schema", d)
+}
@@ -0,0 +1,58 @@
+// Package schemas embeds the JSON schemas of the structured responses
+// expected from the Gemini, Anthropic and Ollama providers. Every provider
+// loads them into its own Go representation and checks them against its
+// own schemacheck.Dialect.
+package schemas
+
+import (
+	"embed"
+	"fmt"
+
+	"github.com/vybdev/vyb/llm/internal/schemacheck"
+)
+
+//go:embed *.json
+var files embed.FS
+
+// Names of the embedded schemas.
+const (
+	WorkspaceChangeProposal = "workspace_change_proposal_schema.json"
+	ModuleContext           = "module_selfcontained_context_schema.json"
+	ModuleExternalContext   = "module_external_context_schema.json"
+	ModuleContexts          = "module_contexts_schema.json"
+	ChangeNarrative         = "change_narrative_schema.json"
+)
+
+// JSONSchema is the Go form of the embedded schemas, for the providers
+// accepting them as they are.
+type JSONSchema struct {
+	Description string                 `json:"description,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+}
+
+// Names lists every embedded schema.
+var Names = []string{WorkspaceChangeProposal, ModuleContext, ModuleExternalContext, ModuleContexts, ChangeNarrative}
+
+// MustLoad parses the embedded schema name into T, panicking with the file
+// name when it does not pass the checks of d.
+func MustLoad[T any](name string, d schemacheck.Dialect) T {
+	return schemacheck.MustLoad[T](files, name, "", d)
+}
+
+// Check verifies every embedded schema against d, returning the first
+// error along with the name of its file.
+func Check(d schemacheck.Dialect) error {
+	for _, name := range Names {
+		data, err := files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := schemacheck.Check(data, d); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package schemas
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemacheck"
+)
+
+// TestNames ensures every embedded file is listed in Names, so Check covers
+// schemas added later.
+func TestNames(t *testing.T) {
+	embedded, err := fs.Glob(files, "*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(embedded)
+	names := slices.Sorted(slices.Values(Names))
+	if !slices.Equal(embedded, names) {
+		t.Fatalf("Names = %v, want the embedded files %v", names, embedded)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(schemacheck.Dialect{Keywords: schemacheck.Keywords()}); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	// Strict mode requires additionalProperties, which no schema sets.
+	if err := Check(schemacheck.Dialect{Keywords: schemacheck.Keywords(), Strict: true}); err == nil {
+		t.Fatal("expected the schemas to fail the checks of a strict dialect")
+	}
+}
@@ -0,0 +1,94 @@
+// Package models resolves the generic (family, size) pair of a command to
+// the concrete model identifier of each LLM provider, and holds the prices
+// the cost of the calls is estimated from.
+package models
+
+import (
+	"fmt"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// Registry maps a provider name to the model used for every family and
+// size, keyed by Key.
+type Registry map[string]map[string]string
+
+// Key returns the registry key of fam and sz, e.g. "reasoning-large".
+func Key(fam config.ModelFamily, sz config.ModelSize) string {
+	return string(fam) + "-" + string(sz)
+}
+
+// defaults holds the built-in models. Providers without distinct families
+// map both families to the same models.
+var defaults = Registry{
+	"openai": {
+		Key(config.ModelFamilyGPT, config.ModelSizeLarge):       "GPT-4.1",
+		Key(config.ModelFamilyGPT, config.ModelSizeSmall):       "GPT-4.1-mini",
+		Key(config.ModelFamilyReasoning, config.ModelSizeLarge): "o3",
+		Key(config.ModelFamilyReasoning, config.ModelSizeSmall): "o4-mini",
+	},
+	// The reasoning family of Gemini maps to the stable 2.5 models, which
+	// think before answering by default.
+	"gemini": {
+		Key(config.ModelFamilyGPT, config.ModelSizeLarge):       "gemini-2.5-pro-preview-06-05",
+		Key(config.ModelFamilyGPT, config.ModelSizeSmall):       "gemini-2.5-flash-preview-05-20",
+		Key(config.ModelFamilyReasoning, config.ModelSizeLarge): "gemini-2.5-pro",
+		Key(config.ModelFamilyReasoning, config.ModelSizeSmall): "gemini-2.5-flash",
+	},
+	"anthropic": bySize("claude-3-5-haiku-latest", "claude-3-5-sonnet-latest"),
+	"ollama":    bySize(DefaultOllamaSmall, DefaultOllamaLarge),
+}
+
+// Default model tags of the ollama provider, pulled from the Ollama
+// library.
+const (
+	DefaultOllamaSmall = "qwen2.5-coder:7b"
+	DefaultOllamaLarge = "qwen2.5-coder:32b"
+)
+
+// bySize maps every family to the small and large models.
+func bySize(small, large string) map[string]string {
+	return map[string]string{
+		Key(config.ModelFamilyGPT, config.ModelSizeSmall):       small,
+		Key(config.ModelFamilyGPT, config.ModelSizeLarge):       large,
+		Key(config.ModelFamilyReasoning, config.ModelSizeSmall): small,
+		Key(config.ModelFamilyReasoning, config.ModelSizeLarge): large,
+	}
+}
+
+// New returns the built-in registry with overrides applied on top of it.
+// Overrides are keyed like the registry; entries missing from overrides
+// keep their built-in model.
+func New(overrides Registry) Registry {
+	r := make(Registry, len(defaults))
+	for provider, models := range defaults {
+		r[provider] = make(map[string]string, len(models))
+		for k, m := range models {
+			r[provider][k] = m
+		}
+	}
+	for provider, models := range overrides {
+		if r[provider] == nil {
+			r[provider] = make(map[string]string, len(models))
+		}
+		for k, m := range models {
+			if m != "" {
+				r[provider][k] = m
+			}
+		}
+	}
+	return r
+}
+
+// Resolve returns the built-in model of provider for fam and sz.
+func Resolve(provider string, fam config.ModelFamily, sz config.ModelSize) (string, error) {
+	return New(nil).Resolve(provider, fam, sz)
+}
+
+// Resolve returns the model of provider for fam and sz.
+func (r Registry) Resolve(provider string, fam config.ModelFamily, sz config.ModelSize) (string, error) {
+	if m, ok := r[provider][Key(fam, sz)]; ok {
+		return m, nil
+	}
+	return "", fmt.Errorf("%s: unsupported model mapping for family=%s size=%s", provider, fam, sz)
+}
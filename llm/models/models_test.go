@@ -0,0 +1,60 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+)
+
+func TestNew(t *testing.T) {
+	r := New(Registry{
+		"openai": {"reasoning-large": "o3-pro", "gpt-small": ""},
+		"acme":   {"gpt-large": "acme-1"},
+	})
+	for _, c := range []struct {
+		provider string
+		fam      config.ModelFamily
+		size     config.ModelSize
+		want     string
+	}{
+		{"openai", config.ModelFamilyReasoning, config.ModelSizeLarge, "o3-pro"},
+		{"openai", config.ModelFamilyGPT, config.ModelSizeSmall, "GPT-4.1-mini"},
+		{"acme", config.ModelFamilyGPT, config.ModelSizeLarge, "acme-1"},
+	} {
+		got, err := r.Resolve(c.provider, c.fam, c.size)
+		if err != nil || got != c.want {
+			t.Fatalf("Resolve(%s,%s,%s) = %q, %v, want %q", c.provider, c.fam, c.size, got, err, c.want)
+		}
+	}
+
+	// Overrides never leak into the built-in registry.
+	if got, _ := Resolve("openai", config.ModelFamilyReasoning, config.ModelSizeLarge); got != "o3" {
+		t.Fatalf("built-in model changed to %q", got)
+	}
+	if _, err := r.Resolve("acme", config.ModelFamilyGPT, config.ModelSizeSmall); err == nil {
+		t.Fatal("expected an error for a missing mapping")
+	}
+}
+
+func TestPrices_Cost(t *testing.T) {
+	p := NewPrices(Prices{"O3-Pro": {Prompt: 20, Completion: 80}, "o3": {Prompt: 1, Completion: 2}})
+	for _, c := range []struct {
+		model string
+		want  float64
+	}{
+		{"GPT-4.1", 2*1.5 + 8*0.5},
+		{"o3-pro", 20*1.5 + 80*0.5},
+		{"o3", 1*1.5 + 2*0.5},
+	} {
+		got, ok := p.Cost(c.model, 1_500_000, 500_000)
+		if !ok || got != c.want {
+			t.Fatalf("Cost(%s) = %v, %v, want %v", c.model, got, ok, c.want)
+		}
+	}
+	if _, ok := p.Cost("acme-1", 1, 1); ok {
+		t.Fatal("expected no price for an unknown model")
+	}
+	if got, _ := NewPrices(nil).Cost("o3", 1_000_000, 0); got != 2 {
+		t.Fatalf("built-in price changed to %v", got)
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import "strings"
+
+// Price is the price of a model, in US dollars per million tokens.
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// Prices maps a lower-cased model identifier to its price.
+type Prices map[string]Price
+
+// defaultPrices holds the list prices of the built-in models of the hosted
+// providers. Local Ollama models are free and have no usage reported.
+var defaultPrices = Prices{
+	"gpt-4.1":                        {Prompt: 2, Completion: 8},
+	"gpt-4.1-mini":                   {Prompt: 0.4, Completion: 1.6},
+	"o3":                             {Prompt: 2, Completion: 8},
+	"o4-mini":                        {Prompt: 1.1, Completion: 4.4},
+	"gemini-2.5-flash-preview-05-20": {Prompt: 0.15, Completion: 0.6},
+	"gemini-2.5-pro-preview-06-05":   {Prompt: 1.25, Completion: 10},
+	"gemini-2.5-flash":               {Prompt: 0.3, Completion: 2.5},
+	"gemini-2.5-pro":                 {Prompt: 1.25, Completion: 10},
+	"claude-3-5-haiku-latest":        {Prompt: 0.8, Completion: 4},
+	"claude-3-5-sonnet-latest":       {Prompt: 3, Completion: 15},
+}
+
+// NewPrices returns the built-in prices with overrides applied on top of
+// them. Overrides are keyed by model identifier, in any case.
+func NewPrices(overrides Prices) Prices {
+	p := make(Prices, len(defaultPrices)+len(overrides))
+	for model, price := range defaultPrices {
+		p[model] = price
+	}
+	for model, price := range overrides {
+		p[strings.ToLower(model)] = price
+	}
+	return p
+}
+
+// Cost returns the cost, in US dollars, of prompt and completion tokens of
+// model, and false when the price of model is unknown.
+func (p Prices) Cost(model string, prompt, completion int) (float64, bool) {
+	price, ok := p[strings.ToLower(model)]
+	if !ok {
+		return 0, false
+	}
+	return (float64(prompt)*price.Prompt + float64(completion)*price.Completion) / 1e6, true
+}
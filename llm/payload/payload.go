@@ -1,12 +1,38 @@
 // Package payload contains data structures for LLM requests and responses.
 package payload
 
+import "fmt"
+
+// SchemaVersion is the version of the JSON documents vyb emits for external
+// tools: these payloads and the documents embedding them, which record it
+// in their schema_version field. It is bumped when a field is renamed or
+// removed, or changes meaning; adding an optional field does not bump it.
+// See the "JSON schema versions" section of the README for the changelog.
+const SchemaVersion = "1"
+
 // --- Request Payloads ---
 
-// FileContent holds the path and content of a file.
+// FileContent holds the path and content of a file, or of one part of a
+// large file split across the request.
 type FileContent struct {
 	Path    string `json:"path"`
 	Content string `json:"content"`
+	// Part and Parts number the part among the parts of the file, starting
+	// at 1, and StartLine and EndLine are the lines it holds. They are zero
+	// when Content is the whole file.
+	Part      int `json:"part,omitempty"`
+	Parts     int `json:"parts,omitempty"`
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
+}
+
+// Label returns the heading of f in a request: its path, followed by the
+// part it is, e.g. "file.go (part 1/3, lines 1-500)".
+func (f FileContent) Label() string {
+	if f.Parts == 0 {
+		return f.Path
+	}
+	return fmt.Sprintf("%s (part %d/%d, lines %d-%d)", f.Path, f.Part, f.Parts, f.StartLine, f.EndLine)
 }
 
 // WorkspaceChangeRequest contains all the necessary context and files for
@@ -25,6 +51,9 @@ type WorkspaceChangeRequest struct {
 	// TargetDirectory is the root directory from which the change request
 	// should be applied (no change is expected outside of this directory or its subdirectories)
 	TargetDirectory string `json:"target_directory"`
+	// TargetFiles lists the files the user invoked the command on, if any,
+	// so the LLM knows which files to focus on.
+	TargetFiles []string `json:"target_files,omitempty"`
 
 	// ParentModuleContexts contains the context of the parent and sibling modules
 	// of the TargetModule contained within the working module, if any
@@ -33,6 +62,10 @@ type WorkspaceChangeRequest struct {
 	// SubModuleContexts contains the context of all the direct submodules of the TargetModule, if any.
 	SubModuleContexts []ModuleContext `json:"submodule_contexts"`
 
+	// SummarizedModuleContexts contains the internal context of the modules
+	// whose files were left out of Files to fit the request token budget.
+	SummarizedModuleContexts []ModuleContext `json:"summarized_module_contexts,omitempty"`
+
 	// Files contains the content of files relevant to the task.
 	Files []FileContent `json:"files"`
 }
@@ -59,6 +92,14 @@ type ModuleContextRequest struct {
 	SubModulesPublicContexts []ModuleContext `json:"sub_modules_public_contexts"`
 }
 
+// ModuleContextsRequest asks for the internal and public contexts of
+// several modules in a single call, each described like a
+// ModuleContextRequest. A sub-module listed with an empty public context is
+// part of the request: its public context is the one generated with it.
+type ModuleContextsRequest struct {
+	Modules []ModuleContextRequest `json:"modules"`
+}
+
 // ExternalContextsRequest contains information about a module hierarchy
 // needed to generate external contexts for each module.
 type ExternalContextsRequest struct {
@@ -73,6 +114,25 @@ type ModuleInfoForExternalContext struct {
 	PublicContext   string `json:"public_context,omitempty"`
 }
 
+// ChangeNarrativeRequest asks for a description of changes already applied
+// to the workspace, e.g. for a commit message or a pull request.
+type ChangeNarrativeRequest struct {
+	// Instructions are the original instructions of the change, if known.
+	Instructions string `json:"instructions,omitempty"`
+	// ModuleContexts holds the internal context of the modules of the
+	// changed files.
+	ModuleContexts []ModuleContext `json:"module_contexts,omitempty"`
+	Diffs          []FileDiff      `json:"diffs"`
+}
+
+// FileDiff is the unified diff of a changed file. When Summarized is true,
+// the diff was too large to be sent and Diff only outlines it.
+type FileDiff struct {
+	Path       string `json:"path"`
+	Diff       string `json:"diff"`
+	Summarized bool   `json:"summarized,omitempty"`
+}
+
 // --- Response Payloads ---
 
 // WorkspaceChangeProposal is a concrete description of proposed workspace
@@ -109,3 +169,18 @@ type ModuleExternalContext struct {
 type ModuleExternalContextResponse struct {
 	Modules []ModuleExternalContext `json:"modules"`
 }
+
+// ModuleContextsResponse captures the LLM response to a
+// ModuleContextsRequest, the contexts of every module by name.
+type ModuleContextsResponse struct {
+	Modules []ModuleSelfContainedContext `json:"modules"`
+}
+
+// ChangeNarrative describes applied changes for a commit message or a pull
+// request description.
+type ChangeNarrative struct {
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	RiskNotes string `json:"risk_notes"`
+	TestPlan  string `json:"test_plan"`
+}
@@ -1,11 +1,17 @@
 package payload
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
 func TestRequestPayloads_JSONMarshalling(t *testing.T) {
 	testcases := []struct {
 		name    string
@@ -77,4 +83,139 @@ func TestRequestPayloads_JSONMarshalling(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// TestPayloads_Golden pins the JSON shape of every payload type. A diff in
+// a golden file is a change of the schema external tools rely on: renaming
+// or removing a field requires bumping SchemaVersion.
+func TestPayloads_Golden(t *testing.T) {
+	testcases := []struct {
+		name    string
+		payload any
+		newInst func() any
+	}{
+		{
+			name: "WorkspaceChangeRequest",
+			payload: &WorkspaceChangeRequest{
+				WorkingModule:            "w",
+				WorkingModuleContext:     "working context",
+				TargetModule:             "w/t",
+				TargetModuleContext:      "target context",
+				TargetDirectory:          "w/t",
+				TargetFiles:              []string{"w/t/main.go"},
+				ParentModuleContexts:     []ModuleContext{{Name: "w/p", Content: "parent context"}},
+				SubModuleContexts:        []ModuleContext{{Name: "w/t/s", Content: "sub context"}},
+				SummarizedModuleContexts: []ModuleContext{{Name: "w/o", Content: "summary"}},
+				Files:                    []FileContent{{Path: "w/t/main.go", Content: "package main"}},
+			},
+			newInst: func() any { return &WorkspaceChangeRequest{} },
+		},
+		{
+			name: "ModuleContextRequest",
+			payload: &ModuleContextRequest{
+				TargetModuleName:         "m",
+				TargetModuleFiles:        []FileContent{{Path: "m/a.go", Content: "package m"}},
+				TargetModuleDirectories:  []string{"m"},
+				SubModulesPublicContexts: []ModuleContext{{Name: "m/s", Content: "public"}},
+			},
+			newInst: func() any { return &ModuleContextRequest{} },
+		},
+		{
+			name: "ExternalContextsRequest",
+			payload: &ExternalContextsRequest{
+				Modules: []ModuleInfoForExternalContext{{Name: "m", ParentName: ".", InternalContext: "internal", PublicContext: "public"}},
+			},
+			newInst: func() any { return &ExternalContextsRequest{} },
+		},
+		{
+			name: "ModuleContextsRequest",
+			payload: &ModuleContextsRequest{
+				Modules: []ModuleContextRequest{
+					{TargetModuleName: "m/sub", TargetModuleFiles: []FileContent{{Path: "m/sub/a.go", Content: "package sub"}}},
+					{TargetModuleName: "m", SubModulesPublicContexts: []ModuleContext{{Name: "m/sub"}}},
+				},
+			},
+			newInst: func() any { return &ModuleContextsRequest{} },
+		},
+		{
+			name: "ChangeNarrativeRequest",
+			payload: &ChangeNarrativeRequest{
+				Instructions:   "instructions",
+				ModuleContexts: []ModuleContext{{Name: "m", Content: "internal"}},
+				Diffs: []FileDiff{
+					{Path: "m/a.go", Diff: "--- a/m/a.go\n+++ b/m/a.go\n"},
+					{Path: "m/b.go", Diff: "m/b.go: 900 lines added", Summarized: true},
+				},
+			},
+			newInst: func() any { return &ChangeNarrativeRequest{} },
+		},
+		{
+			name: "WorkspaceChangeProposal",
+			payload: &WorkspaceChangeProposal{
+				Description: "description",
+				Summary:     "summary",
+				Proposals: []FileChangeProposal{
+					{FileName: "a.go", Content: "package a"},
+					{FileName: "b.go", Delete: true},
+				},
+			},
+			newInst: func() any { return &WorkspaceChangeProposal{} },
+		},
+		{
+			name:    "ModuleSelfContainedContext",
+			payload: &ModuleSelfContainedContext{Name: "m", ExternalContext: "external", InternalContext: "internal", PublicContext: "public"},
+			newInst: func() any { return &ModuleSelfContainedContext{} },
+		},
+		{
+			name: "ModuleExternalContextResponse",
+			payload: &ModuleExternalContextResponse{
+				Modules: []ModuleExternalContext{{Name: "m", ExternalContext: "external"}},
+			},
+			newInst: func() any { return &ModuleExternalContextResponse{} },
+		},
+		{
+			name: "ModuleContextsResponse",
+			payload: &ModuleContextsResponse{
+				Modules: []ModuleSelfContainedContext{{Name: "m", InternalContext: "internal", PublicContext: "public"}},
+			},
+			newInst: func() any { return &ModuleContextsResponse{} },
+		},
+		{
+			name:    "ChangeNarrative",
+			payload: &ChangeNarrative{Title: "title", Body: "body", RiskNotes: "risks", TestPlan: "tests"},
+			newInst: func() any { return &ChangeNarrative{} },
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.MarshalIndent(tc.payload, "", "  ")
+			if err != nil {
+				t.Fatalf("json.MarshalIndent() failed: %v", err)
+			}
+			data = append(data, '\n')
+
+			golden := filepath.Join("testdata", tc.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, data, 0o644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Fatalf("JSON shape changed, bump SchemaVersion if it breaks consumers and run with -update:\ngot:\n%s\nwant:\n%s", data, want)
+			}
+
+			// The golden document loads back into the same payload.
+			loaded := tc.newInst()
+			if err := json.Unmarshal(want, loaded); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(tc.payload, loaded) {
+				t.Errorf("round-trip mismatch.\nGot:  %#v\nWant: %#v", loaded, tc.payload)
+			}
+		})
+	}
+}
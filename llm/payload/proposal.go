@@ -0,0 +1,115 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrMalformedProposal is matched, with errors.Is, by the error returned
+// when a WorkspaceChangeProposal is not structurally valid.
+var ErrMalformedProposal = errors.New("malformed workspace change proposal")
+
+// ProposalError reports a structural problem of the file proposal at Index
+// of a WorkspaceChangeProposal, in its Field (the JSON name).
+type ProposalError struct {
+	Index   int
+	Field   string
+	Problem string
+}
+
+func (e *ProposalError) Error() string {
+	return fmt.Sprintf("proposals[%d].%s %s", e.Index, e.Field, e.Problem)
+}
+
+func (e *ProposalError) Is(target error) bool {
+	return target == ErrMalformedProposal
+}
+
+// UnmarshalJSON decodes a proposal and validates it, so a proposal decoded
+// from the output of a model is structurally valid. A file proposal must
+// hold its content unless it deletes the file.
+func (p *WorkspaceChangeProposal) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Description string `json:"description"`
+		Summary     string `json:"summary"`
+		Proposals   []struct {
+			FileName string  `json:"file_name"`
+			Content  *string `json:"content"`
+			Delete   bool    `json:"delete"`
+		} `json:"proposals"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var errs []error
+	out := WorkspaceChangeProposal{Description: raw.Description, Summary: raw.Summary}
+	if raw.Proposals != nil {
+		out.Proposals = make([]FileChangeProposal, 0, len(raw.Proposals))
+	}
+	for i, fp := range raw.Proposals {
+		if fp.Content == nil && !fp.Delete {
+			errs = append(errs, &ProposalError{Index: i, Field: "content", Problem: "is missing"})
+		}
+		out.Proposals = append(out.Proposals, FileChangeProposal{FileName: fp.FileName, Content: deref(fp.Content), Delete: fp.Delete})
+	}
+	if err := out.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	*p = out
+	return nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Validate checks the file names of the proposal: each must be a relative
+// path within the workspace, proposed once. It returns a ProposalError for
+// every problem found.
+func (p *WorkspaceChangeProposal) Validate() error {
+	var errs []error
+	seen := make(map[string]int)
+	for i, fp := range p.Proposals {
+		fail := func(problem string, args ...any) {
+			errs = append(errs, &ProposalError{Index: i, Field: "file_name", Problem: fmt.Sprintf(problem, args...)})
+		}
+		name := fp.FileName
+		switch {
+		case strings.TrimSpace(name) == "":
+			fail("is empty")
+			continue
+		case path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "":
+			fail("%q is an absolute path", name)
+		case hasDotDot(name):
+			fail("%q contains \"..\"", name)
+		}
+		clean := path.Clean(filepath.ToSlash(name))
+		if j, ok := seen[clean]; ok {
+			fail("%q duplicates proposals[%d]", name, j)
+			continue
+		}
+		seen[clean] = i
+	}
+	return errors.Join(errs...)
+}
+
+// hasDotDot tells whether a segment of name, split on both separators, is
+// "..".
+func hasDotDot(name string) bool {
+	for _, seg := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == ".." {
+			return true
+		}
+	}
+	return false
+}
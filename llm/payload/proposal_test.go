@@ -0,0 +1,64 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceChangeProposal_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		files string
+		// want lists the expected errors, empty for a valid proposal.
+		want []string
+	}{
+		{"valid", `{"file_name":"a.go","content":"package a"},{"file_name":"b.go","delete":true}`, nil},
+		{"empty content", `{"file_name":"a.go","content":""}`, nil},
+		{"missing content", `{"file_name":"a.go"}`, []string{"proposals[0].content is missing"}},
+		{"empty file name", `{"file_name":"a.go","content":"x"},{"file_name":" ","content":"x"}`, []string{"proposals[1].file_name is empty"}},
+		{"duplicate", `{"file_name":"a.go","content":"x"},{"file_name":"./a.go","delete":true}`, []string{`proposals[1].file_name "./a.go" duplicates proposals[0]`}},
+		{"absolute", `{"file_name":"/etc/passwd","content":"x"}`, []string{`proposals[0].file_name "/etc/passwd" is an absolute path`}},
+		{"parent", `{"file_name":"pkg/../../x.go","content":"x"}`, []string{`proposals[0].file_name "pkg/../../x.go" contains ".."`}},
+		{"several", `{"file_name":"","content":"x"},{"file_name":"..\\x.go"}`, []string{
+			"proposals[0].file_name is empty",
+			"proposals[1].content is missing",
+			`proposals[1].file_name "..\\x.go" contains ".."`,
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var p WorkspaceChangeProposal
+			err := json.Unmarshal([]byte(`{"description":"d","summary":"s","proposals":[`+tc.files+`]}`), &p)
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if p.Summary != "s" || len(p.Proposals) == 0 {
+					t.Fatalf("unexpected proposal %+v", p)
+				}
+				return
+			}
+			if !errors.Is(err, ErrMalformedProposal) {
+				t.Fatalf("expected ErrMalformedProposal, got %v", err)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("expected error to contain %q, got:\n%v", w, err)
+				}
+			}
+			if p.Proposals != nil {
+				t.Fatalf("expected no proposal to be decoded, got %+v", p)
+			}
+		})
+	}
+}
+
+func TestWorkspaceChangeProposal_UnmarshalJSON_Syntax(t *testing.T) {
+	var p WorkspaceChangeProposal
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal([]byte(`{"proposals":[{"file_na`), &p); !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a syntax error for truncated JSON, got %v", err)
+	}
+}
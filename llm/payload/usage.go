@@ -0,0 +1,56 @@
+package payload
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// UsageStats counts the tokens of one or more LLM calls, as reported by
+// the provider, and estimates their cost from the prices of their models.
+type UsageStats struct {
+	// Calls is the number of calls whose usage was reported.
+	Calls            int `json:"calls"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	// Cost is the estimated cost of the calls, in US dollars.
+	Cost float64 `json:"cost_usd"`
+	// Unpriced is the number of calls to models without a known price,
+	// left out of Cost.
+	Unpriced int `json:"unpriced,omitempty"`
+}
+
+// Add adds the usage of other to u.
+func (u *UsageStats) Add(other UsageStats) {
+	u.Calls += other.Calls
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.Cost += other.Cost
+	u.Unpriced += other.Unpriced
+}
+
+// String formats u as "184,223 prompt + 12,440 completion tokens (~$0.93)".
+// The cost is left out when no call was priced.
+func (u UsageStats) String() string {
+	s := fmt.Sprintf("%s prompt + %s completion tokens", groupDigits(u.PromptTokens), groupDigits(u.CompletionTokens))
+	switch {
+	case u.Unpriced >= u.Calls:
+	case u.Unpriced > 0:
+		s += fmt.Sprintf(" (~$%.2f, %d call(s) of unpriced models left out)", u.Cost, u.Unpriced)
+	default:
+		s += fmt.Sprintf(" (~$%.2f)", u.Cost)
+	}
+	return s
+}
+
+// groupDigits formats n with a comma between every group of three digits.
+func groupDigits(n int) string {
+	s := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
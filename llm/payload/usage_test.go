@@ -0,0 +1,22 @@
+package payload
+
+import "testing"
+
+func TestUsageStats_String(t *testing.T) {
+	var u UsageStats
+	u.Add(UsageStats{Calls: 1, PromptTokens: 184000, CompletionTokens: 12000, Cost: 0.9})
+	u.Add(UsageStats{Calls: 1, PromptTokens: 223, CompletionTokens: 440, Cost: 0.03})
+	if got, want := u.String(), "184,223 prompt + 12,440 completion tokens (~$0.93)"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+
+	u.Add(UsageStats{Calls: 1, PromptTokens: 7, Unpriced: 1})
+	if got, want := u.String(), "184,230 prompt + 12,440 completion tokens (~$0.93, 1 call(s) of unpriced models left out)"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+
+	unpriced := UsageStats{Calls: 2, PromptTokens: 1234567, CompletionTokens: 12, Unpriced: 2}
+	if got, want := unpriced.String(), "1,234,567 prompt + 12 completion tokens"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+}
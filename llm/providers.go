@@ -1,5 +1,12 @@
 package llm
 
+import (
+    "slices"
+    "strings"
+
+    "github.com/vybdev/vyb/config"
+)
+
 // SupportedProviders returns the list of LLM providers that can be chosen
 // when initialising a new vyb project.  The slice is a copy – callers may
 // modify it without affecting the package-level data.
@@ -7,7 +14,13 @@ func SupportedProviders() []string {
     return append([]string(nil), supportedProviders...) // defensive copy
 }
 
+// IsSupportedProvider reports whether name, in any case, is one of the
+// providers returned by SupportedProviders.
+func IsSupportedProvider(name string) bool {
+    return slices.Contains(supportedProviders, strings.ToLower(name))
+}
+
 // supportedProviders holds the hard-coded list of providers until dynamic
-// registration lands.  Keep the strings in lowercase as they are written
-// verbatim to .vyb/config.yaml.
-var supportedProviders = []string{"openai", "gemini"}
+// registration lands.  It is config.Providers, which .vyb/config.yaml is
+// validated against.
+var supportedProviders = config.Providers
@@ -15,3 +15,16 @@ func TestSupportedProvidersContainsGemini(t *testing.T) {
         t.Fatalf("SupportedProviders() = %v, want to contain 'gemini'", providers)
     }
 }
+
+func TestIsSupportedProvider(t *testing.T) {
+    for _, name := range []string{"openai", "Gemini", "OLLAMA"} {
+        if !IsSupportedProvider(name) {
+            t.Fatalf("IsSupportedProvider(%q) = false, want true", name)
+        }
+    }
+    for _, name := range []string{"", "acme"} {
+        if IsSupportedProvider(name) {
+            t.Fatalf("IsSupportedProvider(%q) = true, want false", name)
+        }
+    }
+}
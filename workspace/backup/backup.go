@@ -0,0 +1,396 @@
+// Package backup snapshots the files about to be rewritten by a proposal, so
+// the change can be reverted with `vyb undo`.
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dir holds one backup set per applied proposal, relative to the project
+// root.
+const Dir = ".vyb/backups"
+
+// manifestFile is the name of the manifest stored in every backup set.
+const manifestFile = "manifest.yaml"
+
+// pendingFile holds, within a backup set, the proposal being applied in
+// chunks, so an interrupted application can be resumed.
+const pendingFile = "pending"
+
+// filesDir holds the saved copies within a backup set, mirroring the paths
+// relative to the project root.
+const filesDir = "files"
+
+// ErrNoBackup is returned by Restore when there is nothing to undo.
+var ErrNoBackup = errors.New("no backup to restore")
+
+// NOTE: now is a var (not a direct call) to allow test overrides.
+var now = time.Now
+
+// Action records what applying a proposal did to a file.
+type Action string
+
+const (
+	// Created files did not exist before, undo removes them.
+	Created Action = "created"
+	// Modified files were overwritten, undo restores their saved copy.
+	Modified Action = "modified"
+	// Deleted files were removed, undo re-creates them from their saved
+	// copy.
+	Deleted Action = "deleted"
+)
+
+// Change is a file about to be written or deleted, relative to the project
+// root.
+type Change struct {
+	Path   string
+	Delete bool
+}
+
+// Entry is a single file of a backup set.
+type Entry struct {
+	Path   string      `yaml:"path"`
+	Action Action      `yaml:"action"`
+	Mode   fs.FileMode `yaml:"mode,omitempty"`
+	// Hash is the digest of the content written by the proposal, recorded
+	// by RecordApplied. Empty for deleted files and in sets taken before it
+	// was recorded.
+	Hash string `yaml:"hash,omitempty"`
+}
+
+// Origin describes the command whose proposal was applied after a backup
+// set was taken, so `vyb narrate` can describe the change.
+type Origin struct {
+	Command string `yaml:"command,omitempty"`
+	// Instructions is the prompt of the command.
+	Instructions string `yaml:"instructions,omitempty"`
+}
+
+// Progress records how far the application of a proposal in chunks went:
+// the first Applied of its Total files are in place.
+type Progress struct {
+	Applied int `yaml:"applied"`
+	Total   int `yaml:"total"`
+}
+
+// Manifest describes a backup set.
+type Manifest struct {
+	Timestamp time.Time `yaml:"timestamp"`
+	Origin    `yaml:",inline"`
+	Files     []Entry `yaml:"files"`
+	// Progress is set while the proposal is applied in chunks, and left set
+	// when the application is interrupted. Nil once the proposal is fully
+	// applied, and for proposals applied at once.
+	Progress *Progress `yaml:"progress,omitempty"`
+	// dir is the absolute path of the backup set.
+	dir string
+}
+
+// Create saves the current content of every file touched by changes into a
+// new backup set under Dir, and records the set, and its origin, in its
+// manifest. Deleting a file that does not exist is a no-op and is not
+// recorded. When it fails, the partially written set is removed.
+func Create(projectRoot string, origin Origin, changes []Change) (*Manifest, error) {
+	ts := now()
+	m := &Manifest{
+		Timestamp: ts,
+		Origin:    origin,
+		dir:       filepath.Join(projectRoot, filepath.FromSlash(Dir), ts.Format("20060102-150405.000000000")),
+	}
+	if err := m.save(projectRoot, changes); err != nil {
+		_ = os.RemoveAll(m.dir)
+		return nil, err
+	}
+	return m, nil
+}
+
+// save copies the files touched by changes into the set of m, and writes
+// its manifest.
+func (m *Manifest) save(projectRoot string, changes []Change) error {
+	for _, c := range changes {
+		src := filepath.Join(projectRoot, filepath.FromSlash(c.Path))
+		info, err := os.Stat(src)
+		if errors.Is(err, os.ErrNotExist) {
+			if !c.Delete {
+				m.Files = append(m.Files, Entry{Path: c.Path, Action: Created})
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to back up %s: %w", c.Path, err)
+		}
+		entry := Entry{Path: c.Path, Action: Modified, Mode: info.Mode().Perm()}
+		if c.Delete {
+			entry.Action = Deleted
+		}
+		if err := copyFile(src, filepath.Join(m.dir, filesDir, filepath.FromSlash(c.Path)), entry.Mode); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", c.Path, err)
+		}
+		m.Files = append(m.Files, entry)
+	}
+	return m.write()
+}
+
+// write stores m in the manifest of its set.
+func (m *Manifest) write() error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest: %w", err)
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory %s: %w", m.dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, manifestFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return nil
+}
+
+// RecordApplied records, once the proposal is applied, the digest of the
+// content of every created or modified file, computed by hash, so Changed
+// can tell the files modified since.
+func (m *Manifest) RecordApplied(projectRoot string, hash func([]byte) string) error {
+	for i, e := range m.Files {
+		if e.Action == Deleted {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(projectRoot, filepath.FromSlash(e.Path)))
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", e.Path, err)
+		}
+		m.Files[i].Hash = hash(data)
+	}
+	return m.write()
+}
+
+// Begin records that the proposal, whose total files are applied in
+// chunks, is about to be applied, and saves pending, its serialized form,
+// so Pending can return it to resume an interrupted application.
+func (m *Manifest) Begin(pending []byte, total int) error {
+	if err := os.WriteFile(filepath.Join(m.dir, pendingFile), pending, 0644); err != nil {
+		return fmt.Errorf("failed to save the pending proposal: %w", err)
+	}
+	m.Progress = &Progress{Total: total}
+	return m.write()
+}
+
+// Advance records that the first applied files of the proposal are in
+// place.
+func (m *Manifest) Advance(applied int) error {
+	if m.Progress == nil {
+		return errors.New("the proposal of the backup set is not applied in chunks")
+	}
+	m.Progress.Applied = applied
+	return m.write()
+}
+
+// Finish records that the proposal started with Begin is fully applied,
+// and removes its saved form.
+func (m *Manifest) Finish() error {
+	m.Progress = nil
+	if err := os.Remove(filepath.Join(m.dir, pendingFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the pending proposal: %w", err)
+	}
+	return m.write()
+}
+
+// Pending returns the proposal saved by Begin.
+func (m *Manifest) Pending() ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(m.dir, pendingFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pending proposal: %w", err)
+	}
+	return data, nil
+}
+
+// Changed returns the paths of the files modified since the proposal was
+// applied: created or modified files whose content no longer matches the
+// digest recorded by RecordApplied, or no longer exist, and deleted files
+// that exist again. Files without a recorded digest are not compared.
+func (m *Manifest) Changed(projectRoot string, hash func([]byte) string) ([]string, error) {
+	var changed []string
+	for _, e := range m.Files {
+		data, err := os.ReadFile(filepath.Join(projectRoot, filepath.FromSlash(e.Path)))
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %w", e.Path, err)
+		}
+		switch {
+		case e.Action == Deleted:
+			if exists {
+				changed = append(changed, e.Path)
+			}
+		case e.Hash != "":
+			if !exists || hash(data) != e.Hash {
+				changed = append(changed, e.Path)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// Latest returns the manifest of the most recent backup set, or ErrNoBackup
+// when there is none.
+func Latest(projectRoot string) (*Manifest, error) {
+	sets, err := List(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(sets) == 0 {
+		return nil, ErrNoBackup
+	}
+	return sets[0], nil
+}
+
+// List returns the manifests of the backup sets not reverted yet, newest
+// first.
+func List(projectRoot string) ([]*Manifest, error) {
+	base := filepath.Join(projectRoot, filepath.FromSlash(Dir))
+	entries, err := os.ReadDir(base)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	// Set names are timestamps, so the newest sorts last.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+	var sets []*Manifest
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(base, e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+		}
+		var m Manifest
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal backup manifest %s: %w", dir, err)
+		}
+		m.dir = dir
+		sets = append(sets, &m)
+	}
+	return sets, nil
+}
+
+// Prune removes the backup sets older than the newest keep ones, and
+// returns their manifests, newest first.
+func Prune(projectRoot string, keep int) ([]*Manifest, error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("cannot keep %d backup sets", keep)
+	}
+	sets, err := List(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(sets) <= keep {
+		return nil, nil
+	}
+	for _, m := range sets[keep:] {
+		if err := m.Discard(); err != nil {
+			return nil, err
+		}
+	}
+	return sets[keep:], nil
+}
+
+// Restore reverts the most recent backup set: created files are removed,
+// modified and deleted files get their saved content back. The set is then
+// discarded, so the next call reverts the previous one. It returns the
+// restored manifest, or ErrNoBackup when there is nothing to undo.
+func Restore(projectRoot string) (*Manifest, error) {
+	m, err := Latest(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Revert(projectRoot); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Revert restores the files recorded in m to their state when the set was
+// taken, then discards the set. Created files that no longer exist are
+// skipped, so a proposal applied only in part can be reverted too.
+func (m *Manifest) Revert(projectRoot string) error {
+	for _, e := range m.Files {
+		if err := m.restore(projectRoot, e); err != nil {
+			return err
+		}
+	}
+	return m.Discard()
+}
+
+// RevertPaths restores the given files of m, and keeps the set. Paths not
+// recorded in m are ignored. It rolls back a single chunk of a proposal
+// applied in chunks.
+func (m *Manifest) RevertPaths(projectRoot string, paths []string) error {
+	for _, e := range m.Files {
+		if !slices.Contains(paths, e.Path) {
+			continue
+		}
+		if err := m.restore(projectRoot, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore brings back the file of e to its state when the set was taken.
+func (m *Manifest) restore(projectRoot string, e Entry) error {
+	dst := filepath.Join(projectRoot, filepath.FromSlash(e.Path))
+	switch e.Action {
+	case Created:
+		if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", e.Path, err)
+		}
+	case Modified, Deleted:
+		if err := copyFile(filepath.Join(m.dir, filesDir, filepath.FromSlash(e.Path)), dst, e.Mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", e.Path, err)
+		}
+	default:
+		return fmt.Errorf("unknown action %q recorded for %s", e.Action, e.Path)
+	}
+	return nil
+}
+
+// Discard removes the backup set, without touching the files it recorded.
+// It is used when the proposal the set was taken for is not applied.
+func (m *Manifest) Discard() error {
+	if err := os.RemoveAll(m.dir); err != nil {
+		return fmt.Errorf("failed to discard backup %s: %w", m.dir, err)
+	}
+	return nil
+}
+
+// Saved returns the content of path saved in the backup set, before the
+// proposal was applied. Created files have no saved content.
+func (m *Manifest) Saved(path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(m.dir, filesDir, filepath.FromSlash(path)))
+}
+
+// copyFile copies src to dst, creating the parent directories of dst.
+func copyFile(src, dst string, mode fs.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, mode)
+}
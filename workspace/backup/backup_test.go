@@ -0,0 +1,298 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+}
+
+func useClock(t *testing.T, times ...time.Time) {
+	t.Helper()
+	old := now
+	now = func() time.Time {
+		next := times[0]
+		times = times[1:]
+		return next
+	}
+	t.Cleanup(func() { now = old })
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":     "package main\n",
+		"pkg/old.go":  "package pkg\n",
+		"pkg/keep.go": "package pkg // keep\n",
+	})
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	useClock(t, ts)
+
+	changes := []Change{
+		{Path: "main.go"},
+		{Path: "pkg/old.go", Delete: true},
+		{Path: "pkg/new.go"},
+		{Path: "gone.go", Delete: true},
+	}
+	m, err := Create(root, Origin{Command: "code", Instructions: "prompt"}, changes)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	want := []Entry{
+		{Path: "main.go", Action: Modified, Mode: 0644},
+		{Path: "pkg/old.go", Action: Deleted, Mode: 0644},
+		{Path: "pkg/new.go", Action: Created},
+	}
+	if diff := cmp.Diff(want, m.Files); diff != "" {
+		t.Fatalf("unexpected manifest (-want +got):\n%s", diff)
+	}
+
+	// Apply the changes.
+	writeFiles(t, root, map[string]string{"main.go": "package main // changed\n", "pkg/new.go": "package pkg // new\n"})
+	if err := os.Remove(filepath.Join(root, "pkg", "old.go")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	latest, err := Latest(root)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if latest.Command != "code" || latest.Instructions != "prompt" {
+		t.Fatalf("expected the origin to be recorded, got %+v", latest.Origin)
+	}
+	if saved, err := latest.Saved("main.go"); err != nil || string(saved) != "package main\n" {
+		t.Fatalf("Saved(main.go) = %q (%v), want the content before the change", saved, err)
+	}
+
+	restored, err := Restore(root)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if diff := cmp.Diff(m, restored, cmp.AllowUnexported(Manifest{}), cmpopts.EquateApproxTime(0)); diff != "" {
+		t.Fatalf("unexpected restored manifest (-want +got):\n%s", diff)
+	}
+	for name, content := range map[string]string{
+		"main.go":     "package main\n",
+		"pkg/old.go":  "package pkg\n",
+		"pkg/keep.go": "package pkg // keep\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Fatalf("%s = %q (%v), want %q", name, got, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "pkg", "new.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected the created file to be removed, got %v", err)
+	}
+
+	if _, err := Restore(root); !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("expected ErrNoBackup once the set is restored, got %v", err)
+	}
+}
+
+func TestRestore_NewestFirst(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.txt": "v1"})
+	useClock(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC))
+
+	if _, err := Create(root, Origin{}, []Change{{Path: "a.txt"}}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	writeFiles(t, root, map[string]string{"a.txt": "v2"})
+	if _, err := Create(root, Origin{}, []Change{{Path: "a.txt"}}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	writeFiles(t, root, map[string]string{"a.txt": "v3"})
+
+	for _, want := range []string{"v2", "v1"} {
+		if _, err := Restore(root); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(got) != want {
+			t.Fatalf("a.txt = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRestore_NoBackup(t *testing.T) {
+	if _, err := Restore(t.TempDir()); !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("expected ErrNoBackup, got %v", err)
+	}
+}
+
+func TestCreate_FailedCopyLeavesNoSet(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.txt": "v1", "dir/b.txt": "v1"})
+
+	// Reading a directory fails once a.txt is already copied into the set.
+	if _, err := Create(root, Origin{}, []Change{{Path: "a.txt"}, {Path: "dir"}}); err == nil {
+		t.Fatal("expected an error backing up a directory")
+	}
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(Dir)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the partial backup set to be removed, found %v", entries)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.txt": "v1"})
+	m, err := Create(root, Origin{}, []Change{{Path: "a.txt"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	writeFiles(t, root, map[string]string{"a.txt": "v2"})
+
+	if err := m.Discard(); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(got) != "v2" {
+		t.Fatalf("expected Discard to leave the files alone, got %q", got)
+	}
+	if _, err := Latest(root); !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("expected ErrNoBackup once the set is discarded, got %v", err)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"mod.txt": "v1", "del.txt": "v1", "same.txt": "v1"})
+	m, err := Create(root, Origin{}, []Change{{Path: "mod.txt"}, {Path: "del.txt", Delete: true}, {Path: "new.txt"}, {Path: "same.txt"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	hash := func(b []byte) string { return string(b) }
+	writeFiles(t, root, map[string]string{"mod.txt": "v2", "new.txt": "v2", "same.txt": "v2"})
+	if err := os.Remove(filepath.Join(root, "del.txt")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := m.RecordApplied(root, hash); err != nil {
+		t.Fatalf("RecordApplied: %v", err)
+	}
+
+	if changed, err := m.Changed(root, hash); err != nil || len(changed) != 0 {
+		t.Fatalf("expected no change right after applying, got %v, %v", changed, err)
+	}
+
+	// The digests are read back from the manifest.
+	latest, err := Latest(root)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	writeFiles(t, root, map[string]string{"mod.txt": "v3", "del.txt": "v3"})
+	if err := os.Remove(filepath.Join(root, "new.txt")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	changed, err := latest.Changed(root, hash)
+	if err != nil {
+		t.Fatalf("Changed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"mod.txt", "del.txt", "new.txt"}, changed); diff != "" {
+		t.Fatalf("unexpected changed files (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.txt": "v1"})
+	useClock(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 2, 0, time.UTC))
+	for i := 0; i < 3; i++ {
+		if _, err := Create(root, Origin{}, []Change{{Path: "a.txt"}}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	removed, err := Prune(root, 2)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(removed) != 1 || removed[0].Timestamp.Second() != 0 {
+		t.Fatalf("expected the oldest set to be removed, got %+v", removed)
+	}
+	sets, err := List(root)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(sets) != 2 || sets[0].Timestamp.Second() != 2 || sets[1].Timestamp.Second() != 1 {
+		t.Fatalf("expected the 2 newest sets, newest first, got %+v", sets)
+	}
+
+	if removed, err := Prune(root, 0); err != nil || len(removed) != 2 {
+		t.Fatalf("Prune(0) = %+v, %v", removed, err)
+	}
+	if _, err := Latest(root); !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("expected no set left, got %v", err)
+	}
+}
+
+func TestChunkedApplication(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.go": "package a\n", "b.go": "package b\n"})
+	m, err := Create(root, Origin{Command: "code"}, []Change{{Path: "a.go"}, {Path: "b.go"}, {Path: "c.go"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := m.Begin([]byte("proposal"), 3); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	// The first chunk is applied, the second one is interrupted half way.
+	writeFiles(t, root, map[string]string{"a.go": "package a // new\n", "b.go": "package b // new\n", "c.go": "package c\n"})
+	if err := m.Advance(1); err != nil {
+		t.Fatalf("Advance: %v", err)
+	}
+	if err := m.RevertPaths(root, []string{"b.go", "c.go"}); err != nil {
+		t.Fatalf("RevertPaths: %v", err)
+	}
+
+	latest, err := Latest(root)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if diff := cmp.Diff(&Progress{Applied: 1, Total: 3}, latest.Progress); diff != "" {
+		t.Fatalf("unexpected progress (-want +got):\n%s", diff)
+	}
+	if pending, err := latest.Pending(); err != nil || string(pending) != "proposal" {
+		t.Fatalf("Pending() = %q, %v", pending, err)
+	}
+	for name, want := range map[string]string{"a.go": "package a // new\n", "b.go": "package b\n"} {
+		if data, _ := os.ReadFile(filepath.Join(root, name)); string(data) != want {
+			t.Fatalf("%s = %q, want %q", name, data, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "c.go")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the created file of the reverted chunk to be removed, got %v", err)
+	}
+
+	if err := latest.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if latest, err = Latest(root); err != nil || latest.Progress != nil {
+		t.Fatalf("expected the progress to be cleared, got %+v, %v", latest.Progress, err)
+	}
+	if _, err := latest.Pending(); err == nil {
+		t.Fatal("expected the pending proposal to be removed")
+	}
+	if err := latest.Advance(2); err == nil {
+		t.Fatal("expected Advance to fail once the proposal is fully applied")
+	}
+}
@@ -3,8 +3,10 @@ package context
 import (
     "fmt"
     "os"
+    "path"
     "path/filepath"
-    "strings"
+
+    "github.com/vybdev/vyb/workspace/pathutil"
 )
 
 // ExecutionContext captures the three key path concepts used by vyb
@@ -14,9 +16,14 @@ import (
 //   • WorkingDir  – directory from which the command is executed. Must be
 //                   the same as ProjectRoot or a descendant of it.
 //   • TargetDir   – directory containing the target file (if one was
-//                   provided to the command). When no target is given it
-//                   equals WorkingDir. TargetDir is guaranteed to be the
+//                   provided to the command), or the deepest directory
+//                   containing all of them when several were. When the
+//                   target is a directory it is that directory. When no
+//                   target is given it equals WorkingDir. TargetDir is guaranteed to be the
 //                   same as WorkingDir or a descendant of it.
+//   • TargetFiles – the target files provided to the command, if any.
+//   • DirTarget   – whether the target was a directory, in which case
+//                   there are no TargetFiles.
 //
 // Invariants are enforced by the constructor – direct struct instantiation
 // outside this package is discouraged.
@@ -24,30 +31,43 @@ import (
 // NOTE: This package purposefully sits outside the project/root package so
 // it can be reused by matcher, selector and template with no import
 // cycles.
-//
-// TODO(vyb): Add convenience helpers (e.g. Rel(path)) when required by
-// later tasks.
 type ExecutionContext struct {
     ProjectRoot string
     WorkingDir  string
     TargetDir   string
+    TargetFiles []string
+    DirTarget   bool
 }
 
 // NewExecutionContext validates and returns an ExecutionContext.
 //
 // Parameters must be *absolute* paths. If targetFile is nil it is treated
-// as if no target was provided.
+// as if no target was provided. targetFile may name a directory, which
+// then becomes TargetDir.
 func NewExecutionContext(projectRoot, workingDir string, targetFile *string) (*ExecutionContext, error) {
+    var targetFiles []string
+    if targetFile != nil {
+        targetFiles = []string{*targetFile}
+    }
+    return NewExecutionContextMulti(projectRoot, workingDir, targetFiles)
+}
+
+// NewExecutionContextMulti validates and returns an ExecutionContext for a
+// command invoked on several target files. Every target must be under
+// workingDir; TargetDir is the deepest directory containing all of them,
+// or workingDir when targetFiles is empty. A single target may instead be
+// a directory, which becomes TargetDir with no target file.
+//
+// Parameters must be *absolute* paths.
+func NewExecutionContextMulti(projectRoot, workingDir string, targetFiles []string) (*ExecutionContext, error) {
     // Sanity-check that we received absolute paths.
     if !filepath.IsAbs(projectRoot) || !filepath.IsAbs(workingDir) {
         return nil, fmt.Errorf("projectRoot and workingDir must be absolute paths")
     }
-    var targetAbs string
-    if targetFile != nil {
-        if !filepath.IsAbs(*targetFile) {
+    for _, t := range targetFiles {
+        if !filepath.IsAbs(t) {
             return nil, fmt.Errorf("targetFile must be an absolute path when provided")
         }
-        targetAbs = filepath.Clean(*targetFile)
     }
 
     root := filepath.Clean(projectRoot)
@@ -59,47 +79,92 @@ func NewExecutionContext(projectRoot, workingDir string, targetFile *string) (*E
     }
 
     // workingDir must be under projectRoot.
-    if !isDescendant(root, work) {
+    if !pathutil.IsPathUnderDir(root, work) {
         return nil, fmt.Errorf("workingDir %s is not within projectRoot %s", work, root)
     }
 
-    // Derive/validate targetDir when a target file is provided.
-    var targetDir string
-    if targetFile != nil {
+    // Derive/validate targetDir when target files are provided.
+    targetDir := work
+    var targets []string
+    for i, t := range targetFiles {
+        targetAbs := filepath.Clean(t)
         fi, err := os.Stat(targetAbs)
         if err != nil {
             return nil, fmt.Errorf("target file %s does not exist: %w", targetAbs, err)
         }
         if fi.IsDir() {
-            return nil, fmt.Errorf("target %s is a directory, expected a file", targetAbs)
+            if len(targetFiles) > 1 {
+                return nil, fmt.Errorf("target %s is a directory, which must be the only target", targetAbs)
+            }
+            if !pathutil.IsPathUnderDir(work, targetAbs) {
+                return nil, fmt.Errorf("target directory %s is outside workingDir %s", targetAbs, work)
+            }
+            return &ExecutionContext{
+                ProjectRoot: root,
+                WorkingDir:  work,
+                TargetDir:   targetAbs,
+                DirTarget:   true,
+            }, nil
         }
+        targets = append(targets, targetAbs)
 
-        if !isDescendant(work, targetAbs) {
+        if !pathutil.IsPathUnderDir(work, targetAbs) {
             return nil, fmt.Errorf("target file %s is outside workingDir %s", targetAbs, work)
         }
 
-        targetDir = filepath.Dir(targetAbs)
-    } else {
-        targetDir = work
+        if i == 0 {
+            targetDir = filepath.Dir(targetAbs)
+        } else {
+            targetDir = commonAncestor(targetDir, filepath.Dir(targetAbs))
+        }
     }
 
     return &ExecutionContext{
         ProjectRoot: root,
         WorkingDir:  work,
         TargetDir:   filepath.Clean(targetDir),
+        TargetFiles: targets,
     }, nil
 }
 
-// isDescendant returns true when child == parent or child is somewhere
-// below parent in the directory hierarchy.
-func isDescendant(parent, child string) bool {
-    rel, err := filepath.Rel(parent, child)
+// Rel returns abs relative to ProjectRoot, with forward slashes, e.g.
+// "pkg/api" or "." for the project root itself.
+func (ec *ExecutionContext) Rel(abs string) string {
+    rel, err := filepath.Rel(ec.ProjectRoot, abs)
     if err != nil {
-        return false
+        return filepath.ToSlash(abs)
     }
-    return rel == "." || !startsWithDotDot(rel)
+    return filepath.ToSlash(rel)
 }
 
-func startsWithDotDot(rel string) bool {
-    return rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+// IsTarget reports whether rel, a forward-slash path relative to
+// ProjectRoot, is one of the target files.
+func (ec *ExecutionContext) IsTarget(rel string) bool {
+    rel = path.Clean(rel)
+    for _, t := range ec.TargetFiles {
+        if ec.Rel(t) == rel {
+            return true
+        }
+    }
+    return false
+}
+
+// UnderTarget reports whether rel, a forward-slash path relative to
+// ProjectRoot, is TargetDir or lies below it.
+func (ec *ExecutionContext) UnderTarget(rel string) bool {
+    return pathutil.IsPathUnderDir(ec.Rel(ec.TargetDir), rel)
+}
+
+// UnderWorking reports whether rel, a forward-slash path relative to
+// ProjectRoot, is WorkingDir or lies below it.
+func (ec *ExecutionContext) UnderWorking(rel string) bool {
+    return pathutil.IsPathUnderDir(ec.Rel(ec.WorkingDir), rel)
+}
+
+// commonAncestor returns the deepest directory containing both a and b.
+func commonAncestor(a, b string) string {
+    for !pathutil.IsPathUnderDir(a, b) {
+        a = filepath.Dir(a)
+    }
+    return a
 }
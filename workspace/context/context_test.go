@@ -72,3 +72,138 @@ func TestNewExecutionContext_ErrTargetOutsideWork(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+func TestNewExecutionContext_DirTarget(t *testing.T) {
+	root := setupProject(t)
+	work := filepath.Join(root, "pkg")
+	target := filepath.Join(work, "selector")
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	ec, err := NewExecutionContext(root, work, &target)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ec.TargetDir != target || !ec.DirTarget || len(ec.TargetFiles) != 0 {
+		t.Fatalf("expected the directory as TargetDir and no target file, got %+v", ec)
+	}
+
+	// The working directory itself is a valid target.
+	ec, err = NewExecutionContext(root, work, &work)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ec.TargetDir != work || !ec.DirTarget {
+		t.Fatalf("expected the working directory as TargetDir, got %+v", ec)
+	}
+}
+
+func TestNewExecutionContext_ErrDirTarget(t *testing.T) {
+	root := setupProject(t)
+	work := filepath.Join(root, "some")
+	other := filepath.Join(root, "other")
+	file := filepath.Join(work, "file.txt")
+	for _, dir := range []string{work, other} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if _, err := NewExecutionContext(root, work, &other); err == nil {
+		t.Fatal("expected an error for a directory outside workingDir, got nil")
+	}
+	if _, err := NewExecutionContext(root, work, &root); err == nil {
+		t.Fatal("expected an error for a parent of workingDir, got nil")
+	}
+	if _, err := NewExecutionContextMulti(root, root, []string{file, work}); err == nil {
+		t.Fatal("expected an error for a directory along with other targets, got nil")
+	}
+}
+
+func TestNewExecutionContextMulti(t *testing.T) {
+	root := setupProject(t)
+	var targets []string
+	for _, name := range []string{"pkg/a/x.go", "pkg/a/x_test.go", "pkg/b/c/y.go"} {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		targets = append(targets, p)
+	}
+
+	ec, err := NewExecutionContextMulti(root, root, targets[:2])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(root, "pkg", "a"); ec.TargetDir != want {
+		t.Fatalf("expected TargetDir %s, got %s", want, ec.TargetDir)
+	}
+
+	ec, err = NewExecutionContextMulti(root, root, targets)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(root, "pkg"); ec.TargetDir != want {
+		t.Fatalf("expected TargetDir %s, got %s", want, ec.TargetDir)
+	}
+
+	// Every target must be under the working dir.
+	if _, err := NewExecutionContextMulti(root, filepath.Join(root, "pkg", "a"), targets); err == nil {
+		t.Fatalf("expected error for a target outside workingDir, got nil")
+	}
+}
+
+func TestExecutionContext_Classify(t *testing.T) {
+	root := setupProject(t)
+	ec := &ExecutionContext{
+		ProjectRoot: root,
+		WorkingDir:  filepath.Join(root, "foo"),
+		TargetDir:   filepath.Join(root, "foo", "api"),
+		TargetFiles: []string{filepath.Join(root, "foo", "api", "h.go")},
+	}
+
+	cases := []struct {
+		rel                               string
+		target, underTarget, underWorking bool
+	}{
+		{"foo/api/h.go", true, true, true},
+		{"foo/api/./h.go", true, true, true},
+		{"foo/api/other.go", false, true, true},
+		{"foo/api", false, true, true},
+		{"foo/main.go", false, false, true},
+		{"foo", false, false, true},
+		{"foobar/x.go", false, false, false},
+		{"foo/apix/x.go", false, false, true},
+		{"main.go", false, false, false},
+		{"foo/../main.go", false, false, false},
+		{"../outside.go", false, false, false},
+	}
+	for _, c := range cases {
+		if got := ec.IsTarget(c.rel); got != c.target {
+			t.Errorf("IsTarget(%q) = %v, want %v", c.rel, got, c.target)
+		}
+		if got := ec.UnderTarget(c.rel); got != c.underTarget {
+			t.Errorf("UnderTarget(%q) = %v, want %v", c.rel, got, c.underTarget)
+		}
+		if got := ec.UnderWorking(c.rel); got != c.underWorking {
+			t.Errorf("UnderWorking(%q) = %v, want %v", c.rel, got, c.underWorking)
+		}
+	}
+
+	// At the project root everything but escaping paths is under the
+	// working directory.
+	ec.WorkingDir = root
+	if !ec.UnderWorking("foobar/x.go") || ec.UnderWorking("../outside.go") {
+		t.Errorf("unexpected classification at the project root")
+	}
+	if got := ec.Rel(root); got != "." {
+		t.Errorf("Rel(root) = %q, want \".\"", got)
+	}
+}
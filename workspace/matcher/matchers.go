@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // mockFileInfo is a helper struct used to simulate file info when the
@@ -161,6 +162,12 @@ func matchesPattern(fileInfo fs.FileInfo, filePath string, matcher string, match
 		return false
 	}
 
+	// A directory matcher used for inclusion matches the directory and
+	// everything under it, as "dir/**" would.
+	if dirMatcher {
+		matcher = strings.TrimSuffix(matcher, "/") + "/**"
+	}
+
 	// If the pattern does not contain a slash, it should be matched against the basename only.
 	if !strings.Contains(matcher, "/") {
 		return matchSingleSegment(filepath.Base(normalizedPath), matcher)
@@ -225,7 +232,8 @@ func matchTokens(pathTokens, patternTokens []string) bool {
 }
 
 // matchSingleSegment matches a single path segment (no slashes) against a
-// .gitignore-style pattern containing possible "*" or "?" characters.
+// .gitignore-style pattern containing possible "*" or "?" characters,
+// bracket expressions such as "[a-z]" or "[!0-9]", and backslash escapes.
 func matchSingleSegment(segment, pattern string) bool {
 	si, pi := 0, 0
 
@@ -245,6 +253,29 @@ func matchSingleSegment(segment, pattern string) bool {
 		case '?':
 			si++
 			pi++
+		case '[':
+			r, width := utf8.DecodeRuneInString(segment[si:])
+			matched, end, ok := matchBracket(r, pattern[pi:])
+			if !ok {
+				// An unterminated bracket is a literal "[".
+				if segment[si] != '[' {
+					return false
+				}
+				si++
+				pi++
+				continue
+			}
+			if !matched {
+				return false
+			}
+			si += width
+			pi += end
+		case '\\':
+			// A backslash matches the character following it literally.
+			if pi+1 < len(pattern) {
+				pi++
+			}
+			fallthrough
 		default:
 			if segment[si] != pattern[pi] {
 				return false
@@ -263,3 +294,41 @@ func matchSingleSegment(segment, pattern string) bool {
 
 	return si == len(segment) && pi == len(pattern)
 }
+
+// matchBracket matches r against the bracket expression at the start of
+// pattern, e.g. "[a-zA-Z_]" or "[!0-9]" ("[^0-9]" works too). It returns
+// whether r matched and the length of the expression, or ok false when
+// the expression has no closing bracket. A "]" right after the opening
+// bracket (or the negation) is a literal, as is any escaped character.
+func matchBracket(r rune, pattern string) (matched bool, end int, ok bool) {
+	i := 1
+	negate := i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^')
+	if negate {
+		i++
+	}
+	first := i
+	// next reads the, possibly escaped, character at i.
+	next := func() rune {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		c, width := utf8.DecodeRuneInString(pattern[i:])
+		i += width
+		return c
+	}
+	for i < len(pattern) {
+		if pattern[i] == ']' && i > first {
+			return matched != negate, i + 1, true
+		}
+		lo := next()
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			i++
+			hi = next()
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+	}
+	return false, 0, false
+}
@@ -124,6 +124,13 @@ func Test_matchesPattern(t *testing.T) {
 		{"foo/bar.txt", false, "foo/", false, true, "When matchAll is false and the template is a directory, it should match the directory hierarchy, not the entire file path"},
 		{"foo/baz/bar.txt", false, "foo/", false, true, "Partial match on a directory matching matches the entire directory hierarchy"},
 		{"baz/foo/bar.txt", false, "foo/", false, false, "Partial match on a directory matching pattern must start from the beginning of the path"},
+		{"foo/bar.txt", false, "foo/", true, true, "When matchAll is true, a directory template matches the files under it, like foo/**"},
+		{"foo/baz/bar.txt", false, "foo/", true, true, "A directory template matches the files of nested directories too"},
+		{"foo", true, "foo/", true, true, "A directory template matches the directory itself"},
+		{"baz/foo/bar.txt", false, "foo/", true, false, "A directory template with matchAll is still relative to the root"},
+		{"api/docs/a.md", false, "api/docs/", true, true, "A nested directory template matches the files under it"},
+		{"api/docs.md", false, "api/docs/", true, false, "A nested directory template does not match a file sharing its prefix"},
+		{"api/v1/docs/a.md", false, "**/docs/", true, true, "A leading ** lets a directory template match at any level"},
 	}
 
 	for _, tc := range tests {
@@ -136,6 +143,54 @@ func Test_matchesPattern(t *testing.T) {
 	}
 }
 
+func Test_matchSingleSegment(t *testing.T) {
+	tests := []struct {
+		segment string
+		pattern string
+		want    bool
+	}{
+		// ranges and sets
+		{"file1.txt", "file[0-9].txt", true},
+		{"filea.txt", "file[0-9].txt", false},
+		{"file10.txt", "file[0-9].txt", false},
+		{"Makefile", "[a-zA-Z]akefile", true},
+		{"_akefile", "[a-zA-Z]akefile", false},
+		{"b.go", "[abc].go", true},
+		{"d.go", "[abc].go", false},
+		{"x-y", "x[-_]y", true},
+		{"x_y", "x[_-]y", true},
+		{"a]b", "a[]]b", true},
+		{"é.txt", "[à-ü].txt", true},
+		{"file1.txt", "file[0-9]*", true},
+		// negation
+		{"filea.txt", "file[!0-9].txt", true},
+		{"file1.txt", "file[!0-9].txt", false},
+		{"filea.txt", "file[^0-9].txt", true},
+		{"file1.txt", "file[^0-9].txt", false},
+		{"a]b", "a[!]]b", false},
+		// escaped metacharacters
+		{"a*b", `a\*b`, true},
+		{"axb", `a\*b`, false},
+		{"a?b", `a\?b`, true},
+		{"axb", `a\?b`, false},
+		{"[x].go", `\[x].go`, true},
+		{"x.go", `\[x].go`, false},
+		{"a-b", `a[\-]b`, true},
+		{"a]b", `a[\]]b`, true},
+		// an unterminated bracket is a literal
+		{"[abc", "[abc", true},
+		{"a", "[abc", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("(%s, %s)", tc.segment, tc.pattern), func(t *testing.T) {
+			if got := matchSingleSegment(tc.segment, tc.pattern); got != tc.want {
+				t.Fatalf("matchSingleSegment(%q, %q) -> %v, want %v", tc.segment, tc.pattern, got, tc.want)
+			}
+		})
+	}
+}
+
 func Test_matchesInclusionPatterns(t *testing.T) {
 	gitignoreExample := []string{
 		"*",      // matches every file's basename
@@ -154,6 +209,9 @@ func Test_matchesInclusionPatterns(t *testing.T) {
 		{"a.md", false, gitignoreExample, true, "matches first rule and no other"},
 		{"foo/a.md", false, gitignoreExample, true, "exclusion in /foo then re-inclusion in /foo/*"},
 		{"foo/bar/a.md", false, []string{"*"}, true, "* should match every file name in every directory"},
+		{"docs/guide/a.md", false, []string{"docs/"}, true, "a directory pattern includes every file under the directory"},
+		{"src/a.go", false, []string{"docs/"}, false, "a directory pattern does not include files elsewhere"},
+		{"docs/draft/a.md", false, []string{"!docs/draft/", "docs/"}, false, "a negated directory pattern leaves out the files under the directory"},
 	}
 
 	for _, tc := range tests {
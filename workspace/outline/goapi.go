@@ -0,0 +1,159 @@
+package outline
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Package digests the exported declarations of a Go package.
+type Package struct {
+	// Dir is the package directory, relative to the outlined directory.
+	Dir          string   `json:"dir"`
+	Name         string   `json:"name"`
+	Declarations []string `json:"declarations"`
+}
+
+// goAPI parses files, slash-separated paths within fsys, and returns the
+// exported declarations of every package, sorted by directory. Function
+// bodies, struct fields and interface methods are left out.
+func goAPI(fsys fs.FS, files []string) ([]Package, error) {
+	fset := token.NewFileSet()
+	byDir := make(map[string]*Package)
+	for _, name := range files {
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, name, src, parser.SkipObjectResolution)
+		if err != nil {
+			// Unparsable files are outlined without their API.
+			continue
+		}
+		dir := path.Dir(name)
+		pkg := byDir[dir]
+		if pkg == nil {
+			pkg = &Package{Dir: dir, Name: f.Name.Name}
+			byDir[dir] = pkg
+		}
+		decls, err := exportedDecls(fset, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		pkg.Declarations = append(pkg.Declarations, decls...)
+	}
+
+	var pkgs []Package
+	for _, pkg := range byDir {
+		if len(pkg.Declarations) == 0 {
+			continue
+		}
+		sort.Strings(pkg.Declarations)
+		pkgs = append(pkgs, *pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Dir < pkgs[j].Dir })
+	return pkgs, nil
+}
+
+// exportedDecls renders the exported top-level declarations of f, one line
+// each.
+func exportedDecls(fset *token.FileSet, f *ast.File) ([]string, error) {
+	var out []string
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() || (d.Recv != nil && !exportedReceiver(d.Recv)) {
+				continue
+			}
+			s, err := render(fset, &ast.FuncDecl{Recv: d.Recv, Name: d.Name, Type: d.Type})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch sp := spec.(type) {
+				case *ast.TypeSpec:
+					if !sp.Name.IsExported() {
+						continue
+					}
+					s, err := renderType(fset, sp)
+					if err != nil {
+						return nil, err
+					}
+					out = append(out, s)
+				case *ast.ValueSpec:
+					for _, n := range sp.Names {
+						if !n.IsExported() {
+							continue
+						}
+						s := d.Tok.String() + " " + n.Name
+						if sp.Type != nil {
+							t, err := render(fset, sp.Type)
+							if err != nil {
+								return nil, err
+							}
+							s += " " + t
+						}
+						out = append(out, s)
+					}
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// exportedReceiver reports whether the receiver type of a method is
+// exported.
+func exportedReceiver(recv *ast.FieldList) bool {
+	if len(recv.List) == 0 {
+		return false
+	}
+	t := recv.List[0].Type
+	for {
+		switch x := t.(type) {
+		case *ast.StarExpr:
+			t = x.X
+		case *ast.IndexExpr:
+			t = x.X
+		case *ast.IndexListExpr:
+			t = x.X
+		case *ast.Ident:
+			return x.IsExported()
+		default:
+			return false
+		}
+	}
+}
+
+// renderType renders a type declaration, eliding the fields of structs and
+// the methods of interfaces.
+func renderType(fset *token.FileSet, sp *ast.TypeSpec) (string, error) {
+	short := &ast.TypeSpec{Name: sp.Name, TypeParams: sp.TypeParams, Assign: sp.Assign, Type: sp.Type}
+	switch sp.Type.(type) {
+	case *ast.StructType:
+		short.Type = ast.NewIdent("struct")
+	case *ast.InterfaceType:
+		short.Type = ast.NewIdent("interface")
+	}
+	s, err := render(fset, short)
+	if err != nil {
+		return "", err
+	}
+	return "type " + s, nil
+}
+
+func render(fset *token.FileSet, node any) (string, error) {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,177 @@
+// Package outline builds a structured overview of a directory purely from
+// local data: its files grouped by role, directory layout, token
+// distribution and exported Go API. No LLM is involved.
+package outline
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// Role is the part a file plays within a module.
+type Role string
+
+const (
+	RoleSource Role = "source"
+	RoleTest   Role = "test"
+	RoleConfig Role = "config"
+	RoleDocs   Role = "docs"
+)
+
+// roles lists every Role in rendering order.
+var roles = []Role{RoleSource, RoleTest, RoleConfig, RoleDocs}
+
+var docExtensions = map[string]bool{".md": true, ".markdown": true, ".rst": true, ".txt": true, ".adoc": true}
+
+var configExtensions = map[string]bool{
+	".yaml": true, ".yml": true, ".json": true, ".toml": true, ".ini": true,
+	".cfg": true, ".conf": true, ".env": true, ".properties": true, ".mod": true,
+	".sum": true, ".lock": true, ".vyb": true,
+}
+
+var configNames = map[string]bool{
+	"makefile": true, "dockerfile": true, ".gitignore": true, ".dockerignore": true,
+	".editorconfig": true, "go.work": true,
+}
+
+// Classify returns the role of the file at the slash-separated path p.
+// Test files are recognised by name (_test.go, .test.js, test_*.py, …) or by
+// living under a test, tests or testdata directory.
+func Classify(p string) Role {
+	base := strings.ToLower(path.Base(p))
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	for _, dir := range strings.Split(path.Dir(p), "/") {
+		switch strings.ToLower(dir) {
+		case "test", "tests", "testdata", "__tests__":
+			return RoleTest
+		}
+	}
+	switch {
+	case strings.HasSuffix(stem, "_test"), strings.HasSuffix(stem, ".test"), strings.HasSuffix(stem, ".spec"),
+		strings.HasPrefix(stem, "test_"):
+		return RoleTest
+	case docExtensions[ext], strings.HasPrefix(stem, "readme"), stem == "license", stem == "changelog":
+		return RoleDocs
+	case configExtensions[ext], configNames[base]:
+		return RoleConfig
+	}
+	return RoleSource
+}
+
+// File is a single file of the outline, relative to the outlined directory.
+type File struct {
+	Path   string `json:"path"`
+	Tokens int64  `json:"tokens"`
+}
+
+// Group holds the files sharing a role.
+type Group struct {
+	Role   Role   `json:"role"`
+	Files  []File `json:"files"`
+	Tokens int64  `json:"tokens"`
+}
+
+// Outline is the overview of a directory.
+type Outline struct {
+	// SchemaVersion is payload.SchemaVersion, recorded in the JSON outline.
+	SchemaVersion string `json:"schema_version"`
+	// Path is the outlined directory, as given by the caller.
+	Path string `json:"path"`
+	// Directories lists the sub-directories holding files, sorted.
+	Directories []string `json:"directories"`
+	Groups      []Group  `json:"groups"`
+	Tokens      int64    `json:"tokens"`
+	// TokensEstimated is set when the token counts are heuristic estimates,
+	// see project.HeuristicTokenCounts.
+	TokensEstimated bool `json:"tokens_estimated,omitempty"`
+	// API digests the exported Go declarations, per package.
+	API []Package `json:"api,omitempty"`
+	// Module is the name of the module whose stored annotation is attached,
+	// empty when there is none.
+	Module     string              `json:"module,omitempty"`
+	Annotation *project.Annotation `json:"annotation,omitempty"`
+}
+
+// Build scans fsys, rooted at the outlined directory, with the same
+// selection rules as the project metadata (.gitignore files are honoured),
+// and returns its outline. p is only recorded in the result.
+func Build(fsys fs.FS, p string) (*Outline, error) {
+	meta, err := project.BuildMetadataFS(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*project.FileRef
+	var walk func(m *project.Module)
+	walk = func(m *project.Module) {
+		if m == nil {
+			return
+		}
+		files = append(files, m.Files...)
+		for _, sub := range m.Modules {
+			walk(sub)
+		}
+	}
+	walk(meta.Modules)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	o := &Outline{SchemaVersion: payload.SchemaVersion, Path: p, Directories: []string{}, TokensEstimated: project.HeuristicTokenCounts()}
+	groups := make(map[Role]*Group)
+	dirs := make(map[string]bool)
+	var goFiles []string
+	for _, f := range files {
+		role := Classify(f.Name)
+		g := groups[role]
+		if g == nil {
+			g = &Group{Role: role}
+			groups[role] = g
+		}
+		g.Files = append(g.Files, File{Path: f.Name, Tokens: f.TokenCount})
+		g.Tokens += f.TokenCount
+		o.Tokens += f.TokenCount
+		for d := path.Dir(f.Name); d != "." && !dirs[d]; d = path.Dir(d) {
+			dirs[d] = true
+		}
+		if role == RoleSource && path.Ext(f.Name) == ".go" {
+			goFiles = append(goFiles, f.Name)
+		}
+	}
+	for _, r := range roles {
+		if g := groups[r]; g != nil {
+			o.Groups = append(o.Groups, *g)
+		}
+	}
+	for d := range dirs {
+		o.Directories = append(o.Directories, d)
+	}
+	sort.Strings(o.Directories)
+
+	o.API, err = goAPI(fsys, goFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to digest the Go API: %w", err)
+	}
+	return o, nil
+}
+
+// AttachAnnotation records the annotation stored for the module covering
+// rel, the slash-separated path of the outlined directory relative to the
+// project root. Nothing is attached when the module has no annotation.
+func (o *Outline) AttachAnnotation(meta *project.Metadata, rel string) {
+	if meta == nil {
+		return
+	}
+	mod := project.FindModule(meta.Modules, rel)
+	if mod == nil || mod.Annotation == nil {
+		return
+	}
+	o.Module = mod.Name
+	o.Annotation = mod.Annotation
+}
@@ -0,0 +1,153 @@
+package outline
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func TestClassify(t *testing.T) {
+	cases := map[string]Role{
+		"main.go":                 RoleSource,
+		"pkg/handler.ts":          RoleSource,
+		"pkg/handler_test.go":     RoleTest,
+		"web/app.spec.ts":         RoleTest,
+		"web/app.test.js":         RoleTest,
+		"py/test_utils.py":        RoleTest,
+		"pkg/testdata/input.json": RoleTest,
+		"tests/helpers.py":        RoleTest,
+		"README.md":               RoleDocs,
+		"docs/guide.rst":          RoleDocs,
+		"LICENSE":                 RoleDocs,
+		"go.mod":                  RoleConfig,
+		"config/app.yaml":         RoleConfig,
+		"Makefile":                RoleConfig,
+		"Dockerfile":              RoleConfig,
+		"package.json":            RoleConfig,
+	}
+	for p, want := range cases {
+		if got := Classify(p); got != want {
+			t.Errorf("Classify(%q) = %s, want %s", p, got, want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	fsys := fstest.MapFS{
+		"go.mod":    {Data: []byte("module example.com/x\n")},
+		"README.md": {Data: []byte("# x\n")},
+		"x.go": {Data: []byte(`package x
+
+// Client talks to the server.
+type Client struct{ addr string }
+
+type Handler interface{ Serve() }
+
+type ID = string
+
+type Set[T comparable] map[T]struct{}
+
+const Version, build = "1.0", "dev"
+
+var ErrClosed error
+
+func New(addr string) *Client { return &Client{addr: addr} }
+
+func (c *Client) Close() error { return nil }
+
+func (c *Client) dial() {}
+
+func helper() {}
+
+type private struct{}
+
+func (p private) Exported() {}
+`)},
+		"x_test.go":             {Data: []byte("package x\n")},
+		"internal/y/y.go":       {Data: []byte("package y\n\nfunc Y() int { return 0 }\n")},
+		"internal/y/broken.go":  {Data: []byte("package y\n\nfunc {\n")},
+		"internal/z/z.go":       {Data: []byte("package z\n\nfunc z() {}\n")},
+		"internal/y/testdata/a": {Data: []byte("fixture\n")},
+	}
+
+	o, err := Build(fsys, "x")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"internal", "internal/y", "internal/y/testdata", "internal/z"}, o.Directories); diff != "" {
+		t.Fatalf("unexpected directories (-want +got):\n%s", diff)
+	}
+
+	groups := make(map[Role][]string)
+	var roles []Role
+	var total int64
+	for _, g := range o.Groups {
+		roles = append(roles, g.Role)
+		var sum int64
+		for _, f := range g.Files {
+			groups[g.Role] = append(groups[g.Role], f.Path)
+			sum += f.Tokens
+		}
+		if sum != g.Tokens {
+			t.Fatalf("group %s holds %d tokens, its files %d", g.Role, g.Tokens, sum)
+		}
+		total += sum
+	}
+	if total != o.Tokens || total == 0 {
+		t.Fatalf("outline holds %d tokens, its groups %d", o.Tokens, total)
+	}
+	wantGroups := map[Role][]string{
+		RoleSource: {"internal/y/broken.go", "internal/y/y.go", "internal/z/z.go", "x.go"},
+		RoleTest:   {"internal/y/testdata/a", "x_test.go"},
+		RoleConfig: {"go.mod"},
+		RoleDocs:   {"README.md"},
+	}
+	if diff := cmp.Diff(wantGroups, groups); diff != "" {
+		t.Fatalf("unexpected groups (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]Role{RoleSource, RoleTest, RoleConfig, RoleDocs}, roles); diff != "" {
+		t.Fatalf("unexpected group order (-want +got):\n%s", diff)
+	}
+
+	wantAPI := []Package{
+		{Dir: ".", Name: "x", Declarations: []string{
+			"const Version",
+			"func (c *Client) Close() error",
+			"func New(addr string) *Client",
+			"type Client struct",
+			"type Handler interface",
+			"type ID = string",
+			"type Set[T comparable] map[T]struct{}",
+			"var ErrClosed error",
+		}},
+		{Dir: "internal/y", Name: "y", Declarations: []string{"func Y() int"}},
+	}
+	if diff := cmp.Diff(wantAPI, o.API); diff != "" {
+		t.Fatalf("unexpected API (-want +got):\n%s", diff)
+	}
+}
+
+func TestAttachAnnotation(t *testing.T) {
+	ann := &project.Annotation{InternalContext: "handles requests"}
+	meta := &project.Metadata{Modules: &project.Module{
+		Name: ".",
+		Modules: []*project.Module{
+			{Name: "svc", Annotation: ann},
+		},
+	}}
+
+	o := &Outline{}
+	o.AttachAnnotation(meta, "svc/internal")
+	if o.Module != "svc" || o.Annotation != ann {
+		t.Fatalf("expected the annotation of svc, got %q %+v", o.Module, o.Annotation)
+	}
+
+	o = &Outline{}
+	o.AttachAnnotation(meta, ".")
+	if o.Module != "" || o.Annotation != nil {
+		t.Fatalf("expected no annotation for the unannotated root, got %q %+v", o.Module, o.Annotation)
+	}
+}
@@ -0,0 +1,133 @@
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// largestFiles is the number of files listed in the token distribution.
+const largestFiles = 5
+
+// WriteJSON writes o as indented JSON.
+func WriteJSON(w io.Writer, o *Outline) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(o)
+}
+
+// WriteMarkdown renders o as a markdown document. The output only depends
+// on o, so it is stable across runs over the same tree.
+func WriteMarkdown(w io.Writer, o *Outline) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Outline of `%s`\n\n", o.Path)
+
+	if o.Annotation != nil {
+		fmt.Fprintf(&sb, "## Annotation of module `%s`\n\n", o.Module)
+		if !o.Annotation.GeneratedAt.IsZero() {
+			fmt.Fprintf(&sb, "Generated at %s.\n\n", o.Annotation.GeneratedAt.UTC().Format("2006-01-02 15:04 MST"))
+		}
+		for _, s := range []struct{ title, text string }{
+			{"External context", o.Annotation.ExternalContext},
+			{"Internal context", o.Annotation.InternalContext},
+			{"Public context", o.Annotation.PublicContext},
+		} {
+			if s.text == "" {
+				continue
+			}
+			fmt.Fprintf(&sb, "### %s\n\n%s\n\n", s.title, strings.TrimSpace(s.text))
+		}
+	}
+
+	sb.WriteString("## Layout\n\n")
+	if len(o.Directories) == 0 {
+		sb.WriteString("No sub-directories.\n\n")
+	} else {
+		for _, d := range o.Directories {
+			depth := strings.Count(d, "/")
+			fmt.Fprintf(&sb, "%s- %s/\n", strings.Repeat("  ", depth), d[strings.LastIndex(d, "/")+1:])
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("## Files\n\n")
+	if len(o.Groups) == 0 {
+		sb.WriteString("No files.\n\n")
+	}
+	for _, g := range o.Groups {
+		fmt.Fprintf(&sb, "### %s (%d files, %d tokens)\n\n", title(g.Role), len(g.Files), g.Tokens)
+		for _, f := range g.Files {
+			fmt.Fprintf(&sb, "- `%s` (%d tokens)\n", f.Path, f.Tokens)
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(o.API) > 0 {
+		sb.WriteString("## Go API\n\n")
+		for _, p := range o.API {
+			fmt.Fprintf(&sb, "### package %s (%s)\n\n", p.Name, p.Dir)
+			for _, d := range p.Declarations {
+				fmt.Fprintf(&sb, "- `%s`\n", d)
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	if o.Tokens > 0 {
+		sb.WriteString("## Token distribution\n\n")
+		if o.TokensEstimated {
+			fmt.Fprintf(&sb, "_Note: %s._\n\n", project.HeuristicTokensNote)
+		}
+		sb.WriteString("| Role | Files | Tokens | Share |\n|------|-------|--------|-------|\n")
+		for _, g := range o.Groups {
+			fmt.Fprintf(&sb, "| %s | %d | %d | %.1f%% |\n", g.Role, len(g.Files), g.Tokens, share(g.Tokens, o.Tokens))
+		}
+		fmt.Fprintf(&sb, "| total | %d | %d | 100.0%% |\n\n", fileCount(o), o.Tokens)
+
+		sb.WriteString("Largest files:\n\n")
+		for _, f := range largest(o, largestFiles) {
+			fmt.Fprintf(&sb, "- `%s` (%d tokens, %.1f%%)\n", f.Path, f.Tokens, share(f.Tokens, o.Tokens))
+		}
+	}
+
+	_, err := io.WriteString(w, strings.TrimRight(sb.String(), "\n")+"\n")
+	return err
+}
+
+func title(r Role) string {
+	return strings.ToUpper(string(r[:1])) + string(r[1:])
+}
+
+func share(n, total int64) float64 {
+	return float64(n) * 100 / float64(total)
+}
+
+func fileCount(o *Outline) int {
+	n := 0
+	for _, g := range o.Groups {
+		n += len(g.Files)
+	}
+	return n
+}
+
+// largest returns the n files with the most tokens, ties broken by path.
+func largest(o *Outline, n int) []File {
+	var all []File
+	for _, g := range o.Groups {
+		all = append(all, g.Files...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Tokens != all[j].Tokens {
+			return all[i].Tokens > all[j].Tokens
+		}
+		return all[i].Path < all[j].Path
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
@@ -0,0 +1,113 @@
+package outline
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func fixtureOutline() *Outline {
+	return &Outline{
+		Path:        "svc",
+		Directories: []string{"api", "api/v1", "docs"},
+		Groups: []Group{
+			{Role: RoleSource, Tokens: 300, Files: []File{{Path: "api/v1/handler.go", Tokens: 200}, {Path: "main.go", Tokens: 100}}},
+			{Role: RoleDocs, Tokens: 100, Files: []File{{Path: "docs/guide.md", Tokens: 100}}},
+		},
+		Tokens: 400,
+		API: []Package{
+			{Dir: "api/v1", Name: "v1", Declarations: []string{"func Handle(w io.Writer) error"}},
+		},
+		Module:     "svc",
+		Annotation: &project.Annotation{InternalContext: "Serves the API.", PublicContext: "Exposes Handle.", GeneratedAt: time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMarkdown(&buf, fixtureOutline()); err != nil {
+		t.Fatalf("WriteMarkdown: %v", err)
+	}
+	want := "# Outline of `svc`\n" +
+		"\n" +
+		"## Annotation of module `svc`\n" +
+		"\n" +
+		"Generated at 2025-03-01 10:00 UTC.\n" +
+		"\n" +
+		"### Internal context\n" +
+		"\n" +
+		"Serves the API.\n" +
+		"\n" +
+		"### Public context\n" +
+		"\n" +
+		"Exposes Handle.\n" +
+		"\n" +
+		"## Layout\n" +
+		"\n" +
+		"- api/\n" +
+		"  - v1/\n" +
+		"- docs/\n" +
+		"\n" +
+		"## Files\n" +
+		"\n" +
+		"### Source (2 files, 300 tokens)\n" +
+		"\n" +
+		"- `api/v1/handler.go` (200 tokens)\n" +
+		"- `main.go` (100 tokens)\n" +
+		"\n" +
+		"### Docs (1 files, 100 tokens)\n" +
+		"\n" +
+		"- `docs/guide.md` (100 tokens)\n" +
+		"\n" +
+		"## Go API\n" +
+		"\n" +
+		"### package v1 (api/v1)\n" +
+		"\n" +
+		"- `func Handle(w io.Writer) error`\n" +
+		"\n" +
+		"## Token distribution\n" +
+		"\n" +
+		"| Role | Files | Tokens | Share |\n" +
+		"|------|-------|--------|-------|\n" +
+		"| source | 2 | 300 | 75.0% |\n" +
+		"| docs | 1 | 100 | 25.0% |\n" +
+		"| total | 3 | 400 | 100.0% |\n" +
+		"\n" +
+		"Largest files:\n" +
+		"\n" +
+		"- `api/v1/handler.go` (200 tokens, 50.0%)\n" +
+		"- `docs/guide.md` (100 tokens, 25.0%)\n" +
+		"- `main.go` (100 tokens, 25.0%)\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("unexpected markdown (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteMarkdown_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMarkdown(&buf, &Outline{Path: "."}); err != nil {
+		t.Fatalf("WriteMarkdown: %v", err)
+	}
+	want := "# Outline of `.`\n\n## Layout\n\nNo sub-directories.\n\n## Files\n\nNo files.\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("unexpected markdown (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, fixtureOutline()); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var got Outline
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if diff := cmp.Diff(fixtureOutline(), &got); diff != "" {
+		t.Fatalf("JSON does not round-trip (-want +got):\n%s", diff)
+	}
+}
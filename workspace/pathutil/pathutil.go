@@ -0,0 +1,21 @@
+// Package pathutil holds the path predicates shared by the workspace and
+// command packages, so every containment check follows the same rules.
+package pathutil
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IsPathUnderDir reports whether p is dir itself or lies somewhere below
+// it. Both paths must be either absolute or relative to the same directory;
+// forward slashes are accepted on every platform. The comparison is made on
+// whole path elements, so "pkg/foobar" is not under "pkg/foo", and a
+// relative path escaping its base (e.g. "../x") is under no relative dir.
+func IsPathUnderDir(dir, p string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(p))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
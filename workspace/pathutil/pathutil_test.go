@@ -0,0 +1,48 @@
+package pathutil
+
+import "testing"
+
+func TestIsPathUnderDir(t *testing.T) {
+	cases := []struct {
+		dir, p string
+		want   bool
+	}{
+		// Relative paths.
+		{".", ".", true},
+		{".", "main.go", true},
+		{".", "pkg/foo/a.go", true},
+		{"", "pkg", true},
+		{"pkg", "pkg", true},
+		{"pkg", "pkg/", true},
+		{"pkg/", "pkg/a.go", true},
+		{"pkg/foo", "pkg/foo/a.go", true},
+		{"pkg/foo", "pkg/foo/bar/a.go", true},
+		{"pkg/foo", "pkg/foobar", false},
+		{"pkg/foo", "pkg/foobar/a.go", false},
+		{"pkg/foo", "pkg", false},
+		{"pkg/foo", "pkg/fo", false},
+		{"pkg", "other/pkg/a.go", false},
+		{"pkg", "./pkg/a.go", true},
+		{"pkg", "pkg/../other/a.go", false},
+		{"pkg", "pkg/sub/../a.go", true},
+		{".", "..", false},
+		{".", "../x", false},
+		{".", "..x", true},
+		{"pkg", "../pkg/a.go", false},
+		// Absolute paths.
+		{"/root", "/root", true},
+		{"/root", "/root/pkg/a.go", true},
+		{"/root/", "/root/pkg", true},
+		{"/root", "/rootfs/a.go", false},
+		{"/root/pkg", "/root", false},
+		{"/", "/any/where", true},
+		// Mixed absolute and relative paths never match.
+		{".", "/root/a.go", false},
+		{"/root", "a.go", false},
+	}
+	for _, c := range cases {
+		if got := IsPathUnderDir(c.dir, c.p); got != c.want {
+			t.Errorf("IsPathUnderDir(%q, %q) = %v, want %v", c.dir, c.p, got, c.want)
+		}
+	}
+}
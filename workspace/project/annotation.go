@@ -1,13 +1,19 @@
 package project
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/tiktoken-go/tokenizer"
 	"github.com/vybdev/vyb/config"
 	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/llm/payload"
 	"github.com/vybdev/vyb/logging"
+	"github.com/vybdev/vyb/workspace/pathutil"
 	"io/fs"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Annotation holds context and summary for a Module.
@@ -15,16 +21,51 @@ import (
 // InternalContext is an LLM-provided textual description of the content that lives within a given Module.
 // PublicContext is an LLM-provided textual description of content that his Module exposes for other modules to use.
 type Annotation struct {
-	ExternalContext string `yaml:"external-context"`
-	InternalContext string `yaml:"internal-context"`
-	PublicContext   string `yaml:"public-context"`
+	ExternalContext string `yaml:"external-context" json:"external_context"`
+	InternalContext string `yaml:"internal-context" json:"internal_context"`
+	PublicContext   string `yaml:"public-context" json:"public_context"`
+	// GeneratedAt records when the LLM last wrote any of the contexts, so
+	// users can tell how fresh the annotation is. Zero for annotations
+	// generated before it was recorded.
+	GeneratedAt time.Time `yaml:"generated-at,omitempty" json:"generated_at,omitzero"`
+	// Provider and ModelSize record the provenance of the annotation, the
+	// LLM provider and model tier that last wrote any of the contexts. Empty
+	// for annotations generated before it was recorded.
+	Provider  string           `yaml:"provider,omitempty" json:"provider,omitempty"`
+	ModelSize config.ModelSize `yaml:"model-size,omitempty" json:"model_size,omitempty"`
 }
 
+// stamp records when and by which provider and model tier a was written.
+func (a *Annotation) stamp(cfg *config.Config) {
+	a.GeneratedAt = timeNow()
+	if cfg != nil {
+		a.Provider = cfg.Provider
+		a.ModelSize = cfg.Annotation.Size()
+	}
+}
+
+// getModuleContext, getModuleContexts and getModuleExternalContexts are the
+// LLM entry-points used by annotate.
+// NOTE: they are vars (not direct calls) to allow test overrides.
+var getModuleContext = llm.GetModuleContext
+var getModuleContexts = llm.GetModuleContexts
+var getModuleExternalContexts = llm.GetModuleExternalContexts
+
+// NOTE: timeNow is a var (not a direct call) to allow test overrides.
+var timeNow = time.Now
+
 // annotate navigates the modules graph, starting from the leaf-most
 // modules back to the root. For each module that has no Annotation, it calls
 // addOrUpdateSelfContainedContext for it after all its submodules are annotated. The creation of
-// annotations is performed in parallel using goroutines.
-func annotate(cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
+// annotations is performed in parallel using goroutines, at most
+// Annotation.Concurrency of them calling the LLM at once. Modules below the
+// annotation.min_module_tokens threshold are skipped (see SkipsAnnotation),
+// their files being sent along with their parent's. Every LLM call is
+// reported to rep, along with the progress of every module when rep is a
+// ProgressReporter, and every generated annotation recorded in journal, when
+// not nil. Once ctx is done, no further module is annotated and the calls in
+// flight are aborted.
+func annotate(ctx context.Context, cfg *config.Config, metadata *Metadata, sysfs fs.FS, rep AnnotationReporter, journal *annotationJournal) error {
 	if metadata == nil || metadata.Modules == nil {
 		return nil
 	}
@@ -38,16 +79,40 @@ func annotate(cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
 	for _, m := range modules {
 		dones[m] = make(chan struct{})
 	}
+	// sem bounds the LLM calls in flight. It is only acquired once the
+	// submodules are done, so waiting parents never hold a slot.
+	concurrency := config.DefaultMaxConcurrency
+	if cfg != nil {
+		concurrency = cfg.Annotation.Concurrency()
+	}
+	sem := make(chan struct{}, concurrency)
+	progress := newProgressTracker(cfg, rep, modules)
+	// failed records the modules that could not be annotated, so their
+	// parents are not annotated (and journaled) without their contexts.
+	var failedMu sync.Mutex
+	failed := make(map[*Module]bool)
+	// Small projects are annotated in a single call when
+	// annotation.single_call_tokens allows it. The modules missing from its
+	// response are annotated one by one below.
+	if batch := singleCallModules(cfg, modules); batch != nil {
+		if err := addSelfContainedContexts(ctx, cfg, batch, sysfs, rep, progress, journal); err != nil {
+			return err
+		}
+	}
 	// Pre-close done channels for modules already annotated.
 	for _, m := range modules {
-		if m.Annotation != nil {
+		if !needsSelfContainedContext(m) || SkipsAnnotation(cfg, m) {
 			close(dones[m])
 		}
 	}
 
 	// Launch annotation tasks.
 	for _, m := range modules {
-		if m.Annotation != nil {
+		if SkipsAnnotation(cfg, m) {
+			logging.Log.Infof("module %q is below annotation.min_module_tokens, skipping...\n", m.Name)
+			continue
+		}
+		if !needsSelfContainedContext(m) {
 			logging.Log.Infof("module %q already has an annotation, skipping...\n", m.Name)
 			continue
 		}
@@ -55,12 +120,37 @@ func annotate(cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
 		// Capture m for the goroutine.
 		go func(mod *Module) {
 			// Wait for all submodules to complete.
+			skip := false
 			for _, sub := range mod.Modules {
 				<-dones[sub]
+				failedMu.Lock()
+				skip = skip || failed[sub]
+				failedMu.Unlock()
+			}
+			if skip {
+				failedMu.Lock()
+				failed[mod] = true
+				failedMu.Unlock()
+				close(dones[mod])
+				return
+			}
+			sem <- struct{}{}
+			err := ctx.Err()
+			if err == nil {
+				ev := progress.start(cfg, mod)
+				start := timeNow()
+				err = addOrUpdateSelfContainedContext(ctx, cfg, mod, sysfs, rep)
+				progress.finish(ev, timeNow().Sub(start), err)
+			}
+			<-sem
+			if err == nil {
+				err = journal.record(mod)
 			}
-			err := addOrUpdateSelfContainedContext(cfg, mod, sysfs)
 			if err != nil {
-				errCh <- fmt.Errorf("failed to create annotation for module %q: %w", mod.Name, err)
+				failedMu.Lock()
+				failed[mod] = true
+				failedMu.Unlock()
+				errCh <- &AnnotationError{Module: mod.Name, Cause: err}
 				// Signal done to avoid blocking parents.
 				close(dones[mod])
 				return
@@ -69,9 +159,13 @@ func annotate(cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
 		}(m)
 	}
 
-	// Wait for root module to finish annotation.
+	// Wait for every module to finish annotation. Waiting for the root
+	// alone is not enough: it is done from the start when only some of its
+	// descendants are regenerated.
+	for _, m := range modules {
+		<-dones[m]
+	}
 	root := metadata.Modules
-	<-dones[root]
 	close(errCh)
 
 	// Check for errors.
@@ -81,18 +175,76 @@ func annotate(cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
 		}
 	}
 
-	// Add all external context annotations in a single shot
-	// In the future, we should make this take into consideration
-	// the token count of the annotations and possibly split the calls.
-	return addOrUpdateExternalContext(cfg, root)
+	// Add all external context annotations, in batches fitting the
+	// configured token budget. The contexts of the batches that succeeded
+	// are journaled even when others failed.
+	err := addOrUpdateExternalContext(ctx, cfg, root, rep)
+	if jErr := journal.record(modules...); jErr != nil && err == nil {
+		err = jErr
+	}
+	return err
+}
+
+// needsSelfContainedContext reports whether the internal and public contexts
+// of m must be generated: m has no annotation, or only kept its external
+// context after files were moved within it.
+func needsSelfContainedContext(m *Module) bool {
+	return m.Annotation == nil || (m.Annotation.InternalContext == "" && m.Annotation.PublicContext == "")
+}
+
+// SkipsAnnotation reports whether m is intentionally left without an
+// annotation of its own: it is not the root module and holds fewer tokens
+// than annotation.min_module_tokens. Its files are part of the annotation of
+// its parent instead.
+func SkipsAnnotation(cfg *config.Config, m *Module) bool {
+	if cfg == nil || m == nil || m.Name == "." || cfg.Annotation.MinModuleTokens <= 0 {
+		return false
+	}
+	return m.TokenCount < int64(cfg.Annotation.MinModuleTokens)
+}
+
+// AnnotatedAncestor returns the module whose annotation describes m, a
+// module of the tree rooted at root: m itself when it has an annotation, or
+// its closest annotated ancestor otherwise, which is the case of the modules
+// skipped by SkipsAnnotation. It returns nil when no module on the way from
+// root has an annotation.
+func AnnotatedAncestor(root, m *Module) *Module {
+	if m == nil {
+		return nil
+	}
+	var closest *Module
+	for mod := root; mod != nil; {
+		if !needsSelfContainedContext(mod) {
+			closest = mod
+		}
+		if mod == m {
+			break
+		}
+		var next *Module
+		for _, child := range mod.Modules {
+			if child != nil && child.Name != "." && pathutil.IsPathUnderDir(child.Name, m.Name) {
+				next = child
+				break
+			}
+		}
+		mod = next
+	}
+	return closest
 }
 
 // collectModulesInPostOrder gathers modules in a post-order traversal (children first).
+// Every module is collected once, even if the graph is corrupted by a cycle
+// or a shared child.
 func collectModulesInPostOrder(root *Module) []*Module {
 	var result []*Module
+	visited := make(map[*Module]bool)
 	var traverse func(*Module)
 
 	traverse = func(m *Module) {
+		if m == nil || visited[m] {
+			return
+		}
+		visited[m] = true
 		for _, sub := range m.Modules {
 			traverse(sub)
 		}
@@ -103,14 +255,72 @@ func collectModulesInPostOrder(root *Module) []*Module {
 	return result
 }
 
-// addOrUpdateSelfContainedContext calls the LLM to construct the internal and public context of a given module.
-func addOrUpdateSelfContainedContext(cfg *config.Config, m *Module, sysfs fs.FS) error {
-	// Build the ModuleContextRequest for this module.
+// selfContainedFiles returns the files described by the self-contained
+// context of m, which include those of its sub-modules skipped by
+// SkipsAnnotation, and the sub-modules whose public contexts it builds on.
+func selfContainedFiles(cfg *config.Config, m *Module) (files []*FileRef, subModules []*Module) {
+	files = append(files, m.Files...)
+	for _, subMod := range m.Modules {
+		if !SkipsAnnotation(cfg, subMod) {
+			subModules = append(subModules, subMod)
+			continue
+		}
+		for _, mod := range collectAllModules(subMod) {
+			files = append(files, mod.Files...)
+		}
+	}
+	return files, subModules
+}
+
+// moduleContextSystemMessage instructs the LLM to summarize a module into
+// its internal and public contexts.
+const moduleContextSystemMessage = `You are a prompt engineer, structuring information about an application's code base 
+so context can be provided to an LLM in the most efficient way. 
+The user message contains information about a module in the application, as well as its immediate sub-modules.
+A module is a folder with files, and possibly other folders within it. 
+
+Module information includes:
+
+- Internal context: a description of the content that lives within the module. 
+This is used when an LLM prompt is constructed from a sub-module of this given module, 
+and the prompt is too large to include all files within the module. 
+So instead of providing all the file contents, the "Internal Context" is used as a summary. 
+The summary you will write for the module you are given will only take into consideration the files you see in the user 
+message, as those are the files included in the module. Do not include information about the sub-modules in the Internal Context.
+
+- Public context: a description of content that this module exposes for other modules to use. 
+This should encapsulate not only the contents of the module, but the contents of all its sub-modules.
+This is used when the LLM prompt is constructed from a module outside of the hierarchy of this given module.
+The "Public Context" can include snippets of interfaces, script parameters, or any useful information for the LLM to 
+understand how to work with a module. If the module you are given has any sub-modules, you will have access to their Public Context. 
+You will contruct a Public Context for the module you are given, and that should encapsulate not only the information 
+you included in the Internal Context, but also all the Public Context information from this module's sub-modules.
+
+Each type of context should be as descriptive as possible, using around one thousand LLM tokens, each.`
+
+// singleCallSystemMessage instructs the LLM to summarize several modules at
+// once, see addSelfContainedContexts.
+const singleCallSystemMessage = moduleContextSystemMessage + `
+
+The user message contains several modules, each under a heading naming it, sub-modules first. 
+Write the Internal Context and the Public Context of every one of them, named as in the user message. 
+A sub-module listed with an empty Public Context is one of the modules of the user message: 
+build on the Public Context you write for it.`
+
+// moduleContextRequest builds the request for the internal and public
+// contexts of m.
+func moduleContextRequest(cfg *config.Config, m *Module, sysfs fs.FS) (*payload.ModuleContextRequest, error) {
+	files, subModules := selfContainedFiles(cfg, m)
+	directories := m.Directories
+	if len(subModules) < len(m.Modules) {
+		directories = deriveDirectoriesFromFiles(files)
+	}
+
 	var targetFiles []payload.FileContent
-	for _, fileRef := range m.Files {
+	for _, fileRef := range files {
 		content, err := fs.ReadFile(sysfs, fileRef.Name)
 		if err != nil {
-			return fmt.Errorf("failed to read file %s: %w", fileRef.Name, err)
+			return nil, fmt.Errorf("failed to read file %s: %w", fileRef.Name, err)
 		}
 		targetFiles = append(targetFiles, payload.FileContent{
 			Path:    fileRef.Name,
@@ -119,7 +329,7 @@ func addOrUpdateSelfContainedContext(cfg *config.Config, m *Module, sysfs fs.FS)
 	}
 
 	var subContexts []payload.ModuleContext
-	for _, subMod := range m.Modules {
+	for _, subMod := range subModules {
 		var publicContext string
 		if subMod.Annotation != nil && subMod.Annotation.PublicContext != "" {
 			publicContext = subMod.Annotation.PublicContext
@@ -130,52 +340,51 @@ func addOrUpdateSelfContainedContext(cfg *config.Config, m *Module, sysfs fs.FS)
 		})
 	}
 
-	req := &payload.ModuleContextRequest{
+	return &payload.ModuleContextRequest{
 		TargetModuleName:         m.Name,
 		TargetModuleFiles:        targetFiles,
-		TargetModuleDirectories:  m.Directories,
+		TargetModuleDirectories:  directories,
 		SubModulesPublicContexts: subContexts,
+	}, nil
+}
+
+// addOrUpdateSelfContainedContext calls the LLM to construct the internal and public context of a given module.
+func addOrUpdateSelfContainedContext(ctx context.Context, cfg *config.Config, m *Module, sysfs fs.FS, rep AnnotationReporter) error {
+	req, err := moduleContextRequest(cfg, m, sysfs)
+	if err != nil {
+		return err
 	}
 
 	logging.Log.Infof("annotating module %q\n", m.Name)
 
-	// System prompt instructing the LLM to summarize code into JSON schema.
-	systemMessage := `You are a prompt engineer, structuring information about an application's code base 
-so context can be provided to an LLM in the most efficient way. 
-The user message contains information about a module in the application, as well as its immediate sub-modules.
-A module is a folder with files, and possibly other folders within it. 
-
-Module information includes:
-
-- Internal context: a description of the content that lives within the module. 
-This is used when an LLM prompt is constructed from a sub-module of this given module, 
-and the prompt is too large to include all files within the module. 
-So instead of providing all the file contents, the "Internal Context" is used as a summary. 
-The summary you will write for the module you are given will only take into consideration the files you see in the user 
-message, as those are the files included in the module. Do not include information about the sub-modules in the Internal Context.
-
-- Public context: a description of content that this module exposes for other modules to use. 
-This should encapsulate not only the contents of the module, but the contents of all its sub-modules.
-This is used when the LLM prompt is constructed from a module outside of the hierarchy of this given module.
-The "Public Context" can include snippets of interfaces, script parameters, or any useful information for the LLM to 
-understand how to work with a module. If the module you are given has any sub-modules, you will have access to their Public Context. 
-You will contruct a Public Context for the module you are given, and that should encapsulate not only the information 
-you included in the Internal Context, but also all the Public Context information from this module's sub-modules.
-
-Each type of context should be as descriptive as possible, using around one thousand LLM tokens, each.`
-
-	context, err := llm.GetModuleContext(cfg, systemMessage, req)
+	start := timeNow()
+	context, usage, err := getModuleContext(ctx, cfg, moduleContextSystemMessage, req)
+	reportCall(rep, m.Name, start, usage, err)
 
 	logging.Log.Infof("  Got response for module %q\n", m.Name)
 
 	if err != nil {
 		return fmt.Errorf("failed to call llm provider: %w", err)
 	}
+	return applySelfContainedContext(cfg, m, context)
+}
 
+// applySelfContainedContext stores the internal and public contexts
+// generated for m in its annotation.
+func applySelfContainedContext(cfg *config.Config, m *Module, context *payload.ModuleSelfContainedContext) error {
 	if m.Annotation == nil {
 		m.Annotation = &Annotation{}
 	}
 
+	limit, enc := contextTokenLimit(cfg), EncodingFor(cfg)
+	var err error
+	if context.InternalContext, err = enforceContextLimit(enc, context.InternalContext, limit, m.Name, "InternalContext"); err != nil {
+		return err
+	}
+	if context.PublicContext, err = enforceContextLimit(enc, context.PublicContext, limit, m.Name, "PublicContext"); err != nil {
+		return err
+	}
+
 	if context.InternalContext != "" {
 		if m.Annotation.InternalContext != "" {
 			logging.Log.Infof("  Overriding field `InternalContext` of module %q.\n", m.Name)
@@ -192,6 +401,111 @@ Each type of context should be as descriptive as possible, using around one thou
 		}
 		m.Annotation.PublicContext = context.PublicContext
 	}
+	m.Annotation.stamp(cfg)
+	return nil
+}
+
+// singleCallModules returns the modules of modules, in the same order, to
+// annotate in a single call: those needing a self-contained context, when
+// annotation.single_call_tokens is set and their files fit within it. It
+// returns nil when they do not fit or are fewer than two.
+func singleCallModules(cfg *config.Config, modules []*Module) []*Module {
+	if cfg == nil || cfg.Annotation.SingleCallTokens <= 0 {
+		return nil
+	}
+	var batch []*Module
+	var tokens int64
+	for _, m := range modules {
+		if !needsSelfContainedContext(m) || SkipsAnnotation(cfg, m) {
+			continue
+		}
+		files, _ := selfContainedFiles(cfg, m)
+		for _, f := range files {
+			tokens += f.TokenCount
+		}
+		batch = append(batch, m)
+	}
+	if len(batch) < 2 || tokens > int64(cfg.Annotation.SingleCallTokens) {
+		return nil
+	}
+	return batch
+}
+
+// errMissingContext reports a module left out of the response of the
+// single call annotating it.
+var errMissingContext = errors.New("missing from the response")
+
+// addSelfContainedContexts calls the LLM once to construct the internal and
+// public contexts of every module of batch, sub-modules first. Every
+// annotated module is recorded in journal. The modules missing from the
+// response, and their ancestors, are left without annotation, so annotate
+// makes a call for each.
+func addSelfContainedContexts(ctx context.Context, cfg *config.Config, batch []*Module, sysfs fs.FS, rep AnnotationReporter, progress *progressTracker, journal *annotationJournal) error {
+	top := batch[len(batch)-1].Name
+	req := &payload.ModuleContextsRequest{}
+	for _, m := range batch {
+		r, err := moduleContextRequest(cfg, m, sysfs)
+		if err != nil {
+			return &AnnotationError{Module: m.Name, Cause: err}
+		}
+		req.Modules = append(req.Modules, *r)
+	}
+
+	events := make([]ProgressEvent, len(batch))
+	for i, m := range batch {
+		events[i] = progress.start(cfg, m)
+	}
+	logging.Log.Infof("annotating %d modules in a single call\n", len(batch))
+	start := timeNow()
+	resp, usage, err := getModuleContexts(ctx, cfg, singleCallSystemMessage, req)
+	elapsed := timeNow().Sub(start)
+	reportCall(rep, top, start, usage, err)
+	if err != nil {
+		for _, ev := range events {
+			progress.finish(ev, elapsed, err)
+		}
+		return &AnnotationError{Module: top, Cause: fmt.Errorf("failed to call llm provider: %w", err)}
+	}
+
+	contexts := make(map[string]*payload.ModuleSelfContainedContext, len(resp.Modules))
+	for i := range resp.Modules {
+		contexts[resp.Modules[i].Name] = &resp.Modules[i]
+	}
+	// The ancestors of a missing module are annotated again after it, as
+	// their public contexts build on its own.
+	parents := make(map[*Module]*Module)
+	for _, m := range batch {
+		for _, sub := range m.Modules {
+			parents[sub] = m
+		}
+	}
+	retried := make(map[*Module]bool)
+	for _, m := range batch {
+		if c, ok := contexts[m.Name]; ok && (c.InternalContext != "" || c.PublicContext != "") {
+			continue
+		}
+		logging.Log.Warnf("module %q is missing from the single call response, annotating it on its own\n", m.Name)
+		for mod := m; mod != nil; mod = parents[mod] {
+			retried[mod] = true
+		}
+	}
+	missing := 0
+	for i, m := range batch {
+		if retried[m] {
+			progress.finish(events[i], elapsed, errMissingContext)
+			missing++
+			continue
+		}
+		err := applySelfContainedContext(cfg, m, contexts[m.Name])
+		if err == nil {
+			err = journal.record(m)
+		}
+		progress.finish(events[i], elapsed, err)
+		if err != nil {
+			return &AnnotationError{Module: m.Name, Cause: err}
+		}
+	}
+	progress.requeue(missing)
 	return nil
 }
 
@@ -204,12 +518,15 @@ Each type of context should be as descriptive as possible, using around one thou
 //  2. For every module gather its current InternalContext and PublicContext
 //     (if available) – this information is provided to the LLM so it can
 //     reason about how the module fits the overall hierarchy.
-//  3. Call the LLM to obtain an ExternalContext string for each module.
+//  3. Split the modules into batches fitting the configured token budget
+//     (see batchExternalContexts) and call the LLM for every batch that
+//     lacks an ExternalContext, concurrently.
 //  4. Persist the returned ExternalContext into the Annotation of the
 //     corresponding module, creating annotation objects when necessary.
 //
-// If the LLM call fails the error is propagated to the caller.
-func addOrUpdateExternalContext(cfg *config.Config, m *Module) error {
+// When some batches fail, the contexts returned for the others are still
+// persisted and the error names the failed batches.
+func addOrUpdateExternalContext(ctx context.Context, cfg *config.Config, m *Module, rep AnnotationReporter) error {
 	if m == nil {
 		return nil
 	}
@@ -218,8 +535,6 @@ func addOrUpdateExternalContext(cfg *config.Config, m *Module) error {
 	// 0. Early-exit optimisation – if EVERY module already has an
 	//    ExternalContext annotation we can skip the expensive LLM call.
 	// ------------------------------------------------------------
-	allHaveExternal := true
-
 	modules := collectAllModules(m)
 
 	// ------------------------------------------------------------
@@ -227,43 +542,31 @@ func addOrUpdateExternalContext(cfg *config.Config, m *Module) error {
 	// ------------------------------------------------------------
 	moduleMap := make(map[string]*Module, len(modules))
 	for _, mod := range modules {
-		if mod.Name != "." && (mod.Annotation == nil || strings.TrimSpace(mod.Annotation.ExternalContext) == "") {
-			allHaveExternal = false
-
-		}
 		moduleMap[mod.Name] = mod
 	}
 
-	if allHaveExternal {
+	if !anyNeedsExternalContext(modules) {
 		return nil // Nothing to do – everything is already annotated.
 	}
 
 	// ------------------------------------------------------------
-	// 2. Build request containing internal & public context that the
-	//    LLM will use to infer external context.
+	// 2. Build the requests containing internal & public context that
+	//    the LLM will use to infer external context.
 	// ------------------------------------------------------------
-	var modulesForRequest []payload.ModuleInfoForExternalContext
-	for _, mod := range modules {
-		var parentName string
-		if mod.Parent != nil {
-			parentName = mod.Parent.Name
-		}
-
-		var internalCtx, publicCtx string
-		if mod.Annotation != nil {
-			internalCtx = mod.Annotation.InternalContext
-			publicCtx = mod.Annotation.PublicContext
-		}
-
-		modulesForRequest = append(modulesForRequest, payload.ModuleInfoForExternalContext{
-			Name:            mod.Name,
-			ParentName:      parentName,
-			InternalContext: internalCtx,
-			PublicContext:   publicCtx,
-		})
+	limit := 0
+	if cfg != nil {
+		limit = cfg.Annotation.BatchTokenLimit()
 	}
-	request := &payload.ExternalContextsRequest{
-		Modules: modulesForRequest,
+	enc := EncodingFor(cfg)
+	batches, err := batchExternalContexts(m, limit, enc)
+	if err != nil {
+		return &AnnotationError{Module: m.Name, Cause: err}
+	}
+	var pending [][]*Module
+	for _, batch := range batches {
+		if anyNeedsExternalContext(batch) {
+			pending = append(pending, batch)
+		}
 	}
 
 	// ------------------------------------------------------------
@@ -283,37 +586,274 @@ concise explanation of where the module lives in the hierarchy and what lives
 
 Return your answer as JSON following the schema you have been provided.`
 
-	resp, err := llm.GetModuleExternalContexts(cfg, sysPrompt, request)
-	if err != nil {
-		return err
+	workers := config.DefaultExternalContextWorkers
+	if cfg != nil {
+		workers = cfg.Annotation.Workers()
+	}
+	responses := make([]*payload.ModuleExternalContextResponse, len(pending))
+	errs := make([]error, len(pending))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, batch := range pending {
+		wg.Add(1)
+		go func(i int, batch []*Module) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			start := timeNow()
+			var usage payload.UsageStats
+			responses[i], usage, errs[i] = getModuleExternalContexts(ctx, cfg, sysPrompt, externalContextsRequest(batch))
+			reportCall(rep, m.Name, start, usage, errs[i])
+		}(i, batch)
 	}
+	wg.Wait()
 
 	// ------------------------------------------------------------
 	// 4. Persist results back into the module annotations.
 	// ------------------------------------------------------------
-	for _, ext := range resp.Modules {
-		if mod, ok := moduleMap[ext.Name]; ok {
-			if mod.Annotation == nil {
-				mod.Annotation = &Annotation{}
+	contextLimit := contextTokenLimit(cfg)
+	var failed []error
+	for i, resp := range responses {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("batch %d/%d (%s): %w", i+1, len(pending), batchModuleNames(pending[i]), errs[i]))
+			continue
+		}
+		for _, ext := range resp.Modules {
+			if mod, ok := moduleMap[ext.Name]; ok {
+				if mod.Annotation == nil {
+					mod.Annotation = &Annotation{}
+				}
+				externalContext, err := enforceContextLimit(enc, ext.ExternalContext, contextLimit, ext.Name, "ExternalContext")
+				if err != nil {
+					return &AnnotationError{Module: ext.Name, Cause: err}
+				}
+				mod.Annotation.ExternalContext = externalContext
+				mod.Annotation.stamp(cfg)
+			} else {
+				logging.Log.Warnf("  WARNING: module %q not found in module map\n", ext.Name)
 			}
-			mod.Annotation.ExternalContext = ext.ExternalContext
-		} else {
-			logging.Log.Warnf("  WARNING: module %q not found in module map\n", ext.Name)
 		}
 	}
+	if len(failed) > 0 {
+		return &AnnotationError{Module: m.Name, Cause: errors.Join(failed...)}
+	}
 
 	return nil
 }
 
+// anyNeedsExternalContext reports whether a module of modules, the root
+// and the modules left without annotation aside, lacks an ExternalContext.
+func anyNeedsExternalContext(modules []*Module) bool {
+	for _, mod := range modules {
+		if mod.Name != "." && !needsSelfContainedContext(mod) && strings.TrimSpace(mod.Annotation.ExternalContext) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// externalContextInfo returns what the LLM is told about mod to infer its
+// external context.
+func externalContextInfo(mod *Module) payload.ModuleInfoForExternalContext {
+	info := payload.ModuleInfoForExternalContext{Name: mod.Name}
+	if mod.Parent != nil {
+		info.ParentName = mod.Parent.Name
+	}
+	if mod.Annotation != nil {
+		info.InternalContext = mod.Annotation.InternalContext
+		info.PublicContext = mod.Annotation.PublicContext
+	}
+	return info
+}
+
+// externalContextsRequest builds the request for the modules of batch,
+// leaving out those without annotation.
+func externalContextsRequest(batch []*Module) *payload.ExternalContextsRequest {
+	request := &payload.ExternalContextsRequest{}
+	for _, mod := range batch {
+		if needsSelfContainedContext(mod) {
+			continue
+		}
+		request.Modules = append(request.Modules, externalContextInfo(mod))
+	}
+	return request
+}
+
+// externalContextTokens estimates the tokens mod adds to an external
+// context request, in the encoding enc.
+func externalContextTokens(mod *Module, enc tokenizer.Encoding) (int, error) {
+	info := externalContextInfo(mod)
+	return CountTokensFor(enc, info.Name+"\n"+info.ParentName+"\n"+info.InternalContext+"\n"+info.PublicContext)
+}
+
+// batchExternalContexts splits the tree rooted at root into batches whose
+// estimated tokens fit within limit, visiting modules parent first. A
+// subtree is kept in a single batch when it fits in one, so parents and
+// children are described together where possible. A module exceeding
+// limit on its own gets a batch of its own. A limit of 0 puts every module
+// in a single batch. Tokens are counted in the encoding enc.
+func batchExternalContexts(root *Module, limit int, enc tokenizer.Encoding) ([][]*Module, error) {
+	modules := collectAllModules(root)
+	if limit <= 0 {
+		return [][]*Module{modules}, nil
+	}
+
+	tokens := make(map[*Module]int, len(modules))
+	for _, mod := range modules {
+		n, err := externalContextTokens(mod, enc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count tokens of module %s: %w", mod.Name, err)
+		}
+		tokens[mod] = n
+	}
+	subtree := make(map[*Module]int, len(modules))
+	for i := len(modules) - 1; i >= 0; i-- {
+		mod := modules[i]
+		subtree[mod] += tokens[mod]
+		if mod != root && mod.Parent != nil {
+			subtree[mod.Parent] += subtree[mod]
+		}
+	}
+
+	var batches [][]*Module
+	var current []*Module
+	used := 0
+	add := func(mods []*Module, n int) {
+		if len(current) > 0 && used+n > limit {
+			batches = append(batches, current)
+			current, used = nil, 0
+		}
+		current = append(current, mods...)
+		used += n
+	}
+	visited := make(map[*Module]bool)
+	var visit func(*Module)
+	visit = func(mod *Module) {
+		if mod == nil || visited[mod] {
+			return
+		}
+		if subtree[mod] <= limit {
+			tree := collectAllModules(mod)
+			for _, sub := range tree {
+				visited[sub] = true
+			}
+			add(tree, subtree[mod])
+			return
+		}
+		visited[mod] = true
+		add([]*Module{mod}, tokens[mod])
+		for _, child := range mod.Modules {
+			visit(child)
+		}
+	}
+	visit(root)
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches, nil
+}
+
+// batchModuleNames lists the names of the modules of batch, for errors.
+func batchModuleNames(batch []*Module) string {
+	names := make([]string, 0, len(batch))
+	for _, mod := range batch {
+		names = append(names, mod.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// reportCall reports to rep, when not nil, the LLM call for module started
+// at start.
+func reportCall(rep AnnotationReporter, module string, start time.Time, usage payload.UsageStats, err error) {
+	if rep != nil {
+		rep.AnnotationCall(module, timeNow().Sub(start), usage, err)
+	}
+}
+
+// truncationMarker is appended to context fields cut down by
+// enforceContextLimit.
+const truncationMarker = "\n\n[truncated: exceeded the configured annotation length]"
+
+// contextTokenLimit returns the cap on annotation context fields configured
+// in cfg, or the default cap when cfg is nil.
+func contextTokenLimit(cfg *config.Config) int {
+	if cfg == nil {
+		return config.DefaultMaxContextTokens
+	}
+	return cfg.Annotation.ContextTokenLimit()
+}
+
+// enforceContextLimit truncates text to at most limit tokens in the
+// encoding enc, marker included. A limit of 0 disables the check.
+func enforceContextLimit(enc tokenizer.Encoding, text string, limit int, module, field string) (string, error) {
+	if limit <= 0 || text == "" {
+		return text, nil
+	}
+	count, err := CountTokensFor(enc, text)
+	if err != nil {
+		return "", fmt.Errorf("failed to count tokens of %s: %w", field, err)
+	}
+	if count <= limit {
+		return text, nil
+	}
+	truncated, err := truncateToTokens(enc, text, limit)
+	if err != nil {
+		return "", fmt.Errorf("failed to truncate %s: %w", field, err)
+	}
+	logging.Log.Warnf("  Field `%s` of module %q has %d tokens, truncated to %d.\n", field, module, count, limit)
+	return truncated, nil
+}
+
+// truncateToTokens returns the longest prefix of text that, followed by
+// truncationMarker, fits within limit tokens in the encoding enc. The
+// prefix is cut at a whitespace boundary whenever one is available in its
+// second half.
+func truncateToTokens(enc tokenizer.Encoding, text string, limit int) (string, error) {
+	markerTokens, err := CountTokensFor(enc, truncationMarker)
+	if err != nil {
+		return "", err
+	}
+	budget := limit - markerTokens
+	if budget <= 0 {
+		return strings.TrimSpace(truncationMarker), nil
+	}
+
+	// Binary search the number of runes to keep.
+	runes := []rune(text)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		n, err := CountTokensFor(enc, string(runes[:mid]))
+		if err != nil {
+			return "", err
+		}
+		if n <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	prefix := string(runes[:lo])
+	if i := strings.LastIndexAny(prefix, " \t\n"); i > len(prefix)/2 {
+		prefix = prefix[:i]
+	}
+	return strings.TrimRight(prefix, " \t\n") + truncationMarker, nil
+}
+
 // collectAllModules returns a depth-first slice containing the provided module
-// and all of its children.
+// and all of its children, each collected once.
 func collectAllModules(root *Module) []*Module {
 	if root == nil {
 		return nil
 	}
 	var out []*Module
+	visited := make(map[*Module]bool)
 	var walk func(*Module)
 	walk = func(mod *Module) {
+		if mod == nil || visited[mod] {
+			return
+		}
+		visited[mod] = true
 		out = append(out, mod)
 		for _, child := range mod.Modules {
 			walk(child)
@@ -321,4 +861,4 @@ func collectAllModules(root *Module) []*Module {
 	}
 	walk(root)
 	return out
-}
\ No newline at end of file
+}
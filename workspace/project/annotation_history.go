@@ -0,0 +1,130 @@
+package project
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/vybdev/vyb/internal/diff"
+)
+
+// annotationHistoryDir holds one history file per module, relative to the
+// project root.
+const annotationHistoryDir = ".vyb/annotations-history"
+
+// maxAnnotationHistoryEntries and maxAnnotationHistoryBytes bound the ring
+// buffer kept for every module. The oldest versions are dropped first, but
+// the most recent version is always retained.
+var maxAnnotationHistoryEntries = 10
+var maxAnnotationHistoryBytes = 256 * 1024
+
+// AnnotationVersion is a single snapshot of a module's Annotation.
+type AnnotationVersion struct {
+	Timestamp  time.Time  `yaml:"timestamp" json:"timestamp"`
+	Annotation Annotation `yaml:"annotation" json:"annotation"`
+}
+
+// annotationHistory is the on-disk representation of a module's history.
+type annotationHistory struct {
+	Module   string              `yaml:"module"`
+	Versions []AnnotationVersion `yaml:"versions"`
+}
+
+// AnnotationChange describes how `vyb update` rewrote a module annotation.
+type AnnotationChange struct {
+	Module   string      `json:"module"`
+	Previous *Annotation `json:"previous,omitempty"`
+	Current  *Annotation `json:"current,omitempty"`
+	// InternalContextDiff is a word-level diff between the previous and
+	// current InternalContext (see diff.Words).
+	InternalContextDiff string `json:"internal_context_diff"`
+}
+
+func newAnnotationChange(module string, previous, current *Annotation) AnnotationChange {
+	var prevInternal, currInternal string
+	if previous != nil {
+		prevInternal = previous.InternalContext
+	}
+	if current != nil {
+		currInternal = current.InternalContext
+	}
+	return AnnotationChange{
+		Module:              module,
+		Previous:            previous,
+		Current:             current,
+		InternalContextDiff: diff.Words(prevInternal, currInternal),
+	}
+}
+
+// historyFilePath returns the history file for module. Module names contain
+// slashes, so the file name is derived from a hash of the name instead.
+func historyFilePath(projectRoot, module string) string {
+	h := md5.Sum([]byte(module))
+	return filepath.Join(projectRoot, annotationHistoryDir, hex.EncodeToString(h[:])+".yaml")
+}
+
+// LoadAnnotationHistory returns the stored annotation versions of module,
+// oldest first. A module without history yields an empty slice.
+func LoadAnnotationHistory(projectRoot, module string) ([]AnnotationVersion, error) {
+	h, err := readAnnotationHistory(projectRoot, module)
+	if err != nil {
+		return nil, err
+	}
+	return h.Versions, nil
+}
+
+func readAnnotationHistory(projectRoot, module string) (*annotationHistory, error) {
+	data, err := os.ReadFile(historyFilePath(projectRoot, module))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &annotationHistory{Module: module}, nil
+		}
+		return nil, fmt.Errorf("failed to read annotation history for module %q: %w", module, err)
+	}
+	var h annotationHistory
+	if err := yaml.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal annotation history for module %q: %w", module, err)
+	}
+	return &h, nil
+}
+
+// recordAnnotationVersion appends ann to the history of module, rotating
+// out the oldest versions once the entry count or size limits are hit.
+func recordAnnotationVersion(projectRoot, module string, ann Annotation, at time.Time) error {
+	h, err := readAnnotationHistory(projectRoot, module)
+	if err != nil {
+		return err
+	}
+	h.Versions = append(h.Versions, AnnotationVersion{Timestamp: at, Annotation: ann})
+
+	var data []byte
+	for {
+		if len(h.Versions) > maxAnnotationHistoryEntries {
+			h.Versions = h.Versions[1:]
+			continue
+		}
+		data, err = yaml.Marshal(h)
+		if err != nil {
+			return fmt.Errorf("failed to marshal annotation history for module %q: %w", module, err)
+		}
+		if len(data) <= maxAnnotationHistoryBytes || len(h.Versions) == 1 {
+			break
+		}
+		h.Versions = h.Versions[1:]
+	}
+
+	path := historyFilePath(projectRoot, module)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return &PersistError{Path: filepath.Dir(path), Cause: err}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return &PersistError{Path: path, Cause: err}
+	}
+	return nil
+}
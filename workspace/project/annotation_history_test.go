@@ -0,0 +1,76 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAnnotationVersion_Rotation(t *testing.T) {
+	oldEntries := maxAnnotationHistoryEntries
+	maxAnnotationHistoryEntries = 3
+	defer func() { maxAnnotationHistoryEntries = oldEntries }()
+
+	root := t.TempDir()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ann := Annotation{InternalContext: fmt.Sprintf("v%d", i)}
+		if err := recordAnnotationVersion(root, "dir/mod", ann, base.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("recordAnnotationVersion: %v", err)
+		}
+	}
+
+	got, err := LoadAnnotationHistory(root, "dir/mod")
+	if err != nil {
+		t.Fatalf("LoadAnnotationHistory: %v", err)
+	}
+	var contexts []string
+	for _, v := range got {
+		contexts = append(contexts, v.Annotation.InternalContext)
+	}
+	if strings.Join(contexts, ",") != "v2,v3,v4" {
+		t.Fatalf("expected the three most recent versions, got %v", contexts)
+	}
+
+	// Histories are kept per module.
+	other, err := LoadAnnotationHistory(root, "dir")
+	if err != nil {
+		t.Fatalf("LoadAnnotationHistory: %v", err)
+	}
+	if len(other) != 0 {
+		t.Fatalf("expected no history for unrelated module, got %d versions", len(other))
+	}
+}
+
+func TestRecordAnnotationVersion_SizeLimit(t *testing.T) {
+	oldBytes := maxAnnotationHistoryBytes
+	maxAnnotationHistoryBytes = 300
+	defer func() { maxAnnotationHistoryBytes = oldBytes }()
+
+	root := t.TempDir()
+	for i := 0; i < 4; i++ {
+		ann := Annotation{InternalContext: fmt.Sprintf("%d %s", i, strings.Repeat("x", 100))}
+		if err := recordAnnotationVersion(root, ".", ann, time.Now()); err != nil {
+			t.Fatalf("recordAnnotationVersion: %v", err)
+		}
+	}
+
+	got, err := LoadAnnotationHistory(root, ".")
+	if err != nil {
+		t.Fatalf("LoadAnnotationHistory: %v", err)
+	}
+	if len(got) == 0 || len(got) >= 4 {
+		t.Fatalf("expected size limit to drop old versions, got %d versions", len(got))
+	}
+	if !strings.HasPrefix(got[len(got)-1].Annotation.InternalContext, "3 ") {
+		t.Fatalf("most recent version must always be retained, got %q", got[len(got)-1].Annotation.InternalContext)
+	}
+}
+
+func TestNewAnnotationChange(t *testing.T) {
+	c := newAnnotationChange("mod", &Annotation{InternalContext: "parses JSON files"}, &Annotation{InternalContext: "parses YAML files"})
+	if c.InternalContextDiff != "parses [-JSON-] {+YAML+} files" {
+		t.Fatalf("unexpected diff %q", c.InternalContextDiff)
+	}
+}
@@ -0,0 +1,102 @@
+package project
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// AnnotationReporter is notified of every LLM call made while annotating
+// modules. Calls are made concurrently, so implementations must be safe
+// for concurrent use.
+type AnnotationReporter interface {
+	// AnnotationCall is called once the call made for module returned,
+	// with its latency, the token usage reported by the provider and the
+	// error it failed with, if any.
+	AnnotationCall(module string, elapsed time.Duration, usage payload.UsageStats, err error)
+}
+
+// AnnotationMetrics summarizes the LLM calls made while annotating modules.
+// Latencies are serialized in nanoseconds.
+type AnnotationMetrics struct {
+	Calls  int           `json:"calls"`
+	Failed int           `json:"failed,omitempty"`
+	Total  time.Duration `json:"total_ns"`
+	P50    time.Duration `json:"p50_ns"`
+	P95    time.Duration `json:"p95_ns"`
+	// Usage adds up the token usage of the calls.
+	Usage payload.UsageStats `json:"usage"`
+}
+
+// String formats m as a one-line summary.
+func (m AnnotationMetrics) String() string {
+	s := fmt.Sprintf("%d annotation call(s)", m.Calls)
+	if m.Failed > 0 {
+		s += fmt.Sprintf(", %d failed", m.Failed)
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	return s + fmt.Sprintf(", %s in total, p50 %s, p95 %s", round(m.Total), round(m.P50), round(m.P95))
+}
+
+// MetricsRecorder is an AnnotationReporter collecting AnnotationMetrics.
+// The zero value is ready to use.
+type MetricsRecorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	failed    int
+	usage     payload.UsageStats
+}
+
+// AnnotationCall records the latency and usage of a call.
+func (r *MetricsRecorder) AnnotationCall(_ string, elapsed time.Duration, usage payload.UsageStats, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, elapsed)
+	r.usage.Add(usage)
+	if err != nil {
+		r.failed++
+	}
+}
+
+// Metrics returns the summary of the calls recorded so far.
+func (r *MetricsRecorder) Metrics() AnnotationMetrics {
+	r.mu.Lock()
+	sorted := append([]time.Duration(nil), r.latencies...)
+	m := AnnotationMetrics{Calls: len(sorted), Failed: r.failed, Usage: r.usage}
+	r.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, d := range sorted {
+		m.Total += d
+	}
+	m.P50 = percentile(sorted, 50)
+	m.P95 = percentile(sorted, 95)
+	return m
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank
+// method, or 0 when sorted is empty.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// reporters fans every call out to several reporters, skipping nil ones.
+type reporters []AnnotationReporter
+
+func (rs reporters) AnnotationCall(module string, elapsed time.Duration, usage payload.UsageStats, err error) {
+	for _, r := range rs {
+		if r != nil {
+			r.AnnotationCall(module, elapsed, usage, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestAnnotate_ReportsCallCount(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.go":    {Data: []byte("package main\n")},
+		"pkg/a/a.go": {Data: []byte("package a\n")},
+		"pkg/b/b.go": {Data: []byte("package b\n")},
+	}
+	meta, err := buildMetadata(fsys)
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+
+	var calls atomic.Int32
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		calls.Add(1)
+		return &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}, payload.UsageStats{Calls: 1, PromptTokens: 100, CompletionTokens: 10, Cost: 0.5}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		calls.Add(1)
+		return &payload.ModuleExternalContextResponse{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	recorder := &MetricsRecorder{}
+	if err := annotate(context.Background(), config.Default(), meta, fsys, recorder, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+
+	// One call per module, plus the external contexts of the whole tree.
+	want := len(collectAllModules(meta.Modules)) + 1
+	metrics := recorder.Metrics()
+	if metrics.Calls != want || int(calls.Load()) != want {
+		t.Fatalf("expected %d calls, got metrics %+v and %d calls made", want, metrics, calls.Load())
+	}
+	if metrics.Failed != 0 {
+		t.Fatalf("expected no failed call, got %d", metrics.Failed)
+	}
+	// The external contexts call reports no usage.
+	modules := want - 1
+	wantUsage := payload.UsageStats{Calls: modules, PromptTokens: 100 * modules, CompletionTokens: 10 * modules, Cost: 0.5 * float64(modules)}
+	if metrics.Usage != wantUsage {
+		t.Fatalf("expected the usage of every call to be added up, got %+v, want %+v", metrics.Usage, wantUsage)
+	}
+}
+
+func TestMetricsRecorder_Metrics(t *testing.T) {
+	r := &MetricsRecorder{}
+	if m := r.Metrics(); m != (AnnotationMetrics{}) {
+		t.Fatalf("expected empty metrics, got %+v", m)
+	}
+	for i := 20; i >= 1; i-- {
+		var err error
+		if i == 3 {
+			err = errors.New("boom")
+		}
+		r.AnnotationCall("m", time.Duration(i)*time.Second, payload.UsageStats{Calls: 1, PromptTokens: i, CompletionTokens: 1}, err)
+	}
+
+	want := AnnotationMetrics{Calls: 20, Failed: 1, Total: 210 * time.Second, P50: 10 * time.Second, P95: 19 * time.Second,
+		Usage: payload.UsageStats{Calls: 20, PromptTokens: 210, CompletionTokens: 20}}
+	if m := r.Metrics(); m != want {
+		t.Fatalf("Metrics() = %+v, want %+v", m, want)
+	}
+}
+
+// progressRecorder is a ProgressReporter recording every event.
+type progressRecorder struct {
+	MetricsRecorder
+	mu     sync.Mutex
+	events []ProgressEvent
+}
+
+func (r *progressRecorder) AnnotationProgress(ev ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func TestAnnotate_ReportsProgress(t *testing.T) {
+	// root -> a, b -> b/c, d (already annotated)
+	root := &Module{Name: "."}
+	a := &Module{Name: "a", Parent: root, Files: []*FileRef{{Name: "a/a.go", TokenCount: 7}}}
+	b := &Module{Name: "b", Parent: root}
+	c := &Module{Name: "b/c", Parent: b}
+	d := &Module{Name: "d", Parent: root, Annotation: &Annotation{InternalContext: "i", PublicContext: "p"}}
+	root.Modules = []*Module{a, b, d}
+	b.Modules = []*Module{c}
+	fsys := fstest.MapFS{"a/a.go": {Data: []byte("package a\n")}}
+
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return &payload.ModuleExternalContextResponse{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	rec := &progressRecorder{}
+	if err := annotate(context.Background(), config.Default(), &Metadata{Modules: root}, fsys, reporters{rec}, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+
+	if len(rec.events) != 8 {
+		t.Fatalf("expected a started and a finished event for 4 modules, got %+v", rec.events)
+	}
+	// position of the started and finished events of every module.
+	started, finished := make(map[string]int), make(map[string]int)
+	indexes := make(map[int]bool)
+	for i, ev := range rec.events {
+		if ev.Total != 4 {
+			t.Fatalf("event %+v: expected a total of 4 modules", ev)
+		}
+		switch ev.Stage {
+		case ProgressStarted:
+			started[ev.Module] = i
+			indexes[ev.Index] = true
+		case ProgressFinished:
+			finished[ev.Module] = i
+			if ev.Err != nil {
+				t.Fatalf("event %+v: unexpected error", ev)
+			}
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		if !indexes[i] {
+			t.Fatalf("expected the started events to be numbered 1 to 4, got %+v", rec.events)
+		}
+	}
+	for _, m := range []string{".", "a", "b", "b/c"} {
+		s, okS := started[m]
+		f, okF := finished[m]
+		if !okS || !okF || s > f {
+			t.Fatalf("module %s: expected started before finished, got %+v", m, rec.events)
+		}
+	}
+	if finished["b/c"] > started["b"] || finished["a"] > started["."] || finished["b"] > started["."] {
+		t.Fatalf("expected sub-modules to finish before their parent starts, got %+v", rec.events)
+	}
+	if ev := rec.events[started["a"]]; ev.Tokens != 7 {
+		t.Fatalf("expected the tokens of a to be reported, got %+v", ev)
+	}
+	if rec.Metrics().Calls != 5 {
+		t.Fatalf("expected the calls to be reported too, got %+v", rec.Metrics())
+	}
+}
@@ -0,0 +1,114 @@
+package project
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// ProgressStage tells whether a ProgressEvent marks the start or the end of
+// the annotation of a module.
+type ProgressStage string
+
+const (
+	ProgressStarted  ProgressStage = "started"
+	ProgressFinished ProgressStage = "finished"
+)
+
+// ProgressEvent reports the annotation of a module starting or finishing.
+type ProgressEvent struct {
+	Stage  ProgressStage
+	Module string
+	// Index is the position of the module, from 1, in the order the
+	// annotations started. Total is the number of modules to annotate in
+	// the run: those lacking an annotation, minus the skipped ones.
+	Index int
+	Total int
+	// Tokens estimates the file tokens sent to the LLM for the module.
+	Tokens int64
+	// Elapsed and Err are only set when the annotation finished.
+	Elapsed time.Duration
+	Err     error
+}
+
+// ProgressReporter is an AnnotationReporter also notified of the progress
+// of the module annotations, so long runs can show how far along they are.
+// annotate reports to it when the AnnotationReporter it is given implements
+// it. Modules are annotated concurrently, so implementations must be safe
+// for concurrent use; the events of a module are always reported in order.
+type ProgressReporter interface {
+	AnnotationReporter
+	AnnotationProgress(ev ProgressEvent)
+}
+
+func (rs reporters) AnnotationProgress(ev ProgressEvent) {
+	for _, r := range rs {
+		if p, ok := r.(ProgressReporter); ok {
+			p.AnnotationProgress(ev)
+		}
+	}
+}
+
+// progressTracker numbers the module annotations of a run and reports them
+// to a ProgressReporter. The zero value reports nothing.
+type progressTracker struct {
+	rep   ProgressReporter
+	total int
+
+	mu      sync.Mutex
+	started int
+}
+
+// newProgressTracker returns the tracker of the annotation of modules,
+// reporting to rep when it is a ProgressReporter.
+func newProgressTracker(cfg *config.Config, rep AnnotationReporter, modules []*Module) *progressTracker {
+	p, ok := rep.(ProgressReporter)
+	if !ok {
+		return &progressTracker{}
+	}
+	total := 0
+	for _, m := range modules {
+		if needsSelfContainedContext(m) && !SkipsAnnotation(cfg, m) {
+			total++
+		}
+	}
+	return &progressTracker{rep: p, total: total}
+}
+
+// start reports that the annotation of m started and returns the event to
+// pass to finish.
+func (t *progressTracker) start(cfg *config.Config, m *Module) ProgressEvent {
+	if t.rep == nil {
+		return ProgressEvent{}
+	}
+	files, _ := selfContainedFiles(cfg, m)
+	var tokens int64
+	for _, f := range files {
+		tokens += f.TokenCount
+	}
+	t.mu.Lock()
+	t.started++
+	ev := ProgressEvent{Stage: ProgressStarted, Module: m.Name, Index: t.started, Total: t.total, Tokens: tokens}
+	t.mu.Unlock()
+	t.rep.AnnotationProgress(ev)
+	return ev
+}
+
+// requeue counts n more annotations in the run, for the modules whose
+// annotation is started again.
+func (t *progressTracker) requeue(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total += n
+}
+
+// finish reports that the annotation started by ev finished after elapsed,
+// with err.
+func (t *progressTracker) finish(ev ProgressEvent, elapsed time.Duration, err error) {
+	if t.rep == nil {
+		return
+	}
+	ev.Stage, ev.Elapsed, ev.Err = ProgressFinished, elapsed, err
+	t.rep.AnnotationProgress(ev)
+}
@@ -0,0 +1,496 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestAddOrUpdateSelfContainedContext_TruncatesOverlongFields(t *testing.T) {
+	overlong := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+	old := getModuleContext
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return &payload.ModuleSelfContainedContext{InternalContext: overlong, PublicContext: "short public context"}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext = old })
+
+	cfg := config.Default()
+	cfg.Annotation.MaxContextTokens = 50
+	mod := &Module{Name: "pkg"}
+	if err := addOrUpdateSelfContainedContext(context.Background(), cfg, mod, fstest.MapFS{}, nil); err != nil {
+		t.Fatalf("addOrUpdateSelfContainedContext: %v", err)
+	}
+
+	internal := mod.Annotation.InternalContext
+	if !strings.HasSuffix(internal, truncationMarker) {
+		t.Fatalf("expected truncation marker, got %q", internal)
+	}
+	if n, _ := CountTokensFor(EncodingFor(cfg), internal); n > 50 {
+		t.Fatalf("expected at most 50 tokens, got %d", n)
+	}
+	if !strings.HasPrefix(overlong, strings.TrimSuffix(internal, truncationMarker)) {
+		t.Fatalf("expected a prefix of the original context, got %q", internal)
+	}
+	if mod.Annotation.PublicContext != "short public context" {
+		t.Fatalf("expected short field to be kept as is, got %q", mod.Annotation.PublicContext)
+	}
+
+	// A negative limit disables the cap.
+	cfg.Annotation.MaxContextTokens = -1
+	if err := addOrUpdateSelfContainedContext(context.Background(), cfg, mod, fstest.MapFS{}, nil); err != nil {
+		t.Fatalf("addOrUpdateSelfContainedContext: %v", err)
+	}
+	if mod.Annotation.InternalContext != overlong {
+		t.Fatalf("expected untruncated context when the cap is disabled")
+	}
+}
+
+// useTime pins the time recorded in generated annotations to ts.
+func useTime(t *testing.T, ts time.Time) {
+	t.Helper()
+	old := timeNow
+	timeNow = func() time.Time { return ts }
+	t.Cleanup(func() { timeNow = old })
+}
+
+func TestAnnotation_GeneratedAt(t *testing.T) {
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return &payload.ModuleSelfContainedContext{InternalContext: "internal", PublicContext: "public"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return &payload.ModuleExternalContextResponse{Modules: []payload.ModuleExternalContext{{Name: "pkg", ExternalContext: "external"}}}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	cfg := config.Default()
+	mod := &Module{Name: "pkg"}
+	generated := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	useTime(t, generated)
+	if err := addOrUpdateSelfContainedContext(context.Background(), cfg, mod, fstest.MapFS{}, nil); err != nil {
+		t.Fatalf("addOrUpdateSelfContainedContext: %v", err)
+	}
+	if !mod.Annotation.GeneratedAt.Equal(generated) {
+		t.Fatalf("GeneratedAt = %s, want %s", mod.Annotation.GeneratedAt, generated)
+	}
+
+	external := generated.Add(time.Minute)
+	useTime(t, external)
+	if err := addOrUpdateExternalContext(context.Background(), cfg, mod, nil); err != nil {
+		t.Fatalf("addOrUpdateExternalContext: %v", err)
+	}
+	if !mod.Annotation.GeneratedAt.Equal(external) {
+		t.Fatalf("GeneratedAt = %s, want %s", mod.Annotation.GeneratedAt, external)
+	}
+
+	// Carried forward annotations keep their timestamp.
+	fresh := &Module{Name: "pkg", MD5: "same"}
+	mod.MD5 = "same"
+	mergeAnnotations(fresh, map[string]*Module{"pkg": mod})
+	if fresh.Annotation == nil || !fresh.Annotation.GeneratedAt.Equal(external) {
+		t.Fatalf("expected the merged annotation to keep its timestamp, got %+v", fresh.Annotation)
+	}
+}
+
+// externalContextTree returns . > {a > {a/x, a/y}, b}, every module holding
+// an internal context of words words.
+func externalContextTree(words int) *Module {
+	text := strings.Repeat("word ", words)
+	newMod := func(name string, parent *Module) *Module {
+		m := &Module{Name: name, Parent: parent, Annotation: &Annotation{InternalContext: text}}
+		if parent != nil {
+			parent.Modules = append(parent.Modules, m)
+		}
+		return m
+	}
+	root := newMod(".", nil)
+	a := newMod("a", root)
+	newMod("a/x", a)
+	newMod("a/y", a)
+	newMod("b", root)
+	return root
+}
+
+func TestBatchExternalContexts(t *testing.T) {
+	root := externalContextTree(100)
+	names := func(batches [][]*Module) [][]string {
+		var out [][]string
+		for _, b := range batches {
+			var n []string
+			for _, m := range b {
+				n = append(n, m.Name)
+			}
+			out = append(out, n)
+		}
+		return out
+	}
+	per, err := externalContextTokens(root, DefaultEncoding)
+	if err != nil {
+		t.Fatalf("externalContextTokens: %v", err)
+	}
+
+	cases := []struct {
+		limit int
+		want  [][]string
+	}{
+		{0, [][]string{{".", "a", "a/x", "a/y", "b"}}},
+		{5 * (per + 10), [][]string{{".", "a", "a/x", "a/y", "b"}}},
+		// The subtree of a fits, so a and its children stay together.
+		{3 * (per + 10), [][]string{{"."}, {"a", "a/x", "a/y"}, {"b"}}},
+		// Nothing but single modules fit.
+		{per + 10, [][]string{{"."}, {"a"}, {"a/x"}, {"a/y"}, {"b"}}},
+	}
+	for _, c := range cases {
+		batches, err := batchExternalContexts(root, c.limit, DefaultEncoding)
+		if err != nil {
+			t.Fatalf("limit %d: %v", c.limit, err)
+		}
+		if got := names(batches); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("limit %d: batches = %v, want %v", c.limit, got, c.want)
+		}
+	}
+}
+
+func TestAddOrUpdateExternalContext_PartialFailure(t *testing.T) {
+	root := externalContextTree(100)
+	per, err := externalContextTokens(root, EncodingFor(config.Default()))
+	if err != nil {
+		t.Fatalf("externalContextTokens: %v", err)
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	old := getModuleExternalContexts
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		resp := &payload.ModuleExternalContextResponse{}
+		for _, m := range req.Modules {
+			if m.Name == "b" {
+				return nil, payload.UsageStats{}, errors.New("context window exceeded")
+			}
+			resp.Modules = append(resp.Modules, payload.ModuleExternalContext{Name: m.Name, ExternalContext: "ext " + m.Name})
+		}
+		return resp, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleExternalContexts = old })
+
+	cfg := config.Default()
+	cfg.Annotation.ExternalContextBatchTokens = 3 * (per + 10)
+	err = addOrUpdateExternalContext(context.Background(), cfg, root, nil)
+	var annErr *AnnotationError
+	if !errors.As(err, &annErr) || !strings.Contains(err.Error(), "batch 2/2 (b)") {
+		t.Fatalf("expected an error naming the failed batch, got %v", err)
+	}
+	// The batch holding the root alone needs no external context.
+	if calls != 2 {
+		t.Fatalf("expected one call per pending batch, got %d", calls)
+	}
+	for _, mod := range collectAllModules(root) {
+		want := "ext " + mod.Name
+		if mod.Name == "." || mod.Name == "b" {
+			want = ""
+		}
+		if mod.Annotation.ExternalContext != want {
+			t.Fatalf("module %s: ExternalContext = %q, want %q", mod.Name, mod.Annotation.ExternalContext, want)
+		}
+	}
+}
+
+func TestAddOrUpdateExternalContext_ManyBatches(t *testing.T) {
+	text := strings.Repeat("word ", 100)
+	root := &Module{Name: ".", Annotation: &Annotation{InternalContext: text}}
+	for i := 0; i < 40; i++ {
+		parent := &Module{Name: fmt.Sprintf("m%02d", i), Parent: root, Annotation: &Annotation{InternalContext: text}}
+		parent.Modules = append(parent.Modules, &Module{Name: parent.Name + "/sub", Parent: parent, Annotation: &Annotation{InternalContext: text}})
+		root.Modules = append(root.Modules, parent)
+	}
+	per, err := externalContextTokens(root, EncodingFor(config.Default()))
+	if err != nil {
+		t.Fatalf("externalContextTokens: %v", err)
+	}
+
+	var mu sync.Mutex
+	calls, inFlight, maxInFlight := 0, 0, 0
+	seen := map[string]int{}
+	old := getModuleExternalContexts
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		mu.Lock()
+		calls++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		for _, m := range req.Modules {
+			seen[m.Name]++
+		}
+		mu.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		resp := &payload.ModuleExternalContextResponse{}
+		for _, m := range req.Modules {
+			resp.Modules = append(resp.Modules, payload.ModuleExternalContext{Name: m.Name, ExternalContext: "ext " + m.Name})
+		}
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return resp, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleExternalContexts = old })
+
+	cfg := config.Default()
+	cfg.Annotation.ExternalContextBatchTokens = 5 * (per + 10)
+	cfg.Annotation.ExternalContextWorkers = 3
+	if err := addOrUpdateExternalContext(context.Background(), cfg, root, nil); err != nil {
+		t.Fatalf("addOrUpdateExternalContext: %v", err)
+	}
+	// Two pairs of modules fit in a batch, plus the root in the first one.
+	if calls != 20 {
+		t.Fatalf("expected 20 batches, got %d", calls)
+	}
+	if maxInFlight > 3 {
+		t.Fatalf("expected at most 3 concurrent requests, got %d", maxInFlight)
+	}
+	for _, mod := range collectAllModules(root) {
+		if seen[mod.Name] != 1 {
+			t.Fatalf("module %s was sent %d times", mod.Name, seen[mod.Name])
+		}
+		if mod.Name != "." && mod.Annotation.ExternalContext != "ext "+mod.Name {
+			t.Fatalf("module %s: ExternalContext = %q", mod.Name, mod.Annotation.ExternalContext)
+		}
+	}
+
+	// Every module is annotated now, so nothing is sent again.
+	calls = 0
+	if err := addOrUpdateExternalContext(context.Background(), cfg, root, nil); err != nil {
+		t.Fatalf("addOrUpdateExternalContext: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no call once every module is annotated, got %d", calls)
+	}
+}
+
+func TestAnnotate_BoundsConcurrency(t *testing.T) {
+	root := &Module{Name: "."}
+	for i := 0; i < 12; i++ {
+		root.Modules = append(root.Modules, &Module{Name: string(rune('a' + i)), Parent: root})
+	}
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	rootStarted := false
+	childrenDone := 0
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		if req.TargetModuleName == "." {
+			rootStarted = childrenDone == len(root.Modules)
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		if req.TargetModuleName != "." {
+			childrenDone++
+		}
+		mu.Unlock()
+		return &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return &payload.ModuleExternalContextResponse{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	cfg := &config.Config{Annotation: config.Annotation{MaxConcurrency: 3}}
+	if err := annotate(context.Background(), cfg, &Metadata{Modules: root}, fstest.MapFS{}, nil, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+	if maxInFlight != 3 {
+		t.Fatalf("expected at most 3 concurrent calls, observed %d", maxInFlight)
+	}
+	if !rootStarted {
+		t.Fatalf("expected the root to be annotated after all its submodules")
+	}
+}
+
+func TestAnnotate_SkipsModulesBelowThreshold(t *testing.T) {
+	ref := func(name string, tokens int64) *FileRef {
+		return &FileRef{Name: name, TokenCount: tokens}
+	}
+	// root -> big, small -> small/sub
+	root := &Module{Name: ".", TokenCount: 650, Files: []*FileRef{ref("main.go", 100)}}
+	big := &Module{Name: "big", Parent: root, TokenCount: 400, Files: []*FileRef{ref("big/b.go", 400)}}
+	small := &Module{Name: "small", Parent: root, TokenCount: 150, Files: []*FileRef{ref("small/s.go", 100)}}
+	sub := &Module{Name: "small/sub", Parent: small, TokenCount: 50, Files: []*FileRef{ref("small/sub/x.go", 50)}}
+	root.Modules = []*Module{big, small}
+	small.Modules = []*Module{sub}
+	fsys := fstest.MapFS{
+		"main.go":        {Data: []byte("package main\n")},
+		"big/b.go":       {Data: []byte("package big\n")},
+		"small/s.go":     {Data: []byte("package small\n")},
+		"small/sub/x.go": {Data: []byte("package sub\n")},
+	}
+
+	var mu sync.Mutex
+	requests := make(map[string]*payload.ModuleContextRequest)
+	var external []string
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		mu.Lock()
+		requests[req.TargetModuleName] = req
+		mu.Unlock()
+		return &payload.ModuleSelfContainedContext{InternalContext: req.TargetModuleName + " internal", PublicContext: req.TargetModuleName + " public"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		resp := &payload.ModuleExternalContextResponse{}
+		for _, m := range req.Modules {
+			external = append(external, m.Name)
+			resp.Modules = append(resp.Modules, payload.ModuleExternalContext{Name: m.Name, ExternalContext: m.Name + " external"})
+		}
+		return resp, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	cfg := &config.Config{Annotation: config.Annotation{MinModuleTokens: 200}}
+	if err := annotate(context.Background(), cfg, &Metadata{Modules: root}, fsys, nil, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+
+	if len(requests) != 2 || requests["."] == nil || requests["big"] == nil {
+		t.Fatalf("expected only the root and big to be annotated, got requests for %v", sortedKeys(requests))
+	}
+	if small.Annotation != nil || sub.Annotation != nil {
+		t.Fatalf("expected the modules below the threshold to stay unannotated, got %+v and %+v", small.Annotation, sub.Annotation)
+	}
+	// The root describes the files of the skipped modules itself.
+	var files []string
+	for _, f := range requests["."].TargetModuleFiles {
+		files = append(files, f.Path)
+	}
+	if want := []string{"main.go", "small/s.go", "small/sub/x.go"}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("root request files = %v, want %v", files, want)
+	}
+	if subs := requests["."].SubModulesPublicContexts; len(subs) != 1 || subs[0].Name != "big" {
+		t.Fatalf("expected only big among the root sub-modules, got %+v", subs)
+	}
+	if !reflect.DeepEqual(external, []string{".", "big"}) {
+		t.Fatalf("expected external contexts for the annotated modules only, got %v", external)
+	}
+
+	if got := AnnotatedAncestor(root, sub); got != root {
+		t.Fatalf("AnnotatedAncestor(small/sub) = %v, want the root", got)
+	}
+	if got := AnnotatedAncestor(root, big); got != big {
+		t.Fatalf("AnnotatedAncestor(big) = %v, want big", got)
+	}
+}
+
+func TestAnnotate_SingleCall(t *testing.T) {
+	ref := func(name string, tokens int64) *FileRef {
+		return &FileRef{Name: name, TokenCount: tokens}
+	}
+	newTree := func() *Module {
+		// root -> a, b
+		root := &Module{Name: ".", Files: []*FileRef{ref("main.go", 100)}}
+		root.Modules = []*Module{
+			{Name: "a", Files: []*FileRef{ref("a/a.go", 100)}},
+			{Name: "b", Files: []*FileRef{ref("b/b.go", 100)}},
+		}
+		return root
+	}
+	fsys := fstest.MapFS{
+		"main.go": {Data: []byte("package main\n")},
+		"a/a.go":  {Data: []byte("package a\n")},
+		"b/b.go":  {Data: []byte("package b\n")},
+	}
+
+	var batched []*payload.ModuleContextsRequest
+	var single []string
+	// omit is left out of the response of the single call.
+	omit := ""
+	oldCtx, oldCtxs, oldExt := getModuleContext, getModuleContexts, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		single = append(single, req.TargetModuleName)
+		return &payload.ModuleSelfContainedContext{InternalContext: req.TargetModuleName + " internal", PublicContext: req.TargetModuleName + " public"}, payload.UsageStats{}, nil
+	}
+	getModuleContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextsRequest) (*payload.ModuleContextsResponse, payload.UsageStats, error) {
+		batched = append(batched, req)
+		resp := &payload.ModuleContextsResponse{}
+		for _, m := range req.Modules {
+			if m.TargetModuleName != omit {
+				resp.Modules = append(resp.Modules, payload.ModuleSelfContainedContext{Name: m.TargetModuleName, InternalContext: m.TargetModuleName + " internal", PublicContext: m.TargetModuleName + " public"})
+			}
+		}
+		return resp, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return &payload.ModuleExternalContextResponse{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleContexts, getModuleExternalContexts = oldCtx, oldCtxs, oldExt })
+
+	// Under the threshold, a single call annotates every module.
+	root := newTree()
+	rec := &progressRecorder{}
+	cfg := &config.Config{Annotation: config.Annotation{SingleCallTokens: 300}}
+	if err := annotate(context.Background(), cfg, &Metadata{Modules: root}, fsys, reporters{rec}, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+	if len(batched) != 1 || len(single) != 0 {
+		t.Fatalf("expected a single batched call, got %d batched and single calls for %v", len(batched), single)
+	}
+	var names []string
+	for _, m := range batched[0].Modules {
+		names = append(names, m.TargetModuleName)
+	}
+	if want := []string{"a", "b", "."}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected the modules sub-modules first, got %v", names)
+	}
+	if subs := batched[0].Modules[2].SubModulesPublicContexts; len(subs) != 2 || subs[0].Content != "" {
+		t.Fatalf("expected the root to list its sub-modules without context, got %+v", subs)
+	}
+	for _, m := range collectAllModules(root) {
+		if m.Annotation == nil || m.Annotation.InternalContext != m.Name+" internal" || m.Annotation.PublicContext != m.Name+" public" {
+			t.Fatalf("module %s: unexpected annotation %+v", m.Name, m.Annotation)
+		}
+	}
+	if rec.Metrics().Calls != 2 || len(rec.events) != 6 {
+		t.Fatalf("expected the batched and external calls, and the progress of 3 modules, got %+v and %+v", rec.Metrics(), rec.events)
+	}
+
+	// A module missing from the response is annotated on its own, and its
+	// parent again after it.
+	batched, omit = nil, "b"
+	root = newTree()
+	if err := annotate(context.Background(), cfg, &Metadata{Modules: root}, fsys, nil, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+	if len(batched) != 1 || !reflect.DeepEqual(single, []string{"b", "."}) {
+		t.Fatalf("expected b then the root to be annotated on their own, got %d batched and single calls for %v", len(batched), single)
+	}
+	if b := root.Modules[1]; b.Annotation == nil || b.Annotation.InternalContext != "b internal" {
+		t.Fatalf("unexpected annotation of b %+v", b.Annotation)
+	}
+
+	// Over the threshold, every module gets a call of its own.
+	batched, single = nil, nil
+	cfg.Annotation.SingleCallTokens = 299
+	if err := annotate(context.Background(), cfg, &Metadata{Modules: newTree()}, fsys, nil, nil); err != nil {
+		t.Fatalf("annotate: %v", err)
+	}
+	if len(batched) != 0 || len(single) != 3 {
+		t.Fatalf("expected a call per module, got %d batched and single calls for %v", len(batched), single)
+	}
+}
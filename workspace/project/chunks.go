@@ -0,0 +1,181 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// ContextKind names one of the three contexts of an Annotation.
+type ContextKind string
+
+const (
+	ContextInternal ContextKind = "internal"
+	ContextPublic   ContextKind = "public"
+	ContextExternal ContextKind = "external"
+)
+
+// DefaultChunkTokens is the chunk size used by `vyb export` when none is
+// given.
+const DefaultChunkTokens = 512
+
+// Chunk is a piece of a module annotation small enough to be embedded on its
+// own.
+type Chunk struct {
+	// SchemaVersion is payload.SchemaVersion, so consumers can detect
+	// breaking changes of the export format.
+	SchemaVersion string `json:"schema_version"`
+	// ID is derived from Module, Kind and ContentHash, so it only changes
+	// when the chunk text does. Downstream indexes can diff the ids of two
+	// exports to re-embed only what `vyb update` changed.
+	ID          string      `json:"id"`
+	Module      string      `json:"module"`
+	Kind        ContextKind `json:"kind"`
+	Text        string      `json:"text"`
+	TokenCount  int         `json:"token_count"`
+	ContentHash string      `json:"content_hash"`
+	// Files lists the files of the module the chunk describes.
+	Files []string `json:"files"`
+}
+
+// ExportChunks loads the metadata of the project at projectRoot and splits
+// its annotations into chunks of at most maxTokens tokens.
+func ExportChunks(projectRoot string, maxTokens int) ([]Chunk, error) {
+	meta, err := LoadMetadata(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	return BuildChunks(meta, maxTokens)
+}
+
+// BuildChunks splits the annotation of every module of meta into chunks of
+// at most maxTokens tokens. Modules are visited depth-first and, within a
+// module, contexts in internal, public, external order. The output is fully
+// determined by the metadata: the same annotations always yield the same
+// chunks. Identical chunks of the same module and kind are emitted once.
+func BuildChunks(meta *Metadata, maxTokens int) ([]Chunk, error) {
+	if maxTokens <= 0 {
+		return nil, fmt.Errorf("chunk token cap must be positive, got %d", maxTokens)
+	}
+	if meta == nil || meta.Modules == nil {
+		return nil, nil
+	}
+
+	var chunks []Chunk
+	for _, mod := range collectAllModules(meta.Modules) {
+		if mod.Annotation == nil {
+			continue
+		}
+		files := make([]string, 0, len(mod.Files))
+		for _, f := range mod.Files {
+			files = append(files, f.Name)
+		}
+		contexts := []struct {
+			kind ContextKind
+			text string
+		}{
+			{ContextInternal, mod.Annotation.InternalContext},
+			{ContextPublic, mod.Annotation.PublicContext},
+			{ContextExternal, mod.Annotation.ExternalContext},
+		}
+		for _, c := range contexts {
+			parts, err := splitText(c.text, maxTokens)
+			if err != nil {
+				return nil, fmt.Errorf("failed to split %s context of module %q: %w", c.kind, mod.Name, err)
+			}
+			seen := make(map[string]bool, len(parts))
+			for _, part := range parts {
+				count, err := CountTokens(part)
+				if err != nil {
+					return nil, err
+				}
+				hash := sha256.Sum256([]byte(part))
+				contentHash := hex.EncodeToString(hash[:])
+				if seen[contentHash] {
+					continue
+				}
+				seen[contentHash] = true
+				chunks = append(chunks, Chunk{
+					SchemaVersion: payload.SchemaVersion,
+					ID:            chunkID(mod.Name, c.kind, contentHash),
+					Module:        mod.Name,
+					Kind:          c.kind,
+					Text:          part,
+					TokenCount:    count,
+					ContentHash:   contentHash,
+					Files:         files,
+				})
+			}
+		}
+	}
+	return chunks, nil
+}
+
+func chunkID(module string, kind ContextKind, contentHash string) string {
+	sum := sha256.Sum256([]byte(module + "\x00" + string(kind) + "\x00" + contentHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// splitText splits text into parts of at most maxTokens tokens. Paragraphs
+// (separated by blank lines) are packed greedily; a paragraph that does not
+// fit on its own is split between words. A single word longer than
+// maxTokens is kept whole.
+func splitText(text string, maxTokens int) ([]string, error) {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+
+	var parts []string
+	var err error
+	current := ""
+	for _, p := range paragraphs {
+		if current, parts, err = pack(current, p, "\n\n", maxTokens, parts); err != nil {
+			return nil, err
+		}
+		if current != p {
+			continue
+		}
+		// p did not fit with the previous paragraphs, check it fits alone.
+		n, err := CountTokens(p)
+		if err != nil {
+			return nil, err
+		}
+		if n <= maxTokens {
+			continue
+		}
+		current = ""
+		for _, word := range strings.Fields(p) {
+			if current, parts, err = pack(current, word, " ", maxTokens, parts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if current != "" {
+		parts = append(parts, current)
+	}
+	return parts, nil
+}
+
+// pack appends unit to current, joined by sep, when the result fits within
+// maxTokens. Otherwise current is flushed to parts and unit starts the next
+// part.
+func pack(current, unit, sep string, maxTokens int, parts []string) (string, []string, error) {
+	if current == "" {
+		return unit, parts, nil
+	}
+	candidate := current + sep + unit
+	n, err := CountTokens(candidate)
+	if err != nil {
+		return "", nil, err
+	}
+	if n <= maxTokens {
+		return candidate, parts, nil
+	}
+	return unit, append(parts, current), nil
+}
@@ -0,0 +1,74 @@
+package project
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestSplitText(t *testing.T) {
+	long := strings.TrimSpace(strings.Repeat("alpha beta gamma delta ", 10))
+	text := "first paragraph here.\n\nsecond one.\n\n" + long
+
+	parts, err := splitText(text, 8)
+	if err != nil {
+		t.Fatalf("splitText: %v", err)
+	}
+	if parts[0] != "first paragraph here.\n\nsecond one." {
+		t.Fatalf("expected short paragraphs to be packed together, got %q", parts[0])
+	}
+	var words []string
+	for _, p := range parts {
+		if n, _ := CountTokens(p); n > 8 {
+			t.Errorf("chunk %q has %d tokens, want at most 8", p, n)
+		}
+		words = append(words, strings.Fields(p)...)
+	}
+	if strings.Join(words, " ") != strings.Join(strings.Fields(text), " ") {
+		t.Fatalf("chunks do not cover the original text:\n%v", parts)
+	}
+
+	again, _ := splitText(text, 8)
+	if strings.Join(again, "|") != strings.Join(parts, "|") {
+		t.Fatalf("splitting is not deterministic")
+	}
+}
+
+func TestBuildChunks_StableIDs(t *testing.T) {
+	newMeta := func(pkgInternal string) *Metadata {
+		pkg := &Module{Name: "pkg", Files: []*FileRef{{Name: "pkg/lib.go"}}, Annotation: &Annotation{
+			InternalContext: pkgInternal,
+			PublicContext:   "pkg exposes Greet.",
+		}}
+		root := &Module{Name: ".", Modules: []*Module{pkg}, Annotation: &Annotation{
+			InternalContext: "root internal.",
+			PublicContext:   "root public.",
+			ExternalContext: "",
+		}}
+		pkg.Parent = root
+		return &Metadata{Modules: root}
+	}
+
+	before, err := BuildChunks(newMeta("pkg internal, first version."), 16)
+	if err != nil {
+		t.Fatalf("BuildChunks: %v", err)
+	}
+	after, err := BuildChunks(newMeta("pkg internal, second version."), 16)
+	if err != nil {
+		t.Fatalf("BuildChunks: %v", err)
+	}
+	if len(before) != 4 || len(after) != 4 {
+		t.Fatalf("expected 4 chunks (empty contexts skipped), got %d and %d", len(before), len(after))
+	}
+
+	for i := range before {
+		changed := before[i].Module == "pkg" && before[i].Kind == ContextInternal
+		if same := before[i].ID == after[i].ID; same == changed {
+			t.Errorf("chunk %s/%s: id stable = %v, want %v", before[i].Module, before[i].Kind, same, !changed)
+		}
+	}
+	if got := before[2]; got.SchemaVersion != payload.SchemaVersion || got.Module != "pkg" || len(got.Files) != 1 || got.Files[0] != "pkg/lib.go" || got.TokenCount == 0 {
+		t.Fatalf("unexpected chunk: %+v", got)
+	}
+}
@@ -0,0 +1,54 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoMetadata is returned, wrapped, when .vyb/metadata.yaml cannot be
+// found: either the directory is not part of a vyb project or the project
+// was never initialized.
+var ErrNoMetadata = errors.New("project metadata not found")
+
+// ErrCorruptMetadata is returned, wrapped, when .vyb/metadata.yaml exists
+// but cannot be parsed.
+var ErrCorruptMetadata = errors.New("project metadata is corrupt")
+
+// ErrCorruptModuleGraph is returned, wrapped along with ErrCorruptMetadata,
+// when the module tree stored in .vyb/metadata.yaml is not a strict tree:
+// duplicate module names, a module reachable from more than one parent, a
+// cycle or a child whose name is not nested under its parent's.
+var ErrCorruptModuleGraph = errors.New("corrupt module graph")
+
+// AnnotationError reports a failure to generate the annotation of a module.
+type AnnotationError struct {
+	// Module is the name of the module being annotated. External contexts
+	// are generated for a whole module tree, in batches, in which case
+	// Module is the name of the tree's root.
+	Module string
+	Cause  error
+}
+
+func (e *AnnotationError) Error() string {
+	return fmt.Sprintf("failed to annotate module %q: %v", e.Module, e.Cause)
+}
+
+func (e *AnnotationError) Unwrap() error {
+	return e.Cause
+}
+
+// PersistError reports a failure to write (or remove) project state on
+// disk.
+type PersistError struct {
+	// Path is the file or directory that could not be written.
+	Path  string
+	Cause error
+}
+
+func (e *PersistError) Error() string {
+	return fmt.Sprintf("failed to persist %s: %v", e.Path, e.Cause)
+}
+
+func (e *PersistError) Unwrap() error {
+	return e.Cause
+}
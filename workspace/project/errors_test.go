@@ -0,0 +1,86 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestLoadMetadata_Errors(t *testing.T) {
+	root := t.TempDir()
+
+	_, err := LoadMetadata(root)
+	if !errors.Is(err, ErrNoMetadata) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNoMetadata wrapping fs.ErrNotExist, got %v", err)
+	}
+	if _, err := FindDistanceToRoot(root); !errors.Is(err, ErrNoMetadata) {
+		t.Fatalf("expected ErrNoMetadata from FindDistanceToRoot, got %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "metadata.yaml"), []byte("modules: [unterminated\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := LoadMetadata(root); !errors.Is(err, ErrCorruptMetadata) {
+		t.Fatalf("expected ErrCorruptMetadata, got %v", err)
+	}
+	if _, err := Update(root); !errors.Is(err, ErrCorruptMetadata) {
+		t.Fatalf("expected ErrCorruptMetadata from Update, got %v", err)
+	}
+}
+
+func TestUpdate_AnnotationError(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// Module "." without annotation, so Update must generate it.
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "metadata.yaml"), []byte("modules:\n  name: .\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	cause := errors.New("provider unavailable")
+	old := getModuleContext
+	getModuleContext = func(context.Context, *config.Config, string, *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return nil, payload.UsageStats{}, cause
+	}
+	t.Cleanup(func() { getModuleContext = old })
+
+	_, err := Update(root)
+	var annErr *AnnotationError
+	if !errors.As(err, &annErr) || annErr.Module != "." {
+		t.Fatalf("expected AnnotationError for module \".\", got %v", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected AnnotationError to wrap the provider error, got %v", err)
+	}
+}
+
+func TestCreate_PersistError(t *testing.T) {
+	root := t.TempDir()
+	// A file named .vyb prevents the configuration directory from being
+	// created.
+	if err := os.WriteFile(filepath.Join(root, ".vyb"), nil, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	err := Create(root, "openai")
+	var persistErr *PersistError
+	if !errors.As(err, &persistErr) || persistErr.Path != filepath.Join(root, ".vyb") {
+		t.Fatalf("expected PersistError for the .vyb directory, got %v", err)
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected PersistError to wrap the I/O error, got %v", err)
+	}
+}
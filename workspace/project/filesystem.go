@@ -1,10 +1,8 @@
 package project
 
 import (
-	"bufio"
 	"crypto/md5"
 	"fmt"
-	"io"
 	"io/fs"
 	"path/filepath"
 	"strings"
@@ -12,8 +10,8 @@ import (
 	"github.com/tiktoken-go/tokenizer"
 )
 
-// newFileRefFromFS creates a *project.FileRef with computed last-modified time, token count, and MD5.
-func newFileRefFromFS(fsys fs.FS, relPath string) (*FileRef, error) {
+// newFileRefFromFS creates a *project.FileRef with computed last-modified time, token count in the encoding enc, and MD5.
+func newFileRefFromFS(fsys fs.FS, relPath string, enc tokenizer.Encoding) (*FileRef, error) {
 	info, err := fs.Stat(fsys, relPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat file %s: %w", relPath, err)
@@ -24,18 +22,21 @@ func newFileRefFromFS(fsys fs.FS, relPath string) (*FileRef, error) {
 		return nil, fmt.Errorf("failed to read file %s: %w", relPath, err)
 	}
 
-	tCount, _ := getFileTokenCount(content)
+	tCount, _ := getFileTokenCount(enc, content)
 
-	hash, err := computeMd5(fsys, relPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute MD5 for %s: %w", relPath, err)
-	}
+	return newFileRef(relPath, info.ModTime(), int64(tCount), ContentHash(content)), nil
+}
 
-	return newFileRef(relPath, info.ModTime(), int64(tCount), hash), nil
+// ContentHash returns the hex MD5 digest of content, as recorded in the
+// FileRef of a file.
+func ContentHash(content []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(content))
 }
 
 // findOrCreateParentModule navigates from the root module down the path minus the last component.
-func findOrCreateParentModule(root *Module, relPath string) *Module {
+// When maxDepth is positive, the navigation stops that many levels below the root, so
+// files of deeper directories belong to their ancestor at maxDepth.
+func findOrCreateParentModule(root *Module, relPath string, maxDepth int) *Module {
 	parts := strings.Split(relPath, string(filepath.Separator))
 	if len(parts) < 1 {
 		return root
@@ -45,6 +46,9 @@ func findOrCreateParentModule(root *Module, relPath string) *Module {
 	if len(parentParts) == 0 {
 		return root
 	}
+	if maxDepth > 0 && len(parentParts) > maxDepth {
+		parentParts = parentParts[:maxDepth]
+	}
 
 	return navigateOrCreateModule(root, parentParts)
 }
@@ -106,28 +110,3 @@ func collapseModules(m *Module) {
 		}
 	}
 }
-
-// getFileTokenCount uses the tiktoken-go library to determine the token count.
-func getFileTokenCount(content []byte) (int, error) {
-	enc, err := tokenizer.Get(tokenizer.Cl100kBase)
-	if err != nil {
-		return 0, err
-	}
-	tokens, _, _ := enc.Encode(string(content))
-	return len(tokens), nil
-}
-
-func computeMd5(fsys fs.FS, path string) (string, error) {
-	f, err := fsys.Open(path)
-	if err != nil {
-		return "", err
-	}
-
-	defer f.Close()
-	hasher := md5.New()
-	_, err = io.Copy(hasher, bufio.NewReader(f))
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
-}
\ No newline at end of file
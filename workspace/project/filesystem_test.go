@@ -1,6 +1,9 @@
 package project
 
 import (
+	"fmt"
+	"path"
+	"strings"
 	"testing"
 	"testing/fstest"
 
@@ -9,8 +12,9 @@ import (
 )
 
 func TestBuildTree(t *testing.T) {
-
+	old := maxTokenCountPerModule
 	maxTokenCountPerModule = 5
+	t.Cleanup(func() { maxTokenCountPerModule = old })
 	memFS := fstest.MapFS{
 		"dir1/file1.txt":           {Data: []byte("test file 1")},
 		"dir1/dir2/file2.go":       {Data: []byte("package main\n\nfunc main() {}")},
@@ -26,7 +30,7 @@ func TestBuildTree(t *testing.T) {
 		"dir3/dir4/dir5/file3.txt",
 		"dir3/dir4/dir5/file4.txt",
 		"dir3/file5.md",
-	})
+	}, 0, DefaultEncoding)
 	if err != nil {
 		t.Fatalf("error building tree: %v", err)
 	}
@@ -104,7 +108,7 @@ func TestCollapseSingleChildFolders(t *testing.T) {
 		"dirA/dirB/ignored.txt":    {Data: []byte("this file is ignored and should not be included in the final data structure")},
 	}
 
-	rm, err := buildModuleFromFS(dirLayout, []string{"dirA/dirB/dirC/fileA.txt"})
+	rm, err := buildModuleFromFS(dirLayout, []string{"dirA/dirB/dirC/fileA.txt"}, 0, DefaultEncoding)
 	if err != nil {
 		t.Fatalf("unexpected error building tree: %v", err)
 	}
@@ -125,3 +129,41 @@ func TestCollapseSingleChildFolders(t *testing.T) {
 		t.Errorf("unexpected file path, got %s", folder.Files[0].Name)
 	}
 }
+
+func TestBuildMetadata_MaxModuleDepth(t *testing.T) {
+	// Keep every directory a module of its own, so only the depth cap
+	// aggregates them.
+	old := minTokenCountPerModule
+	minTokenCountPerModule = 0
+	t.Cleanup(func() { minTokenCountPerModule = old })
+
+	fsys := fstest.MapFS{
+		".vyb/config.yaml": {Data: []byte("modules:\n  max_depth: 5\n")},
+	}
+	dir := ""
+	for i := 0; i < 40; i++ {
+		dir = path.Join(dir, fmt.Sprintf("d%d", i))
+		fsys[path.Join(dir, "f.txt")] = &fstest.MapFile{Data: []byte("data")}
+	}
+
+	meta, err := buildMetadata(fsys)
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	modules := collectAllModules(meta.Modules)
+	if len(modules) != 6 {
+		t.Fatalf("expected the root and 5 nested modules, got %d", len(modules))
+	}
+	deepest := modules[0]
+	for _, m := range modules {
+		if n := len(strings.Split(m.Name, "/")); n > 5 {
+			t.Fatalf("module %q has %d segments, want at most 5", m.Name, n)
+		}
+		if len(m.Name) > len(deepest.Name) {
+			deepest = m
+		}
+	}
+	if deepest.Name != "d0/d1/d2/d3/d4" || len(deepest.Files) != 36 {
+		t.Fatalf("expected d0/d1/d2/d3/d4 to hold the 36 deepest files, got %q with %d", deepest.Name, len(deepest.Files))
+	}
+}
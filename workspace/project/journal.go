@@ -0,0 +1,122 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/vybdev/vyb/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// JournalFile holds, relative to the project root, the annotations
+// generated by a 'vyb update' that did not complete. They are restored by
+// the next update, for the modules whose content did not change since.
+const JournalFile = ".vyb/metadata.partial.yaml"
+
+// journalEntry is the annotation of a module, along with the MD5 of the
+// module it was generated for.
+type journalEntry struct {
+	MD5        string      `yaml:"md5"`
+	Annotation *Annotation `yaml:"annotation"`
+}
+
+// annotationJournal flushes every annotation to JournalFile as soon as it
+// is generated, so a failure or an interruption does not lose the
+// annotations already paid for. It is safe for concurrent use; a nil
+// journal records nothing.
+type annotationJournal struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]journalEntry
+}
+
+// openJournal loads the journal of the project at absRoot, left by an
+// interrupted update, or starts an empty one.
+func openJournal(absRoot string) (*annotationJournal, error) {
+	j := &annotationJournal{
+		path:    filepath.Join(absRoot, filepath.FromSlash(JournalFile)),
+		entries: make(map[string]journalEntry),
+	}
+	data, err := os.ReadFile(j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", JournalFile, err)
+	}
+	if err := yaml.Unmarshal(data, &j.entries); err != nil {
+		// The journal is only an optimization: start over rather than
+		// blocking the update.
+		logging.Log.Warnf("ignoring unreadable %s: %v", JournalFile, err)
+		j.entries = make(map[string]journalEntry)
+	}
+	return j, nil
+}
+
+// restore sets, on the modules missing their internal and public contexts,
+// the annotation journaled for the same content, and returns how many were
+// restored.
+func (j *annotationJournal) restore(modules map[string]*Module) int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	restored := 0
+	for name, e := range j.entries {
+		mod, ok := modules[name]
+		if !ok || mod.MD5 != e.MD5 || e.Annotation == nil || !needsSelfContainedContext(mod) {
+			continue
+		}
+		a := *e.Annotation
+		mod.Annotation = &a
+		restored++
+	}
+	return restored
+}
+
+// record journals the current annotation of every module given, and
+// flushes the journal to disk.
+func (j *annotationJournal) record(modules ...*Module) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, m := range modules {
+		if m.Annotation != nil {
+			a := *m.Annotation
+			j.entries[m.Name] = journalEntry{MD5: m.MD5, Annotation: &a}
+		}
+	}
+	data, err := yaml.Marshal(j.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", JournalFile, err)
+	}
+	// Write then rename, so an interruption never leaves a truncated
+	// journal behind.
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return &PersistError{Path: tmp, Cause: err}
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return &PersistError{Path: j.path, Cause: err}
+	}
+	return nil
+}
+
+// remove deletes the journal once its annotations are persisted in
+// .vyb/metadata.yaml.
+func (j *annotationJournal) remove() error {
+	if j == nil {
+		return nil
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return &PersistError{Path: j.path, Cause: err}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package project
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// languageExtensions maps the extension of source files to the language
+// they are written in. Documentation, data and configuration files are left
+// out, so they never make the primary language of a project.
+var languageExtensions = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".java":  "java",
+	".kt":    "kotlin",
+	".kts":   "kotlin",
+	".scala": "scala",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".cxx":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".php":   "php",
+	".swift": "swift",
+	".dart":  "dart",
+	".ex":    "elixir",
+	".exs":   "elixir",
+	".sh":    "shell",
+}
+
+// languageNames holds the display name of every language of
+// languageExtensions.
+var languageNames = map[string]string{
+	"go":         "Go",
+	"python":     "Python",
+	"javascript": "JavaScript",
+	"typescript": "TypeScript",
+	"java":       "Java",
+	"kotlin":     "Kotlin",
+	"scala":      "Scala",
+	"ruby":       "Ruby",
+	"rust":       "Rust",
+	"c":          "C",
+	"cpp":        "C++",
+	"csharp":     "C#",
+	"php":        "PHP",
+	"swift":      "Swift",
+	"dart":       "Dart",
+	"elixir":     "Elixir",
+	"shell":      "Shell",
+}
+
+// maxLanguages is the number of languages recorded in Metadata.Languages.
+const maxLanguages = 3
+
+// minLanguageShare is the share of the source files a language other than
+// the primary one must hold to be recorded.
+const minLanguageShare = 0.1
+
+// LanguageName returns the display name of the language id recorded in
+// Metadata.Languages, e.g. "C++" for "cpp".
+func LanguageName(id string) string {
+	if name, ok := languageNames[id]; ok {
+		return name
+	}
+	return id
+}
+
+// detectLanguages returns the languages of the source files among files,
+// by number of files, most used first: the primary language, then at most
+// two others holding a tenth of the source files each. Ties are broken by
+// name. It returns nothing when no file is a source file.
+func detectLanguages(files []string) []string {
+	counts := make(map[string]int)
+	total := 0
+	for _, f := range files {
+		if lang, ok := languageExtensions[strings.ToLower(path.Ext(f))]; ok {
+			counts[lang]++
+			total++
+		}
+	}
+	languages := make([]string, 0, len(counts))
+	for lang := range counts {
+		languages = append(languages, lang)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if counts[languages[i]] != counts[languages[j]] {
+			return counts[languages[i]] > counts[languages[j]]
+		}
+		return languages[i] < languages[j]
+	})
+	for i, lang := range languages {
+		if i == maxLanguages || (i > 0 && float64(counts[lang]) < minLanguageShare*float64(total)) {
+			return languages[:i]
+		}
+	}
+	return languages
+}
@@ -1,8 +1,10 @@
 package project
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/vybdev/vyb/config"
 	"io/fs"
@@ -12,9 +14,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/tiktoken-go/tokenizer"
 	"gopkg.in/yaml.v3"
 
-	"github.com/vybdev/vyb/workspace/context"
+	wscontext "github.com/vybdev/vyb/workspace/context"
+	"github.com/vybdev/vyb/workspace/pathutil"
 	"github.com/vybdev/vyb/workspace/selector"
 )
 
@@ -22,7 +26,51 @@ import (
 // file should exist within a given vyb project, and it should be located in
 // the .vyb/ directory under the project root directory.
 type Metadata struct {
-	Modules *Module `yaml:"modules"`
+	// Version identifies how module hashes were computed. Metadata written
+	// before versioning was introduced has Version 0.
+	Version int `yaml:"version,omitempty"`
+	// Languages lists the languages of the project source files, detected
+	// from their extensions, the primary one first (e.g. ["go", "python"]).
+	Languages []string `yaml:"languages,omitempty"`
+	Modules   *Module  `yaml:"modules"`
+}
+
+// PrimaryLanguage returns the language most of the project source files
+// are written in, or "" when none was detected.
+func (m *Metadata) PrimaryLanguage() string {
+	if len(m.Languages) == 0 {
+		return ""
+	}
+	return m.Languages[0]
+}
+
+// metadataVersion is the Version of metadata written by this code.
+//
+//   - 0: module hashes only cover the content of their files.
+//   - 1: module hashes also cover the path of every file and sub-module.
+const metadataVersion = 1
+
+// upgrade recomputes, once, the module hashes of metadata written with an
+// older hashing scheme. Hashes are derived from the stored file hashes, so
+// the stored snapshot is preserved: only the content changed since then is
+// reported by Patch, and annotations are kept.
+func (m *Metadata) upgrade() {
+	if m.Version >= metadataVersion {
+		return
+	}
+	rehash(m.Modules)
+	m.Version = metadataVersion
+}
+
+// rehash recomputes the MD5 of mod and its descendants from their files.
+func rehash(mod *Module) {
+	if mod == nil {
+		return
+	}
+	for _, child := range mod.Modules {
+		rehash(child)
+	}
+	mod.MD5 = computeHashFromChildren(mod.Modules, mod.Files)
 }
 
 // PatchResult summarizes the changes performed by the Patch method.
@@ -36,6 +84,9 @@ type PatchResult struct {
 type ModuleChange struct {
 	PreviousTokenCount int64
 	CurrentTokenCount  int64
+	// PathsOnly is true when files were moved or renamed within the
+	// module, but the content of the module is the same.
+	PathsOnly bool
 }
 
 // ChangePercentage returns the percentage change in token count for a module.
@@ -50,15 +101,37 @@ func (mc ModuleChange) ChangePercentage() float64 {
 // while preserving annotations. It also validates that the module hierarchy is consistent
 // and returns a summary of the changes.
 func (m *Metadata) Patch(other *Metadata) *PatchResult {
+	result := m.DryRunPatch(other)
+
+	walkMatchingModules(m.Modules, other.Modules, func(stored, fresh *Module) {
+		fresh.Annotation = stored.Annotation
+	})
+
+	m.Modules = other.Modules
+	m.Version = other.Version
+	m.Languages = other.Languages
+
+	return result
+}
+
+// DryRunPatch returns the summary Patch would produce, without modifying
+// either Metadata.
+func (m *Metadata) DryRunPatch(other *Metadata) *PatchResult {
 	result := &PatchResult{
 		ChangedModules: make(map[string]ModuleChange),
 	}
 
 	validateModuleSets(m.Modules, other.Modules, result)
 
-	patchModule(m.Modules, other.Modules, result)
-
-	m.Modules = other.Modules
+	walkMatchingModules(m.Modules, other.Modules, func(stored, fresh *Module) {
+		if stored.MD5 != fresh.MD5 {
+			result.ChangedModules[stored.Name] = ModuleChange{
+				PreviousTokenCount: stored.TokenCount,
+				CurrentTokenCount:  fresh.TokenCount,
+				PathsOnly:          stored.TokenCount == fresh.TokenCount && computeContentHash(stored) == computeContentHash(fresh),
+			}
+		}
+	})
 
 	return result
 }
@@ -82,24 +155,19 @@ func validateModuleSets(a, b *Module, result *PatchResult) {
 	}
 }
 
-func patchModule(stored, fresh *Module, result *PatchResult) {
+// walkMatchingModules calls fn for every pair of modules with the same name
+// reachable from stored and fresh through modules present in both trees.
+func walkMatchingModules(stored, fresh *Module, fn func(stored, fresh *Module)) {
 	if stored == nil || fresh == nil {
 		return
 	}
 
-	if stored.MD5 != fresh.MD5 {
-		result.ChangedModules[stored.Name] = ModuleChange{
-			PreviousTokenCount: stored.TokenCount,
-			CurrentTokenCount:  fresh.TokenCount,
-		}
-	}
-
-	fresh.Annotation = stored.Annotation
+	fn(stored, fresh)
 
 	for _, storedChild := range stored.Modules {
 		for _, freshChild := range fresh.Modules {
 			if storedChild.Name == freshChild.Name {
-				patchModule(storedChild, freshChild, result)
+				walkMatchingModules(storedChild, freshChild, fn)
 				break
 			}
 		}
@@ -163,13 +231,29 @@ func computeTokenCountFromChildren(modules []*Module, files []*FileRef) int64 {
 	return count
 }
 
+// computeHashFromChildren hashes the name and hash of every sub-module and
+// file, so moving or renaming a file changes the hash of its module even
+// when the content stays the same.
 func computeHashFromChildren(modules []*Module, files []*FileRef) string {
-	var hashes []string
+	var entries []string
 	for _, m := range modules {
-		hashes = append(hashes, m.MD5)
+		entries = append(entries, m.Name+"\x00"+m.MD5)
 	}
 	for _, f := range files {
-		hashes = append(hashes, f.MD5)
+		entries = append(entries, f.Name+"\x00"+f.MD5)
+	}
+	sort.Strings(entries)
+	return computeHashFromBytes([]byte(strings.Join(entries, "\n")))
+}
+
+// computeContentHash hashes the content of every file in the module tree
+// rooted at m, ignoring their paths.
+func computeContentHash(m *Module) string {
+	var hashes []string
+	for _, mod := range collectAllModules(m) {
+		for _, f := range mod.Files {
+			hashes = append(hashes, f.MD5)
+		}
 	}
 	sort.Strings(hashes)
 	return computeHashFromBytes([]byte(strings.Join(hashes, "")))
@@ -197,14 +281,6 @@ func newFileRef(name string, lastModified time.Time, tokenCount int64, md5 strin
 	}
 }
 
-var systemExclusionPatterns = []string{
-	".git/",
-	".gitignore",
-	".vyb/",
-	"LICENSE",
-	"go.sum",
-}
-
 // Create creates the project metadata configuration at the project root.
 // The function now also persists .vyb/config.yaml with the chosen LLM
 // provider so callers do not have to duplicate that logic.
@@ -213,6 +289,13 @@ var systemExclusionPatterns = []string{
 // exists.  If a ".vyb" folder exists in the root directory or any of its
 // subdirectories, this function returns an error.
 func Create(projectRoot string, provider string) error {
+	return CreateWithReporter(context.Background(), projectRoot, provider, nil)
+}
+
+// CreateWithReporter behaves like Create, reporting every LLM call made to
+// annotate the modules to rep, when not nil. Cancelling ctx aborts the
+// annotation.
+func CreateWithReporter(ctx context.Context, projectRoot string, provider string, rep AnnotationReporter) error {
 
 	if provider == "" {
 		provider = config.Default().Provider
@@ -229,7 +312,7 @@ func Create(projectRoot string, provider string) error {
 
 	configDir := filepath.Join(projectRoot, ".vyb")
 	if err := os.Mkdir(configDir, 0755); err != nil {
-		return fmt.Errorf("failed to create .vyb directory: %w", err)
+		return &PersistError{Path: configDir, Cause: err}
 	}
 
 	// ------------------------------------------------------------------
@@ -246,7 +329,7 @@ func Create(projectRoot string, provider string) error {
 	}
 	cfgPath := filepath.Join(configDir, "config.yaml")
 	if err := os.WriteFile(cfgPath, cfgBytes, 0644); err != nil {
-		return fmt.Errorf("failed to write config.yaml: %w", err)
+		return &PersistError{Path: cfgPath, Cause: err}
 	}
 
 	// ------------------------------------------------------------------
@@ -257,7 +340,7 @@ func Create(projectRoot string, provider string) error {
 		return fmt.Errorf("failed to build metadata: %w", err)
 	}
 
-	err = annotate(cfg, metadata, rootFS)
+	err = annotate(ctx, cfg, metadata, rootFS, rep, nil)
 	if err != nil {
 		return fmt.Errorf("failed to annotate metadata: %w", err)
 	}
@@ -269,7 +352,7 @@ func Create(projectRoot string, provider string) error {
 
 	metaFilePath := filepath.Join(configDir, "metadata.yaml")
 	if err := os.WriteFile(metaFilePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write metadata.yaml: %w", err)
+		return &PersistError{Path: metaFilePath, Cause: err}
 	}
 
 	return nil
@@ -292,20 +375,30 @@ func buildMetadata(fsys fs.FS) (*Metadata, error) {
 	// Build a minimal execution context anchored at workspace root so selector
 	// includes *all* files. We bypass constructor to avoid filesystem checks
 	// (unit-tests use fstest.MapFS).
-	ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "."}
+	ec := &wscontext.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "."}
 
-	selected, err := selector.Select(fsys, ec, systemExclusionPatterns, []string{"*"})
+	selected, err := selector.Select(fsys, ec, selector.SystemExclusionPatterns, []string{"*"})
 	if err != nil {
 		return nil, fmt.Errorf("failed during file selection: %w", err)
 	}
 
-	rootModule, err := buildModuleFromFS(fsys, selected)
+	// The module depth cap and the token encoding shape the hierarchy, so
+	// every caller must read them from the project itself to build the same
+	// modules.
+	cfg, err := config.LoadFS(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	rootModule, err := buildModuleFromFS(fsys, selected, cfg.Modules.Depth(), EncodingFor(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to build summary module tree: %w", err)
 	}
 
 	metadata := &Metadata{
-		Modules: rootModule,
+		Version:   metadataVersion,
+		Languages: detectLanguages(selected),
+		Modules:   rootModule,
 	}
 	return metadata, nil
 }
@@ -316,13 +409,20 @@ func buildMetadata(fsys fs.FS) (*Metadata, error) {
 func loadStoredMetadata(fsys fs.FS) (*Metadata, error) {
 	data, err := fs.ReadFile(fsys, ".vyb/metadata.yaml")
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrNoMetadata, err)
+		}
 		return nil, fmt.Errorf("failed to read metadata file .vyb/metadata.yaml: %w", err)
 	}
 
 	var meta Metadata
 	if err := yaml.Unmarshal(data, &meta); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal metadata from .vyb/metadata.yaml: %w", err)
+		return nil, fmt.Errorf("%w: failed to unmarshal .vyb/metadata.yaml: %w", ErrCorruptMetadata, err)
+	}
+	if err := validateModuleGraph(meta.Modules); err != nil {
+		return nil, fmt.Errorf("%w: .vyb/metadata.yaml: %w", ErrCorruptMetadata, err)
 	}
+	meta.upgrade()
 
 	return &meta, nil
 }
@@ -369,7 +469,7 @@ func Remove(projectRoot string) error {
 	}
 
 	if err := os.RemoveAll(configDir); err != nil {
-		return fmt.Errorf("failed to remove .vyb directory: %w", err)
+		return &PersistError{Path: configDir, Cause: err}
 	}
 
 	return nil
@@ -455,8 +555,9 @@ func rebuildModule(old *Module, parent *Module) *Module {
 }
 
 // buildModuleFromFS constructs a hierarchy of Modules and Files for the given path entries.
-// It returns the Module representing the root folder.
-func buildModuleFromFS(fsys fs.FS, pathEntries []string) (*Module, error) {
+// It returns the Module representing the root folder. When maxDepth is positive, no module
+// name has more than maxDepth segments: deeper files are aggregated into their ancestor.
+func buildModuleFromFS(fsys fs.FS, pathEntries []string, maxDepth int, enc tokenizer.Encoding) (*Module, error) {
 	// First, create a basic tree with empty token information so we can easily
 	// attach files to the correct folder hierarchy.
 	root := &Module{Name: ".", Modules: []*Module{}, Files: []*FileRef{}}
@@ -474,12 +575,12 @@ func buildModuleFromFS(fsys fs.FS, pathEntries []string) (*Module, error) {
 			continue
 		}
 
-		fileRef, err := newFileRefFromFS(fsys, entry)
+		fileRef, err := newFileRefFromFS(fsys, entry, enc)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build file object for %s: %w", entry, err)
 		}
 
-		parent := findOrCreateParentModule(root, entry)
+		parent := findOrCreateParentModule(root, entry, maxDepth)
 		parent.Files = append(parent.Files, fileRef)
 	}
 
@@ -498,12 +599,73 @@ func buildModuleFromFS(fsys fs.FS, pathEntries []string) (*Module, error) {
 	return rebuildModule(rebuilt, nil), nil
 }
 
+// collectModuleNames records the name of m and of all its descendants into
+// set. Names already in set are not descended into again, which guards
+// against cycles.
 func collectModuleNames(m *Module, set map[string]struct{}) {
 	if m == nil {
 		return
 	}
+	if _, seen := set[m.Name]; seen {
+		return
+	}
 	set[m.Name] = struct{}{}
 	for _, c := range m.Modules {
 		collectModuleNames(c, set)
 	}
 }
+
+// validateModuleGraph checks that the module tree rooted at root is a strict
+// tree: module names are unique, every module has a single parent, there are
+// no cycles and each child is nested under its parent, both by name and by
+// Parent pointer when set. The returned error wraps ErrCorruptModuleGraph and
+// names the offending module.
+func validateModuleGraph(root *Module) error {
+	names := make(map[string]struct{})
+	visited := make(map[*Module]bool) // true while the module is on the stack
+	var walk func(m, parent *Module) error
+	walk = func(m, parent *Module) error {
+		if m == nil {
+			return fmt.Errorf("%w: module %q has a nil sub-module", ErrCorruptModuleGraph, parent.Name)
+		}
+		if onStack, seen := visited[m]; seen {
+			if onStack {
+				return fmt.Errorf("%w: module %q is its own ancestor", ErrCorruptModuleGraph, m.Name)
+			}
+			return fmt.Errorf("%w: module %q has more than one parent", ErrCorruptModuleGraph, m.Name)
+		}
+		if _, dup := names[m.Name]; dup {
+			return fmt.Errorf("%w: duplicate module name %q", ErrCorruptModuleGraph, m.Name)
+		}
+		if parent != nil {
+			if !isModuleNameUnder(parent.Name, m.Name) {
+				return fmt.Errorf("%w: module %q is not nested under its parent %q", ErrCorruptModuleGraph, m.Name, parent.Name)
+			}
+			if m.Parent != nil && m.Parent.Name != parent.Name {
+				return fmt.Errorf("%w: module %q records parent %q but is a sub-module of %q", ErrCorruptModuleGraph, m.Name, m.Parent.Name, parent.Name)
+			}
+		}
+		visited[m] = true
+		names[m.Name] = struct{}{}
+		for _, c := range m.Modules {
+			if err := walk(c, m); err != nil {
+				return err
+			}
+		}
+		visited[m] = false
+		return nil
+	}
+	if root == nil {
+		return nil
+	}
+	return walk(root, nil)
+}
+
+// isModuleNameUnder reports whether the module named child may be a
+// descendant of the module named parent.
+func isModuleNameUnder(parent, child string) bool {
+	if child == "." || child == parent {
+		return false
+	}
+	return pathutil.IsPathUnderDir(parent, child)
+}
@@ -1,18 +1,21 @@
 package project
 
 import (
+    "errors"
     "fmt"
     "gopkg.in/yaml.v3"
     "io/fs"
     "os"
     "path/filepath"
-    "strings"
+
+    "github.com/vybdev/vyb/workspace/pathutil"
 )
 
 // LoadMetadata reads .vyb/metadata.yaml under the provided absolute
 // project root directory and unmarshals it into a *Metadata.  The
 // function returns an error when the metadata file cannot be found or
-// parsed.
+// parsed, or when its module tree is not a strict tree
+// (ErrCorruptModuleGraph).
 func LoadMetadata(projectRoot string) (*Metadata, error) {
     if projectRoot == "" {
         return nil, fmt.Errorf("projectRoot must not be empty")
@@ -26,12 +29,19 @@ func LoadMetadata(projectRoot string) (*Metadata, error) {
 func LoadMetadataFS(fsys fs.FS) (*Metadata, error) {
     data, err := fs.ReadFile(fsys, ".vyb/metadata.yaml")
     if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil, fmt.Errorf("%w: %w", ErrNoMetadata, err)
+        }
         return nil, fmt.Errorf("failed to read metadata.yaml: %w", err)
     }
     var m Metadata
     if err := yaml.Unmarshal(data, &m); err != nil {
-        return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+        return nil, fmt.Errorf("%w: failed to unmarshal metadata: %w", ErrCorruptMetadata, err)
     }
+    if err := validateModuleGraph(m.Modules); err != nil {
+        return nil, fmt.Errorf("%w: %w", ErrCorruptMetadata, err)
+    }
+    m.upgrade()
     return &m, nil
 }
 
@@ -54,10 +64,16 @@ func FindModule(root *Module, relPath string) *Module {
     // not belong to any nested sub-module.
     best := root
 
+    // visited guards against cycles in a corrupted module graph.
+    visited := map[*Module]bool{root: true}
     var dfs func(*Module)
     dfs = func(m *Module) {
         for _, c := range m.Modules {
-            if relPath == c.Name || (c.Name != "." && strings.HasPrefix(relPath, c.Name+"/")) {
+            if c == nil || visited[c] {
+                continue
+            }
+            visited[c] = true
+            if c.Name != "." && pathutil.IsPathUnderDir(c.Name, relPath) {
                 best = c
             }
             dfs(c)
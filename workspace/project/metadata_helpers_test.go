@@ -1,6 +1,11 @@
 package project
 
-import "testing"
+import (
+    "errors"
+    "strings"
+    "testing"
+    "testing/fstest"
+)
 
 func TestFindModule_RootFile(t *testing.T) {
     // build a tiny hierarchy: root (.) with child "dir"
@@ -18,3 +23,76 @@ func TestFindModule_RootFile(t *testing.T) {
         t.Fatalf("expected child module for nested file, got %v", got)
     }
 }
+
+func TestLoadMetadataFS_CorruptModuleGraph(t *testing.T) {
+    cases := map[string]struct {
+        yaml   string
+        module string
+    }{
+        "duplicate name": {
+            yaml:   "modules:\n  name: .\n  modules:\n    - name: a\n    - name: a\n",
+            module: `"a"`,
+        },
+        "not nested": {
+            yaml:   "modules:\n  name: .\n  modules:\n    - name: a\n      modules:\n        - name: b/c\n",
+            module: `"b/c"`,
+        },
+    }
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            fsys := fstest.MapFS{".vyb/metadata.yaml": {Data: []byte(tc.yaml)}}
+            _, err := LoadMetadataFS(fsys)
+            if !errors.Is(err, ErrCorruptModuleGraph) || !errors.Is(err, ErrCorruptMetadata) {
+                t.Fatalf("expected ErrCorruptModuleGraph and ErrCorruptMetadata, got %v", err)
+            }
+            if !strings.Contains(err.Error(), tc.module) {
+                t.Fatalf("error %q does not name module %s", err, tc.module)
+            }
+            if _, err := loadStoredMetadata(fsys); !errors.Is(err, ErrCorruptModuleGraph) {
+                t.Fatalf("expected ErrCorruptModuleGraph from loadStoredMetadata, got %v", err)
+            }
+        })
+    }
+}
+
+func TestModuleGraph_Cycles(t *testing.T) {
+    // root (.) -> a -> a/b -> a (cycle)
+    root := &Module{Name: "."}
+    a := &Module{Name: "a"}
+    b := &Module{Name: "a/b"}
+    root.Modules = []*Module{a}
+    a.Modules = []*Module{b}
+    b.Modules = []*Module{a}
+
+    err := validateModuleGraph(root)
+    if !errors.Is(err, ErrCorruptModuleGraph) || !strings.Contains(err.Error(), `"a"`) {
+        t.Fatalf("expected ErrCorruptModuleGraph naming module a, got %v", err)
+    }
+
+    // Traversal helpers must terminate and visit every module once.
+    if got := len(collectAllModules(root)); got != 3 {
+        t.Fatalf("collectAllModules returned %d modules, want 3", got)
+    }
+    if got := len(collectModulesInPostOrder(root)); got != 3 {
+        t.Fatalf("collectModulesInPostOrder returned %d modules, want 3", got)
+    }
+    names := map[string]struct{}{}
+    collectModuleNames(root, names)
+    if len(names) != 3 {
+        t.Fatalf("collectModuleNames returned %d names, want 3", len(names))
+    }
+    if got := FindModule(root, "a/b/file.go"); got != b {
+        t.Fatalf("FindModule returned %v, want a/b", got)
+    }
+
+    // A child shared by two parents.
+    shared := &Module{Name: "x/z/y"}
+    root = &Module{Name: ".", Modules: []*Module{
+        {Name: "x", Modules: []*Module{shared}},
+        {Name: "x/z", Modules: []*Module{shared}},
+    }}
+    err = validateModuleGraph(root)
+    if !errors.Is(err, ErrCorruptModuleGraph) || !strings.Contains(err.Error(), `"x/z/y"`) || !strings.Contains(err.Error(), "more than one parent") {
+        t.Fatalf("expected ErrCorruptModuleGraph naming module x/z/y, got %v", err)
+    }
+}
@@ -1,8 +1,12 @@
 package project
 
 import (
-	"github.com/stretchr/testify/assert"
+	"fmt"
+	"sort"
 	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMetadata_Patch(t *testing.T) {
@@ -122,4 +126,101 @@ func TestMetadata_Patch(t *testing.T) {
 			assert.Equal(t, tc.expected, result)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestMetadata_DryRunPatch(t *testing.T) {
+	storedRoot := &Module{Name: ".", MD5: "abc", TokenCount: 100, Annotation: &Annotation{InternalContext: "stored"}}
+	stored := &Metadata{Modules: storedRoot}
+	fresh := &Metadata{Modules: &Module{Name: ".", MD5: "def", TokenCount: 200}}
+
+	result := stored.DryRunPatch(fresh)
+
+	assert.Equal(t, &PatchResult{
+		ChangedModules: map[string]ModuleChange{".": {PreviousTokenCount: 100, CurrentTokenCount: 200}},
+	}, result)
+	assert.Same(t, storedRoot, stored.Modules, "stored tree must not be replaced")
+	assert.Nil(t, fresh.Modules.Annotation, "annotations must not be copied")
+}
+
+func TestBuildMetadata_Vybignore(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitignore":           {Data: []byte("*.log\n")},
+		".vybignore":           {Data: []byte("LICENSE\n*.md\n")},
+		"LICENSE":              {Data: []byte("MIT")},
+		"README.md":            {Data: []byte("# readme")},
+		"main.go":              {Data: []byte("package main")},
+		"app.log":              {Data: []byte("log")},
+		"docs/.vybignore":      {Data: []byte("!guide.md\n/drafts/\n")},
+		"docs/guide.md":        {Data: []byte("# guide")},
+		"docs/notes.md":        {Data: []byte("# notes")},
+		"docs/drafts/todo.go":  {Data: []byte("package drafts")},
+		"docs/api/drafts/a.go": {Data: []byte("package drafts")},
+	}
+
+	meta, err := buildMetadata(fsys)
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+
+	var got []string
+	var collect func(m *Module)
+	collect = func(m *Module) {
+		for _, f := range m.Files {
+			got = append(got, f.Name)
+		}
+		for _, sub := range m.Modules {
+			collect(sub)
+		}
+	}
+	collect(meta.Modules)
+	sort.Strings(got)
+
+	want := []string{"docs/api/drafts/a.go", "docs/guide.md", "main.go"}
+	assert.Equal(t, want, got)
+}
+
+func TestBuildMetadata_Languages(t *testing.T) {
+	goProject := fstest.MapFS{
+		"README.md":      {Data: []byte("# readme")},
+		"docs/a.md":      {Data: []byte("# a")},
+		"config.yaml":    {Data: []byte("a: 1")},
+		"scripts/gen.py": {Data: []byte("print(1)")},
+	}
+	for i := range 10 {
+		goProject[fmt.Sprintf("pkg/f%d.go", i)] = &fstest.MapFile{Data: []byte("package pkg")}
+	}
+	meta, err := buildMetadata(goProject)
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	// The single Python file is below a tenth of the source files.
+	assert.Equal(t, "go", meta.PrimaryLanguage())
+	assert.Equal(t, []string{"go"}, meta.Languages)
+
+	mixed := fstest.MapFS{
+		"api/a.py":      {Data: []byte("a = 1")},
+		"api/b.py":      {Data: []byte("b = 1")},
+		"api/c.py":      {Data: []byte("c = 1")},
+		"api/d.py":      {Data: []byte("d = 1")},
+		"web/app.ts":    {Data: []byte("let a = 1")},
+		"web/view.tsx":  {Data: []byte("let v = 1")},
+		"web/util.ts":   {Data: []byte("let u = 1")},
+		"web/legacy.js": {Data: []byte("var l = 1")},
+		"web/old.js":    {Data: []byte("var o = 1")},
+		"cli/main.go":   {Data: []byte("package main")},
+		"cli/run.go":    {Data: []byte("package main")},
+	}
+	meta, err = buildMetadata(mixed)
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	// Go and JavaScript tie, the name breaks it; only three are kept.
+	assert.Equal(t, []string{"python", "typescript", "go"}, meta.Languages)
+
+	meta, err = buildMetadata(fstest.MapFS{"README.md": {Data: []byte("# readme")}})
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	assert.Empty(t, meta.Languages)
+	assert.Equal(t, "", meta.PrimaryLanguage())
+}
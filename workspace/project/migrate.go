@@ -0,0 +1,293 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/workspace/pathutil"
+)
+
+// Layout identifies the shape of a .vyb/metadata.yaml file.
+type Layout string
+
+const (
+	// LayoutCurrent is the module-based layout written by this version.
+	LayoutCurrent Layout = "current"
+	// LayoutFlat is the pre-module layout: a flat list of files plus one
+	// free-form summary per directory.
+	LayoutFlat Layout = "flat"
+	// LayoutRootKey is the first module-based layout. Besides the module
+	// tree it stored, under `root`, the relative location of the project
+	// root, so nested .vyb folders could point to a parent project.
+	LayoutRootKey Layout = "root-key"
+)
+
+// legacyDir holds the archived originals, relative to the project root.
+const legacyDir = ".vyb/legacy"
+
+// legacyFlatMetadata is the metadata.yaml format of LayoutFlat.
+type legacyFlatMetadata struct {
+	Root  string `yaml:"root"`
+	Files []struct {
+		Path     string `yaml:"path"`
+		Checksum string `yaml:"checksum"`
+	} `yaml:"files"`
+	// Summaries maps a directory (relative to the root) to its summary.
+	Summaries map[string]string `yaml:"summaries"`
+}
+
+// legacyRootKeyMetadata is the metadata.yaml format of LayoutRootKey.
+type legacyRootKeyMetadata struct {
+	Root    string  `yaml:"root"`
+	Modules *Module `yaml:"modules"`
+}
+
+// MigrationReport summarizes the changes performed by Migrate.
+type MigrationReport struct {
+	Layout Layout `json:"layout"`
+	// Archived lists the paths moved under .vyb/legacy/, relative to the
+	// project root.
+	Archived []string `json:"archived,omitempty"`
+	// PreservedConfig is true when the existing config.yaml was kept.
+	PreservedConfig bool `json:"preserved_config"`
+	// PreservedAnnotations and RegeneratedAnnotations list module names
+	// whose annotation was converted from the legacy data or generated
+	// from scratch, respectively.
+	PreservedAnnotations   []string `json:"preserved_annotations,omitempty"`
+	RegeneratedAnnotations []string `json:"regenerated_annotations,omitempty"`
+}
+
+// detectLayout inspects the top-level keys of a metadata.yaml file.
+func detectLayout(data []byte) (Layout, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return "", fmt.Errorf("%w: failed to parse .vyb/metadata.yaml: %w", ErrCorruptMetadata, err)
+	}
+	_, hasRoot := raw["root"]
+	_, hasModules := raw["modules"]
+	_, hasFiles := raw["files"]
+	_, hasSummaries := raw["summaries"]
+	switch {
+	case hasModules && hasRoot:
+		return LayoutRootKey, nil
+	case hasModules:
+		return LayoutCurrent, nil
+	case hasFiles || hasSummaries:
+		return LayoutFlat, nil
+	}
+	return "", fmt.Errorf("%w: unrecognized .vyb/metadata.yaml layout", ErrCorruptMetadata)
+}
+
+// Migrate converts a .vyb directory written by an older vyb version into
+// the current layout.
+//
+// Algorithm:
+//  1. Detect the layout of .vyb/metadata.yaml. Current projects are left
+//     untouched.
+//  2. Archive the original .vyb contents, and any nested .vyb folder left
+//     behind by LayoutRootKey, under .vyb/legacy/.
+//  3. Build a fresh metadata snapshot and carry over whatever the legacy
+//     data can still vouch for (see adoptFlatSummaries and
+//     mergeAnnotations).
+//  4. Write the converted config and metadata.
+//  5. Run Update, which annotates every module that is still missing an
+//     annotation.
+func Migrate(ctx context.Context, projectRoot string) (*MigrationReport, error) {
+	absRoot, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine absolute project root: %w", err)
+	}
+	vybDir := filepath.Join(absRoot, ".vyb")
+
+	data, err := os.ReadFile(filepath.Join(vybDir, "metadata.yaml"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrNoMetadata, err)
+		}
+		return nil, fmt.Errorf("failed to read .vyb/metadata.yaml: %w", err)
+	}
+	layout, err := detectLayout(data)
+	if err != nil {
+		return nil, err
+	}
+	report := &MigrationReport{Layout: layout}
+	if layout == LayoutCurrent {
+		return report, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(absRoot, legacyDir)); err == nil {
+		return nil, fmt.Errorf("%s already exists, move it away before migrating again", legacyDir)
+	}
+
+	// adopt carries the reusable legacy data over to the fresh snapshot.
+	var adopt func(root *Module)
+	var legacyRoot string
+	switch layout {
+	case LayoutFlat:
+		var legacy legacyFlatMetadata
+		if err := yaml.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("%w: failed to unmarshal legacy metadata: %w", ErrCorruptMetadata, err)
+		}
+		legacyRoot = legacy.Root
+		adopt = func(root *Module) { adoptFlatSummaries(root, &legacy) }
+	case LayoutRootKey:
+		var legacy legacyRootKeyMetadata
+		if err := yaml.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("%w: failed to unmarshal legacy metadata: %w", ErrCorruptMetadata, err)
+		}
+		legacyRoot = legacy.Root
+		oldMap := make(map[string]*Module)
+		collectModuleMap(legacy.Modules, oldMap)
+		adopt = func(root *Module) { mergeAnnotations(root, oldMap) }
+	}
+	if legacyRoot != "" && filepath.Clean(legacyRoot) != "." {
+		return nil, fmt.Errorf("this .vyb folder points to the project root at %q, run 'vyb migrate' from there", legacyRoot)
+	}
+
+	// Keep the existing config only when the current version can read it.
+	rootFS := os.DirFS(absRoot)
+	cfgData, err := os.ReadFile(filepath.Join(vybDir, "config.yaml"))
+	if err == nil {
+		_, err = config.LoadFS(rootFS)
+		report.PreservedConfig = err == nil
+	}
+	if !report.PreservedConfig {
+		cfgData, err = yaml.Marshal(&config.Config{Provider: config.Default().Provider})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal config.yaml: %w", err)
+		}
+	}
+
+	// Nested .vyb folders are not covered by the system exclusion
+	// patterns, so they must be archived before the snapshot is built.
+	nested, err := findAllConfigWithinRoot(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := archiveLegacyConfig(absRoot, nested)
+	if err != nil {
+		return nil, err
+	}
+	report.Archived = archived
+
+	meta, err := buildMetadata(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	adopt(meta.Modules)
+	for _, mod := range collectAllModules(meta.Modules) {
+		if mod.Annotation != nil {
+			report.PreservedAnnotations = append(report.PreservedAnnotations, mod.Name)
+		} else {
+			report.RegeneratedAnnotations = append(report.RegeneratedAnnotations, mod.Name)
+		}
+	}
+	sort.Strings(report.PreservedAnnotations)
+	sort.Strings(report.RegeneratedAnnotations)
+
+	if err := os.WriteFile(filepath.Join(vybDir, "config.yaml"), cfgData, 0644); err != nil {
+		return nil, &PersistError{Path: filepath.Join(vybDir, "config.yaml"), Cause: err}
+	}
+	metaData, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata.yaml: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(vybDir, "metadata.yaml"), metaData, 0644); err != nil {
+		return nil, &PersistError{Path: filepath.Join(vybDir, "metadata.yaml"), Cause: err}
+	}
+
+	if _, err := UpdateWithOptions(ctx, absRoot, UpdateOptions{}); err != nil {
+		return nil, fmt.Errorf("project layout migrated, but annotations could not be generated (run 'vyb update' to retry): %w", err)
+	}
+	return report, nil
+}
+
+// adoptFlatSummaries turns legacy directory summaries into annotations.
+//
+// A summary is only reused for leaf modules whose files are exactly the
+// ones recorded in the legacy metadata, with unchanged checksums. For such
+// modules the summary describes both the module content and everything it
+// exposes, so it stands in for the internal and public contexts. The
+// external context is left for annotate to fill in.
+func adoptFlatSummaries(root *Module, legacy *legacyFlatMetadata) {
+	checksums := make(map[string]string, len(legacy.Files))
+	for _, f := range legacy.Files {
+		checksums[filepath.Clean(f.Path)] = f.Checksum
+	}
+
+	for _, mod := range collectAllModules(root) {
+		summary := strings.TrimSpace(legacy.Summaries[mod.Name])
+		if summary == "" || len(mod.Modules) > 0 || !hasLegacyFiles(mod, checksums) {
+			continue
+		}
+		mod.Annotation = &Annotation{InternalContext: summary, PublicContext: summary}
+	}
+}
+
+// hasLegacyFiles reports whether the files of the leaf module mod match the
+// legacy checksums one to one.
+func hasLegacyFiles(mod *Module, checksums map[string]string) bool {
+	count := 0
+	for p := range checksums {
+		if pathutil.IsPathUnderDir(mod.Name, p) {
+			count++
+		}
+	}
+	if count != len(mod.Files) {
+		return false
+	}
+	for _, f := range mod.Files {
+		if checksums[f.Name] != f.MD5 {
+			return false
+		}
+	}
+	return true
+}
+
+// archiveLegacyConfig moves every entry of the root .vyb folder, as well as
+// the nested .vyb folders, under .vyb/legacy/. Nested folders keep their
+// relative location below .vyb/legacy/nested/. It returns the archived
+// paths relative to projectRoot.
+func archiveLegacyConfig(projectRoot string, nested []string) ([]string, error) {
+	vybDir := filepath.Join(projectRoot, ".vyb")
+	dst := filepath.Join(projectRoot, legacyDir)
+
+	entries, err := os.ReadDir(vybDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .vyb directory: %w", err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, &PersistError{Path: dst, Cause: err}
+	}
+
+	var archived []string
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(vybDir, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return nil, &PersistError{Path: filepath.Join(dst, e.Name()), Cause: err}
+		}
+		archived = append(archived, filepath.Join(".vyb", e.Name()))
+	}
+
+	for _, n := range nested {
+		if n == ".vyb" {
+			continue
+		}
+		target := filepath.Join(dst, "nested", n)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, &PersistError{Path: filepath.Dir(target), Cause: err}
+		}
+		if err := os.Rename(filepath.Join(projectRoot, n), target); err != nil {
+			return nil, &PersistError{Path: target, Cause: err}
+		}
+		archived = append(archived, n)
+	}
+	return archived, nil
+}
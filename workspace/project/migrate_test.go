@@ -0,0 +1,186 @@
+package project
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// copyFixture copies testdata/<name> into a temp dir. Fixture folders are
+// named dot-vyb so they are not mistaken for real vyb configuration.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	src := filepath.Join("testdata", name)
+	dst := t.TempDir()
+	err := filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := filepath.Join(dst, strings.ReplaceAll(rel, "dot-vyb", ".vyb"))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+	if err != nil {
+		t.Fatalf("failed to copy fixture %s: %v", name, err)
+	}
+	return dst
+}
+
+// fakeAnnotator replaces the annotation LLM calls. The returned function
+// lists the modules whose self-contained context was requested.
+func fakeAnnotator(t *testing.T) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var requested []string
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		mu.Lock()
+		requested = append(requested, req.TargetModuleName)
+		mu.Unlock()
+		return &payload.ModuleSelfContainedContext{
+			InternalContext: "generated internal " + req.TargetModuleName,
+			PublicContext:   "generated public " + req.TargetModuleName,
+		}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		resp := &payload.ModuleExternalContextResponse{}
+		for _, m := range req.Modules {
+			resp.Modules = append(resp.Modules, payload.ModuleExternalContext{Name: m.Name, ExternalContext: "external " + m.Name})
+		}
+		return resp, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		sort.Strings(requested)
+		return requested
+	}
+}
+
+func TestDetectLayout(t *testing.T) {
+	cases := map[string]Layout{
+		"modules:\n  name: .\n":             LayoutCurrent,
+		"root: .\nmodules:\n  name: .\n":    LayoutRootKey,
+		"root: .\nfiles:\n  - path: a.go\n": LayoutFlat,
+		"summaries:\n  .: text\n":           LayoutFlat,
+	}
+	for in, want := range cases {
+		got, err := detectLayout([]byte(in))
+		if err != nil || got != want {
+			t.Errorf("detectLayout(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := detectLayout([]byte("foo: bar\n")); err == nil {
+		t.Errorf("expected error for unrecognized layout")
+	}
+}
+
+func TestMigrate_Flat(t *testing.T) {
+	root := copyFixture(t, "legacy-flat")
+	requested := fakeAnnotator(t)
+
+	report, err := Migrate(context.Background(), root)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	want := &MigrationReport{
+		Layout:                 LayoutFlat,
+		Archived:               []string{".vyb/metadata.yaml", "pkg/.vyb"},
+		PreservedAnnotations:   []string{"pkg"},
+		RegeneratedAnnotations: []string{".", "util"},
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Fatalf("report mismatch (-want +got):\n%s", diff)
+	}
+	// util changed since the legacy summary was written, and the root
+	// module's summary does not cover its sub-modules.
+	if diff := cmp.Diff([]string{".", "util"}, requested()); diff != "" {
+		t.Fatalf("unexpected annotation requests (-want +got):\n%s", diff)
+	}
+
+	for _, p := range []string{".vyb/legacy/metadata.yaml", ".vyb/legacy/nested/pkg/.vyb/metadata.yaml", ".vyb/config.yaml"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected %s to exist: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "pkg", ".vyb")); !os.IsNotExist(err) {
+		t.Errorf("expected nested .vyb folder to be archived, got %v", err)
+	}
+
+	meta, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	modules := make(map[string]*Module)
+	collectModuleMap(meta.Modules, modules)
+	pkg := modules["pkg"].Annotation
+	if pkg == nil || !strings.HasPrefix(pkg.InternalContext, "Package pkg exposes Greet") || pkg.ExternalContext != "external pkg" {
+		t.Fatalf("expected legacy summary to be preserved for pkg, got %+v", pkg)
+	}
+	if got := modules["util"].Annotation.InternalContext; got != "generated internal util" {
+		t.Fatalf("expected util annotation to be regenerated, got %q", got)
+	}
+
+	// A second run finds the current layout and does nothing.
+	again, err := Migrate(context.Background(), root)
+	if err != nil || again.Layout != LayoutCurrent {
+		t.Fatalf("expected current layout on second run, got %+v, %v", again, err)
+	}
+}
+
+func TestMigrate_RootKey(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	meta, err := buildMetadata(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	meta.Modules.Annotation = &Annotation{InternalContext: "kept", PublicContext: "kept", ExternalContext: "kept"}
+	data, err := yaml.Marshal(legacyRootKeyMetadata{Root: ".", Modules: meta.Modules})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "metadata.yaml"), data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte("provider: gemini\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	requested := fakeAnnotator(t)
+
+	report, err := Migrate(context.Background(), root)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if !report.PreservedConfig || len(report.RegeneratedAnnotations) != 0 || len(requested()) != 0 {
+		t.Fatalf("expected config and annotations to be preserved, got %+v", report)
+	}
+	cfg, err := config.Load(root)
+	if err != nil || cfg.Provider != "gemini" {
+		t.Fatalf("expected preserved provider, got %+v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,139 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// ErrUpdateDeclined is returned by UpdateWithOptions when the regeneration
+// plan was not confirmed. Nothing is written in that case.
+var ErrUpdateDeclined = errors.New("update declined")
+
+// Regenerate selects stored annotations that must be regenerated even though
+// the files of their module did not change, e.g. after switching provider.
+type Regenerate struct {
+	// All selects every module.
+	All bool
+	// FromProvider selects the annotations written by this provider.
+	FromProvider string
+	// Module selects the module with this name, its path relative to the
+	// project root ("." for the root module).
+	Module string
+	// Ancestors, with Module, also regenerates the external contexts of the
+	// ancestors of Module, inferred along with its own.
+	Ancestors bool
+}
+
+// matches reports whether a, the annotation of the module name, is
+// selected for regeneration.
+func (r Regenerate) matches(name string, a *Annotation) bool {
+	if a == nil {
+		return false
+	}
+	return r.All || name == r.Module || (r.FromProvider != "" && a.Provider == r.FromProvider)
+}
+
+// resolveModule normalizes r.Module and checks that root has a module with
+// that name. FindModule locates the module holding the path, so a directory
+// that is not a module is reported along with the module it is part of.
+func (r *Regenerate) resolveModule(root *Module) error {
+	if r.Module == "" {
+		return nil
+	}
+	r.Module = path.Clean(filepath.ToSlash(r.Module))
+	m := FindModule(root, r.Module)
+	switch {
+	case m == nil:
+		return fmt.Errorf("module %q not found, the project has no module", r.Module)
+	case m.Name != r.Module:
+		return fmt.Errorf("module %q not found, it is part of module %q", r.Module, m.Name)
+	}
+	return nil
+}
+
+// RegenerationPlan previews the modules re-annotated because of Regenerate.
+type RegenerationPlan struct {
+	Modules []string
+	// ExternalContexts lists the modules, the ancestors of Regenerate.Module,
+	// whose external context alone is regenerated.
+	ExternalContexts []string
+	// Tokens estimates the file content tokens sent to the LLM to
+	// regenerate the internal and public contexts of Modules.
+	Tokens int64
+}
+
+// UpdateOptions tunes UpdateWithOptions.
+type UpdateOptions struct {
+	Overrides  config.Overrides
+	Regenerate Regenerate
+	// Confirm is called with the regeneration plan, when it is not empty,
+	// before any LLM call. Returning false aborts the update with
+	// ErrUpdateDeclined. A nil Confirm accepts every plan.
+	Confirm func(*RegenerationPlan) (bool, error)
+	// Reporter, when not nil, is notified of every LLM call made to
+	// regenerate annotations, in addition to UpdateReport.Metrics.
+	Reporter AnnotationReporter
+}
+
+// planRegeneration returns the plan of the annotations of modules selected
+// by r, sorted by module name, then the ancestors of r.Module, nearest
+// first.
+func planRegeneration(modules map[string]*Module, r Regenerate) *RegenerationPlan {
+	plan := &RegenerationPlan{}
+	for _, name := range sortedKeys(modules) {
+		mod := modules[name]
+		if !r.matches(name, mod.Annotation) {
+			continue
+		}
+		plan.Modules = append(plan.Modules, name)
+		for _, f := range mod.Files {
+			plan.Tokens += f.TokenCount
+		}
+	}
+	if _, ok := modules[r.Module]; ok && r.Ancestors {
+		// Module names are nested like their paths, so the ancestors are
+		// the modules named after the parent directories. The root module
+		// has no external context.
+		for dir := path.Dir(r.Module); dir != "."; dir = path.Dir(dir) {
+			if p, ok := modules[dir]; ok && p.Annotation != nil && p.Annotation.ExternalContext != "" {
+				plan.ExternalContexts = append(plan.ExternalContexts, dir)
+			}
+		}
+	}
+	return plan
+}
+
+// PolicyViolation is a stored annotation whose provenance does not comply
+// with the annotation policy of the project.
+type PolicyViolation struct {
+	Module    string
+	Provider  string
+	ModelSize config.ModelSize
+}
+
+// CheckPolicy returns the annotations of meta that were not written by the
+// provider and model tier required by policy, sorted by module name.
+// Annotations without provenance never comply with a policy.
+func CheckPolicy(meta *Metadata, policy config.Annotation) []PolicyViolation {
+	if meta == nil || (policy.RequireProvider == "" && policy.RequireModelSize == "") {
+		return nil
+	}
+	var violations []PolicyViolation
+	for _, mod := range collectAllModules(meta.Modules) {
+		a := mod.Annotation
+		if a == nil {
+			continue
+		}
+		if (policy.RequireProvider != "" && a.Provider != policy.RequireProvider) ||
+			(policy.RequireModelSize != "" && a.ModelSize != policy.RequireModelSize) {
+			violations = append(violations, PolicyViolation{Module: mod.Name, Provider: a.Provider, ModelSize: a.ModelSize})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Module < violations[j].Module })
+	return violations
+}
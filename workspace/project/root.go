@@ -47,7 +47,7 @@ func FindDistanceToRoot(path string) (string, error) {
 			var m Metadata
 			err := yaml.Unmarshal(data, &m)
 			if err != nil {
-				return "", fmt.Errorf("project root has invalid metadata: %w", err)
+				return "", fmt.Errorf("%w: project root %s has invalid metadata: %w", ErrCorruptMetadata, curr, err)
 			}
 			projectRoot = curr
 			found = true
@@ -60,7 +60,7 @@ func FindDistanceToRoot(path string) (string, error) {
 		curr = parent
 	}
 	if !found {
-		return "", fmt.Errorf("given path %s is not within a valid project root", path)
+		return "", fmt.Errorf("%w: given path %s is not within a valid project root", ErrNoMetadata, path)
 	}
 
 	// Compute the relative path from the given path to the project root.
@@ -0,0 +1,4 @@
+package pkg
+
+// Greet returns a greeting for name.
+func Greet(name string) string { return "hello " + name }
@@ -0,0 +1,9 @@
+package util
+
+// Max returns the largest of a and b.
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
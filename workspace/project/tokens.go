@@ -0,0 +1,152 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/models"
+	"github.com/vybdev/vyb/logging"
+)
+
+// HeuristicBytesPerToken is the number of bytes counted as one token when
+// the tiktoken data cannot be loaded, e.g. in stripped-down containers.
+const HeuristicBytesPerToken = 4
+
+// HeuristicTokensNote labels reports built from estimated token counts.
+const HeuristicTokensNote = "token counts are estimated (bytes/4) because the tokenizer data could not be loaded"
+
+// DefaultEncoding is the tiktoken encoding used when the tokenizer of the
+// configured model is not known.
+const DefaultEncoding = tokenizer.Cl100kBase
+
+// o200kPrefixes are the prefixes of the OpenAI models using o200k_base.
+var o200kPrefixes = []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "chatgpt-4o", "o1", "o3", "o4"}
+
+// EncodingFor returns the tiktoken encoding closest to the tokenizer of the
+// model annotating the project of cfg, DefaultEncoding when it is not known.
+// Gemini models do not use a tiktoken encoding; o200k_base, whose
+// vocabulary is of a similar size, approximates them.
+func EncodingFor(cfg *config.Config) tokenizer.Encoding {
+	if cfg == nil {
+		return DefaultEncoding
+	}
+	model, err := models.New(cfg.Models).Resolve(cfg.Provider, config.ModelFamilyReasoning, cfg.Annotation.Size())
+	if err != nil {
+		return DefaultEncoding
+	}
+	return encodingForModel(cfg.Provider, model)
+}
+
+// encodingForModel returns the tiktoken encoding of model, served by
+// provider.
+func encodingForModel(provider, model string) tokenizer.Encoding {
+	switch strings.ToLower(provider) {
+	case "gemini":
+		return tokenizer.O200kBase
+	case "openai":
+		model = strings.ToLower(model)
+		for _, prefix := range o200kPrefixes {
+			if strings.HasPrefix(model, prefix) {
+				return tokenizer.O200kBase
+			}
+		}
+	}
+	return DefaultEncoding
+}
+
+// tokenizers loads the codec of every encoding once per process.
+type tokenizers struct {
+	load    func(tokenizer.Encoding) (tokenizer.Codec, error)
+	mu      sync.Mutex
+	loaders map[tokenizer.Encoding]*tokenizerLoader
+}
+
+func newTokenizers(load func(tokenizer.Encoding) (tokenizer.Codec, error)) *tokenizers {
+	return &tokenizers{load: load, loaders: make(map[tokenizer.Encoding]*tokenizerLoader)}
+}
+
+// get returns the codec of enc, or the error preventing it from loading.
+func (t *tokenizers) get(enc tokenizer.Encoding) (tokenizer.Codec, error) {
+	t.mu.Lock()
+	l, ok := t.loaders[enc]
+	if !ok {
+		l = &tokenizerLoader{load: func() (tokenizer.Codec, error) { return t.load(enc) }}
+		t.loaders[enc] = l
+	}
+	t.mu.Unlock()
+	return l.get()
+}
+
+// tokenizerLoader loads the codec of one encoding once.
+type tokenizerLoader struct {
+	load  func() (tokenizer.Codec, error)
+	once  sync.Once
+	codec tokenizer.Codec
+	err   error
+}
+
+// NOTE: tokenCounter is a var (not a direct call) to allow test overrides.
+var tokenCounter = newTokenizers(tokenizer.Get)
+
+// get returns the codec, or the error preventing it from loading. A panic
+// of the tokenizer library is reported as an error. The first failure is
+// logged as a warning.
+func (l *tokenizerLoader) get() (tokenizer.Codec, error) {
+	l.once.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				l.codec, l.err = nil, fmt.Errorf("tokenizer panicked: %v", r)
+			}
+			if l.err != nil {
+				logging.Log.Warnf("%s: %v", HeuristicTokensNote, l.err)
+			}
+		}()
+		l.codec, l.err = l.load()
+	})
+	return l.codec, l.err
+}
+
+// TokenizerError returns the error preventing the tokenizer from loading,
+// or nil when token counts are exact.
+func TokenizerError() error {
+	_, err := tokenCounter.get(DefaultEncoding)
+	return err
+}
+
+// HeuristicTokenCounts reports whether token counts are estimated from the
+// byte length of the text, see HeuristicBytesPerToken.
+func HeuristicTokenCounts() bool {
+	return TokenizerError() != nil
+}
+
+// CountTokens returns the number of tokens in text, using DefaultEncoding.
+func CountTokens(text string) (int, error) {
+	return getFileTokenCount(DefaultEncoding, []byte(text))
+}
+
+// CountTokensFor returns the number of tokens in text, using the encoding
+// enc, as returned by EncodingFor. An empty enc means DefaultEncoding.
+func CountTokensFor(enc tokenizer.Encoding, text string) (int, error) {
+	if enc == "" {
+		enc = DefaultEncoding
+	}
+	return getFileTokenCount(enc, []byte(text))
+}
+
+// getFileTokenCount uses the tiktoken-go library to determine the token
+// count of content in the encoding enc, or estimates it from the length of
+// content when the tokenizer is unavailable.
+func getFileTokenCount(encoding tokenizer.Encoding, content []byte) (int, error) {
+	enc, err := tokenCounter.get(encoding)
+	if err != nil {
+		return (len(content) + HeuristicBytesPerToken - 1) / HeuristicBytesPerToken, nil
+	}
+	ids, _, err := enc.Encode(string(content))
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,97 @@
+package project
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tiktoken-go/tokenizer"
+	"github.com/vybdev/vyb/config"
+)
+
+// withTokenizer replaces the tokenizer loader for the duration of the test.
+func withTokenizer(t *testing.T, load func() (tokenizer.Codec, error)) {
+	t.Helper()
+	old := tokenCounter
+	tokenCounter = newTokenizers(func(tokenizer.Encoding) (tokenizer.Codec, error) { return load() })
+	t.Cleanup(func() { tokenCounter = old })
+}
+
+func TestCountTokens_HeuristicFallback(t *testing.T) {
+	for name, load := range map[string]func() (tokenizer.Codec, error){
+		"error": func() (tokenizer.Codec, error) { return nil, errors.New("no embedded data") },
+		"panic": func() (tokenizer.Codec, error) { panic("cgo failure") },
+	} {
+		withTokenizer(t, load)
+
+		if err := TokenizerError(); err == nil || !HeuristicTokenCounts() {
+			t.Fatalf("%s: expected the tokenizer to be reported as unavailable", name)
+		}
+		for text, want := range map[string]int{"": 0, "abcd": 1, "abcdefghi": 3} {
+			if n, err := CountTokens(text); err != nil || n != want {
+				t.Fatalf("%s: CountTokens(%q) = %d, %v, want %d", name, text, n, err, want)
+			}
+		}
+
+		meta, err := buildMetadata(fstest.MapFS{"main.go": {Data: []byte(strings.Repeat("x", 40))}})
+		if err != nil {
+			t.Fatalf("%s: buildMetadata: %v", name, err)
+		}
+		if got := meta.Modules.Files[0].TokenCount; got != 10 {
+			t.Fatalf("%s: expected an estimated token count of 10, got %d", name, got)
+		}
+	}
+}
+
+func TestCountTokens_Tokenizer(t *testing.T) {
+	if err := TokenizerError(); err != nil {
+		t.Fatalf("expected the embedded tokenizer to load, got %v", err)
+	}
+	if n, err := CountTokens("hello world"); err != nil || n != 2 {
+		t.Fatalf("CountTokens = %d, %v, want 2", n, err)
+	}
+}
+
+func TestCountTokensFor_Encodings(t *testing.T) {
+	const text = `func main() { fmt.Println("héllo, wörld") }`
+	for enc, want := range map[tokenizer.Encoding]int{tokenizer.Cl100kBase: 16, tokenizer.O200kBase: 15} {
+		if n, err := CountTokensFor(enc, text); err != nil || n != want {
+			t.Fatalf("CountTokensFor(%s) = %d, %v, want %d", enc, n, err, want)
+		}
+	}
+	if n, err := CountTokensFor("", text); err != nil || n != 16 {
+		t.Fatalf("CountTokensFor with no encoding = %d, %v, want the cl100k_base count", n, err)
+	}
+
+	fsys := fstest.MapFS{
+		"main.go":          {Data: []byte(text)},
+		".vyb/config.yaml": {Data: []byte("provider: gemini\n")},
+	}
+	meta, err := buildMetadata(fsys)
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	if got := meta.Modules.Files[0].TokenCount; got != 15 {
+		t.Fatalf("expected the o200k_base token count of a gemini project, got %d", got)
+	}
+}
+
+func TestEncodingFor(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  *config.Config
+		want tokenizer.Encoding
+	}{
+		{nil, tokenizer.Cl100kBase},
+		{&config.Config{Provider: "openai"}, tokenizer.O200kBase},
+		{&config.Config{Provider: "openai", Models: map[string]map[string]string{"openai": {"reasoning-small": "gpt-4-turbo"}}}, tokenizer.Cl100kBase},
+		{&config.Config{Provider: "gemini"}, tokenizer.O200kBase},
+		{&config.Config{Provider: "anthropic"}, tokenizer.Cl100kBase},
+		{&config.Config{Provider: "ollama"}, tokenizer.Cl100kBase},
+		{&config.Config{Provider: "unknown"}, tokenizer.Cl100kBase},
+	} {
+		if got := EncodingFor(tc.cfg); got != tc.want {
+			t.Errorf("EncodingFor(%+v) = %s, want %s", tc.cfg, got, tc.want)
+		}
+	}
+}
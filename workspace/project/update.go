@@ -1,20 +1,28 @@
 package project
 
 import (
+	"context"
 	"fmt"
 	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/logging"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // collectModuleMap traverses a module tree and records every module by
-// its Name into dst.
+// its Name into dst. Names already in dst are not descended into again.
 func collectModuleMap(mod *Module, dst map[string]*Module) {
 	if mod == nil {
 		return
 	}
+	if _, seen := dst[mod.Name]; seen {
+		return
+	}
 	dst[mod.Name] = mod
 	for _, child := range mod.Modules {
 		collectModuleMap(child, dst)
@@ -39,6 +47,17 @@ func mergeAnnotations(fresh *Module, oldMap map[string]*Module) {
 	}
 }
 
+// UpdateReport summarizes the changes performed by Update.
+type UpdateReport struct {
+	// SchemaVersion is payload.SchemaVersion, recorded in the JSON report.
+	SchemaVersion     string             `json:"schema_version"`
+	AddedModules      []string           `json:"added_modules,omitempty"`
+	RemovedModules    []string           `json:"removed_modules,omitempty"`
+	AnnotationChanges []AnnotationChange `json:"annotation_changes,omitempty"`
+	// Metrics summarizes the LLM calls made to regenerate annotations.
+	Metrics AnnotationMetrics `json:"metrics"`
+}
+
 // Update refreshes the .vyb/metadata.yaml content to reflect the current
 // workspace state while preserving valid annotations.
 //
@@ -46,13 +65,34 @@ func mergeAnnotations(fresh *Module, oldMap map[string]*Module) {
 //  1. Load the stored metadata (with annotations).
 //  2. Produce a fresh metadata snapshot from the file system.
 //  3. Patch the stored metadata with the fresh snapshot.
-//  4. Run annotate so missing/invalid annotations are regenerated.
-//  5. Persist the updated metadata back to disk.
-func Update(projectRoot string) error {
+//  4. Drop the annotations of modules whose content changed, remembering
+//     them so the regenerated versions can be compared. When files were only
+//     moved or renamed, the external context is kept.
+//  5. Restore the annotations journaled by an interrupted update, then run
+//     annotate so missing/invalid annotations are regenerated, journaling
+//     each one as soon as it is generated.
+//  6. Record every regenerated annotation under .vyb/annotations-history/.
+//  7. Persist the updated metadata back to disk and remove the journal.
+func Update(projectRoot string) (*UpdateReport, error) {
+	return UpdateWithOverrides(projectRoot, config.Overrides{})
+}
+
+// UpdateWithOverrides behaves like Update, annotating modules with the
+// project configuration patched by overrides. The configuration file is
+// left untouched.
+func UpdateWithOverrides(projectRoot string, overrides config.Overrides) (*UpdateReport, error) {
+	return UpdateWithOptions(context.Background(), projectRoot, UpdateOptions{Overrides: overrides})
+}
+
+// UpdateWithOptions behaves like UpdateWithOverrides, additionally dropping
+// the annotations selected by opts.Regenerate so they are written again.
+// Cancelling ctx aborts the annotation; the annotations generated so far
+// stay journaled for the next update.
+func UpdateWithOptions(ctx context.Context, projectRoot string, opts UpdateOptions) (*UpdateReport, error) {
 	// Ensure we have an absolute project root path.
 	absRoot, err := filepath.Abs(projectRoot)
 	if err != nil {
-		return fmt.Errorf("failed to determine absolute project root: %w", err)
+		return nil, fmt.Errorf("failed to determine absolute project root: %w", err)
 	}
 
 	rootFS := os.DirFS(absRoot)
@@ -60,37 +100,135 @@ func Update(projectRoot string) error {
 	// load existing metadata (with annotations).
 	stored, err := loadStoredMetadata(rootFS)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// build a fresh snapshot.
 	fresh, err := buildMetadata(rootFS)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// patch stored metadata with the fresh structure.
-	stored.Patch(fresh)
+	patch := stored.Patch(fresh)
+
+	report := &UpdateReport{
+		SchemaVersion:  payload.SchemaVersion,
+		AddedModules:   patch.AddedModules,
+		RemovedModules: patch.RemovedModules,
+	}
+	sort.Strings(report.AddedModules)
+	sort.Strings(report.RemovedModules)
+
+	modules := make(map[string]*Module)
+	collectModuleMap(stored.Modules, modules)
+
+	previous := make(map[string]*Annotation)
+	for name, change := range patch.ChangedModules {
+		if mod, ok := modules[name]; ok && mod.Annotation != nil {
+			previous[name] = mod.Annotation
+			if change.PathsOnly {
+				// Files were only moved or renamed: the paths mentioned
+				// by the internal and public contexts may be stale, the
+				// surroundings described by the external context are not.
+				mod.Annotation = &Annotation{ExternalContext: mod.Annotation.ExternalContext}
+				continue
+			}
+			mod.Annotation = nil
+		}
+	}
+
+	if err := opts.Regenerate.resolveModule(stored.Modules); err != nil {
+		return nil, err
+	}
+	plan := planRegeneration(modules, opts.Regenerate)
+	if (len(plan.Modules) > 0 || len(plan.ExternalContexts) > 0) && opts.Confirm != nil {
+		ok, err := opts.Confirm(plan)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrUpdateDeclined
+		}
+	}
+	for _, name := range plan.Modules {
+		if _, ok := previous[name]; !ok {
+			previous[name] = modules[name].Annotation
+		}
+		modules[name].Annotation = nil
+	}
+	for _, name := range plan.ExternalContexts {
+		kept := *modules[name].Annotation
+		kept.ExternalContext = ""
+		modules[name].Annotation = &kept
+	}
 
 	cfg, err := config.Load(absRoot)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	cfg = opts.Overrides.Apply(cfg)
+	// Resume the work of an interrupted update: annotations journaled for
+	// an unchanged module are not generated again.
+	journal, err := openJournal(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	if n := journal.restore(modules); n > 0 {
+		logging.Log.Infof("restored %d annotation(s) from %s\n", n, JournalFile)
 	}
 	// (re)annotate modules missing or with invalid annotations.
-	if err := annotate(cfg, stored, rootFS); err != nil {
-		return err
+	recorder := &MetricsRecorder{}
+	if err := annotate(ctx, cfg, stored, rootFS, reporters{recorder, opts.Reporter}, journal); err != nil {
+		return nil, err
+	}
+	report.Metrics = recorder.Metrics()
+
+	now := time.Now()
+	for _, name := range sortedKeys(previous) {
+		mod := modules[name]
+		report.AnnotationChanges = append(report.AnnotationChanges, newAnnotationChange(name, previous[name], mod.Annotation))
+		if mod.Annotation == nil {
+			continue
+		}
+		// Seed the history with the version being replaced the first time
+		// a module is regenerated, so it can be reviewed later.
+		history, err := LoadAnnotationHistory(absRoot, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(history) == 0 {
+			if err := recordAnnotationVersion(absRoot, name, *previous[name], now); err != nil {
+				return nil, err
+			}
+		}
+		if err := recordAnnotationVersion(absRoot, name, *mod.Annotation, now); err != nil {
+			return nil, err
+		}
 	}
 
 	// persist back to .vyb/metadata.yaml.
 	data, err := yaml.Marshal(stored)
 	if err != nil {
-		return fmt.Errorf("failed to marshal updated metadata: %w", err)
+		return nil, fmt.Errorf("failed to marshal updated metadata: %w", err)
 	}
 
 	metaFilePath := filepath.Join(absRoot, ".vyb", "metadata.yaml")
 	if err := os.WriteFile(metaFilePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write updated metadata.yaml: %w", err)
+		return nil, &PersistError{Path: metaFilePath, Cause: err}
 	}
+	if err := journal.remove(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
 
-	return nil
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,392 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"gopkg.in/yaml.v3"
+)
+
+func TestUpdateWithOverrides(t *testing.T) {
+	root := t.TempDir()
+	configYAML := "provider: openai\n"
+	for name, content := range map[string]string{
+		"main.go":            "package main\n",
+		".vyb/config.yaml":   configYAML,
+		".vyb/metadata.yaml": "modules:\n  name: .\n",
+	} {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	var used []*config.Config
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, cfg *config.Config, _ string, _ *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		used = append(used, cfg)
+		return &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, cfg *config.Config, _ string, _ *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		used = append(used, cfg)
+		return &payload.ModuleExternalContextResponse{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	overrides := config.Overrides{Provider: "gemini", ModelSize: config.ModelSizeLarge}
+	if _, err := UpdateWithOverrides(root, overrides); err != nil {
+		t.Fatalf("UpdateWithOverrides: %v", err)
+	}
+
+	if len(used) == 0 {
+		t.Fatalf("expected the module to be annotated")
+	}
+	for _, cfg := range used {
+		if cfg.Provider != "gemini" || cfg.Annotation.Size() != config.ModelSizeLarge {
+			t.Fatalf("expected the override to be used, got provider=%q size=%q", cfg.Provider, cfg.Annotation.Size())
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".vyb", "config.yaml"))
+	if err != nil || string(data) != configYAML {
+		t.Fatalf("expected config.yaml to be left untouched, got %q (%v)", data, err)
+	}
+}
+
+// writeAnnotatedProject writes files under a temp directory, along with
+// metadata built from them where every module is annotated, and returns the
+// project root.
+func writeAnnotatedProject(t *testing.T, files map[string]string, version int) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	meta, err := buildMetadata(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("buildMetadata: %v", err)
+	}
+	for _, mod := range collectAllModules(meta.Modules) {
+		mod.Annotation = &Annotation{ExternalContext: "ext " + mod.Name, InternalContext: "old internal", PublicContext: "old public"}
+		if version < metadataVersion {
+			mod.MD5 = "content-only hash"
+		}
+	}
+	meta.Version = version
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".vyb", "metadata.yaml"), data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return root
+}
+
+var moveFixture = map[string]string{
+	"pkg/a/x.go": "package a\n",
+	"pkg/b/y.go": "package b\n",
+	"pkg/c/z.go": "package c\n",
+}
+
+func TestUpdate_MoveWithinModule(t *testing.T) {
+	root := writeAnnotatedProject(t, moveFixture, metadataVersion)
+
+	old := getModuleContext
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		return &payload.ModuleSelfContainedContext{InternalContext: "new internal " + req.TargetModuleName, PublicContext: "new public"}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext = old })
+	generated := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	useTime(t, generated)
+
+	// Nothing changed: the module hashes must match.
+	if report, err := Update(root); err != nil || len(report.AnnotationChanges) != 0 {
+		t.Fatalf("expected no annotation change, got %+v (%v)", report, err)
+	}
+
+	// Move a file between two directories of module "pkg", keeping its
+	// content: the module hash changes, its content hash does not.
+	if err := os.MkdirAll(filepath.Join(root, "pkg", "b"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Rename(filepath.Join(root, "pkg", "a", "x.go"), filepath.Join(root, "pkg", "b", "x.go")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if _, err := Update(root); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	meta, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	pkg := FindModule(meta.Modules, "pkg")
+	if pkg == nil || pkg.Name != "pkg" {
+		t.Fatalf("expected module pkg, got %+v", pkg)
+	}
+	want := Annotation{ExternalContext: "ext pkg", InternalContext: "new internal pkg", PublicContext: "new public", GeneratedAt: generated, Provider: "openai", ModelSize: config.ModelSizeSmall}
+	if *pkg.Annotation != want {
+		t.Fatalf("expected a soft invalidation keeping the external context, got %+v", *pkg.Annotation)
+	}
+}
+
+func TestUpdate_UpgradesModuleHashes(t *testing.T) {
+	root := writeAnnotatedProject(t, moveFixture, 0)
+
+	old := getModuleContext
+	getModuleContext = func(context.Context, *config.Config, string, *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		t.Fatalf("unchanged modules must not be re-annotated after the hash upgrade")
+		return nil, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext = old })
+
+	if _, err := Update(root); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	meta, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	if meta.Version != metadataVersion {
+		t.Fatalf("expected version %d to be persisted, got %d", metadataVersion, meta.Version)
+	}
+	if meta.Modules.Annotation.InternalContext != "old internal" {
+		t.Fatalf("expected annotations to be kept, got %+v", meta.Modules.Annotation)
+	}
+}
+
+func TestUpdateWithOptions_Regenerate(t *testing.T) {
+	root := writeAnnotatedProject(t, moveFixture, metadataVersion)
+
+	var annotated []string
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		annotated = append(annotated, req.TargetModuleName)
+		return &payload.ModuleSelfContainedContext{InternalContext: "new internal", PublicContext: "new public"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		resp := &payload.ModuleExternalContextResponse{}
+		for _, m := range req.Modules {
+			resp.Modules = append(resp.Modules, payload.ModuleExternalContext{Name: m.Name, ExternalContext: "new ext"})
+		}
+		return resp, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	var plans []*RegenerationPlan
+	decline := func(p *RegenerationPlan) (bool, error) { plans = append(plans, p); return false, nil }
+	accept := func(p *RegenerationPlan) (bool, error) { plans = append(plans, p); return true, nil }
+
+	_, err := UpdateWithOptions(context.Background(), root, UpdateOptions{Regenerate: Regenerate{All: true}, Confirm: decline})
+	if !errors.Is(err, ErrUpdateDeclined) {
+		t.Fatalf("expected ErrUpdateDeclined, got %v", err)
+	}
+	if len(annotated) != 0 {
+		t.Fatalf("expected no LLM call before the plan is confirmed, got %v", annotated)
+	}
+	if len(plans) != 1 || len(plans[0].Modules) == 0 || plans[0].Tokens == 0 {
+		t.Fatalf("expected a plan with modules and tokens, got %+v", plans)
+	}
+
+	report, err := UpdateWithOptions(context.Background(), root, UpdateOptions{Regenerate: Regenerate{All: true}, Confirm: accept})
+	if err != nil {
+		t.Fatalf("UpdateWithOptions: %v", err)
+	}
+	if len(report.AnnotationChanges) != len(plans[1].Modules) || len(annotated) != len(plans[1].Modules) {
+		t.Fatalf("expected every planned module to be regenerated, got %+v and %v", report, annotated)
+	}
+	meta, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	if a := meta.Modules.Annotation; a.InternalContext != "new internal" || a.Provider != "openai" {
+		t.Fatalf("expected a regenerated annotation with its provenance, got %+v", a)
+	}
+
+	// No annotation was written by gemini: nothing to confirm or regenerate.
+	annotated = nil
+	if _, err := UpdateWithOptions(context.Background(), root, UpdateOptions{Regenerate: Regenerate{FromProvider: "gemini"}, Confirm: decline}); err != nil {
+		t.Fatalf("UpdateWithOptions: %v", err)
+	}
+	if len(plans) != 2 || len(annotated) != 0 {
+		t.Fatalf("expected no regeneration, got %d plans and %v", len(plans), annotated)
+	}
+}
+
+func TestUpdateWithOptions_RegenerateModule(t *testing.T) {
+	old := minTokenCountPerModule
+	minTokenCountPerModule = 0
+	t.Cleanup(func() { minTokenCountPerModule = old })
+	root := writeAnnotatedProject(t, map[string]string{
+		"main.go":           "package main\n",
+		"pkg/p.go":          "package pkg\n",
+		"pkg/sub/s.go":      "package sub\n",
+		"pkg/sub/deep/d.go": "package deep\n",
+		"other/o.go":        "package other\n",
+	}, metadataVersion)
+
+	var annotated, external []string
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		annotated = append(annotated, req.TargetModuleName)
+		return &payload.ModuleSelfContainedContext{InternalContext: "new internal", PublicContext: "new public"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		resp := &payload.ModuleExternalContextResponse{}
+		for _, m := range req.Modules {
+			external = append(external, m.Name)
+			resp.Modules = append(resp.Modules, payload.ModuleExternalContext{Name: m.Name, ExternalContext: "new ext " + m.Name})
+		}
+		return resp, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	// pkg/nope is a directory of module pkg, not a module.
+	_, err := UpdateWithOptions(context.Background(), root, UpdateOptions{Regenerate: Regenerate{Module: "pkg/nope"}})
+	if want := `module "pkg/nope" not found, it is part of module "pkg"`; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+
+	var plan *RegenerationPlan
+	opts := UpdateOptions{
+		Regenerate: Regenerate{Module: "pkg/sub/deep/", Ancestors: true},
+		Confirm:    func(p *RegenerationPlan) (bool, error) { plan = p; return true, nil },
+	}
+	report, err := UpdateWithOptions(context.Background(), root, opts)
+	if err != nil {
+		t.Fatalf("UpdateWithOptions: %v", err)
+	}
+	if !reflect.DeepEqual(plan.Modules, []string{"pkg/sub/deep"}) || !reflect.DeepEqual(plan.ExternalContexts, []string{"pkg/sub", "pkg"}) {
+		t.Fatalf("expected the module and its ancestors to be planned, got %+v", plan)
+	}
+	if !reflect.DeepEqual(annotated, []string{"pkg/sub/deep"}) || len(report.AnnotationChanges) != 1 {
+		t.Fatalf("expected only the module to be re-annotated, got %v and %+v", annotated, report.AnnotationChanges)
+	}
+	if !slices.Contains(external, "pkg") || !slices.Contains(external, "pkg/sub/deep") {
+		t.Fatalf("expected the external contexts of the module and its ancestors to be requested, got %v", external)
+	}
+
+	meta, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	byName := make(map[string]*Annotation)
+	for _, m := range collectAllModules(meta.Modules) {
+		byName[m.Name] = m.Annotation
+	}
+	if a := byName["pkg/sub/deep"]; a.InternalContext != "new internal" || a.ExternalContext != "new ext pkg/sub/deep" {
+		t.Fatalf("expected the module to be regenerated, got %+v", a)
+	}
+	if a := byName["pkg"]; a.InternalContext != "old internal" || a.ExternalContext != "new ext pkg" {
+		t.Fatalf("expected only the external context of the ancestor to be regenerated, got %+v", a)
+	}
+	if a := byName["."]; a.InternalContext != "old internal" || a.PublicContext != "old public" {
+		t.Fatalf("expected the root module not to be re-annotated, got %+v", a)
+	}
+}
+
+func TestUpdate_ResumesFromJournal(t *testing.T) {
+	root := writeAnnotatedProject(t, moveFixture, metadataVersion)
+
+	var mu sync.Mutex
+	var annotated []string
+	failing := "pkg"
+	oldCtx, oldExt := getModuleContext, getModuleExternalContexts
+	getModuleContext = func(_ context.Context, _ *config.Config, _ string, req *payload.ModuleContextRequest) (*payload.ModuleSelfContainedContext, payload.UsageStats, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		annotated = append(annotated, req.TargetModuleName)
+		if req.TargetModuleName == failing {
+			return nil, payload.UsageStats{}, errors.New("provider unavailable")
+		}
+		return &payload.ModuleSelfContainedContext{InternalContext: "new internal", PublicContext: "new public"}, payload.UsageStats{}, nil
+	}
+	getModuleExternalContexts = func(_ context.Context, _ *config.Config, _ string, req *payload.ExternalContextsRequest) (*payload.ModuleExternalContextResponse, payload.UsageStats, error) {
+		return &payload.ModuleExternalContextResponse{}, payload.UsageStats{}, nil
+	}
+	t.Cleanup(func() { getModuleContext, getModuleExternalContexts = oldCtx, oldExt })
+
+	regenerateAll := UpdateOptions{Regenerate: Regenerate{All: true}}
+	update := func(want ...string) {
+		t.Helper()
+		annotated = nil
+		_, err := UpdateWithOptions(context.Background(), root, regenerateAll)
+		if (err != nil) != (failing != "") {
+			t.Fatalf("UpdateWithOptions failing %q: unexpected error %v", failing, err)
+		}
+		sort.Strings(annotated)
+		if !reflect.DeepEqual(annotated, want) {
+			t.Fatalf("failing %q: expected %v to be annotated, got %v", failing, want, annotated)
+		}
+	}
+
+	// The parent of a module that failed is not annotated.
+	update("pkg")
+	failing = "."
+	update(".", "pkg")
+	journal, err := openJournal(root)
+	if err != nil {
+		t.Fatalf("openJournal: %v", err)
+	}
+	if len(journal.entries) != 1 || journal.entries["pkg"].Annotation.InternalContext != "new internal" {
+		t.Fatalf("expected the completed annotation to be journaled, got %+v", journal.entries)
+	}
+
+	// The journaled annotation survives the failure and is reused.
+	failing = ""
+	update(".")
+	meta, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	for _, mod := range collectAllModules(meta.Modules) {
+		if mod.Annotation == nil || mod.Annotation.InternalContext != "new internal" {
+			t.Fatalf("expected module %s to be regenerated, got %+v", mod.Name, mod.Annotation)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(JournalFile))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the journal to be removed once persisted, got %v", err)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	meta := &Metadata{Modules: &Module{Name: ".", Annotation: &Annotation{Provider: "gemini", ModelSize: config.ModelSizeSmall}, Modules: []*Module{
+		{Name: "a", Annotation: &Annotation{Provider: "openai", ModelSize: config.ModelSizeSmall}},
+		{Name: "b", Annotation: &Annotation{}},
+		{Name: "c"},
+	}}}
+
+	if v := CheckPolicy(meta, config.Annotation{}); v != nil {
+		t.Fatalf("expected no violation without a policy, got %+v", v)
+	}
+	got := CheckPolicy(meta, config.Annotation{RequireProvider: "gemini"})
+	want := []PolicyViolation{{Module: "a", Provider: "openai", ModelSize: config.ModelSizeSmall}, {Module: "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CheckPolicy = %+v, want %+v", got, want)
+	}
+	if got := CheckPolicy(meta, config.Annotation{RequireModelSize: config.ModelSizeLarge}); len(got) != 3 {
+		t.Fatalf("expected every annotation to violate the model size policy, got %+v", got)
+	}
+}
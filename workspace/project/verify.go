@@ -0,0 +1,126 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StructuralDiff lists the differences between the module hierarchy and file
+// hashes recorded in .vyb/metadata.yaml and those of the project files.
+// Annotations and token counts are not compared.
+type StructuralDiff struct {
+	AddedModules   []string `json:"added_modules,omitempty"`
+	RemovedModules []string `json:"removed_modules,omitempty"`
+	AddedFiles     []string `json:"added_files,omitempty"`
+	RemovedFiles   []string `json:"removed_files,omitempty"`
+	// ChangedFiles lists the files whose content hash differs.
+	ChangedFiles []string `json:"changed_files,omitempty"`
+}
+
+// Empty reports whether the stored metadata matches the project files.
+func (d *StructuralDiff) Empty() bool {
+	return len(d.AddedModules) == 0 && len(d.RemovedModules) == 0 &&
+		len(d.AddedFiles) == 0 && len(d.RemovedFiles) == 0 && len(d.ChangedFiles) == 0
+}
+
+// String renders the diff with one line per difference: `+` marks entries
+// missing from the stored metadata, `-` entries it still records, and `~`
+// files whose content changed.
+func (d *StructuralDiff) String() string {
+	var sb strings.Builder
+	sb.WriteString("--- .vyb/metadata.yaml\n+++ project files\n")
+	for _, m := range d.AddedModules {
+		fmt.Fprintf(&sb, "+module %s\n", m)
+	}
+	for _, m := range d.RemovedModules {
+		fmt.Fprintf(&sb, "-module %s\n", m)
+	}
+	for _, f := range d.AddedFiles {
+		fmt.Fprintf(&sb, "+file   %s\n", f)
+	}
+	for _, f := range d.RemovedFiles {
+		fmt.Fprintf(&sb, "-file   %s\n", f)
+	}
+	for _, f := range d.ChangedFiles {
+		fmt.Fprintf(&sb, "~file   %s\n", f)
+	}
+	return sb.String()
+}
+
+// Verify builds a fresh structural snapshot of the project at projectRoot,
+// without calling the LLM, and compares it with the stored metadata.
+func Verify(projectRoot string) (*StructuralDiff, error) {
+	absRoot, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine absolute project root: %w", err)
+	}
+	rootFS := os.DirFS(absRoot)
+
+	stored, err := loadStoredMetadata(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := buildMetadata(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	return diffStructure(stored, fresh), nil
+}
+
+// diffStructure compares the module names and file hashes of stored and
+// fresh.
+func diffStructure(stored, fresh *Metadata) *StructuralDiff {
+	diff := &StructuralDiff{}
+
+	storedModules := make(map[string]struct{})
+	collectModuleNames(stored.Modules, storedModules)
+	freshModules := make(map[string]struct{})
+	collectModuleNames(fresh.Modules, freshModules)
+	for name := range freshModules {
+		if _, ok := storedModules[name]; !ok {
+			diff.AddedModules = append(diff.AddedModules, name)
+		}
+	}
+	for name := range storedModules {
+		if _, ok := freshModules[name]; !ok {
+			diff.RemovedModules = append(diff.RemovedModules, name)
+		}
+	}
+
+	storedFiles := collectFileHashes(stored.Modules)
+	freshFiles := collectFileHashes(fresh.Modules)
+	for name, hash := range freshFiles {
+		storedHash, ok := storedFiles[name]
+		switch {
+		case !ok:
+			diff.AddedFiles = append(diff.AddedFiles, name)
+		case storedHash != hash:
+			diff.ChangedFiles = append(diff.ChangedFiles, name)
+		}
+	}
+	for name := range storedFiles {
+		if _, ok := freshFiles[name]; !ok {
+			diff.RemovedFiles = append(diff.RemovedFiles, name)
+		}
+	}
+
+	for _, s := range [][]string{diff.AddedModules, diff.RemovedModules, diff.AddedFiles, diff.RemovedFiles, diff.ChangedFiles} {
+		sort.Strings(s)
+	}
+	return diff
+}
+
+// collectFileHashes maps the name of every file in the module tree rooted at
+// root to its MD5.
+func collectFileHashes(root *Module) map[string]string {
+	hashes := make(map[string]string)
+	for _, mod := range collectAllModules(root) {
+		for _, f := range mod.Files {
+			hashes[f.Name] = f.MD5
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,168 @@
+package selector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/vybdev/vyb/logging"
+	"github.com/vybdev/vyb/workspace/context"
+)
+
+// CacheDir is where Cache stores selection results, relative to the
+// project root.
+const CacheDir = ".vyb/cache/selection"
+
+// Cache reuses the results of Select across runs. A cached result is used as
+// long as none of the directories walked to produce it, nor the .gitignore
+// and .vybignore files read on the way, changed since: adding, removing or renaming a file
+// updates the modification time of its directory, so the file list cannot
+// have changed either. Checking these times is much cheaper than walking
+// and matching every file of a large repository.
+type Cache struct {
+	// Dir is the OS directory holding one JSON file per cache entry.
+	Dir string
+}
+
+// NewCache returns the Cache of the project rooted at projectRoot.
+func NewCache(projectRoot string) *Cache {
+	return &Cache{Dir: filepath.Join(projectRoot, filepath.FromSlash(CacheDir))}
+}
+
+// cacheKey identifies a selection. Select only depends on the target
+// directory and the patterns, the working directory is recorded so
+// invocations from different directories never share an entry.
+type cacheKey struct {
+	WorkingDir        string   `json:"working_dir"`
+	TargetDir         string   `json:"target_dir"`
+	ExclusionPatterns []string `json:"exclusion_patterns"`
+	InclusionPatterns []string `json:"inclusion_patterns"`
+}
+
+// cacheEntry is the content of a cache file.
+type cacheEntry struct {
+	Key   cacheKey `json:"key"`
+	Files []string `json:"files"`
+	// ModTimes maps every directory walked, and every ignore file read,
+	// to its modification time in nanoseconds.
+	ModTimes map[string]int64 `json:"mod_times"`
+}
+
+// Select returns the same files as the package-level Select, reading them
+// from the cache when the entry for these arguments is still valid. Cache
+// failures are never fatal: the selection is computed again instead.
+func (c *Cache) Select(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns, inclusionPatterns []string) ([]string, error) {
+	if ec == nil {
+		return nil, fs.ErrInvalid
+	}
+	key := cacheKey{
+		WorkingDir:        ec.Rel(ec.WorkingDir),
+		TargetDir:         ec.Rel(ec.TargetDir),
+		ExclusionPatterns: exclusionPatterns,
+		InclusionPatterns: inclusionPatterns,
+	}
+	file, err := c.entryPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, ok := c.load(file); ok && entry.valid(projectRoot) {
+		logging.Log.Debugf("selection cache hit: %s", file)
+		return entry.Files, nil
+	}
+
+	files, dirs, err := walk(projectRoot, ec, exclusionPatterns, inclusionPatterns)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{Key: key, Files: files, ModTimes: modTimes(projectRoot, dirs)}
+	if err := c.store(file, entry); err != nil {
+		logging.Log.Debugf("failed to write selection cache: %v", err)
+	}
+	return files, nil
+}
+
+func (c *Cache) entryPath(key cacheKey) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal selection cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:16])+".json"), nil
+}
+
+func (c *Cache) load(file string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// store writes entry to file through a temporary file, so a concurrent run
+// never reads a partial entry.
+func (c *Cache) store(file string, entry *cacheEntry) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// valid reports whether every recorded path still has the same
+// modification time.
+func (e *cacheEntry) valid(projectRoot fs.FS) bool {
+	if len(e.ModTimes) == 0 {
+		return false
+	}
+	for p, modTime := range e.ModTimes {
+		info, err := fs.Stat(projectRoot, p)
+		if err != nil || info.ModTime().UnixNano() != modTime {
+			return false
+		}
+	}
+	return true
+}
+
+// modTimes records the modification time of dirs and of the ignore files
+// they hold, if any.
+func modTimes(projectRoot fs.FS, dirs []string) map[string]int64 {
+	times := make(map[string]int64, len(dirs))
+	for _, dir := range dirs {
+		paths := []string{dir}
+		for _, name := range ignoreFiles {
+			paths = append(paths, path.Join(dir, name))
+		}
+		for _, p := range paths {
+			if info, err := fs.Stat(projectRoot, p); err == nil {
+				times[p] = info.ModTime().UnixNano()
+			}
+		}
+	}
+	return times
+}
@@ -0,0 +1,90 @@
+package selector
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vybdev/vyb/workspace/context"
+)
+
+// countingFS records the directories listed while walking.
+type countingFS struct {
+	fstest.MapFS
+	readDirs int
+}
+
+func (c *countingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.readDirs++
+	return c.MapFS.ReadDir(name)
+}
+
+func TestCache_Select(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"src":           {Mode: fs.ModeDir, ModTime: t0},
+		"src/a.go":      {Data: []byte("package src")},
+		"src/b.txt":     {Data: []byte("b")},
+		"docs/guide.md": {Data: []byte("# guide")},
+	}}
+	ec := &context.ExecutionContext{ProjectRoot: "/p", WorkingDir: "/p/src", TargetDir: "/p/src"}
+	cache := &Cache{Dir: t.TempDir()}
+	excl, incl := []string{".git/"}, []string{"*.go"}
+
+	first, err := cache.Select(fsys, ec, excl, incl)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if diff := cmp.Diff([]string{"src/a.go"}, first); diff != "" {
+		t.Fatalf("unexpected selection (-want +got):\n%s", diff)
+	}
+	walked := fsys.readDirs
+
+	second, err := cache.Select(fsys, ec, excl, incl)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if fsys.readDirs != walked {
+		t.Fatalf("expected a cache hit without walking, got %d extra directory reads", fsys.readDirs-walked)
+	}
+	if diff := cmp.Diff(first, second); diff != "" {
+		t.Fatalf("cached selection differs (-want +got):\n%s", diff)
+	}
+
+	// Different patterns never share an entry.
+	if txt, _ := cache.Select(fsys, ec, excl, []string{"*.txt"}); !cmp.Equal(txt, []string{"src/b.txt"}) {
+		t.Fatalf("expected a separate entry per pattern set, got %v", txt)
+	}
+
+	// Adding a file updates the directory mtime and invalidates the entry.
+	fsys.MapFS["src/c.go"] = &fstest.MapFile{Data: []byte("package src")}
+	fsys.MapFS["src"] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: t0.Add(time.Second)}
+	walked = fsys.readDirs
+	third, err := cache.Select(fsys, ec, excl, incl)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if fsys.readDirs == walked {
+		t.Fatalf("expected the changed directory to trigger a new walk")
+	}
+	if diff := cmp.Diff([]string{"src/a.go", "src/c.go"}, third); diff != "" {
+		t.Fatalf("unexpected selection after change (-want +got):\n%s", diff)
+	}
+
+	// Editing an ignore file read during the walk invalidates the entry too.
+	fsys.MapFS["src/.vybignore"] = &fstest.MapFile{Data: []byte("c.go\n"), ModTime: t0}
+	fsys.MapFS["src"] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: t0.Add(2 * time.Second)}
+	if _, err := cache.Select(fsys, ec, excl, incl); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	fsys.MapFS["src/.vybignore"] = &fstest.MapFile{Data: []byte("a.go\n"), ModTime: t0.Add(time.Second)}
+	fourth, err := cache.Select(fsys, ec, excl, incl)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if diff := cmp.Diff([]string{"src/c.go"}, fourth); diff != "" {
+		t.Fatalf("unexpected selection after editing .vybignore (-want +got):\n%s", diff)
+	}
+}
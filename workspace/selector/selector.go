@@ -5,11 +5,11 @@ import (
 	"io"
 	"io/fs"
 	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/vybdev/vyb/workspace/context"
 	"github.com/vybdev/vyb/workspace/matcher"
+	"github.com/vybdev/vyb/workspace/pathutil"
 )
 
 // Select walks the workspace starting from ec.TargetDir (relative to the
@@ -24,52 +24,31 @@ import (
 //
 // - If a directory is excluded if matcher.IsExcluded returns true;
 // - If a directory is excluded, none of its contents will be evaluated;
-// - For each directory that is not excluded, if a .gitignore file is present, it will be read, and its contents will be appended to the exclusionPatterns for this and all its sub-directories;
+// - For each directory that is not excluded, if a .gitignore or .vybignore file is present, it will be read, and its contents will be appended to the exclusionPatterns for this and all its sub-directories;
+// - DependencyExclusionPatterns are applied before exclusionPatterns, so ignore files can re-include dependency directories;
 // - All arguments (commandBaseDir, target, exclusionPatterns, and inclusionPatterns) are relative to the projectRoot;
-// - .gitignore patterns are relative to the directory where the .gitignore file was found;
+// - .gitignore and .vybignore patterns are relative to the directory where the file was found;
 func Select(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns, inclusionPatterns []string) ([]string, error) {
+	files, _, err := walk(projectRoot, ec, exclusionPatterns, inclusionPatterns)
+	return files, err
+}
+
+// walk implements Select. It also returns the directories it descended
+// into, whose entries determine the result.
+func walk(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns, inclusionPatterns []string) ([]string, []string, error) {
 	if ec == nil {
-		return nil, fs.ErrInvalid
+		return nil, nil, fs.ErrInvalid
 	}
 
 	// Compute the directory (relative to project root) that will seed the
 	// comparisons when deciding which files to include. This is guaranteed to
 	// be within the workspace as enforced by ExecutionContext.
-	relStart := "."
-	if rel, err := filepath.Rel(ec.ProjectRoot, ec.TargetDir); err == nil {
-		relStart = filepath.ToSlash(rel)
-	}
-
-	// ------------------------------------------------------------
-	// Helper predicates
-	// ------------------------------------------------------------
-
-	// isDescendant returns true when p == target or p is nested somewhere under
-	// target.
-	isDescendant := func(p, target string) bool {
-		if target == "." {
-			return true
-		}
-		p = path.Clean(p)
-		target = path.Clean(target)
-		return p == target || strings.HasPrefix(p+"/", target+"/")
-	}
-
-	// isAncestor returns true when p == target or p is an ancestor directory of
-	// target.
-	isAncestor := func(p, target string) bool {
-		if p == "." {
-			return true
-		}
-		p = path.Clean(p)
-		target = path.Clean(target)
-		return p == target || strings.HasPrefix(target+"/", p+"/")
-	}
+	relStart := ec.Rel(ec.TargetDir)
 
 	// A directory is relevant if it is the target itself, one of its ancestors
 	// or one of its descendants.
 	isRelevantDir := func(dir string) bool {
-		return isAncestor(dir, relStart) || isDescendant(dir, relStart)
+		return pathutil.IsPathUnderDir(dir, relStart) || pathutil.IsPathUnderDir(relStart, dir)
 	}
 
 	// ------------------------------------------------------------
@@ -78,8 +57,10 @@ func Select(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns,
 
 	// effectiveExclusions keeps the accumulated exclusion patterns per dir.
 	effectiveExclusions := map[string][]string{}
+	exclusionPatterns = append(append([]string{}, DependencyExclusionPatterns...), exclusionPatterns...)
 
 	var results []string
+	var dirs []string
 
 	err := fs.WalkDir(projectRoot, ".", func(currPath string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -95,7 +76,7 @@ func Select(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns,
 			}
 		} else {
 			// Skip files that are not inside the target subtree.
-			if !isDescendant(currPath, relStart) {
+			if !pathutil.IsPathUnderDir(relStart, currPath) {
 				return nil
 			}
 		}
@@ -113,6 +94,7 @@ func Select(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns,
 			}
 			// Build this dir's exclusion list inheriting parent + .gitignore.
 			effectiveExclusions[currPath] = computeEffectiveExclusions(projectRoot, currPath, parentExcl)
+			dirs = append(dirs, currPath)
 			return nil
 		}
 
@@ -125,21 +107,83 @@ func Select(projectRoot fs.FS, ec *context.ExecutionContext, exclusionPatterns,
 		return nil
 	})
 
-	return results, err
+	return results, dirs, err
 }
 
+// SystemExclusionPatterns lists the paths vyb never selects: version control
+// and vyb's own files. Anything else is excluded with .gitignore or
+// .vybignore files.
+var SystemExclusionPatterns = []string{
+	".git/",
+	".gitignore",
+	".vybignore",
+	".vyb/",
+}
+
+// DependencyDirs lists the well-known directories holding vendored or
+// installed dependencies. They are large and rarely worth summarizing or
+// modifying, so their files are not selected unless re-included.
+var DependencyDirs = []string{
+	"vendor",
+	"node_modules",
+	"bower_components",
+	".venv",
+	"venv",
+	"__pycache__",
+}
+
+// DependencyExclusionPatterns excludes the files of DependencyDirs at any
+// level. They match files rather than directories, so a negation in an
+// ignore file (e.g. "!vendor/" in the root .vybignore) re-includes them.
+var DependencyExclusionPatterns = dependencyPatterns(DependencyDirs)
+
+func dependencyPatterns(dirs []string) []string {
+	patterns := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		patterns = append(patterns, "**/"+d+"/**")
+	}
+	return patterns
+}
+
+// ignoreFiles lists the files whose patterns exclude paths of the directory
+// holding them and of its sub-directories. A .vybignore hides files from vyb
+// only, with the .gitignore syntax; the one at the project root applies to
+// every command.
+var ignoreFiles = []string{".gitignore", ".vybignore"}
+
 // computeEffectiveExclusions extracts the effective exclusion patterns for a
 // directory. It starts with the provided baseExclusions and appends patterns
-// from a .gitignore file, if present.
+// from the .gitignore and .vybignore files, if present, so the latter can
+// override the former.
 func computeEffectiveExclusions(projectRoot fs.FS, dir string, baseExclusions []string) []string {
 	exclusions := append([]string{}, baseExclusions...)
-	gitignorePath := path.Join(dir, ".gitignore")
-	if data, err := fs.ReadFile(projectRoot, gitignorePath); err == nil {
-		exclusions = append(exclusions, parseGitignore(string(data))...)
+	for _, name := range ignoreFiles {
+		if data, err := fs.ReadFile(projectRoot, path.Join(dir, name)); err == nil {
+			for _, pattern := range parseGitignore(string(data)) {
+				exclusions = append(exclusions, anchorPattern(dir, pattern))
+			}
+		}
 	}
 	return exclusions
 }
 
+// anchorPattern rewrites a pattern read from an ignore file of dir so it is
+// relative to the project root. Patterns holding a separator are relative to
+// dir; the others match at any level below it and are returned unchanged.
+func anchorPattern(dir, pattern string) string {
+	if dir == "." {
+		return pattern
+	}
+	negation := ""
+	if strings.HasPrefix(pattern, "!") {
+		negation, pattern = "!", pattern[1:]
+	}
+	if !strings.Contains(pattern, "/") {
+		return negation + pattern
+	}
+	return negation + dir + "/" + strings.TrimPrefix(pattern, "/")
+}
+
 // parseGitignore parses the content of a .gitignore file and returns a slice
 // of patterns.
 func parseGitignore(data string) []string {
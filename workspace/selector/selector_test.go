@@ -75,6 +75,27 @@ func TestSelect(t *testing.T) {
 				"base/dir2/subdir1/file7.md",
 			},
 		},
+		{
+			baseDir:    "base",
+			exclusions: []string{".gitignore"},
+			inclusions: []string{"base/dir2/"},
+			want: []string{
+				"base/dir2/file5.txt",
+				"base/dir2/file6.md",
+				"base/dir2/subdir1/file7.md",
+			},
+			explanation: "A directory inclusion pattern includes every file under the directory.",
+		},
+		{
+			baseDir:    "base",
+			exclusions: []string{".gitignore"},
+			inclusions: []string{"base/dir1/subdir1/", "base/dir2/subdir1/"},
+			want: []string{
+				"base/dir1/subdir1/file2.txt",
+				"base/dir2/subdir1/file7.md",
+			},
+			explanation: "Nested directory inclusion patterns only include their own directories.",
+		},
 	}
 
 	for i, tc := range tests {
@@ -133,6 +154,150 @@ func TestSelect_TargetDirIsolation(t *testing.T) {
 	}
 }
 
+// TestSelect_Vybignore ensures .vybignore patterns are honoured like the
+// .gitignore ones: the root file applies to every target, nested files to
+// their own sub-tree only.
+func TestSelect_Vybignore(t *testing.T) {
+	fsys := fstest.MapFS{
+		".vybignore":          {Data: []byte("# docs are not context\n*.md\n")},
+		"README.md":           {Data: []byte("# readme")},
+		"main.go":             {Data: []byte("package main")},
+		"pkg/doc.md":          {Data: []byte("# doc")},
+		"pkg/lib.go":          {Data: []byte("package pkg")},
+		"pkg/gen/.vybignore":  {Data: []byte("*.pb.go\n")},
+		"pkg/gen/api.pb.go":   {Data: []byte("package gen")},
+		"pkg/gen/api.go":      {Data: []byte("package gen")},
+		"other/api.pb.go":     {Data: []byte("package other")},
+		"other/sub/notes.md":  {Data: []byte("# notes")},
+		"other/sub/helper.go": {Data: []byte("package sub")},
+	}
+
+	tests := []struct {
+		targetDir string
+		want      []string
+	}{
+		{
+			targetDir: ".",
+			want: []string{
+				"main.go",
+				"other/api.pb.go",
+				"other/sub/helper.go",
+				"pkg/gen/api.go",
+				"pkg/lib.go",
+			},
+		},
+		{
+			targetDir: "other/sub",
+			want:      []string{"other/sub/helper.go"},
+		},
+		{
+			targetDir: "pkg/gen",
+			want:      []string{"pkg/gen/api.go"},
+		},
+	}
+	for _, tc := range tests {
+		ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: tc.targetDir}
+		got, err := Select(fsys, ec, []string{".vybignore"}, []string{"*"})
+		if err != nil {
+			t.Fatalf("Select(%s) returned error: %v", tc.targetDir, err)
+		}
+		if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Fatalf("Select(%s) mismatch (-want +got):\n%s", tc.targetDir, diff)
+		}
+	}
+}
+
+// TestSelect_NestedVybignore ensures nested .vybignore files are evaluated
+// after .gitignore ones, may re-include files excluded above them and anchor
+// patterns holding a separator to their own directory.
+func TestSelect_NestedVybignore(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitignore":              {Data: []byte("*.gen.go\n")},
+		".vybignore":              {Data: []byte("*.md\n")},
+		"main.go":                 {Data: []byte("package main")},
+		"svc/.vybignore":          {Data: []byte("!README.md\n!keep.gen.go\n/fixtures/\n")},
+		"svc/README.md":           {Data: []byte("# svc")},
+		"svc/CHANGES.md":          {Data: []byte("# changes")},
+		"svc/keep.gen.go":         {Data: []byte("package svc")},
+		"svc/drop.gen.go":         {Data: []byte("package svc")},
+		"svc/fixtures/a.json":     {Data: []byte("{}")},
+		"svc/api/fixtures/b.json": {Data: []byte("{}")},
+		"fixtures/c.json":         {Data: []byte("{}")},
+		"svc/api/sub/.vybignore":  {Data: []byte("!*.md\n")},
+		"svc/api/sub/doc.md":      {Data: []byte("# doc")},
+		"svc/api/sub/handler.go":  {Data: []byte("package sub")},
+	}
+
+	tests := []struct {
+		targetDir string
+		want      []string
+	}{
+		{
+			targetDir: ".",
+			want: []string{
+				"fixtures/c.json",
+				"main.go",
+				"svc/README.md",
+				"svc/api/fixtures/b.json",
+				"svc/api/sub/doc.md",
+				"svc/api/sub/handler.go",
+				"svc/keep.gen.go",
+			},
+		},
+		{
+			targetDir: "svc/api",
+			want: []string{
+				"svc/api/fixtures/b.json",
+				"svc/api/sub/doc.md",
+				"svc/api/sub/handler.go",
+			},
+		},
+	}
+	for _, tc := range tests {
+		ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: tc.targetDir}
+		got, err := Select(fsys, ec, SystemExclusionPatterns, []string{"*"})
+		if err != nil {
+			t.Fatalf("Select(%s) returned error: %v", tc.targetDir, err)
+		}
+		if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Fatalf("Select(%s) mismatch (-want +got):\n%s", tc.targetDir, diff)
+		}
+	}
+}
+
 func target(t string) *string {
 	return &t
 }
+
+func TestSelect_DependencyDirs(t *testing.T) {
+	files := fstest.MapFS{
+		"main.go":                     {Data: []byte("package main")},
+		"vendor/github.com/x/x.go":    {Data: []byte("package x")},
+		"node_modules/left-pad/i.js":  {Data: []byte("module.exports = 1")},
+		"web/node_modules/react/i.js": {Data: []byte("module.exports = 2")},
+		"web/app.js":                  {Data: []byte("app()")},
+		"tools/.venv/lib/site.py":     {Data: []byte("pass")},
+	}
+	ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "."}
+
+	got, err := Select(files, ec, SystemExclusionPatterns, []string{"*"})
+	if err != nil {
+		t.Fatalf("Select returned error: %v", err)
+	}
+	want := []string{"main.go", "web/app.js"}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Fatalf("dependency directories must be excluded by default (-want +got):\n%s", diff)
+	}
+
+	// A negation in an ignore file re-includes a dependency directory.
+	files[".vybignore"] = &fstest.MapFile{Data: []byte("!vendor/\n")}
+	files["web/.vybignore"] = &fstest.MapFile{Data: []byte("!node_modules/\n")}
+	got, err = Select(files, ec, SystemExclusionPatterns, []string{"*"})
+	if err != nil {
+		t.Fatalf("Select returned error: %v", err)
+	}
+	want = []string{"main.go", "vendor/github.com/x/x.go", "web/app.js", "web/node_modules/react/i.js"}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Fatalf("negations must re-include dependency directories (-want +got):\n%s", diff)
+	}
+}